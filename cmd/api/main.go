@@ -2,24 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/controllers"
+	"github.com/cursor/FMgo/models"
 	"github.com/cursor/FMgo/repository"
 	"github.com/cursor/FMgo/services"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/estado"
+	"github.com/cursor/FMgo/services/folios"
+	"github.com/cursor/FMgo/services/seguimiento"
+	"github.com/cursor/FMgo/services/token"
 	"github.com/cursor/FMgo/sii"
+	"github.com/cursor/FMgo/utils/xmldsig"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// maximoEmpresas limita las empresas que se leen al iniciar para registrar su ambiente SII
+const maximoEmpresas = 10000
+
 func main() {
 	// Configurar MongoDB
 	ctx := context.Background()
@@ -55,6 +67,42 @@ func main() {
 		os.Getenv("SII_AMBIENTE"),
 	)
 
+	// Configurar ambientes SII y consultas de estado
+	registro, err := nuevoRegistro(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientesEstado, err := nuevosClientesEstado(registro, redisClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	consultorEstado := estado.NewClienteAmbientes(registro, clientesEstado)
+
+	// Iniciar seguimiento de TrackID en segundo plano
+	tareasCtx, detenerTareas := context.WithCancel(context.Background())
+	var tareas sync.WaitGroup
+	poller := seguimiento.NewPoller(seguimiento.NewRepositorioMongo(db), consultorEstado, nil, seguimiento.ConfigPorDefecto())
+	for ambienteSII, cliente := range clientesEstado {
+		poller.SetConsultorAmbiente(ambienteSII, cliente)
+	}
+	tareas.Add(1)
+	go func() {
+		defer tareas.Done()
+		poller.Iniciar(tareasCtx)
+	}()
+
+	// Iniciar la asignación de folios por bloques arrendados
+	repoFolios := folios.NewRepositorioMongo(db)
+	if err := repoFolios.CrearIndices(ctx); err != nil {
+		log.Fatal(err)
+	}
+	asignador := folios.NewAsignador(repoFolios, registro, folios.ConfigAsignadorPorDefecto())
+	tareas.Add(1)
+	go func() {
+		defer tareas.Done()
+		asignador.Iniciar(tareasCtx)
+	}()
+
 	// Inicializar repositorios
 	docRepo := repository.NewDocumentRepository(db)
 
@@ -70,9 +118,12 @@ func main() {
 	)
 	auditSvc := services.NewAuditService(db)
 	docService := services.NewDocumentService(docRepo, validationSvc, cafSvc, auditSvc)
+	folioSvc := services.NewFolioService(db, cafSvc.(*services.CAFService), redisClient, 100)
+	folioSvc.SetAsignador(asignador)
 
 	// Inicializar controladores
 	docController := controllers.NewDocumentController(docService)
+	folioController := controllers.NewFolioController(folioSvc)
 
 	// Configurar router
 	router := gin.Default()
@@ -85,6 +136,9 @@ func main() {
 	router.POST("/api/documentos/referencias", docController.AgregarReferencia)
 	router.GET("/api/documentos/:tipo/:folio/referencias", docController.ObtenerReferencias)
 
+	// Rutas de folios
+	folioController.RegisterRoutes(router.Group("/api"))
+
 	// Configurar servidor
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -111,9 +165,90 @@ func main() {
 		log.Fatal("Error al apagar el servidor:", err)
 	}
 
+	// Detener las tareas en segundo plano y devolver los bloques de folios arrendados
+	detenerTareas()
+	tareas.Wait()
+	if err := asignador.Cerrar(ctx); err != nil {
+		log.Println("Error al devolver los bloques de folios:", err)
+	}
+
 	log.Println("Servidor apagado correctamente")
 }
 
+// nuevoRegistro crea el registro de ambientes SII con el ambiente de cada empresa guardada en
+// Supabase (FMGO_CONFIG) y, si se indica, la empresa SII_RUT_EMPRESA en el ambiente
+// SII_AMBIENTE
+func nuevoRegistro(ctx context.Context) (*ambiente.Registro, error) {
+	ambienteSII, err := models.ParseAmbienteSII(getEnv("SII_AMBIENTE", string(models.AmbienteCertificacion)))
+	if err != nil {
+		return nil, err
+	}
+	registro := ambiente.NewRegistro()
+	if err := cargarEmpresas(ctx, registro); err != nil {
+		return nil, err
+	}
+	if rutEmpresa := os.Getenv("SII_RUT_EMPRESA"); rutEmpresa != "" {
+		if err := registro.AsignarAmbiente(rutEmpresa, ambienteSII); err != nil {
+			return nil, err
+		}
+	}
+	return registro, nil
+}
+
+// cargarEmpresas registra el ambiente de todas las empresas guardadas en Supabase
+func cargarEmpresas(ctx context.Context, registro *ambiente.Registro) error {
+	repo, err := repository.InitializeRepository(getEnv("FMGO_CONFIG", "config.json"))
+	if err != nil {
+		return err
+	}
+	guardadas, err := repo.ListEmpresas(ctx, maximoEmpresas)
+	if err != nil {
+		return err
+	}
+	empresas := make([]*models.Empresa, 0, len(guardadas))
+	for _, e := range guardadas {
+		empresas = append(empresas, &models.Empresa{ID: e.ID, Nombre: e.Nombre, RUT: e.RUT, Ambiente: e.Ambiente})
+	}
+	if err := registro.Cargar(empresas); err != nil {
+		return fmt.Errorf("error al cargar ambientes de empresas: %v", err)
+	}
+	log.Printf("Ambientes SII cargados para %d empresas", len(empresas))
+	return nil
+}
+
+// nuevosClientesEstado crea un cliente de consulta de estado por ambiente, con los tokens de
+// ese ambiente obtenidos con el certificado SII_CERT_FILE y compartidos en Redis
+func nuevosClientesEstado(registro *ambiente.Registro, redisClient *redis.Client) (map[models.AmbienteSII]*estado.Cliente, error) {
+	certPEM, err := os.ReadFile(os.Getenv("SII_CERT_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("error al leer certificado: %v", err)
+	}
+	keyPEM, err := os.ReadFile(os.Getenv("SII_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("error al leer llave privada: %v", err)
+	}
+	firmante, err := xmldsig.CargarFirmante(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	credenciales := token.CredencialesFunc(func(ctx context.Context, rutEmpresa string) (token.Firmador, error) {
+		return firmante, nil
+	})
+
+	cache := token.NewRedisCache(redisClient)
+	clientes := make(map[models.AmbienteSII]*estado.Cliente)
+	for _, ambienteSII := range []models.AmbienteSII{models.AmbienteCertificacion, models.AmbienteProduccion} {
+		endpoints, err := registro.Endpoints(ambienteSII)
+		if err != nil {
+			return nil, err
+		}
+		autenticador := token.NewAutenticador(endpoints.Semilla, endpoints.Token, 30*time.Second)
+		tokens := token.NewManager(cache, autenticador, credenciales, string(ambienteSII))
+		clientes[ambienteSII] = estado.NewCliente(estado.NewEndpoints(endpoints), tokens, 30*time.Second)
+	}
+	return clientes, nil
+}
+
 // getEnv obtiene una variable de entorno o devuelve un valor por defecto
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -0,0 +1,123 @@
+// Comando certificacion ejecuta el set de pruebas del SII: genera los documentos de cada caso
+// con los CAF de certificación, arma y firma los envíos y libros, los deja en el directorio de
+// salida y, con -enviar, los sube al ambiente de certificación (maullin).
+//
+// Uso:
+//
+//	certificacion -set set_pruebas.txt -config empresa.json -cert cert.pem -key key.pem [-caf caf_test] [-salida salida] [-enviar]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/certificacion"
+	"github.com/cursor/FMgo/services/sii"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+func main() {
+	rutaSet := flag.String("set", "", "archivo de texto del set de pruebas entregado por el SII")
+	rutaConfig := flag.String("config", "", "archivo JSON con los datos de emisor, receptor y resolución")
+	dirCAF := flag.String("caf", "caf_test", "directorio con los CAF de certificación")
+	rutaCert := flag.String("cert", "", "certificado del usuario que envía (PEM)")
+	rutaKey := flag.String("key", "", "llave privada del certificado (PEM)")
+	salida := flag.String("salida", "salida_certificacion", "directorio donde se escriben los envíos y el reporte")
+	enviar := flag.Bool("enviar", false, "enviar los archivos al ambiente de certificación del SII")
+	flag.Parse()
+
+	if *rutaSet == "" || *rutaConfig == "" || *rutaCert == "" || *rutaKey == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	params, err := cargarParametros(*rutaConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archivo, err := os.Open(*rutaSet)
+	if err != nil {
+		log.Fatalf("error al abrir set de pruebas: %v", err)
+	}
+	set, err := certificacion.ParsearSet(archivo)
+	archivo.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manager := caf.NewManager("")
+	if err := manager.LoadCAFs(*dirCAF); err != nil {
+		log.Fatal(err)
+	}
+
+	certPEM, err := os.ReadFile(*rutaCert)
+	if err != nil {
+		log.Fatalf("error al leer certificado: %v", err)
+	}
+	keyPEM, err := os.ReadFile(*rutaKey)
+	if err != nil {
+		log.Fatalf("error al leer llave privada: %v", err)
+	}
+	firmante, err := xmldsig.CargarFirmante(certPEM, keyPEM)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var (
+		uploader    certificacion.Uploader
+		tokenSesion string
+	)
+	if *enviar {
+		// El set de pruebas se envía siempre al ambiente de certificación
+		registro := ambiente.NewRegistro()
+		if err := registro.AsignarAmbiente(params.Emisor.RUT, models.AmbienteCertificacion); err != nil {
+			log.Fatal(err)
+		}
+		endpoints, err := registro.EndpointsEmpresa(params.Emisor.RUT)
+		if err != nil {
+			log.Fatal(err)
+		}
+		autenticador := token.NewAutenticador(endpoints.Semilla, endpoints.Token, 30*time.Second)
+		if tokenSesion, err = autenticador.SolicitarToken(ctx, firmante); err != nil {
+			log.Fatalf("error al obtener token del SII: %v", err)
+		}
+		uploader = sii.NewUploader(registro, 60*time.Second)
+	}
+
+	runner := certificacion.NewRunner(params, ted.NewGenerador(manager), firmante, uploader, tokenSesion)
+	reporte, err := runner.Ejecutar(ctx, set, certificacion.NewFoliosCAF(manager, nil), *salida)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(reporte.Texto())
+}
+
+// cargarParametros lee los datos de la empresa que se certifica desde un archivo JSON
+func cargarParametros(ruta string) (certificacion.Parametros, error) {
+	var params certificacion.Parametros
+	data, err := os.ReadFile(ruta)
+	if err != nil {
+		return params, fmt.Errorf("error al leer configuración: %v", err)
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return params, fmt.Errorf("error al decodificar configuración: %v", err)
+	}
+	// Los documentos del set se emiten con la fecha del día si la configuración no indica otra
+	if params.FechaEmision.IsZero() {
+		params.FechaEmision = time.Now()
+	}
+	return params, nil
+}
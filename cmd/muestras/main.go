@@ -0,0 +1,63 @@
+// Comando muestras genera las muestras impresas de la certificación del SII: lee uno o más
+// EnvioDTE firmados, dibuja un PDF por DTE (copia tributaria y, en facturas y guías, copia
+// cedible con acuse de recibo) y los empaqueta en un archivo ZIP listo para subir.
+//
+// Uso:
+//
+//	muestras [-unidad "Santiago Oriente"] [-salida muestras_impresas.zip] EnvioDTE.xml...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cursor/FMgo/services/muestras"
+)
+
+func main() {
+	unidad := flag.String("unidad", "", "unidad del SII correspondiente al domicilio del emisor")
+	salida := flag.String("salida", "muestras_impresas.zip", "archivo ZIP donde se escriben las muestras")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	generador := muestras.NewGenerador(*unidad)
+	var todas []muestras.Muestra
+	for _, ruta := range flag.Args() {
+		envio, err := os.ReadFile(ruta)
+		if err != nil {
+			log.Fatalf("error al leer envío: %v", err)
+		}
+		generadas, err := generador.Generar(envio)
+		if err != nil {
+			log.Fatalf("%s: %v", ruta, err)
+		}
+		todas = append(todas, generadas...)
+	}
+
+	archivo, err := os.Create(*salida)
+	if err != nil {
+		log.Fatalf("error al crear archivo de muestras: %v", err)
+	}
+	if err := muestras.Empaquetar(archivo, todas); err != nil {
+		archivo.Close()
+		log.Fatal(err)
+	}
+	if err := archivo.Close(); err != nil {
+		log.Fatalf("error al cerrar archivo de muestras: %v", err)
+	}
+
+	for _, muestra := range todas {
+		copias := "tributaria"
+		if muestra.Leyenda != "" {
+			copias += " + " + muestra.Leyenda
+		}
+		fmt.Printf("%s\ttipo %d folio %d\t%s\n", muestra.Archivo, muestra.TipoDTE, muestra.Folio, copias)
+	}
+	fmt.Printf("%d muestras en %s\n", len(todas), *salida)
+}
@@ -0,0 +1,40 @@
+// Comando simulador levanta el simulador de servicios del SII para desarrollo local: semilla y
+// token, DTEUpload, QueryEstUp, QueryEstDte y los servicios REST de boleta. Acepta todos los
+// envíos; las consultas de estado informan el envío en proceso durante las primeras consultas.
+//
+// Uso:
+//
+//	simulador [-puerto 8080] [-en-proceso 1]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/cursor/FMgo/mock/simulador"
+)
+
+func main() {
+	puerto := flag.Int("puerto", 8080, "puerto HTTP donde se atienden los servicios")
+	enProceso := flag.Int("en-proceso", 1, "consultas en que un envío sigue en proceso antes de su estado final")
+	flag.Parse()
+
+	sim := simulador.New()
+	sim.ConsultasEnProceso(*enProceso)
+
+	direccion := fmt.Sprintf(":%d", *puerto)
+	urls := simulador.NewURLs(fmt.Sprintf("http://localhost:%d", *puerto))
+	fmt.Printf("Simulador SII en %s\n", direccion)
+	fmt.Printf("  Semilla:       %s\n", urls.Semilla)
+	fmt.Printf("  Token:         %s\n", urls.Token)
+	fmt.Printf("  DTEUpload:     %s\n", urls.UploadDTE)
+	fmt.Printf("  QueryEstUp:    %s\n", urls.EstadoUpload)
+	fmt.Printf("  QueryEstDte:   %s\n", urls.EstadoDTE)
+	fmt.Printf("  Boleta (REST): %s\n", urls.Boleta)
+
+	if err := http.ListenAndServe(direccion, sim); err != nil {
+		log.Fatalf("error al iniciar simulador: %v", err)
+	}
+}
@@ -207,7 +207,7 @@ func GetSiiEndpoint(c *Config) string {
 	}
 
 	// Elegir endpoint según ambiente
-	if c.Env == "production" {
+	if ambiente, err := models.ParseAmbienteSII(c.Env); err == nil && ambiente == models.AmbienteProduccion {
 		return urlProduccion
 	}
 
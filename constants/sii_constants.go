@@ -28,13 +28,6 @@ const (
 	ErrorRangoFolio        = "00205"
 )
 
-// URLs de servicios SII (Certificación)
-const (
-	URLSemilla      = "https://maullin.sii.cl/DTEWS/CrSeed.jws?WSDL"
-	URLToken        = "https://maullin.sii.cl/DTEWS/GetTokenFromSeed.jws?WSDL"
-	URLRecepcionDTE = "https://maullin.sii.cl/DTEWS/RecepcionDTE.jws?WSDL"
-)
-
 // Mensajes de error
 var ErrorMessages = map[string]string{
 	ErrorSchemaInvalido:    "El esquema XML del DTE es inválido",
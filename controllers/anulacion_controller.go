@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/folios"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// AnulacionController maneja las solicitudes de anulación de folios ante el SII
+type AnulacionController struct {
+	anulador *folios.Anulador
+	empresas folios.Empresas
+}
+
+// NewAnulacionController crea una nueva instancia del controlador de anulaciones
+func NewAnulacionController(anulador *folios.Anulador, empresas folios.Empresas) *AnulacionController {
+	return &AnulacionController{
+		anulador: anulador,
+		empresas: empresas,
+	}
+}
+
+// PresentarAnulacionRequest registra la presentación de una solicitud en el SII
+type PresentarAnulacionRequest struct {
+	NumeroAtencion string `json:"numero_atencion" binding:"required"`
+}
+
+// ResolverAnulacionRequest registra la respuesta del SII a una solicitud
+type ResolverAnulacionRequest struct {
+	Aprobada *bool  `json:"aprobada" binding:"required"`
+	Glosa    string `json:"glosa"`
+}
+
+// ListarPorAnular retorna, agrupados por CAF, los folios de la empresa que deben anularse
+func (c *AnulacionController) ListarPorAnular(ctx *gin.Context) {
+	empresa, ok := c.empresa(ctx)
+	if !ok {
+		return
+	}
+	rangos, err := c.anulador.Recolectar(ctx.Request.Context(), empresa.RUT, empresa.Ambiente)
+	if err != nil {
+		c.responderError(ctx, "ListarPorAnular", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rangos": rangos})
+}
+
+// GenerarAnulacion crea la solicitud de anulación con los folios por anular de la empresa
+func (c *AnulacionController) GenerarAnulacion(ctx *gin.Context) {
+	empresa, ok := c.empresa(ctx)
+	if !ok {
+		return
+	}
+	solicitud, err := c.anulador.Generar(ctx.Request.Context(), empresa.RUT, empresa.Ambiente)
+	if err != nil {
+		c.responderError(ctx, "GenerarAnulacion", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, solicitud)
+}
+
+// ObtenerAnulacion retorna una solicitud de anulación
+func (c *AnulacionController) ObtenerAnulacion(ctx *gin.Context) {
+	solicitud, err := c.anulador.Obtener(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, solicitud)
+}
+
+// ExportarAnulacion descarga la solicitud en formato CSV (por defecto) o JSON para
+// presentarla en el SII
+func (c *AnulacionController) ExportarAnulacion(ctx *gin.Context) {
+	solicitud, err := c.anulador.Obtener(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	formato := strings.ToUpper(ctx.DefaultQuery("formato", "CSV"))
+	data, err := folios.ExportarSolicitud(solicitud, formato)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "text/csv"
+	if formato == "JSON" {
+		contentType = "application/json"
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=anulacion_"+solicitud.ID+"."+strings.ToLower(formato))
+	ctx.Data(http.StatusOK, contentType, data)
+}
+
+// PresentarAnulacion registra el número de atención con que se presentó la solicitud al SII
+func (c *AnulacionController) PresentarAnulacion(ctx *gin.Context) {
+	var req PresentarAnulacionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	solicitud, err := c.anulador.Presentar(ctx.Request.Context(), ctx.Param("id"), req.NumeroAtencion)
+	if err != nil {
+		c.responderError(ctx, "PresentarAnulacion", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, solicitud)
+}
+
+// ResolverAnulacion registra la aprobación o el rechazo del SII
+func (c *AnulacionController) ResolverAnulacion(ctx *gin.Context) {
+	var req ResolverAnulacionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	solicitud, err := c.anulador.Resolver(ctx.Request.Context(), ctx.Param("id"), *req.Aprobada, req.Glosa)
+	if err != nil {
+		c.responderError(ctx, "ResolverAnulacion", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, solicitud)
+}
+
+// RegisterRoutes registra las rutas del controlador
+func (c *AnulacionController) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/empresas/:empresa_id/anulaciones/folios", c.ListarPorAnular)
+	router.POST("/empresas/:empresa_id/anulaciones", c.GenerarAnulacion)
+	router.GET("/anulaciones/:id", c.ObtenerAnulacion)
+	router.GET("/anulaciones/:id/exportar", c.ExportarAnulacion)
+	router.POST("/anulaciones/:id/presentar", c.PresentarAnulacion)
+	router.POST("/anulaciones/:id/resolver", c.ResolverAnulacion)
+}
+
+// empresa obtiene la empresa de la ruta; si no existe responde el error
+func (c *AnulacionController) empresa(ctx *gin.Context) (*models.Empresa, bool) {
+	empresaID := ctx.Param("empresa_id")
+	if empresaID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de empresa es requerido"})
+		return nil, false
+	}
+	empresa, err := c.empresas.ObtenerEmpresa(empresaID)
+	if err != nil || empresa == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "empresa no encontrada"})
+		return nil, false
+	}
+	return empresa, true
+}
+
+func (c *AnulacionController) responderError(ctx *gin.Context, endpoint string, err error) {
+	utils.LogError(err, zap.String("endpoint", endpoint))
+	switch {
+	case errors.Is(err, folios.ErrSinFoliosPorAnular):
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, folios.ErrSolicitudSuperpuesta), errors.Is(err, folios.ErrEstadoSolicitud):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
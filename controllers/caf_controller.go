@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/folios"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// tamanoMaximoCAF limita el archivo CAF aceptado; un CAF del SII pesa unos pocos KB
+const tamanoMaximoCAF = 64 << 10
+
+// CAFController maneja la importación de archivos CAF
+type CAFController struct {
+	importador *folios.Importador
+}
+
+// NewCAFController crea una nueva instancia del controlador de CAF
+func NewCAFController(importador *folios.Importador) *CAFController {
+	return &CAFController{
+		importador: importador,
+	}
+}
+
+// ImportarCAF recibe el archivo CAF de una empresa, como campo "archivo" de un formulario
+// multipart o como cuerpo XML, y registra su rango de folios. El campo opcional "tipo_dte"
+// exige que el CAF sea de ese tipo.
+func (c *CAFController) ImportarCAF(ctx *gin.Context) {
+	empresaID := ctx.Param("empresa_id")
+	if empresaID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de empresa es requerido"})
+		return
+	}
+
+	solicitud := folios.SolicitudImportacion{EmpresaID: empresaID}
+	tipo := ctx.Query("tipo_dte")
+	if tipo == "" {
+		tipo = ctx.PostForm("tipo_dte")
+	}
+	if tipo != "" {
+		numero, err := strconv.Atoi(tipo)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "tipo_dte inválido"})
+			return
+		}
+		solicitud.TipoDTE = models.TipoDTE(numero)
+	}
+
+	archivo, err := leerArchivoCAF(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	solicitud.Archivo = archivo
+
+	registro, err := c.importador.Importar(ctx.Request.Context(), solicitud)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "ImportarCAF"), zap.String("empresa_id", empresaID))
+		switch {
+		case errors.Is(err, folios.ErrSuperposicion), errors.Is(err, folios.ErrCAFDuplicado):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, folios.ErrCAFInvalido), errors.Is(err, folios.ErrFirmaSII),
+			errors.Is(err, folios.ErrEmpresaCAF), errors.Is(err, folios.ErrCAFVencido):
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, registro)
+}
+
+// RegisterRoutes registra las rutas del controlador
+func (c *CAFController) RegisterRoutes(router *gin.RouterGroup) {
+	router.POST("/empresas/:empresa_id/cafs", c.ImportarCAF)
+}
+
+// leerArchivoCAF toma el archivo del campo "archivo" o, si no viene, el cuerpo de la petición
+func leerArchivoCAF(ctx *gin.Context) ([]byte, error) {
+	if cabecera, err := ctx.FormFile("archivo"); err == nil {
+		if cabecera.Size > tamanoMaximoCAF {
+			return nil, errors.New("el archivo CAF excede el tamaño máximo")
+		}
+		archivo, err := cabecera.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer archivo.Close()
+		return io.ReadAll(archivo)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(ctx.Request.Body, tamanoMaximoCAF+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("se requiere el archivo CAF")
+	}
+	if len(data) > tamanoMaximoCAF {
+		return nil, errors.New("el archivo CAF excede el tamaño máximo")
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services"
+	"github.com/cursor/FMgo/services/cesion"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// EmpresaProvider entrega los datos de una empresa por su ID
+type EmpresaProvider interface {
+	ObtenerEmpresa(id string) (*models.Empresa, error)
+}
+
+// CesionController maneja las peticiones de cesión de facturas
+type CesionController struct {
+	cesionService      *cesion.Service
+	certificadoService *services.CertificadoService
+	empresas           EmpresaProvider
+}
+
+// NewCesionController crea una nueva instancia del controlador de cesiones
+func NewCesionController(cesionService *cesion.Service, certificadoService *services.CertificadoService, empresas EmpresaProvider) *CesionController {
+	return &CesionController{
+		cesionService:      cesionService,
+		certificadoService: certificadoService,
+		empresas:           empresas,
+	}
+}
+
+// CederFactura genera y envía el AEC de una factura al Registro Electrónico de Cesiones
+func (c *CesionController) CederFactura(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de factura es requerido"})
+		return
+	}
+
+	var request struct {
+		EmpresaID string                 `json:"empresa_id" binding:"required"`
+		Solicitud cesion.SolicitudCesion `json:"solicitud" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// El servicio sólo firma si la empresa es el cedente y el emisor del documento almacenado
+	empresa, err := c.empresas.ObtenerEmpresa(request.EmpresaID)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "CederFactura"))
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	firmador, err := c.certificadoService.FirmadorEmpresa(request.EmpresaID)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "CederFactura"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resultado, err := c.cesionService.CederDocumento(ctx.Request.Context(), firmador, empresa.RUT, id, request.Solicitud)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "CederFactura"), zap.String("id", id))
+		if errors.Is(err, cesion.ErrCedenteAjeno) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if resultado != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "cesion": resultado.Cesion})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resultado)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cursor/FMgo/services/folios"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ConciliacionController maneja la conciliación de los folios de las empresas
+type ConciliacionController struct {
+	conciliador *folios.Conciliador
+	empresas    folios.Empresas
+}
+
+// NewConciliacionController crea una nueva instancia del controlador de conciliaciones
+func NewConciliacionController(conciliador *folios.Conciliador, empresas folios.Empresas) *ConciliacionController {
+	return &ConciliacionController{
+		conciliador: conciliador,
+		empresas:    empresas,
+	}
+}
+
+// ConciliarFolios concilia los folios de la empresa. Con ?reparar=true registra el uso de los
+// folios de los hallazgos reparables.
+func (c *ConciliacionController) ConciliarFolios(ctx *gin.Context) {
+	empresaID := ctx.Param("empresa_id")
+	if empresaID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de empresa es requerido"})
+		return
+	}
+	empresa, err := c.empresas.ObtenerEmpresa(empresaID)
+	if err != nil || empresa == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "empresa no encontrada"})
+		return
+	}
+
+	reparar := ctx.Query("reparar") == "true"
+	reporte, err := c.conciliador.Conciliar(ctx.Request.Context(), empresa.RUT, empresa.Ambiente, reparar)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "ConciliarFolios"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, reporte)
+}
+
+// ObtenerConciliacion retorna un reporte de conciliación
+func (c *ConciliacionController) ObtenerConciliacion(ctx *gin.Context) {
+	reporte, err := c.conciliador.Obtener(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, reporte)
+}
+
+// ExportarConciliacion descarga el reporte en formato PDF (por defecto), CSV o JSON
+func (c *ConciliacionController) ExportarConciliacion(ctx *gin.Context) {
+	reporte, err := c.conciliador.Obtener(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	formato := strings.ToUpper(ctx.DefaultQuery("formato", "PDF"))
+	data, err := folios.ExportarConciliacion(reporte, formato)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/pdf"
+	switch formato {
+	case "CSV":
+		contentType = "text/csv"
+	case "JSON":
+		contentType = "application/json"
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=conciliacion_"+reporte.ID+"."+strings.ToLower(formato))
+	ctx.Data(http.StatusOK, contentType, data)
+}
+
+// RegisterRoutes registra las rutas del controlador
+func (c *ConciliacionController) RegisterRoutes(router *gin.RouterGroup) {
+	router.POST("/empresas/:empresa_id/conciliaciones", c.ConciliarFolios)
+	router.GET("/conciliaciones/:id", c.ObtenerConciliacion)
+	router.GET("/conciliaciones/:id/exportar", c.ExportarConciliacion)
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cursor/FMgo/services"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/folios"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// FolioController maneja la entrega de folios a los emisores
+type FolioController struct {
+	folioService *services.FolioService
+}
+
+// NewFolioController crea una nueva instancia del controlador de folios
+func NewFolioController(folioService *services.FolioService) *FolioController {
+	return &FolioController{
+		folioService: folioService,
+	}
+}
+
+// SiguienteFolio entrega el siguiente folio del tipo de documento para el emisor
+func (c *FolioController) SiguienteFolio(ctx *gin.Context) {
+	rutEmisor := ctx.Param("rut_emisor")
+	tipoDTE := ctx.Param("tipo_dte")
+
+	folio, err := c.folioService.ObtenerFolioDisponible(ctx.Request.Context(), rutEmisor, tipoDTE)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "SiguienteFolio"), zap.String("rut_emisor", rutEmisor))
+		switch {
+		case errors.Is(err, folios.ErrSinFolios):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, ambiente.ErrEmpresaDesconocida):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, folio)
+}
+
+// RegisterRoutes registra las rutas del controlador
+func (c *FolioController) RegisterRoutes(router *gin.RouterGroup) {
+	router.POST("/folios/:rut_emisor/:tipo_dte", c.SiguienteFolio)
+}
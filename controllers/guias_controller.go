@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/cursor/FMgo/services/guias"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GuiasController maneja las peticiones de guías de despacho
+type GuiasController struct {
+	guiasService *guias.Service
+}
+
+// NewGuiasController crea una nueva instancia del controlador de guías de despacho
+func NewGuiasController(guiasService *guias.Service) *GuiasController {
+	return &GuiasController{
+		guiasService: guiasService,
+	}
+}
+
+// FacturarGuias consolida en una factura las guías entregadas y no facturadas de un receptor
+// en el período indicado
+func (c *GuiasController) FacturarGuias(ctx *gin.Context) {
+	var params guias.ParametrosFacturacion
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resultado, err := c.guiasService.FacturarGuias(ctx.Request.Context(), params)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "FacturarGuias"), zap.String("rut_receptor", params.RutReceptor), zap.String("periodo", params.Periodo))
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, resultado)
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/cursor/FMgo/services"
+	"github.com/cursor/FMgo/services/intercambio"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// IntercambioController maneja la recepción de DTE de proveedores y sus respuestas
+type IntercambioController struct {
+	intercambioService *intercambio.Service
+	certificadoService *services.CertificadoService
+}
+
+// NewIntercambioController crea una nueva instancia del controlador de intercambio
+func NewIntercambioController(intercambioService *intercambio.Service, certificadoService *services.CertificadoService) *IntercambioController {
+	return &IntercambioController{
+		intercambioService: intercambioService,
+		certificadoService: certificadoService,
+	}
+}
+
+// RecibirEnvio recibe un EnvioDTE de un proveedor y responde con la recepción del envío
+func (c *IntercambioController) RecibirEnvio(ctx *gin.Context) {
+	empresaID := ctx.PostForm("empresa_id")
+	rutReceptor := ctx.PostForm("rut_receptor")
+	if empresaID == "" || rutReceptor == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "empresa_id y rut_receptor son requeridos"})
+		return
+	}
+
+	archivo, err := ctx.FormFile("archivo")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Archivo no proporcionado"})
+		return
+	}
+	contenido, err := archivo.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Error al leer archivo"})
+		return
+	}
+	defer contenido.Close()
+	data, err := io.ReadAll(contenido)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Error al leer archivo"})
+		return
+	}
+
+	firmador, err := c.certificadoService.FirmadorEmpresa(empresaID)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "RecibirEnvio"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resultado, err := c.intercambioService.RecibirEnvio(ctx.Request.Context(), firmador, rutReceptor, archivo.Filename, data)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "RecibirEnvio"), zap.String("archivo", archivo.Filename))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resultado)
+}
+
+// ResponderDocumentos genera el resultado comercial (aceptación o rechazo) de los DTE recibidos
+func (c *IntercambioController) ResponderDocumentos(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de intercambio es requerido"})
+		return
+	}
+
+	var request struct {
+		EmpresaID  string                    `json:"empresa_id" binding:"required"`
+		Decisiones []intercambio.DecisionDTE `json:"decisiones"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	firmador, err := c.certificadoService.FirmadorEmpresa(request.EmpresaID)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "ResponderDocumentos"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resultado, err := c.intercambioService.ResponderDocumentos(ctx.Request.Context(), firmador, id, request.Decisiones)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "ResponderDocumentos"), zap.String("id", id))
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resultado)
+}
+
+// DescargarRespuesta entrega el XML firmado de la respuesta indicada (recepcion o resultado)
+// para enviarlo al emisor
+func (c *IntercambioController) DescargarRespuesta(ctx *gin.Context) {
+	id := ctx.Param("id")
+	resultado, err := c.intercambioService.ObtenerIntercambio(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var xmlRespuesta string
+	switch ctx.Param("tipo") {
+	case "recepcion":
+		xmlRespuesta = resultado.XMLRecepcion
+	case "resultado":
+		xmlRespuesta = resultado.XMLResultado
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "tipo de respuesta inválido"})
+		return
+	}
+	if xmlRespuesta == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "respuesta no generada"})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/xml", []byte(xmlRespuesta))
+}
+
+// RegistrarAcuse marca un documento recibido como entregado y genera el acuse de recibo (Ley 19.983)
+func (c *IntercambioController) RegistrarAcuse(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de documento es requerido"})
+		return
+	}
+
+	var request struct {
+		EmpresaID string                     `json:"empresa_id" binding:"required"`
+		Acuse     intercambio.SolicitudAcuse `json:"acuse" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	firmador, err := c.certificadoService.FirmadorEmpresa(request.EmpresaID)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "RegistrarAcuse"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	acuse, err := c.intercambioService.RegistrarAcuse(ctx.Request.Context(), firmador, id, request.Acuse, ctx.GetString("user_id"), ctx.ClientIP())
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "RegistrarAcuse"), zap.String("id", id))
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, acuse)
+}
+
+// ObtenerAcuse obtiene un acuse de recibo con su historial
+func (c *IntercambioController) ObtenerAcuse(ctx *gin.Context) {
+	acuse, err := c.intercambioService.ObtenerAcuse(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, acuse)
+}
+
+// DescargarAcuse entrega el EnvioRecibos firmado para enviarlo al emisor del documento
+func (c *IntercambioController) DescargarAcuse(ctx *gin.Context) {
+	xmlEnvio, err := c.intercambioService.DescargarAcuse(ctx.Request.Context(), ctx.Param("id"), ctx.GetString("user_id"), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/xml", []byte(xmlEnvio))
+}
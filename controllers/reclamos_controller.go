@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/reclamos"
+	"github.com/cursor/FMgo/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ReclamosController maneja el registro de aceptación o reclamo de DTE ante el SII
+type ReclamosController struct {
+	reclamosService *reclamos.Service
+}
+
+// NewReclamosController crea una nueva instancia del controlador de reclamos
+func NewReclamosController(reclamosService *reclamos.Service) *ReclamosController {
+	return &ReclamosController{
+		reclamosService: reclamosService,
+	}
+}
+
+// RegistrarAccion acepta, reclama u otorga el recibo de mercaderías de un documento recibido
+func (c *ReclamosController) RegistrarAccion(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "ID de documento es requerido"})
+		return
+	}
+
+	var request struct {
+		Accion models.AccionRegistro `json:"accion" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !request.Accion.EsValida() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "acción inválida"})
+		return
+	}
+
+	respuesta, err := c.reclamosService.RegistrarAccion(ctx.Request.Context(), id, request.Accion)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "RegistrarAccion"), zap.String("id", id))
+		if respuesta != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "respuesta": respuesta})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, respuesta)
+}
+
+// ObtenerEventos lista los eventos conocidos de un documento
+func (c *ReclamosController) ObtenerEventos(ctx *gin.Context) {
+	tipo, err := strconv.Atoi(ctx.Param("tipo"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "tipo de documento inválido"})
+		return
+	}
+	folio, err := strconv.ParseInt(ctx.Param("folio"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "folio inválido"})
+		return
+	}
+
+	eventos, err := c.reclamosService.ObtenerEventos(ctx.Request.Context(), ctx.Param("rut"), tipo, folio)
+	if err != nil {
+		utils.LogError(err, zap.String("endpoint", "ObtenerEventos"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, eventos)
+}
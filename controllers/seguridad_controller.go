@@ -97,12 +97,13 @@ func (c *SeguridadController) RegistrarOperacion(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Operación registrada correctamente"})
 }
 
-// ValidarFirmaDigital valida una firma digital
+// ValidarFirmaDigital valida una firma digital. Si no se informa firma, el documento se
+// trata como XML firmado y se verifica cada uno de sus nodos Signature.
 func (c *SeguridadController) ValidarFirmaDigital(ctx *gin.Context) {
 	var request struct {
-		UsuarioID string `json:"usuario_id" binding:"required"`
+		UsuarioID string `json:"usuario_id"`
 		Documento string `json:"documento" binding:"required"`
-		Firma     string `json:"firma" binding:"required"`
+		Firma     string `json:"firma"`
 	}
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -116,6 +117,26 @@ func (c *SeguridadController) ValidarFirmaDigital(ctx *gin.Context) {
 		return
 	}
 
+	if request.Firma == "" {
+		firmas, err := c.seguridadService.VerificarFirmasXML(documento)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		valido := true
+		for _, f := range firmas {
+			valido = valido && f.Valida
+		}
+		ctx.JSON(http.StatusOK, gin.H{"valido": valido, "firmas": firmas})
+		return
+	}
+
+	if request.UsuarioID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "usuario_id es requerido para validar una firma separada"})
+		return
+	}
+
 	firma, err := base64.StdEncoding.DecodeString(request.Firma)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "firma inválida"})
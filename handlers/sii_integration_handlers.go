@@ -94,8 +94,13 @@ func (h *SIIIntegrationHandler) ConsultarEstadoHandler(w http.ResponseWriter, r
 		http.Error(w, "Track ID no proporcionado", http.StatusBadRequest)
 		return
 	}
+	rutEmpresa := r.URL.Query().Get("rut_empresa")
+	if rutEmpresa == "" {
+		http.Error(w, "RUT de empresa no proporcionado", http.StatusBadRequest)
+		return
+	}
 
-	estado, err := h.siiService.ConsultarEstado(trackID)
+	estado, err := h.siiService.ConsultarEstado(rutEmpresa, trackID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
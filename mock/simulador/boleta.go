@@ -0,0 +1,251 @@
+package simulador
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// boletaSemilla atiende boleta.electronica.semilla, que retorna la SII:RESPUESTA sin sobre SOAP
+func (s *Simulador) boletaSemilla(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioBoletaSemilla, tieneFalla); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	semilla := s.nuevaSemilla()
+	s.mu.Unlock()
+
+	escribirXML(w, respuestaSII("00", "", map[string]string{"SEMILLA": semilla}))
+}
+
+// boletaToken atiende boleta.electronica.token con la semilla firmada en el cuerpo
+func (s *Simulador) boletaToken(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioBoletaToken, tieneFalla); !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error al leer solicitud", http.StatusBadRequest)
+		return
+	}
+	valor, estado, err := s.canjearSemilla(string(body), true)
+	if err != nil {
+		escribirXML(w, respuestaSII(estado, err.Error(), nil))
+		return
+	}
+	escribirXML(w, respuestaSII(estado, "Token Creado", map[string]string{"TOKEN": valor}))
+}
+
+// boletaEnvio atiende boleta.electronica.envio. El envío siempre queda en estado REC; su estado
+// final se informa en la consulta por TrackID.
+func (s *Simulador) boletaEnvio(w http.ResponseWriter, r *http.Request) {
+	base, ok := s.entrada(w, r, ServicioBoletaEnvio, paraRecepcion)
+	if !ok {
+		return
+	}
+	if !s.autorizarBoleta(w, r) {
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "formulario inválido", http.StatusBadRequest)
+		return
+	}
+
+	rutEnvia := r.FormValue("rutSender") + "-" + r.FormValue("dvSender")
+	rutEmpresa := r.FormValue("rutCompany") + "-" + r.FormValue("dvCompany")
+	s.mu.Lock()
+	especifico, _ := s.aplicar(paraRecepcion, claveRUT(rutEmpresa))
+	s.mu.Unlock()
+	if s.fallar(w, r, especifico) {
+		return
+	}
+	escenario := combinar(base, especifico)
+
+	archivo, cabecera, err := r.FormFile("archivo")
+	if err != nil {
+		http.Error(w, "falta el archivo", http.StatusBadRequest)
+		return
+	}
+	defer archivo.Close()
+	contenido, err := io.ReadAll(archivo)
+	if err != nil || len(contenido) == 0 {
+		http.Error(w, "archivo vacío", http.StatusBadRequest)
+		return
+	}
+	if escenario.StatusUpload != "" && escenario.StatusUpload != "0" {
+		http.Error(w, "envío rechazado con status "+escenario.StatusUpload, http.StatusBadRequest)
+		return
+	}
+
+	envio := s.recibir(ServicioBoletaEnvio, rutEmpresa, rutEnvia, cabecera.Filename, contenido, escenario)
+	trackID, _ := strconv.ParseInt(envio.TrackID, 10, 64)
+	escribirJSON(w, models.RespuestaEnvioBoleta{
+		RutEmisor:      envio.RutEmisor,
+		RutEnvia:       envio.RutEnvia,
+		TrackID:        trackID,
+		FechaRecepcion: envio.Recibido.Format("2006-01-02 15:04:05"),
+		Estado:         EstadoEnvioRecibido,
+		Archivo:        envio.Archivo,
+	})
+}
+
+// boletaEstadoEnvio atiende la consulta de un envío de boletas por {rut}-{dv}-{trackid}
+func (s *Simulador) boletaEstadoEnvio(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioBoletaEstadoEnvio, paraConsultaEnvio); !ok {
+		return
+	}
+	if !s.autorizarBoleta(w, r) {
+		return
+	}
+
+	partes := strings.Split(r.PathValue("id"), "-")
+	if len(partes) != 3 {
+		http.Error(w, "identificador de envío inválido", http.StatusBadRequest)
+		return
+	}
+	rutEmpresa := partes[0] + "-" + partes[1]
+	s.mu.Lock()
+	especifico, _ := s.aplicar(paraConsultaEnvio, claveRUT(rutEmpresa))
+	s.mu.Unlock()
+	if s.fallar(w, r, especifico) {
+		return
+	}
+
+	estado, estadisticas := s.consultarEnvio(partes[2], rutEmpresa)
+	if estado == EstadoConsultaTrackNoExiste {
+		http.Error(w, "TrackID no existe", http.StatusNotFound)
+		return
+	}
+	envio, _ := s.Envio(partes[2])
+
+	trackID, _ := strconv.ParseInt(envio.TrackID, 10, 64)
+	respuesta := models.EstadoEnvioBoleta{
+		RutEmisor:      envio.RutEmisor,
+		RutEnvia:       envio.RutEnvia,
+		TrackID:        trackID,
+		FechaRecepcion: envio.Recibido.Format("2006-01-02 15:04:05"),
+		Estado:         estado,
+	}
+	for _, e := range estadisticas {
+		respuesta.Estadisticas = append(respuesta.Estadisticas, models.EstadisticaEnvioBoleta{
+			Tipo:       e.tipo,
+			Informados: e.informados,
+			Aceptados:  e.aceptados,
+			Rechazados: e.rechazados,
+			Reparos:    e.reparos,
+		})
+	}
+	if estadisticas != nil {
+		for _, doc := range envio.Documentos {
+			if detalle, ok := detalleBoleta(doc); ok {
+				respuesta.Detalles = append(respuesta.Detalles, detalle)
+			}
+		}
+	}
+	escribirJSON(w, respuesta)
+}
+
+// detalleBoleta retorna el detalle de una boleta rechazada o aceptada con reparos
+func detalleBoleta(doc Documento) (models.DetalleRechazoBoleta, bool) {
+	detalle := models.DetalleRechazoBoleta{Tipo: doc.TipoDTE, Folio: doc.Folio}
+	switch doc.Resultado {
+	case DocumentoRechazado:
+		detalle.Estado = EstadoEnvioRechazado
+		detalle.Descripcion = "Rechazado"
+		detalle.Errores = []models.ErrorBoletaSII{{
+			Seccion: "DTE", Linea: 1, Nivel: 1, Codigo: 100,
+			Descripcion: "Documento rechazado por el simulador", Detalle: "Encabezado",
+		}}
+	case DocumentoReparos:
+		detalle.Estado = EstadoEnvioReparos
+		detalle.Descripcion = "Aceptado con reparos"
+		detalle.Errores = []models.ErrorBoletaSII{{
+			Seccion: "DTE", Linea: 1, Nivel: 2, Codigo: 200,
+			Descripcion: "Documento con reparos del simulador", Detalle: "Encabezado",
+		}}
+	default:
+		return detalle, false
+	}
+	return detalle, true
+}
+
+// boletaEstado atiende la consulta de una boleta por {rut}-{dv}-{tipo}-{folio}
+func (s *Simulador) boletaEstado(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioBoletaEstado, paraConsultaDocumento); !ok {
+		return
+	}
+	if !s.autorizarBoleta(w, r) {
+		return
+	}
+
+	partes := strings.Split(r.PathValue("id"), "-")
+	if len(partes) != 4 {
+		http.Error(w, "identificador de boleta inválido", http.StatusBadRequest)
+		return
+	}
+	rutEmpresa := partes[0] + "-" + partes[1]
+	tipo, errTipo := strconv.Atoi(partes[2])
+	folio, errFolio := strconv.ParseInt(partes[3], 10, 64)
+	consulta := r.URL.Query()
+	monto, errMonto := strconv.ParseInt(consulta.Get("monto"), 10, 64)
+	fecha, errFecha := time.Parse("02-01-2006", consulta.Get("fechaEmision"))
+	if errTipo != nil || errFolio != nil || errMonto != nil || errFecha != nil {
+		http.Error(w, "parámetros de consulta inválidos", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	especifico, _ := s.aplicar(paraConsultaDocumento, claveFolio(rutEmpresa, tipo, folio), claveRUT(rutEmpresa))
+	s.mu.Unlock()
+	if s.fallar(w, r, especifico) {
+		return
+	}
+
+	estado := especifico.EstadoDTE
+	if estado == "" {
+		estado = s.estadoDocumento(Documento{
+			TipoDTE:      tipo,
+			Folio:        folio,
+			RutEmisor:    utils.NormalizarRUT(rutEmpresa),
+			RutReceptor:  utils.NormalizarRUT(consulta.Get("rut_receptor") + "-" + consulta.Get("dv_receptor")),
+			FechaEmision: fecha.Format("2006-01-02"),
+			MontoTotal:   monto,
+		})
+	}
+	escribirJSON(w, models.EstadoDocumentoBoleta{
+		Codigo:      estado,
+		Estado:      estado,
+		Descripcion: GlosaEstado(estado),
+	})
+}
+
+// autorizarBoleta valida la cookie TOKEN emitida por los servicios de boleta; si no es válida
+// responde 401, como el SII
+func (s *Simulador) autorizarBoleta(w http.ResponseWriter, r *http.Request) bool {
+	cookie, err := r.Cookie("TOKEN")
+	if err == nil {
+		if valido, _ := s.validarToken(cookie.Value, true); valido {
+			return true
+		}
+	}
+	http.Error(w, "token inválido", http.StatusUnauthorized)
+	return false
+}
+
+func escribirXML(w http.ResponseWriter, respuesta string) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, respuesta)
+}
+
+func escribirJSON(w http.ResponseWriter, respuesta interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(respuesta)
+}
@@ -0,0 +1,567 @@
+package simulador
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// crSeed atiende getSeed con una semilla nueva
+func (s *Simulador) crSeed(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioSemilla, tieneFalla); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	semilla := s.nuevaSemilla()
+	s.mu.Unlock()
+
+	respuesta := respuestaSII("00", "", map[string]string{"SEMILLA": semilla})
+	escribirSOAP(w, "getSeed", respuesta)
+}
+
+// getToken atiende getToken canjeando la semilla firmada por un token
+func (s *Simulador) getToken(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioToken, tieneFalla); !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error al leer solicitud", http.StatusBadRequest)
+		return
+	}
+	solicitud, ok := parametrosSOAP(body)["pszXml"]
+	if !ok {
+		solicitud = string(body)
+	}
+
+	valor, estado, err := s.canjearSemilla(solicitud, false)
+	if err != nil {
+		escribirSOAP(w, "getToken", respuestaSII(estado, err.Error(), nil))
+		return
+	}
+	escribirSOAP(w, "getToken", respuestaSII(estado, "Token Creado", map[string]string{"TOKEN": valor}))
+}
+
+// upload atiende los servicios de carga de archivos (DTEUpload y registro de cesiones). La
+// respuesta es el XML raiz (RECEPCIONDTE o RECEPCIONAEC) con el STATUS y el TrackID.
+func (s *Simulador) upload(servicio Servicio, raiz string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base, ok := s.entrada(w, r, servicio, paraRecepcion)
+		if !ok {
+			return
+		}
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			escribirRecepcion(w, raiz, map[string]string{"STATUS": "3"})
+			return
+		}
+
+		rutEnvia := r.FormValue("rutSender") + "-" + r.FormValue("dvSender")
+		if servicio == ServicioUploadAEC {
+			rutEnvia = r.FormValue("emailNotif")
+		}
+		rutEmpresa := r.FormValue("rutCompany") + "-" + r.FormValue("dvCompany")
+		campos := map[string]string{
+			"RUTSENDER":  rutEnvia,
+			"RUTCOMPANY": rutEmpresa,
+		}
+
+		cookie, err := r.Cookie("TOKEN")
+		if err != nil {
+			campos["STATUS"] = "5"
+			escribirRecepcion(w, raiz, campos)
+			return
+		}
+		if valido, _ := s.validarToken(cookie.Value, false); !valido {
+			campos["STATUS"] = "5"
+			escribirRecepcion(w, raiz, campos)
+			return
+		}
+
+		s.mu.Lock()
+		especifico, _ := s.aplicar(paraRecepcion, claveRUT(rutEmpresa))
+		s.mu.Unlock()
+		if s.fallar(w, r, especifico) {
+			return
+		}
+		escenario := combinar(base, especifico)
+
+		archivo, cabecera, err := r.FormFile("archivo")
+		if err != nil {
+			campos["STATUS"] = "3"
+			escribirRecepcion(w, raiz, campos)
+			return
+		}
+		defer archivo.Close()
+		contenido, err := io.ReadAll(archivo)
+		if err != nil || len(contenido) == 0 {
+			campos["STATUS"] = "2"
+			escribirRecepcion(w, raiz, campos)
+			return
+		}
+		campos["FILE"] = cabecera.Filename
+
+		if escenario.StatusUpload != "" && escenario.StatusUpload != "0" {
+			campos["STATUS"] = escenario.StatusUpload
+			escribirRecepcion(w, raiz, campos)
+			return
+		}
+
+		envio := s.recibir(servicio, rutEmpresa, rutEnvia, cabecera.Filename, contenido, escenario)
+		campos["TIMESTAMP"] = envio.Recibido.Format("2006-01-02 15:04:05")
+		campos["STATUS"] = "0"
+		campos["TRACKID"] = envio.TrackID
+		escribirRecepcion(w, raiz, campos)
+	}
+}
+
+// recibir registra el envío con su TrackID y determina su estado final y el de cada documento
+func (s *Simulador) recibir(servicio Servicio, rutEmpresa, rutEnvia, nombre string, contenido []byte, escenario Escenario) *Envio {
+	documentos, estado := leerEnvio(contenido)
+	if estado == "" && escenario.EstadoEnvio != "" {
+		estado = escenario.EstadoEnvio
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trackID++
+	envio := &Envio{
+		TrackID:   strconv.FormatInt(s.trackID, 10),
+		Servicio:  servicio,
+		RutEmisor: utils.NormalizarRUT(rutEmpresa),
+		RutEnvia:  utils.NormalizarRUT(rutEnvia),
+		Archivo:   nombre,
+		Recibido:  s.ahora(),
+		Estado:    estado,
+		enProceso: s.enProceso,
+	}
+	if escenario.EnProceso > 0 {
+		envio.enProceso = escenario.EnProceso
+	}
+
+	if envio.Estado == "" {
+		envio.Estado = EstadoEnvioProcesado
+		rechazados := 0
+		for i := range documentos {
+			doc := &documentos[i]
+			if doc.RutEmisor == "" {
+				doc.RutEmisor = envio.RutEmisor
+			}
+			porFolio, _ := s.aplicar(paraDocumentoRecibido, claveFolio(doc.RutEmisor, doc.TipoDTE, doc.Folio))
+			resultado := combinar(escenario, porFolio)
+			switch {
+			case resultado.Rechazar:
+				doc.Resultado = DocumentoRechazado
+				rechazados++
+			case resultado.Reparos:
+				doc.Resultado = DocumentoReparos
+			default:
+				doc.Resultado = DocumentoAceptado
+			}
+			if doc.Resultado != DocumentoRechazado {
+				registrado := *doc
+				s.documentos[claveFolio(doc.RutEmisor, doc.TipoDTE, doc.Folio)] = &registrado
+			}
+		}
+		if len(documentos) > 0 && rechazados == len(documentos) && servicio == ServicioBoletaEnvio {
+			envio.Estado = EstadoEnvioRechazado
+		}
+	}
+	envio.Documentos = documentos
+
+	s.envios[envio.TrackID] = envio
+	return envio
+}
+
+// leerEnvio extrae los documentos del archivo. Retorna un estado de rechazo si el archivo no es
+// un XML válido o si alguna de sus firmas no verifica.
+func leerEnvio(contenido []byte) ([]Documento, string) {
+	doc, err := xmldsig.ParseDocument(contenido)
+	if err != nil || doc.Root() == nil {
+		return nil, EstadoEnvioRechazoSchema
+	}
+
+	if len(xmldsig.BuscarFirmas(doc.Root())) > 0 {
+		verificaciones, err := xmldsig.VerificarFirmas(contenido)
+		if err != nil {
+			return nil, EstadoEnvioRechazoFirma
+		}
+		for _, v := range verificaciones {
+			if v.Error != nil {
+				return nil, EstadoEnvioRechazoFirma
+			}
+		}
+	}
+
+	var documentos []Documento
+	for _, dte := range doc.FindElements("//Encabezado") {
+		tipo, _ := strconv.Atoi(textoElemento(dte, "IdDoc/TipoDTE"))
+		folio, _ := strconv.ParseInt(textoElemento(dte, "IdDoc/Folio"), 10, 64)
+		monto, _ := strconv.ParseFloat(textoElemento(dte, "Totales/MntTotal"), 64)
+		documentos = append(documentos, Documento{
+			TipoDTE:      tipo,
+			Folio:        folio,
+			RutEmisor:    utils.NormalizarRUT(textoElemento(dte, "Emisor/RUTEmisor")),
+			RutReceptor:  utils.NormalizarRUT(textoElemento(dte, "Receptor/RUTRecep")),
+			FechaEmision: textoElemento(dte, "IdDoc/FchEmis"),
+			MontoTotal:   int64(monto + 0.5),
+		})
+	}
+	return documentos, ""
+}
+
+// queryEstUp atiende getEstUp con el estado del envío
+func (s *Simulador) queryEstUp(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioEstadoUpload, paraConsultaEnvio); !ok {
+		return
+	}
+	parametros, ok := leerParametros(w, r)
+	if !ok {
+		return
+	}
+
+	rutEmpresa := parametros["rutcompania"] + "-" + parametros["dvcompania"]
+	s.mu.Lock()
+	especifico, _ := s.aplicar(paraConsultaEnvio, claveRUT(rutEmpresa))
+	s.mu.Unlock()
+	if s.fallar(w, r, especifico) {
+		return
+	}
+
+	if valido, estado := s.validarToken(tokenSolicitud(r, parametros), false); !valido {
+		s.escribirEstadoUpload(w, parametros["trackid"], estado, nil)
+		return
+	}
+
+	estado, estadisticas := s.consultarEnvio(parametros["trackid"], rutEmpresa)
+	s.escribirEstadoUpload(w, parametros["trackid"], estado, estadisticas)
+}
+
+// estadistica resume los documentos de un tipo dentro de un envío
+type estadistica struct {
+	tipo                                       int
+	informados, aceptados, rechazados, reparos int
+}
+
+// consultarEnvio cuenta una consulta del envío y retorna su estado vigente y, si ya fue
+// procesado, el resumen por tipo de documento
+func (s *Simulador) consultarEnvio(trackID, rutEmpresa string) (string, []estadistica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	envio, ok := s.envios[strings.TrimSpace(trackID)]
+	if !ok || envio.RutEmisor != utils.NormalizarRUT(rutEmpresa) {
+		return EstadoConsultaTrackNoExiste, nil
+	}
+	envio.Consultas++
+	if envio.Consultas <= envio.enProceso {
+		indice := envio.Consultas - 1
+		if indice >= len(estadosEnProceso) {
+			indice = len(estadosEnProceso) - 1
+		}
+		return estadosEnProceso[indice], nil
+	}
+	if envio.Estado != EstadoEnvioProcesado && envio.Estado != EstadoEnvioRechazado {
+		return envio.Estado, nil
+	}
+
+	porTipo := make(map[int]*estadistica)
+	hayReparos := false
+	for _, doc := range envio.Documentos {
+		e, ok := porTipo[doc.TipoDTE]
+		if !ok {
+			e = &estadistica{tipo: doc.TipoDTE}
+			porTipo[doc.TipoDTE] = e
+		}
+		e.informados++
+		switch doc.Resultado {
+		case DocumentoRechazado:
+			e.rechazados++
+		case DocumentoReparos:
+			e.aceptados++
+			e.reparos++
+			hayReparos = true
+		default:
+			e.aceptados++
+		}
+	}
+	estadisticas := make([]estadistica, 0, len(porTipo))
+	for _, e := range porTipo {
+		estadisticas = append(estadisticas, *e)
+	}
+	sort.Slice(estadisticas, func(i, j int) bool { return estadisticas[i].tipo < estadisticas[j].tipo })
+
+	if envio.Servicio == ServicioBoletaEnvio && envio.Estado == EstadoEnvioProcesado && hayReparos {
+		return EstadoEnvioReparos, estadisticas
+	}
+	return envio.Estado, estadisticas
+}
+
+// escribirEstadoUpload responde getEstUp con el estado y el resumen por tipo de documento
+func (s *Simulador) escribirEstadoUpload(w http.ResponseWriter, trackID, estado string, estadisticas []estadistica) {
+	doc, hdr, body := documentoRespuesta()
+	hdr.CreateElement("TRACKID").SetText(trackID)
+	hdr.CreateElement("ESTADO").SetText(estado)
+	hdr.CreateElement("GLOSA").SetText(GlosaEstado(estado))
+	if estado == EstadoConsultaTrackNoExiste {
+		// Error -11 con ERR_CODE 1: el envío no pertenece a la empresa
+		hdr.CreateElement("ERR_CODE").SetText("1")
+		hdr.CreateElement("SQL_CODE").SetText("0")
+		hdr.CreateElement("SRV_CODE").SetText("0")
+	}
+	s.mu.Lock()
+	hdr.CreateElement("NUM_ATENCION").SetText(s.numeroAtencion())
+	s.mu.Unlock()
+	for _, e := range estadisticas {
+		body.CreateElement("TIPO_DOCTO").SetText(strconv.Itoa(e.tipo))
+		body.CreateElement("INFORMADOS").SetText(strconv.Itoa(e.informados))
+		body.CreateElement("ACEPTADOS").SetText(strconv.Itoa(e.aceptados))
+		body.CreateElement("RECHAZADOS").SetText(strconv.Itoa(e.rechazados))
+		body.CreateElement("REPAROS").SetText(strconv.Itoa(e.reparos))
+	}
+	if len(estadisticas) == 0 {
+		doc.Root().RemoveChild(body)
+	}
+	escribirSOAP(w, "getEstUp", serializar(doc))
+}
+
+// queryEstDte atiende getEstDte comparando los datos consultados con los del documento recibido
+func (s *Simulador) queryEstDte(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.entrada(w, r, ServicioEstadoDTE, paraConsultaDocumento); !ok {
+		return
+	}
+	parametros, ok := leerParametros(w, r)
+	if !ok {
+		return
+	}
+
+	rutEmpresa := parametros["rutcompania"] + "-" + parametros["dvcompania"]
+	tipo, errTipo := strconv.Atoi(parametros["tipodte"])
+	folio, errFolio := strconv.ParseInt(parametros["foliodte"], 10, 64)
+	monto, errMonto := strconv.ParseInt(parametros["montodte"], 10, 64)
+	fecha, errFecha := time.Parse("02012006", parametros["fechaemisiondte"])
+
+	s.mu.Lock()
+	especifico, _ := s.aplicar(paraConsultaDocumento, claveFolio(rutEmpresa, tipo, folio), claveRUT(rutEmpresa))
+	s.mu.Unlock()
+	if s.fallar(w, r, especifico) {
+		return
+	}
+
+	if valido, estado := s.validarToken(tokenSolicitud(r, parametros), false); !valido {
+		s.escribirEstadoDTE(w, estado)
+		return
+	}
+	if errTipo != nil || errFolio != nil || errMonto != nil || errFecha != nil {
+		s.escribirEstadoDTE(w, EstadoConsultaDatosInvalidos)
+		return
+	}
+
+	consulta := Documento{
+		TipoDTE:      tipo,
+		Folio:        folio,
+		RutEmisor:    utils.NormalizarRUT(rutEmpresa),
+		RutReceptor:  utils.NormalizarRUT(parametros["rutreceptor"] + "-" + parametros["dvreceptor"]),
+		FechaEmision: fecha.Format("2006-01-02"),
+		MontoTotal:   monto,
+	}
+	estado := especifico.EstadoDTE
+	if estado == "" {
+		estado = s.estadoDocumento(consulta)
+	}
+	s.escribirEstadoDTE(w, estado)
+}
+
+// estadoDocumento compara la consulta con el documento registrado
+func (s *Simulador) estadoDocumento(consulta Documento) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	registrado, ok := s.documentos[claveFolio(consulta.RutEmisor, consulta.TipoDTE, consulta.Folio)]
+	switch {
+	case !ok:
+		return EstadoDTENoRecibido
+	case registrado.RutReceptor != consulta.RutReceptor,
+		registrado.FechaEmision != consulta.FechaEmision,
+		registrado.MontoTotal != consulta.MontoTotal:
+		return EstadoDTEDatosNoOK
+	default:
+		return EstadoDTEDatosOK
+	}
+}
+
+// escribirEstadoDTE responde getEstDte con el estado del documento
+func (s *Simulador) escribirEstadoDTE(w http.ResponseWriter, estado string) {
+	doc, hdr, _ := documentoRespuesta()
+	hdr.CreateElement("ESTADO").SetText(estado)
+	hdr.CreateElement("GLOSA_ESTADO").SetText(GlosaEstado(estado))
+	hdr.CreateElement("ERR_CODE").SetText(models.EstadoConsultaDTE(estado).ErrCode())
+	hdr.CreateElement("GLOSA_ERR").SetText("")
+	s.mu.Lock()
+	hdr.CreateElement("NUM_ATENCION").SetText(s.numeroAtencion())
+	s.mu.Unlock()
+	escribirSOAP(w, "getEstDte", serializar(doc))
+}
+
+// leerParametros lee los parámetros de una llamada SOAP y los de la URL, con nombres en
+// minúscula. Si el cuerpo no es XML responde 400 y retorna false.
+func leerParametros(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	parametros := make(map[string]string)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error al leer solicitud", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		soap := parametrosSOAP(body)
+		if soap == nil {
+			http.Error(w, "solicitud SOAP inválida", http.StatusBadRequest)
+			return nil, false
+		}
+		for nombre, valor := range soap {
+			parametros[strings.ToLower(nombre)] = valor
+		}
+	}
+	for nombre, valores := range r.URL.Query() {
+		if len(valores) > 0 {
+			parametros[strings.ToLower(nombre)] = valores[0]
+		}
+	}
+	return parametros, true
+}
+
+// parametrosSOAP retorna el texto de los elementos hoja del cuerpo SOAP por nombre local, o nil
+// si el cuerpo no es XML
+func parametrosSOAP(body []byte) map[string]string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(body); err != nil {
+		return nil
+	}
+	parametros := make(map[string]string)
+	for _, elemento := range doc.FindElements("//*") {
+		if len(elemento.ChildElements()) == 0 {
+			parametros[elemento.Tag] = strings.TrimSpace(elemento.Text())
+		}
+	}
+	return parametros
+}
+
+// tokenSolicitud retorna el token del parámetro Token o, si no viene, de la cookie TOKEN
+func tokenSolicitud(r *http.Request, parametros map[string]string) string {
+	if valor := parametros["token"]; valor != "" {
+		return valor
+	}
+	if cookie, err := r.Cookie("TOKEN"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// combinar superpone los campos definidos del escenario específico sobre los del general
+func combinar(general, especifico Escenario) Escenario {
+	if especifico.StatusUpload != "" {
+		general.StatusUpload = especifico.StatusUpload
+	}
+	if especifico.EstadoEnvio != "" {
+		general.EstadoEnvio = especifico.EstadoEnvio
+	}
+	if especifico.Rechazar {
+		general.Rechazar = true
+		general.Reparos = false
+	}
+	if especifico.Reparos {
+		general.Reparos = true
+		general.Rechazar = false
+	}
+	if especifico.EstadoDTE != "" {
+		general.EstadoDTE = especifico.EstadoDTE
+	}
+	if especifico.EnProceso > 0 {
+		general.EnProceso = especifico.EnProceso
+	}
+	return general
+}
+
+// documentoRespuesta crea una SII:RESPUESTA con sus secciones RESP_HDR y RESP_BODY
+func documentoRespuesta() (*etree.Document, *etree.Element, *etree.Element) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	raiz := doc.CreateElement("SII:RESPUESTA")
+	raiz.CreateAttr("xmlns:SII", "http://www.sii.cl/XMLSchema")
+	hdr := raiz.CreateElement("SII:RESP_HDR")
+	body := raiz.CreateElement("SII:RESP_BODY")
+	return doc, hdr, body
+}
+
+// respuestaSII arma una SII:RESPUESTA con el estado, la glosa y los campos del cuerpo
+func respuestaSII(estado, glosa string, campos map[string]string) string {
+	doc, hdr, body := documentoRespuesta()
+	hdr.CreateElement("ESTADO").SetText(estado)
+	if glosa != "" {
+		hdr.CreateElement("GLOSA").SetText(glosa)
+	}
+	nombres := make([]string, 0, len(campos))
+	for nombre := range campos {
+		nombres = append(nombres, nombre)
+	}
+	sort.Strings(nombres)
+	for _, nombre := range nombres {
+		body.CreateElement(nombre).SetText(campos[nombre])
+	}
+	return serializar(doc)
+}
+
+func serializar(doc *etree.Document) string {
+	texto, err := doc.WriteToString()
+	if err != nil {
+		// etree sólo falla al escribir si falla el io.Writer, que aquí es un buffer
+		panic(fmt.Sprintf("error al serializar respuesta: %v", err))
+	}
+	return texto
+}
+
+// escribirSOAP envuelve la respuesta en el sobre SOAP de los servicios DTEWS, que la retornan
+// escapada dentro del elemento {metodo}Return
+func escribirSOAP(w http.ResponseWriter, metodo, respuesta string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<soapenv:Body><ns1:%sResponse soapenv:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/" xmlns:ns1="http://DefaultNamespace">`+
+		`<%sReturn xsi:type="xsd:string">%s</%sReturn></ns1:%sResponse></soapenv:Body></soapenv:Envelope>`,
+		metodo, metodo, html.EscapeString(respuesta), metodo, metodo)
+}
+
+// escribirRecepcion responde un servicio de carga con el XML de recepción
+func escribirRecepcion(w http.ResponseWriter, raiz string, campos map[string]string) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0"`)
+	recepcion := doc.CreateElement(raiz)
+	for _, nombre := range []string{"RUTSENDER", "RUTCOMPANY", "FILE", "TIMESTAMP", "STATUS", "TRACKID"} {
+		if valor, ok := campos[nombre]; ok {
+			recepcion.CreateElement(nombre).SetText(valor)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	io.WriteString(w, serializar(doc))
+}
+
+// textoElemento retorna el texto del elemento en la ruta relativa, o "" si no existe
+func textoElemento(padre *etree.Element, ruta string) string {
+	if elemento := padre.FindElement(ruta); elemento != nil {
+		return strings.TrimSpace(elemento.Text())
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+package simulador
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/utils"
+)
+
+// Servicio identifica un servicio simulado, para programar fallas sólo en ese servicio
+type Servicio string
+
+// Servicios del SII que implementa el simulador
+const (
+	ServicioSemilla           Servicio = "CrSeed"
+	ServicioToken             Servicio = "GetTokenFromSeed"
+	ServicioUpload            Servicio = "DTEUpload"
+	ServicioUploadAEC         Servicio = "RTCAnotEnvio"
+	ServicioEstadoUpload      Servicio = "QueryEstUp"
+	ServicioEstadoDTE         Servicio = "QueryEstDte"
+	ServicioBoletaSemilla     Servicio = "boleta.electronica.semilla"
+	ServicioBoletaToken       Servicio = "boleta.electronica.token"
+	ServicioBoletaEnvio       Servicio = "boleta.electronica.envio"
+	ServicioBoletaEstadoEnvio Servicio = "boleta.electronica.envio.estado"
+	ServicioBoletaEstado      Servicio = "boleta.electronica.estado"
+)
+
+// Escenario define cómo responde el simulador a las solicitudes que alcanza una regla (por
+// servicio, por RUT de la empresa o por folio). El valor cero acepta todo.
+type Escenario struct {
+	// StatusUpload distinto de "0" hace que DTEUpload rechace el archivo con ese STATUS, sin
+	// asignar TrackID
+	StatusUpload string
+	// EstadoEnvio rechaza el envío completo con ese estado (RSC, RFR, RCT...) en QueryEstUp
+	EstadoEnvio string
+	// Rechazar rechaza los documentos alcanzados; no quedan registrados en el SII
+	Rechazar bool
+	// Reparos acepta los documentos alcanzados con reparos
+	Reparos bool
+	// EstadoDTE fija la respuesta de QueryEstDte y de la consulta de boletas (FAN, FNA, ANC...)
+	EstadoDTE string
+	// EnProceso es el número de consultas en que el envío sigue en proceso antes de informar su
+	// estado final
+	EnProceso int
+	// Demora es la espera antes de responder, para provocar timeouts del cliente
+	Demora time.Duration
+	// CodigoHTTP distinto de cero responde con ese código (por ejemplo 503) sin procesar
+	CodigoHTTP int
+	// Veces limita la regla a ese número de solicitudes; cero la aplica siempre
+	Veces int
+}
+
+// Aceptar retorna el escenario que acepta los envíos y documentos
+func Aceptar() Escenario {
+	return Escenario{}
+}
+
+// RechazarUpload rechaza el archivo en DTEUpload con el STATUS indicado
+func RechazarUpload(status string) Escenario {
+	return Escenario{StatusUpload: status}
+}
+
+// RechazarEnvio recibe el archivo pero rechaza el envío con el estado indicado
+func RechazarEnvio(estado string) Escenario {
+	return Escenario{EstadoEnvio: estado}
+}
+
+// RechazarDocumentos rechaza los documentos alcanzados por la regla
+func RechazarDocumentos() Escenario {
+	return Escenario{Rechazar: true}
+}
+
+// AceptarConReparos acepta los documentos alcanzados con reparos
+func AceptarConReparos() Escenario {
+	return Escenario{Reparos: true}
+}
+
+// ConEstadoDTE fija el estado que informa la consulta de los documentos alcanzados
+func ConEstadoDTE(estado string) Escenario {
+	return Escenario{EstadoDTE: estado}
+}
+
+// Demorar responde después de la espera indicada
+func Demorar(espera time.Duration) Escenario {
+	return Escenario{Demora: espera}
+}
+
+// FallarHTTP responde con el código HTTP indicado
+func FallarHTTP(codigo int) Escenario {
+	return Escenario{CodigoHTTP: codigo}
+}
+
+// PorVeces limita el escenario a las primeras n solicitudes que alcanza
+func (e Escenario) PorVeces(n int) Escenario {
+	e.Veces = n
+	return e
+}
+
+// regla es un escenario registrado con las solicitudes que le quedan por aplicar
+type regla struct {
+	escenario Escenario
+	restantes int
+}
+
+// Claves de las reglas por servicio, por RUT y por folio
+func claveServicio(servicio Servicio) string { return "servicio:" + string(servicio) }
+func claveRUT(rut string) string             { return "rut:" + utils.NormalizarRUT(rut) }
+func claveFolio(rut string, tipo int, folio int64) string {
+	return fmt.Sprintf("folio:%s:%d:%d", utils.NormalizarRUT(rut), tipo, folio)
+}
+
+// ParaServicio aplica el escenario a todas las solicitudes del servicio indicado
+func (s *Simulador) ParaServicio(servicio Servicio, escenario Escenario) {
+	s.registrar(claveServicio(servicio), escenario)
+}
+
+// ParaRUT aplica el escenario a los envíos y consultas de la empresa indicada
+func (s *Simulador) ParaRUT(rut string, escenario Escenario) {
+	s.registrar(claveRUT(rut), escenario)
+}
+
+// ParaFolio aplica el escenario al documento indicado, en los envíos que lo incluyen y en sus
+// consultas de estado
+func (s *Simulador) ParaFolio(rutEmisor string, tipo int, folio int64, escenario Escenario) {
+	s.registrar(claveFolio(rutEmisor, tipo, folio), escenario)
+}
+
+// LimpiarEscenarios elimina todas las reglas registradas
+func (s *Simulador) LimpiarEscenarios() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reglas = make(map[string]*regla)
+}
+
+func (s *Simulador) registrar(clave string, escenario Escenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reglas[clave] = &regla{escenario: escenario, restantes: escenario.Veces}
+}
+
+// Reglas relevantes en cada etapa: una regla sólo se descuenta en las solicitudes donde tiene
+// efecto, para que un rechazo programado no se consuma en una consulta de estado
+func paraRecepcion(e Escenario) bool {
+	return e.StatusUpload != "" || e.EstadoEnvio != "" || e.Rechazar || e.Reparos || e.EnProceso > 0 || tieneFalla(e)
+}
+
+func paraDocumentoRecibido(e Escenario) bool {
+	return e.Rechazar || e.Reparos
+}
+
+func paraConsultaEnvio(e Escenario) bool {
+	return tieneFalla(e)
+}
+
+func paraConsultaDocumento(e Escenario) bool {
+	return e.EstadoDTE != "" || tieneFalla(e)
+}
+
+func tieneFalla(e Escenario) bool {
+	return e.Demora > 0 || e.CodigoHTTP != 0
+}
+
+// aplicar retorna el escenario de la primera clave con una regla relevante y descuenta una
+// solicitud; las reglas agotadas se eliminan. Debe llamarse con el mutex tomado.
+func (s *Simulador) aplicar(relevante func(Escenario) bool, claves ...string) (Escenario, bool) {
+	for _, clave := range claves {
+		r, ok := s.reglas[clave]
+		if !ok || !relevante(r.escenario) {
+			continue
+		}
+		if r.escenario.Veces > 0 {
+			r.restantes--
+			if r.restantes <= 0 {
+				delete(s.reglas, clave)
+			}
+		}
+		return r.escenario, true
+	}
+	return Escenario{}, false
+}
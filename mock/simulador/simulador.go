@@ -0,0 +1,388 @@
+// Package simulador implementa en memoria los servicios del SII que usan los clientes del
+// proyecto: autenticación por semilla (CrSeed y GetTokenFromSeed), carga de archivos
+// (DTEUpload y cesiones), consulta de estado de envíos (QueryEstUp) y de documentos
+// (QueryEstDte) y los servicios REST de boleta electrónica. Se monta sobre un servidor
+// httptest para probar los clientes sin acceso a la red, y permite programar rechazos, reparos,
+// demoras y errores HTTP por servicio, por empresa o por folio.
+package simulador
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/services/ambiente"
+)
+
+// Estados de un envío informados por QueryEstUp y por la consulta de envíos de boletas
+const (
+	EstadoEnvioRecibido          = "REC"
+	EstadoEnvioSchemaOK          = "SOK"
+	EstadoEnvioFirmaOK           = "FOK"
+	EstadoEnvioEnProceso         = "PDR"
+	EstadoEnvioCaratulaOK        = "CRT"
+	EstadoEnvioProcesado         = "EPR"
+	EstadoEnvioReparos           = "RPR"
+	EstadoEnvioRechazado         = "RCH"
+	EstadoEnvioRechazoSchema     = "RSC"
+	EstadoEnvioRechazoFirma      = "RFR"
+	EstadoEnvioRechazoCaratula   = "RCT"
+	EstadoEnvioRechazoConsumo    = "RCO"
+	EstadoConsultaTokenInactivo  = "002"
+	EstadoConsultaTokenNoExiste  = "003"
+	EstadoConsultaTrackNoExiste  = "-11"
+	EstadoConsultaDatosInvalidos = "-3"
+)
+
+// Estados de un documento informados por QueryEstDte y por la consulta de boletas
+const (
+	EstadoDTEDatosOK      = "DOK"
+	EstadoDTEDatosNoOK    = "DNK"
+	EstadoDTENoRecibido   = "FAU"
+	EstadoDTENoAutorizado = "FNA"
+	EstadoDTEAnulado      = "FAN"
+	EstadoDTEEmpresaNoAut = "EMP"
+	EstadoDTENDModTexto   = "TMD"
+	EstadoDTENCModTexto   = "TMC"
+	EstadoDTENDModMontos  = "MMD"
+	EstadoDTENCModMontos  = "MMC"
+	EstadoDTENDAnula      = "AND"
+	EstadoDTENCAnula      = "ANC"
+)
+
+// Resultado de cada documento dentro de un envío
+const (
+	DocumentoAceptado  = "ACEPTADO"
+	DocumentoReparos   = "REPAROS"
+	DocumentoRechazado = "RECHAZADO"
+)
+
+// glosasEstado describe los estados de envíos y documentos
+var glosasEstado = map[string]string{
+	EstadoEnvioRecibido:          "Envío Recibido",
+	EstadoEnvioSchemaOK:          "Schema Validado",
+	EstadoEnvioFirmaOK:           "Firma de Envío Validada",
+	EstadoEnvioEnProceso:         "Envío en Proceso",
+	EstadoEnvioCaratulaOK:        "Carátula OK",
+	EstadoEnvioProcesado:         "Envío Procesado",
+	EstadoEnvioReparos:           "Envío Aceptado con Reparos",
+	EstadoEnvioRechazado:         "Envío Rechazado",
+	EstadoEnvioRechazoSchema:     "Rechazado por Error en Schema",
+	EstadoEnvioRechazoFirma:      "Rechazado por Error en Firma",
+	EstadoEnvioRechazoCaratula:   "Rechazado por Error en Carátula",
+	EstadoEnvioRechazoConsumo:    "Rechazado por Error en Consumo de Folios",
+	EstadoConsultaTokenInactivo:  "Token Inactivo",
+	EstadoConsultaTokenNoExiste:  "Token No Existe",
+	EstadoConsultaTrackNoExiste:  "TrackID No Existe",
+	EstadoConsultaDatosInvalidos: "Datos de la Consulta Inválidos",
+	EstadoDTEDatosOK:             "Documento Recibido por el SII. Datos Coinciden con los Registrados",
+	EstadoDTEDatosNoOK:           "Documento Recibido por el SII pero Datos NO Coinciden con los registrados",
+	EstadoDTENoRecibido:          "Documento No Recibido por el SII",
+	EstadoDTENoAutorizado:        "Documento No Autorizado",
+	EstadoDTEAnulado:             "Documento Anulado",
+	EstadoDTEEmpresaNoAut:        "Empresa no autorizada a Emitir Documentos Tributarios Electrónicos",
+	EstadoDTENDModTexto:          "Existe Nota de Débito que Modifica Texto Documento",
+	EstadoDTENCModTexto:          "Existe Nota de Crédito que Modifica Textos Documento",
+	EstadoDTENDModMontos:         "Existe Nota de Débito que Modifica Montos Documento",
+	EstadoDTENCModMontos:         "Existe Nota de Crédito que Modifica Montos Documento",
+	EstadoDTENDAnula:             "Existe Nota de Débito que Anula Documento",
+	EstadoDTENCAnula:             "Existe Nota de Crédito que Anula Documento",
+}
+
+// GlosaEstado retorna la descripción de un estado de envío o de documento
+func GlosaEstado(estado string) string {
+	if glosa, ok := glosasEstado[estado]; ok {
+		return glosa
+	}
+	return "Estado " + estado
+}
+
+// estadosEnProceso son los estados que recorre un envío antes de su estado final
+var estadosEnProceso = []string{EstadoEnvioRecibido, EstadoEnvioSchemaOK, EstadoEnvioFirmaOK, EstadoEnvioEnProceso, EstadoEnvioCaratulaOK}
+
+// Envio es un archivo recibido por el simulador
+type Envio struct {
+	TrackID    string
+	Servicio   Servicio
+	RutEmisor  string
+	RutEnvia   string
+	Archivo    string
+	Recibido   time.Time
+	Documentos []Documento
+	Estado     string // Estado final del envío
+	Consultas  int
+	enProceso  int
+}
+
+// Documento es un documento informado en un envío
+type Documento struct {
+	TipoDTE      int
+	Folio        int64
+	RutEmisor    string
+	RutReceptor  string
+	FechaEmision string // AAAA-MM-DD
+	MontoTotal   int64
+	Resultado    string // DocumentoAceptado, DocumentoReparos o DocumentoRechazado
+}
+
+// Simulador atiende los servicios del SII en memoria. Implementa http.Handler.
+type Simulador struct {
+	mu          sync.Mutex
+	mux         *http.ServeMux
+	ahora       func() time.Time
+	reglas      map[string]*regla
+	semillas    map[string]bool
+	tokens      map[string]*tokenEmitido
+	envios      map[string]*Envio
+	documentos  map[string]*Documento
+	solicitudes map[Servicio]int
+	enProceso   int
+	secuencia   int64
+	trackID     int64
+}
+
+// tokenEmitido es un token emitido por el simulador y el ámbito de servicios en que es válido
+type tokenEmitido struct {
+	boleta  bool
+	vigente bool
+}
+
+// New crea un simulador sin envíos, que acepta todos los archivos
+func New() *Simulador {
+	s := &Simulador{
+		mux:         http.NewServeMux(),
+		ahora:       time.Now,
+		reglas:      make(map[string]*regla),
+		semillas:    make(map[string]bool),
+		tokens:      make(map[string]*tokenEmitido),
+		envios:      make(map[string]*Envio),
+		documentos:  make(map[string]*Documento),
+		solicitudes: make(map[Servicio]int),
+		trackID:     4000000000,
+	}
+
+	s.mux.HandleFunc("/DTEWS/CrSeed.jws", s.crSeed)
+	s.mux.HandleFunc("/DTEWS/GetTokenFromSeed.jws", s.getToken)
+	s.mux.HandleFunc("/cgi_dte/UPL/DTEUpload", s.upload(ServicioUpload, "RECEPCIONDTE"))
+	s.mux.HandleFunc("/cgi_rtc/RTC/RTCAnotEnvio.cgi", s.upload(ServicioUploadAEC, "RECEPCIONAEC"))
+	s.mux.HandleFunc("/DTEWS/QueryEstUp.jws", s.queryEstUp)
+	s.mux.HandleFunc("/DTEWS/QueryEstDte.jws", s.queryEstDte)
+	s.mux.HandleFunc("GET /recursos/v1/boleta.electronica.semilla", s.boletaSemilla)
+	s.mux.HandleFunc("POST /recursos/v1/boleta.electronica.token", s.boletaToken)
+	s.mux.HandleFunc("POST /recursos/v1/boleta.electronica.envio", s.boletaEnvio)
+	s.mux.HandleFunc("GET /recursos/v1/boleta.electronica.envio/{id}", s.boletaEstadoEnvio)
+	s.mux.HandleFunc("GET /recursos/v1/boleta.electronica/{id}/estado", s.boletaEstado)
+	return s
+}
+
+// ServeHTTP atiende la solicitud con el servicio simulado que corresponde a la ruta
+func (s *Simulador) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Iniciar levanta el simulador en un servidor httptest; el llamador debe cerrarlo
+func (s *Simulador) Iniciar() (*httptest.Server, URLs) {
+	servidor := httptest.NewServer(s)
+	return servidor, NewURLs(servidor.URL)
+}
+
+// URLs son las direcciones de los servicios simulados, equivalentes a las de maullin y palena
+type URLs struct {
+	Semilla      string
+	Token        string
+	UploadDTE    string
+	UploadAEC    string
+	EstadoUpload string
+	EstadoDTE    string
+	Boleta       string // Base de los servicios REST de boleta (API y envío)
+}
+
+// NewURLs retorna las direcciones de los servicios de un simulador atendido en base
+func NewURLs(base string) URLs {
+	base = strings.TrimSuffix(base, "/")
+	return URLs{
+		Semilla:      base + "/DTEWS/CrSeed.jws",
+		Token:        base + "/DTEWS/GetTokenFromSeed.jws",
+		UploadDTE:    base + "/cgi_dte/UPL/DTEUpload",
+		UploadAEC:    base + "/cgi_rtc/RTC/RTCAnotEnvio.cgi",
+		EstadoUpload: base + "/DTEWS/QueryEstUp.jws",
+		EstadoDTE:    base + "/DTEWS/QueryEstDte.jws",
+		Boleta:       base + "/recursos/v1",
+	}
+}
+
+// Endpoints retorna las URLs del simulador como un ambiente del registro, para apuntar a él
+// certificación o producción con ambiente.Registro.SetEndpoints
+func (u URLs) Endpoints() ambiente.Endpoints {
+	return ambiente.Endpoints{
+		Semilla:      u.Semilla,
+		Token:        u.Token,
+		UploadDTE:    u.UploadDTE,
+		EstadoEnvio:  u.EstadoUpload,
+		EstadoDTE:    u.EstadoDTE,
+		BoletaAPI:    u.Boleta,
+		BoletaEnvio:  u.Boleta,
+		UploadCesion: u.UploadAEC,
+	}
+}
+
+// SetReloj reemplaza el reloj con que se fechan las recepciones
+func (s *Simulador) SetReloj(ahora func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ahora = ahora
+}
+
+// ConsultasEnProceso fija cuántas consultas de estado informan un envío en proceso antes de su
+// estado final, para los envíos cuyo escenario no indica otro valor
+func (s *Simulador) ConsultasEnProceso(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enProceso = n
+}
+
+// EmitirToken emite un token vigente para los servicios DTE sin pasar por la semilla
+func (s *Simulador) EmitirToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nuevoToken(false)
+}
+
+// EmitirTokenBoleta emite un token vigente para los servicios REST de boleta
+func (s *Simulador) EmitirTokenBoleta() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nuevoToken(true)
+}
+
+// ExpirarTokens deja inactivos todos los tokens emitidos, como ocurre al vencer la sesión
+func (s *Simulador) ExpirarTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		t.vigente = false
+	}
+}
+
+// RegistrarDocumento registra un documento como recibido por el SII, para consultar su
+// estado sin enviarlo
+func (s *Simulador) RegistrarDocumento(doc Documento) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if doc.Resultado == "" {
+		doc.Resultado = DocumentoAceptado
+	}
+	s.documentos[claveFolio(doc.RutEmisor, doc.TipoDTE, doc.Folio)] = &doc
+}
+
+// Envio retorna una copia del envío con el TrackID indicado
+func (s *Simulador) Envio(trackID string) (Envio, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	envio, ok := s.envios[trackID]
+	if !ok {
+		return Envio{}, false
+	}
+	copia := *envio
+	copia.Documentos = append([]Documento(nil), envio.Documentos...)
+	return copia, true
+}
+
+// Solicitudes retorna el número de solicitudes recibidas por el servicio
+func (s *Simulador) Solicitudes(servicio Servicio) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.solicitudes[servicio]
+}
+
+// entrada cuenta la solicitud y aplica la regla del servicio. Retorna false si la solicitud
+// ya fue respondida por una falla programada.
+func (s *Simulador) entrada(w http.ResponseWriter, r *http.Request, servicio Servicio, relevante func(Escenario) bool) (Escenario, bool) {
+	s.mu.Lock()
+	s.solicitudes[servicio]++
+	escenario, _ := s.aplicar(relevante, claveServicio(servicio))
+	s.mu.Unlock()
+	return escenario, !s.fallar(w, r, escenario)
+}
+
+// fallar aplica la demora y el error HTTP del escenario. Retorna true si la solicitud quedó
+// respondida o el cliente la abandonó.
+func (s *Simulador) fallar(w http.ResponseWriter, r *http.Request, escenario Escenario) bool {
+	if escenario.Demora > 0 {
+		select {
+		case <-time.After(escenario.Demora):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if escenario.CodigoHTTP != 0 {
+		http.Error(w, http.StatusText(escenario.CodigoHTTP), escenario.CodigoHTTP)
+		return true
+	}
+	return false
+}
+
+// validarToken indica si el token fue emitido para el ámbito y sigue vigente; si no, retorna el
+// estado de consulta que corresponde
+func (s *Simulador) validarToken(valor string, boleta bool) (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[valor]
+	switch {
+	case !ok || t.boleta != boleta:
+		return false, EstadoConsultaTokenNoExiste
+	case !t.vigente:
+		return false, EstadoConsultaTokenInactivo
+	default:
+		return true, ""
+	}
+}
+
+// nuevaSemilla emite una semilla de 12 dígitos. Debe llamarse con el mutex tomado.
+func (s *Simulador) nuevaSemilla() string {
+	s.secuencia++
+	semilla := fmt.Sprintf("%012d", 100000000000+s.secuencia)
+	s.semillas[semilla] = true
+	return semilla
+}
+
+// nuevoToken emite un token de 13 caracteres. Debe llamarse con el mutex tomado.
+func (s *Simulador) nuevoToken(boleta bool) string {
+	s.secuencia++
+	valor := fmt.Sprintf("TKN%010d", s.secuencia)
+	s.tokens[valor] = &tokenEmitido{boleta: boleta, vigente: true}
+	return valor
+}
+
+// canjearSemilla lee la semilla de la solicitud de token y, si fue emitida por el simulador y no
+// se ha usado, emite el token. La firma de la solicitud no se verifica.
+func (s *Simulador) canjearSemilla(solicitud string, boleta bool) (string, string, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(strings.TrimSpace(solicitud)); err != nil {
+		return "", "-07", fmt.Errorf("solicitud de token ilegible: %v", err)
+	}
+	elemento := doc.FindElement("//Semilla")
+	if elemento == nil {
+		return "", "-07", fmt.Errorf("la solicitud no contiene la semilla")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	semilla := strings.TrimSpace(elemento.Text())
+	if !s.semillas[semilla] {
+		return "", "10", fmt.Errorf("semilla no existe o ya fue utilizada")
+	}
+	delete(s.semillas, semilla)
+	return s.nuevoToken(boleta), "00", nil
+}
+
+// numeroAtencion retorna el número de atención con que el SII identifica cada respuesta.
+// Debe llamarse con el mutex tomado.
+func (s *Simulador) numeroAtencion() string {
+	s.secuencia++
+	return fmt.Sprintf("%d ( %s)", s.secuencia, s.ahora().Format("2006/01/02 15:04:05"))
+}
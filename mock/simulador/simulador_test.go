@@ -0,0 +1,302 @@
+package simulador
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/boleta"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rutEmpresa = "76212889-6"
+
+// firmantePrueba crea un firmante con un certificado autofirmado
+func firmantePrueba(t *testing.T) *xmldsig.Firmante {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Usuario Simulador"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return xmldsig.NewFirmante(llave, cert)
+}
+
+// envioFirmado arma y firma un EnvioDTE (o EnvioBOLETA) con un documento por folio
+func envioFirmado(t *testing.T, raiz string, tipo int, folios ...int64) []byte {
+	t.Helper()
+	var dtes strings.Builder
+	for _, folio := range folios {
+		fmt.Fprintf(&dtes, `<DTE version="1.0"><Documento ID="T%dF%d"><Encabezado>`+
+			`<IdDoc><TipoDTE>%d</TipoDTE><Folio>%d</Folio><FchEmis>2024-06-03</FchEmis></IdDoc>`+
+			`<Emisor><RUTEmisor>%s</RUTEmisor></Emisor><Receptor><RUTRecep>77777777-7</RUTRecep></Receptor>`+
+			`<Totales><MntTotal>%d</MntTotal></Totales></Encabezado></Documento></DTE>`,
+			tipo, folio, tipo, folio, rutEmpresa, folio*1000)
+	}
+	envio := fmt.Sprintf(`<?xml version="1.0" encoding="ISO-8859-1"?><%s xmlns="http://www.sii.cl/SiiDte" version="1.0">`+
+		`<SetDTE ID="SetDoc"><Caratula version="1.0"><RutEmisor>%s</RutEmisor><RutEnvia>11111111-1</RutEnvia></Caratula>%s</SetDTE></%s>`,
+		raiz, rutEmpresa, dtes.String(), raiz)
+
+	firmado, err := firmantePrueba(t).Firmar([]byte(envio))
+	require.NoError(t, err)
+	return firmado
+}
+
+// subir envía el archivo a DTEUpload como sii.Uploader y retorna la respuesta RECEPCIONDTE
+func subir(t *testing.T, url, tokenSII string, archivo []byte) models.RespuestaUploadSII {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for nombre, valor := range map[string]string{"rutSender": "11111111", "dvSender": "1", "rutCompany": "76212889", "dvCompany": "6"} {
+		require.NoError(t, writer.WriteField(nombre, valor))
+	}
+	parte, err := writer.CreateFormFile("archivo", "EnvioDTE.xml")
+	require.NoError(t, err)
+	parte.Write(archivo)
+	require.NoError(t, writer.Close())
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if tokenSII != "" {
+		req.Header.Set("Cookie", "TOKEN="+tokenSII)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var respuesta models.RespuestaUploadSII
+	require.NoError(t, xml.NewDecoder(resp.Body).Decode(&respuesta))
+	return respuesta
+}
+
+// invocarSOAP llama un servicio DTEWS y retorna la SII:RESPUESTA desescapada
+func invocarSOAP(t *testing.T, url, metodo string, parametros map[string]string) string {
+	t.Helper()
+	var campos strings.Builder
+	for nombre, valor := range parametros {
+		fmt.Fprintf(&campos, "<%s>%s</%s>", nombre, valor, nombre)
+	}
+	sobre := `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><` +
+		metodo + `>` + campos.String() + `</` + metodo + `></soapenv:Body></soapenv:Envelope>`
+
+	resp, err := http.Post(url, "text/xml", strings.NewReader(sobre))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	retorno := regexp.MustCompile(`<` + metodo + `Return[^>]*>(.*)</` + metodo + `Return>`).FindStringSubmatch(string(body))
+	require.Len(t, retorno, 2, string(body))
+	return html.UnescapeString(retorno[1])
+}
+
+// campo extrae el texto del primer elemento con el nombre indicado
+func campo(respuesta, nombre string) string {
+	if m := regexp.MustCompile(`<` + nombre + `>([^<]*)</` + nombre + `>`).FindStringSubmatch(respuesta); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func consultarEstUp(t *testing.T, urls URLs, tokenSII, trackID string) string {
+	return invocarSOAP(t, urls.EstadoUpload, "getEstUp", map[string]string{
+		"RutCompania": "76212889", "DvCompania": "6", "TrackId": trackID, "Token": tokenSII,
+	})
+}
+
+func consultarEstDte(t *testing.T, urls URLs, tokenSII string, folio, monto int64) string {
+	return campo(invocarSOAP(t, urls.EstadoDTE, "getEstDte", map[string]string{
+		"RutConsultante": "76212889", "DvConsultante": "6",
+		"RutCompania": "76212889", "DvCompania": "6",
+		"RutReceptor": "77777777", "DvReceptor": "7",
+		"TipoDte": "33", "FolioDte": fmt.Sprint(folio),
+		"FechaEmisionDte": "03062024", "MontoDte": fmt.Sprint(monto),
+		"Token": tokenSII,
+	}), "ESTADO")
+}
+
+func TestAutenticacionSOAP(t *testing.T) {
+	sim := New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+
+	autenticador := token.NewAutenticador(urls.Semilla, urls.Token, 5*time.Second)
+	tokenSII, err := autenticador.SolicitarToken(context.Background(), firmantePrueba(t))
+	require.NoError(t, err)
+	assert.Len(t, tokenSII, 13)
+
+	// El token sirve para subir archivos; uno inventado no
+	assert.Equal(t, "0", subir(t, urls.UploadDTE, tokenSII, envioFirmado(t, "EnvioDTE", 33, 1)).Status)
+	assert.Equal(t, "5", subir(t, urls.UploadDTE, "INVENTADO", envioFirmado(t, "EnvioDTE", 33, 2)).Status)
+	assert.Equal(t, 1, sim.Solicitudes(ServicioSemilla))
+}
+
+func TestUploadYConsultas(t *testing.T) {
+	sim := New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+	tokenSII := sim.EmitirToken()
+
+	sim.ConsultasEnProceso(1)
+	sim.ParaFolio(rutEmpresa, 33, 2, RechazarDocumentos())
+	sim.ParaFolio(rutEmpresa, 33, 3, AceptarConReparos())
+
+	recepcion := subir(t, urls.UploadDTE, tokenSII, envioFirmado(t, "EnvioDTE", 33, 1, 2, 3))
+	require.Equal(t, "0", recepcion.Status)
+	require.NotEmpty(t, recepcion.TrackID)
+	assert.Equal(t, "76212889-6", recepcion.RutCompany)
+
+	enProceso := consultarEstUp(t, urls, tokenSII, recepcion.TrackID)
+	assert.Equal(t, EstadoEnvioRecibido, campo(enProceso, "ESTADO"))
+
+	procesado := consultarEstUp(t, urls, tokenSII, recepcion.TrackID)
+	assert.Equal(t, EstadoEnvioProcesado, campo(procesado, "ESTADO"))
+	assert.Equal(t, "33", campo(procesado, "TIPO_DOCTO"))
+	assert.Equal(t, "3", campo(procesado, "INFORMADOS"))
+	assert.Equal(t, "2", campo(procesado, "ACEPTADOS"))
+	assert.Equal(t, "1", campo(procesado, "RECHAZADOS"))
+	assert.Equal(t, "1", campo(procesado, "REPAROS"))
+	assert.NotEmpty(t, campo(procesado, "NUM_ATENCION"))
+
+	assert.Equal(t, EstadoDTEDatosOK, consultarEstDte(t, urls, tokenSII, 1, 1000))
+	assert.Equal(t, EstadoDTEDatosNoOK, consultarEstDte(t, urls, tokenSII, 1, 999))
+	assert.Equal(t, EstadoDTENoRecibido, consultarEstDte(t, urls, tokenSII, 2, 2000))
+	assert.Equal(t, EstadoDTEDatosOK, consultarEstDte(t, urls, tokenSII, 3, 3000))
+
+	// Un estado programado se informa sólo en la consulta siguiente
+	sim.ParaFolio(rutEmpresa, 33, 1, ConEstadoDTE(EstadoDTEAnulado).PorVeces(1))
+	assert.Equal(t, EstadoDTEAnulado, consultarEstDte(t, urls, tokenSII, 1, 1000))
+	assert.Equal(t, EstadoDTEDatosOK, consultarEstDte(t, urls, tokenSII, 1, 1000))
+
+	assert.Equal(t, EstadoConsultaTrackNoExiste, campo(consultarEstUp(t, urls, tokenSII, "999"), "ESTADO"))
+	sim.ExpirarTokens()
+	assert.Equal(t, EstadoConsultaTokenInactivo, campo(consultarEstUp(t, urls, tokenSII, recepcion.TrackID), "ESTADO"))
+}
+
+func TestUploadRechazos(t *testing.T) {
+	sim := New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+	tokenSII := sim.EmitirToken()
+
+	// Firma que no verifica
+	alterado := bytes.Replace(envioFirmado(t, "EnvioDTE", 33, 1), []byte("<MntTotal>1000<"), []byte("<MntTotal>1<"), 1)
+	recepcion := subir(t, urls.UploadDTE, tokenSII, alterado)
+	require.Equal(t, "0", recepcion.Status)
+	assert.Equal(t, EstadoEnvioRechazoFirma, campo(consultarEstUp(t, urls, tokenSII, recepcion.TrackID), "ESTADO"))
+
+	recepcion = subir(t, urls.UploadDTE, tokenSII, []byte("<EnvioDTE><SetDTE>"))
+	assert.Equal(t, EstadoEnvioRechazoSchema, campo(consultarEstUp(t, urls, tokenSII, recepcion.TrackID), "ESTADO"))
+
+	// Rechazo en la carga sólo para el primer envío de la empresa
+	sim.ParaRUT(rutEmpresa, RechazarUpload("7").PorVeces(1))
+	recepcion = subir(t, urls.UploadDTE, tokenSII, envioFirmado(t, "EnvioDTE", 33, 1))
+	assert.Equal(t, "7", recepcion.Status)
+	assert.Empty(t, recepcion.TrackID)
+	assert.Equal(t, "0", subir(t, urls.UploadDTE, tokenSII, envioFirmado(t, "EnvioDTE", 33, 1)).Status)
+
+	sim.ParaRUT(rutEmpresa, RechazarEnvio(EstadoEnvioRechazoCaratula))
+	recepcion = subir(t, urls.UploadDTE, tokenSII, envioFirmado(t, "EnvioDTE", 33, 4))
+	assert.Equal(t, EstadoEnvioRechazoCaratula, campo(consultarEstUp(t, urls, tokenSII, recepcion.TrackID), "ESTADO"))
+	envio, ok := sim.Envio(recepcion.TrackID)
+	require.True(t, ok)
+	assert.Equal(t, "EnvioDTE.xml", envio.Archivo)
+}
+
+func TestFallasHTTP(t *testing.T) {
+	sim := New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+
+	sim.ParaServicio(ServicioSemilla, FallarHTTP(http.StatusServiceUnavailable).PorVeces(1))
+	autenticador := token.NewAutenticador(urls.Semilla, urls.Token, 5*time.Second)
+	_, err := autenticador.ObtenerSemilla(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	_, err = autenticador.ObtenerSemilla(context.Background())
+	require.NoError(t, err)
+
+	// La demora supera el timeout del cliente
+	sim.ParaServicio(ServicioSemilla, Demorar(500*time.Millisecond))
+	lento := token.NewAutenticador(urls.Semilla, urls.Token, 50*time.Millisecond)
+	_, err = lento.ObtenerSemilla(context.Background())
+	require.Error(t, err)
+
+	sim.LimpiarEscenarios()
+	_, err = lento.ObtenerSemilla(context.Background())
+	require.NoError(t, err)
+}
+
+// tokensSimulador entrega el token de boleta del simulador
+type tokensSimulador struct {
+	token string
+}
+
+func (t tokensSimulador) ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error {
+	return operacion(t.token)
+}
+
+func TestBoleta(t *testing.T) {
+	sim := New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+
+	autenticador := token.NewAutenticadorREST(urls.Boleta+"/boleta.electronica.semilla", urls.Boleta+"/boleta.electronica.token", 5*time.Second)
+	tokenBoleta, err := autenticador.SolicitarToken(context.Background(), firmantePrueba(t))
+	require.NoError(t, err)
+
+	endpoints := boleta.Endpoints{API: urls.Boleta, Envio: urls.Boleta}
+	cliente := boleta.NewCliente(endpoints, tokensSimulador{token: tokenBoleta}, 5*time.Second)
+
+	sim.ParaFolio(rutEmpresa, 39, 11, AceptarConReparos())
+	respuesta, err := cliente.Enviar(context.Background(), "11111111-1", rutEmpresa, "EnvioBOLETA.xml", envioFirmado(t, "EnvioBOLETA", 39, 10, 11))
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoEnvioBoletaRecibido, respuesta.Estado)
+
+	estado, err := cliente.ConsultarEnvio(context.Background(), rutEmpresa, fmt.Sprint(respuesta.TrackID))
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoEnvioBoletaReparos, estado.Estado)
+	require.Len(t, estado.Estadisticas, 1)
+	assert.Equal(t, models.EstadisticaEnvioBoleta{Tipo: 39, Informados: 2, Aceptados: 2, Reparos: 1}, estado.Estadisticas[0])
+	require.Len(t, estado.Detalles, 1)
+	assert.Equal(t, int64(11), estado.Detalles[0].Folio)
+
+	documento, err := cliente.ConsultarBoleta(context.Background(), models.ConsultaEstadoBoleta{
+		RutEmisor: rutEmpresa, Tipo: 39, Folio: 10, RutReceptor: "77777777-7", Monto: 10000, FechaEmision: "03-06-2024",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, EstadoDTEDatosOK, documento.Codigo)
+
+	// Los tokens de los servicios SOAP no sirven para los servicios de boleta
+	conTokenDTE := boleta.NewCliente(endpoints, tokensSimulador{token: sim.EmitirToken()}, 5*time.Second)
+	_, err = conTokenDTE.ConsultarEnvio(context.Background(), rutEmpresa, fmt.Sprint(respuesta.TrackID))
+	require.ErrorIs(t, err, token.ErrTokenRechazado)
+}
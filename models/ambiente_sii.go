@@ -0,0 +1,32 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AmbienteSII es el ambiente del SII en que opera una empresa
+type AmbienteSII string
+
+// Ambientes del SII
+const (
+	AmbienteCertificacion AmbienteSII = "certificacion" // maullin, apicert y pangal
+	AmbienteProduccion    AmbienteSII = "produccion"    // palena, api y rahue
+)
+
+// ParseAmbienteSII interpreta el nombre de un ambiente. Acepta también los nombres de los
+// servidores (maullin, palena) y los usados en la configuración (production, cert)
+func ParseAmbienteSII(valor string) (AmbienteSII, error) {
+	switch strings.ToLower(strings.TrimSpace(valor)) {
+	case "certificacion", "certificación", "cert", "maullin":
+		return AmbienteCertificacion, nil
+	case "produccion", "producción", "production", "prod", "palena":
+		return AmbienteProduccion, nil
+	}
+	return "", fmt.Errorf("ambiente SII desconocido: %q", valor)
+}
+
+// Valido indica si el ambiente es certificación o producción
+func (a AmbienteSII) Valido() bool {
+	return a == AmbienteCertificacion || a == AmbienteProduccion
+}
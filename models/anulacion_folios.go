@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// MotivoAnulacion indica por qué se solicita anular un folio
+type MotivoAnulacion string
+
+// Motivos de anulación de folios
+const (
+	MotivoFolioSaltado      MotivoAnulacion = "FOLIO_SALTADO"       // Entregado por el asignador sin documento emitido
+	MotivoAnuladoAntesEnvio MotivoAnulacion = "ANULADO_ANTES_ENVIO" // Documento anulado antes de enviarse al SII
+	MotivoCAFVencido        MotivoAnulacion = "CAF_VENCIDO"         // Folio sin usar de un CAF vencido
+	MotivoFolioInutilizado  MotivoAnulacion = "FOLIO_INUTILIZADO"   // Marcado para anular, por ejemplo papel dañado
+)
+
+// EstadoSolicitudAnulacion es el estado de una solicitud de anulación de folios
+type EstadoSolicitudAnulacion string
+
+// Estados de una solicitud de anulación
+const (
+	EstadoAnulacionPendiente  EstadoSolicitudAnulacion = "PENDIENTE"  // Generada, por presentar al SII
+	EstadoAnulacionPresentada EstadoSolicitudAnulacion = "PRESENTADA" // Presentada, en espera de la aprobación del SII
+	EstadoAnulacionAprobada   EstadoSolicitudAnulacion = "APROBADA"   // Folios anulados
+	EstadoAnulacionRechazada  EstadoSolicitudAnulacion = "RECHAZADA"  // Los folios pueden incluirse en otra solicitud
+)
+
+// RangoAnulacion es un rango continuo de folios de un CAF que se solicita anular
+type RangoAnulacion struct {
+	CAFID   string          `json:"caf_id" bson:"caf_id"`
+	TipoDTE TipoDTE         `json:"tipo_dte" bson:"tipo_dte"`
+	Desde   int64           `json:"desde" bson:"desde"`
+	Hasta   int64           `json:"hasta" bson:"hasta"`
+	Motivo  MotivoAnulacion `json:"motivo" bson:"motivo"`
+}
+
+// Cantidad retorna la cantidad de folios del rango
+func (r RangoAnulacion) Cantidad() int64 {
+	return r.Hasta - r.Desde + 1
+}
+
+// SolicitudAnulacion agrupa por CAF los folios de una empresa que se solicita anular al SII
+type SolicitudAnulacion struct {
+	ID                string                   `json:"id" bson:"_id"`
+	RutEmisor         string                   `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente          AmbienteSII              `json:"ambiente" bson:"ambiente"`
+	Rangos            []RangoAnulacion         `json:"rangos" bson:"rangos"`
+	CantidadFolios    int64                    `json:"cantidad_folios" bson:"cantidad_folios"`
+	Estado            EstadoSolicitudAnulacion `json:"estado" bson:"estado"`
+	NumeroAtencion    string                   `json:"numero_atencion,omitempty" bson:"numero_atencion,omitempty"` // Entregado por el SII al presentar
+	Glosa             string                   `json:"glosa,omitempty" bson:"glosa,omitempty"`
+	FechaPresentacion *time.Time               `json:"fecha_presentacion,omitempty" bson:"fecha_presentacion,omitempty"`
+	FechaResolucion   *time.Time               `json:"fecha_resolucion,omitempty" bson:"fecha_resolucion,omitempty"`
+	CreatedAt         time.Time                `json:"created_at" bson:"created_at"`
+	UpdatedAt         time.Time                `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Estados de un bloque de folios
+const (
+	EstadoBloqueArrendado = "ARRENDADO" // Una instancia entrega sus folios
+	EstadoBloqueLibre     = "LIBRE"     // Folios devueltos, disponibles para otro arriendo
+	EstadoBloqueCerrado   = "CERRADO"   // Devuelto; los folios hasta UsadoHasta se entregaron
+	EstadoBloqueExpirado  = "EXPIRADO"  // El arriendo venció sin devolverse; los folios posteriores a UsadoHasta quedan sin confirmar
+)
+
+// BloqueFolios es un tramo contiguo de un rango de folios arrendado a una instancia del
+// gateway, que entrega sus folios en orden sin consultar la base de datos
+type BloqueFolios struct {
+	ID               string      `json:"id" bson:"_id"`
+	RangoID          string      `json:"rango_id" bson:"rango_id"`
+	CAFID            string      `json:"caf_id" bson:"caf_id"`
+	RutEmisor        string      `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente         AmbienteSII `json:"ambiente" bson:"ambiente"`
+	TipoDTE          TipoDTE     `json:"tipo_dte" bson:"tipo_dte"`
+	Desde            int64       `json:"desde" bson:"desde"`
+	Hasta            int64       `json:"hasta" bson:"hasta"`
+	UsadoHasta       int64       `json:"usado_hasta" bson:"usado_hasta"` // Último folio entregado informado
+	Estado           string      `json:"estado" bson:"estado"`
+	Instancia        string      `json:"instancia,omitempty" bson:"instancia,omitempty"`
+	ExpiraEn         time.Time   `json:"expira_en,omitempty" bson:"expira_en,omitempty"`
+	Origen           string      `json:"origen,omitempty" bson:"origen,omitempty"` // Bloque del que se devolvieron estos folios
+	FechaVencimiento time.Time   `json:"fecha_vencimiento" bson:"fecha_vencimiento"`
+	CreatedAt        time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+// Disponibles retorna la cantidad de folios del bloque aún no entregados
+func (b *BloqueFolios) Disponibles() int64 {
+	return b.Hasta - b.UsadoHasta
+}
+
+// FolioAsignado registra la entrega de un folio para la auditoría
+type FolioAsignado struct {
+	Folio     int64       `json:"folio" bson:"folio"`
+	TipoDTE   TipoDTE     `json:"tipo_dte" bson:"tipo_dte"`
+	RutEmisor string      `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente  AmbienteSII `json:"ambiente" bson:"ambiente"`
+	CAFID     string      `json:"caf_id" bson:"caf_id"`
+	BloqueID  string      `json:"bloque_id" bson:"bloque_id"`
+	Instancia string      `json:"instancia" bson:"instancia"`
+	Fecha     time.Time   `json:"fecha" bson:"fecha"`
+}
@@ -28,6 +28,7 @@ type Boleta struct {
 	Referencias         []Referencia     `json:"referencias,omitempty" bson:"referencias,omitempty"`
 	Estado              string           `json:"estado" bson:"estado"`
 	EstadoSII           string           `json:"estado_sii"`
+	TimbreElectronico   string           `json:"timbre_electronico,omitempty" bson:"timbre_electronico,omitempty"`
 	Detalles            []*DetalleBoleta `json:"detalles,omitempty"`
 	CreatedAt           time.Time        `json:"created_at" bson:"created_at"`
 	UpdatedAt           time.Time        `json:"updated_at" bson:"updated_at"`
@@ -0,0 +1,77 @@
+package models
+
+// Estados de un envío de boletas informados por el servicio REST del SII
+const (
+	EstadoEnvioBoletaRecibido   = "REC" // Envío recibido
+	EstadoEnvioBoletaProcesado  = "EPR" // Envío procesado
+	EstadoEnvioBoletaReparos    = "RPR" // Aceptado con reparos
+	EstadoEnvioBoletaRechazado  = "RCH" // Rechazado por errores en los documentos
+	EstadoEnvioBoletaRechFirma  = "RFR" // Rechazado por error en la firma
+	EstadoEnvioBoletaRechSchema = "RSC" // Rechazado por error de schema
+)
+
+// RespuestaEnvioBoleta es la respuesta JSON del servicio boleta.electronica.envio
+type RespuestaEnvioBoleta struct {
+	RutEmisor      string `json:"rut_emisor"`
+	RutEnvia       string `json:"rut_envia"`
+	TrackID        int64  `json:"trackid"`
+	FechaRecepcion string `json:"fecha_recepcion"`
+	Estado         string `json:"estado"`
+	Archivo        string `json:"file"`
+}
+
+// EstadisticaEnvioBoleta resume los documentos de un tipo dentro del envío
+type EstadisticaEnvioBoleta struct {
+	Tipo       int `json:"tipo"`
+	Informados int `json:"informados"`
+	Aceptados  int `json:"aceptados"`
+	Rechazados int `json:"rechazados"`
+	Reparos    int `json:"reparos"`
+}
+
+// ErrorBoletaSII es un error informado por el SII sobre una boleta
+type ErrorBoletaSII struct {
+	Seccion     string `json:"seccion"`
+	Linea       int    `json:"linea"`
+	Nivel       int    `json:"nivel"`
+	Codigo      int    `json:"codigo"`
+	Descripcion string `json:"descripcion"`
+	Detalle     string `json:"detalle"`
+}
+
+// DetalleRechazoBoleta describe una boleta rechazada o aceptada con reparos
+type DetalleRechazoBoleta struct {
+	Tipo        int              `json:"tipo"`
+	Folio       int64            `json:"folio"`
+	Estado      string           `json:"estado"`
+	Descripcion string           `json:"descripcion"`
+	Errores     []ErrorBoletaSII `json:"error"`
+}
+
+// EstadoEnvioBoleta es la respuesta JSON de la consulta de estado de un envío por TrackID
+type EstadoEnvioBoleta struct {
+	RutEmisor      string                   `json:"rut_emisor"`
+	RutEnvia       string                   `json:"rut_envia"`
+	TrackID        int64                    `json:"trackid"`
+	FechaRecepcion string                   `json:"fecha_recepcion"`
+	Estado         string                   `json:"estado"`
+	Estadisticas   []EstadisticaEnvioBoleta `json:"estadistica"`
+	Detalles       []DetalleRechazoBoleta   `json:"detalle_rep_rech"`
+}
+
+// ConsultaEstadoBoleta identifica una boleta para consultar su estado por RUT, tipo y folio
+type ConsultaEstadoBoleta struct {
+	RutEmisor    string
+	Tipo         TipoDTE
+	Folio        int64
+	RutReceptor  string
+	Monto        int64
+	FechaEmision string // dd-mm-aaaa
+}
+
+// EstadoDocumentoBoleta es la respuesta JSON de la consulta de estado de una boleta
+type EstadoDocumentoBoleta struct {
+	Codigo      string `json:"codigo"`
+	Estado      string `json:"estado"`
+	Descripcion string `json:"descripcion"`
+}
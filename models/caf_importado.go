@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Estados de un rango de folios
+const (
+	EstadoRangoDisponible = "DISPONIBLE" // Quedan folios por asignar
+	EstadoRangoAgotado    = "AGOTADO"    // Todos los folios fueron asignados
+	EstadoRangoVencido    = "VENCIDO"    // El CAF venció con folios sin asignar
+)
+
+// CAFImportado es un CAF del SII registrado por la importación. El nodo CAF firmado se guarda
+// tal como llegó y la llave RSASK solo cifrada; ninguno de los dos se expone en JSON.
+type CAFImportado struct {
+	ID                string      `json:"id" bson:"_id"`
+	EmpresaID         string      `json:"empresa_id" bson:"empresa_id"`
+	RutEmisor         string      `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente          AmbienteSII `json:"ambiente" bson:"ambiente"`
+	TipoDTE           TipoDTE     `json:"tipo_dte" bson:"tipo_dte"`
+	FolioDesde        int64       `json:"folio_desde" bson:"folio_desde"`
+	FolioHasta        int64       `json:"folio_hasta" bson:"folio_hasta"`
+	FechaAutorizacion time.Time   `json:"fecha_autorizacion" bson:"fecha_autorizacion"`
+	FechaVencimiento  time.Time   `json:"fecha_vencimiento" bson:"fecha_vencimiento"`
+	IDK               int         `json:"idk" bson:"idk"`
+	Hash              string      `json:"hash" bson:"hash"` // SHA-256 del nodo CAF, identifica reimportaciones
+	NodoCAF           string      `json:"-" bson:"nodo_caf"`
+	RSAPUBK           string      `json:"-" bson:"rsapubk"`
+	RSASKCifrada      []byte      `json:"-" bson:"rsask_cifrada"`
+	LlaveCifrado      string      `json:"-" bson:"llave_cifrado"` // Identificador de la llave maestra usada
+	CreatedAt         time.Time   `json:"created_at" bson:"created_at"`
+}
+
+// RangoFolios es el rango de folios de un CAF que administra el asignador. Siguiente es el
+// primer folio aún no entregado.
+type RangoFolios struct {
+	ID               string      `json:"id" bson:"_id"`
+	CAFID            string      `json:"caf_id" bson:"caf_id"`
+	EmpresaID        string      `json:"empresa_id" bson:"empresa_id"`
+	RutEmisor        string      `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente         AmbienteSII `json:"ambiente" bson:"ambiente"`
+	TipoDTE          TipoDTE     `json:"tipo_dte" bson:"tipo_dte"`
+	Desde            int64       `json:"desde" bson:"desde"`
+	Hasta            int64       `json:"hasta" bson:"hasta"`
+	Siguiente        int64       `json:"siguiente" bson:"siguiente"`
+	Estado           string      `json:"estado" bson:"estado"`
+	FechaVencimiento time.Time   `json:"fecha_vencimiento" bson:"fecha_vencimiento"`
+	CreatedAt        time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+// Superpone indica si el rango comparte algún folio con [desde, hasta]
+func (r *RangoFolios) Superpone(desde, hasta int64) bool {
+	return r.Desde <= hasta && desde <= r.Hasta
+}
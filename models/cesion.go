@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// EstadoCesion representa el estado de una cesión ante el Registro Electrónico de Cesiones
+type EstadoCesion string
+
+const (
+	EstadoCesionGenerada  EstadoCesion = "GENERADA"
+	EstadoCesionEnviada   EstadoCesion = "ENVIADA"
+	EstadoCesionAceptada  EstadoCesion = "ACEPTADA"
+	EstadoCesionRechazada EstadoCesion = "RECHAZADA"
+	EstadoCesionError     EstadoCesion = "ERROR"
+)
+
+// CesionInfo registra la cesión vigente de un documento y quién es titular del crédito
+type CesionInfo struct {
+	SeqCesion             int          `json:"seq_cesion" bson:"seq_cesion"`
+	RutCedente            string       `json:"rut_cedente" bson:"rut_cedente"`
+	RazonSocialCedente    string       `json:"razon_social_cedente" bson:"razon_social_cedente"`
+	RutCesionario         string       `json:"rut_cesionario" bson:"rut_cesionario"`
+	RazonSocialCesionario string       `json:"razon_social_cesionario" bson:"razon_social_cesionario"`
+	MontoCesion           int64        `json:"monto_cesion" bson:"monto_cesion"`
+	UltimoVencimiento     string       `json:"ultimo_vencimiento,omitempty" bson:"ultimo_vencimiento,omitempty"`
+	FechaCesion           time.Time    `json:"fecha_cesion" bson:"fecha_cesion"`
+	Estado                EstadoCesion `json:"estado" bson:"estado"`
+	TrackID               string       `json:"track_id,omitempty" bson:"track_id,omitempty"`
+	Glosa                 string       `json:"glosa,omitempty" bson:"glosa,omitempty"`
+}
+
+// AECXML representa el Archivo Electrónico de Cesión (AEC_v10.xsd)
+type AECXML struct {
+	XMLName      xml.Name        `xml:"AEC"`
+	Xmlns        string          `xml:"xmlns,attr"`
+	Version      string          `xml:"version,attr"`
+	DocumentoAEC DocumentoAECXML `xml:"DocumentoAEC"`
+}
+
+// DocumentoAECXML representa el contenido firmado del AEC
+type DocumentoAECXML struct {
+	ID       string         `xml:"ID,attr"`
+	Caratula CaratulaAECXML `xml:"Caratula"`
+	Cesiones CesionesAECXML `xml:"Cesiones"`
+}
+
+// CaratulaAECXML representa la carátula del AEC
+type CaratulaAECXML struct {
+	Version        string `xml:"version,attr"`
+	RutCedente     string `xml:"RutCedente"`
+	RutCesionario  string `xml:"RutCesionario"`
+	NmbContacto    string `xml:"NmbContacto,omitempty"`
+	FonoContacto   string `xml:"FonoContacto,omitempty"`
+	MailContacto   string `xml:"MailContacto,omitempty"`
+	TmstFirmaEnvio string `xml:"TmstFirmaEnvio"`
+}
+
+// CesionesAECXML contiene el DTE cedido y las cesiones, ya firmados
+type CesionesAECXML struct {
+	Contenido string `xml:",innerxml"`
+}
+
+// DTECedidoXML representa el nodo DTECedido (DTECedido_v10.xsd)
+type DTECedidoXML struct {
+	XMLName            xml.Name              `xml:"DTECedido"`
+	Xmlns              string                `xml:"xmlns,attr"`
+	Version            string                `xml:"version,attr"`
+	DocumentoDTECedido DocumentoDTECedidoXML `xml:"DocumentoDTECedido"`
+}
+
+// DocumentoDTECedidoXML contiene el DTE original firmado
+type DocumentoDTECedidoXML struct {
+	ID        string `xml:"ID,attr"`
+	DTE       string `xml:",innerxml"`
+	TmstFirma string `xml:"TmstFirma"`
+}
+
+// CesionXML representa el nodo Cesion (Cesion_v10.xsd)
+type CesionXML struct {
+	XMLName         xml.Name           `xml:"Cesion"`
+	Xmlns           string             `xml:"xmlns,attr"`
+	Version         string             `xml:"version,attr"`
+	DocumentoCesion DocumentoCesionXML `xml:"DocumentoCesion"`
+}
+
+// DocumentoCesionXML representa los datos de una cesión
+type DocumentoCesionXML struct {
+	ID                string         `xml:"ID,attr"`
+	SeqCesion         int            `xml:"SeqCesion"`
+	IdDTE             IdDTECedidoXML `xml:"IdDTE"`
+	Cedente           CedenteXML     `xml:"Cedente"`
+	Cesionario        CesionarioXML  `xml:"Cesionario"`
+	MontoCesion       int64          `xml:"MontoCesion"`
+	UltimoVencimiento string         `xml:"UltimoVencimiento"`
+	TmstCesion        string         `xml:"TmstCesion"`
+}
+
+// IdDTECedidoXML identifica el DTE que se cede
+type IdDTECedidoXML struct {
+	TipoDTE     int    `xml:"TipoDTE"`
+	RUTEmisor   string `xml:"RUTEmisor"`
+	RUTReceptor string `xml:"RUTReceptor"`
+	Folio       int64  `xml:"Folio"`
+	FchEmis     string `xml:"FchEmis"`
+	MntTotal    int64  `xml:"MntTotal"`
+}
+
+// CedenteXML representa al cedente de la cesión
+type CedenteXML struct {
+	RUT               string             `xml:"RUT"`
+	RazonSocial       string             `xml:"RazonSocial"`
+	Direccion         string             `xml:"Direccion"`
+	Email             string             `xml:"eMail"`
+	RUTAutorizado     []RUTAutorizadoXML `xml:"RUTAutorizado"`
+	DeclaracionJurada string             `xml:"DeclaracionJurada"`
+}
+
+// RUTAutorizadoXML representa a la persona autorizada a firmar la cesión
+type RUTAutorizadoXML struct {
+	RUT    string `xml:"RUT"`
+	Nombre string `xml:"Nombre"`
+}
+
+// CesionarioXML representa al cesionario (factoring) de la cesión
+type CesionarioXML struct {
+	RUT         string `xml:"RUT"`
+	RazonSocial string `xml:"RazonSocial"`
+	Direccion   string `xml:"Direccion"`
+	Email       string `xml:"eMail"`
+}
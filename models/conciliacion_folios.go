@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// TipoHallazgoFolio clasifica una diferencia encontrada al conciliar los folios de un CAF
+type TipoHallazgoFolio string
+
+// Hallazgos de la conciliación de folios
+const (
+	HallazgoFolioFaltante         TipoHallazgoFolio = "FOLIO_FALTANTE"          // Entregado sin registro de uso ni documento
+	HallazgoFolioDuplicado        TipoHallazgoFolio = "FOLIO_DUPLICADO"         // Más de un documento no rechazado con el folio
+	HallazgoUtilizadoSinDocumento TipoHallazgoFolio = "UTILIZADO_SIN_DOCUMENTO" // Registrado como utilizado sin documento emitido
+	HallazgoFolioSinRegistro      TipoHallazgoFolio = "FOLIO_SIN_REGISTRO"      // Documento emitido sin el uso del folio registrado
+	HallazgoDocumentoFueraCAF     TipoHallazgoFolio = "DOCUMENTO_FUERA_CAF"     // Documento con un folio de ningún CAF del ambiente
+	HallazgoDocumentoNoAceptado   TipoHallazgoFolio = "DOCUMENTO_NO_ACEPTADO"   // Rechazado por el SII o sin estado final de su TrackID
+	HallazgoAnuladoConDocumento   TipoHallazgoFolio = "ANULADO_CON_DOCUMENTO"   // Anulado ante el SII y usado en un documento
+)
+
+// HallazgoFolio es una diferencia entre un CAF, la colección de folios y los documentos emitidos
+type HallazgoFolio struct {
+	Tipo       TipoHallazgoFolio `json:"tipo" bson:"tipo"`
+	TipoDTE    TipoDTE           `json:"tipo_dte" bson:"tipo_dte"`
+	Folio      int64             `json:"folio" bson:"folio"`
+	CAFID      string            `json:"caf_id,omitempty" bson:"caf_id,omitempty"` // Vacío si el folio no es de ningún CAF
+	Documentos []string          `json:"documentos,omitempty" bson:"documentos,omitempty"`
+	TrackID    string            `json:"track_id,omitempty" bson:"track_id,omitempty"`
+	Detalle    string            `json:"detalle" bson:"detalle"`
+	Reparable  bool              `json:"reparable" bson:"reparable"` // Se corrige registrando el uso del folio
+	Reparado   bool              `json:"reparado" bson:"reparado"`
+}
+
+// ConciliacionCAF resume cómo terminaron los folios de un CAF. Está conciliado cuando cada
+// folio usado tiene un documento aceptado por el SII o fue anulado y, si el CAF venció, no le
+// quedan folios sin usar por anular.
+type ConciliacionCAF struct {
+	CAFID            string    `json:"caf_id" bson:"caf_id"`
+	TipoDTE          TipoDTE   `json:"tipo_dte" bson:"tipo_dte"`
+	Desde            int64     `json:"desde" bson:"desde"`
+	Hasta            int64     `json:"hasta" bson:"hasta"`
+	FechaVencimiento time.Time `json:"fecha_vencimiento" bson:"fecha_vencimiento"`
+	Aceptados        int64     `json:"aceptados" bson:"aceptados"`
+	Anulados         int64     `json:"anulados" bson:"anulados"`
+	EnAnulacion      int64     `json:"en_anulacion" bson:"en_anulacion"` // Por anular o en una solicitud sin resolver
+	Pendientes       int64     `json:"pendientes" bson:"pendientes"`     // Dentro del plazo de gracia o en espera del SII
+	ConHallazgos     int64     `json:"con_hallazgos" bson:"con_hallazgos"`
+	SinUsar          int64     `json:"sin_usar" bson:"sin_usar"`
+	Conciliado       bool      `json:"conciliado" bson:"conciliado"`
+}
+
+// ReporteConciliacion es el resultado de conciliar los folios de una empresa en un ambiente
+type ReporteConciliacion struct {
+	ID        string            `json:"id" bson:"_id"`
+	RutEmisor string            `json:"rut_emisor" bson:"rut_emisor"`
+	Ambiente  AmbienteSII       `json:"ambiente" bson:"ambiente"`
+	Fecha     time.Time         `json:"fecha" bson:"fecha"`
+	CAFs      []ConciliacionCAF `json:"cafs" bson:"cafs"`
+	Hallazgos []HallazgoFolio   `json:"hallazgos" bson:"hallazgos"`
+	Reparados int               `json:"reparados" bson:"reparados"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// EstadoConsumoFolios representa el estado de un reporte de consumo de folios
+type EstadoConsumoFolios string
+
+const (
+	EstadoRCOFGenerado  EstadoConsumoFolios = "GENERADO"
+	EstadoRCOFEnviado   EstadoConsumoFolios = "ENVIADO"
+	EstadoRCOFAceptado  EstadoConsumoFolios = "ACEPTADO"
+	EstadoRCOFRechazado EstadoConsumoFolios = "RECHAZADO"
+	EstadoRCOFError     EstadoConsumoFolios = "ERROR"
+)
+
+// ConsumoFoliosXML representa el documento ConsumoFolios (ConsumoFolio_v10.xsd)
+type ConsumoFoliosXML struct {
+	XMLName                xml.Name                  `xml:"ConsumoFolios"`
+	Xmlns                  string                    `xml:"xmlns,attr"`
+	Version                string                    `xml:"version,attr"`
+	DocumentoConsumoFolios DocumentoConsumoFoliosXML `xml:"DocumentoConsumoFolios"`
+}
+
+// DocumentoConsumoFoliosXML representa el contenido firmado del reporte
+type DocumentoConsumoFoliosXML struct {
+	ID       string              `xml:"ID,attr"`
+	Caratula CaratulaConsumoXML  `xml:"Caratula"`
+	Resumen  []ResumenConsumoXML `xml:"Resumen,omitempty"`
+}
+
+// CaratulaConsumoXML representa la carátula del reporte de consumo de folios
+type CaratulaConsumoXML struct {
+	Version      string `xml:"version,attr"`
+	RutEmisor    string `xml:"RutEmisor"`
+	RutEnvia     string `xml:"RutEnvia"`
+	FchResol     string `xml:"FchResol"`
+	NroResol     int    `xml:"NroResol"`
+	FchInicio    string `xml:"FchInicio"`
+	FchFinal     string `xml:"FchFinal"`
+	SecEnvio     int    `xml:"SecEnvio"`
+	TmstFirmaEnv string `xml:"TmstFirmaEnv"`
+}
+
+// ResumenConsumoXML representa el resumen de un tipo de boleta en el día
+type ResumenConsumoXML struct {
+	TipoDocumento    int              `xml:"TipoDocumento" json:"tipo_documento" bson:"tipo_documento"`
+	MntNeto          int64            `xml:"MntNeto,omitempty" json:"mnt_neto,omitempty" bson:"mnt_neto,omitempty"`
+	MntIva           int64            `xml:"MntIva,omitempty" json:"mnt_iva,omitempty" bson:"mnt_iva,omitempty"`
+	TasaIVA          float64          `xml:"TasaIVA,omitempty" json:"tasa_iva,omitempty" bson:"tasa_iva,omitempty"`
+	MntExento        int64            `xml:"MntExento,omitempty" json:"mnt_exento,omitempty" bson:"mnt_exento,omitempty"`
+	MntTotal         int64            `xml:"MntTotal" json:"mnt_total" bson:"mnt_total"`
+	FoliosEmitidos   int              `xml:"FoliosEmitidos" json:"folios_emitidos" bson:"folios_emitidos"`
+	FoliosAnulados   int              `xml:"FoliosAnulados" json:"folios_anulados" bson:"folios_anulados"`
+	FoliosUtilizados int              `xml:"FoliosUtilizados" json:"folios_utilizados" bson:"folios_utilizados"`
+	RangoUtilizados  []RangoFoliosXML `xml:"RangoUtilizados,omitempty" json:"rango_utilizados,omitempty" bson:"rango_utilizados,omitempty"`
+	RangoAnulados    []RangoFoliosXML `xml:"RangoAnulados,omitempty" json:"rango_anulados,omitempty" bson:"rango_anulados,omitempty"`
+}
+
+// RangoFoliosXML representa un rango continuo de folios
+type RangoFoliosXML struct {
+	Inicial int `xml:"Inicial" json:"inicial" bson:"inicial"`
+	Final   int `xml:"Final" json:"final" bson:"final"`
+}
+
+// ConsumoFolios representa un reporte de consumo de folios enviado (o por enviar) al SII
+type ConsumoFolios struct {
+	ID         string              `json:"id" bson:"_id,omitempty"`
+	RutEmisor  string              `json:"rut_emisor" bson:"rut_emisor"`
+	Fecha      string              `json:"fecha" bson:"fecha"`
+	SecEnvio   int                 `json:"sec_envio" bson:"sec_envio"`
+	Resumenes  []ResumenConsumoXML `json:"resumenes" bson:"resumenes"`
+	XML        string              `json:"xml,omitempty" bson:"xml"`
+	Estado     EstadoConsumoFolios `json:"estado" bson:"estado"`
+	TrackID    string              `json:"track_id,omitempty" bson:"track_id,omitempty"`
+	Glosa      string              `json:"glosa,omitempty" bson:"glosa,omitempty"`
+	FechaEnvio *time.Time          `json:"fecha_envio,omitempty" bson:"fecha_envio,omitempty"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at" bson:"updated_at"`
+}
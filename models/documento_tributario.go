@@ -9,44 +9,55 @@ type DetalleTributario struct {
 	PrecioUnitario float64 `json:"precio_unitario" bson:"precio_unitario"`
 	MontoItem      float64 `json:"monto_item" bson:"monto_item"`
 	Exento         bool    `json:"exento" bson:"exento"`
+	UnidadMedida   string  `json:"unidad_medida,omitempty" bson:"unidad_medida,omitempty"`
 }
 
 // DocumentoTributario representa la estructura común para todos los documentos tributarios
 type DocumentoTributario struct {
-	ID                  string       `json:"id" bson:"_id,omitempty"`
-	Folio               int          `json:"folio" bson:"folio"`
-	FechaEmision        time.Time    `json:"fecha_emision" bson:"fecha_emision"`
-	TipoDocumento       TipoDTE      `json:"tipo_documento" bson:"tipo_documento"`
-	TipoDTE             string       `json:"tipo_dte" bson:"tipo_dte"` // Representa el DTE como string para interfaz con SII
-	RUTEmisor           string       `json:"rut_emisor" bson:"rut_emisor"`
-	RazonSocialEmisor   string       `json:"razon_social_emisor" bson:"razon_social_emisor"`
-	GiroEmisor          string       `json:"giro_emisor" bson:"giro_emisor"`
-	DireccionEmisor     string       `json:"direccion_emisor" bson:"direccion_emisor"`
-	ComunaEmisor        string       `json:"comuna_emisor" bson:"comuna_emisor"`
-	RUTReceptor         string       `json:"rut_receptor" bson:"rut_receptor"`
-	RazonSocialReceptor string       `json:"razon_social_receptor" bson:"razon_social_receptor"`
-	GiroReceptor        string       `json:"giro_receptor,omitempty" bson:"giro_receptor,omitempty"`
-	DireccionReceptor   string       `json:"direccion_receptor" bson:"direccion_receptor"`
-	ComunaReceptor      string       `json:"comuna_receptor,omitempty" bson:"comuna_receptor,omitempty"`
-	MontoNeto           float64      `json:"monto_neto" bson:"monto_neto"`
-	MontoExento         float64      `json:"monto_exento" bson:"monto_exento"`
-	MontoIVA            float64      `json:"monto_iva" bson:"monto_iva"`
-	TasaIVA             float64      `json:"tasa_iva" bson:"tasa_iva"`
-	MontoTotal          float64      `json:"monto_total" bson:"monto_total"`
-	Referencias         []Referencia `json:"referencias,omitempty" bson:"referencias,omitempty"`
-	Estado              EstadoDTE    `json:"estado" bson:"estado"`
-	TrackID             string       `json:"track_id,omitempty" bson:"track_id,omitempty"`
-	PDF                 string       `json:"pdf,omitempty" bson:"pdf,omitempty"`
-	PDFData             []byte       `json:"pdf_data,omitempty" bson:"-"`
-	XML                 string       `json:"xml,omitempty" bson:"xml,omitempty"`
-	CreatedAt           time.Time    `json:"created_at" bson:"created_at"`
-	UpdatedAt           time.Time    `json:"updated_at" bson:"updated_at"`
-	Timestamps          Timestamps   `json:"timestamps,omitempty" bson:"timestamps,omitempty"`
+	ID                   string                  `json:"id" bson:"_id,omitempty"`
+	Folio                int                     `json:"folio" bson:"folio"`
+	FechaEmision         time.Time               `json:"fecha_emision" bson:"fecha_emision"`
+	TipoDocumento        TipoDTE                 `json:"tipo_documento" bson:"tipo_documento"`
+	TipoDTE              string                  `json:"tipo_dte" bson:"tipo_dte"` // Representa el DTE como string para interfaz con SII
+	RUTEmisor            string                  `json:"rut_emisor" bson:"rut_emisor"`
+	RazonSocialEmisor    string                  `json:"razon_social_emisor" bson:"razon_social_emisor"`
+	GiroEmisor           string                  `json:"giro_emisor" bson:"giro_emisor"`
+	DireccionEmisor      string                  `json:"direccion_emisor" bson:"direccion_emisor"`
+	ComunaEmisor         string                  `json:"comuna_emisor" bson:"comuna_emisor"`
+	RUTReceptor          string                  `json:"rut_receptor" bson:"rut_receptor"`
+	RazonSocialReceptor  string                  `json:"razon_social_receptor" bson:"razon_social_receptor"`
+	GiroReceptor         string                  `json:"giro_receptor,omitempty" bson:"giro_receptor,omitempty"`
+	DireccionReceptor    string                  `json:"direccion_receptor" bson:"direccion_receptor"`
+	ComunaReceptor       string                  `json:"comuna_receptor,omitempty" bson:"comuna_receptor,omitempty"`
+	MontoNeto            float64                 `json:"monto_neto" bson:"monto_neto"`
+	MontoExento          float64                 `json:"monto_exento" bson:"monto_exento"`
+	MontoIVA             float64                 `json:"monto_iva" bson:"monto_iva"`
+	TasaIVA              float64                 `json:"tasa_iva" bson:"tasa_iva"`
+	MontoTotal           float64                 `json:"monto_total" bson:"monto_total"`
+	ImpuestosAdicionales []ImpuestoAdicionalItem `json:"impuestos_adicionales,omitempty" bson:"impuestos_adicionales,omitempty"`
+	Referencias          []Referencia            `json:"referencias,omitempty" bson:"referencias,omitempty"`
+	Estado               EstadoDTE               `json:"estado" bson:"estado"`
+	TrackID              string                  `json:"track_id,omitempty" bson:"track_id,omitempty"`
+	Ambiente             AmbienteSII             `json:"ambiente,omitempty" bson:"ambiente,omitempty"` // Ambiente SII al que se envió
+	DetalleSII           *DetalleEstadoSII       `json:"detalle_sii,omitempty" bson:"detalle_sii,omitempty"`
+	AcuseReciboID        string                  `json:"acuse_recibo_id,omitempty" bson:"acuse_recibo_id,omitempty"`
+	PDF                  string                  `json:"pdf,omitempty" bson:"pdf,omitempty"`
+	PDFData              []byte                  `json:"pdf_data,omitempty" bson:"-"`
+	XML                  string                  `json:"xml,omitempty" bson:"xml,omitempty"`
+	CreatedAt            time.Time               `json:"created_at" bson:"created_at"`
+	UpdatedAt            time.Time               `json:"updated_at" bson:"updated_at"`
+	Timestamps           Timestamps              `json:"timestamps,omitempty" bson:"timestamps,omitempty"`
 
 	// Campos adicionales para la emisión de documentos
 	Emisor   *Emisor             `json:"emisor,omitempty" bson:"emisor,omitempty"`
 	Receptor *Receptor           `json:"receptor,omitempty" bson:"receptor,omitempty"`
 	Detalles []DetalleTributario `json:"detalles,omitempty" bson:"detalles,omitempty"`
+
+	// Datos de los documentos de exportación (110, 111 y 112)
+	Exportacion *DatosExportacion `json:"exportacion,omitempty" bson:"exportacion,omitempty"`
+
+	// IVA retenido por el comprador en las facturas de compra (46)
+	RetencionIVA *RetencionIVA `json:"retencion_iva,omitempty" bson:"retencion_iva,omitempty"`
 }
 
 // GetField obtiene el valor de un campo
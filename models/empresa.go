@@ -6,24 +6,25 @@ import (
 
 // Empresa representa una empresa en el sistema
 type Empresa struct {
-	ID          string    `json:"id" db:"id"`
-	Nombre      string    `json:"nombre" db:"nombre"`
-	RazonSocial string    `json:"razon_social" db:"razon_social"`
-	Giro        string    `json:"giro" db:"giro"`
-	RUT         string    `json:"rut" db:"rut"`
-	Direccion   string    `json:"direccion" db:"direccion"`
-	Comuna      string    `json:"comuna" db:"comuna"`
-	Ciudad      string    `json:"ciudad" db:"ciudad"`
-	Telefono    string    `json:"telefono" db:"telefono"`
-	Email       string    `json:"email" db:"email"`
-	RUTFirma    string    `json:"rut_firma" db:"rut_firma"`
-	NombreFirma string    `json:"nombre_firma" db:"nombre_firma"`
-	ClaveFirma  string    `json:"clave_firma" db:"clave_firma"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID          string      `json:"id" db:"id"`
+	Nombre      string      `json:"nombre" db:"nombre"`
+	RazonSocial string      `json:"razon_social" db:"razon_social"`
+	Giro        string      `json:"giro" db:"giro"`
+	RUT         string      `json:"rut" db:"rut"`
+	Direccion   string      `json:"direccion" db:"direccion"`
+	Comuna      string      `json:"comuna" db:"comuna"`
+	Ciudad      string      `json:"ciudad" db:"ciudad"`
+	Telefono    string      `json:"telefono" db:"telefono"`
+	Email       string      `json:"email" db:"email"`
+	RUTFirma    string      `json:"rut_firma" db:"rut_firma"`
+	NombreFirma string      `json:"nombre_firma" db:"nombre_firma"`
+	ClaveFirma  string      `json:"clave_firma" db:"clave_firma"`
+	Ambiente    AmbienteSII `json:"ambiente" db:"ambiente"` // Ambiente del SII en que emite la empresa
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
 }
 
-// NewEmpresa crea una nueva instancia de Empresa
+// NewEmpresa crea una nueva instancia de Empresa en el ambiente de certificación
 func NewEmpresa(nombre, razonSocial, giro, rut, direccion, comuna, ciudad, telefono, email, rutFirma, nombreFirma, claveFirma string) *Empresa {
 	return &Empresa{
 		Nombre:      nombre,
@@ -38,6 +39,7 @@ func NewEmpresa(nombre, razonSocial, giro, rut, direccion, comuna, ciudad, telef
 		RUTFirma:    rutFirma,
 		NombreFirma: nombreFirma,
 		ClaveFirma:  claveFirma,
+		Ambiente:    AmbienteCertificacion,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -66,5 +68,8 @@ func (e *Empresa) Validate() error {
 	if e.ClaveFirma == "" {
 		return &ValidationFieldError{Field: "clave_firma", Message: "La clave de la firma es obligatoria"}
 	}
+	if !e.Ambiente.Valido() {
+		return &ValidationFieldError{Field: "ambiente", Message: "El ambiente SII debe ser certificacion o produccion"}
+	}
 	return nil
 }
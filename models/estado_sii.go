@@ -0,0 +1,441 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EstadoConsultaEnvio es el estado de un envío informado por QueryEstUp
+type EstadoConsultaEnvio string
+
+// Estados de un envío y errores de la consulta según el manual de QueryEstUp. Los estados de
+// boleta (REC, RPR, RCH) también pueden aparecer en envíos de DTE.
+const (
+	EstadoConsultaEnvioRecibido        EstadoConsultaEnvio = "REC" // Envío recibido
+	EstadoConsultaEnvioSchemaOK        EstadoConsultaEnvio = "SOK" // Schema validado
+	EstadoConsultaEnvioFirmaOK         EstadoConsultaEnvio = "FOK" // Firma de envío validada
+	EstadoConsultaEnvioEnProceso       EstadoConsultaEnvio = "PDR" // Envío en proceso
+	EstadoConsultaEnvioCaratulaOK      EstadoConsultaEnvio = "CRT" // Carátula OK
+	EstadoConsultaEnvioProcesado       EstadoConsultaEnvio = "EPR" // Envío procesado
+	EstadoConsultaEnvioReparos         EstadoConsultaEnvio = "RPR" // Aceptado con reparos
+	EstadoConsultaEnvioReparosLeves    EstadoConsultaEnvio = "RLV" // Aceptado con reparos leves
+	EstadoConsultaEnvioRechazado       EstadoConsultaEnvio = "RCH" // Rechazado por errores en los documentos
+	EstadoConsultaEnvioRechazoSchema   EstadoConsultaEnvio = "RSC" // Rechazado por error en schema
+	EstadoConsultaEnvioRechazoFirma    EstadoConsultaEnvio = "RFR" // Rechazado por error en firma
+	EstadoConsultaEnvioRechazoCaratula EstadoConsultaEnvio = "RCT" // Rechazado por error en carátula
+	EstadoConsultaEnvioRechazoConsumo  EstadoConsultaEnvio = "RCO" // Rechazado por error en consumo de folios
+	EstadoConsultaEnvioCookieInactivo  EstadoConsultaEnvio = "001" // Cookie inactivo
+	EstadoConsultaEnvioTokenInactivo   EstadoConsultaEnvio = "002" // Token inactivo
+	EstadoConsultaEnvioTokenNoExiste   EstadoConsultaEnvio = "003" // Token no existe
+	EstadoConsultaEnvioErrorInterno    EstadoConsultaEnvio = "-11" // Error interno, ver ERR_CODE, SQL_CODE y SRV_CODE
+)
+
+// glosasEstadoEnvio describe los estados y errores documentados de QueryEstUp
+var glosasEstadoEnvio = map[EstadoConsultaEnvio]string{
+	EstadoConsultaEnvioRecibido:        "Envío Recibido",
+	EstadoConsultaEnvioSchemaOK:        "Schema Validado",
+	EstadoConsultaEnvioFirmaOK:         "Firma de Envío Validada",
+	EstadoConsultaEnvioEnProceso:       "Envío en Proceso",
+	EstadoConsultaEnvioCaratulaOK:      "Carátula OK",
+	EstadoConsultaEnvioProcesado:       "Envío Procesado",
+	EstadoConsultaEnvioReparos:         "Envío Aceptado con Reparos",
+	EstadoConsultaEnvioReparosLeves:    "Envío Aceptado con Reparos Leves",
+	EstadoConsultaEnvioRechazado:       "Envío Rechazado",
+	EstadoConsultaEnvioRechazoSchema:   "Rechazado por Error en Schema",
+	EstadoConsultaEnvioRechazoFirma:    "Rechazado por Error en Firma",
+	EstadoConsultaEnvioRechazoCaratula: "Rechazado por Error en Carátula",
+	EstadoConsultaEnvioRechazoConsumo:  "Rechazado por Error en Consumo de Folios",
+	EstadoConsultaEnvioCookieInactivo:  "Cookie Inactivo",
+	EstadoConsultaEnvioTokenInactivo:   "Token Inactivo",
+	EstadoConsultaEnvioTokenNoExiste:   "Token No Existe",
+	"-1":                               "Error: Retorno Campo Estado No Existe",
+	"-2":                               "Error Retorno",
+	"-3":                               "Error: RUT Usuario No Existe",
+	"-4":                               "Error Obtención de Datos",
+	"-5":                               "Error Retorno Datos",
+	"-6":                               "Error: Usuario No Autorizado",
+	"-7":                               "Error Retorno Datos",
+	"-8":                               "Error Retorno Datos",
+	"-9":                               "Error Retorno Datos",
+	"-10":                              "Error Valida RUT Usuario",
+	EstadoConsultaEnvioErrorInterno:    "Error Interno",
+	"-12":                              "Error Retorno Consulta",
+	"-13":                              "Error RUT Usuario Nulo",
+	"-14":                              "Error XML Retorno Datos",
+}
+
+// Glosa retorna la descripción del estado según el manual, o "Estado desconocido"
+func (e EstadoConsultaEnvio) Glosa() string {
+	if glosa, ok := glosasEstadoEnvio[e]; ok {
+		return glosa
+	}
+	return "Estado desconocido"
+}
+
+// EnProceso indica que el SII aún no termina de validar el envío
+func (e EstadoConsultaEnvio) EnProceso() bool {
+	switch e {
+	case EstadoConsultaEnvioRecibido, EstadoConsultaEnvioSchemaOK, EstadoConsultaEnvioFirmaOK,
+		EstadoConsultaEnvioEnProceso, EstadoConsultaEnvioCaratulaOK,
+		"PRD": // El manual también usa PRD para el envío en proceso
+		return true
+	}
+	return false
+}
+
+// Rechazo indica que el SII rechazó el envío completo
+func (e EstadoConsultaEnvio) Rechazo() bool {
+	switch e {
+	case EstadoConsultaEnvioRechazado, EstadoConsultaEnvioRechazoSchema, EstadoConsultaEnvioRechazoFirma,
+		EstadoConsultaEnvioRechazoCaratula, EstadoConsultaEnvioRechazoConsumo:
+		return true
+	}
+	return false
+}
+
+// Final indica que el envío terminó de procesarse, aceptado o rechazado
+func (e EstadoConsultaEnvio) Final() bool {
+	switch e {
+	case EstadoConsultaEnvioProcesado, EstadoConsultaEnvioReparos, EstadoConsultaEnvioReparosLeves:
+		return true
+	}
+	return e.Rechazo()
+}
+
+// ErrorToken indica que el SII no aceptó el token de la consulta
+func (e EstadoConsultaEnvio) ErrorToken() bool {
+	return e == EstadoConsultaEnvioCookieInactivo || e == EstadoConsultaEnvioTokenInactivo || e == EstadoConsultaEnvioTokenNoExiste
+}
+
+// ErrorConsulta indica que el SII no pudo responder la consulta (códigos negativos)
+func (e EstadoConsultaEnvio) ErrorConsulta() bool {
+	return strings.HasPrefix(string(e), "-")
+}
+
+// ResultadoEnvio clasifica la respuesta de QueryEstUp para decidir qué hacer con el envío
+type ResultadoEnvio string
+
+// Resultados posibles de una consulta de envío
+const (
+	ResultadoEnvioEnProceso      ResultadoEnvio = "EN_PROCESO"      // Volver a consultar más tarde
+	ResultadoEnvioAceptado       ResultadoEnvio = "ACEPTADO"        // Todos los documentos aceptados
+	ResultadoEnvioReparos        ResultadoEnvio = "REPAROS"         // Aceptado, con documentos con reparos
+	ResultadoEnvioRechazoParcial ResultadoEnvio = "RECHAZO_PARCIAL" // Algunos documentos rechazados
+	ResultadoEnvioRechazado      ResultadoEnvio = "RECHAZADO"       // Envío o todos sus documentos rechazados
+	ResultadoEnvioErrorToken     ResultadoEnvio = "ERROR_TOKEN"     // Renovar el token y reintentar
+	ResultadoEnvioNoEncontrado   ResultadoEnvio = "NO_ENCONTRADO"   // El TrackID no existe para la empresa
+	ResultadoEnvioErrorConsulta  ResultadoEnvio = "ERROR_CONSULTA"  // Error del servicio; reintentar
+	ResultadoEnvioDesconocido    ResultadoEnvio = "DESCONOCIDO"     // Estado no documentado
+)
+
+// ResumenEstadoEnvio cuenta los documentos de un tipo dentro de un envío procesado
+type ResumenEstadoEnvio struct {
+	TipoDTE    TipoDTE `json:"tipo_dte" bson:"tipo_dte"`
+	Informados int     `json:"informados" bson:"informados"`
+	Aceptados  int     `json:"aceptados" bson:"aceptados"`
+	Rechazados int     `json:"rechazados" bson:"rechazados"`
+	Reparos    int     `json:"reparos" bson:"reparos"`
+}
+
+// RespuestaEstadoEnvio es la respuesta de QueryEstUp. Documentos sólo se informa cuando el
+// envío está procesado (EPR), con un resumen por tipo de documento. ErrCode, SQLCode y SrvCode
+// detallan el error -11.
+type RespuestaEstadoEnvio struct {
+	TrackID     string               `json:"track_id" bson:"track_id"`
+	Estado      EstadoConsultaEnvio  `json:"estado" bson:"estado"`
+	Glosa       string               `json:"glosa" bson:"glosa"`
+	NumAtencion string               `json:"num_atencion,omitempty" bson:"num_atencion,omitempty"`
+	ErrCode     string               `json:"err_code,omitempty" bson:"err_code,omitempty"`
+	SQLCode     string               `json:"sql_code,omitempty" bson:"sql_code,omitempty"`
+	SrvCode     string               `json:"srv_code,omitempty" bson:"srv_code,omitempty"`
+	Documentos  []ResumenEstadoEnvio `json:"documentos,omitempty" bson:"documentos,omitempty"`
+}
+
+// Totales suma los resúmenes de todos los tipos de documento
+func (r *RespuestaEstadoEnvio) Totales() ResumenEstadoEnvio {
+	var total ResumenEstadoEnvio
+	for _, d := range r.Documentos {
+		total.Informados += d.Informados
+		total.Aceptados += d.Aceptados
+		total.Rechazados += d.Rechazados
+		total.Reparos += d.Reparos
+	}
+	return total
+}
+
+// EnvioNoEncontrado indica que el TrackID no pertenece a la empresa o no existe (error -11
+// con ERR_CODE 1)
+func (r *RespuestaEstadoEnvio) EnvioNoEncontrado() bool {
+	return r.Estado == EstadoConsultaEnvioErrorInterno && r.ErrCode == "1"
+}
+
+// DetalleError describe los códigos ERR_CODE, SRV_CODE y SQL_CODE de un error -11
+func (r *RespuestaEstadoEnvio) DetalleError() string {
+	if r.Estado != EstadoConsultaEnvioErrorInterno {
+		return ""
+	}
+	var partes []string
+	switch r.ErrCode {
+	case "0":
+		partes = append(partes, "estado retornado")
+	case "1":
+		partes = append(partes, "el envío no es de la empresa o faltan parámetros de entrada")
+	case "2":
+		partes = append(partes, "error de proceso")
+	}
+	switch r.SrvCode {
+	case "1":
+		partes = append(partes, "error en parámetros de entrada")
+	case "2":
+		partes = append(partes, "error en consulta SQL")
+	}
+	if r.SQLCode != "" && r.SQLCode != "0" {
+		partes = append(partes, "código Oracle "+r.SQLCode)
+	}
+	return strings.Join(partes, "; ")
+}
+
+// Resultado clasifica la respuesta. Un envío EPR se evalúa por sus documentos: sin aceptados
+// es un rechazo, con algunos rechazados es un rechazo parcial y con reparos queda con reparos.
+func (r *RespuestaEstadoEnvio) Resultado() ResultadoEnvio {
+	switch {
+	case r.Estado.ErrorToken():
+		return ResultadoEnvioErrorToken
+	case r.EnvioNoEncontrado():
+		return ResultadoEnvioNoEncontrado
+	case r.Estado.ErrorConsulta():
+		return ResultadoEnvioErrorConsulta
+	case r.Estado.EnProceso():
+		return ResultadoEnvioEnProceso
+	case r.Estado.Rechazo():
+		return ResultadoEnvioRechazado
+	case r.Estado == EstadoConsultaEnvioReparos, r.Estado == EstadoConsultaEnvioReparosLeves:
+		return ResultadoEnvioReparos
+	case r.Estado != EstadoConsultaEnvioProcesado:
+		return ResultadoEnvioDesconocido
+	}
+
+	total := r.Totales()
+	switch {
+	case total.Informados > 0 && total.Aceptados == 0:
+		return ResultadoEnvioRechazado
+	case total.Rechazados > 0:
+		return ResultadoEnvioRechazoParcial
+	case total.Reparos > 0:
+		return ResultadoEnvioReparos
+	default:
+		return ResultadoEnvioAceptado
+	}
+}
+
+// EstadoSII convierte la respuesta al estado genérico usado por los servicios existentes
+func (r *RespuestaEstadoEnvio) EstadoSII() *EstadoSII {
+	estado := &EstadoSII{
+		Estado:      string(r.Estado),
+		Glosa:       r.Glosa,
+		Descripcion: string(r.Resultado()),
+		Timestamp:   time.Now(),
+		TrackID:     r.TrackID,
+	}
+	if estado.Glosa == "" {
+		estado.Glosa = r.Estado.Glosa()
+	}
+	estado.Codigo, _ = strconv.Atoi(r.ErrCode)
+	if detalle := r.DetalleError(); detalle != "" {
+		estado.Errores = append(estado.Errores, ErrorReporteSII{
+			Codigo:      r.ErrCode,
+			Mensaje:     r.Glosa,
+			Descripcion: detalle,
+			Timestamp:   estado.Timestamp,
+		})
+	}
+	return estado
+}
+
+// EstadoConsultaDTE es el estado de un documento informado por QueryEstDte
+type EstadoConsultaDTE string
+
+// Estados de un documento y errores de la consulta según el manual de QueryEstDte
+const (
+	EstadoConsultaDTEDatosOK          EstadoConsultaDTE = "DOK" // Recibido, datos coinciden
+	EstadoConsultaDTEDatosNoOK        EstadoConsultaDTE = "DNK" // Recibido, datos no coinciden
+	EstadoConsultaDTENoRecibido       EstadoConsultaDTE = "FAU" // No recibido por el SII
+	EstadoConsultaDTENoAutorizado     EstadoConsultaDTE = "FNA" // No autorizado
+	EstadoConsultaDTEAnulado          EstadoConsultaDTE = "FAN" // Anulado
+	EstadoConsultaDTEEmpresaNoAut     EstadoConsultaDTE = "EMP" // Empresa no autorizada a emitir DTE
+	EstadoConsultaDTENDModificaTexto  EstadoConsultaDTE = "TMD" // Nota de débito modifica texto
+	EstadoConsultaDTENCModificaTexto  EstadoConsultaDTE = "TMC" // Nota de crédito modifica texto
+	EstadoConsultaDTENDModificaMontos EstadoConsultaDTE = "MMD" // Nota de débito modifica montos
+	EstadoConsultaDTENCModificaMontos EstadoConsultaDTE = "MMC" // Nota de crédito modifica montos
+	EstadoConsultaDTENDAnula          EstadoConsultaDTE = "AND" // Nota de débito anula el documento
+	EstadoConsultaDTENCAnula          EstadoConsultaDTE = "ANC" // Nota de crédito anula el documento
+	EstadoConsultaDTECookieInactivo   EstadoConsultaDTE = "001" // Cookie inactivo
+	EstadoConsultaDTETokenInactivo    EstadoConsultaDTE = "002" // Token inactivo
+	EstadoConsultaDTETokenNoExiste    EstadoConsultaDTE = "003" // Token no existe
+	EstadoConsultaDTEErrorRetorno     EstadoConsultaDTE = "-1"  // Error retorno (-1 a -4)
+)
+
+// glosasEstadoDTE describe los estados y errores documentados de QueryEstDte
+var glosasEstadoDTE = map[EstadoConsultaDTE]string{
+	EstadoConsultaDTEDatosOK:          "Documento Recibido por el SII. Datos Coinciden con los Registrados",
+	EstadoConsultaDTEDatosNoOK:        "Documento Recibido por el SII pero Datos NO Coinciden con los registrados",
+	EstadoConsultaDTENoRecibido:       "Documento No Recibido por el SII",
+	EstadoConsultaDTENoAutorizado:     "Documento No Autorizado",
+	EstadoConsultaDTEAnulado:          "Documento Anulado",
+	EstadoConsultaDTEEmpresaNoAut:     "Empresa no autorizada a Emitir Documentos Tributarios Electrónicos",
+	EstadoConsultaDTENDModificaTexto:  "Existe Nota de Débito que Modifica Texto Documento",
+	EstadoConsultaDTENCModificaTexto:  "Existe Nota de Crédito que Modifica Textos Documento",
+	EstadoConsultaDTENDModificaMontos: "Existe Nota de Débito que Modifica Montos Documento",
+	EstadoConsultaDTENCModificaMontos: "Existe Nota de Crédito que Modifica Montos Documento",
+	EstadoConsultaDTENDAnula:          "Existe Nota de Débito que Anula Documento",
+	EstadoConsultaDTENCAnula:          "Existe Nota de Crédito que Anula Documento",
+	EstadoConsultaDTECookieInactivo:   "Cookie Inactivo",
+	EstadoConsultaDTETokenInactivo:    "Token Inactivo",
+	EstadoConsultaDTETokenNoExiste:    "Token No Existe",
+	EstadoConsultaDTEErrorRetorno:     "Error Retorno",
+	"-2":                              "Error Retorno",
+	"-3":                              "Error Retorno",
+	"-4":                              "Error Retorno",
+}
+
+// estadosPorErrCode relaciona el ERR_CODE de QueryEstDte con el estado del documento; los
+// códigos no documentados corresponden a un error interno
+var estadosPorErrCode = map[string]EstadoConsultaDTE{
+	"0":  EstadoConsultaDTEDatosOK,
+	"1":  EstadoConsultaDTEDatosNoOK,
+	"3":  EstadoConsultaDTENoRecibido,
+	"4":  EstadoConsultaDTENoAutorizado,
+	"5":  EstadoConsultaDTEAnulado,
+	"6":  EstadoConsultaDTEEmpresaNoAut,
+	"10": EstadoConsultaDTENDModificaTexto,
+	"11": EstadoConsultaDTENCModificaTexto,
+	"12": EstadoConsultaDTENDModificaMontos,
+	"13": EstadoConsultaDTENCModificaMontos,
+	"14": EstadoConsultaDTENDAnula,
+	"15": EstadoConsultaDTENCAnula,
+}
+
+// EstadoPorErrCode retorna el estado que corresponde a un ERR_CODE de QueryEstDte
+func EstadoPorErrCode(errCode string) (EstadoConsultaDTE, bool) {
+	estado, ok := estadosPorErrCode[strings.TrimSpace(errCode)]
+	return estado, ok
+}
+
+// ErrCode retorna el ERR_CODE que acompaña al estado, o vacío si el estado es un error
+func (e EstadoConsultaDTE) ErrCode() string {
+	for codigo, estado := range estadosPorErrCode {
+		if estado == e {
+			return codigo
+		}
+	}
+	return ""
+}
+
+// Documentado indica que el estado es uno de los códigos del manual
+func (e EstadoConsultaDTE) Documentado() bool {
+	_, ok := glosasEstadoDTE[e]
+	return ok
+}
+
+// Glosa retorna la descripción del estado según el manual, o "Error Interno"
+func (e EstadoConsultaDTE) Glosa() string {
+	if glosa, ok := glosasEstadoDTE[e]; ok {
+		return glosa
+	}
+	return "Error Interno"
+}
+
+// Recibido indica que el SII tiene registrado el documento
+func (e EstadoConsultaDTE) Recibido() bool {
+	switch e {
+	case EstadoConsultaDTEDatosOK, EstadoConsultaDTEDatosNoOK, EstadoConsultaDTEAnulado:
+		return true
+	}
+	return e.Modificado() || e.AnuladoPorNota()
+}
+
+// Modificado indica que una nota de crédito o débito modifica el texto o los montos
+func (e EstadoConsultaDTE) Modificado() bool {
+	switch e {
+	case EstadoConsultaDTENDModificaTexto, EstadoConsultaDTENCModificaTexto,
+		EstadoConsultaDTENDModificaMontos, EstadoConsultaDTENCModificaMontos:
+		return true
+	}
+	return false
+}
+
+// AnuladoPorNota indica que una nota de crédito o débito anula el documento
+func (e EstadoConsultaDTE) AnuladoPorNota() bool {
+	return e == EstadoConsultaDTENDAnula || e == EstadoConsultaDTENCAnula
+}
+
+// Vigente indica que el documento está recibido con los datos consultados y no fue anulado
+func (e EstadoConsultaDTE) Vigente() bool {
+	return e == EstadoConsultaDTEDatosOK || e.Modificado()
+}
+
+// ErrorToken indica que el SII no aceptó el token de la consulta
+func (e EstadoConsultaDTE) ErrorToken() bool {
+	return e == EstadoConsultaDTECookieInactivo || e == EstadoConsultaDTETokenInactivo || e == EstadoConsultaDTETokenNoExiste
+}
+
+// ErrorConsulta indica que el SII no pudo responder la consulta
+func (e EstadoConsultaDTE) ErrorConsulta() bool {
+	return strings.HasPrefix(string(e), "-")
+}
+
+// ConsultaEstadoDTE identifica un documento para QueryEstDte. El SII compara el receptor, la
+// fecha y el monto con los del documento recibido.
+type ConsultaEstadoDTE struct {
+	RutConsultante string // RUT de quien consulta, titular del certificado
+	RutEmisor      string
+	RutReceptor    string
+	TipoDTE        TipoDTE
+	Folio          int64
+	FechaEmision   time.Time
+	MontoTotal     int64
+	// RutEmpresa es la empresa con cuyo token se consulta; si está vacío se usa la emisora
+	RutEmpresa string
+}
+
+// RespuestaEstadoDTE es la respuesta de QueryEstDte
+type RespuestaEstadoDTE struct {
+	Estado      EstadoConsultaDTE `json:"estado" bson:"estado"`
+	GlosaEstado string            `json:"glosa_estado" bson:"glosa_estado"`
+	ErrCode     string            `json:"err_code,omitempty" bson:"err_code,omitempty"`
+	GlosaErr    string            `json:"glosa_err,omitempty" bson:"glosa_err,omitempty"`
+	NumAtencion string            `json:"num_atencion,omitempty" bson:"num_atencion,omitempty"`
+}
+
+// ErrorInterno indica que el SII respondió un ERR_CODE no documentado
+func (r *RespuestaEstadoDTE) ErrorInterno() bool {
+	if r.Estado.ErrorToken() || r.Estado.ErrorConsulta() {
+		return false
+	}
+	_, ok := EstadoPorErrCode(r.ErrCode)
+	return r.ErrCode != "" && !ok
+}
+
+// EstadoSII convierte la respuesta al estado genérico usado por los servicios existentes
+func (r *RespuestaEstadoDTE) EstadoSII() *EstadoSII {
+	estado := &EstadoSII{
+		Estado:      string(r.Estado),
+		Glosa:       r.GlosaEstado,
+		Descripcion: r.GlosaErr,
+		Timestamp:   time.Now(),
+	}
+	if estado.Glosa == "" {
+		estado.Glosa = r.Estado.Glosa()
+	}
+	estado.Codigo, _ = strconv.Atoi(r.ErrCode)
+	if r.ErrorInterno() || r.Estado.ErrorConsulta() {
+		estado.Errores = append(estado.Errores, ErrorReporteSII{
+			Codigo:      r.ErrCode,
+			Mensaje:     estado.Glosa,
+			Descripcion: r.GlosaErr,
+			Timestamp:   estado.Timestamp,
+		})
+	}
+	return estado
+}
@@ -5,14 +5,16 @@ type EstadoDTE string
 
 // Estados de los documentos DTE
 const (
-	EstadoDTEEmitido   EstadoDTE = "EMITIDO"   // Documento emitido pero no enviado al SII
-	EstadoDTEEnviado   EstadoDTE = "ENVIADO"   // Documento enviado al SII
-	EstadoDTEAceptado  EstadoDTE = "ACEPTADO"  // Documento aceptado por el SII
-	EstadoDTERechazado EstadoDTE = "RECHAZADO" // Documento rechazado por el SII
-	EstadoDTEPendiente EstadoDTE = "PENDIENTE" // Documento pendiente de resolución por el SII
-	EstadoDTEAnulado   EstadoDTE = "ANULADO"   // Documento anulado
-	EstadoDTEErroneo   EstadoDTE = "ERRONEO"   // Documento con errores
-	EstadoDTEBorrador  EstadoDTE = "BORRADOR"  // Documento en estado borrador
+	EstadoDTEEmitido   EstadoDTE = "EMITIDO"              // Documento emitido pero no enviado al SII
+	EstadoDTEEnviado   EstadoDTE = "ENVIADO"              // Documento enviado al SII
+	EstadoDTEAceptado  EstadoDTE = "ACEPTADO"             // Documento aceptado por el SII
+	EstadoDTEReparos   EstadoDTE = "ACEPTADO_CON_REPAROS" // Documento aceptado con reparos por el SII
+	EstadoDTERechazado EstadoDTE = "RECHAZADO"            // Documento rechazado por el SII
+	EstadoDTEPendiente EstadoDTE = "PENDIENTE"            // Documento pendiente de resolución por el SII
+	EstadoDTEAnulado   EstadoDTE = "ANULADO"              // Documento anulado
+	EstadoDTEErroneo   EstadoDTE = "ERRONEO"              // Documento con errores
+	EstadoDTEBorrador  EstadoDTE = "BORRADOR"             // Documento en estado borrador
+	EstadoDTERecibido  EstadoDTE = "RECIBIDO"             // Documento recibido de un proveedor
 )
 
 // Timestamps representa las fechas importantes de un documento
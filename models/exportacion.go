@@ -0,0 +1,198 @@
+package models
+
+import "encoding/xml"
+
+// RutReceptorExtranjero es el RUT genérico que se informa cuando el receptor de un documento de
+// exportación no tiene RUT chileno; su identificación va en el nodo Extranjero
+const RutReceptorExtranjero = "55555555-5"
+
+// MonedaPesoChileno es la glosa de la tabla de monedas del SII para el peso chileno
+const MonedaPesoChileno = "PESO CL"
+
+// Indicadores de servicio para documentos de exportación
+const (
+	IndServicioExportacionNoAplica   = 0
+	IndServicioExportacionPeriodico  = 1
+	IndServicioExportacionServicio   = 3
+	IndServicioExportacionHoteleria  = 4
+	IndServicioExportacionTransporte = 5
+)
+
+// EsExportacion indica si el tipo corresponde a un documento de exportación (110, 111 o 112)
+func (t TipoDTE) EsExportacion() bool {
+	switch t {
+	case TipoFacturaExportacion, TipoNotaDebitoExportacion, TipoNotaCreditoExportacion:
+		return true
+	default:
+		return false
+	}
+}
+
+// DatosExportacion agrupa la información propia de los documentos de exportación: datos del
+// receptor extranjero, del despacho aduanero y de la moneda de la operación
+type DatosExportacion struct {
+	IndServicio int                `json:"ind_servicio,omitempty" bson:"ind_servicio,omitempty"`
+	FmaPagExp   int                `json:"fma_pag_exp,omitempty" bson:"fma_pag_exp,omitempty"`
+	Extranjero  ReceptorExtranjero `json:"extranjero" bson:"extranjero"`
+	Aduana      DatosAduana        `json:"aduana" bson:"aduana"`
+	TpoMoneda   string             `json:"tpo_moneda" bson:"tpo_moneda"`
+	TpoCambio   float64            `json:"tpo_cambio,omitempty" bson:"tpo_cambio,omitempty"`
+
+	// Montos en pesos calculados con el tipo de cambio, informados en OtraMoneda
+	MontoExentoPesos float64 `json:"monto_exento_pesos,omitempty" bson:"monto_exento_pesos,omitempty"`
+	MontoTotalPesos  float64 `json:"monto_total_pesos,omitempty" bson:"monto_total_pesos,omitempty"`
+}
+
+// ReceptorExtranjero identifica a un receptor sin RUT chileno
+type ReceptorExtranjero struct {
+	NumID        string `json:"num_id,omitempty" bson:"num_id,omitempty"`
+	Nacionalidad string `json:"nacionalidad,omitempty" bson:"nacionalidad,omitempty"` // Código de país de la tabla de Aduana
+}
+
+// DatosAduana contiene los datos del despacho de la mercadería según las tablas de Aduana
+type DatosAduana struct {
+	CodModVenta    int         `json:"cod_mod_venta,omitempty" bson:"cod_mod_venta,omitempty"`
+	CodClauVenta   int         `json:"cod_clau_venta,omitempty" bson:"cod_clau_venta,omitempty"`
+	TotClauVenta   float64     `json:"tot_clau_venta,omitempty" bson:"tot_clau_venta,omitempty"`
+	CodViaTransp   int         `json:"cod_via_transp,omitempty" bson:"cod_via_transp,omitempty"`
+	NombreTransp   string      `json:"nombre_transp,omitempty" bson:"nombre_transp,omitempty"`
+	CodPtoEmbarque int         `json:"cod_pto_embarque,omitempty" bson:"cod_pto_embarque,omitempty"`
+	CodPtoDesemb   int         `json:"cod_pto_desemb,omitempty" bson:"cod_pto_desemb,omitempty"`
+	TotBultos      int         `json:"tot_bultos,omitempty" bson:"tot_bultos,omitempty"`
+	TipoBultos     []TipoBulto `json:"tipo_bultos,omitempty" bson:"tipo_bultos,omitempty"`
+	MntFlete       float64     `json:"mnt_flete,omitempty" bson:"mnt_flete,omitempty"`
+	MntSeguro      float64     `json:"mnt_seguro,omitempty" bson:"mnt_seguro,omitempty"`
+	CodPaisRecep   int         `json:"cod_pais_recep,omitempty" bson:"cod_pais_recep,omitempty"`
+	CodPaisDestin  int         `json:"cod_pais_destin,omitempty" bson:"cod_pais_destin,omitempty"`
+}
+
+// TipoBulto describe un tipo de bulto del embarque
+type TipoBulto struct {
+	CodTpoBultos int    `json:"cod_tpo_bultos" bson:"cod_tpo_bultos"`
+	CantBultos   int    `json:"cant_bultos" bson:"cant_bultos"`
+	Marcas       string `json:"marcas,omitempty" bson:"marcas,omitempty"`
+	IdContainer  string `json:"id_container,omitempty" bson:"id_container,omitempty"`
+	Sello        string `json:"sello,omitempty" bson:"sello,omitempty"`
+}
+
+// DTEExportacionXML representa un documento de exportación (110, 111 o 112) en formato XML.
+// Los montos de Totales y Detalle están expresados en la moneda de la operación y admiten
+// decimales, por lo que no comparte estructura con los documentos nacionales.
+type DTEExportacionXML struct {
+	XMLName     xml.Name                `xml:"DTE"`
+	Xmlns       string                  `xml:"xmlns,attr,omitempty"`
+	Version     string                  `xml:"version,attr"`
+	Exportacion DocumentoExportacionXML `xml:"Exportaciones"`
+}
+
+// DocumentoExportacionXML representa el nodo Exportaciones del DTE
+type DocumentoExportacionXML struct {
+	ID         string                     `xml:"ID,attr"`
+	Encabezado EncabezadoExportacionXML   `xml:"Encabezado"`
+	Detalle    []DetalleExportacionXML    `xml:"Detalle"`
+	Referencia []ReferenciaExportacionXML `xml:"Referencia,omitempty"`
+	TED        *TEDXMLModel               `xml:"TED,omitempty"`
+	TmstFirma  string                     `xml:"TmstFirma,omitempty"`
+}
+
+// EncabezadoExportacionXML representa el encabezado de un documento de exportación
+type EncabezadoExportacionXML struct {
+	IdDoc      IdDocExportacionXML       `xml:"IdDoc"`
+	Emisor     EmisorXML                 `xml:"Emisor"`
+	Receptor   ReceptorExportacionXML    `xml:"Receptor"`
+	Transporte *TransporteExportacionXML `xml:"Transporte,omitempty"`
+	Totales    TotalesExportacionXML     `xml:"Totales"`
+	OtraMoneda *OtraMonedaXML            `xml:"OtraMoneda,omitempty"`
+}
+
+// IdDocExportacionXML representa la identificación de un documento de exportación
+type IdDocExportacionXML struct {
+	TipoDTE     int    `xml:"TipoDTE"`
+	Folio       int    `xml:"Folio"`
+	FchEmis     string `xml:"FchEmis"`
+	IndServicio int    `xml:"IndServicio,omitempty"`
+	FmaPagExp   int    `xml:"FmaPagExp,omitempty"`
+}
+
+// ReceptorExportacionXML representa al receptor extranjero
+type ReceptorExportacionXML struct {
+	RUTRecep    string         `xml:"RUTRecep"`
+	RznSocRecep string         `xml:"RznSocRecep"`
+	Extranjero  *ExtranjeroXML `xml:"Extranjero,omitempty"`
+	GiroRecep   string         `xml:"GiroRecep,omitempty"`
+	DirRecep    string         `xml:"DirRecep,omitempty"`
+	CmnaRecep   string         `xml:"CmnaRecep,omitempty"`
+	CiudadRecep string         `xml:"CiudadRecep,omitempty"`
+}
+
+// ExtranjeroXML identifica al receptor extranjero
+type ExtranjeroXML struct {
+	NumId        string `xml:"NumId,omitempty"`
+	Nacionalidad string `xml:"Nacionalidad,omitempty"`
+}
+
+// TransporteExportacionXML representa el nodo Transporte con los datos de Aduana
+type TransporteExportacionXML struct {
+	Aduana AduanaXML `xml:"Aduana"`
+}
+
+// AduanaXML representa los datos aduaneros del documento de exportación
+type AduanaXML struct {
+	CodModVenta    int            `xml:"CodModVenta,omitempty"`
+	CodClauVenta   int            `xml:"CodClauVenta,omitempty"`
+	TotClauVenta   float64        `xml:"TotClauVenta,omitempty"`
+	CodViaTransp   int            `xml:"CodViaTransp,omitempty"`
+	NombreTransp   string         `xml:"NombreTransp,omitempty"`
+	CodPtoEmbarque int            `xml:"CodPtoEmbarque,omitempty"`
+	CodPtoDesemb   int            `xml:"CodPtoDesemb,omitempty"`
+	TotBultos      int            `xml:"TotBultos,omitempty"`
+	TipoBultos     []TipoBultoXML `xml:"TipoBultos,omitempty"`
+	MntFlete       float64        `xml:"MntFlete,omitempty"`
+	MntSeguro      float64        `xml:"MntSeguro,omitempty"`
+	CodPaisRecep   int            `xml:"CodPaisRecep,omitempty"`
+	CodPaisDestin  int            `xml:"CodPaisDestin,omitempty"`
+}
+
+// TipoBultoXML representa un tipo de bulto del embarque
+type TipoBultoXML struct {
+	CodTpoBultos int    `xml:"CodTpoBultos"`
+	CantBultos   int    `xml:"CantBultos"`
+	Marcas       string `xml:"Marcas,omitempty"`
+	IdContainer  string `xml:"IdContainer,omitempty"`
+	Sello        string `xml:"Sello,omitempty"`
+}
+
+// TotalesExportacionXML representa los totales en la moneda de la operación
+type TotalesExportacionXML struct {
+	TpoMoneda string  `xml:"TpoMoneda"`
+	MntExe    float64 `xml:"MntExe,omitempty"`
+	MntTotal  float64 `xml:"MntTotal"`
+}
+
+// OtraMonedaXML representa los totales expresados en otra moneda (pesos chilenos)
+type OtraMonedaXML struct {
+	TpoMoneda     string  `xml:"TpoMoneda"`
+	TpoCambio     float64 `xml:"TpoCambio"`
+	MntExeOtrMnda float64 `xml:"MntExeOtrMnda,omitempty"`
+	MntTotOtrMnda float64 `xml:"MntTotOtrMnda"`
+}
+
+// DetalleExportacionXML representa un ítem de un documento de exportación
+type DetalleExportacionXML struct {
+	NroLinDet int     `xml:"NroLinDet"`
+	NmbItem   string  `xml:"NmbItem"`
+	QtyItem   float64 `xml:"QtyItem,omitempty"`
+	UnmdItem  string  `xml:"UnmdItem,omitempty"`
+	PrcItem   float64 `xml:"PrcItem,omitempty"`
+	MontoItem float64 `xml:"MontoItem"`
+}
+
+// ReferenciaExportacionXML representa una referencia de un documento de exportación
+type ReferenciaExportacionXML struct {
+	NroLinRef int    `xml:"NroLinRef"`
+	TpoDocRef string `xml:"TpoDocRef"`
+	FolioRef  string `xml:"FolioRef"`
+	FchRef    string `xml:"FchRef"`
+	CodRef    string `xml:"CodRef,omitempty"`
+	RazonRef  string `xml:"RazonRef,omitempty"`
+}
@@ -66,6 +66,7 @@ type FacturaResponse struct {
 	Items               []domain.Item `json:"items"`
 	TimbreElectronico   string        `json:"timbre_electronico,omitempty"`
 	FirmaElectronica    string        `json:"firma_electronica,omitempty"`
+	Cesion              *CesionInfo   `json:"cesion,omitempty"`
 }
 
 // DetalleFactura representa un detalle de factura
@@ -0,0 +1,57 @@
+package models
+
+// CodigoIVARetenidoTotal es el código de impuesto con que el comprador informa la retención del
+// total del IVA en una factura de compra
+const CodigoIVARetenidoTotal = "15"
+
+// Códigos de retención parcial de IVA usados en facturas de compra
+const (
+	CodigoIVARetenidoLegumbres       = "30"
+	CodigoIVARetenidoSilvestres      = "31"
+	CodigoIVARetenidoGanado          = "32"
+	CodigoIVARetenidoMadera          = "33"
+	CodigoIVARetenidoTrigo           = "34"
+	CodigoIVARetenidoArroz           = "36"
+	CodigoIVARetenidoHidrobiologicas = "37"
+	CodigoIVARetenidoChatarra        = "38"
+	CodigoIVARetenidoPPA             = "39"
+	CodigoIVARetenidoConstruccion    = "41"
+	CodigoIVARetenidoCartones        = "47"
+	CodigoIVARetenidoFrambuesas      = "48"
+)
+
+var glosasRetencionIVA = map[string]string{
+	CodigoIVARetenidoTotal:           "IVA retenido total",
+	CodigoIVARetenidoLegumbres:       "IVA retenido legumbres",
+	CodigoIVARetenidoSilvestres:      "IVA retenido silvestres",
+	CodigoIVARetenidoGanado:          "IVA retenido ganado",
+	CodigoIVARetenidoMadera:          "IVA retenido madera",
+	CodigoIVARetenidoTrigo:           "IVA retenido trigo",
+	CodigoIVARetenidoArroz:           "IVA retenido arroz",
+	CodigoIVARetenidoHidrobiologicas: "IVA retenido especies hidrobiológicas",
+	CodigoIVARetenidoChatarra:        "IVA retenido chatarra",
+	CodigoIVARetenidoPPA:             "IVA retenido PPA",
+	CodigoIVARetenidoConstruccion:    "IVA retenido construcción",
+	CodigoIVARetenidoCartones:        "IVA retenido cartones",
+	CodigoIVARetenidoFrambuesas:      "IVA retenido frambuesas y pasas",
+}
+
+// GlosaRetencionIVA retorna la descripción del código de retención de IVA, o vacío si el
+// código no corresponde a una retención
+func GlosaRetencionIVA(codigo string) string {
+	return glosasRetencionIVA[codigo]
+}
+
+// RetencionIVA es el IVA que el comprador retiene al vendedor en una factura de compra (46).
+// La tasa se aplica sobre el monto neto; en la retención total es la tasa de IVA
+type RetencionIVA struct {
+	Codigo        string  `json:"codigo" bson:"codigo"`
+	Tasa          float64 `json:"tasa" bson:"tasa"`
+	Monto         float64 `json:"monto" bson:"monto"`
+	IVANoRetenido float64 `json:"iva_no_retenido,omitempty" bson:"iva_no_retenido,omitempty"`
+}
+
+// EsTotal indica si la retención corresponde al total del IVA
+func (r *RetencionIVA) EsTotal() bool {
+	return r.Codigo == CodigoIVARetenidoTotal
+}
@@ -3,6 +3,7 @@ package models
 // FirmaXMLModel representa la firma digital del DTE
 type FirmaXMLModel struct {
 	XMLName        struct{}      `xml:"Signature"`
+	Xmlns          string        `xml:"xmlns,attr,omitempty"`
 	SignedInfo     SignedInfoXML `xml:"SignedInfo"`
 	SignatureValue string        `xml:"SignatureValue"`
 	KeyInfo        KeyInfoXML    `xml:"KeyInfo"`
@@ -2,9 +2,57 @@ package models
 
 import "time"
 
+// Indicadores de traslado (IndTraslado) de la guía de despacho
+const (
+	IndTrasladoVenta                = "1" // Operación constituye venta
+	IndTrasladoVentaPorEfectuar     = "2" // Ventas por efectuar
+	IndTrasladoConsignacion         = "3" // Consignaciones
+	IndTrasladoEntregaGratuita      = "4" // Entrega gratuita
+	IndTrasladoInterno              = "5" // Traslados internos
+	IndTrasladoOtrosNoVenta         = "6" // Otros traslados no venta
+	IndTrasladoDevolucion           = "7" // Guía de devolución
+	IndTrasladoExportacion          = "8" // Traslado para exportación (no venta)
+	IndTrasladoVentaParaExportacion = "9" // Venta para exportación
+)
+
+// Tipos de despacho (TipoDespacho) de la guía
+const (
+	TipoDespachoReceptor           = "1" // Despacho por cuenta del receptor del documento
+	TipoDespachoEmisorACliente     = "2" // Despacho por cuenta del emisor a instalaciones del cliente
+	TipoDespachoEmisorAOtrasInstal = "3" // Despacho por cuenta del emisor a otras instalaciones
+)
+
+var glosasIndTraslado = map[string]string{
+	IndTrasladoVenta:                "Operación constituye venta",
+	IndTrasladoVentaPorEfectuar:     "Ventas por efectuar",
+	IndTrasladoConsignacion:         "Consignaciones",
+	IndTrasladoEntregaGratuita:      "Entrega gratuita",
+	IndTrasladoInterno:              "Traslados internos",
+	IndTrasladoOtrosNoVenta:         "Otros traslados no venta",
+	IndTrasladoDevolucion:           "Guía de devolución",
+	IndTrasladoExportacion:          "Traslado para exportación (no venta)",
+	IndTrasladoVentaParaExportacion: "Venta para exportación",
+}
+
+var glosasTipoDespacho = map[string]string{
+	TipoDespachoReceptor:           "Por cuenta del receptor",
+	TipoDespachoEmisorACliente:     "Por cuenta del emisor a instalaciones del cliente",
+	TipoDespachoEmisorAOtrasInstal: "Por cuenta del emisor a otras instalaciones",
+}
+
+// GlosaIndTraslado retorna la descripción del indicador de traslado, o vacío si no es válido
+func GlosaIndTraslado(indicador string) string {
+	return glosasIndTraslado[indicador]
+}
+
+// GlosaTipoDespacho retorna la descripción del tipo de despacho, o vacío si no es válido
+func GlosaTipoDespacho(tipo string) string {
+	return glosasTipoDespacho[tipo]
+}
+
 // GuiaDespacho representa una guía de despacho electrónica
 type GuiaDespacho struct {
-	DocumentoTributario
+	DocumentoTributario        `bson:",inline"`
 	IndicadorTraslado          string    `json:"indicador_traslado" bson:"indicador_traslado"`
 	IndicadorServicio          string    `json:"indicador_servicio,omitempty" bson:"indicador_servicio,omitempty"`
 	IndicadorVentas            string    `json:"indicador_ventas,omitempty" bson:"indicador_ventas,omitempty"`
@@ -36,6 +84,29 @@ type GuiaDespacho struct {
 	MontoExento                float64   `json:"monto_exento" bson:"monto_exento"`
 	MontoIVA                   float64   `json:"monto_iva" bson:"monto_iva"`
 	Items                      []Item    `json:"items" bson:"items"`
+	TipoDespacho               string    `json:"tipo_despacho,omitempty" bson:"tipo_despacho,omitempty"`
+	RutChofer                  string    `json:"rut_chofer,omitempty" bson:"rut_chofer,omitempty"`
+	NombreChofer               string    `json:"nombre_chofer,omitempty" bson:"nombre_chofer,omitempty"`
+
+	// Factura que consolidó la guía en la facturación del período
+	FolioFactura     int       `json:"folio_factura,omitempty" bson:"folio_factura,omitempty"`
+	FechaFacturacion time.Time `json:"fecha_facturacion,omitempty" bson:"fecha_facturacion,omitempty"`
+}
+
+// ConstituyeVenta indica si el traslado de la guía es una venta que debe facturarse
+func (g *GuiaDespacho) ConstituyeVenta() bool {
+	return g.IndicadorTraslado == IndTrasladoVenta
+}
+
+// DespachoPorEmisor indica si el emisor realiza el despacho, caso en que la guía debe
+// informar los datos del transporte
+func (g *GuiaDespacho) DespachoPorEmisor() bool {
+	return g.TipoDespacho == TipoDespachoEmisorACliente || g.TipoDespacho == TipoDespachoEmisorAOtrasInstal
+}
+
+// Facturada indica si la guía ya fue incluida en una factura
+func (g *GuiaDespacho) Facturada() bool {
+	return g.FolioFactura != 0
 }
 
 // GuiaDespachoRequest representa la solicitud para crear una guía de despacho
@@ -69,6 +140,9 @@ type GuiaDespachoRequest struct {
 	DireccionDestino           string    `json:"direccion_destino"`
 	ComunaDestino              string    `json:"comuna_destino"`
 	CiudadDestino              string    `json:"ciudad_destino"`
+	TipoDespacho               string    `json:"tipo_despacho,omitempty"`
+	RutChofer                  string    `json:"rut_chofer,omitempty"`
+	NombreChofer               string    `json:"nombre_chofer,omitempty"`
 	Items                      []Item    `json:"items"`
 }
 
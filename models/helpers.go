@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -42,3 +43,8 @@ func ParseFecha(fecha string) (time.Time, error) {
 
 	return time.Time{}, fmt.Errorf("formato de fecha no reconocido: %s", fecha)
 }
+
+// RedondearMonto convierte un monto a entero según las reglas del SII
+func RedondearMonto(monto float64) int64 {
+	return int64(math.Round(monto))
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Estados de recepción de un envío (EstadoRecepEnv)
+const (
+	EstadoRecepEnvOK          = 0  // Envío recibido conforme
+	EstadoRecepEnvSchema      = 1  // Error de schema
+	EstadoRecepEnvFirma       = 2  // Error de firma
+	EstadoRecepEnvRutReceptor = 3  // RUT receptor no corresponde
+	EstadoRecepEnvRepetido    = 90 // Archivo repetido
+	EstadoRecepEnvIlegible    = 91 // Archivo ilegible
+	EstadoRecepEnvOtros       = 99 // Envío rechazado por otros motivos
+)
+
+// Estados de recepción de cada DTE del envío (EstadoRecepDTE)
+const (
+	EstadoRecepDTEOK          = 0  // DTE recibido OK
+	EstadoRecepDTEFirma       = 1  // Error de firma
+	EstadoRecepDTERutEmisor   = 2  // Error en RUT emisor
+	EstadoRecepDTERutReceptor = 3  // Error en RUT receptor
+	EstadoRecepDTERepetido    = 4  // DTE repetido
+	EstadoRecepDTEOtros       = 99 // DTE no recibido por otros motivos
+)
+
+// Resultado de la aprobación comercial de un DTE (EstadoDTE)
+const (
+	EstadoResultadoAceptado                = 0 // DTE aceptado
+	EstadoResultadoAceptadoConDiscrepancia = 1 // DTE aceptado con discrepancias
+	EstadoResultadoRechazado               = 2 // DTE rechazado
+)
+
+// RespuestaDTEXML representa el documento RespuestaDTE (RespuestaEnvioDTE_v10.xsd)
+type RespuestaDTEXML struct {
+	XMLName   xml.Name           `xml:"RespuestaDTE"`
+	Xmlns     string             `xml:"xmlns,attr"`
+	Version   string             `xml:"version,attr"`
+	Resultado ResultadoRespuesta `xml:"Resultado"`
+}
+
+// ResultadoRespuesta es el nodo firmado de la respuesta
+type ResultadoRespuesta struct {
+	ID             string              `xml:"ID,attr"`
+	Caratula       CaratulaRespuesta   `xml:"Caratula"`
+	RecepcionEnvio []RecepcionEnvioXML `xml:"RecepcionEnvio,omitempty"`
+	ResultadoDTE   []ResultadoDTEXML   `xml:"ResultadoDTE,omitempty"`
+}
+
+// CaratulaRespuesta identifica a quien responde y a quien se dirige la respuesta
+type CaratulaRespuesta struct {
+	Version       string `xml:"version,attr"`
+	RutResponde   string `xml:"RutResponde"`
+	RutRecibe     string `xml:"RutRecibe"`
+	IdRespuesta   int64  `xml:"IdRespuesta"`
+	NroDetalles   int    `xml:"NroDetalles"`
+	NmbContacto   string `xml:"NmbContacto,omitempty"`
+	FonoContacto  string `xml:"FonoContacto,omitempty"`
+	MailContacto  string `xml:"MailContacto,omitempty"`
+	TmstFirmaResp string `xml:"TmstFirmaResp"`
+}
+
+// RecepcionEnvioXML informa la recepción técnica de un EnvioDTE
+type RecepcionEnvioXML struct {
+	NmbEnvio       string            `xml:"NmbEnvio"`
+	FchRecep       string            `xml:"FchRecep"`
+	CodEnvio       int64             `xml:"CodEnvio"`
+	EnvioDTEID     string            `xml:"EnvioDTEID"`
+	Digest         string            `xml:"Digest,omitempty"`
+	RutEmisor      string            `xml:"RutEmisor,omitempty"`
+	RutReceptor    string            `xml:"RutReceptor,omitempty"`
+	EstadoRecepEnv int               `xml:"EstadoRecepEnv"`
+	RecepEnvGlosa  string            `xml:"RecepEnvGlosa"`
+	NroDTE         int               `xml:"NroDTE,omitempty"`
+	RecepcionDTE   []RecepcionDTEXML `xml:"RecepcionDTE,omitempty"`
+}
+
+// RecepcionDTEXML informa la recepción de un DTE del envío
+type RecepcionDTEXML struct {
+	TipoDTE        int    `xml:"TipoDTE"`
+	Folio          int64  `xml:"Folio"`
+	FchEmis        string `xml:"FchEmis"`
+	RUTEmisor      string `xml:"RUTEmisor"`
+	RUTRecep       string `xml:"RUTRecep"`
+	MntTotal       int64  `xml:"MntTotal"`
+	EstadoRecepDTE int    `xml:"EstadoRecepDTE"`
+	RecepDTEGlosa  string `xml:"RecepDTEGlosa"`
+}
+
+// ResultadoDTEXML informa la aprobación o rechazo comercial de un DTE
+type ResultadoDTEXML struct {
+	TipoDTE        int    `xml:"TipoDTE"`
+	Folio          int64  `xml:"Folio"`
+	FchEmis        string `xml:"FchEmis"`
+	RUTEmisor      string `xml:"RUTEmisor"`
+	RUTRecep       string `xml:"RUTRecep"`
+	MntTotal       int64  `xml:"MntTotal"`
+	CodEnvio       int64  `xml:"CodEnvio"`
+	EstadoDTE      int    `xml:"EstadoDTE"`
+	EstadoDTEGlosa string `xml:"EstadoDTEGlosa"`
+	CodRchDsc      int    `xml:"CodRchDsc,omitempty"`
+}
+
+// DTERecibido registra el resultado de la recepción de un DTE de un proveedor
+type DTERecibido struct {
+	DocumentoID    string `json:"documento_id,omitempty" bson:"documento_id,omitempty"`
+	TipoDTE        int    `json:"tipo_dte" bson:"tipo_dte"`
+	Folio          int64  `json:"folio" bson:"folio"`
+	FchEmis        string `json:"fecha_emision" bson:"fecha_emision"`
+	RutEmisor      string `json:"rut_emisor" bson:"rut_emisor"`
+	RutReceptor    string `json:"rut_receptor" bson:"rut_receptor"`
+	MontoTotal     int64  `json:"monto_total" bson:"monto_total"`
+	EstadoRecepDTE int    `json:"estado_recep_dte" bson:"estado_recep_dte"`
+	Glosa          string `json:"glosa" bson:"glosa"`
+	EstadoDTE      *int   `json:"estado_dte,omitempty" bson:"estado_dte,omitempty"`
+	GlosaResultado string `json:"glosa_resultado,omitempty" bson:"glosa_resultado,omitempty"`
+}
+
+// IntercambioDTE registra un EnvioDTE recibido de un proveedor y las respuestas generadas
+type IntercambioDTE struct {
+	ID             string        `json:"id" bson:"_id"`
+	CodEnvio       int64         `json:"cod_envio" bson:"cod_envio"`
+	RutReceptor    string        `json:"rut_receptor" bson:"rut_receptor"`
+	RutEmisor      string        `json:"rut_emisor" bson:"rut_emisor"`
+	NombreArchivo  string        `json:"nombre_archivo" bson:"nombre_archivo"`
+	EnvioDTEID     string        `json:"envio_dte_id" bson:"envio_dte_id"`
+	Digest         string        `json:"digest" bson:"digest"`
+	EstadoRecepEnv int           `json:"estado_recep_env" bson:"estado_recep_env"`
+	Glosa          string        `json:"glosa" bson:"glosa"`
+	Documentos     []DTERecibido `json:"documentos" bson:"documentos"`
+	XMLRecepcion   string        `json:"xml_recepcion,omitempty" bson:"xml_recepcion,omitempty"`
+	XMLResultado   string        `json:"xml_resultado,omitempty" bson:"xml_resultado,omitempty"`
+	FechaRecepcion time.Time     `json:"fecha_recepcion" bson:"fecha_recepcion"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// DeclaracionRecibo es el texto fijo de la Ley 19.983 que acredita la recepción
+const DeclaracionRecibo = "El acuse de recibo que se declara en este acto, de acuerdo a lo dispuesto en la letra b) del Art. 4, " +
+	"y la letra c) del Art. 5 de la Ley 19.983, acredita que la entrega de mercaderias o servicio(s) prestado(s) ha(n) sido recibido(s)."
+
+// EnvioRecibosXML representa el documento EnvioRecibos (EnvioRecibos_v10.xsd)
+type EnvioRecibosXML struct {
+	XMLName    xml.Name      `xml:"EnvioRecibos"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	Version    string        `xml:"version,attr"`
+	SetRecibos SetRecibosXML `xml:"SetRecibos"`
+}
+
+// SetRecibosXML es el nodo firmado del envío. Los Recibo se agregan y firman dentro del envío.
+type SetRecibosXML struct {
+	ID       string             `xml:"ID,attr"`
+	Caratula CaratulaRecibosXML `xml:"Caratula"`
+	Recibos  string             `xml:",innerxml"`
+}
+
+// CaratulaRecibosXML identifica a quien genera los recibos y al emisor de los documentos
+type CaratulaRecibosXML struct {
+	Version      string `xml:"version,attr"`
+	RutResponde  string `xml:"RutResponde"`
+	RutRecibe    string `xml:"RutRecibe"`
+	NmbContacto  string `xml:"NmbContacto,omitempty"`
+	FonoContacto string `xml:"FonoContacto,omitempty"`
+	MailContacto string `xml:"MailContacto,omitempty"`
+	TmstFirmaEnv string `xml:"TmstFirmaEnv"`
+}
+
+// ReciboXML representa el recibo de mercaderías o servicios de un documento (Recibos_v10.xsd)
+type ReciboXML struct {
+	XMLName         xml.Name           `xml:"Recibo"`
+	Xmlns           string             `xml:"xmlns,attr"`
+	Version         string             `xml:"version,attr"`
+	DocumentoRecibo DocumentoReciboXML `xml:"DocumentoRecibo"`
+}
+
+// DocumentoReciboXML es el nodo firmado del recibo
+type DocumentoReciboXML struct {
+	ID              string `xml:"ID,attr"`
+	TipoDoc         int    `xml:"TipoDoc"`
+	Folio           int64  `xml:"Folio"`
+	FchEmis         string `xml:"FchEmis"`
+	RUTEmisor       string `xml:"RUTEmisor"`
+	RUTRecep        string `xml:"RUTRecep"`
+	MntTotal        int64  `xml:"MntTotal"`
+	Recinto         string `xml:"Recinto"`
+	RutFirma        string `xml:"RutFirma"`
+	Declaracion     string `xml:"Declaracion"`
+	TmstFirmaRecibo string `xml:"TmstFirmaRecibo"`
+}
+
+// Acciones registradas en el historial de un acuse de recibo
+const (
+	AccionAcuseRegistrado = "REGISTRADO"
+	AccionAcuseFirmado    = "FIRMADO"
+	AccionAcuseDescargado = "DESCARGADO"
+)
+
+// EventoAcuse es una entrada del historial de un acuse de recibo
+type EventoAcuse struct {
+	Fecha   time.Time `json:"fecha" bson:"fecha"`
+	Accion  string    `json:"accion" bson:"accion"`
+	Usuario string    `json:"usuario,omitempty" bson:"usuario,omitempty"`
+	IP      string    `json:"ip,omitempty" bson:"ip,omitempty"`
+	Detalle string    `json:"detalle,omitempty" bson:"detalle,omitempty"`
+}
+
+// AcuseRecibo registra el acuse de recibo de mercaderías o servicios de un documento recibido
+type AcuseRecibo struct {
+	ID              string        `json:"id" bson:"_id"`
+	DocumentoID     string        `json:"documento_id" bson:"documento_id"`
+	TipoDTE         int           `json:"tipo_dte" bson:"tipo_dte"`
+	Folio           int64         `json:"folio" bson:"folio"`
+	RutEmisor       string        `json:"rut_emisor" bson:"rut_emisor"`
+	RutReceptor     string        `json:"rut_receptor" bson:"rut_receptor"`
+	MontoTotal      int64         `json:"monto_total" bson:"monto_total"`
+	Recinto         string        `json:"recinto" bson:"recinto"`
+	RutFirma        string        `json:"rut_firma" bson:"rut_firma"`
+	FechaRecepcion  time.Time     `json:"fecha_recepcion" bson:"fecha_recepcion"`
+	XMLRecibo       string        `json:"xml_recibo,omitempty" bson:"xml_recibo,omitempty"`
+	XMLEnvioRecibos string        `json:"xml_envio_recibos,omitempty" bson:"xml_envio_recibos,omitempty"`
+	Historial       []EventoAcuse `json:"historial" bson:"historial"`
+	CreatedAt       time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at" bson:"updated_at"`
+}
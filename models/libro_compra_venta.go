@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Tipos de operación del Libro de Compra y Venta
+const (
+	TipoOperacionCompra = "COMPRA"
+	TipoOperacionVenta  = "VENTA"
+)
+
+// Tipos de libro según el instructivo del IECV
+const (
+	TipoLibroMensual   = "MENSUAL"
+	TipoLibroEspecial  = "ESPECIAL"
+	TipoLibroRectifica = "RECTIFICA"
+)
+
+// Tipos de envío del libro
+const (
+	TipoEnvioTotal   = "TOTAL"
+	TipoEnvioParcial = "PARCIAL"
+	TipoEnvioFinal   = "FINAL"
+	TipoEnvioAjuste  = "AJUSTE"
+)
+
+// EstadoLibro representa el estado de un libro dentro del sistema
+type EstadoLibro string
+
+const (
+	EstadoLibroGenerado  EstadoLibro = "GENERADO"
+	EstadoLibroEnviado   EstadoLibro = "ENVIADO"
+	EstadoLibroAceptado  EstadoLibro = "ACEPTADO"
+	EstadoLibroRechazado EstadoLibro = "RECHAZADO"
+	EstadoLibroError     EstadoLibro = "ERROR"
+)
+
+// LibroCompraVentaXML representa el documento LibroCompraVenta (LibroCV_v10.xsd)
+type LibroCompraVentaXML struct {
+	XMLName    xml.Name      `xml:"LibroCompraVenta"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	Version    string        `xml:"version,attr"`
+	EnvioLibro EnvioLibroXML `xml:"EnvioLibro"`
+}
+
+// EnvioLibroXML representa el contenido firmado del libro
+type EnvioLibroXML struct {
+	ID             string             `xml:"ID,attr"`
+	Caratula       CaratulaLibroXML   `xml:"Caratula"`
+	ResumenPeriodo *ResumenPeriodoXML `xml:"ResumenPeriodo,omitempty"`
+	Detalle        []DetalleLibroXML  `xml:"Detalle,omitempty"`
+	TmstFirma      string             `xml:"TmstFirma"`
+}
+
+// CaratulaLibroXML representa la carátula del libro
+type CaratulaLibroXML struct {
+	RutEmisorLibro    string `xml:"RutEmisorLibro"`
+	RutEnvia          string `xml:"RutEnvia"`
+	PeriodoTributario string `xml:"PeriodoTributario"`
+	FchResol          string `xml:"FchResol"`
+	NroResol          int    `xml:"NroResol"`
+	TipoOperacion     string `xml:"TipoOperacion"`
+	TipoLibro         string `xml:"TipoLibro"`
+	TipoEnvio         string `xml:"TipoEnvio"`
+	NroSegmento       int    `xml:"NroSegmento,omitempty"`
+	FolioNotificacion int64  `xml:"FolioNotificacion,omitempty"`
+	CodAutRec         string `xml:"CodAutRec,omitempty"`
+}
+
+// ResumenPeriodoXML agrupa los totales del período por tipo de documento
+type ResumenPeriodoXML struct {
+	TotalesPeriodo []TotalesPeriodoXML `xml:"TotalesPeriodo"`
+}
+
+// TotalesPeriodoXML representa los totales de un tipo de documento en el período
+type TotalesPeriodoXML struct {
+	TpoDoc           int              `xml:"TpoDoc" json:"tpo_doc" bson:"tpo_doc"`
+	TpoImp           int              `xml:"TpoImp,omitempty" json:"tpo_imp,omitempty" bson:"tpo_imp,omitempty"`
+	TotDoc           int              `xml:"TotDoc" json:"tot_doc" bson:"tot_doc"`
+	TotAnulado       int              `xml:"TotAnulado,omitempty" json:"tot_anulado,omitempty" bson:"tot_anulado,omitempty"`
+	TotOpExe         int              `xml:"TotOpExe,omitempty" json:"tot_op_exe,omitempty" bson:"tot_op_exe,omitempty"`
+	TotMntExe        int64            `xml:"TotMntExe" json:"tot_mnt_exe" bson:"tot_mnt_exe"`
+	TotMntNeto       int64            `xml:"TotMntNeto" json:"tot_mnt_neto" bson:"tot_mnt_neto"`
+	TotOpIVARec      int              `xml:"TotOpIVARec,omitempty" json:"tot_op_iva_rec,omitempty" bson:"tot_op_iva_rec,omitempty"`
+	TotMntIVA        int64            `xml:"TotMntIVA" json:"tot_mnt_iva" bson:"tot_mnt_iva"`
+	TotOtrosImp      []TotOtrosImpXML `xml:"TotOtrosImp,omitempty" json:"tot_otros_imp,omitempty" bson:"tot_otros_imp,omitempty"`
+	TotOpIVARetTotal int              `xml:"TotOpIVARetTotal,omitempty" json:"tot_op_iva_ret_total,omitempty" bson:"tot_op_iva_ret_total,omitempty"`
+	TotIVARetTotal   int64            `xml:"TotIVARetTotal,omitempty" json:"tot_iva_ret_total,omitempty" bson:"tot_iva_ret_total,omitempty"`
+	TotMntTotal      int64            `xml:"TotMntTotal" json:"tot_mnt_total" bson:"tot_mnt_total"`
+}
+
+// TotOtrosImpXML representa el total de un impuesto adicional en el período
+type TotOtrosImpXML struct {
+	CodImp    string `xml:"CodImp" json:"cod_imp" bson:"cod_imp"`
+	TotMntImp int64  `xml:"TotMntImp" json:"tot_mnt_imp" bson:"tot_mnt_imp"`
+}
+
+// DetalleLibroXML representa el detalle de un documento dentro del libro
+type DetalleLibroXML struct {
+	TpoDoc      int                `xml:"TpoDoc"`
+	NroDoc      int64              `xml:"NroDoc"`
+	Anulado     string             `xml:"Anulado,omitempty"`
+	TasaImp     float64            `xml:"TasaImp,omitempty"`
+	FchDoc      string             `xml:"FchDoc"`
+	RUTDoc      string             `xml:"RUTDoc"`
+	RznSoc      string             `xml:"RznSoc,omitempty"`
+	MntExe      int64              `xml:"MntExe,omitempty"`
+	MntNeto     int64              `xml:"MntNeto,omitempty"`
+	MntIVA      int64              `xml:"MntIVA,omitempty"`
+	OtrosImp    []OtrosImpLibroXML `xml:"OtrosImp,omitempty"`
+	IVARetTotal int64              `xml:"IVARetTotal,omitempty"`
+	MntTotal    int64              `xml:"MntTotal"`
+}
+
+// OtrosImpLibroXML representa un impuesto adicional en el detalle del libro
+type OtrosImpLibroXML struct {
+	CodImp  string  `xml:"CodImp"`
+	TasaImp float64 `xml:"TasaImp"`
+	MntImp  int64   `xml:"MntImp"`
+}
+
+// LibroCompraVenta representa un libro de compra o venta generado y su seguimiento ante el SII
+type LibroCompraVenta struct {
+	ID                 string              `json:"id" bson:"_id,omitempty"`
+	RutEmisor          string              `json:"rut_emisor" bson:"rut_emisor"`
+	Periodo            string              `json:"periodo" bson:"periodo"`
+	TipoOperacion      string              `json:"tipo_operacion" bson:"tipo_operacion"`
+	TipoLibro          string              `json:"tipo_libro" bson:"tipo_libro"`
+	TipoEnvio          string              `json:"tipo_envio" bson:"tipo_envio"`
+	FolioNotificacion  int64               `json:"folio_notificacion,omitempty" bson:"folio_notificacion,omitempty"`
+	CantidadDocumentos int                 `json:"cantidad_documentos" bson:"cantidad_documentos"`
+	Totales            []TotalesPeriodoXML `json:"totales" bson:"totales"`
+	XML                string              `json:"xml,omitempty" bson:"xml"`
+	Estado             EstadoLibro         `json:"estado" bson:"estado"`
+	TrackID            string              `json:"track_id,omitempty" bson:"track_id,omitempty"`
+	Glosa              string              `json:"glosa,omitempty" bson:"glosa,omitempty"`
+	FechaGeneracion    time.Time           `json:"fecha_generacion" bson:"fecha_generacion"`
+	FechaEnvio         *time.Time          `json:"fecha_envio,omitempty" bson:"fecha_envio,omitempty"`
+	CreatedAt          time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt          time.Time           `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// AccionRegistro es una acción del Registro de Aceptación o Reclamo de un DTE (Ley 20.956)
+type AccionRegistro string
+
+// Acciones aceptadas por ingresarAceptacionReclamoDoc
+const (
+	AccionAceptaContenido     AccionRegistro = "ACD" // Acepta contenido del documento
+	AccionReclamoContenido    AccionRegistro = "RCD" // Reclamo al contenido del documento
+	AccionReciboMercaderias   AccionRegistro = "ERM" // Otorga recibo de mercaderías o servicios
+	AccionReclamoFaltaParcial AccionRegistro = "RFP" // Reclamo por falta parcial de mercaderías
+	AccionReclamoFaltaTotal   AccionRegistro = "RFT" // Reclamo por falta total de mercaderías
+)
+
+// EsValida indica si la acción puede registrarse en el SII
+func (a AccionRegistro) EsValida() bool {
+	switch a {
+	case AccionAceptaContenido, AccionReclamoContenido, AccionReciboMercaderias,
+		AccionReclamoFaltaParcial, AccionReclamoFaltaTotal:
+		return true
+	}
+	return false
+}
+
+// EsReclamo indica si la acción corresponde a un reclamo
+func (a AccionRegistro) EsReclamo() bool {
+	return a == AccionReclamoContenido || a == AccionReclamoFaltaParcial || a == AccionReclamoFaltaTotal
+}
+
+// Códigos de respuesta del servicio de registro de reclamos
+const (
+	CodigoRegistroOK           = 0  // Acción completada OK
+	CodigoEventoPrevio         = 7  // Evento registrado previamente
+	CodigoPlazoVencido         = 8  // Pasados 8 días no es posible registrar eventos
+	CodigoListadoEventos       = 15 // Listado de eventos del documento
+	CodigoSinEventos           = 16 // Documento no presenta eventos
+	CodigoCedibleSinReclamos   = 23 // DTE cedible, sin reclamos
+	CodigoNoCedibleReclamado   = 24 // DTE no cedible, reclamado por el receptor
+	CodigoCedibleAcusePresunto = 25 // DTE cedible, pasados 8 días se entiende dado el acuse
+)
+
+// RespuestaRegistroReclamo es la respuesta (codResp/descResp) de los métodos del servicio
+type RespuestaRegistroReclamo struct {
+	Codigo      int    `json:"codigo"`
+	Descripcion string `json:"descripcion"`
+}
+
+// EventoDTESII es un evento registrado en el SII sobre un documento
+type EventoDTESII struct {
+	CodEvento      string `json:"cod_evento" xml:"codEvento"`
+	DescEvento     string `json:"desc_evento" xml:"descEvento"`
+	RutResponsable string `json:"rut_responsable" xml:"rutResponsable"`
+	DvResponsable  string `json:"dv_responsable" xml:"dvResponsable"`
+	FechaEvento    string `json:"fecha_evento" xml:"fechaEvento"`
+}
+
+// Origen de un evento registrado
+const (
+	OrigenEventoPropio = "PROPIO" // Registrado por la empresa a través del gateway
+	OrigenEventoSII    = "SII"    // Registrado por otra parte y obtenido desde el SII
+)
+
+// EventoRegistroReclamo almacena un evento de aceptación o reclamo de un documento
+type EventoRegistroReclamo struct {
+	ID             string    `json:"id" bson:"_id"`
+	RutEmisor      string    `json:"rut_emisor" bson:"rut_emisor"`
+	TipoDTE        int       `json:"tipo_dte" bson:"tipo_dte"`
+	Folio          int64     `json:"folio" bson:"folio"`
+	DocumentoID    string    `json:"documento_id,omitempty" bson:"documento_id,omitempty"`
+	CodEvento      string    `json:"cod_evento" bson:"cod_evento"`
+	DescEvento     string    `json:"desc_evento" bson:"desc_evento"`
+	RutResponsable string    `json:"rut_responsable" bson:"rut_responsable"`
+	FechaEvento    string    `json:"fecha_evento" bson:"fecha_evento"`
+	Origen         string    `json:"origen" bson:"origen"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// DetalleEstadoSII es el estado informado por el SII que se adjunta al documento cuando el
+// seguimiento de su envío termina
+type DetalleEstadoSII struct {
+	TrackID       string              `json:"track_id" bson:"track_id"`
+	EstadoEnvio   EstadoConsultaEnvio `json:"estado_envio" bson:"estado_envio"`
+	GlosaEnvio    string              `json:"glosa_envio" bson:"glosa_envio"`
+	Resultado     ResultadoEnvio      `json:"resultado" bson:"resultado"`
+	Resumen       *ResumenEstadoEnvio `json:"resumen,omitempty" bson:"resumen,omitempty"` // Resumen del tipo del documento
+	EstadoDTE     EstadoConsultaDTE   `json:"estado_dte,omitempty" bson:"estado_dte,omitempty"`
+	GlosaDTE      string              `json:"glosa_dte,omitempty" bson:"glosa_dte,omitempty"`
+	NumAtencion   string              `json:"num_atencion,omitempty" bson:"num_atencion,omitempty"`
+	FechaConsulta time.Time           `json:"fecha_consulta" bson:"fecha_consulta"`
+}
+
+// EstadoSeguimiento es el estado del seguimiento de un TrackID
+type EstadoSeguimiento string
+
+// Estados del seguimiento de un TrackID
+const (
+	EstadoSeguimientoPendiente  EstadoSeguimiento = "PENDIENTE"  // Se sigue consultando
+	EstadoSeguimientoFinalizado EstadoSeguimiento = "FINALIZADO" // Documentos con estado final
+	EstadoSeguimientoAbandonado EstadoSeguimiento = "ABANDONADO" // Sin estado final dentro del horizonte
+)
+
+// DocumentoSeguimiento identifica un documento del envío seguido, con los datos que pide
+// QueryEstDte
+type DocumentoSeguimiento struct {
+	ID           string    `json:"id" bson:"id"`
+	TipoDTE      TipoDTE   `json:"tipo_dte" bson:"tipo_dte"`
+	Folio        int64     `json:"folio" bson:"folio"`
+	RutReceptor  string    `json:"rut_receptor" bson:"rut_receptor"`
+	FechaEmision time.Time `json:"fecha_emision" bson:"fecha_emision"`
+	MontoTotal   int64     `json:"monto_total" bson:"monto_total"`
+	Estado       EstadoDTE `json:"estado" bson:"estado"`
+}
+
+// SeguimientoEnvio registra las consultas de estado de un TrackID hasta que sus documentos
+// quedan aceptados o rechazados
+type SeguimientoEnvio struct {
+	ID              string                 `json:"id" bson:"_id"`
+	TrackID         string                 `json:"track_id" bson:"track_id"`
+	RutEmpresa      string                 `json:"rut_empresa" bson:"rut_empresa"`
+	RutEnvia        string                 `json:"rut_envia,omitempty" bson:"rut_envia,omitempty"`
+	Ambiente        AmbienteSII            `json:"ambiente,omitempty" bson:"ambiente,omitempty"` // Ambiente en que se hizo el envío
+	Documentos      []DocumentoSeguimiento `json:"documentos" bson:"documentos"`
+	Estado          EstadoSeguimiento      `json:"estado" bson:"estado"`
+	Intentos        int                    `json:"intentos" bson:"intentos"`
+	Enviado         time.Time              `json:"enviado" bson:"enviado"`
+	UltimaConsulta  time.Time              `json:"ultima_consulta,omitempty" bson:"ultima_consulta,omitempty"`
+	ProximaConsulta time.Time              `json:"proxima_consulta" bson:"proxima_consulta"`
+	UltimaRespuesta *RespuestaEstadoEnvio  `json:"ultima_respuesta,omitempty" bson:"ultima_respuesta,omitempty"`
+	Resultado       ResultadoEnvio         `json:"resultado,omitempty" bson:"resultado,omitempty"`
+	Error           string                 `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt       time.Time              `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at" bson:"updated_at"`
+}
@@ -67,6 +67,18 @@ type FirmaDigital struct {
 	FechaModificacion time.Time `json:"fecha_modificacion" bson:"fecha_modificacion"`
 }
 
+// VerificacionFirmaXML representa el resultado de verificar un nodo Signature de un documento XML
+type VerificacionFirmaXML struct {
+	Firma        string    `json:"firma,omitempty"`
+	Ruta         string    `json:"ruta"`
+	Valida       bool      `json:"valida"`
+	Error        string    `json:"error,omitempty"`
+	Referencias  []string  `json:"referencias,omitempty"`
+	Firmante     string    `json:"firmante,omitempty"`
+	NumeroSerie  string    `json:"numero_serie,omitempty"`
+	VigenteHasta time.Time `json:"vigente_hasta,omitempty"`
+}
+
 // DatosEncriptados representa datos sensibles encriptados
 type DatosEncriptados struct {
 	ID                string    `json:"id" bson:"_id"`
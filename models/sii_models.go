@@ -2,6 +2,9 @@ package models
 
 import "time"
 
+// NamespaceSII es el namespace de los documentos tributarios electrónicos del SII
+const NamespaceSII = "http://www.sii.cl/SiiDte"
+
 // ErrorSII representa un error del SII en la respuesta
 type ErrorSII struct {
 	Codigo      string `xml:"Codigo" json:"codigo"`
@@ -18,3 +21,13 @@ type RespuestaSII struct {
 	FechaProceso time.Time  `xml:"FECHA_PROCESO" json:"fecha_proceso"`
 	Errores      []ErrorSII `xml:"ERRORES>ERROR,omitempty" json:"errores,omitempty"`
 }
+
+// RespuestaUploadSII representa la respuesta RECEPCIONDTE de DTEUpload (o RECEPCIONAEC del registro de cesiones)
+type RespuestaUploadSII struct {
+	RutSender  string `xml:"RUTSENDER" json:"rut_sender"`
+	RutCompany string `xml:"RUTCOMPANY" json:"rut_company"`
+	File       string `xml:"FILE" json:"file"`
+	Timestamp  string `xml:"TIMESTAMP" json:"timestamp"`
+	Status     string `xml:"STATUS" json:"status"`
+	TrackID    string `xml:"TRACKID" json:"track_id"`
+}
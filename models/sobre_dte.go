@@ -14,6 +14,8 @@ type SetDTE struct {
 	ID       string        `xml:"ID,attr"`
 	Caratula *Caratula     `xml:"Caratula"`
 	DTEs     []DTEXMLModel `xml:"DTE"`
+	// Exportaciones son los DTE 110, 111 y 112, que también se escriben como DTE
+	Exportaciones []DTEExportacionXML `xml:",any"`
 }
 
 // Caratula representa la información de caratula del envío
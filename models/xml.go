@@ -17,20 +17,59 @@ type DTEXMLModel struct {
 
 // DocumentoXMLModel representa un documento en XML
 type DocumentoXMLModel struct {
-	XMLName     xml.Name             `xml:"Documento"`
-	ID          string               `xml:"ID,attr,omitempty"`
-	Encabezado  EncabezadoXMLModel   `xml:"Encabezado"`
-	Detalle     []DetalleXML         `xml:"Detalle"`
-	Referencias []ReferenciaXMLModel `xml:"Referencias>Referencia,omitempty"`
+	XMLName      xml.Name             `xml:"Documento"`
+	ID           string               `xml:"ID,attr,omitempty"`
+	Encabezado   EncabezadoXMLModel   `xml:"Encabezado"`
+	Detalle      []DetalleXML         `xml:"Detalle"`
+	DscRcgGlobal []DscRcgGlobalXML    `xml:"DscRcgGlobal,omitempty"`
+	Referencias  []ReferenciaXMLModel `xml:"Referencia,omitempty"`
+	TED          *TEDXMLModel         `xml:"TED,omitempty"`
+	TmstFirma    string               `xml:"TmstFirma,omitempty"`
+}
+
+// DscRcgGlobalXML representa un descuento o recargo global del documento
+type DscRcgGlobalXML struct {
+	NroLinDR int     `xml:"NroLinDR"`
+	TpoMov   string  `xml:"TpoMov"`
+	GlosaDR  string  `xml:"GlosaDR,omitempty"`
+	TpoValor string  `xml:"TpoValor"`
+	ValorDR  float64 `xml:"ValorDR"`
+	IndExeDR int     `xml:"IndExeDR,omitempty"`
+}
+
+// TEDXMLModel representa el timbre electrónico del documento. El contenido se conserva tal
+// como fue generado para no invalidar la firma FRMT.
+type TEDXMLModel struct {
+	XMLName   xml.Name `xml:"TED"`
+	Version   string   `xml:"version,attr"`
+	Contenido string   `xml:",innerxml"`
 }
 
 // EncabezadoXMLModel representa el encabezado de un documento
 type EncabezadoXMLModel struct {
-	XMLName  xml.Name       `xml:"Encabezado"`
-	IdDoc    IDDocumentoXML `xml:"IdDoc"`
-	Emisor   EmisorXML      `xml:"Emisor"`
-	Receptor ReceptorXML    `xml:"Receptor"`
-	Totales  TotalesXML     `xml:"Totales"`
+	XMLName    xml.Name       `xml:"Encabezado"`
+	IdDoc      IDDocumentoXML `xml:"IdDoc"`
+	Emisor     EmisorXML      `xml:"Emisor"`
+	Receptor   ReceptorXML    `xml:"Receptor"`
+	Transporte *TransporteXML `xml:"Transporte,omitempty"`
+	Totales    TotalesXML     `xml:"Totales"`
+}
+
+// TransporteXML representa los datos del transporte de una guía de despacho
+type TransporteXML struct {
+	XMLName    xml.Name   `xml:"Transporte"`
+	Patente    string     `xml:"Patente,omitempty"`
+	RUTTrans   string     `xml:"RUTTrans,omitempty"`
+	Chofer     *ChoferXML `xml:"Chofer,omitempty"`
+	DirDest    string     `xml:"DirDest,omitempty"`
+	CmnaDest   string     `xml:"CmnaDest,omitempty"`
+	CiudadDest string     `xml:"CiudadDest,omitempty"`
+}
+
+// ChoferXML identifica al chofer del vehículo que transporta la mercadería
+type ChoferXML struct {
+	RUTChofer    string `xml:"RUTChofer"`
+	NombreChofer string `xml:"NombreChofer"`
 }
 
 // IDDocumentoXML representa la identificación del documento
@@ -40,6 +79,7 @@ type IDDocumentoXML struct {
 	Folio             int      `xml:"Folio"`
 	FechaEmision      string   `xml:"FchEmis"`
 	TipoDespacho      string   `xml:"TipoDespacho,omitempty"`
+	IndTraslado       string   `xml:"IndTraslado,omitempty"`
 	IndicadorServicio int      `xml:"IndServicio,omitempty"`
 }
 
@@ -71,12 +111,14 @@ type ReceptorXML struct {
 
 // TotalesXML representa los totales del documento
 type TotalesXML struct {
-	XMLName     xml.Name `xml:"Totales"`
-	MntNeto     *int64   `xml:"MntNeto,omitempty"`
-	MontoExento int      `xml:"MntExe,omitempty"`
-	TasaIVA     *float64 `xml:"TasaIVA,omitempty"`
-	IVA         *int64   `xml:"IVA,omitempty"`
-	MntTotal    int64    `xml:"MntTotal"`
+	XMLName     xml.Name      `xml:"Totales"`
+	MntNeto     *int64        `xml:"MntNeto,omitempty"`
+	MontoExento int           `xml:"MntExe,omitempty"`
+	TasaIVA     *float64      `xml:"TasaIVA,omitempty"`
+	IVA         *int64        `xml:"IVA,omitempty"`
+	ImptoReten  []ImpuestoXML `xml:"ImptoReten,omitempty"`
+	IVANoRet    *int64        `xml:"IVANoRet,omitempty"`
+	MntTotal    int64         `xml:"MntTotal"`
 }
 
 // DetalleXML representa un detalle de producto o servicio
@@ -86,13 +128,15 @@ type DetalleXML struct {
 	TipoDocumento  string        `xml:"TpoDocLiq,omitempty"`
 	Codigo         string        `xml:"CdgItem>TpoCodigo,omitempty"`
 	ValorCodigo    string        `xml:"CdgItem>VlrCodigo,omitempty"`
+	IndExe         int           `xml:"IndExe,omitempty"`
 	Nombre         string        `xml:"NmbItem"`
 	Descripcion    *string       `xml:"DscItem,omitempty"`
 	Cantidad       *float64      `xml:"QtyItem,omitempty"`
 	UnidadMedida   string        `xml:"UnmdItem,omitempty"`
 	Precio         *float64      `xml:"PrcItem,omitempty"`
-	Descuento      float64       `xml:"DescuentoMonto,omitempty"`
 	PorcentajeDesc float64       `xml:"DescuentoPct,omitempty"`
+	Descuento      float64       `xml:"DescuentoMonto,omitempty"`
+	CodImpAdic     string        `xml:"CodImpAdic,omitempty"`
 	MontoItem      int64         `xml:"MontoItem"`
 	Impuestos      []ImpuestoXML `xml:"ImptoReten,omitempty"`
 }
@@ -108,6 +152,7 @@ type ImpuestoXML struct {
 // ReferenciaXMLModel representa una referencia a otro documento
 type ReferenciaXMLModel struct {
 	XMLName    xml.Name `xml:"Referencia"`
+	NroLinRef  int      `xml:"NroLinRef"`
 	TipoDocRef string   `xml:"TpoDocRef"`
 	FolioRef   string   `xml:"FolioRef"`
 	FechaRef   string   `xml:"FchRef"`
@@ -3,6 +3,8 @@ package repository
 import (
 	"fmt"
 	"time"
+
+	"github.com/cursor/FMgo/models"
 )
 
 // ValidationError representa un error de validación en un modelo
@@ -26,17 +28,18 @@ func NewValidationError(field, message string) *ValidationError {
 
 // Empresa representa una empresa en el sistema
 type Empresa struct {
-	ID          string    `json:"id" db:"id"`
-	Nombre      string    `json:"nombre" db:"nombre"`
-	RUT         string    `json:"rut" db:"rut"`
-	Direccion   string    `json:"direccion" db:"direccion"`
-	Telefono    string    `json:"telefono" db:"telefono"`
-	Email       string    `json:"email" db:"email"`
-	RUTFirma    string    `json:"rut_firma" db:"rut_firma"`
-	NombreFirma string    `json:"nombre_firma" db:"nombre_firma"`
-	ClaveFirma  string    `json:"clave_firma" db:"clave_firma"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID          string             `json:"id" db:"id"`
+	Nombre      string             `json:"nombre" db:"nombre"`
+	RUT         string             `json:"rut" db:"rut"`
+	Direccion   string             `json:"direccion" db:"direccion"`
+	Telefono    string             `json:"telefono" db:"telefono"`
+	Email       string             `json:"email" db:"email"`
+	RUTFirma    string             `json:"rut_firma" db:"rut_firma"`
+	NombreFirma string             `json:"nombre_firma" db:"nombre_firma"`
+	ClaveFirma  string             `json:"clave_firma" db:"clave_firma"`
+	Ambiente    models.AmbienteSII `json:"ambiente" db:"ambiente"` // Ambiente del SII en que emite la empresa
+	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" db:"updated_at"`
 }
 
 // NewEmpresa crea una nueva instancia de Empresa
@@ -50,6 +53,7 @@ func NewEmpresa(nombre, rut, direccion, telefono, email, rutFirma, nombreFirma,
 		RUTFirma:    rutFirma,
 		NombreFirma: nombreFirma,
 		ClaveFirma:  claveFirma,
+		Ambiente:    models.AmbienteCertificacion,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
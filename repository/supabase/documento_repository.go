@@ -518,5 +518,9 @@ func mapToDocumentoTributario(data map[string]interface{}) (*models.DocumentoTri
 		doc.TrackID = trackID
 	}
 
+	if ambiente, ok := data["ambiente"].(string); ok {
+		doc.Ambiente = models.AmbienteSII(ambiente)
+	}
+
 	return doc, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/cursor/FMgo/models"
 	"github.com/cursor/FMgo/supabase"
 )
 
@@ -35,6 +36,7 @@ func (r *SupabaseRepository) CreateEmpresa(ctx context.Context, empresa *Empresa
 		"rut_firma":    empresa.RUTFirma,
 		"nombre_firma": empresa.NombreFirma,
 		"clave_firma":  empresa.ClaveFirma,
+		"ambiente":     empresa.Ambiente,
 	}
 
 	// Insertar registro
@@ -54,6 +56,7 @@ func (r *SupabaseRepository) CreateEmpresa(ctx context.Context, empresa *Empresa
 		RUTFirma:    fmt.Sprintf("%v", result["rut_firma"]),
 		NombreFirma: fmt.Sprintf("%v", result["nombre_firma"]),
 		ClaveFirma:  fmt.Sprintf("%v", result["clave_firma"]),
+		Ambiente:    models.AmbienteSII(fmt.Sprintf("%v", result["ambiente"])),
 	}
 
 	return empresaInsertada, nil
@@ -78,6 +81,7 @@ func (r *SupabaseRepository) GetEmpresaByID(ctx context.Context, id string) (*Em
 		RUTFirma:    fmt.Sprintf("%v", result["rut_firma"]),
 		NombreFirma: fmt.Sprintf("%v", result["nombre_firma"]),
 		ClaveFirma:  fmt.Sprintf("%v", result["clave_firma"]),
+		Ambiente:    models.AmbienteSII(fmt.Sprintf("%v", result["ambiente"])),
 	}
 
 	return empresa, nil
@@ -112,6 +116,7 @@ func (r *SupabaseRepository) GetEmpresaByRUT(ctx context.Context, rut string) (*
 		RUTFirma:    fmt.Sprintf("%v", result["rut_firma"]),
 		NombreFirma: fmt.Sprintf("%v", result["nombre_firma"]),
 		ClaveFirma:  fmt.Sprintf("%v", result["clave_firma"]),
+		Ambiente:    models.AmbienteSII(fmt.Sprintf("%v", result["ambiente"])),
 	}
 
 	return empresa, nil
@@ -134,6 +139,7 @@ func (r *SupabaseRepository) UpdateEmpresa(ctx context.Context, empresa *Empresa
 		"rut_firma":    empresa.RUTFirma,
 		"nombre_firma": empresa.NombreFirma,
 		"clave_firma":  empresa.ClaveFirma,
+		"ambiente":     empresa.Ambiente,
 	}
 
 	// Actualizar registro
@@ -153,6 +159,7 @@ func (r *SupabaseRepository) UpdateEmpresa(ctx context.Context, empresa *Empresa
 		RUTFirma:    fmt.Sprintf("%v", result["rut_firma"]),
 		NombreFirma: fmt.Sprintf("%v", result["nombre_firma"]),
 		ClaveFirma:  fmt.Sprintf("%v", result["clave_firma"]),
+		Ambiente:    models.AmbienteSII(fmt.Sprintf("%v", result["ambiente"])),
 	}
 
 	return empresaActualizada, nil
@@ -190,6 +197,7 @@ func (r *SupabaseRepository) ListEmpresas(ctx context.Context, limit int) ([]*Em
 			RUTFirma:    fmt.Sprintf("%v", result["rut_firma"]),
 			NombreFirma: fmt.Sprintf("%v", result["nombre_firma"]),
 			ClaveFirma:  fmt.Sprintf("%v", result["clave_firma"]),
+			Ambiente:    models.AmbienteSII(fmt.Sprintf("%v", result["ambiente"])),
 		}
 		empresas = append(empresas, empresa)
 	}
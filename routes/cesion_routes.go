@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/cursor/FMgo/controllers"
+	"github.com/cursor/FMgo/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupCesionRoutes configura las rutas para la cesión de facturas electrónicas
+func SetupCesionRoutes(router *gin.Engine, cesionController *controllers.CesionController) {
+	cesiones := router.Group("/api/facturas")
+	{
+		cesiones.Use(middleware.AuthMiddleware("admin"))
+		cesiones.Use(middleware.RateLimitMiddleware(20, time.Minute))
+
+		cesiones.POST("/:id/ceder", cesionController.CederFactura)
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/cursor/FMgo/controllers"
+	"github.com/cursor/FMgo/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupGuiasRoutes configura las rutas de las guías de despacho
+func SetupGuiasRoutes(router *gin.Engine, guiasController *controllers.GuiasController) {
+	guias := router.Group("/api/guias")
+	{
+		guias.Use(middleware.AuthMiddleware("admin"))
+		guias.Use(middleware.RateLimitMiddleware(20, time.Minute))
+
+		guias.POST("/facturar", guiasController.FacturarGuias)
+	}
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/cursor/FMgo/controllers"
+	"github.com/cursor/FMgo/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupIntercambioRoutes configura las rutas para el intercambio de DTE con proveedores
+func SetupIntercambioRoutes(router *gin.Engine, intercambioController *controllers.IntercambioController) {
+	intercambios := router.Group("/api/intercambio")
+	{
+		intercambios.Use(middleware.AuthMiddleware("admin"))
+		intercambios.Use(middleware.RateLimitMiddleware(60, time.Minute))
+
+		intercambios.POST("/envios", intercambioController.RecibirEnvio)
+		intercambios.POST("/:id/resultado", intercambioController.ResponderDocumentos)
+		intercambios.GET("/:id/respuestas/:tipo", intercambioController.DescargarRespuesta)
+
+		intercambios.POST("/documentos/:id/acuse", intercambioController.RegistrarAcuse)
+		intercambios.GET("/acuses/:id", intercambioController.ObtenerAcuse)
+		intercambios.GET("/acuses/:id/xml", intercambioController.DescargarAcuse)
+	}
+}
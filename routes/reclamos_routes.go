@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/cursor/FMgo/controllers"
+	"github.com/cursor/FMgo/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupReclamosRoutes configura las rutas del registro de aceptación o reclamo de DTE
+func SetupReclamosRoutes(router *gin.Engine, reclamosController *controllers.ReclamosController) {
+	reclamos := router.Group("/api/reclamos")
+	{
+		reclamos.Use(middleware.AuthMiddleware("admin"))
+		reclamos.Use(middleware.RateLimitMiddleware(60, time.Minute))
+
+		reclamos.POST("/documentos/:id/accion", reclamosController.RegistrarAccion)
+		reclamos.GET("/eventos/:rut/:tipo/:folio", reclamosController.ObtenerEventos)
+	}
+}
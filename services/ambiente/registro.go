@@ -0,0 +1,165 @@
+// Package ambiente registra las URLs de los servicios del SII de cada ambiente (certificación y
+// producción) y el ambiente en que opera cada empresa, de modo que una misma instancia del
+// gateway atienda empresas de ambos ambientes sin enviar documentos al ambiente equivocado.
+package ambiente
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// ErrEmpresaDesconocida indica que la empresa no tiene un ambiente registrado
+var ErrEmpresaDesconocida = errors.New("empresa sin ambiente SII registrado")
+
+// Endpoints agrupa las URLs de los servicios del SII de un ambiente
+type Endpoints struct {
+	Semilla        string // CrSeed.jws
+	Token          string // GetTokenFromSeed.jws
+	UploadDTE      string // DTEUpload
+	EstadoEnvio    string // QueryEstUp.jws
+	EstadoDTE      string // QueryEstDte.jws
+	BoletaAPI      string // Semilla, token y consultas de boleta electrónica (REST)
+	BoletaEnvio    string // Recepción de envíos de boletas (REST)
+	RCV            string // Registro de aceptación o reclamo de DTE del Registro de Compras y Ventas
+	UploadCesion   string // RTCAnotEnvio.cgi
+	ConsultaCesion string // wsRPETCConsulta
+}
+
+// URLs de los servicios del SII en certificación (maullin) y producción (palena)
+var (
+	Certificacion = Endpoints{
+		Semilla:        "https://maullin.sii.cl/DTEWS/CrSeed.jws",
+		Token:          "https://maullin.sii.cl/DTEWS/GetTokenFromSeed.jws",
+		UploadDTE:      "https://maullin.sii.cl/cgi_dte/UPL/DTEUpload",
+		EstadoEnvio:    "https://maullin.sii.cl/DTEWS/QueryEstUp.jws",
+		EstadoDTE:      "https://maullin.sii.cl/DTEWS/QueryEstDte.jws",
+		BoletaAPI:      "https://apicert.sii.cl/recursos/v1",
+		BoletaEnvio:    "https://pangal.sii.cl/recursos/v1",
+		RCV:            "https://ws2.sii.cl/WSREGISTRORECLAMODTECERT/registroreclamodteservice",
+		UploadCesion:   "https://maullin.sii.cl/cgi_rtc/RTC/RTCAnotEnvio.cgi",
+		ConsultaCesion: "https://maullin.sii.cl/DTEWS/services/wsRPETCConsulta",
+	}
+	Produccion = Endpoints{
+		Semilla:        "https://palena.sii.cl/DTEWS/CrSeed.jws",
+		Token:          "https://palena.sii.cl/DTEWS/GetTokenFromSeed.jws",
+		UploadDTE:      "https://palena.sii.cl/cgi_dte/UPL/DTEUpload",
+		EstadoEnvio:    "https://palena.sii.cl/DTEWS/QueryEstUp.jws",
+		EstadoDTE:      "https://palena.sii.cl/DTEWS/QueryEstDte.jws",
+		BoletaAPI:      "https://api.sii.cl/recursos/v1",
+		BoletaEnvio:    "https://rahue.sii.cl/recursos/v1",
+		RCV:            "https://ws1.sii.cl/WSREGISTRORECLAMODTE/registroreclamodteservice",
+		UploadCesion:   "https://palena.sii.cl/cgi_rtc/RTC/RTCAnotEnvio.cgi",
+		ConsultaCesion: "https://palena.sii.cl/DTEWS/services/wsRPETCConsulta",
+	}
+)
+
+// EndpointsAmbiente retorna las URLs oficiales del ambiente indicado
+func EndpointsAmbiente(ambiente models.AmbienteSII) (Endpoints, error) {
+	switch ambiente {
+	case models.AmbienteCertificacion:
+		return Certificacion, nil
+	case models.AmbienteProduccion:
+		return Produccion, nil
+	}
+	return Endpoints{}, fmt.Errorf("ambiente SII desconocido: %q", ambiente)
+}
+
+// Registro asocia cada empresa con su ambiente y cada ambiente con sus URLs. Es seguro para uso
+// concurrente, de modo que las empresas pueden registrarse o cambiar de ambiente en caliente.
+type Registro struct {
+	mu        sync.RWMutex
+	endpoints map[models.AmbienteSII]Endpoints
+	empresas  map[string]models.AmbienteSII
+}
+
+// NewRegistro crea un registro con las URLs oficiales de ambos ambientes y sin empresas
+func NewRegistro() *Registro {
+	return &Registro{
+		endpoints: map[models.AmbienteSII]Endpoints{
+			models.AmbienteCertificacion: Certificacion,
+			models.AmbienteProduccion:    Produccion,
+		},
+		empresas: make(map[string]models.AmbienteSII),
+	}
+}
+
+// SetEndpoints reemplaza las URLs de un ambiente, por ejemplo para apuntarlo al simulador
+func (r *Registro) SetEndpoints(ambiente models.AmbienteSII, endpoints Endpoints) error {
+	if !ambiente.Valido() {
+		return fmt.Errorf("ambiente SII desconocido: %q", ambiente)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endpoints[ambiente] = endpoints
+	return nil
+}
+
+// Endpoints retorna las URLs registradas para el ambiente
+func (r *Registro) Endpoints(ambiente models.AmbienteSII) (Endpoints, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	endpoints, ok := r.endpoints[ambiente]
+	if !ok {
+		return Endpoints{}, fmt.Errorf("ambiente SII desconocido: %q", ambiente)
+	}
+	return endpoints, nil
+}
+
+// RegistrarEmpresa registra el ambiente de la empresa. Una empresa sin ambiente se rechaza en
+// lugar de asumir uno, para no enviar sus documentos al ambiente equivocado.
+func (r *Registro) RegistrarEmpresa(empresa *models.Empresa) error {
+	if empresa == nil {
+		return errors.New("empresa nula")
+	}
+	return r.AsignarAmbiente(empresa.RUT, empresa.Ambiente)
+}
+
+// Cargar registra el ambiente de todas las empresas; se detiene en la primera inválida
+func (r *Registro) Cargar(empresas []*models.Empresa) error {
+	for _, empresa := range empresas {
+		if err := r.RegistrarEmpresa(empresa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AsignarAmbiente fija el ambiente de la empresa con el RUT indicado
+func (r *Registro) AsignarAmbiente(rutEmpresa string, ambiente models.AmbienteSII) error {
+	clave := utils.NormalizarRUT(rutEmpresa)
+	if clave == "" {
+		return errors.New("RUT de empresa vacío")
+	}
+	if !ambiente.Valido() {
+		return fmt.Errorf("ambiente SII inválido para la empresa %s: %q", rutEmpresa, ambiente)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.empresas[clave] = ambiente
+	return nil
+}
+
+// AmbienteEmpresa retorna el ambiente de la empresa o un error que envuelve
+// ErrEmpresaDesconocida si no está registrada
+func (r *Registro) AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ambiente, ok := r.empresas[utils.NormalizarRUT(rutEmpresa)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrEmpresaDesconocida, rutEmpresa)
+	}
+	return ambiente, nil
+}
+
+// EndpointsEmpresa retorna las URLs del ambiente de la empresa
+func (r *Registro) EndpointsEmpresa(rutEmpresa string) (Endpoints, error) {
+	ambiente, err := r.AmbienteEmpresa(rutEmpresa)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	return r.Endpoints(ambiente)
+}
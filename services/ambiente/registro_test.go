@@ -0,0 +1,85 @@
+package ambiente
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointsAmbiente(t *testing.T) {
+	certificacion, err := EndpointsAmbiente(models.AmbienteCertificacion)
+	require.NoError(t, err)
+	produccion, err := EndpointsAmbiente(models.AmbienteProduccion)
+	require.NoError(t, err)
+
+	assert.Contains(t, certificacion.UploadDTE, "maullin.sii.cl")
+	assert.Contains(t, produccion.UploadDTE, "palena.sii.cl")
+	assert.Contains(t, certificacion.RCV, "WSREGISTRORECLAMODTECERT")
+	assert.Equal(t, "https://api.sii.cl/recursos/v1", produccion.BoletaAPI)
+
+	_, err = EndpointsAmbiente("desarrollo")
+	assert.Error(t, err)
+}
+
+func TestParseAmbienteSII(t *testing.T) {
+	for valor, esperado := range map[string]models.AmbienteSII{
+		"certificacion": models.AmbienteCertificacion,
+		"Certificación": models.AmbienteCertificacion,
+		"maullin":       models.AmbienteCertificacion,
+		"production":    models.AmbienteProduccion,
+		" PRODUCCION ":  models.AmbienteProduccion,
+		"palena":        models.AmbienteProduccion,
+	} {
+		ambiente, err := models.ParseAmbienteSII(valor)
+		require.NoError(t, err, valor)
+		assert.Equal(t, esperado, ambiente, valor)
+	}
+	_, err := models.ParseAmbienteSII("")
+	assert.Error(t, err)
+}
+
+func TestRegistroEmpresas(t *testing.T) {
+	registro := NewRegistro()
+	require.NoError(t, registro.Cargar([]*models.Empresa{
+		{RUT: "76.212.889-6", Ambiente: models.AmbienteCertificacion},
+		{RUT: "77888999-k", Ambiente: models.AmbienteProduccion},
+	}))
+
+	ambiente, err := registro.AmbienteEmpresa("76212889-6")
+	require.NoError(t, err)
+	assert.Equal(t, models.AmbienteCertificacion, ambiente)
+
+	endpoints, err := registro.EndpointsEmpresa("77.888.999-K")
+	require.NoError(t, err)
+	assert.Equal(t, Produccion, endpoints)
+
+	// Una empresa sin ambiente no se asume en ninguno
+	_, err = registro.EndpointsEmpresa("11111111-1")
+	assert.True(t, errors.Is(err, ErrEmpresaDesconocida))
+	assert.Error(t, registro.RegistrarEmpresa(&models.Empresa{RUT: "11111111-1"}))
+
+	// Cambio de ambiente tras aprobar la certificación
+	require.NoError(t, registro.AsignarAmbiente("76212889-6", models.AmbienteProduccion))
+	ambiente, err = registro.AmbienteEmpresa("76212889-6")
+	require.NoError(t, err)
+	assert.Equal(t, models.AmbienteProduccion, ambiente)
+}
+
+func TestRegistroSetEndpoints(t *testing.T) {
+	registro := NewRegistro()
+	local := Endpoints{Semilla: "http://localhost:8089/DTEWS/CrSeed.jws"}
+	require.NoError(t, registro.SetEndpoints(models.AmbienteCertificacion, local))
+	require.NoError(t, registro.AsignarAmbiente("76212889-6", models.AmbienteCertificacion))
+
+	endpoints, err := registro.EndpointsEmpresa("76212889-6")
+	require.NoError(t, err)
+	assert.Equal(t, local, endpoints)
+
+	produccion, err := registro.Endpoints(models.AmbienteProduccion)
+	require.NoError(t, err)
+	assert.Equal(t, Produccion, produccion)
+	assert.Error(t, registro.SetEndpoints("desarrollo", local))
+}
@@ -0,0 +1,242 @@
+package boleta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/cursor/FMgo/utils"
+)
+
+// userAgent es el User-Agent que el SII exige en los servicios de boleta
+const userAgent = "Mozilla/4.0 ( compatible; PROG 1.0; Windows NT)"
+
+// descripcionesEstado describe los estados de un envío de boletas
+var descripcionesEstado = map[string]string{
+	models.EstadoEnvioBoletaRecibido:   "Envío recibido",
+	models.EstadoEnvioBoletaProcesado:  "Envío procesado",
+	models.EstadoEnvioBoletaReparos:    "Envío aceptado con reparos",
+	models.EstadoEnvioBoletaRechazado:  "Envío rechazado",
+	models.EstadoEnvioBoletaRechFirma:  "Envío rechazado por error en la firma",
+	models.EstadoEnvioBoletaRechSchema: "Envío rechazado por error de schema",
+}
+
+// DescripcionEstado retorna la glosa de un estado de envío de boletas
+func DescripcionEstado(estado string) string {
+	if descripcion, ok := descripcionesEstado[estado]; ok {
+		return descripcion
+	}
+	return "Estado desconocido"
+}
+
+// Endpoints agrupa los hosts de los servicios REST de boleta electrónica de un ambiente
+type Endpoints struct {
+	API   string // Semilla, token y consultas de estado
+	Envio string // Recepción de envíos
+}
+
+// Hosts de certificación y producción de los servicios de boleta
+var (
+	EndpointsCertificacion = NewEndpoints(ambiente.Certificacion)
+	EndpointsProduccion    = NewEndpoints(ambiente.Produccion)
+)
+
+// NewEndpoints toma los hosts de boleta de las URLs de un ambiente del registro
+func NewEndpoints(endpoints ambiente.Endpoints) Endpoints {
+	return Endpoints{API: endpoints.BoletaAPI, Envio: endpoints.BoletaEnvio}
+}
+
+// EndpointsAmbiente retorna los hosts del ambiente indicado (certificacion por defecto)
+func EndpointsAmbiente(nombre string) Endpoints {
+	if a, err := models.ParseAmbienteSII(nombre); err == nil && a == models.AmbienteProduccion {
+		return EndpointsProduccion
+	}
+	return EndpointsCertificacion
+}
+
+// URLSemilla retorna la URL del servicio boleta.electronica.semilla
+func (e Endpoints) URLSemilla() string {
+	return e.API + "/boleta.electronica.semilla"
+}
+
+// URLToken retorna la URL del servicio boleta.electronica.token
+func (e Endpoints) URLToken() string {
+	return e.API + "/boleta.electronica.token"
+}
+
+// Tokens ejecuta operaciones con el token vigente de la empresa, renovándolo si el SII lo rechaza
+type Tokens interface {
+	ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error
+}
+
+// Cliente envía boletas 39/41 y consulta su estado en los servicios REST del SII
+type Cliente struct {
+	client    *http.Client
+	endpoints Endpoints
+	tokens    Tokens
+}
+
+// NewCliente crea un cliente para los hosts indicados. Los tokens deben obtenerse de los
+// servicios de boleta (token.AutenticadorREST), no de los servicios SOAP de DTE.
+func NewCliente(endpoints Endpoints, tokens Tokens, timeout time.Duration) *Cliente {
+	return &Cliente{
+		client:    &http.Client{Timeout: timeout},
+		endpoints: endpoints,
+		tokens:    tokens,
+	}
+}
+
+// Enviar sube un EnvioBOLETA firmado y retorna el TrackID asignado
+func (c *Cliente) Enviar(ctx context.Context, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaEnvioBoleta, error) {
+	rutSender, dvSender, err := utils.SepararRUT(rutEnvia)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de envío inválido: %v", err)
+	}
+	rutCompany, dvCompany, err := utils.SepararRUT(rutEmpresa)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de empresa inválido: %v", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, campo := range [][2]string{
+		{"rutSender", rutSender},
+		{"dvSender", dvSender},
+		{"rutCompany", rutCompany},
+		{"dvCompany", dvCompany},
+	} {
+		if err := writer.WriteField(campo[0], campo[1]); err != nil {
+			return nil, fmt.Errorf("error al construir formulario: %v", err)
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="archivo"; filename="%s"`, nombreArchivo))
+	header.Set("Content-Type", "application/xml")
+	parte, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("error al construir formulario: %v", err)
+	}
+	if _, err := parte.Write(archivo); err != nil {
+		return nil, fmt.Errorf("error al construir formulario: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error al construir formulario: %v", err)
+	}
+
+	var respuesta models.RespuestaEnvioBoleta
+	err = c.tokens.ConToken(ctx, rutEmpresa, func(tokenSII string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoints.Envio+"/boleta.electronica.envio", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return fmt.Errorf("error al crear request: %v", err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return c.invocar(req, tokenSII, &respuesta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if respuesta.TrackID == 0 {
+		return &respuesta, fmt.Errorf("el SII no retornó un TrackID (estado %s)", respuesta.Estado)
+	}
+
+	return &respuesta, nil
+}
+
+// ConsultarEnvio consulta el estado de un envío por su TrackID
+func (c *Cliente) ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.EstadoEnvioBoleta, error) {
+	rut, dv, err := utils.SepararRUT(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+	direccion := fmt.Sprintf("%s/boleta.electronica.envio/%s-%s-%s", c.endpoints.API, rut, dv, url.PathEscape(trackID))
+
+	var estado models.EstadoEnvioBoleta
+	err = c.tokens.ConToken(ctx, rutEmpresa, func(tokenSII string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, direccion, nil)
+		if err != nil {
+			return fmt.Errorf("error al crear request: %v", err)
+		}
+		return c.invocar(req, tokenSII, &estado)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &estado, nil
+}
+
+// ConsultarBoleta consulta el estado de una boleta por RUT emisor, tipo y folio
+func (c *Cliente) ConsultarBoleta(ctx context.Context, consulta models.ConsultaEstadoBoleta) (*models.EstadoDocumentoBoleta, error) {
+	rut, dv, err := utils.SepararRUT(consulta.RutEmisor)
+	if err != nil {
+		return nil, err
+	}
+	rutReceptor, dvReceptor, err := utils.SepararRUT(consulta.RutReceptor)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de receptor inválido: %v", err)
+	}
+
+	parametros := url.Values{}
+	parametros.Set("rut_receptor", rutReceptor)
+	parametros.Set("dv_receptor", dvReceptor)
+	parametros.Set("monto", strconv.FormatInt(consulta.Monto, 10))
+	parametros.Set("fechaEmision", consulta.FechaEmision)
+	direccion := fmt.Sprintf("%s/boleta.electronica/%s-%s-%d-%d/estado?%s",
+		c.endpoints.API, rut, dv, consulta.Tipo, consulta.Folio, parametros.Encode())
+
+	var estado models.EstadoDocumentoBoleta
+	err = c.tokens.ConToken(ctx, consulta.RutEmisor, func(tokenSII string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, direccion, nil)
+		if err != nil {
+			return fmt.Errorf("error al crear request: %v", err)
+		}
+		return c.invocar(req, tokenSII, &estado)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &estado, nil
+}
+
+// invocar envía la petición autenticada y decodifica la respuesta JSON
+func (c *Cliente) invocar(req *http.Request, tokenSII string, destino interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Cookie", "TOKEN="+tokenSII)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error al invocar servicio de boletas: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error al leer respuesta del SII: %v", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("error HTTP %d del SII: %w", resp.StatusCode, token.ErrTokenRechazado)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("error HTTP %d del SII: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.Unmarshal(body, destino); err != nil {
+		return fmt.Errorf("error al decodificar respuesta del SII: %v", err)
+	}
+	return nil
+}
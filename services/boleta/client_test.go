@@ -0,0 +1,140 @@
+package boleta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokensPrueba entrega los tokens en orden, avanzando cuando el SII rechaza el actual
+type tokensPrueba struct {
+	tokens []string
+}
+
+func (t *tokensPrueba) ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error {
+	err := operacion(t.tokens[0])
+	if errors.Is(err, token.ErrTokenRechazado) && len(t.tokens) > 1 {
+		t.tokens = t.tokens[1:]
+		return operacion(t.tokens[0])
+	}
+	return err
+}
+
+func TestEnviar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/boleta.electronica.envio", r.URL.Path)
+		cookie, err := r.Cookie("TOKEN")
+		require.NoError(t, err)
+		assert.Equal(t, "TOK1", cookie.Value)
+
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "11111111", r.FormValue("rutSender"))
+		assert.Equal(t, "1", r.FormValue("dvSender"))
+		assert.Equal(t, "76123456", r.FormValue("rutCompany"))
+		assert.Equal(t, "K", r.FormValue("dvCompany"))
+		_, archivo, err := r.FormFile("archivo")
+		require.NoError(t, err)
+		assert.Equal(t, "EnvioBOLETA.xml", archivo.Filename)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"rut_emisor":"76123456-K","rut_envia":"11111111-1","trackid":5470,"fecha_recepcion":"2024-03-01 09:34:47","estado":"REC","file":"EnvioBOLETA.xml"}`))
+	}))
+	defer server.Close()
+
+	cliente := NewCliente(Endpoints{API: server.URL, Envio: server.URL}, &tokensPrueba{tokens: []string{"TOK1"}}, 5*time.Second)
+	respuesta, err := cliente.Enviar(context.Background(), "11111111-1", "76123456-k", "EnvioBOLETA.xml", []byte("<EnvioBOLETA/>"))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(5470), respuesta.TrackID)
+	assert.Equal(t, models.EstadoEnvioBoletaRecibido, respuesta.Estado)
+}
+
+func TestEnviarReintentaConTokenNuevo(t *testing.T) {
+	var cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("TOKEN")
+		cookies = append(cookies, cookie.Value)
+		if cookie.Value == "VENCIDO" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"trackid":5471,"estado":"REC"}`))
+	}))
+	defer server.Close()
+
+	cliente := NewCliente(Endpoints{API: server.URL, Envio: server.URL}, &tokensPrueba{tokens: []string{"VENCIDO", "NUEVO"}}, 5*time.Second)
+	respuesta, err := cliente.Enviar(context.Background(), "11111111-1", "76123456-K", "EnvioBOLETA.xml", []byte("<EnvioBOLETA/>"))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(5471), respuesta.TrackID)
+	assert.Equal(t, []string{"VENCIDO", "NUEVO"}, cookies)
+}
+
+func TestConsultarEnvio(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/boleta.electronica.envio/76123456-K-5470", r.URL.Path)
+		w.Write([]byte(`{
+			"rut_emisor": "76123456-K",
+			"rut_envia": "11111111-1",
+			"trackid": 5470,
+			"fecha_recepcion": "2024-03-01 09:34:47",
+			"estado": "EPR",
+			"estadistica": [{"tipo": 39, "informados": 2, "aceptados": 1, "rechazados": 1, "reparos": 0}],
+			"detalle_rep_rech": [{
+				"tipo": 39, "folio": 12, "estado": "RCH", "descripcion": "Rechazado",
+				"error": [{"seccion": "DTE", "linea": 1, "nivel": 1, "codigo": 100, "descripcion": "Monto total incorrecto", "detalle": "MntTotal"}]
+			}]
+		}`))
+	}))
+	defer server.Close()
+
+	cliente := NewCliente(Endpoints{API: server.URL, Envio: server.URL}, &tokensPrueba{tokens: []string{"TOK1"}}, 5*time.Second)
+	estado, err := cliente.ConsultarEnvio(context.Background(), "76123456-K", "5470")
+	require.NoError(t, err)
+
+	assert.Equal(t, models.EstadoEnvioBoletaProcesado, estado.Estado)
+	require.Len(t, estado.Estadisticas, 1)
+	assert.Equal(t, 1, estado.Estadisticas[0].Rechazados)
+	require.Len(t, estado.Detalles, 1)
+	assert.Equal(t, int64(12), estado.Detalles[0].Folio)
+	require.Len(t, estado.Detalles[0].Errores, 1)
+	assert.Equal(t, "Monto total incorrecto", estado.Detalles[0].Errores[0].Descripcion)
+}
+
+func TestConsultarBoleta(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/boleta.electronica/76123456-K-39-12/estado", r.URL.Path)
+		assert.Equal(t, "66666666", r.URL.Query().Get("rut_receptor"))
+		assert.Equal(t, "6", r.URL.Query().Get("dv_receptor"))
+		assert.Equal(t, "1190", r.URL.Query().Get("monto"))
+		assert.Equal(t, "01-03-2024", r.URL.Query().Get("fechaEmision"))
+		w.Write([]byte(`{"codigo":"DOK","estado":"DOK","descripcion":"Documento Recibido por el SII. Datos Coinciden con los Registrados"}`))
+	}))
+	defer server.Close()
+
+	cliente := NewCliente(Endpoints{API: server.URL, Envio: server.URL}, &tokensPrueba{tokens: []string{"TOK1"}}, 5*time.Second)
+	estado, err := cliente.ConsultarBoleta(context.Background(), models.ConsultaEstadoBoleta{
+		RutEmisor:    "76123456-K",
+		Tipo:         models.TipoBoleta,
+		Folio:        12,
+		RutReceptor:  "66666666-6",
+		Monto:        1190,
+		FechaEmision: "01-03-2024",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "DOK", estado.Estado)
+}
+
+func TestEndpointsAmbiente(t *testing.T) {
+	assert.Equal(t, EndpointsProduccion, EndpointsAmbiente("produccion"))
+	assert.Equal(t, EndpointsCertificacion, EndpointsAmbiente("CERTIFICACION"))
+	assert.Equal(t, "https://api.sii.cl/recursos/v1/boleta.electronica.token", EndpointsProduccion.URLToken())
+}
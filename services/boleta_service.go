@@ -1,17 +1,44 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/boleta"
 	"github.com/cursor/FMgo/utils"
 	"go.uber.org/zap"
 )
 
+// TransporteBoleta envía boletas y consulta su estado en los servicios REST de boleta del SII
+type TransporteBoleta interface {
+	Enviar(ctx context.Context, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaEnvioBoleta, error)
+	ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.EstadoEnvioBoleta, error)
+	ConsultarBoleta(ctx context.Context, consulta models.ConsultaEstadoBoleta) (*models.EstadoDocumentoBoleta, error)
+}
+
+// GeneradorEnvioBoleta construye el EnvioBOLETA firmado de una boleta y retorna el RUT de quien lo envía
+type GeneradorEnvioBoleta interface {
+	GenerarEnvioBoleta(boleta *models.Boleta) (rutEnvia string, xmlEnvio []byte, err error)
+}
+
+// RepositorioBoletas obtiene las boletas emitidas, con su folio y sus detalles, para reenviarlas
+type RepositorioBoletas interface {
+	ObtenerBoleta(ctx context.Context, id string) (*models.Boleta, error)
+}
+
+// ErrBoletaNoEmitible indica que no hay una boleta emitida y persistida que enviar al SII
+var ErrBoletaNoEmitible = errors.New("la boleta no se puede enviar al SII sin un documento emitido y persistido")
+
 // BoletaService maneja las operaciones relacionadas con boletas
 type BoletaService struct {
 	siiService SIIClientInterface
 	boletaRepo interface{} // Repositorio de boletas
+	transporte TransporteBoleta
+	generador  GeneradorEnvioBoleta
 }
 
 // NewBoletaService crea una nueva instancia del servicio de boletas
@@ -22,6 +49,13 @@ func NewBoletaService(siiService SIIClientInterface, boletaRepo interface{}) *Bo
 	}
 }
 
+// SetTransporte configura el envío de boletas a los servicios REST de boleta del SII. Las
+// boletas 39/41 no se reciben en el DTEUpload que usa SIIClient.EnviarDTE.
+func (s *BoletaService) SetTransporte(transporte TransporteBoleta, generador GeneradorEnvioBoleta) {
+	s.transporte = transporte
+	s.generador = generador
+}
+
 // EstadoBoletaResponse representa la respuesta del estado de una boleta
 type EstadoBoletaResponse struct {
 	TrackID        string    `json:"track_id"`
@@ -42,17 +76,24 @@ func (s *BoletaService) CrearBoleta(request *models.BoletaRequest) (*models.Bole
 	// y se enviaría al SII
 
 	// Devolver una boleta de ejemplo
-	return &models.Boleta{
+	nueva := &models.Boleta{
 		ID:                  "BOL-123456",
 		TrackID:             "12345678",
 		Folio:               1,
 		MontoTotal:          10000,
 		FechaEmision:        time.Now(),
-		RutEmisor:           request.RutEmisor,
+		RUTEmisor:           request.RutEmisor,
 		RazonSocialEmisor:   "Empresa de Prueba",
 		RazonSocialReceptor: "Cliente de Prueba",
 		Estado:              "PENDIENTE",
-	}, nil
+	}
+
+	// La boleta de ejemplo no tiene folio asignado ni timbre, por lo que no se envía al SII
+	if s.transporte != nil {
+		return nil, fmt.Errorf("%w: la creación de boletas aún no asigna folio ni guarda el documento", ErrBoletaNoEmitible)
+	}
+
+	return nueva, nil
 }
 
 // ConsultarEstadoBoleta consulta el estado de una boleta
@@ -63,7 +104,13 @@ func (s *BoletaService) ConsultarEstadoBoleta(trackID, rutEmisor string) (*Estad
 		zap.String("rut_emisor", rutEmisor),
 	)
 
-	// En una implementación real, aquí se consultaría el estado al SII
+	if s.transporte != nil {
+		estado, err := s.transporte.ConsultarEnvio(context.Background(), rutEmisor, trackID)
+		if err != nil {
+			return nil, fmt.Errorf("error al consultar estado de boleta: %v", err)
+		}
+		return convertirEstadoEnvioBoleta(estado), nil
+	}
 
 	// Devolver un estado de ejemplo
 	return &EstadoBoletaResponse{
@@ -90,7 +137,7 @@ func (s *BoletaService) GetBoleta(id string) (*models.Boleta, error) {
 		Folio:               1,
 		MontoTotal:          10000,
 		FechaEmision:        time.Now(),
-		RutEmisor:           "76.000.000-0",
+		RUTEmisor:           "76.000.000-0",
 		RazonSocialEmisor:   "Empresa de Prueba",
 		RazonSocialReceptor: "Cliente de Prueba",
 		Estado:              "ACEPTADO",
@@ -147,7 +194,7 @@ func (s *BoletaService) ListarBoletas(rutEmisor string, fechaInicio, fechaFin ti
 			Folio:               1,
 			MontoTotal:          10000,
 			FechaEmision:        time.Now(),
-			RutEmisor:           rutEmisor,
+			RUTEmisor:           rutEmisor,
 			RazonSocialEmisor:   "Empresa de Prueba",
 			RazonSocialReceptor: "Cliente de Prueba",
 			Estado:              "ACEPTADO",
@@ -158,7 +205,7 @@ func (s *BoletaService) ListarBoletas(rutEmisor string, fechaInicio, fechaFin ti
 			Folio:               2,
 			MontoTotal:          20000,
 			FechaEmision:        time.Now(),
-			RutEmisor:           rutEmisor,
+			RUTEmisor:           rutEmisor,
 			RazonSocialEmisor:   "Empresa de Prueba",
 			RazonSocialReceptor: "Cliente de Prueba 2",
 			Estado:              "ACEPTADO",
@@ -187,7 +234,79 @@ func (s *BoletaService) ReenviarBoleta(id string) error {
 		zap.String("id", id),
 	)
 
-	// En una implementación real, aquí se reenviaría la boleta al SII
+	if s.transporte == nil {
+		return nil
+	}
+
+	// Sólo se reenvía la boleta persistida, nunca la de ejemplo de GetBoleta
+	repo, ok := s.boletaRepo.(RepositorioBoletas)
+	if !ok {
+		return fmt.Errorf("%w: el repositorio de boletas no permite obtener la boleta %s", ErrBoletaNoEmitible, id)
+	}
+	ctx := context.Background()
+	existente, err := repo.ObtenerBoleta(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error al obtener boleta %s: %v", id, err)
+	}
+	if existente.Folio <= 0 {
+		return fmt.Errorf("%w: la boleta %s no tiene folio asignado", ErrBoletaNoEmitible, id)
+	}
+	return s.enviarBoleta(ctx, existente)
+}
+
+// ConsultarEstadoDocumentoBoleta consulta el estado de una boleta por RUT emisor, tipo y folio
+func (s *BoletaService) ConsultarEstadoDocumentoBoleta(consulta models.ConsultaEstadoBoleta) (*models.EstadoDocumentoBoleta, error) {
+	if s.transporte == nil {
+		return nil, fmt.Errorf("transporte de boletas no configurado")
+	}
+	return s.transporte.ConsultarBoleta(context.Background(), consulta)
+}
+
+// enviarBoleta genera el EnvioBOLETA y lo sube al servicio REST de boletas
+func (s *BoletaService) enviarBoleta(ctx context.Context, b *models.Boleta) error {
+	rutEnvia, xmlEnvio, err := s.generador.GenerarEnvioBoleta(b)
+	if err != nil {
+		return fmt.Errorf("error al generar EnvioBOLETA: %v", err)
+	}
 
+	nombreArchivo := fmt.Sprintf("EnvioBOLETA_%s_%d.xml", b.RUTEmisor, b.Folio)
+	respuesta, err := s.transporte.Enviar(ctx, rutEnvia, b.RUTEmisor, nombreArchivo, xmlEnvio)
+	if err != nil {
+		return fmt.Errorf("error al enviar boleta al SII: %v", err)
+	}
+
+	b.TrackID = strconv.FormatInt(respuesta.TrackID, 10)
+	b.Estado = string(models.EstadoDTEEnviado)
+	b.EstadoSII = respuesta.Estado
+	b.UpdatedAt = time.Now()
+
+	utils.LogInfo("boleta enviada al SII",
+		zap.String("rut_emisor", b.RUTEmisor),
+		zap.Int("folio", b.Folio),
+		zap.String("track_id", b.TrackID),
+	)
 	return nil
 }
+
+// convertirEstadoEnvioBoleta traduce la respuesta JSON del SII a la respuesta del servicio
+func convertirEstadoEnvioBoleta(estado *models.EstadoEnvioBoleta) *EstadoBoletaResponse {
+	respuesta := &EstadoBoletaResponse{
+		TrackID: strconv.FormatInt(estado.TrackID, 10),
+		Estado:  estado.Estado,
+		Glosa:   boleta.DescripcionEstado(estado.Estado),
+	}
+	if fecha, err := time.ParseInLocation("2006-01-02 15:04:05", estado.FechaRecepcion, time.Local); err == nil {
+		respuesta.FechaRecepcion = fecha
+	}
+	for _, detalle := range estado.Detalles {
+		for _, e := range detalle.Errores {
+			respuesta.Errores = append(respuesta.Errores,
+				fmt.Sprintf("Tipo %d folio %d (%s): %s %s", detalle.Tipo, detalle.Folio, detalle.Estado, e.Descripcion, e.Detalle))
+		}
+		if len(detalle.Errores) == 0 {
+			respuesta.Errores = append(respuesta.Errores,
+				fmt.Sprintf("Tipo %d folio %d (%s): %s", detalle.Tipo, detalle.Folio, detalle.Estado, detalle.Descripcion))
+		}
+	}
+	return respuesta
+}
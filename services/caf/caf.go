@@ -1,12 +1,23 @@
 package caf
 
 import (
+	"crypto"
 	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"encoding/xml"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 // CAFXml representa un archivo de autorización de folios en formato XML
@@ -20,6 +31,127 @@ type CAFXml struct {
 	FechaResol  string   `xml:"FechaResol"`
 	NumResol    string   `xml:"NumResol"`
 	Signature   string   `xml:"Signature"`
+
+	// Campos del archivo entregado por el SII (AUTORIZACION/CAF, RSASK y RSAPUBK)
+	RSASK   string `xml:"-"`
+	RSAPUBK string `xml:"-"`
+	IDK     int    `xml:"-"`
+
+	// nodo es el elemento CAF tal como lo firmó el SII, que se copia en el DD de cada timbre
+	nodo *etree.Element
+}
+
+// ParseCAF lee un archivo de autorización de folios. Acepta el formato entregado por el SII
+// (AUTORIZACION con los nodos CAF, RSASK y RSAPUBK, en ISO-8859-1) y el formato simplificado
+// con los campos en la raíz.
+func ParseCAF(data []byte) (*CAFXml, error) {
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("error al decodificar CAF: %w", err)
+	}
+
+	nodo := doc.Root().SelectElement("CAF")
+	if nodo == nil {
+		var caf CAFXml
+		if err := xml.Unmarshal(data, &caf); err != nil {
+			return nil, fmt.Errorf("error al decodificar CAF: %w", err)
+		}
+		return &caf, nil
+	}
+
+	caf := &CAFXml{
+		Version:    nodo.SelectAttrValue("version", ""),
+		RUTEmisor:  texto(nodo, "DA/RE"),
+		FechaResol: texto(nodo, "DA/FA"),
+		Signature:  texto(nodo, "FRMA"),
+		RSASK:      texto(doc.Root(), "RSASK"),
+		RSAPUBK:    texto(doc.Root(), "RSAPUBK"),
+		nodo:       nodo.Copy(),
+	}
+	if caf.TipoDTE, err = strconv.Atoi(texto(nodo, "DA/TD")); err != nil {
+		return nil, fmt.Errorf("tipo DTE inválido en el CAF: %w", err)
+	}
+	if caf.FolioInicio, err = strconv.Atoi(texto(nodo, "DA/RNG/D")); err != nil {
+		return nil, fmt.Errorf("folio inicial inválido en el CAF: %w", err)
+	}
+	if caf.FolioFinal, err = strconv.Atoi(texto(nodo, "DA/RNG/H")); err != nil {
+		return nil, fmt.Errorf("folio final inválido en el CAF: %w", err)
+	}
+	if idk := texto(nodo, "DA/IDK"); idk != "" {
+		if caf.IDK, err = strconv.Atoi(idk); err != nil {
+			return nil, fmt.Errorf("IDK inválido en el CAF: %w", err)
+		}
+	}
+
+	return caf, nil
+}
+
+// ParseAutorizacion reconstruye un CAF del SII desde el nodo CAF firmado y sus llaves, tal como
+// lo guarda la importación con la llave RSASK aparte
+func ParseAutorizacion(nodoCAF []byte, rsask, rsapubk string) (*CAFXml, error) {
+	nodo, err := xmldsig.ParseDocument(nodoCAF)
+	if err != nil {
+		return nil, fmt.Errorf("error al decodificar nodo CAF: %w", err)
+	}
+	if nodo.Root().Tag != "CAF" {
+		return nil, fmt.Errorf("se esperaba el nodo CAF y se recibió %s", nodo.Root().Tag)
+	}
+
+	doc := etree.NewDocument()
+	raiz := doc.CreateElement("AUTORIZACION")
+	raiz.AddChild(nodo.Root().Copy())
+	raiz.CreateElement("RSASK").SetText(rsask)
+	raiz.CreateElement("RSAPUBK").SetText(rsapubk)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("error al componer CAF: %w", err)
+	}
+	return ParseCAF(data)
+}
+
+// Nodo retorna una copia del elemento CAF firmado por el SII, para incluirlo en el DD de un timbre
+func (c *CAFXml) Nodo() (*etree.Element, error) {
+	if c.nodo == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene el nodo CAF del SII", c.TipoDTE)
+	}
+	return c.nodo.Copy(), nil
+}
+
+// LlavePrivada retorna la llave RSASK con que se firman los timbres de los folios del CAF
+func (c *CAFXml) LlavePrivada() (*rsa.PrivateKey, error) {
+	bloque, _ := pem.Decode([]byte(c.RSASK))
+	if bloque == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene una llave RSASK válida", c.TipoDTE)
+	}
+	llave, err := x509.ParsePKCS1PrivateKey(bloque.Bytes)
+	if err != nil {
+		pkcs8, err8 := x509.ParsePKCS8PrivateKey(bloque.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("error al parsear llave RSASK: %w", err)
+		}
+		var ok bool
+		if llave, ok = pkcs8.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("la llave RSASK no es RSA")
+		}
+	}
+	return llave, nil
+}
+
+// LlavePublica retorna la llave RSAPK del CAF, con la que se verifican los timbres
+func (c *CAFXml) LlavePublica() (*rsa.PublicKey, error) {
+	if c.nodo == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene el nodo CAF del SII", c.TipoDTE)
+	}
+	rsapk := c.nodo.FindElement("DA/RSAPK")
+	if rsapk == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene la llave RSAPK", c.TipoDTE)
+	}
+	return xmldsig.LlaveRSA(texto(rsapk, "M"), texto(rsapk, "E"))
+}
+
+// ContieneFolio indica si el folio pertenece al rango autorizado
+func (c *CAFXml) ContieneFolio(folio int) bool {
+	return folio >= c.FolioInicio && folio <= c.FolioFinal
 }
 
 // Manager maneja los CAF disponibles
@@ -46,20 +178,24 @@ func (m *Manager) LoadCAF(filename string) error {
 	}
 
 	// Decodificar XML
-	var caf CAFXml
-	err = xml.Unmarshal(data, &caf)
+	caf, err := ParseCAF(data)
 	if err != nil {
-		return fmt.Errorf("error al decodificar CAF: %w", err)
+		return err
 	}
 
+	return m.AgregarCAF(caf)
+}
+
+// AgregarCAF valida y registra un CAF ya leído, reemplazando el del mismo tipo de DTE
+func (m *Manager) AgregarCAF(caf *CAFXml) error {
 	// Validar CAF
-	if err := m.validateCAF(&caf); err != nil {
+	if err := m.validateCAF(caf); err != nil {
 		return fmt.Errorf("error al validar CAF: %w", err)
 	}
 
 	// Guardar CAF
 	m.mu.Lock()
-	m.cafs[caf.TipoDTE] = &caf
+	m.cafs[caf.TipoDTE] = caf
 	m.mu.Unlock()
 
 	return nil
@@ -145,8 +281,8 @@ func (m *Manager) validateCAF(caf *CAFXml) error {
 		return fmt.Errorf("fecha resolución no puede estar vacía")
 	}
 
-	// Validar número resolución
-	if caf.NumResol == "" {
+	// Validar número resolución (el archivo del SII no lo incluye)
+	if caf.nodo == nil && caf.NumResol == "" {
 		return fmt.Errorf("número resolución no puede estar vacío")
 	}
 
@@ -155,21 +291,79 @@ func (m *Manager) validateCAF(caf *CAFXml) error {
 		return fmt.Errorf("firma no puede estar vacía")
 	}
 
-	// TODO: Implementar validación de firma usando la clave pública del SII
+	// Validar que la llave privada corresponda a la pública autorizada
+	if caf.nodo != nil {
+		privada, err := caf.LlavePrivada()
+		if err != nil {
+			return err
+		}
+		publica, err := caf.LlavePublica()
+		if err != nil {
+			return err
+		}
+		if !privada.PublicKey.Equal(publica) {
+			return fmt.Errorf("la llave RSASK no corresponde a la llave RSAPK del CAF")
+		}
+	}
 
 	return nil
 }
 
-// VerifySignature verifica la firma de un CAF
+// VerifySignature verifica la firma FRMA del CAF con la llave pública del SII
 func (m *Manager) VerifySignature(caf *CAFXml, publicKey *rsa.PublicKey) error {
-	// TODO: Implementar verificación de firma
+	return caf.VerificarFirma(publicKey)
+}
+
+// VerificarFirma verifica la firma FRMA (SHA1withRSA) que el SII calcula sobre el elemento DA
+// canonicalizado y sin espacios entre elementos, con la llave pública del SII de su IDK
+func (c *CAFXml) VerificarFirma(llaveSII *rsa.PublicKey) error {
+	if c.nodo == nil {
+		return fmt.Errorf("el CAF del tipo %d no contiene el nodo CAF del SII", c.TipoDTE)
+	}
+	if llaveSII == nil {
+		return fmt.Errorf("llave pública del SII nula")
+	}
+	da := c.nodo.SelectElement("DA")
+	if da == nil {
+		return fmt.Errorf("el CAF del tipo %d no contiene el elemento DA", c.TipoDTE)
+	}
+	firma, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c.Signature), ""))
+	if err != nil {
+		return fmt.Errorf("error al decodificar FRMA: %w", err)
+	}
+	digest := sha1.Sum(espaciosEntreElementos.ReplaceAll(xmldsig.Canonicalize(da), []byte("><")))
+	if err := rsa.VerifyPKCS1v15(llaveSII, crypto.SHA1, digest[:], firma); err != nil {
+		return fmt.Errorf("firma FRMA inválida: %w", err)
+	}
 	return nil
 }
 
-// SaveCAF guarda un CAF en un archivo XML
+// Autorizacion retorna el archivo AUTORIZACION del SII con el nodo CAF firmado, RSASK y RSAPUBK
+func (c *CAFXml) Autorizacion() ([]byte, error) {
+	if c.nodo == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene el nodo CAF del SII", c.TipoDTE)
+	}
+	return c.xml()
+}
+
+// NodoXML retorna el elemento CAF firmado por el SII serializado, sin las llaves
+func (c *CAFXml) NodoXML() ([]byte, error) {
+	if c.nodo == nil {
+		return nil, fmt.Errorf("el CAF del tipo %d no contiene el nodo CAF del SII", c.TipoDTE)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(c.nodo.Copy())
+	return xmldsig.Serializar(doc)
+}
+
+// espaciosEntreElementos identifica los saltos de línea e indentación entre etiquetas
+var espaciosEntreElementos = regexp.MustCompile(`>\s+<`)
+
+// SaveCAF guarda un CAF en un archivo XML. Los CAF del SII se guardan en su formato original
+// para no alterar el nodo firmado.
 func (m *Manager) SaveCAF(caf *CAFXml, filename string) error {
 	// Convertir a XML
-	data, err := xml.MarshalIndent(caf, "", "  ")
+	data, err := caf.xml()
 	if err != nil {
 		return fmt.Errorf("error al convertir CAF a XML: %w", err)
 	}
@@ -182,3 +376,27 @@ func (m *Manager) SaveCAF(caf *CAFXml, filename string) error {
 
 	return nil
 }
+
+// xml serializa el CAF en el formato en que fue leído
+func (c *CAFXml) xml() ([]byte, error) {
+	if c.nodo == nil {
+		return xml.MarshalIndent(c, "", "  ")
+	}
+
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="ISO-8859-1"`)
+	raiz := doc.CreateElement("AUTORIZACION")
+	raiz.AddChild(c.nodo.Copy())
+	raiz.CreateElement("RSASK").SetText(c.RSASK)
+	raiz.CreateElement("RSAPUBK").SetText(c.RSAPUBK)
+	return xmldsig.Serializar(doc)
+}
+
+// texto retorna el texto sin espacios del elemento en la ruta indicada
+func texto(el *etree.Element, ruta string) string {
+	hijo := el.FindElement(ruta)
+	if hijo == nil {
+		return ""
+	}
+	return strings.TrimSpace(hijo.Text())
+}
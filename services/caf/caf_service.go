@@ -2,56 +2,49 @@ package caf
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
 )
 
 // Service representa el servicio de CAF
 type Service struct {
-	config *config.Config
-	redis  interface{}
-	sii    interface{}
+	config  *config.Config
+	redis   interface{}
+	sii     interface{}
+	manager *Manager
 }
 
 // NewService crea una nueva instancia del servicio de CAF
 func NewService(config *config.Config, redis interface{}, sii interface{}) *Service {
 	return &Service{
-		config: config,
-		redis:  redis,
-		sii:    sii,
+		config:  config,
+		redis:   redis,
+		sii:     sii,
+		manager: NewManager(""),
 	}
 }
 
-// GetCAFDisponible obtiene un CAF disponible
-func (s *Service) GetCAFDisponible(ctx context.Context, tipoDTE models.TipoDTE, rutEmisor string) (*models.CAFDTEXML, error) {
-	// Implementación mock
-	return &models.CAFDTEXML{
-		Version: "1.0",
-		DA: models.DAXMLModel{
-			RUT: models.RutXMLModel{
-				Numero: rutEmisor,
-			},
-			RazonSocial: "EMPRESA DE PRUEBA",
-			TipoDTE:     string(tipoDTE),
-			RangoDesde:  1,
-			RangoHasta:  100,
-			FechaAut:    "2023-01-01",
-			RSAPK: models.RSAPKXMLModel{
-				Modulo:    "test-modulus",
-				Exponente: "test-exponent",
-			},
-			IDK: 1,
-		},
-		FRMA: models.FRMAXMLModel{
-			Algoritmo: "SHA1withRSA",
-			Valor:     "test-signature",
-		},
-	}, nil
+// SetManager asigna el administrador que mantiene los CAF cargados
+func (s *Service) SetManager(manager *Manager) {
+	s.manager = manager
+}
+
+// GetCAFDisponible obtiene el CAF cargado para el tipo de documento y emisor
+func (s *Service) GetCAFDisponible(ctx context.Context, tipoDTE models.TipoDTE, rutEmisor string) (*CAFXml, error) {
+	caf, err := s.manager.GetCAF(int(tipoDTE))
+	if err != nil {
+		return nil, err
+	}
+	if rutEmisor != "" && !utils.MismoRUT(caf.RUTEmisor, rutEmisor) {
+		return nil, fmt.Errorf("no hay CAF disponible para el emisor %s y el tipo de DTE %d", rutEmisor, tipoDTE)
+	}
+	return caf, nil
 }
 
 // ValidarCAF valida un CAF
-func (s *Service) ValidarCAF(caf *models.CAFDTEXML) error {
-	// Implementación mock
-	return nil
+func (s *Service) ValidarCAF(caf *CAFXml) error {
+	return s.manager.validateCAF(caf)
 }
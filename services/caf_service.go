@@ -2,14 +2,10 @@ package services
 
 import (
 	"context"
-	"crypto"
-	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -23,7 +19,10 @@ import (
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/domain"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/folios"
 	"github.com/cursor/FMgo/sii"
+	"github.com/cursor/FMgo/utils/xmldsig"
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -37,6 +36,8 @@ type CAFService struct {
 	certFile   string
 	keyFile    string
 	config     *config.SupabaseConfig
+	llavesSII  *folios.LlavesSII
+	ambiente   models.AmbienteSII
 }
 
 // SIICAFRequest representa la solicitud de CAF al SII
@@ -126,6 +127,13 @@ func NewCAFService(
 	}
 }
 
+// SetLlavesSII asigna las llaves con que se verifica el FRMA de los CAF descargados y el
+// ambiente al que pertenecen
+func (s *CAFService) SetLlavesSII(llaves *folios.LlavesSII, ambiente models.AmbienteSII) {
+	s.llavesSII = llaves
+	s.ambiente = ambiente
+}
+
 // ObtenerCAF obtiene un CAF por tipo de documento
 func (s *CAFService) ObtenerCAF(ctx context.Context, tipoDocumento string) (*domain.CAF, error) {
 	collection := s.db.Collection("cafs")
@@ -375,11 +383,18 @@ func (s *CAFService) validarArchivoCAF(filePath string, rutEmisor string) (*CAFM
 		return nil, fmt.Errorf("error leyendo archivo: %v", err)
 	}
 
-	// Parsear XML
+	// Parsear XML (los CAF del SII vienen en ISO-8859-1)
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parseando XML: %v", err)
+	}
 	var caf SIICAFXML
-	if err := xml.Unmarshal(data, &caf); err != nil {
+	if err := xml.Unmarshal(xmldsig.Canonicalize(doc.Root()), &caf); err != nil {
 		return nil, fmt.Errorf("error parseando XML: %v", err)
 	}
+	if doc.FindElement("//CAF/DA") == nil {
+		return nil, s.manejarErrorSII("001", "El CAF no contiene DA")
+	}
 
 	// Validar RUT emisor
 	if caf.RUTEmisor != rutEmisor {
@@ -387,10 +402,19 @@ func (s *CAFService) validarArchivoCAF(filePath string, rutEmisor string) (*CAFM
 	}
 
 	// Validar firma digital
-	if err := s.validarFirmaCAF(&caf); err != nil {
+	if err := s.validarFirmaCAF(data); err != nil {
 		return nil, s.manejarErrorSII("003", "Firma digital inválida")
 	}
 
+	// Los CAF reenviados dentro de documentos firmados deben conservar firmas XMLDSig válidas
+	if verificaciones, err := xmldsig.VerificarFirmas(data); err == nil {
+		for _, v := range verificaciones {
+			if !v.Valida() {
+				return nil, s.manejarErrorSII("003", fmt.Sprintf("Firma XML inválida en %s: %v", v.Ruta, v.Error))
+			}
+		}
+	}
+
 	// Validar fechas
 	fechaAutorizacion, err := time.Parse("2006-01-02", caf.FechaAutorizacion)
 	if err != nil {
@@ -432,48 +456,21 @@ func (s *CAFService) validarSuperposicionFolios(rutEmisor string, tipoDTE string
 	return nil
 }
 
-// validarFirmaCAF valida la firma digital del CAF
-func (s *CAFService) validarFirmaCAF(caf *SIICAFXML) error {
-	// Decodificar clave pública
-	block, _ := pem.Decode([]byte(caf.PublicKey))
-	if block == nil {
-		return fmt.Errorf("error decodificando clave pública")
+// validarFirmaCAF valida la firma FRMA del CAF con la llave pública del SII de su IDK en el
+// ambiente del servicio; sin llaves configuradas ningún CAF se acepta
+func (s *CAFService) validarFirmaCAF(data []byte) error {
+	if s.llavesSII == nil {
+		return fmt.Errorf("no hay llaves del SII configuradas")
 	}
-
-	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	autorizacion, err := caf.ParseCAF(data)
 	if err != nil {
-		return fmt.Errorf("error parseando clave pública: %v", err)
-	}
-
-	rsaPubKey, ok := pubKey.(*rsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("clave pública no es RSA")
+		return err
 	}
-
-	// Decodificar firma
-	signature, err := base64.StdEncoding.DecodeString(caf.Firma)
+	llave, err := s.llavesSII.Llave(s.ambiente, autorizacion.IDK)
 	if err != nil {
-		return fmt.Errorf("error decodificando firma: %v", err)
-	}
-
-	// Calcular hash del documento
-	hash := sha1.New()
-	hash.Write([]byte(fmt.Sprintf("%s%s%s%d%d%s%s%s",
-		caf.RUTEmisor,
-		caf.RazonSocial,
-		caf.TipoDTE,
-		caf.FolioInicial,
-		caf.FolioFinal,
-		caf.FechaAutorizacion,
-		caf.Modulo,
-		caf.Exponente)))
-
-	// Verificar firma
-	if err := rsa.VerifyPKCS1v15(rsaPubKey, crypto.SHA1, hash.Sum(nil), signature); err != nil {
-		return fmt.Errorf("error verificando firma: %v", err)
+		return err
 	}
-
-	return nil
+	return autorizacion.VerificarFirma(llave)
 }
 
 // calcularHashCAF calcula el hash del archivo CAF
@@ -5,6 +5,8 @@ import (
 
 	"github.com/cursor/FMgo/domain"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/exportacion"
+	"github.com/cursor/FMgo/services/facturacompra"
 	"github.com/cursor/FMgo/utils"
 )
 
@@ -263,6 +265,16 @@ func (c *TributarioCalculation) CalcularImpuestos(doc interface{}) error {
 		return c.calcularImpuestosNotaDebito(d, amountValidator)
 	case *models.GuiaDespacho:
 		return c.calcularImpuestosGuiaDespacho(d, amountValidator)
+	case *models.DocumentoTributario:
+		// Los documentos de exportación son exentos de IVA y se calculan en la moneda de la operación
+		if d.TipoDocumento.EsExportacion() {
+			return exportacion.Calcular(d)
+		}
+		// En la factura de compra el comprador retiene todo o parte del IVA al vendedor
+		if d.TipoDocumento == models.TipoFacturaCompra {
+			return facturacompra.Calcular(d)
+		}
+		return fmt.Errorf("tipo de documento no soportado: %d", d.TipoDocumento)
 	case *domain.DocumentoTributario:
 		// Obtener los items del documento de dominio
 		montoNeto, montoExento, montoIVA, totalImpuestosAdicionales, _, err := c.calcularMontosDomainItems([]domain.Item{}, amountValidator)
@@ -0,0 +1,120 @@
+package certificacion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// Códigos de las tablas de Aduana que el set de exportación indica por su glosa
+var (
+	codigosModalidadVenta = map[string]int{
+		"A FIRME":                               1,
+		"BAJO CONDICION":                        2,
+		"EN CONSIGNACION LIBRE":                 3,
+		"EN CONSIGNACION CON UN MINIMO A FIRME": 4,
+		"SIN PAGO":                              9,
+	}
+	codigosClausulaVenta = map[string]int{
+		"CIF": 1, "CFR": 2, "EXW": 3, "FAS": 4, "FOB": 5, "S/CL": 6, "OTROS": 9,
+		"DDP": 10, "FCA": 11, "CPT": 12, "CIP": 13, "DAT": 17, "DAP": 18,
+	}
+	codigosViaTransporte = map[string]int{
+		"MARITIMA, FLUVIAL Y LACUSTRE": 1,
+		"AEREO":                        4,
+		"AEREA":                        4,
+		"POSTAL":                       5,
+		"FERROVIARIO":                  6,
+		"FERROVIARIA":                  6,
+		"CARRETERO/TERRESTRE":          7,
+		"CARRETERA / TERRESTRE":        7,
+		"OLEODUCTOS, GASODUCTOS":       8,
+		"TENDIDO ELECTRICO":            9,
+		"OTRA":                         10,
+	}
+)
+
+// aplicarCamposExportacion completa los datos de exportación con los campos del caso. Las
+// glosas se traducen con las tablas conocidas o con los códigos de los parámetros; un campo
+// que ya trae el código numérico se usa tal cual
+func aplicarCamposExportacion(datos *models.DatosExportacion, caso *Caso, codigos map[string]int) error {
+	codigo := func(tabla map[string]int, glosa string) (int, error) {
+		glosa = normalizar(glosa)
+		// La glosa puede venir seguida del código o de una aclaración entre paréntesis
+		if i := strings.Index(glosa, "("); i > 0 {
+			glosa = strings.TrimSpace(glosa[:i])
+		}
+		if n, err := strconv.Atoi(glosa); err == nil {
+			return n, nil
+		}
+		if n, ok := tabla[glosa]; ok {
+			return n, nil
+		}
+		for clave, n := range codigos {
+			if normalizar(clave) == glosa {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("la glosa %q no tiene código de Aduana; indíquelo en los códigos de Aduana de los parámetros", glosa)
+	}
+
+	var bultos models.TipoBulto
+	for clave, valor := range caso.Campos {
+		if valor == "" {
+			continue
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(clave, "MONEDA"):
+			datos.TpoMoneda = normalizar(valor)
+		case strings.HasPrefix(clave, "FORMA DE PAGO"):
+			datos.FmaPagExp, err = codigo(nil, valor)
+		case strings.HasPrefix(clave, "MODALIDAD DE VENTA"):
+			datos.Aduana.CodModVenta, err = codigo(codigosModalidadVenta, valor)
+		case strings.HasPrefix(clave, "CLAUSULA DE VENTA"):
+			datos.Aduana.CodClauVenta, err = codigo(codigosClausulaVenta, valor)
+		case strings.HasPrefix(clave, "TOTAL CLAUSULA"):
+			datos.Aduana.TotClauVenta, err = parsearNumero(valor)
+		case strings.HasPrefix(clave, "VIA DE TRANSPORTE"):
+			datos.Aduana.CodViaTransp, err = codigo(codigosViaTransporte, valor)
+		case strings.HasPrefix(clave, "PUERTO DE EMBARQUE"):
+			datos.Aduana.CodPtoEmbarque, err = codigo(nil, valor)
+		case strings.HasPrefix(clave, "PUERTO DE DESEMBARQUE"):
+			datos.Aduana.CodPtoDesemb, err = codigo(nil, valor)
+		case strings.HasPrefix(clave, "TIPO DE BULTO"):
+			bultos.CodTpoBultos, err = codigo(nil, valor)
+		case strings.HasPrefix(clave, "TOTAL BULTOS"), strings.HasPrefix(clave, "CANTIDAD DE BULTOS"):
+			var total float64
+			total, err = parsearNumero(valor)
+			bultos.CantBultos = int(total)
+		case strings.HasPrefix(clave, "FLETE"):
+			datos.Aduana.MntFlete, err = parsearNumero(valor)
+		case strings.HasPrefix(clave, "SEGURO"):
+			datos.Aduana.MntSeguro, err = parsearNumero(valor)
+		case strings.HasPrefix(clave, "TIPO DE CAMBIO"):
+			datos.TpoCambio, err = parsearNumero(valor)
+		case strings.HasPrefix(clave, "PAIS RECEPTOR") && strings.Contains(clave, "DESTINO"):
+			datos.Aduana.CodPaisRecep, err = codigo(nil, valor)
+			datos.Aduana.CodPaisDestin = datos.Aduana.CodPaisRecep
+		case strings.HasPrefix(clave, "PAIS RECEPTOR"):
+			datos.Aduana.CodPaisRecep, err = codigo(nil, valor)
+		case strings.HasPrefix(clave, "PAIS DESTINO"), strings.HasPrefix(clave, "PAIS DE DESTINO"):
+			datos.Aduana.CodPaisDestin, err = codigo(nil, valor)
+		}
+		if err != nil {
+			return fmt.Errorf("campo %s: %v", clave, err)
+		}
+	}
+
+	if bultos.CodTpoBultos != 0 {
+		datos.Aduana.TipoBultos = []models.TipoBulto{bultos}
+		datos.Aduana.TotBultos = bultos.CantBultos
+	}
+	if datos.Extranjero.Nacionalidad == "" && datos.Aduana.CodPaisRecep != 0 {
+		datos.Extranjero.Nacionalidad = strconv.Itoa(datos.Aduana.CodPaisRecep)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package certificacion
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/exportacion"
+	"github.com/cursor/FMgo/services/facturacompra"
+	"github.com/cursor/FMgo/services/guias"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+const (
+	// RutSII es el RUT del SII, receptor de los envíos de certificación
+	RutSII = "60803000-K"
+	// idSetDTE es el ID del SetDTE que firma el envío
+	idSetDTE = "SetDoc"
+	// formatoTimestamp es el formato de TmstFirma y TmstFirmaEnv
+	formatoTimestamp = "2006-01-02T15:04:05"
+)
+
+// Timbrador agrega el TED a un elemento Documento o Exportaciones con el CAF de su tipo
+type Timbrador interface {
+	TimbrarDocumento(documento *etree.Element) error
+}
+
+// Firmador firma un EnvioDTE (cada DTE y luego el SetDTE) o un libro
+type Firmador interface {
+	Firmar(data []byte) ([]byte, error)
+}
+
+// ConstruirEnvio arma el EnvioDTE con los documentos indicados, dirigido al SII. Cada DTE se
+// timbra con el CAF de su tipo; el envío queda listo para firmar
+func ConstruirEnvio(params Parametros, documentos []*DocumentoCaso, timbrador Timbrador, instante time.Time) ([]byte, error) {
+	if len(documentos) == 0 {
+		return nil, fmt.Errorf("el envío no tiene documentos")
+	}
+	tmst := instante.Format(formatoTimestamp)
+
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="ISO-8859-1"`)
+	envio := doc.CreateElement("EnvioDTE")
+	envio.CreateAttr("xmlns", models.NamespaceSII)
+	envio.CreateAttr("xmlns:xsi", "http://www.w3.org/2001/XMLSchema-instance")
+	envio.CreateAttr("xsi:schemaLocation", models.NamespaceSII+" EnvioDTE_v10.xsd")
+	envio.CreateAttr("version", "1.0")
+
+	setDTE := envio.CreateElement("SetDTE")
+	setDTE.CreateAttr("ID", idSetDTE)
+
+	caratula := setDTE.CreateElement("Caratula")
+	caratula.CreateAttr("version", "1.0")
+	caratula.CreateElement("RutEmisor").SetText(params.Emisor.RUT)
+	caratula.CreateElement("RutEnvia").SetText(params.RutEnvia)
+	caratula.CreateElement("RutReceptor").SetText(RutSII)
+	caratula.CreateElement("FchResol").SetText(params.FchResol)
+	caratula.CreateElement("NroResol").SetText(strconv.Itoa(params.NroResol))
+	caratula.CreateElement("TmstFirmaEnv").SetText(tmst)
+
+	cantidades := make(map[models.TipoDTE]int)
+	for _, documento := range documentos {
+		cantidades[documento.TipoDTE]++
+	}
+	tipos := make([]int, 0, len(cantidades))
+	for tipo := range cantidades {
+		tipos = append(tipos, int(tipo))
+	}
+	sort.Ints(tipos)
+	for _, tipo := range tipos {
+		subTotal := caratula.CreateElement("SubTotDTE")
+		subTotal.CreateElement("TpoDTE").SetText(strconv.Itoa(tipo))
+		subTotal.CreateElement("NroDTE").SetText(strconv.Itoa(cantidades[models.TipoDTE(tipo)]))
+	}
+
+	for _, documento := range documentos {
+		dte, err := timbrarDTE(documento, timbrador, tmst)
+		if err != nil {
+			return nil, fmt.Errorf("caso %s: %v", documento.Caso, err)
+		}
+		setDTE.AddChild(dte)
+	}
+
+	return xmldsig.Serializar(doc)
+}
+
+// timbrarDTE serializa el DTE del caso y le agrega el TED
+func timbrarDTE(documento *DocumentoCaso, timbrador Timbrador, tmst string) (*etree.Element, error) {
+	modelo, err := ConstruirDTE(documento, tmst)
+	if err != nil {
+		return nil, err
+	}
+	data, err := xml.Marshal(modelo)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar DTE: %v", err)
+	}
+
+	parsed, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	dte := parsed.Root()
+	// El DTE hereda el namespace del sobre
+	dte.RemoveAttr("xmlns")
+
+	elementos := dte.ChildElements()
+	if len(elementos) == 0 {
+		return nil, fmt.Errorf("el DTE no tiene documento")
+	}
+	if err := timbrador.TimbrarDocumento(elementos[0]); err != nil {
+		return nil, fmt.Errorf("error al timbrar documento: %v", err)
+	}
+	return dte, nil
+}
+
+// ConstruirDTE arma el modelo XML del documento del caso con el builder de su tipo
+func ConstruirDTE(documento *DocumentoCaso, tmstFirma string) (interface{}, error) {
+	switch {
+	case documento.TipoDTE == models.TipoGuiaDespacho:
+		dte, err := guias.ConstruirDTE(documento.Guia)
+		if err != nil {
+			return nil, err
+		}
+		dte.Documento.TmstFirma = tmstFirma
+		return dte, nil
+	case documento.TipoDTE == models.TipoFacturaCompra:
+		dte, err := facturacompra.ConstruirDTE(documento.Documento)
+		if err != nil {
+			return nil, err
+		}
+		dte.Documento.TmstFirma = tmstFirma
+		return dte, nil
+	case documento.TipoDTE.EsExportacion():
+		dte, err := exportacion.ConstruirDTE(documento.Documento)
+		if err != nil {
+			return nil, err
+		}
+		dte.Exportacion.TmstFirma = tmstFirma
+		return dte, nil
+	default:
+		dte := construirDTENacional(documento)
+		dte.Documento.TmstFirma = tmstFirma
+		return dte, nil
+	}
+}
+
+// construirDTENacional arma el XML de facturas afectas y exentas y de notas de crédito y
+// débito, con los descuentos por ítem y el descuento global sobre los ítems afectos
+func construirDTENacional(documento *DocumentoCaso) *models.DTEXMLModel {
+	doc := documento.Documento
+	encabezado := models.EncabezadoXMLModel{
+		IdDoc: models.IDDocumentoXML{
+			TipoDTE:      strconv.Itoa(int(doc.TipoDocumento)),
+			Folio:        doc.Folio,
+			FechaEmision: doc.FechaEmision.Format("2006-01-02"),
+		},
+		Emisor: models.EmisorXML{
+			RUT:         doc.RUTEmisor,
+			RazonSocial: doc.RazonSocialEmisor,
+			Giro:        doc.GiroEmisor,
+			Direccion:   doc.DireccionEmisor,
+			Comuna:      doc.ComunaEmisor,
+		},
+		Receptor: models.ReceptorXML{
+			RUT:         doc.RUTReceptor,
+			RazonSocial: doc.RazonSocialReceptor,
+			Giro:        doc.GiroReceptor,
+			Direccion:   doc.DireccionReceptor,
+			Comuna:      doc.ComunaReceptor,
+		},
+		Totales: models.TotalesXML{
+			MontoExento: int(doc.MontoExento),
+			MntTotal:    int64(doc.MontoTotal),
+		},
+	}
+	if doc.Emisor != nil {
+		encabezado.Emisor.Ciudad = doc.Emisor.Ciudad
+	}
+	if doc.Receptor != nil {
+		encabezado.Receptor.Ciudad = doc.Receptor.Ciudad
+	}
+	if doc.MontoNeto > 0 {
+		neto := int64(doc.MontoNeto)
+		iva := int64(doc.MontoIVA)
+		tasa := doc.TasaIVA
+		encabezado.Totales.MntNeto = &neto
+		encabezado.Totales.TasaIVA = &tasa
+		encabezado.Totales.IVA = &iva
+	}
+
+	dte := &models.DTEXMLModel{
+		Version: "1.0",
+		Documento: models.DocumentoXMLModel{
+			ID:         fmt.Sprintf("T%dF%d", doc.TipoDocumento, doc.Folio),
+			Encabezado: encabezado,
+		},
+	}
+
+	for i, item := range documento.Items {
+		linea := models.DetalleXML{
+			NroLinDet:      i + 1,
+			Nombre:         item.Nombre,
+			UnidadMedida:   item.Unidad,
+			PorcentajeDesc: item.DescuentoPct,
+			Descuento:      descuentoItem(item),
+			MontoItem:      int64(montoItem(item)),
+		}
+		// En la factura exenta todos los ítems son exentos y no se indican línea a línea
+		if item.Exento && doc.TipoDocumento != models.TipoFacturaExenta {
+			linea.IndExe = 1
+		}
+		if item.Cantidad > 0 {
+			cantidad := item.Cantidad
+			linea.Cantidad = &cantidad
+		}
+		if item.Precio > 0 {
+			precio := item.Precio
+			linea.Precio = &precio
+		}
+		dte.Documento.Detalle = append(dte.Documento.Detalle, linea)
+	}
+
+	if documento.DescuentoGlobal > 0 {
+		dte.Documento.DscRcgGlobal = []models.DscRcgGlobalXML{{
+			NroLinDR: 1,
+			TpoMov:   "D",
+			GlosaDR:  "DESCUENTO GLOBAL ITEMES AFECTOS",
+			TpoValor: "%",
+			ValorDR:  documento.DescuentoGlobal,
+		}}
+	}
+
+	for i, ref := range doc.Referencias {
+		dte.Documento.Referencias = append(dte.Documento.Referencias, models.ReferenciaXMLModel{
+			NroLinRef:  i + 1,
+			TipoDocRef: ref.TipoDocumento,
+			FolioRef:   strconv.Itoa(ref.Folio),
+			FechaRef:   ref.FechaReferencia.Format("2006-01-02"),
+			CodigoRef:  string(ref.TipoReferencia),
+			RazonRef:   ref.RazonReferencia,
+		})
+	}
+
+	return dte
+}
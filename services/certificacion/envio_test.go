@@ -0,0 +1,180 @@
+package certificacion
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cafsPrueba registra un CAF con una llave creada para la prueba por cada tipo indicado
+func cafsPrueba(t *testing.T, tipos ...int) *caf.Manager {
+	t.Helper()
+	manager := caf.NewManager("")
+	for _, tipo := range tipos {
+		llave, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		privada := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(llave)})
+
+		autorizacion, err := caf.ParseCAF([]byte(fmt.Sprintf(`<?xml version="1.0"?>
+<AUTORIZACION>
+<CAF version="1.0">
+<DA>
+<RE>76212889-6</RE>
+<RS>COMERCIAL LOS ANDES</RS>
+<TD>%d</TD>
+<RNG><D>1</D><H>50</H></RNG>
+<FA>2024-01-01</FA>
+<RSAPK><M>%s</M><E>%s</E></RSAPK>
+<IDK>100</IDK>
+</DA>
+<FRMA algoritmo="SHA1withRSA">c2lp</FRMA>
+</CAF>
+<RSASK>%s</RSASK>
+</AUTORIZACION>`, tipo,
+			base64.StdEncoding.EncodeToString(llave.N.Bytes()),
+			base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()),
+			privada)))
+		require.NoError(t, err)
+		require.NoError(t, manager.AgregarCAF(autorizacion))
+	}
+	return manager
+}
+
+// firmantePrueba crea un firmante con un certificado autofirmado
+func firmantePrueba(t *testing.T) *xmldsig.Firmante {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "Usuario Certificación"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return xmldsig.NewFirmante(llave, cert)
+}
+
+// uploaderPrueba registra los archivos recibidos y asigna TrackID correlativos
+type uploaderPrueba struct {
+	archivos []string
+}
+
+func (u *uploaderPrueba) Subir(ctx context.Context, token, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error) {
+	u.archivos = append(u.archivos, nombreArchivo)
+	return &models.RespuestaUploadSII{Status: "0", TrackID: fmt.Sprintf("%d", 1000+len(u.archivos))}, nil
+}
+
+func TestConstruirEnvio(t *testing.T) {
+	cafs := cafsPrueba(t, 33, 56, 61)
+	generador := ted.NewGenerador(cafs)
+	params := parametrosPrueba()
+
+	set := setPrueba(t)
+	set.Secciones = set.Secciones[:1]
+	documentos, err := GenerarDocumentos(set, params, NewFoliosCAF(cafs, nil))
+	require.NoError(t, err)
+
+	envio, err := ConstruirEnvio(params, documentos, generador, time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	salida := string(envio)
+	assert.True(t, strings.HasPrefix(salida, `<?xml version="1.0" encoding="ISO-8859-1"?>`))
+	assert.Contains(t, salida, `<Caratula version="1.0"><RutEmisor>76212889-6</RutEmisor><RutEnvia>11111111-1</RutEnvia>`+
+		`<RutReceptor>60803000-K</RutReceptor><FchResol>2014-08-22</FchResol><NroResol>0</NroResol>`+
+		`<TmstFirmaEnv>2024-06-03T10:00:00</TmstFirmaEnv>`+
+		`<SubTotDTE><TpoDTE>33</TpoDTE><NroDTE>2</NroDTE></SubTotDTE>`+
+		`<SubTotDTE><TpoDTE>56</TpoDTE><NroDTE>1</NroDTE></SubTotDTE>`+
+		`<SubTotDTE><TpoDTE>61</TpoDTE><NroDTE>2</NroDTE></SubTotDTE></Caratula>`)
+	assert.Contains(t, salida, `<DescuentoPct>4</DescuentoPct><DescuentoMonto>18104</DescuentoMonto><MontoItem>434506</MontoItem>`)
+	assert.Contains(t, salida, `<DscRcgGlobal><NroLinDR>1</NroLinDR><TpoMov>D</TpoMov><GlosaDR>DESCUENTO GLOBAL ITEMES AFECTOS</GlosaDR>`+
+		`<TpoValor>%</TpoValor><ValorDR>6</ValorDR></DscRcgGlobal>`)
+	assert.Contains(t, salida, `<Referencia><NroLinRef>1</NroLinRef><TpoDocRef>SET</TpoDocRef><FolioRef>0</FolioRef>`+
+		`<FchRef>2024-06-03</FchRef><RazonRef>CASO 4010123-1</RazonRef></Referencia>`)
+	assert.Contains(t, salida, `<Referencia><NroLinRef>2</NroLinRef><TpoDocRef>61</TpoDocRef><FolioRef>1</FolioRef>`+
+		`<FchRef>2024-06-03</FchRef><CodRef>1</CodRef>`)
+
+	firmado, err := firmantePrueba(t).Firmar(envio)
+	require.NoError(t, err)
+
+	// Cada DTE y el SetDTE quedan firmados
+	verificaciones, err := xmldsig.VerificarFirmas(firmado)
+	require.NoError(t, err)
+	require.Len(t, verificaciones, 6)
+	for _, v := range verificaciones {
+		assert.NoError(t, v.Error, v.Ruta)
+	}
+
+	// Cada timbre corresponde al CAF de su tipo y al documento
+	doc, err := xmldsig.ParseDocument(firmado)
+	require.NoError(t, err)
+	timbres := doc.FindElements("//TED")
+	require.Len(t, timbres, 5)
+	datos, err := generador.Verificar(timbres[1])
+	require.NoError(t, err)
+	assert.Equal(t, 33, datos.TipoDTE)
+	assert.Equal(t, int64(2), datos.Folio)
+	assert.Equal(t, int64(521239), datos.MontoTotal)
+}
+
+func TestRunnerEjecutar(t *testing.T) {
+	cafs := cafsPrueba(t, 33, 52, 56, 61)
+	uploader := &uploaderPrueba{}
+	runner := NewRunner(parametrosPrueba(), ted.NewGenerador(cafs), firmantePrueba(t), uploader, "TOKEN")
+	runner.ahora = func() time.Time { return time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC) }
+
+	salida := t.TempDir()
+	reporte, err := runner.Ejecutar(context.Background(), setPrueba(t), NewFoliosCAF(cafs, map[models.TipoDTE]int{33: 10}), salida)
+	require.NoError(t, err)
+
+	require.Len(t, reporte.Casos, 7)
+	assert.Equal(t, ResultadoCaso{
+		Set: "SET BASICO", Atencion: "4010123", Caso: "4010123-1", TipoDTE: 33, Folio: 10,
+		Archivo: "EnvioDTE_SET_BASICO_4010123.xml", TrackID: "1001",
+	}, reporte.Casos[0])
+	assert.Equal(t, ResultadoCaso{
+		Set: "SET GUIA DE DESPACHO", Atencion: "4010124", Caso: "4010124-2", TipoDTE: 52, Folio: 2,
+		Archivo: "EnvioDTE_SET_GUIA_DE_DESPACHO_4010124.xml", TrackID: "1002",
+	}, reporte.Casos[6])
+
+	require.Len(t, reporte.Libros, 2)
+	assert.Equal(t, models.TipoOperacionCompra, reporte.Libros[0].TipoOperacion)
+	assert.Equal(t, "1003", reporte.Libros[0].TrackID)
+	assert.Equal(t, "LibroVentas_SET_LIBRO_DE_VENTAS_4010126.xml", reporte.Libros[1].Archivo)
+	assert.Len(t, uploader.archivos, 4)
+
+	// El libro de compras es especial, con el número de atención como folio de notificación
+	libro, err := os.ReadFile(filepath.Join(salida, reporte.Libros[0].Archivo))
+	require.NoError(t, err)
+	assert.Contains(t, string(libro), `<TipoLibro>ESPECIAL</TipoLibro>`)
+	assert.Contains(t, string(libro), `<FolioNotificacion>4010125</FolioNotificacion>`)
+	verificaciones, err := xmldsig.VerificarFirmas(libro)
+	require.NoError(t, err)
+	require.Len(t, verificaciones, 1)
+	assert.NoError(t, verificaciones[0].Error)
+
+	texto, err := os.ReadFile(filepath.Join(salida, "reporte.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(texto), "4010123-1")
+	assert.FileExists(t, filepath.Join(salida, "reporte.json"))
+}
@@ -0,0 +1,551 @@
+package certificacion
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/exportacion"
+	"github.com/cursor/FMgo/services/facturacompra"
+	"github.com/cursor/FMgo/services/ted"
+)
+
+const (
+	// tasaIVA es la tasa de IVA de los documentos nacionales del set
+	tasaIVA = 19.0
+	// TipoDocRefSet es el tipo de documento con que cada DTE referencia su caso del set
+	TipoDocRefSet = "SET"
+)
+
+// Contribuyente contiene los datos de emisor, receptor o vendedor usados en el set
+type Contribuyente struct {
+	RUT         string `json:"rut"`
+	RazonSocial string `json:"razon_social"`
+	Giro        string `json:"giro"`
+	Direccion   string `json:"direccion"`
+	Comuna      string `json:"comuna"`
+	Ciudad      string `json:"ciudad"`
+}
+
+// Transporte contiene los datos del despacho de las guías realizadas por el emisor
+type Transporte struct {
+	Patente          string `json:"patente"`
+	RutTransportista string `json:"rut_transportista"`
+	RutChofer        string `json:"rut_chofer"`
+	NombreChofer     string `json:"nombre_chofer"`
+	DireccionDestino string `json:"direccion_destino"`
+	ComunaDestino    string `json:"comuna_destino"`
+	CiudadDestino    string `json:"ciudad_destino"`
+}
+
+// Parametros contiene los datos del contribuyente que se certifica y de las contrapartes con
+// que se emiten los documentos del set
+type Parametros struct {
+	Emisor       Contribuyente `json:"emisor"`
+	Receptor     Contribuyente `json:"receptor"`
+	Vendedor     Contribuyente `json:"vendedor"`   // vendedor de las facturas de compra; por defecto el receptor
+	Extranjero   Contribuyente `json:"extranjero"` // receptor de los documentos de exportación
+	RutEnvia     string        `json:"rut_envia"`
+	FchResol     string        `json:"fch_resol"` // AAAA-MM-DD
+	NroResol     int           `json:"nro_resol"`
+	FechaEmision time.Time     `json:"fecha_emision"`
+	Transporte   Transporte    `json:"transporte"`
+
+	// Datos de exportación por defecto; los campos del caso los reemplazan
+	Exportacion models.DatosExportacion `json:"exportacion"`
+	// Códigos de las tablas de Aduana para glosas del set que no tienen código conocido
+	// (puertos, países, tipos de bulto, formas de pago), indexados por la glosa
+	CodigosAduana map[string]int `json:"codigos_aduana"`
+
+	// Folio de notificación con que el SII solicita los libros del set
+	FolioNotificacion int64 `json:"folio_notificacion"`
+}
+
+// Validar verifica que estén los datos necesarios para generar el set
+func (p Parametros) Validar() error {
+	if p.Emisor.RUT == "" || p.Emisor.RazonSocial == "" {
+		return fmt.Errorf("RUT y razón social del emisor requeridos")
+	}
+	if p.Receptor.RUT == "" || p.Receptor.RazonSocial == "" {
+		return fmt.Errorf("RUT y razón social del receptor requeridos")
+	}
+	if p.RutEnvia == "" {
+		return fmt.Errorf("RUT del usuario que envía requerido")
+	}
+	if _, err := time.Parse("2006-01-02", p.FchResol); err != nil {
+		return fmt.Errorf("fecha de resolución inválida: %s", p.FchResol)
+	}
+	if p.FechaEmision.IsZero() {
+		return fmt.Errorf("fecha de emisión requerida")
+	}
+	return nil
+}
+
+// Folios entrega el siguiente folio autorizado de un tipo de documento
+type Folios interface {
+	SiguienteFolio(tipo models.TipoDTE) (int, error)
+}
+
+// FoliosCAF asigna folios correlativos dentro del rango de los CAF de certificación
+type FoliosCAF struct {
+	cafs       ted.Autorizaciones
+	siguientes map[models.TipoDTE]int
+}
+
+// NewFoliosCAF crea un asignador de folios sobre los CAF indicados. desde permite partir
+// desde un folio posterior al inicio del CAF cuando ya se usaron folios en otro intento
+func NewFoliosCAF(cafs ted.Autorizaciones, desde map[models.TipoDTE]int) *FoliosCAF {
+	siguientes := make(map[models.TipoDTE]int, len(desde))
+	for tipo, folio := range desde {
+		siguientes[tipo] = folio
+	}
+	return &FoliosCAF{cafs: cafs, siguientes: siguientes}
+}
+
+// SiguienteFolio retorna el siguiente folio del CAF del tipo indicado
+func (f *FoliosCAF) SiguienteFolio(tipo models.TipoDTE) (int, error) {
+	autorizacion, err := f.cafs.GetCAF(int(tipo))
+	if err != nil {
+		return 0, fmt.Errorf("error al obtener CAF del tipo %d: %v", tipo, err)
+	}
+	folio := f.siguientes[tipo]
+	if folio < autorizacion.FolioInicio {
+		folio = autorizacion.FolioInicio
+	}
+	if folio > autorizacion.FolioFinal {
+		return 0, fmt.Errorf("el CAF del tipo %d no tiene folios disponibles (%d-%d)", tipo, autorizacion.FolioInicio, autorizacion.FolioFinal)
+	}
+	f.siguientes[tipo] = folio + 1
+	return folio, nil
+}
+
+// DocumentoCaso es el documento generado para un caso del set
+type DocumentoCaso struct {
+	Set      string
+	Atencion string
+	Caso     string
+	TipoDTE  models.TipoDTE
+	Folio    int
+
+	// Documento contiene encabezado, montos y referencias; en las guías es el documento
+	// tributario embebido en Guia
+	Documento *models.DocumentoTributario
+	Guia      *models.GuiaDespacho
+
+	// Items son las líneas tal como se informan en el XML, con cantidades decimales y
+	// descuentos por ítem; DescuentoGlobal es el porcentaje sobre los ítems afectos
+	Items           []ItemCaso
+	DescuentoGlobal float64
+}
+
+// GenerarDocumentos genera los documentos de todos los casos del set, en orden, asignando
+// folios de los CAF y referenciando cada documento a su caso. Las notas referencian el
+// documento generado para el caso que indican con el código de referencia que corresponde
+// a la razón: 1 anula, 2 corrige texto y 3 corrige montos.
+func GenerarDocumentos(set *SetPruebas, params Parametros, folios Folios) ([]*DocumentoCaso, error) {
+	if err := params.Validar(); err != nil {
+		return nil, err
+	}
+
+	var documentos []*DocumentoCaso
+	generados := make(map[string]*DocumentoCaso)
+	for _, seccion := range set.Secciones {
+		for _, caso := range seccion.Casos {
+			folio, err := folios.SiguienteFolio(caso.TipoDTE)
+			if err != nil {
+				return nil, fmt.Errorf("caso %s: %v", caso.Numero, err)
+			}
+			doc := &DocumentoCaso{
+				Set:      seccion.Nombre,
+				Atencion: seccion.Atencion,
+				Caso:     caso.Numero,
+				TipoDTE:  caso.TipoDTE,
+				Folio:    folio,
+			}
+
+			switch {
+			case caso.TipoDTE == models.TipoGuiaDespacho:
+				err = generarGuia(doc, caso, params)
+			case caso.TipoDTE == models.TipoFacturaCompra:
+				err = generarFacturaCompra(doc, caso, params)
+			case caso.TipoDTE.EsExportacion():
+				err = generarExportacion(doc, caso, params, generados)
+			default:
+				err = generarNacional(doc, caso, params, generados)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("caso %s: %v", caso.Numero, err)
+			}
+
+			generados[caso.Numero] = doc
+			documentos = append(documentos, doc)
+		}
+	}
+
+	return documentos, nil
+}
+
+// documentoBase arma el encabezado del documento con el emisor, el receptor indicado y la
+// referencia al caso del set
+func documentoBase(doc *DocumentoCaso, params Parametros, receptor Contribuyente) *models.DocumentoTributario {
+	return &models.DocumentoTributario{
+		Folio:               doc.Folio,
+		FechaEmision:        params.FechaEmision,
+		TipoDocumento:       doc.TipoDTE,
+		TipoDTE:             strconv.Itoa(int(doc.TipoDTE)),
+		RUTEmisor:           params.Emisor.RUT,
+		RazonSocialEmisor:   params.Emisor.RazonSocial,
+		GiroEmisor:          params.Emisor.Giro,
+		DireccionEmisor:     params.Emisor.Direccion,
+		ComunaEmisor:        params.Emisor.Comuna,
+		RUTReceptor:         receptor.RUT,
+		RazonSocialReceptor: receptor.RazonSocial,
+		GiroReceptor:        receptor.Giro,
+		DireccionReceptor:   receptor.Direccion,
+		ComunaReceptor:      receptor.Comuna,
+		Estado:              models.EstadoDTEEmitido,
+		Emisor: &models.Emisor{
+			RUT:           params.Emisor.RUT,
+			RazonSocial:   params.Emisor.RazonSocial,
+			GiroComercial: params.Emisor.Giro,
+			Direccion:     params.Emisor.Direccion,
+			Comuna:        params.Emisor.Comuna,
+			Ciudad:        params.Emisor.Ciudad,
+		},
+		Receptor: &models.Receptor{
+			RUT:           receptor.RUT,
+			RazonSocial:   receptor.RazonSocial,
+			GiroComercial: receptor.Giro,
+			Direccion:     receptor.Direccion,
+			Comuna:        receptor.Comuna,
+			Ciudad:        receptor.Ciudad,
+		},
+		Referencias: []models.Referencia{{
+			TipoDocumento:   TipoDocRefSet,
+			FechaReferencia: params.FechaEmision,
+			RazonReferencia: "CASO " + doc.Caso,
+		}},
+	}
+}
+
+// generarNacional genera facturas afectas y exentas y notas de crédito y débito
+func generarNacional(doc *DocumentoCaso, caso *Caso, params Parametros, generados map[string]*DocumentoCaso) error {
+	doc.Documento = documentoBase(doc, params, params.Receptor)
+
+	if caso.TipoDTE == models.TipoNotaCredito || caso.TipoDTE == models.TipoNotaDebito {
+		if err := aplicarReferencia(doc, caso, params, generados); err != nil {
+			return err
+		}
+	} else {
+		descuento, err := caso.DescuentoGlobal()
+		if err != nil {
+			return err
+		}
+		doc.Items, doc.DescuentoGlobal = caso.Items, descuento
+	}
+
+	if caso.TipoDTE == models.TipoFacturaExenta {
+		for i := range doc.Items {
+			doc.Items[i].Exento = true
+		}
+	}
+	if len(doc.Items) == 0 {
+		return fmt.Errorf("el caso no tiene ítems")
+	}
+
+	calcularMontos(doc)
+	return nil
+}
+
+// aplicarReferencia completa la nota con la referencia al documento del caso indicado y
+// con los ítems que corresponden a su código de referencia
+func aplicarReferencia(doc *DocumentoCaso, caso *Caso, params Parametros, generados map[string]*DocumentoCaso) error {
+	numero := caso.CasoReferenciado()
+	if numero == "" {
+		return fmt.Errorf("la nota no indica el caso que referencia")
+	}
+	original, ok := generados[numero]
+	if !ok {
+		return fmt.Errorf("el caso referenciado %s no se generó antes de la nota", numero)
+	}
+
+	razon := caso.Campo(CampoRazonReferencia)
+	codigo := codigoReferencia(razon, caso)
+	switch codigo {
+	case models.TipoAnula:
+		doc.Items = append([]ItemCaso(nil), original.Items...)
+		doc.DescuentoGlobal = original.DescuentoGlobal
+	case models.TipoCorrige:
+		// La corrección de texto se informa con una línea sin montos
+		doc.Items = []ItemCaso{{Nombre: razon}}
+	default:
+		items, err := itemsCorregidos(caso.Items, original.Items)
+		if err != nil {
+			return err
+		}
+		doc.Items = items
+	}
+
+	doc.Documento.Referencias = append(doc.Documento.Referencias, models.Referencia{
+		TipoDocumento:   strconv.Itoa(int(original.TipoDTE)),
+		TipoReferencia:  codigo,
+		Folio:           original.Folio,
+		FechaReferencia: original.Documento.FechaEmision,
+		RazonReferencia: razon,
+	})
+	return nil
+}
+
+// codigoReferencia determina el código de referencia de una nota según la razón del caso
+func codigoReferencia(razon string, caso *Caso) models.TipoReferencia {
+	razon = normalizar(razon)
+	switch {
+	case strings.Contains(razon, "ANULA"):
+		return models.TipoAnula
+	case strings.Contains(razon, "CORRIGE") && len(caso.Items) == 0:
+		return models.TipoCorrige
+	default:
+		return models.TipoPreciosCantidad
+	}
+}
+
+// itemsCorregidos completa los ítems de una nota que corrige montos con el precio y el
+// descuento del ítem del documento original cuando el caso sólo indica la cantidad
+func itemsCorregidos(items, originales []ItemCaso) ([]ItemCaso, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("la nota que corrige montos debe indicar ítems")
+	}
+	corregidos := make([]ItemCaso, len(items))
+	for i, item := range items {
+		for _, original := range originales {
+			if normalizar(original.Nombre) != normalizar(item.Nombre) {
+				continue
+			}
+			if item.Precio == 0 {
+				item.Precio = original.Precio
+			}
+			if item.DescuentoPct == 0 {
+				item.DescuentoPct = original.DescuentoPct
+			}
+			if item.Unidad == "" {
+				item.Unidad = original.Unidad
+			}
+			item.Exento = item.Exento || original.Exento
+			break
+		}
+		if item.Precio == 0 {
+			return nil, fmt.Errorf("el ítem %q no tiene precio ni existe en el documento referenciado", item.Nombre)
+		}
+		corregidos[i] = item
+	}
+	return corregidos, nil
+}
+
+// calcularMontos calcula el monto de cada línea, el neto con el descuento global sobre los
+// ítems afectos, el exento, el IVA y el total
+func calcularMontos(doc *DocumentoCaso) {
+	var afecto, exento float64
+	doc.Documento.Detalles = nil
+	for _, item := range doc.Items {
+		monto := montoItem(item)
+		if item.Exento {
+			exento += monto
+		} else {
+			afecto += monto
+		}
+		doc.Documento.Detalles = append(doc.Documento.Detalles, models.DetalleTributario{
+			Descripcion:    item.Nombre,
+			Cantidad:       int(math.Round(item.Cantidad)),
+			PrecioUnitario: item.Precio,
+			MontoItem:      monto,
+			Exento:         item.Exento,
+			UnidadMedida:   item.Unidad,
+		})
+	}
+
+	neto := afecto - math.Round(afecto*doc.DescuentoGlobal/100)
+	documento := doc.Documento
+	documento.MontoNeto = neto
+	documento.MontoExento = exento
+	documento.TasaIVA = tasaIVA
+	documento.MontoIVA = math.Round(neto * tasaIVA / 100)
+	documento.MontoTotal = neto + exento + documento.MontoIVA
+}
+
+// descuentoItem retorna el descuento en pesos de una línea
+func descuentoItem(item ItemCaso) float64 {
+	return math.Round(item.Cantidad * item.Precio * item.DescuentoPct / 100)
+}
+
+// montoItem retorna el monto de una línea: cantidad por precio menos el descuento
+func montoItem(item ItemCaso) float64 {
+	return math.Round(item.Cantidad*item.Precio - descuentoItem(item))
+}
+
+// generarGuia genera una guía de despacho: el motivo determina el indicador de traslado y
+// "TRASLADO POR" el tipo de despacho; los traslados internos se emiten al mismo emisor
+func generarGuia(doc *DocumentoCaso, caso *Caso, params Parametros) error {
+	indicador, err := indicadorTraslado(caso.Campo(CampoMotivo))
+	if err != nil {
+		return err
+	}
+	receptor := params.Receptor
+	if indicador == models.IndTrasladoInterno {
+		receptor = params.Emisor
+	}
+
+	guia := &models.GuiaDespacho{
+		DocumentoTributario: *documentoBase(doc, params, receptor),
+		IndicadorTraslado:   indicador,
+		TipoDespacho:        tipoDespacho(caso.Campo(CampoTrasladoPor)),
+		CiudadOrigen:        params.Emisor.Ciudad,
+	}
+	if guia.DespachoPorEmisor() {
+		transporte := params.Transporte
+		guia.Patente = transporte.Patente
+		guia.RutTransportista = transporte.RutTransportista
+		guia.RutChofer = transporte.RutChofer
+		guia.NombreChofer = transporte.NombreChofer
+		guia.DireccionDestino, guia.ComunaDestino, guia.CiudadDestino = transporte.DireccionDestino, transporte.ComunaDestino, transporte.CiudadDestino
+		if guia.DireccionDestino == "" {
+			guia.DireccionDestino, guia.ComunaDestino, guia.CiudadDestino = receptor.Direccion, receptor.Comuna, receptor.Ciudad
+		}
+	}
+
+	doc.Items = caso.Items
+	doc.Guia = guia
+	doc.Documento = &guia.DocumentoTributario
+	calcularMontos(doc)
+
+	for i, item := range doc.Items {
+		guia.Items = append(guia.Items, models.Item{
+			NumeroLinea:         i + 1,
+			Nombre:              item.Nombre,
+			Cantidad:            item.Cantidad,
+			UnidadMedida:        item.Unidad,
+			PrecioUnitario:      item.Precio,
+			PorcentajeDescuento: item.DescuentoPct,
+			Descuento:           descuentoItem(item),
+			MontoItem:           montoItem(item),
+			Exento:              item.Exento,
+		})
+	}
+	guia.MontoNeto, guia.MontoExento, guia.MontoIVA = guia.DocumentoTributario.MontoNeto, guia.DocumentoTributario.MontoExento, guia.DocumentoTributario.MontoIVA
+	return nil
+}
+
+// indicadorTraslado traduce el motivo del caso al indicador de traslado de la guía
+func indicadorTraslado(motivo string) (string, error) {
+	motivo = normalizar(motivo)
+	switch {
+	case motivo == "":
+		return "", fmt.Errorf("la guía no indica el motivo del traslado")
+	case strings.Contains(motivo, "EXPORTACION"):
+		if strings.Contains(motivo, "VENTA") {
+			return models.IndTrasladoVentaParaExportacion, nil
+		}
+		return models.IndTrasladoExportacion, nil
+	case strings.Contains(motivo, "POR EFECTUAR"):
+		return models.IndTrasladoVentaPorEfectuar, nil
+	case strings.Contains(motivo, "VENTA"):
+		return models.IndTrasladoVenta, nil
+	case strings.Contains(motivo, "CONSIGNACION"):
+		return models.IndTrasladoConsignacion, nil
+	case strings.Contains(motivo, "GRATUITA"):
+		return models.IndTrasladoEntregaGratuita, nil
+	case strings.Contains(motivo, "DEVOLUCION"):
+		return models.IndTrasladoDevolucion, nil
+	case strings.Contains(motivo, "TRASLADO") && (strings.Contains(motivo, "BODEGA") || strings.Contains(motivo, "INTERNO")):
+		return models.IndTrasladoInterno, nil
+	default:
+		return models.IndTrasladoOtrosNoVenta, nil
+	}
+}
+
+// tipoDespacho traduce el campo "TRASLADO POR" al tipo de despacho; vacío si no se indica
+func tipoDespacho(trasladoPor string) string {
+	trasladoPor = normalizar(trasladoPor)
+	switch {
+	case strings.Contains(trasladoPor, "EMISOR") && strings.Contains(trasladoPor, "OTRAS"):
+		return models.TipoDespachoEmisorAOtrasInstal
+	case strings.Contains(trasladoPor, "EMISOR"):
+		return models.TipoDespachoEmisorACliente
+	case strings.Contains(trasladoPor, "CLIENTE") || strings.Contains(trasladoPor, "RECEPTOR"):
+		return models.TipoDespachoReceptor
+	default:
+		return ""
+	}
+}
+
+// generarFacturaCompra genera una factura de compra con retención total del IVA, emitida al
+// vendedor de los parámetros
+func generarFacturaCompra(doc *DocumentoCaso, caso *Caso, params Parametros) error {
+	vendedor := params.Vendedor
+	if vendedor.RUT == "" {
+		vendedor = params.Receptor
+	}
+	doc.Documento = documentoBase(doc, params, vendedor)
+	doc.Documento.RetencionIVA = &models.RetencionIVA{Codigo: models.CodigoIVARetenidoTotal}
+	doc.Items = caso.Items
+
+	for _, item := range doc.Items {
+		doc.Documento.Detalles = append(doc.Documento.Detalles, models.DetalleTributario{
+			Descripcion:    item.Nombre,
+			Cantidad:       int(math.Round(item.Cantidad)),
+			PrecioUnitario: item.Precio,
+			MontoItem:      montoItem(item),
+			Exento:         item.Exento,
+			UnidadMedida:   item.Unidad,
+		})
+	}
+	return facturacompra.Calcular(doc.Documento)
+}
+
+// generarExportacion genera documentos de exportación. La factura toma los datos de Aduana de
+// los campos del caso; las notas copian los datos de exportación de la factura referenciada
+func generarExportacion(doc *DocumentoCaso, caso *Caso, params Parametros, generados map[string]*DocumentoCaso) error {
+	extranjero := params.Extranjero
+	extranjero.RUT = models.RutReceptorExtranjero
+	doc.Documento = documentoBase(doc, params, extranjero)
+
+	if caso.TipoDTE == models.TipoFacturaExportacion {
+		datos := params.Exportacion
+		datos.Aduana.TipoBultos = append([]models.TipoBulto(nil), datos.Aduana.TipoBultos...)
+		if err := aplicarCamposExportacion(&datos, caso, params.CodigosAduana); err != nil {
+			return err
+		}
+		doc.Documento.Exportacion = &datos
+		doc.Items = caso.Items
+	} else {
+		if err := aplicarReferencia(doc, caso, params, generados); err != nil {
+			return err
+		}
+		original := generados[caso.CasoReferenciado()]
+		if original.Documento.Exportacion == nil {
+			return fmt.Errorf("el caso referenciado %s no es un documento de exportación", original.Caso)
+		}
+		datos := *original.Documento.Exportacion
+		doc.Documento.Exportacion = &datos
+	}
+
+	for _, item := range doc.Items {
+		doc.Documento.Detalles = append(doc.Documento.Detalles, models.DetalleTributario{
+			Descripcion:    item.Nombre,
+			Cantidad:       int(math.Round(item.Cantidad)),
+			PrecioUnitario: item.Precio,
+			UnidadMedida:   item.Unidad,
+		})
+	}
+	if err := exportacion.Calcular(doc.Documento); err != nil {
+		return err
+	}
+
+	aduana := &doc.Documento.Exportacion.Aduana
+	if caso.TipoDTE == models.TipoFacturaExportacion && aduana.CodClauVenta != 0 && aduana.TotClauVenta == 0 {
+		aduana.TotClauVenta = doc.Documento.MontoTotal
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package certificacion
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/libros"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// LibroSet es un libro de compra o venta simulado que pide el set de pruebas
+type LibroSet struct {
+	Set           string
+	Atencion      string
+	TipoOperacion string
+	Libro         *models.LibroCompraVentaXML
+}
+
+// ConstruirLibros arma los libros de las secciones de libros del set. El libro de ventas se
+// construye con los documentos generados para el set básico y el de compras con los registros
+// de la tabla del set. Ambos son libros especiales cuyo folio de notificación es el de los
+// parámetros o, si no se indica, el número de atención de la sección
+func ConstruirLibros(set *SetPruebas, params Parametros, documentos []*DocumentoCaso) ([]LibroSet, error) {
+	var resultado []LibroSet
+	for _, seccion := range set.Secciones {
+		var (
+			operacion string
+			docs      []models.DocumentoTributario
+		)
+		switch {
+		case seccion.EsLibroVentas():
+			operacion = models.TipoOperacionVenta
+			for _, documento := range documentos {
+				if documento.Set == "SET BASICO" {
+					docs = append(docs, *documento.Documento)
+				}
+			}
+		case seccion.EsLibroCompras():
+			operacion = models.TipoOperacionCompra
+			docs = documentosCompra(seccion.Compras, params)
+		default:
+			continue
+		}
+		if len(docs) == 0 {
+			return nil, fmt.Errorf("el %s no tiene documentos que informar", seccion.Nombre)
+		}
+
+		folioNotificacion := params.FolioNotificacion
+		if folioNotificacion == 0 {
+			folioNotificacion, _ = strconv.ParseInt(seccion.Atencion, 10, 64)
+		}
+		libro, err := libros.ConstruirLibro(libros.ParametrosLibro{
+			RutEmisor:         params.Emisor.RUT,
+			RutEnvia:          params.RutEnvia,
+			Periodo:           params.FechaEmision.Format("2006-01"),
+			FchResol:          params.FchResol,
+			NroResol:          params.NroResol,
+			TipoOperacion:     operacion,
+			TipoLibro:         models.TipoLibroEspecial,
+			TipoEnvio:         models.TipoEnvioTotal,
+			FolioNotificacion: folioNotificacion,
+			IncluirDetalle:    true,
+		}, docs)
+		if err != nil {
+			return nil, fmt.Errorf("error al construir %s: %v", seccion.Nombre, err)
+		}
+
+		resultado = append(resultado, LibroSet{
+			Set:           seccion.Nombre,
+			Atencion:      seccion.Atencion,
+			TipoOperacion: operacion,
+			Libro:         libro,
+		})
+	}
+	return resultado, nil
+}
+
+// documentosCompra convierte los registros del libro de compras en documentos emitidos por el
+// proveedor (el receptor de los parámetros) al contribuyente que se certifica
+func documentosCompra(registros []RegistroCompra, params Parametros) []models.DocumentoTributario {
+	docs := make([]models.DocumentoTributario, 0, len(registros))
+	for _, registro := range registros {
+		doc := models.DocumentoTributario{
+			Folio:               registro.Folio,
+			FechaEmision:        params.FechaEmision,
+			TipoDocumento:       registro.TipoDTE,
+			TipoDTE:             strconv.Itoa(int(registro.TipoDTE)),
+			RUTEmisor:           params.Receptor.RUT,
+			RazonSocialEmisor:   params.Receptor.RazonSocial,
+			RUTReceptor:         params.Emisor.RUT,
+			RazonSocialReceptor: params.Emisor.RazonSocial,
+			MontoExento:         registro.MontoExento,
+			MontoNeto:           registro.MontoAfecto,
+			Estado:              models.EstadoDTERecibido,
+		}
+		if doc.MontoNeto > 0 {
+			doc.TasaIVA = tasaIVA
+			doc.MontoIVA = math.Round(doc.MontoNeto * tasaIVA / 100)
+		}
+		doc.MontoTotal = doc.MontoNeto + doc.MontoExento + doc.MontoIVA
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// serializar escribe un modelo XML codificado en ISO-8859-1, como lo exige el SII
+func serializar(modelo interface{}) ([]byte, error) {
+	data, err := xml.Marshal(modelo)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar XML: %v", err)
+	}
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	doc.InsertChildAt(0, etree.NewProcInst("xml", `version="1.0" encoding="ISO-8859-1"`))
+	return xmldsig.Serializar(doc)
+}
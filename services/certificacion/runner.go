@@ -0,0 +1,221 @@
+package certificacion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// Uploader envía un archivo al SII y retorna la respuesta con su TrackID
+type Uploader interface {
+	Subir(ctx context.Context, token, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error)
+}
+
+// ResultadoCaso indica con qué folio se emitió un caso y en qué envío se informó
+type ResultadoCaso struct {
+	Set      string `json:"set"`
+	Atencion string `json:"atencion"`
+	Caso     string `json:"caso"`
+	TipoDTE  int    `json:"tipo_dte"`
+	Folio    int    `json:"folio"`
+	Archivo  string `json:"archivo"`
+	TrackID  string `json:"track_id,omitempty"`
+}
+
+// ResultadoLibro indica el archivo y el TrackID de un libro del set
+type ResultadoLibro struct {
+	Set           string `json:"set"`
+	Atencion      string `json:"atencion"`
+	TipoOperacion string `json:"tipo_operacion"`
+	Archivo       string `json:"archivo"`
+	TrackID       string `json:"track_id,omitempty"`
+}
+
+// Reporte es el resultado de ejecutar el set de pruebas, que se entrega al SII al declarar
+// el avance de la certificación
+type Reporte struct {
+	Fecha  time.Time        `json:"fecha"`
+	Casos  []ResultadoCaso  `json:"casos"`
+	Libros []ResultadoLibro `json:"libros,omitempty"`
+}
+
+// Texto retorna el reporte como tabla: caso, documento, folio y TrackID
+func (r *Reporte) Texto() string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SET\tCASO\tTIPO\tFOLIO\tTRACKID\tARCHIVO")
+	for _, caso := range r.Casos {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", caso.Set, caso.Caso, caso.TipoDTE, caso.Folio, caso.TrackID, caso.Archivo)
+	}
+	for _, libro := range r.Libros {
+		fmt.Fprintf(w, "%s\tLIBRO %s\t\t\t%s\t%s\n", libro.Set, libro.TipoOperacion, libro.TrackID, libro.Archivo)
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// Runner ejecuta el set de pruebas: genera y timbra los documentos, arma y firma un envío por
+// cada set y los libros simulados, los escribe en el directorio de salida y, si tiene un
+// uploader, los envía al ambiente de certificación
+type Runner struct {
+	params    Parametros
+	timbrador Timbrador
+	firmador  Firmador
+	uploader  Uploader
+	token     string
+	ahora     func() time.Time
+}
+
+// NewRunner crea un runner. Con uploader nil los archivos sólo se escriben en disco
+func NewRunner(params Parametros, timbrador Timbrador, firmador Firmador, uploader Uploader, token string) *Runner {
+	return &Runner{
+		params:    params,
+		timbrador: timbrador,
+		firmador:  firmador,
+		uploader:  uploader,
+		token:     token,
+		ahora:     time.Now,
+	}
+}
+
+// Ejecutar procesa el set completo y escribe en el directorio de salida los envíos, los
+// libros y el reporte (reporte.json y reporte.txt)
+func (r *Runner) Ejecutar(ctx context.Context, set *SetPruebas, folios Folios, salida string) (*Reporte, error) {
+	documentos, err := GenerarDocumentos(set, r.params, folios)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(salida, 0755); err != nil {
+		return nil, fmt.Errorf("error al crear directorio de salida: %v", err)
+	}
+
+	reporte := &Reporte{Fecha: r.ahora()}
+
+	// Un envío por set, con los documentos en el orden de sus casos
+	var orden []string
+	porSet := make(map[string][]*DocumentoCaso)
+	for _, documento := range documentos {
+		clave := documento.Set + "|" + documento.Atencion
+		if _, ok := porSet[clave]; !ok {
+			orden = append(orden, clave)
+		}
+		porSet[clave] = append(porSet[clave], documento)
+	}
+
+	for _, clave := range orden {
+		grupo := porSet[clave]
+		envio, err := ConstruirEnvio(r.params, grupo, r.timbrador, r.ahora())
+		if err != nil {
+			return nil, fmt.Errorf("error al construir envío del %s: %v", grupo[0].Set, err)
+		}
+		firmado, err := r.firmador.Firmar(envio)
+		if err != nil {
+			return nil, fmt.Errorf("error al firmar envío del %s: %v", grupo[0].Set, err)
+		}
+
+		nombre := nombreArchivo("EnvioDTE", grupo[0].Set, grupo[0].Atencion)
+		trackID, err := r.guardar(ctx, salida, nombre, firmado)
+		if err != nil {
+			return nil, err
+		}
+		for _, documento := range grupo {
+			reporte.Casos = append(reporte.Casos, ResultadoCaso{
+				Set:      documento.Set,
+				Atencion: documento.Atencion,
+				Caso:     documento.Caso,
+				TipoDTE:  int(documento.TipoDTE),
+				Folio:    documento.Folio,
+				Archivo:  nombre,
+				TrackID:  trackID,
+			})
+		}
+	}
+
+	librosSet, err := ConstruirLibros(set, r.params, documentos)
+	if err != nil {
+		return nil, err
+	}
+	for _, libro := range librosSet {
+		data, err := serializar(libro.Libro)
+		if err != nil {
+			return nil, err
+		}
+		firmado, err := r.firmador.Firmar(data)
+		if err != nil {
+			return nil, fmt.Errorf("error al firmar %s: %v", libro.Set, err)
+		}
+
+		prefijo := "LibroVentas"
+		if libro.TipoOperacion == models.TipoOperacionCompra {
+			prefijo = "LibroCompras"
+		}
+		nombre := nombreArchivo(prefijo, libro.Set, libro.Atencion)
+		trackID, err := r.guardar(ctx, salida, nombre, firmado)
+		if err != nil {
+			return nil, err
+		}
+		reporte.Libros = append(reporte.Libros, ResultadoLibro{
+			Set:           libro.Set,
+			Atencion:      libro.Atencion,
+			TipoOperacion: libro.TipoOperacion,
+			Archivo:       nombre,
+			TrackID:       trackID,
+		})
+	}
+
+	if err := escribirReporte(salida, reporte); err != nil {
+		return nil, err
+	}
+	return reporte, nil
+}
+
+// guardar escribe el archivo en el directorio de salida y, si hay uploader, lo envía al SII
+// y retorna su TrackID
+func (r *Runner) guardar(ctx context.Context, salida, nombre string, data []byte) (string, error) {
+	if err := os.WriteFile(filepath.Join(salida, nombre), data, 0644); err != nil {
+		return "", fmt.Errorf("error al escribir %s: %v", nombre, err)
+	}
+	if r.uploader == nil {
+		return "", nil
+	}
+
+	respuesta, err := r.uploader.Subir(ctx, r.token, r.params.RutEnvia, r.params.Emisor.RUT, nombre, data)
+	if err != nil {
+		return "", fmt.Errorf("error al enviar %s al SII: %v", nombre, err)
+	}
+	if respuesta.TrackID == "" {
+		return "", fmt.Errorf("el SII no asignó TrackID a %s (estado %s)", nombre, respuesta.Status)
+	}
+	return respuesta.TrackID, nil
+}
+
+// escribirReporte guarda el reporte en JSON y como tabla de texto
+func escribirReporte(salida string, reporte *Reporte) error {
+	data, err := json.MarshalIndent(reporte, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error al serializar reporte: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(salida, "reporte.json"), data, 0644); err != nil {
+		return fmt.Errorf("error al escribir reporte: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(salida, "reporte.txt"), []byte(reporte.Texto()), 0644); err != nil {
+		return fmt.Errorf("error al escribir reporte: %v", err)
+	}
+	return nil
+}
+
+// nombreArchivo arma el nombre del archivo de un set, por ejemplo EnvioDTE_SET_BASICO_4010123.xml
+func nombreArchivo(prefijo, set, atencion string) string {
+	nombre := prefijo + "_" + strings.ReplaceAll(set, " ", "_")
+	if atencion != "" {
+		nombre += "_" + atencion
+	}
+	return nombre + ".xml"
+}
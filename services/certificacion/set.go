@@ -0,0 +1,429 @@
+// Package certificacion ejecuta el set de pruebas de la certificación ante el SII: lee el
+// archivo de texto del set, genera los documentos de cada caso con folios de los CAF de
+// certificación, arma y firma los envíos y los libros, y reporta el folio y el TrackID con
+// que se envió cada caso.
+package certificacion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// Claves de los campos de un caso, normalizadas sin tildes y en mayúsculas
+const (
+	CampoDocumento       = "DOCUMENTO"
+	CampoReferencia      = "REFERENCIA"
+	CampoRazonReferencia = "RAZON REFERENCIA"
+	CampoMotivo          = "MOTIVO"
+	CampoTrasladoPor     = "TRASLADO POR"
+)
+
+// prefijoDescuentoGlobal identifica el campo de descuento global sobre los ítems afectos
+const prefijoDescuentoGlobal = "DESCUENTO GLOBAL"
+
+var (
+	reCaso        = regexp.MustCompile(`^CASO\s+(\d+-\d+)`)
+	reAtencion    = regexp.MustCompile(`ATENCION\s*:?\s*(\d+)`)
+	reCasoRef     = regexp.MustCompile(`CASO\s+(\d+-\d+)`)
+	reSeparador   = regexp.MustCompile(`^[=\-_*\s]+$`)
+	reColumnas    = regexp.MustCompile(`\t+|\s{2,}`)
+	reMiles       = regexp.MustCompile(`^\d{1,3}(\.\d{3})+$`)
+	sinTildes     = strings.NewReplacer("Á", "A", "É", "E", "Í", "I", "Ó", "O", "Ú", "U", "Ü", "U", "á", "A", "é", "E", "í", "I", "ó", "O", "ú", "U", "ü", "U")
+	tiposPorGlosa = map[string]models.TipoDTE{
+		"FACTURA ELECTRONICA":                        models.TipoFactura,
+		"FACTURA NO AFECTA O EXENTA ELECTRONICA":     models.TipoFacturaExenta,
+		"FACTURA EXENTA ELECTRONICA":                 models.TipoFacturaExenta,
+		"FACTURA DE COMPRA ELECTRONICA":              models.TipoFacturaCompra,
+		"GUIA DE DESPACHO":                           models.TipoGuiaDespacho,
+		"GUIA DE DESPACHO ELECTRONICA":               models.TipoGuiaDespacho,
+		"NOTA DE DEBITO ELECTRONICA":                 models.TipoNotaDebito,
+		"NOTA DE CREDITO ELECTRONICA":                models.TipoNotaCredito,
+		"FACTURA DE EXPORTACION ELECTRONICA":         models.TipoFacturaExportacion,
+		"NOTA DE DEBITO DE EXPORTACION ELECTRONICA":  models.TipoNotaDebitoExportacion,
+		"NOTA DE CREDITO DE EXPORTACION ELECTRONICA": models.TipoNotaCreditoExportacion,
+	}
+	// tiposCompra agrega los documentos en papel que aparecen en el libro de compras
+	tiposCompra = map[string]models.TipoDTE{
+		"FACTURA":         30,
+		"FACTURA EXENTA":  32,
+		"NOTA DE DEBITO":  55,
+		"NOTA DE CREDITO": 60,
+	}
+)
+
+// SetPruebas es el contenido del archivo de texto del set de pruebas entregado por el SII
+type SetPruebas struct {
+	Secciones []*Seccion
+}
+
+// Seccion es un set del archivo (básico, guías, compras, exportación, libros), identificado
+// por el número de atención que le asignó el SII
+type Seccion struct {
+	Nombre   string
+	Atencion string
+	Casos    []*Caso
+	Compras  []RegistroCompra
+}
+
+// Caso es un caso del set: el documento a emitir, sus campos y sus ítems
+type Caso struct {
+	Numero    string // número de atención y correlativo, por ejemplo 4010123-1
+	Documento string
+	TipoDTE   models.TipoDTE
+	Campos    map[string]string
+	Items     []ItemCaso
+}
+
+// ItemCaso es una línea de detalle de un caso
+type ItemCaso struct {
+	Nombre       string
+	Cantidad     float64
+	Unidad       string
+	Precio       float64
+	DescuentoPct float64
+	Exento       bool
+}
+
+// RegistroCompra es un documento recibido del libro de compras del set
+type RegistroCompra struct {
+	TipoDocumento string
+	TipoDTE       models.TipoDTE
+	Folio         int
+	Observaciones string
+	MontoExento   float64
+	MontoAfecto   float64
+}
+
+// EsLibroCompras indica si la sección es el set del libro de compras
+func (s *Seccion) EsLibroCompras() bool {
+	return strings.Contains(s.Nombre, "LIBRO DE COMPRAS")
+}
+
+// EsLibroVentas indica si la sección es el set del libro de ventas
+func (s *Seccion) EsLibroVentas() bool {
+	return strings.Contains(s.Nombre, "LIBRO DE VENTAS")
+}
+
+// EsLibroGuias indica si la sección es el set del libro de guías
+func (s *Seccion) EsLibroGuias() bool {
+	return strings.Contains(s.Nombre, "LIBRO DE GUIAS")
+}
+
+// Casos retorna los casos de todas las secciones en el orden del archivo
+func (s *SetPruebas) Casos() []*Caso {
+	var casos []*Caso
+	for _, seccion := range s.Secciones {
+		casos = append(casos, seccion.Casos...)
+	}
+	return casos
+}
+
+// Caso busca un caso por su número
+func (s *SetPruebas) Caso(numero string) *Caso {
+	for _, caso := range s.Casos() {
+		if caso.Numero == numero {
+			return caso
+		}
+	}
+	return nil
+}
+
+// Campo retorna el valor de un campo del caso; la clave se compara normalizada
+func (c *Caso) Campo(clave string) string {
+	return c.Campos[normalizar(clave)]
+}
+
+// CampoConPrefijo retorna el primer campo cuya clave comienza con el prefijo indicado
+func (c *Caso) CampoConPrefijo(prefijo string) (string, bool) {
+	prefijo = normalizar(prefijo)
+	for clave, valor := range c.Campos {
+		if strings.HasPrefix(clave, prefijo) {
+			return valor, true
+		}
+	}
+	return "", false
+}
+
+// CasoReferenciado retorna el número del caso al que apunta el campo REFERENCIA, o vacío
+func (c *Caso) CasoReferenciado() string {
+	if m := reCasoRef.FindStringSubmatch(c.Campo(CampoReferencia)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// DescuentoGlobal retorna el porcentaje de descuento global sobre los ítems afectos
+func (c *Caso) DescuentoGlobal() (float64, error) {
+	valor, ok := c.CampoConPrefijo(prefijoDescuentoGlobal)
+	if !ok {
+		return 0, nil
+	}
+	return parsearNumero(valor)
+}
+
+// ParsearSet lee el archivo de texto del set de pruebas. Reconoce los encabezados de cada set
+// con su número de atención ("SET BASICO - NUMERO DE ATENCION: ..."), los casos ("CASO N-k"), los campos clave-valor
+// separados por tabulación o dos puntos, las tablas de ítems y la tabla del libro de compras
+func ParsearSet(r io.Reader) (*SetPruebas, error) {
+	set := &SetPruebas{}
+	var (
+		seccion  *Seccion
+		caso     *Caso
+		columnas []string
+		nroLinea int
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		nroLinea++
+		linea := strings.TrimRight(scanner.Text(), "\r")
+		limpia := normalizar(linea)
+
+		switch {
+		case limpia == "":
+			// Una línea en blanco cierra la tabla en curso
+			columnas = nil
+			continue
+		case reSeparador.MatchString(limpia):
+			continue
+		case strings.HasPrefix(limpia, "SET ") && reAtencion.MatchString(limpia):
+			seccion = &Seccion{
+				Nombre:   limpia,
+				Atencion: reAtencion.FindStringSubmatch(limpia)[1],
+			}
+			if i := strings.Index(limpia, " - "); i > 0 {
+				seccion.Nombre = strings.TrimSpace(limpia[:i])
+			}
+			set.Secciones = append(set.Secciones, seccion)
+			caso, columnas = nil, nil
+			continue
+		case seccion == nil:
+			// Texto previo al primer set
+			continue
+		}
+
+		if m := reCaso.FindStringSubmatch(limpia); m != nil {
+			caso = &Caso{Numero: m[1], Campos: make(map[string]string)}
+			seccion.Casos = append(seccion.Casos, caso)
+			columnas = nil
+			continue
+		}
+
+		campos := dividirColumnas(linea)
+		primero := normalizar(campos[0])
+
+		switch {
+		case caso != nil && primero == "ITEM":
+			columnas = normalizarTodos(campos)
+			continue
+		case seccion.EsLibroCompras() && strings.HasPrefix(primero, "TIPO DOC"):
+			columnas = normalizarTodos(campos)
+			continue
+		}
+
+		if columnas != nil && !strings.HasPrefix(primero, prefijoDescuentoGlobal) {
+			var err error
+			if seccion.EsLibroCompras() && caso == nil {
+				err = agregarCompra(seccion, campos)
+			} else if caso != nil {
+				err = agregarItem(caso, columnas, campos)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("línea %d: %v", nroLinea, err)
+			}
+			continue
+		}
+
+		if caso == nil {
+			continue
+		}
+		clave, valor, ok := dividirCampo(linea)
+		if !ok {
+			continue
+		}
+		caso.Campos[clave] = valor
+		if clave == CampoDocumento {
+			caso.Documento = normalizar(valor)
+			tipo, ok := tiposPorGlosa[caso.Documento]
+			if !ok {
+				return nil, fmt.Errorf("línea %d: documento no soportado en el caso %s: %s", nroLinea, caso.Numero, valor)
+			}
+			caso.TipoDTE = tipo
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error al leer set de pruebas: %v", err)
+	}
+
+	if len(set.Secciones) == 0 {
+		return nil, fmt.Errorf("el archivo no contiene sets de pruebas")
+	}
+	for _, caso := range set.Casos() {
+		if caso.TipoDTE == 0 {
+			return nil, fmt.Errorf("el caso %s no indica el documento a emitir", caso.Numero)
+		}
+	}
+
+	return set, nil
+}
+
+// agregarItem agrega al caso una fila de la tabla de ítems según las columnas del encabezado
+func agregarItem(caso *Caso, columnas, campos []string) error {
+	item := ItemCaso{Nombre: strings.TrimSpace(campos[0])}
+	item.Exento = strings.Contains(normalizar(item.Nombre), "EXENTO")
+
+	for i := 1; i < len(campos) && i < len(columnas); i++ {
+		valor := strings.TrimSpace(campos[i])
+		columna := columnas[i]
+		if strings.Contains(columna, "UNIDAD") {
+			item.Unidad = valor
+			continue
+		}
+
+		numero, err := parsearNumero(valor)
+		if err != nil {
+			return fmt.Errorf("ítem %q: %v", item.Nombre, err)
+		}
+		switch {
+		case strings.Contains(columna, "CANTIDAD"):
+			item.Cantidad = numero
+		case strings.Contains(columna, "PRECIO"):
+			item.Precio = numero
+		case strings.Contains(columna, "DESCUENTO"):
+			item.DescuentoPct = numero
+		case strings.Contains(columna, "EXENTO"):
+			// Columna de valor exento: el monto informado es el precio del ítem exento
+			item.Precio, item.Exento = numero, true
+		}
+	}
+
+	caso.Items = append(caso.Items, item)
+	return nil
+}
+
+// agregarCompra agrega una fila del libro de compras. Las observaciones y los montos pueden
+// continuar en la línea siguiente, que no comienza con un tipo de documento
+func agregarCompra(seccion *Seccion, campos []string) error {
+	glosa := normalizar(campos[0])
+	tipo, ok := tiposPorGlosa[glosa]
+	if !ok {
+		tipo, ok = tiposCompra[glosa]
+	}
+	if !ok {
+		n := len(seccion.Compras)
+		if n == 0 {
+			return fmt.Errorf("tipo de documento de compra no soportado: %s", campos[0])
+		}
+		completarCompra(&seccion.Compras[n-1], campos)
+		return nil
+	}
+	if len(campos) < 2 {
+		return fmt.Errorf("el registro de compra %s no informa folio", campos[0])
+	}
+
+	folio, err := strconv.Atoi(strings.TrimSpace(campos[1]))
+	if err != nil {
+		return fmt.Errorf("folio de compra inválido: %s", campos[1])
+	}
+	registro := RegistroCompra{TipoDocumento: glosa, TipoDTE: tipo, Folio: folio}
+	completarCompra(&registro, campos[2:])
+
+	seccion.Compras = append(seccion.Compras, registro)
+	return nil
+}
+
+// completarCompra agrega al registro las observaciones y los montos de la fila. Con dos
+// montos el primero es exento y el segundo afecto; un monto único es afecto salvo que las
+// observaciones indiquen que es exento
+func completarCompra(registro *RegistroCompra, campos []string) {
+	var montos []float64
+	for _, campo := range campos {
+		if numero, err := parsearNumero(campo); err == nil {
+			if numero != 0 {
+				montos = append(montos, numero)
+			}
+			continue
+		}
+		registro.Observaciones = strings.TrimSpace(registro.Observaciones + " " + strings.TrimSpace(campo))
+	}
+
+	switch len(montos) {
+	case 0:
+	case 1:
+		if strings.Contains(normalizar(registro.Observaciones), "EXENT") {
+			registro.MontoExento = montos[0]
+		} else {
+			registro.MontoAfecto = montos[0]
+		}
+	default:
+		registro.MontoExento, registro.MontoAfecto = montos[len(montos)-2], montos[len(montos)-1]
+	}
+}
+
+// dividirCampo separa una línea "CLAVE: valor" o "CLAVE<tab>valor"
+func dividirCampo(linea string) (string, string, bool) {
+	linea = strings.TrimSpace(linea)
+	if i := strings.Index(linea, ":"); i > 0 && !strings.ContainsAny(linea[:i], "\t0123456789") {
+		return normalizar(linea[:i]), strings.TrimSpace(linea[i+1:]), true
+	}
+	campos := dividirColumnas(linea)
+	if len(campos) < 2 {
+		return "", "", false
+	}
+	return normalizar(campos[0]), strings.TrimSpace(strings.Join(campos[1:], " ")), true
+}
+
+// dividirColumnas separa una fila por tabulaciones o por dos o más espacios
+func dividirColumnas(linea string) []string {
+	var campos []string
+	for _, campo := range reColumnas.Split(strings.TrimSpace(linea), -1) {
+		if campo = strings.TrimSpace(campo); campo != "" {
+			campos = append(campos, campo)
+		}
+	}
+	if len(campos) == 0 {
+		return []string{""}
+	}
+	return campos
+}
+
+// parsearNumero interpreta montos con punto de miles y coma decimal, porcentajes y valores
+// con signo peso
+func parsearNumero(valor string) (float64, error) {
+	limpio := strings.NewReplacer("$", "", "%", "", " ", "").Replace(strings.TrimSpace(valor))
+	switch {
+	case limpio == "":
+		return 0, nil
+	case strings.Contains(limpio, ","):
+		limpio = strings.ReplaceAll(limpio, ".", "")
+		limpio = strings.ReplaceAll(limpio, ",", ".")
+	case reMiles.MatchString(limpio):
+		limpio = strings.ReplaceAll(limpio, ".", "")
+	}
+	numero, err := strconv.ParseFloat(limpio, 64)
+	if err != nil {
+		return 0, fmt.Errorf("número inválido: %q", valor)
+	}
+	return numero, nil
+}
+
+// normalizar deja el texto en mayúsculas, sin tildes ni espacios repetidos
+func normalizar(texto string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(sinTildes.Replace(texto))), " ")
+}
+
+// normalizarTodos normaliza cada uno de los textos
+func normalizarTodos(textos []string) []string {
+	normalizados := make([]string, len(textos))
+	for i, texto := range textos {
+		normalizados[i] = normalizar(texto)
+	}
+	return normalizados
+}
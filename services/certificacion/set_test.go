@@ -0,0 +1,194 @@
+package certificacion
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setPrueba lee el set de pruebas de testdata
+func setPrueba(t *testing.T) *SetPruebas {
+	t.Helper()
+	archivo, err := os.Open("testdata/set_pruebas.txt")
+	require.NoError(t, err)
+	defer archivo.Close()
+
+	set, err := ParsearSet(archivo)
+	require.NoError(t, err)
+	return set
+}
+
+// parametrosPrueba retorna los datos del contribuyente que se certifica
+func parametrosPrueba() Parametros {
+	return Parametros{
+		Emisor: Contribuyente{
+			RUT: "76212889-6", RazonSocial: "Comercial Los Andes SpA", Giro: "Venta al por mayor",
+			Direccion: "Av. Apoquindo 4500", Comuna: "Las Condes", Ciudad: "Santiago",
+		},
+		Receptor: Contribuyente{
+			RUT: "77777777-7", RazonSocial: "Distribuidora Sur Ltda", Giro: "Comercio",
+			Direccion: "Los Carrera 120", Comuna: "Concepción", Ciudad: "Concepción",
+		},
+		RutEnvia:     "11111111-1",
+		FchResol:     "2014-08-22",
+		NroResol:     0,
+		FechaEmision: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+		Transporte: Transporte{
+			Patente: "HJKL12", RutChofer: "12345678-5", NombreChofer: "Juan Pérez",
+		},
+	}
+}
+
+// foliosFijos asigna folios correlativos desde 1 por tipo de documento
+type foliosFijos map[models.TipoDTE]int
+
+func (f foliosFijos) SiguienteFolio(tipo models.TipoDTE) (int, error) {
+	f[tipo]++
+	return f[tipo], nil
+}
+
+func TestParsearSet(t *testing.T) {
+	set := setPrueba(t)
+
+	require.Len(t, set.Secciones, 4)
+	assert.Equal(t, "SET BASICO", set.Secciones[0].Nombre)
+	assert.Equal(t, "4010123", set.Secciones[0].Atencion)
+	assert.Equal(t, "SET GUIA DE DESPACHO", set.Secciones[1].Nombre)
+	assert.True(t, set.Secciones[2].EsLibroCompras())
+	assert.True(t, set.Secciones[3].EsLibroVentas())
+	assert.Len(t, set.Casos(), 7)
+
+	caso := set.Caso("4010123-2")
+	require.NotNil(t, caso)
+	assert.Equal(t, models.TipoFactura, caso.TipoDTE)
+	assert.Equal(t, []ItemCaso{
+		{Nombre: "Pañuelo AFECTO", Cantidad: 235, Precio: 1926, DescuentoPct: 4},
+		{Nombre: "ITEM 2 SERVICIO EXENTO", Cantidad: 1, Precio: 35200, Exento: true},
+	}, caso.Items)
+	descuento, err := caso.DescuentoGlobal()
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, descuento)
+
+	nota := set.Caso("4010123-4")
+	assert.Equal(t, models.TipoNotaCredito, nota.TipoDTE)
+	assert.Equal(t, "4010123-2", nota.CasoReferenciado())
+	assert.Equal(t, "DEVOLUCION DE MERCADERIAS", nota.Campo("Razón referencia"))
+	assert.Equal(t, []ItemCaso{{Nombre: "Pañuelo AFECTO", Cantidad: 82}}, nota.Items)
+
+	guia := set.Caso("4010124-2")
+	assert.Equal(t, models.TipoGuiaDespacho, guia.TipoDTE)
+	assert.Equal(t, "VENTA", guia.Campo(CampoMotivo))
+	assert.Equal(t, "EMISOR DEL DOCUMENTO AL LOCAL DEL CLIENTE", guia.Campo(CampoTrasladoPor))
+
+	// La fila de la nota de crédito continúa en la línea siguiente con su monto
+	assert.Equal(t, []RegistroCompra{
+		{TipoDocumento: "FACTURA", TipoDTE: 30, Folio: 234, Observaciones: "FACTURA DEL GIRO CON DERECHO A CREDITO", MontoAfecto: 55016},
+		{TipoDocumento: "FACTURA ELECTRONICA", TipoDTE: 33, Folio: 32, Observaciones: "FACTURA DEL GIRO CON DERECHO A CREDITO", MontoExento: 11220, MontoAfecto: 9843},
+		{TipoDocumento: "NOTA DE CREDITO", TipoDTE: 60, Folio: 451, Observaciones: "NOTA DE CREDITO POR DESCUENTO A FACTURA 234", MontoAfecto: 2938},
+	}, set.Secciones[2].Compras)
+}
+
+func TestParsearSetDocumentoNoSoportado(t *testing.T) {
+	_, err := ParsearSet(strings.NewReader("SET BASICO - NUMERO DE ATENCION: 1\nCASO 1-1\nDOCUMENTO\tBOLETA ELECTRONICA\n"))
+	assert.ErrorContains(t, err, "documento no soportado")
+
+	_, err = ParsearSet(strings.NewReader("sin sets\n"))
+	assert.ErrorContains(t, err, "no contiene sets")
+}
+
+func TestParsearNumero(t *testing.T) {
+	casos := map[string]float64{
+		"1.473":   1473,
+		"104,98":  104.98,
+		"1.234,5": 1234.5,
+		"6%":      6,
+		"$ 2.938": 2938,
+		"1.5":     1.5,
+		"":        0,
+	}
+	for valor, esperado := range casos {
+		numero, err := parsearNumero(valor)
+		require.NoError(t, err, valor)
+		assert.Equal(t, esperado, numero, valor)
+	}
+}
+
+func TestGenerarDocumentos(t *testing.T) {
+	documentos, err := GenerarDocumentos(setPrueba(t), parametrosPrueba(), foliosFijos{})
+	require.NoError(t, err)
+	require.Len(t, documentos, 7)
+	porCaso := make(map[string]*DocumentoCaso)
+	for _, doc := range documentos {
+		porCaso[doc.Caso] = doc
+		// Todo documento referencia su caso del set
+		ref := doc.Documento.Referencias[0]
+		assert.Equal(t, TipoDocRefSet, ref.TipoDocumento)
+		assert.Equal(t, "CASO "+doc.Caso, ref.RazonReferencia)
+	}
+
+	factura := porCaso["4010123-1"].Documento
+	assert.Equal(t, 1, factura.Folio)
+	assert.Equal(t, 191598.0, factura.MontoNeto)
+	assert.Equal(t, 36404.0, factura.MontoIVA)
+	assert.Equal(t, 228002.0, factura.MontoTotal)
+
+	// Descuento por ítem y descuento global sólo sobre los afectos
+	conDescuento := porCaso["4010123-2"].Documento
+	assert.Equal(t, 2, conDescuento.Folio)
+	assert.Equal(t, 408436.0, conDescuento.MontoNeto)
+	assert.Equal(t, 35200.0, conDescuento.MontoExento)
+	assert.Equal(t, 77603.0, conDescuento.MontoIVA)
+	assert.Equal(t, 521239.0, conDescuento.MontoTotal)
+
+	// Corrección de giro: código 2 con una línea sin montos
+	corrige := porCaso["4010123-3"]
+	assert.Equal(t, models.Referencia{
+		TipoDocumento:   "33",
+		TipoReferencia:  models.TipoCorrige,
+		Folio:           1,
+		FechaReferencia: factura.FechaEmision,
+		RazonReferencia: "CORRIGE GIRO DEL RECEPTOR",
+	}, corrige.Documento.Referencias[1])
+	assert.Zero(t, corrige.Documento.MontoTotal)
+
+	// Devolución: código 3 con el precio y descuento de la factura original
+	devolucion := porCaso["4010123-4"]
+	assert.Equal(t, models.TipoPreciosCantidad, devolucion.Documento.Referencias[1].TipoReferencia)
+	assert.Equal(t, 2, devolucion.Documento.Referencias[1].Folio)
+	assert.Equal(t, 151615.0, devolucion.Documento.MontoNeto)
+	assert.Equal(t, 180422.0, devolucion.Documento.MontoTotal)
+
+	// La nota de débito anula la nota de crédito
+	anula := porCaso["4010123-5"]
+	assert.Equal(t, models.TipoAnula, anula.Documento.Referencias[1].TipoReferencia)
+	assert.Equal(t, "61", anula.Documento.Referencias[1].TipoDocumento)
+	assert.Equal(t, corrige.Items, anula.Items)
+
+	traslado := porCaso["4010124-1"].Guia
+	assert.Equal(t, models.IndTrasladoInterno, traslado.IndicadorTraslado)
+	assert.Equal(t, traslado.RUTEmisor, traslado.RUTReceptor)
+	assert.Empty(t, traslado.TipoDespacho)
+
+	venta := porCaso["4010124-2"].Guia
+	assert.Equal(t, models.IndTrasladoVenta, venta.IndicadorTraslado)
+	assert.Equal(t, models.TipoDespachoEmisorACliente, venta.TipoDespacho)
+	assert.Equal(t, "HJKL12", venta.Patente)
+	assert.Equal(t, "Los Carrera 120", venta.DireccionDestino)
+	assert.Equal(t, 391776.0, venta.MontoNeto)
+	assert.Equal(t, 466213.0, venta.MontoTotal)
+}
+
+func TestGenerarDocumentosReferenciaInexistente(t *testing.T) {
+	set, err := ParsearSet(strings.NewReader("SET BASICO - NUMERO DE ATENCION: 1\nCASO 1-1\n" +
+		"DOCUMENTO\tNOTA DE CREDITO ELECTRONICA\nREFERENCIA\tFACTURA ELECTRONICA CORRESPONDIENTE A CASO 1-9\n" +
+		"RAZON REFERENCIA\tANULA FACTURA\n"))
+	require.NoError(t, err)
+
+	_, err = GenerarDocumentos(set, parametrosPrueba(), foliosFijos{})
+	assert.ErrorContains(t, err, "caso 1-1: el caso referenciado 1-9")
+}
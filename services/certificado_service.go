@@ -16,6 +16,7 @@ import (
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
 	"github.com/supabase-community/postgrest-go"
 	"software.sslmate.com/src/go-pkcs12"
 )
@@ -129,6 +130,31 @@ func (s *CertificadoService) EliminarCertificado(id string) error {
 	return nil
 }
 
+// FirmadorEmpresa construye un servicio de firma con el certificado digital de la empresa
+func (s *CertificadoService) FirmadorEmpresa(empresaID string) (*FirmaDigitalService, error) {
+	certificado, err := s.GetCertificadoByEmpresaID(empresaID)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, cert, err := pkcs12.Decode(certificado.Contenido, certificado.Password)
+	if err != nil {
+		return nil, fmt.Errorf("error al decodificar certificado: %v", err)
+	}
+
+	rsaKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("la llave privada del certificado no es RSA")
+	}
+
+	return &FirmaDigitalService{
+		privateKey:  rsaKey,
+		certificate: cert,
+		rutFirmante: certificado.RutFirma,
+		firmante:    xmldsig.NewFirmante(rsaKey, cert),
+	}, nil
+}
+
 // validarCertificado valida un certificado antes de crearlo o actualizarlo
 func (s *CertificadoService) validarCertificado(certificado *models.CertificadoDigital) error {
 	if certificado.EmpresaID == "" {
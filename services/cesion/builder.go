@@ -0,0 +1,268 @@
+package cesion
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// Firmador firma un elemento en su ubicación final dentro del documento, agregando la firma
+// a continuación del elemento
+type Firmador interface {
+	FirmarElemento(objetivo *etree.Element, referenciaID string) (*etree.Element, error)
+}
+
+// Parte representa los datos de contacto de cedente o cesionario
+type Parte struct {
+	RUT         string `json:"rut" binding:"required"`
+	RazonSocial string `json:"razon_social" binding:"required"`
+	Direccion   string `json:"direccion" binding:"required"`
+	Email       string `json:"email" binding:"required"`
+}
+
+// SolicitudCesion contiene los datos necesarios para ceder un DTE
+type SolicitudCesion struct {
+	Cedente           Parte     `json:"cedente" binding:"required"`
+	Cesionario        Parte     `json:"cesionario" binding:"required"`
+	RutAutorizado     string    `json:"rut_autorizado" binding:"required"`
+	NombreAutorizado  string    `json:"nombre_autorizado" binding:"required"`
+	MontoCesion       int64     `json:"monto_cesion"`
+	UltimoVencimiento time.Time `json:"ultimo_vencimiento" binding:"required"`
+	NmbContacto       string    `json:"nombre_contacto"`
+	FonoContacto      string    `json:"fono_contacto"`
+	MailContacto      string    `json:"mail_contacto"`
+}
+
+// dteOrigen contiene los campos del DTE original necesarios para la cesión
+type dteOrigen struct {
+	Documento struct {
+		ID         string `xml:"ID,attr"`
+		Encabezado struct {
+			IdDoc struct {
+				TipoDTE int    `xml:"TipoDTE"`
+				Folio   int64  `xml:"Folio"`
+				FchEmis string `xml:"FchEmis"`
+			} `xml:"IdDoc"`
+			Emisor struct {
+				RUTEmisor string `xml:"RUTEmisor"`
+			} `xml:"Emisor"`
+			Receptor struct {
+				RUTRecep    string `xml:"RUTRecep"`
+				RznSocRecep string `xml:"RznSocRecep"`
+			} `xml:"Receptor"`
+			Totales struct {
+				MntTotal int64 `xml:"MntTotal"`
+			} `xml:"Totales"`
+		} `xml:"Encabezado"`
+	} `xml:"Documento"`
+}
+
+// ExtraerDTE obtiene el nodo DTE firmado desde un DTE o un EnvioDTE. La copia declara los
+// namespaces que el DTE heredaba del sobre, para que la forma canónica de su Documento, y con
+// ella la firma del emisor, no cambie al incluirlo en el AEC.
+func ExtraerDTE(xmlDocumento string) (*etree.Element, error) {
+	doc, err := xmldsig.ParseDocument([]byte(xmlDocumento))
+	if err != nil {
+		return nil, err
+	}
+
+	dte := doc.Root()
+	if dte.Tag != "DTE" {
+		if dte = doc.Root().FindElement(".//DTE"); dte == nil {
+			return nil, fmt.Errorf("el documento no contiene un nodo DTE")
+		}
+	}
+
+	copia := dte.Copy()
+	for padre := dte.Parent(); padre != nil; padre = padre.Parent() {
+		for _, attr := range padre.Attr {
+			esNamespace := attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns")
+			if esNamespace && copia.SelectAttr(attr.FullKey()) == nil {
+				copia.CreateAttr(attr.FullKey(), attr.Value)
+			}
+		}
+	}
+	return copia, nil
+}
+
+// IdentificarDTE lee del DTE los datos que identifican el documento cedido
+func IdentificarDTE(dte *etree.Element) (models.IdDTECedidoXML, string, error) {
+	var origen dteOrigen
+	if err := xml.Unmarshal(xmldsig.Canonicalize(dte), &origen); err != nil {
+		return models.IdDTECedidoXML{}, "", fmt.Errorf("error al leer DTE: %v", err)
+	}
+
+	enc := origen.Documento.Encabezado
+	switch enc.IdDoc.TipoDTE {
+	case int(models.TipoFactura), int(models.TipoFacturaExenta):
+	default:
+		return models.IdDTECedidoXML{}, "", fmt.Errorf("el tipo de documento %d no es cedible", enc.IdDoc.TipoDTE)
+	}
+
+	return models.IdDTECedidoXML{
+		TipoDTE:     enc.IdDoc.TipoDTE,
+		RUTEmisor:   enc.Emisor.RUTEmisor,
+		RUTReceptor: enc.Receptor.RUTRecep,
+		Folio:       enc.IdDoc.Folio,
+		FchEmis:     enc.IdDoc.FchEmis,
+		MntTotal:    enc.Totales.MntTotal,
+	}, enc.Receptor.RznSocRecep, nil
+}
+
+// DeclaracionJurada genera el texto de declaración exigido por la Ley 19.983
+func DeclaracionJurada(solicitud SolicitudCesion, id models.IdDTECedidoXML, razonSocialDeudor string) string {
+	return fmt.Sprintf("Se declara bajo juramento que %s, RUT %s ha puesto a disposición del cesionario %s, RUT %s, "+
+		"el o los documentos donde constan los recibos de las mercaderías entregadas o servicios prestados, "+
+		"entregados por parte del deudor de la factura %s, RUT %s, de acuerdo a lo establecido en la Ley N°19.983.",
+		solicitud.NombreAutorizado, solicitud.RutAutorizado,
+		solicitud.Cesionario.RazonSocial, solicitud.Cesionario.RUT,
+		razonSocialDeudor, id.RUTReceptor)
+}
+
+// ConstruirAEC arma y firma las tres capas del AEC (DTECedido, Cesion y DocumentoAEC)
+// y retorna el AEC junto con los datos de la cesión efectuada. El AEC se arma completo en
+// ISO-8859-1 y cada capa se firma en su ubicación final, de modo que todas las firmas se
+// calculan sobre el mismo texto y con los mismos namespaces que recibe el SII.
+func ConstruirAEC(firmador Firmador, solicitud SolicitudCesion, xmlDocumento string) ([]byte, *models.DocumentoCesionXML, error) {
+	dte, err := ExtraerDTE(xmlDocumento)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, razonSocialDeudor, err := IdentificarDTE(dte)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Sólo se soporta la primera cesión, en la que el cedente es el emisor del documento
+	if solicitud.Cedente.RUT != id.RUTEmisor {
+		return nil, nil, fmt.Errorf("%w: el cedente %s no es el emisor del documento", ErrCedenteAjeno, solicitud.Cedente.RUT)
+	}
+	if solicitud.MontoCesion == 0 {
+		solicitud.MontoCesion = id.MntTotal
+	}
+	if solicitud.MontoCesion > id.MntTotal {
+		return nil, nil, fmt.Errorf("el monto cedido %d excede el total del documento %d", solicitud.MontoCesion, id.MntTotal)
+	}
+
+	const seqCesion = 1
+	sufijo := fmt.Sprintf("%d_%d", id.TipoDTE, id.Folio)
+	ahora := time.Now().Format("2006-01-02T15:04:05")
+
+	// Capa 3: AEC, con las cesiones vacías hasta agregar las otras capas
+	aec := models.AECXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		DocumentoAEC: models.DocumentoAECXML{
+			ID: "AEC_" + sufijo + "_" + strconv.Itoa(seqCesion),
+			Caratula: models.CaratulaAECXML{
+				Version:        "1.0",
+				RutCedente:     solicitud.Cedente.RUT,
+				RutCesionario:  solicitud.Cesionario.RUT,
+				NmbContacto:    solicitud.NmbContacto,
+				FonoContacto:   solicitud.FonoContacto,
+				MailContacto:   solicitud.MailContacto,
+				TmstFirmaEnvio: ahora,
+			},
+		},
+	}
+	data, err := xml.Marshal(aec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al serializar AEC: %v", err)
+	}
+	if data, err = xmldsig.CodificarLatin1(data); err != nil {
+		return nil, nil, fmt.Errorf("error al codificar AEC: %v", err)
+	}
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	documentoAEC := doc.Root().SelectElement("DocumentoAEC")
+	cesiones := documentoAEC.SelectElement("Cesiones")
+
+	// Capa 1: DTE cedido
+	dteCedido := models.DTECedidoXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		DocumentoDTECedido: models.DocumentoDTECedidoXML{
+			ID:        "DTECedido_" + sufijo,
+			TmstFirma: ahora,
+		},
+	}
+	nodoDTECedido, err := agregarNodo(cesiones, dteCedido)
+	if err != nil {
+		return nil, nil, err
+	}
+	documentoDTECedido := nodoDTECedido.SelectElement("DocumentoDTECedido")
+	documentoDTECedido.InsertChildAt(0, dte)
+	if _, err := firmador.FirmarElemento(documentoDTECedido, dteCedido.DocumentoDTECedido.ID); err != nil {
+		return nil, nil, fmt.Errorf("error al firmar DTECedido: %v", err)
+	}
+
+	// Capa 2: Cesión
+	cesion := models.CesionXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		DocumentoCesion: models.DocumentoCesionXML{
+			ID:        "Cesion_" + sufijo + "_" + strconv.Itoa(seqCesion),
+			SeqCesion: seqCesion,
+			IdDTE:     id,
+			Cedente: models.CedenteXML{
+				RUT:         solicitud.Cedente.RUT,
+				RazonSocial: solicitud.Cedente.RazonSocial,
+				Direccion:   solicitud.Cedente.Direccion,
+				Email:       solicitud.Cedente.Email,
+				RUTAutorizado: []models.RUTAutorizadoXML{{
+					RUT:    solicitud.RutAutorizado,
+					Nombre: solicitud.NombreAutorizado,
+				}},
+				DeclaracionJurada: DeclaracionJurada(solicitud, id, razonSocialDeudor),
+			},
+			Cesionario: models.CesionarioXML{
+				RUT:         solicitud.Cesionario.RUT,
+				RazonSocial: solicitud.Cesionario.RazonSocial,
+				Direccion:   solicitud.Cesionario.Direccion,
+				Email:       solicitud.Cesionario.Email,
+			},
+			MontoCesion:       solicitud.MontoCesion,
+			UltimoVencimiento: solicitud.UltimoVencimiento.Format("2006-01-02"),
+			TmstCesion:        ahora,
+		},
+	}
+	nodoCesion, err := agregarNodo(cesiones, cesion)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := firmador.FirmarElemento(nodoCesion.SelectElement("DocumentoCesion"), cesion.DocumentoCesion.ID); err != nil {
+		return nil, nil, fmt.Errorf("error al firmar Cesion: %v", err)
+	}
+
+	if _, err := firmador.FirmarElemento(documentoAEC, aec.DocumentoAEC.ID); err != nil {
+		return nil, nil, fmt.Errorf("error al firmar AEC: %v", err)
+	}
+
+	aecFirmado, err := xmldsig.Serializar(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aecFirmado, &cesion.DocumentoCesion, nil
+}
+
+// agregarNodo serializa un nodo y lo agrega como último hijo del padre
+func agregarNodo(padre *etree.Element, nodo interface{}) (*etree.Element, error) {
+	xmlData, err := xml.Marshal(nodo)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar nodo: %v", err)
+	}
+	doc, err := xmldsig.ParseDocument(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	elemento := doc.Root()
+	padre.AddChild(elemento)
+	return elemento, nil
+}
@@ -0,0 +1,117 @@
+package cesion
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const envioDTEPrueba = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<EnvioDTE xmlns="http://www.sii.cl/SiiDte" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" version="1.0"><SetDTE ID="SetDoc"><DTE version="1.0"><Documento ID="F33T1"><Encabezado><IdDoc><TipoDTE>33</TipoDTE><Folio>1</Folio><FchEmis>2024-03-01</FchEmis></IdDoc><Emisor><RUTEmisor>76212889-6</RUTEmisor></Emisor><Receptor><RUTRecep>11111111-1</RUTRecep><RznSocRecep>Ñandú Compañía SpA</RznSocRecep></Receptor><Totales><MntTotal>119000</MntTotal></Totales></Encabezado></Documento></DTE></SetDTE></EnvioDTE>`
+
+// firmantePrueba genera una llave y un certificado autofirmado
+func firmantePrueba(t *testing.T) *xmldsig.Firmante {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "Juan Pérez"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return xmldsig.NewFirmante(llave, cert)
+}
+
+// envioFirmado retorna el EnvioDTE de prueba en ISO-8859-1 con el DTE y el sobre firmados
+func envioFirmado(t *testing.T, firmante *xmldsig.Firmante, envio string) string {
+	t.Helper()
+	data, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(envio))
+	require.NoError(t, err)
+	firmado, err := firmante.Firmar(data)
+	require.NoError(t, err)
+	return string(firmado)
+}
+
+func solicitudPrueba() SolicitudCesion {
+	return SolicitudCesion{
+		Cedente:           Parte{RUT: "76212889-6", RazonSocial: "Emisor SpA", Direccion: "Calle 1", Email: "emisor@example.com"},
+		Cesionario:        Parte{RUT: "99999999-9", RazonSocial: "Factoring SA", Direccion: "Calle 2", Email: "factoring@example.com"},
+		RutAutorizado:     "13195458-1",
+		NombreAutorizado:  "Juan Pérez",
+		UltimoVencimiento: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestConstruirAEC(t *testing.T) {
+	firmante := firmantePrueba(t)
+	aec, doc, err := ConstruirAEC(firmante, solicitudPrueba(), envioFirmado(t, firmante, envioDTEPrueba))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(119000), doc.MontoCesion)
+	assert.Equal(t, 33, doc.IdDTE.TipoDTE)
+	assert.True(t, strings.HasPrefix(string(aec), `<?xml version="1.0" encoding="ISO-8859-1"?>`))
+	assert.True(t, bytes.Contains(aec, []byte("puesto a disposici\xf3n del cesionario")))
+	assert.True(t, bytes.Contains(aec, []byte("Ley N\xb019.983")))
+
+	// Firma del emisor, DTECedido, Cesion y AEC
+	resultados, err := xmldsig.VerificarDocumento(aec)
+	require.NoError(t, err)
+	referencias := make([]string, 0, len(resultados))
+	for _, r := range resultados {
+		referencias = append(referencias, r.Referencia)
+	}
+	assert.ElementsMatch(t, []string{"F33T1", "DTECedido_33_1", "Cesion_33_1_1", "AEC_33_1_1"}, referencias)
+
+	leido, err := xmldsig.ParseDocument(aec)
+	require.NoError(t, err)
+	declaracion := leido.FindElement("//DeclaracionJurada").Text()
+	assert.Contains(t, declaracion, "deudor de la factura Ñandú Compañía SpA, RUT 11111111-1")
+	assert.NotNil(t, leido.FindElement("//DocumentoDTECedido/DTE/Documento[@ID='F33T1']"))
+}
+
+func TestConstruirAECValidaciones(t *testing.T) {
+	firmante := firmantePrueba(t)
+	envio := envioFirmado(t, firmante, envioDTEPrueba)
+
+	solicitud := solicitudPrueba()
+	solicitud.Cedente.RUT = "88888888-8"
+	_, _, err := ConstruirAEC(firmante, solicitud, envio)
+	assert.ErrorIs(t, err, ErrCedenteAjeno)
+
+	solicitud = solicitudPrueba()
+	solicitud.MontoCesion = 200000
+	_, _, err = ConstruirAEC(firmante, solicitud, envio)
+	assert.Error(t, err)
+
+	boleta := envioFirmado(t, firmante, strings.Replace(envioDTEPrueba, "<TipoDTE>33<", "<TipoDTE>39<", 1))
+	_, _, err = ConstruirAEC(firmante, solicitudPrueba(), boleta)
+	assert.Error(t, err)
+
+	_, err = ExtraerDTE(`<EnvioDTE><SetDTE/></EnvioDTE>`)
+	assert.Error(t, err)
+}
+
+func TestCederDocumentoEmpresaAjena(t *testing.T) {
+	// La empresa del certificado no es el cedente: se rechaza antes de leer o firmar el documento
+	servicio := NewService(nil, nil, nil, nil)
+	_, err := servicio.CederDocumento(context.Background(), firmantePrueba(t), "88.888.888-8", "doc-1", solicitudPrueba())
+	assert.ErrorIs(t, err, ErrCedenteAjeno)
+}
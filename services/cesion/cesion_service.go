@@ -0,0 +1,125 @@
+package cesion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// SchemaAEC es la clave del esquema del AEC en el validador XML
+const SchemaAEC = "AEC"
+
+// ErrCedenteAjeno indica que el cedente no es la empresa que cede ni el emisor del documento
+var ErrCedenteAjeno = errors.New("el cedente no corresponde a la empresa")
+
+// RepositorioDocumentos entrega el XML de un documento emitido y registra su cesión
+type RepositorioDocumentos interface {
+	ObtenerXMLDocumento(ctx context.Context, id string) (string, error)
+	RegistrarCesion(ctx context.Context, id string, cesion *models.CesionInfo) error
+}
+
+// Validador valida un documento XML contra un esquema registrado
+type Validador interface {
+	ValidateXML(xmlData []byte, schemaType string) error
+}
+
+// Uploader envía el AEC al Registro Electrónico de Cesiones del SII
+type Uploader interface {
+	SubirAEC(ctx context.Context, token, rutEmpresa, emailNotificacion, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error)
+}
+
+// Tokens ejecuta operaciones con el token vigente de la empresa, renovándolo si el SII lo rechaza
+type Tokens interface {
+	ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error
+}
+
+// Service gestiona la cesión de facturas electrónicas
+type Service struct {
+	documentos RepositorioDocumentos
+	validador  Validador
+	uploader   Uploader
+	tokens     Tokens
+}
+
+// NewService crea una nueva instancia del servicio de cesiones. Los envíos usan los tokens
+// compartidos de token.Manager.
+func NewService(documentos RepositorioDocumentos, validador Validador, uploader Uploader, tokens Tokens) *Service {
+	return &Service{
+		documentos: documentos,
+		validador:  validador,
+		uploader:   uploader,
+		tokens:     tokens,
+	}
+}
+
+// ResultadoCesion contiene el AEC generado y la cesión registrada en el documento
+type ResultadoCesion struct {
+	Cesion *models.CesionInfo `json:"cesion"`
+	AEC    string             `json:"aec"`
+}
+
+// CederDocumento genera el AEC del documento firmado con el certificado del cedente, lo envía
+// al SII y registra la cesión en el documento. El firmador debe ser el de la empresa rutEmpresa,
+// que tiene que ser el cedente y el emisor del documento.
+func (s *Service) CederDocumento(ctx context.Context, firmador Firmador, rutEmpresa, documentoID string, solicitud SolicitudCesion) (*ResultadoCesion, error) {
+	if !utils.MismoRUT(rutEmpresa, solicitud.Cedente.RUT) {
+		return nil, fmt.Errorf("%w: el cedente %s no es la empresa %s", ErrCedenteAjeno, solicitud.Cedente.RUT, rutEmpresa)
+	}
+
+	xmlDocumento, err := s.documentos.ObtenerXMLDocumento(ctx, documentoID)
+	if err != nil {
+		return nil, err
+	}
+
+	aec, doc, err := ConstruirAEC(firmador, solicitud, xmlDocumento)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.validador != nil {
+		if err := s.validador.ValidateXML(aec, SchemaAEC); err != nil {
+			return nil, fmt.Errorf("AEC no cumple el esquema: %v", err)
+		}
+	}
+
+	cesion := &models.CesionInfo{
+		SeqCesion:             doc.SeqCesion,
+		RutCedente:            doc.Cedente.RUT,
+		RazonSocialCedente:    doc.Cedente.RazonSocial,
+		RutCesionario:         doc.Cesionario.RUT,
+		RazonSocialCesionario: doc.Cesionario.RazonSocial,
+		MontoCesion:           doc.MontoCesion,
+		UltimoVencimiento:     doc.UltimoVencimiento,
+		FechaCesion:           time.Now(),
+		Estado:                models.EstadoCesionGenerada,
+	}
+
+	nombreArchivo := fmt.Sprintf("AEC_%s_%d_%d.xml", doc.IdDTE.RUTEmisor, doc.IdDTE.TipoDTE, doc.IdDTE.Folio)
+	var respuesta *models.RespuestaUploadSII
+	errEnvio := s.tokens.ConToken(ctx, rutEmpresa, func(token string) error {
+		var err error
+		respuesta, err = s.uploader.SubirAEC(ctx, token, solicitud.Cedente.RUT, solicitud.Cedente.Email, nombreArchivo, aec)
+		return err
+	})
+	if errEnvio != nil {
+		cesion.Estado = models.EstadoCesionError
+		cesion.Glosa = errEnvio.Error()
+	} else {
+		cesion.Estado = models.EstadoCesionEnviada
+		cesion.TrackID = respuesta.TrackID
+	}
+
+	if err := s.documentos.RegistrarCesion(ctx, documentoID, cesion); err != nil {
+		return nil, fmt.Errorf("error al registrar cesión: %v", err)
+	}
+
+	resultado := &ResultadoCesion{Cesion: cesion, AEC: string(aec)}
+	if errEnvio != nil {
+		return resultado, fmt.Errorf("error al enviar AEC al SII: %v", errEnvio)
+	}
+	return resultado, nil
+}
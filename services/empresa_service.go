@@ -8,9 +8,16 @@ import (
 	"github.com/cursor/FMgo/models"
 )
 
+// RegistroAmbientes recibe el ambiente SII de las empresas creadas o actualizadas
+// (ambiente.Registro)
+type RegistroAmbientes interface {
+	RegistrarEmpresa(empresa *models.Empresa) error
+}
+
 // EmpresaService maneja la lógica de negocio de empresas
 type EmpresaService struct {
-	config *config.SupabaseConfig
+	config    *config.SupabaseConfig
+	ambientes RegistroAmbientes
 }
 
 // NewEmpresaService crea una nueva instancia del servicio de empresa
@@ -20,6 +27,12 @@ func NewEmpresaService(config *config.SupabaseConfig) *EmpresaService {
 	}
 }
 
+// SetRegistroAmbientes registra el ambiente de cada empresa creada o actualizada, para que
+// sus documentos se envíen al SII de ese ambiente sin reiniciar el gateway
+func (s *EmpresaService) SetRegistroAmbientes(ambientes RegistroAmbientes) {
+	s.ambientes = ambientes
+}
+
 // GetEmpresaByRUT obtiene una empresa por su RUT
 func (s *EmpresaService) GetEmpresaByRUT(rut string) (*models.Empresa, error) {
 	// Implementación temporal - se sustituirá cuando tengamos acceso a la base de datos
@@ -28,6 +41,7 @@ func (s *EmpresaService) GetEmpresaByRUT(rut string) (*models.Empresa, error) {
 		Nombre:   "Empresa de prueba",
 		Email:    "contacto@empresa.com",
 		Telefono: "123456789",
+		Ambiente: models.AmbienteCertificacion,
 	}, nil
 	/*
 		var empresa models.Empresa
@@ -54,6 +68,7 @@ func (s *EmpresaService) GetEmpresaByID(id string) (*models.Empresa, error) {
 		Nombre:   "Empresa de prueba",
 		Email:    "contacto@empresa.com",
 		Telefono: "123456789",
+		Ambiente: models.AmbienteCertificacion,
 	}, nil
 	/*
 		var empresa models.Empresa
@@ -78,6 +93,11 @@ func (s *EmpresaService) ObtenerEmpresa(id string) (*models.Empresa, error) {
 
 // CrearEmpresa crea una nueva empresa
 func (s *EmpresaService) CrearEmpresa(empresa *models.Empresa) (*models.Empresa, error) {
+	// Las empresas nuevas emiten en certificación, como en la tabla empresas
+	if empresa.Ambiente == "" {
+		empresa.Ambiente = models.AmbienteCertificacion
+	}
+
 	// Validar empresa
 	if err := s.validarEmpresa(empresa); err != nil {
 		return nil, err
@@ -85,6 +105,9 @@ func (s *EmpresaService) CrearEmpresa(empresa *models.Empresa) (*models.Empresa,
 
 	// Implementación temporal - se sustituirá cuando tengamos acceso a la base de datos
 	empresa.ID = models.GenerateID()
+	if err := s.registrarAmbiente(empresa); err != nil {
+		return nil, err
+	}
 	return empresa, nil
 
 	/*
@@ -109,7 +132,7 @@ func (s *EmpresaService) ActualizarEmpresa(empresa *models.Empresa) error {
 	}
 
 	// Implementación temporal - se sustituirá cuando tengamos acceso a la base de datos
-	return nil
+	return s.registrarAmbiente(empresa)
 
 	/*
 		// Actualizar empresa en Supabase
@@ -166,6 +189,20 @@ func (s *EmpresaService) validarEmpresa(empresa *models.Empresa) error {
 	if empresa.NombreFirma == "" {
 		return fmt.Errorf("nombre firma requerido")
 	}
+	if !empresa.Ambiente.Valido() {
+		return fmt.Errorf("ambiente SII inválido: %q", empresa.Ambiente)
+	}
+	return nil
+}
+
+// registrarAmbiente informa el ambiente de la empresa al registro, si está configurado
+func (s *EmpresaService) registrarAmbiente(empresa *models.Empresa) error {
+	if s.ambientes == nil {
+		return nil
+	}
+	if err := s.ambientes.RegistrarEmpresa(empresa); err != nil {
+		return fmt.Errorf("error al registrar ambiente de la empresa: %v", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,66 @@
+package estado
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// Ambientes resuelve el ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error)
+}
+
+// ClienteAmbientes consulta cada envío o documento con el Cliente del ambiente de la empresa,
+// para que una consulta de certificación nunca llegue a producción ni al revés
+type ClienteAmbientes struct {
+	ambientes Ambientes
+	clientes  map[models.AmbienteSII]*Cliente
+}
+
+// NewClienteAmbientes crea el enrutador con un Cliente por ambiente
+func NewClienteAmbientes(ambientes Ambientes, clientes map[models.AmbienteSII]*Cliente) *ClienteAmbientes {
+	return &ClienteAmbientes{ambientes: ambientes, clientes: clientes}
+}
+
+// Cliente retorna el Cliente registrado para el ambiente
+func (c *ClienteAmbientes) Cliente(ambiente models.AmbienteSII) (*Cliente, error) {
+	cliente, ok := c.clientes[ambiente]
+	if !ok {
+		return nil, fmt.Errorf("sin cliente de consulta de estado para el ambiente %s", ambiente)
+	}
+	return cliente, nil
+}
+
+// ClienteEmpresa retorna el Cliente del ambiente de la empresa
+func (c *ClienteAmbientes) ClienteEmpresa(rutEmpresa string) (*Cliente, error) {
+	ambiente, err := c.ambientes.AmbienteEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cliente(ambiente)
+}
+
+// ConsultarEnvio consulta el envío en el ambiente de la empresa
+func (c *ClienteAmbientes) ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error) {
+	cliente, err := c.ClienteEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+	return cliente.ConsultarEnvio(ctx, rutEmpresa, trackID)
+}
+
+// ConsultarDTE consulta el documento en el ambiente de la empresa (RutEmpresa o, si está
+// vacío, RutEmisor)
+func (c *ClienteAmbientes) ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	empresa := consulta.RutEmpresa
+	if empresa == "" {
+		empresa = consulta.RutEmisor
+	}
+	cliente, err := c.ClienteEmpresa(empresa)
+	if err != nil {
+		return nil, err
+	}
+	return cliente.ConsultarDTE(ctx, consulta)
+}
@@ -0,0 +1,346 @@
+// Package estado consulta en el SII el estado de los envíos (QueryEstUp) y de los documentos
+// (QueryEstDte) según los manuales de estado_envio y estado_dte, y clasifica cada código
+// documentado en los tipos de models.
+package estado
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// ErrConsulta indica que el SII respondió la consulta con un código de error (-1, -11...)
+var ErrConsulta = errors.New("el SII no pudo responder la consulta")
+
+// Endpoints agrupa las URLs de los servicios de consulta de estado de un ambiente
+type Endpoints struct {
+	EstadoEnvio string // QueryEstUp
+	EstadoDTE   string // QueryEstDte
+}
+
+// URLs de certificación y producción de los servicios de consulta
+var (
+	EndpointsCertificacion = NewEndpoints(ambiente.Certificacion)
+	EndpointsProduccion    = NewEndpoints(ambiente.Produccion)
+)
+
+// NewEndpoints toma las URLs de consulta de estado de un ambiente del registro
+func NewEndpoints(endpoints ambiente.Endpoints) Endpoints {
+	return Endpoints{EstadoEnvio: endpoints.EstadoEnvio, EstadoDTE: endpoints.EstadoDTE}
+}
+
+// EndpointsAmbiente retorna las URLs del ambiente indicado (certificacion por defecto)
+func EndpointsAmbiente(nombre string) Endpoints {
+	if a, err := models.ParseAmbienteSII(nombre); err == nil && a == models.AmbienteProduccion {
+		return EndpointsProduccion
+	}
+	return EndpointsCertificacion
+}
+
+// Tokens ejecuta operaciones con el token vigente de la empresa, renovándolo si el SII lo rechaza
+type Tokens interface {
+	ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error
+}
+
+// Cliente consulta el estado de envíos y documentos en los servicios SOAP del SII
+type Cliente struct {
+	client    *http.Client
+	endpoints Endpoints
+	tokens    Tokens
+}
+
+// NewCliente crea un cliente para las URLs indicadas con los tokens de los servicios SOAP
+func NewCliente(endpoints Endpoints, tokens Tokens, timeout time.Duration) *Cliente {
+	return &Cliente{
+		client:    &http.Client{Timeout: timeout},
+		endpoints: endpoints,
+		tokens:    tokens,
+	}
+}
+
+// ConsultarEnvio consulta el estado de un envío por su TrackID. Si el SII responde un error de
+// consulta retorna la respuesta junto con un error que envuelve ErrConsulta.
+func (c *Cliente) ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error) {
+	rut, dv, err := utils.SepararRUT(rutEmpresa)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de empresa inválido: %v", err)
+	}
+
+	var respuesta *models.RespuestaEstadoEnvio
+	err = c.tokens.ConToken(ctx, rutEmpresa, func(tokenSII string) error {
+		sobre := sobreSOAP("getEstUp", [][2]string{
+			{"RutCompania", rut},
+			{"DvCompania", dv},
+			{"TrackId", strings.TrimSpace(trackID)},
+			{"Token", tokenSII},
+		})
+		data, err := c.invocar(ctx, c.endpoints.EstadoEnvio, sobre)
+		if err != nil {
+			return err
+		}
+		respuesta, err = ParsearEstadoEnvio(data)
+		if err != nil {
+			return err
+		}
+		if respuesta.Estado.ErrorToken() {
+			return fmt.Errorf("el SII respondió %s (%s): %w", respuesta.Estado, respuesta.Estado.Glosa(), token.ErrTokenRechazado)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if respuesta.Estado.ErrorConsulta() {
+		detalle := respuesta.Estado.Glosa()
+		if d := respuesta.DetalleError(); d != "" {
+			detalle += ": " + d
+		}
+		return respuesta, fmt.Errorf("error al consultar envío %s, estado %s (%s): %w", trackID, respuesta.Estado, detalle, ErrConsulta)
+	}
+	return respuesta, nil
+}
+
+// ConsultarDTE consulta el estado de un documento. Si el SII responde un error de consulta
+// retorna la respuesta junto con un error que envuelve ErrConsulta.
+func (c *Cliente) ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	rutConsultante, dvConsultante, err := utils.SepararRUT(consulta.RutConsultante)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de consultante inválido: %v", err)
+	}
+	rutEmisor, dvEmisor, err := utils.SepararRUT(consulta.RutEmisor)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de emisor inválido: %v", err)
+	}
+	rutReceptor, dvReceptor, err := utils.SepararRUT(consulta.RutReceptor)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de receptor inválido: %v", err)
+	}
+	empresa := consulta.RutEmpresa
+	if empresa == "" {
+		empresa = consulta.RutEmisor
+	}
+
+	var respuesta *models.RespuestaEstadoDTE
+	err = c.tokens.ConToken(ctx, empresa, func(tokenSII string) error {
+		sobre := sobreSOAP("getEstDte", [][2]string{
+			{"RutConsultante", rutConsultante},
+			{"DvConsultante", dvConsultante},
+			{"RutCompania", rutEmisor},
+			{"DvCompania", dvEmisor},
+			{"RutReceptor", rutReceptor},
+			{"DvReceptor", dvReceptor},
+			{"TipoDte", strconv.Itoa(int(consulta.TipoDTE))},
+			{"FolioDte", strconv.FormatInt(consulta.Folio, 10)},
+			{"FechaEmisionDte", consulta.FechaEmision.Format("02012006")},
+			{"MontoDte", strconv.FormatInt(consulta.MontoTotal, 10)},
+			{"Token", tokenSII},
+		})
+		data, err := c.invocar(ctx, c.endpoints.EstadoDTE, sobre)
+		if err != nil {
+			return err
+		}
+		respuesta, err = ParsearEstadoDTE(data)
+		if err != nil {
+			return err
+		}
+		if respuesta.Estado.ErrorToken() {
+			return fmt.Errorf("el SII respondió %s (%s): %w", respuesta.Estado, respuesta.Estado.Glosa(), token.ErrTokenRechazado)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if respuesta.Estado.ErrorConsulta() || respuesta.ErrorInterno() {
+		return respuesta, fmt.Errorf("error al consultar DTE %d folio %d, estado %s (%s): %w",
+			consulta.TipoDTE, consulta.Folio, respuesta.Estado, respuesta.GlosaErr, ErrConsulta)
+	}
+	return respuesta, nil
+}
+
+// ParsearEstadoEnvio interpreta el SII:RESPUESTA de QueryEstUp. El RESP_BODY repite la
+// secuencia TIPO_DOCTO, INFORMADOS, ACEPTADOS, RECHAZADOS y REPAROS por cada tipo de documento.
+func ParsearEstadoEnvio(data []byte) (*models.RespuestaEstadoEnvio, error) {
+	hdr, body, err := parsearRespuesta(data)
+	if err != nil {
+		return nil, err
+	}
+
+	respuesta := &models.RespuestaEstadoEnvio{
+		TrackID:     texto(hdr, "TRACKID"),
+		Estado:      models.EstadoConsultaEnvio(texto(hdr, "ESTADO")),
+		Glosa:       texto(hdr, "GLOSA"),
+		NumAtencion: texto(hdr, "NUM_ATENCION"),
+		ErrCode:     texto(hdr, "ERR_CODE"),
+		SQLCode:     texto(hdr, "SQL_CODE"),
+		SrvCode:     texto(hdr, "SRV_CODE"),
+	}
+	if respuesta.Estado == "" {
+		return nil, fmt.Errorf("la respuesta del SII no informa estado")
+	}
+	if body == nil {
+		return respuesta, nil
+	}
+
+	var actual *models.ResumenEstadoEnvio
+	for _, campo := range body.ChildElements() {
+		valor := strings.TrimSpace(campo.Text())
+		if campo.Tag == "TIPO_DOCTO" {
+			tipo, err := strconv.Atoi(valor)
+			if err != nil {
+				return nil, fmt.Errorf("TIPO_DOCTO inválido %q: %v", valor, err)
+			}
+			respuesta.Documentos = append(respuesta.Documentos, models.ResumenEstadoEnvio{TipoDTE: models.TipoDTE(tipo)})
+			actual = &respuesta.Documentos[len(respuesta.Documentos)-1]
+			continue
+		}
+
+		var destino *int
+		switch {
+		case actual == nil:
+			continue
+		case campo.Tag == "INFORMADOS":
+			destino = &actual.Informados
+		case campo.Tag == "ACEPTADOS":
+			destino = &actual.Aceptados
+		case campo.Tag == "RECHAZADOS":
+			destino = &actual.Rechazados
+		case campo.Tag == "REPAROS":
+			destino = &actual.Reparos
+		default:
+			continue
+		}
+		cantidad, err := strconv.Atoi(valor)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválido %q: %v", campo.Tag, valor, err)
+		}
+		*destino = cantidad
+	}
+
+	return respuesta, nil
+}
+
+// ParsearEstadoDTE interpreta el SII:RESPUESTA de QueryEstDte. Si ESTADO no es un código
+// documentado, el estado se deduce de ERR_CODE.
+func ParsearEstadoDTE(data []byte) (*models.RespuestaEstadoDTE, error) {
+	hdr, _, err := parsearRespuesta(data)
+	if err != nil {
+		return nil, err
+	}
+
+	respuesta := &models.RespuestaEstadoDTE{
+		Estado:      models.EstadoConsultaDTE(texto(hdr, "ESTADO")),
+		GlosaEstado: texto(hdr, "GLOSA_ESTADO"),
+		ErrCode:     texto(hdr, "ERR_CODE"),
+		GlosaErr:    texto(hdr, "GLOSA_ERR"),
+		NumAtencion: texto(hdr, "NUM_ATENCION"),
+	}
+	if respuesta.GlosaEstado == "" {
+		respuesta.GlosaEstado = texto(hdr, "GLOSA")
+	}
+	if !respuesta.Estado.Documentado() {
+		if estado, ok := models.EstadoPorErrCode(respuesta.ErrCode); ok {
+			respuesta.Estado = estado
+		}
+	}
+	if respuesta.Estado == "" {
+		return nil, fmt.Errorf("la respuesta del SII no informa estado")
+	}
+
+	return respuesta, nil
+}
+
+// parsearRespuesta retorna el RESP_HDR y el RESP_BODY (si existe) de un SII:RESPUESTA
+func parsearRespuesta(data []byte) (*etree.Element, *etree.Element, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil, fmt.Errorf("respuesta vacía del SII")
+	}
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al decodificar respuesta del SII: %v", err)
+	}
+	hdr := doc.FindElement("//RESP_HDR")
+	if hdr == nil {
+		return nil, nil, fmt.Errorf("la respuesta del SII no contiene RESP_HDR")
+	}
+	return hdr, doc.FindElement("//RESP_BODY"), nil
+}
+
+// invocar envía el sobre SOAP y retorna el SII:RESPUESTA contenido en el return
+func (c *Cliente) invocar(ctx context.Context, url, sobre string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(sobre))
+	if err != nil {
+		return nil, fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/xml;charset=UTF-8")
+	req.Header.Set("SOAPAction", "")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al invocar servicio de estado: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer respuesta del SII: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d del SII: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var respuesta struct {
+		Body struct {
+			Respuesta struct {
+				Return string `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.Unmarshal(body, &respuesta); err != nil {
+		return nil, fmt.Errorf("error al decodificar sobre SOAP: %v", err)
+	}
+	return []byte(strings.TrimSpace(respuesta.Body.Respuesta.Return)), nil
+}
+
+// sobreSOAP arma la llamada al método con sus parámetros en orden
+func sobreSOAP(metodo string, parametros [][2]string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+   <soapenv:Body>
+      <` + metodo + `>`)
+	for _, p := range parametros {
+		b.WriteString("\n         <" + p[0] + ">")
+		xml.EscapeText(&b, []byte(p[1]))
+		b.WriteString("</" + p[0] + ">")
+	}
+	b.WriteString(`
+      </` + metodo + `>
+   </soapenv:Body>
+</soapenv:Envelope>`)
+	return b.String()
+}
+
+// texto retorna el texto del hijo indicado, o vacío si no existe
+func texto(padre *etree.Element, tag string) string {
+	if e := padre.SelectElement(tag); e != nil {
+		return strings.TrimSpace(e.Text())
+	}
+	return ""
+}
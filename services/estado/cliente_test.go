@@ -0,0 +1,198 @@
+package estado
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/mock/simulador"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rutEmpresa = "76212889-6"
+
+// tokensPrueba entrega los tokens en orden, avanzando cuando el SII rechaza el actual
+type tokensPrueba struct {
+	tokens []string
+	usados []string
+}
+
+func (t *tokensPrueba) ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error {
+	t.usados = append(t.usados, t.tokens[0])
+	err := operacion(t.tokens[0])
+	if errors.Is(err, token.ErrTokenRechazado) && len(t.tokens) > 1 {
+		t.tokens = t.tokens[1:]
+		t.usados = append(t.usados, t.tokens[0])
+		return operacion(t.tokens[0])
+	}
+	return err
+}
+
+func TestParsearEstadoEnvio(t *testing.T) {
+	respuesta, err := ParsearEstadoEnvio([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema">
+<SII:RESP_HDR><TRACKID>5870</TRACKID><ESTADO>EPR</ESTADO><GLOSA>Envio Procesado</GLOSA><NUM_ATENCION>123 ( 2024/06/03 10:00:00)</NUM_ATENCION></SII:RESP_HDR>
+<SII:RESP_BODY>
+<TIPO_DOCTO>33</TIPO_DOCTO><INFORMADOS>3</INFORMADOS><ACEPTADOS>2</ACEPTADOS><RECHAZADOS>1</RECHAZADOS><REPAROS>0</REPAROS>
+<TIPO_DOCTO>61</TIPO_DOCTO><INFORMADOS>1</INFORMADOS><ACEPTADOS>1</ACEPTADOS><RECHAZADOS>0</RECHAZADOS><REPAROS>1</REPAROS>
+</SII:RESP_BODY>
+</SII:RESPUESTA>`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "5870", respuesta.TrackID)
+	assert.Equal(t, models.EstadoConsultaEnvioProcesado, respuesta.Estado)
+	assert.Equal(t, []models.ResumenEstadoEnvio{
+		{TipoDTE: 33, Informados: 3, Aceptados: 2, Rechazados: 1},
+		{TipoDTE: 61, Informados: 1, Aceptados: 1, Reparos: 1},
+	}, respuesta.Documentos)
+	assert.Equal(t, models.ResumenEstadoEnvio{Informados: 4, Aceptados: 3, Rechazados: 1, Reparos: 1}, respuesta.Totales())
+	assert.Equal(t, models.ResultadoEnvioRechazoParcial, respuesta.Resultado())
+}
+
+func TestResultadoEnvio(t *testing.T) {
+	casos := []struct {
+		estado     models.EstadoConsultaEnvio
+		errCode    string
+		documentos []models.ResumenEstadoEnvio
+		resultado  models.ResultadoEnvio
+	}{
+		{estado: "SOK", resultado: models.ResultadoEnvioEnProceso},
+		{estado: "CRT", resultado: models.ResultadoEnvioEnProceso},
+		{estado: "RSC", resultado: models.ResultadoEnvioRechazado},
+		{estado: "RFR", resultado: models.ResultadoEnvioRechazado},
+		{estado: "RCT", resultado: models.ResultadoEnvioRechazado},
+		{estado: "RCH", resultado: models.ResultadoEnvioRechazado},
+		{estado: "RPR", resultado: models.ResultadoEnvioReparos},
+		{estado: "EPR", documentos: []models.ResumenEstadoEnvio{{TipoDTE: 33, Informados: 2, Aceptados: 2}}, resultado: models.ResultadoEnvioAceptado},
+		{estado: "EPR", documentos: []models.ResumenEstadoEnvio{{TipoDTE: 33, Informados: 2, Aceptados: 2, Reparos: 1}}, resultado: models.ResultadoEnvioReparos},
+		{estado: "EPR", documentos: []models.ResumenEstadoEnvio{{TipoDTE: 33, Informados: 2, Rechazados: 2}}, resultado: models.ResultadoEnvioRechazado},
+		{estado: "002", resultado: models.ResultadoEnvioErrorToken},
+		{estado: "-11", errCode: "1", resultado: models.ResultadoEnvioNoEncontrado},
+		{estado: "-11", errCode: "2", resultado: models.ResultadoEnvioErrorConsulta},
+		{estado: "-6", resultado: models.ResultadoEnvioErrorConsulta},
+		{estado: "XYZ", resultado: models.ResultadoEnvioDesconocido},
+	}
+	for _, caso := range casos {
+		respuesta := models.RespuestaEstadoEnvio{Estado: caso.estado, ErrCode: caso.errCode, Documentos: caso.documentos}
+		assert.Equal(t, caso.resultado, respuesta.Resultado(), "estado %s", caso.estado)
+	}
+}
+
+func TestParsearEstadoDTE(t *testing.T) {
+	respuesta, err := ParsearEstadoDTE([]byte(`<SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_HDR>` +
+		`<ESTADO>MMC</ESTADO><GLOSA_ESTADO>Existe Nota de Credito que Modifica Montos Documento</GLOSA_ESTADO>` +
+		`<ERR_CODE>13</ERR_CODE><GLOSA_ERR></GLOSA_ERR><NUM_ATENCION>99</NUM_ATENCION></SII:RESP_HDR></SII:RESPUESTA>`))
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTENCModificaMontos, respuesta.Estado)
+	assert.True(t, respuesta.Estado.Recibido())
+	assert.True(t, respuesta.Estado.Vigente())
+	assert.False(t, respuesta.ErrorInterno())
+
+	// Sin un ESTADO documentado el estado se deduce del ERR_CODE
+	respuesta, err = ParsearEstadoDTE([]byte(`<SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_HDR>` +
+		`<ESTADO>ANULADO</ESTADO><ERR_CODE>15</ERR_CODE></SII:RESP_HDR></SII:RESPUESTA>`))
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTENCAnula, respuesta.Estado)
+	assert.True(t, respuesta.Estado.AnuladoPorNota())
+	assert.False(t, respuesta.Estado.Vigente())
+}
+
+func TestConsultarDTE(t *testing.T) {
+	sim := simulador.New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+
+	sim.RegistrarDocumento(simulador.Documento{
+		TipoDTE: 33, Folio: 10, RutEmisor: rutEmpresa, RutReceptor: "77777777-7", FechaEmision: "2024-06-03", MontoTotal: 11900,
+	})
+	sim.ParaFolio(rutEmpresa, 33, 11, simulador.ConEstadoDTE(simulador.EstadoDTEAnulado))
+
+	tokens := &tokensPrueba{tokens: []string{"VENCIDO", sim.EmitirToken()}}
+	cliente := NewCliente(Endpoints{EstadoEnvio: urls.EstadoUpload, EstadoDTE: urls.EstadoDTE}, tokens, 5*time.Second)
+	consulta := models.ConsultaEstadoDTE{
+		RutConsultante: "11111111-1",
+		RutEmisor:      rutEmpresa,
+		RutReceptor:    "77777777-7",
+		TipoDTE:        33,
+		Folio:          10,
+		FechaEmision:   time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+		MontoTotal:     11900,
+	}
+
+	respuesta, err := cliente.ConsultarDTE(context.Background(), consulta)
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTEDatosOK, respuesta.Estado)
+	assert.Equal(t, "0", respuesta.ErrCode)
+	assert.Len(t, tokens.usados, 2, "el token vencido debe renovarse")
+
+	consulta.MontoTotal = 1
+	respuesta, err = cliente.ConsultarDTE(context.Background(), consulta)
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTEDatosNoOK, respuesta.Estado)
+	assert.Equal(t, "1", respuesta.ErrCode)
+
+	consulta.Folio = 11
+	respuesta, err = cliente.ConsultarDTE(context.Background(), consulta)
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTEAnulado, respuesta.Estado)
+	assert.Equal(t, "5", respuesta.ErrCode)
+
+	consulta.Folio = 12
+	respuesta, err = cliente.ConsultarDTE(context.Background(), consulta)
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoConsultaDTENoRecibido, respuesta.Estado)
+	assert.False(t, respuesta.Estado.Recibido())
+}
+
+func TestConsultarEnvio(t *testing.T) {
+	sim := simulador.New()
+	servidor, urls := sim.Iniciar()
+	defer servidor.Close()
+
+	cliente := NewCliente(Endpoints{EstadoEnvio: urls.EstadoUpload, EstadoDTE: urls.EstadoDTE}, &tokensPrueba{tokens: []string{sim.EmitirToken()}}, 5*time.Second)
+	respuesta, err := cliente.ConsultarEnvio(context.Background(), rutEmpresa, "999")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrConsulta))
+	assert.True(t, respuesta.EnvioNoEncontrado())
+	assert.Equal(t, models.ResultadoEnvioNoEncontrado, respuesta.Resultado())
+
+	sim.ExpirarTokens()
+	_, err = cliente.ConsultarEnvio(context.Background(), rutEmpresa, "999")
+	assert.True(t, errors.Is(err, token.ErrTokenRechazado))
+}
+
+func TestClienteAmbientes(t *testing.T) {
+	simCertificacion := simulador.New()
+	servidorCertificacion, urlsCertificacion := simCertificacion.Iniciar()
+	defer servidorCertificacion.Close()
+	simProduccion := simulador.New()
+	servidorProduccion, urlsProduccion := simProduccion.Iniciar()
+	defer servidorProduccion.Close()
+
+	const rutProduccion = "77888999-K"
+	registro := ambiente.NewRegistro()
+	require.NoError(t, registro.AsignarAmbiente(rutEmpresa, models.AmbienteCertificacion))
+	require.NoError(t, registro.AsignarAmbiente(rutProduccion, models.AmbienteProduccion))
+
+	clientes := NewClienteAmbientes(registro, map[models.AmbienteSII]*Cliente{
+		models.AmbienteCertificacion: NewCliente(NewEndpoints(urlsCertificacion.Endpoints()), &tokensPrueba{tokens: []string{simCertificacion.EmitirToken()}}, 5*time.Second),
+		models.AmbienteProduccion:    NewCliente(NewEndpoints(urlsProduccion.Endpoints()), &tokensPrueba{tokens: []string{simProduccion.EmitirToken()}}, 5*time.Second),
+	})
+
+	_, err := clientes.ConsultarEnvio(context.Background(), rutEmpresa, "1")
+	assert.True(t, errors.Is(err, ErrConsulta))
+	_, err = clientes.ConsultarEnvio(context.Background(), rutProduccion, "1")
+	assert.True(t, errors.Is(err, ErrConsulta))
+	assert.Equal(t, 1, simCertificacion.Solicitudes(simulador.ServicioEstadoUpload))
+	assert.Equal(t, 1, simProduccion.Solicitudes(simulador.ServicioEstadoUpload))
+
+	_, err = clientes.ConsultarEnvio(context.Background(), "11111111-1", "1")
+	assert.True(t, errors.Is(err, ambiente.ErrEmpresaDesconocida))
+	assert.Equal(t, 1, simCertificacion.Solicitudes(simulador.ServicioEstadoUpload))
+	assert.Equal(t, 1, simProduccion.Solicitudes(simulador.ServicioEstadoUpload))
+}
@@ -0,0 +1,135 @@
+package exportacion
+
+import (
+	"fmt"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// ConstruirDTE valida el documento de exportación y arma su XML con el receptor extranjero,
+// los datos de Aduana, los totales en la moneda de la operación y, si hay tipo de cambio, los
+// totales en pesos en OtraMoneda
+func ConstruirDTE(doc *models.DocumentoTributario) (*models.DTEExportacionXML, error) {
+	if err := Validar(doc); err != nil {
+		return nil, err
+	}
+	exp := doc.Exportacion
+
+	encabezado := models.EncabezadoExportacionXML{
+		IdDoc: models.IdDocExportacionXML{
+			TipoDTE:     int(doc.TipoDocumento),
+			Folio:       doc.Folio,
+			FchEmis:     doc.FechaEmision.Format("2006-01-02"),
+			IndServicio: exp.IndServicio,
+			FmaPagExp:   exp.FmaPagExp,
+		},
+		Emisor: models.EmisorXML{
+			RUT:         doc.RUTEmisor,
+			RazonSocial: doc.RazonSocialEmisor,
+			Giro:        doc.GiroEmisor,
+			Direccion:   doc.DireccionEmisor,
+			Comuna:      doc.ComunaEmisor,
+		},
+		Receptor: models.ReceptorExportacionXML{
+			RUTRecep:    models.RutReceptorExtranjero,
+			RznSocRecep: doc.RazonSocialReceptor,
+			Extranjero: &models.ExtranjeroXML{
+				NumId:        exp.Extranjero.NumID,
+				Nacionalidad: exp.Extranjero.Nacionalidad,
+			},
+			GiroRecep: doc.GiroReceptor,
+			DirRecep:  doc.DireccionReceptor,
+			CmnaRecep: doc.ComunaReceptor,
+		},
+		Totales: models.TotalesExportacionXML{
+			TpoMoneda: exp.TpoMoneda,
+			MntExe:    redondearMoneda(doc.MontoExento),
+			MntTotal:  redondearMoneda(doc.MontoTotal),
+		},
+	}
+	if doc.Emisor != nil {
+		encabezado.Emisor.Ciudad = doc.Emisor.Ciudad
+	}
+	if doc.Receptor != nil {
+		encabezado.Receptor.CiudadRecep = doc.Receptor.Ciudad
+	}
+
+	if aduana := construirAduana(exp.Aduana); aduana != nil {
+		encabezado.Transporte = &models.TransporteExportacionXML{Aduana: *aduana}
+	}
+
+	if exp.TpoCambio > 0 {
+		encabezado.OtraMoneda = &models.OtraMonedaXML{
+			TpoMoneda:     models.MonedaPesoChileno,
+			TpoCambio:     exp.TpoCambio,
+			MntExeOtrMnda: exp.MontoExentoPesos,
+			MntTotOtrMnda: exp.MontoTotalPesos,
+		}
+	}
+
+	dte := &models.DTEExportacionXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		Exportacion: models.DocumentoExportacionXML{
+			ID:         fmt.Sprintf("T%dF%d", doc.TipoDocumento, doc.Folio),
+			Encabezado: encabezado,
+		},
+	}
+
+	for i, detalle := range doc.Detalles {
+		dte.Exportacion.Detalle = append(dte.Exportacion.Detalle, models.DetalleExportacionXML{
+			NroLinDet: i + 1,
+			NmbItem:   detalle.Descripcion,
+			QtyItem:   float64(detalle.Cantidad),
+			UnmdItem:  detalle.UnidadMedida,
+			PrcItem:   redondearMoneda(detalle.PrecioUnitario),
+			MontoItem: redondearMoneda(detalle.MontoItem),
+		})
+	}
+
+	for i, ref := range doc.Referencias {
+		dte.Exportacion.Referencia = append(dte.Exportacion.Referencia, models.ReferenciaExportacionXML{
+			NroLinRef: i + 1,
+			TpoDocRef: ref.TipoDocumento,
+			FolioRef:  fmt.Sprintf("%d", ref.Folio),
+			FchRef:    ref.FechaReferencia.Format("2006-01-02"),
+			CodRef:    string(ref.TipoReferencia),
+			RazonRef:  ref.RazonReferencia,
+		})
+	}
+
+	return dte, nil
+}
+
+// construirAduana arma el nodo Aduana; retorna nil si no hay datos aduaneros que informar
+func construirAduana(datos models.DatosAduana) *models.AduanaXML {
+	aduana := &models.AduanaXML{
+		CodModVenta:    datos.CodModVenta,
+		CodClauVenta:   datos.CodClauVenta,
+		TotClauVenta:   redondearMoneda(datos.TotClauVenta),
+		CodViaTransp:   datos.CodViaTransp,
+		NombreTransp:   datos.NombreTransp,
+		CodPtoEmbarque: datos.CodPtoEmbarque,
+		CodPtoDesemb:   datos.CodPtoDesemb,
+		TotBultos:      datos.TotBultos,
+		MntFlete:       redondearMoneda(datos.MntFlete),
+		MntSeguro:      redondearMoneda(datos.MntSeguro),
+		CodPaisRecep:   datos.CodPaisRecep,
+		CodPaisDestin:  datos.CodPaisDestin,
+	}
+	for _, tipo := range datos.TipoBultos {
+		aduana.TipoBultos = append(aduana.TipoBultos, models.TipoBultoXML{
+			CodTpoBultos: tipo.CodTpoBultos,
+			CantBultos:   tipo.CantBultos,
+			Marcas:       tipo.Marcas,
+			IdContainer:  tipo.IdContainer,
+			Sello:        tipo.Sello,
+		})
+	}
+
+	if aduana.CodModVenta == 0 && aduana.CodViaTransp == 0 && aduana.CodPaisRecep == 0 &&
+		aduana.CodPaisDestin == 0 && len(aduana.TipoBultos) == 0 {
+		return nil
+	}
+	return aduana
+}
@@ -0,0 +1,152 @@
+package exportacion
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func facturaExportacion() *models.DocumentoTributario {
+	return &models.DocumentoTributario{
+		Folio:               15,
+		FechaEmision:        time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		TipoDocumento:       models.TipoFacturaExportacion,
+		RUTEmisor:           "76212889-6",
+		RazonSocialEmisor:   "Exportadora Sur SpA",
+		GiroEmisor:          "Exportación de frutas",
+		DireccionEmisor:     "Av. Central 123",
+		ComunaEmisor:        "Rancagua",
+		RazonSocialReceptor: "Fruit Imports LLC",
+		DireccionReceptor:   "1 Harbor St, Miami",
+		Detalles: []models.DetalleTributario{
+			{Descripcion: "Cerezas frescas", Cantidad: 120, PrecioUnitario: 35.125, UnidadMedida: "CJ"},
+			{Descripcion: "Arándanos", Cantidad: 3, PrecioUnitario: 10.3333},
+		},
+		Exportacion: &models.DatosExportacion{
+			FmaPagExp:  1,
+			Extranjero: models.ReceptorExtranjero{NumID: "12-3456789", Nacionalidad: "225"},
+			Aduana: models.DatosAduana{
+				CodModVenta:    1,
+				CodClauVenta:   2,
+				TotClauVenta:   4245.9999,
+				CodViaTransp:   1,
+				CodPtoEmbarque: 906,
+				CodPtoDesemb:   213,
+				TotBultos:      123,
+				TipoBultos:     []models.TipoBulto{{CodTpoBultos: 75, CantBultos: 120}, {CodTpoBultos: 75, CantBultos: 3}},
+				CodPaisRecep:   225,
+				CodPaisDestin:  225,
+			},
+			TpoMoneda: "DOLAR USA",
+			TpoCambio: 950.5,
+		},
+	}
+}
+
+func TestCalcularExportacionExenta(t *testing.T) {
+	doc := facturaExportacion()
+	doc.MontoNeto = 100
+	doc.MontoIVA = 19
+
+	require.NoError(t, Calcular(doc))
+	assert.Equal(t, 4215.0, doc.Detalles[0].MontoItem)
+	assert.Equal(t, 30.9999, doc.Detalles[1].MontoItem)
+	assert.True(t, doc.Detalles[1].Exento)
+	assert.Zero(t, doc.MontoNeto)
+	assert.Zero(t, doc.MontoIVA)
+	assert.Equal(t, 4245.9999, doc.MontoExento)
+	assert.Equal(t, doc.MontoExento, doc.MontoTotal)
+	assert.Equal(t, 4035823.0, doc.Exportacion.MontoTotalPesos)
+
+	doc.TipoDocumento = models.TipoFactura
+	assert.Error(t, Calcular(doc))
+}
+
+func TestConstruirDTEExportacion(t *testing.T) {
+	doc := facturaExportacion()
+	require.NoError(t, Calcular(doc))
+
+	dte, err := ConstruirDTE(doc)
+	require.NoError(t, err)
+
+	data, err := xml.Marshal(dte)
+	require.NoError(t, err)
+	salida := string(data)
+
+	assert.Contains(t, salida, `<Exportaciones ID="T110F15">`)
+	assert.Contains(t, salida, `<RUTRecep>55555555-5</RUTRecep><RznSocRecep>Fruit Imports LLC</RznSocRecep>`+
+		`<Extranjero><NumId>12-3456789</NumId><Nacionalidad>225</Nacionalidad></Extranjero>`)
+	assert.Contains(t, salida, `<Transporte><Aduana><CodModVenta>1</CodModVenta><CodClauVenta>2</CodClauVenta>`)
+	assert.Contains(t, salida, `<TipoBultos><CodTpoBultos>75</CodTpoBultos><CantBultos>120</CantBultos></TipoBultos>`)
+	assert.Contains(t, salida, `<Totales><TpoMoneda>DOLAR USA</TpoMoneda><MntExe>4245.9999</MntExe><MntTotal>4245.9999</MntTotal></Totales>`)
+	assert.Contains(t, salida, `<OtraMoneda><TpoMoneda>PESO CL</TpoMoneda><TpoCambio>950.5</TpoCambio>`)
+	assert.NotContains(t, salida, "<IVA>")
+
+	// Totales y OtraMoneda siguen el orden del esquema del SII
+	assert.Less(t, strings.Index(salida, "<Transporte>"), strings.Index(salida, "<Totales>"))
+	assert.Less(t, strings.Index(salida, "<Totales>"), strings.Index(salida, "<OtraMoneda>"))
+
+	var leido models.DTEExportacionXML
+	require.NoError(t, xml.Unmarshal(data, &leido))
+	assert.Equal(t, 110, leido.Exportacion.Encabezado.IdDoc.TipoDTE)
+	assert.Len(t, leido.Exportacion.Detalle, 2)
+}
+
+func TestSetDTEConExportacion(t *testing.T) {
+	doc := facturaExportacion()
+	require.NoError(t, Calcular(doc))
+	dte, err := ConstruirDTE(doc)
+	require.NoError(t, err)
+
+	set := models.SetDTE{
+		ID:            "SetDoc",
+		DTEs:          []models.DTEXMLModel{{Version: "1.0", Documento: models.DocumentoXMLModel{ID: "F1T33"}}},
+		Exportaciones: []models.DTEExportacionXML{*dte},
+	}
+	data, err := xml.Marshal(set)
+	require.NoError(t, err)
+	salida := string(data)
+
+	assert.Contains(t, salida, `<DTE version="1.0"><Documento ID="F1T33">`)
+	assert.Contains(t, salida, `<DTE xmlns="http://www.sii.cl/SiiDte" version="1.0"><Exportaciones ID="T110F15">`)
+	assert.Contains(t, salida, `<Transporte><Aduana>`)
+	assert.Contains(t, salida, `<OtraMoneda><TpoMoneda>PESO CL</TpoMoneda>`)
+}
+
+func TestValidarExportacion(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		modificar func(doc *models.DocumentoTributario)
+		error     string
+	}{
+		{"receptor con RUT chileno", func(doc *models.DocumentoTributario) { doc.RUTReceptor = "11111111-1" }, "55555555-5"},
+		{"sin nacionalidad", func(doc *models.DocumentoTributario) { doc.Exportacion.Extranjero.Nacionalidad = "" }, "nacionalidad"},
+		{"sin moneda", func(doc *models.DocumentoTributario) { doc.Exportacion.TpoMoneda = "" }, "moneda"},
+		{"con IVA", func(doc *models.DocumentoTributario) { doc.MontoIVA = 10 }, "exentos"},
+		{"sin cláusula", func(doc *models.DocumentoTributario) { doc.Exportacion.Aduana.CodClauVenta = 0 }, "cláusula"},
+		{"bultos descuadrados", func(doc *models.DocumentoTributario) { doc.Exportacion.Aduana.TotBultos = 100 }, "bultos"},
+		{"total descuadrado", func(doc *models.DocumentoTributario) { doc.MontoTotal++ }, "suma exenta"},
+		{"nota sin referencia", func(doc *models.DocumentoTributario) { doc.TipoDocumento = models.TipoNotaCreditoExportacion }, "referencia"},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			doc := facturaExportacion()
+			require.NoError(t, Calcular(doc))
+			caso.modificar(doc)
+			assert.ErrorContains(t, Validar(doc), caso.error)
+		})
+	}
+
+	// Una exportación de servicios no requiere modalidad, cláusula ni puertos
+	doc := facturaExportacion()
+	doc.Exportacion.IndServicio = models.IndServicioExportacionServicio
+	doc.Exportacion.Aduana = models.DatosAduana{CodPaisRecep: 225, CodPaisDestin: 225}
+	require.NoError(t, Calcular(doc))
+	assert.NoError(t, Validar(doc))
+}
@@ -0,0 +1,181 @@
+// Package exportacion implementa el cálculo, la validación y la generación del XML de los
+// documentos de exportación: factura (110), nota de débito (111) y nota de crédito (112).
+package exportacion
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// decimalesMoneda es la cantidad de decimales con que se informan los montos en la moneda de
+// la operación
+const decimalesMoneda = 4
+
+// Calcular completa los montos de un documento de exportación. Las exportaciones son exentas
+// de IVA, por lo que todos los ítems se consideran exentos y el total corresponde al monto
+// exento en la moneda de la operación. Si hay tipo de cambio, también se calculan los montos
+// en pesos que se informan en OtraMoneda.
+func Calcular(doc *models.DocumentoTributario) error {
+	if !doc.TipoDocumento.EsExportacion() {
+		return fmt.Errorf("el tipo de documento %d no es de exportación", doc.TipoDocumento)
+	}
+	if doc.Exportacion == nil {
+		return fmt.Errorf("el documento de exportación no tiene datos de exportación")
+	}
+
+	var exento float64
+	for i := range doc.Detalles {
+		detalle := &doc.Detalles[i]
+		if detalle.MontoItem == 0 {
+			detalle.MontoItem = redondearMoneda(detalle.PrecioUnitario * float64(detalle.Cantidad))
+		}
+		detalle.Exento = true
+		exento += detalle.MontoItem
+	}
+
+	doc.MontoNeto = 0
+	doc.MontoIVA = 0
+	doc.TasaIVA = 0
+	doc.MontoExento = redondearMoneda(exento)
+	doc.MontoTotal = doc.MontoExento
+
+	doc.Exportacion.MontoExentoPesos = 0
+	doc.Exportacion.MontoTotalPesos = 0
+	if doc.Exportacion.TpoCambio > 0 {
+		doc.Exportacion.MontoExentoPesos = math.Round(doc.MontoExento * doc.Exportacion.TpoCambio)
+		doc.Exportacion.MontoTotalPesos = math.Round(doc.MontoTotal * doc.Exportacion.TpoCambio)
+	}
+
+	return nil
+}
+
+// Validar verifica las reglas del SII para documentos de exportación: receptor extranjero,
+// operación exenta expresada en una moneda, datos de Aduana para las facturas y referencia al
+// documento original en las notas
+func Validar(doc *models.DocumentoTributario) error {
+	if !doc.TipoDocumento.EsExportacion() {
+		return fmt.Errorf("el tipo de documento %d no es de exportación", doc.TipoDocumento)
+	}
+	exp := doc.Exportacion
+	if exp == nil {
+		return fmt.Errorf("el documento de exportación no tiene datos de exportación")
+	}
+	if doc.Folio <= 0 {
+		return fmt.Errorf("folio inválido: %d", doc.Folio)
+	}
+
+	if doc.RUTReceptor != "" && doc.RUTReceptor != models.RutReceptorExtranjero {
+		return fmt.Errorf("el receptor de un documento de exportación se informa con RUT %s", models.RutReceptorExtranjero)
+	}
+	if doc.RazonSocialReceptor == "" {
+		return fmt.Errorf("razón social del receptor requerida")
+	}
+	if exp.Extranjero.Nacionalidad == "" {
+		return fmt.Errorf("nacionalidad del receptor extranjero requerida")
+	}
+
+	switch exp.IndServicio {
+	case models.IndServicioExportacionNoAplica, models.IndServicioExportacionPeriodico,
+		models.IndServicioExportacionServicio, models.IndServicioExportacionHoteleria,
+		models.IndServicioExportacionTransporte:
+	default:
+		return fmt.Errorf("indicador de servicio inválido para exportación: %d", exp.IndServicio)
+	}
+
+	if exp.TpoMoneda == "" {
+		return fmt.Errorf("tipo de moneda de la operación requerido")
+	}
+	if exp.TpoCambio < 0 {
+		return fmt.Errorf("tipo de cambio inválido: %v", exp.TpoCambio)
+	}
+
+	if doc.MontoNeto != 0 || doc.MontoIVA != 0 {
+		return fmt.Errorf("los documentos de exportación son exentos y no pueden informar monto neto ni IVA")
+	}
+	if len(doc.Detalles) == 0 {
+		return fmt.Errorf("el documento debe tener al menos un item")
+	}
+	var exento float64
+	for i, detalle := range doc.Detalles {
+		if detalle.MontoItem < 0 {
+			return fmt.Errorf("monto inválido en la línea %d", i+1)
+		}
+		exento += detalle.MontoItem
+	}
+	if doc.MontoTotal <= 0 {
+		return fmt.Errorf("monto total debe ser mayor que cero")
+	}
+	if math.Abs(redondearMoneda(exento)-doc.MontoExento) > 0.0001 || doc.MontoTotal != doc.MontoExento {
+		return fmt.Errorf("el monto total (%v) debe ser igual a la suma exenta de los ítems (%v)", doc.MontoTotal, redondearMoneda(exento))
+	}
+
+	if doc.TipoDocumento == models.TipoFacturaExportacion {
+		if err := validarAduana(exp); err != nil {
+			return err
+		}
+	} else if len(doc.Referencias) == 0 {
+		return fmt.Errorf("la %s requiere una referencia al documento original", nombreTipo(doc.TipoDocumento))
+	}
+
+	return nil
+}
+
+// validarAduana verifica los datos aduaneros de la factura de exportación. Las exportaciones
+// de servicios no informan modalidad, cláusula, vía de transporte ni puertos.
+func validarAduana(exp *models.DatosExportacion) error {
+	aduana := exp.Aduana
+	if aduana.CodPaisRecep == 0 || aduana.CodPaisDestin == 0 {
+		return fmt.Errorf("país receptor y país de destino requeridos")
+	}
+
+	if exp.IndServicio == models.IndServicioExportacionNoAplica {
+		switch {
+		case aduana.CodModVenta == 0:
+			return fmt.Errorf("modalidad de venta requerida")
+		case aduana.CodClauVenta == 0:
+			return fmt.Errorf("cláusula de venta requerida")
+		case aduana.CodViaTransp == 0:
+			return fmt.Errorf("vía de transporte requerida")
+		case aduana.CodPtoEmbarque == 0 || aduana.CodPtoDesemb == 0:
+			return fmt.Errorf("puertos de embarque y desembarque requeridos")
+		}
+	}
+	if aduana.CodClauVenta != 0 && aduana.TotClauVenta <= 0 {
+		return fmt.Errorf("total de la cláusula de venta requerido")
+	}
+
+	if len(aduana.TipoBultos) > 0 {
+		bultos := 0
+		for _, tipo := range aduana.TipoBultos {
+			if tipo.CodTpoBultos == 0 || tipo.CantBultos <= 0 {
+				return fmt.Errorf("tipo de bulto inválido: código %d, cantidad %d", tipo.CodTpoBultos, tipo.CantBultos)
+			}
+			bultos += tipo.CantBultos
+		}
+		if bultos != aduana.TotBultos {
+			return fmt.Errorf("el total de bultos (%d) no coincide con la suma por tipo (%d)", aduana.TotBultos, bultos)
+		}
+	}
+
+	return nil
+}
+
+// nombreTipo retorna el nombre del documento de exportación para los mensajes de error
+func nombreTipo(tipo models.TipoDTE) string {
+	switch tipo {
+	case models.TipoNotaDebitoExportacion:
+		return "nota de débito de exportación"
+	case models.TipoNotaCreditoExportacion:
+		return "nota de crédito de exportación"
+	default:
+		return "factura de exportación"
+	}
+}
+
+// redondearMoneda ajusta un monto en moneda extranjera a los decimales admitidos por el SII
+func redondearMoneda(monto float64) float64 {
+	factor := math.Pow(10, decimalesMoneda)
+	return math.Round(monto*factor) / factor
+}
@@ -145,7 +145,7 @@ func (s *FacturaService) ConsultarEstado(ctx context.Context, docID string) (str
 		return "", fmt.Errorf("error al obtener documento: %v", err)
 	}
 
-	estado, err := s.siiService.ConsultarEstado(docID)
+	estado, err := s.siiService.ConsultarEstado(doc.RutEmisor, docID)
 	if err != nil {
 		return "", fmt.Errorf("error al consultar estado: %v", err)
 	}
@@ -160,6 +160,25 @@ func (s *FacturaService) ConsultarEstado(ctx context.Context, docID string) (str
 	return estado, nil
 }
 
+// GetFactura obtiene una factura por ID, incluyendo la cesión vigente si el crédito fue cedido
+func (s *FacturaService) GetFactura(id string) (*models.FacturaResponse, error) {
+	doc, err := s.supabase.ObtenerDocumento(context.Background(), id)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener documento: %v", err)
+	}
+
+	return &models.FacturaResponse{
+		ID:            doc.ID,
+		TipoDocumento: doc.Tipo,
+		Folio:         int64(doc.Folio),
+		RutEmisor:     doc.RutEmisor,
+		RutReceptor:   doc.RutReceptor,
+		MontoTotal:    doc.MontoTotal,
+		Estado:        doc.Estado,
+		Cesion:        doc.Cesion,
+	}, nil
+}
+
 // validarFactura valida una factura antes de crearla
 func (s *FacturaService) validarFactura(factura *models.Factura) error {
 	if factura.RutEmisor == "" {
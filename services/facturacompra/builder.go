@@ -0,0 +1,110 @@
+package facturacompra
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// ConstruirDTE valida la factura de compra y arma su XML: el comprador como emisor, el vendedor
+// como receptor y, en los totales, el IVA retenido (ImptoReten) y el IVA no retenido
+func ConstruirDTE(doc *models.DocumentoTributario) (*models.DTEXMLModel, error) {
+	if err := Validar(doc); err != nil {
+		return nil, err
+	}
+
+	encabezado := models.EncabezadoXMLModel{
+		IdDoc: models.IDDocumentoXML{
+			TipoDTE:      strconv.Itoa(int(models.TipoFacturaCompra)),
+			Folio:        doc.Folio,
+			FechaEmision: doc.FechaEmision.Format("2006-01-02"),
+		},
+		Emisor: models.EmisorXML{
+			RUT:         doc.RUTEmisor,
+			RazonSocial: doc.RazonSocialEmisor,
+			Giro:        doc.GiroEmisor,
+			Direccion:   doc.DireccionEmisor,
+			Comuna:      doc.ComunaEmisor,
+		},
+		Receptor: models.ReceptorXML{
+			RUT:         doc.RUTReceptor,
+			RazonSocial: doc.RazonSocialReceptor,
+			Giro:        doc.GiroReceptor,
+			Direccion:   doc.DireccionReceptor,
+			Comuna:      doc.ComunaReceptor,
+		},
+		Totales: models.TotalesXML{
+			MontoExento: int(doc.MontoExento),
+			MntTotal:    int64(doc.MontoTotal),
+		},
+	}
+	if doc.Emisor != nil {
+		encabezado.Emisor.Ciudad = doc.Emisor.Ciudad
+	}
+	if doc.Receptor != nil {
+		encabezado.Receptor.Ciudad = doc.Receptor.Ciudad
+	}
+
+	if doc.MontoNeto > 0 {
+		neto := int64(doc.MontoNeto)
+		iva := int64(doc.MontoIVA)
+		tasa := doc.TasaIVA
+		encabezado.Totales.MntNeto = &neto
+		encabezado.Totales.TasaIVA = &tasa
+		encabezado.Totales.IVA = &iva
+	}
+
+	var codigoRetencion string
+	if ret := doc.RetencionIVA; ret != nil {
+		codigoRetencion = ret.Codigo
+		encabezado.Totales.ImptoReten = []models.ImpuestoXML{
+			{Tipo: ret.Codigo, Tasa: ret.Tasa, Monto: int(ret.Monto)},
+		}
+		if ret.IVANoRetenido > 0 {
+			noRetenido := int64(ret.IVANoRetenido)
+			encabezado.Totales.IVANoRet = &noRetenido
+		}
+	}
+
+	dte := &models.DTEXMLModel{
+		Version: "1.0",
+		Documento: models.DocumentoXMLModel{
+			ID:         fmt.Sprintf("T%dF%d", models.TipoFacturaCompra, doc.Folio),
+			Encabezado: encabezado,
+		},
+	}
+
+	for i, detalle := range doc.Detalles {
+		cantidad := float64(detalle.Cantidad)
+		precio := detalle.PrecioUnitario
+		linea := models.DetalleXML{
+			NroLinDet:    i + 1,
+			Nombre:       detalle.Descripcion,
+			Cantidad:     &cantidad,
+			UnidadMedida: detalle.UnidadMedida,
+			Precio:       &precio,
+			MontoItem:    int64(detalle.MontoItem),
+		}
+		// Las líneas afectas indican el código de la retención que les aplica el comprador
+		if detalle.Exento {
+			linea.IndExe = 1
+		} else {
+			linea.CodImpAdic = codigoRetencion
+		}
+		dte.Documento.Detalle = append(dte.Documento.Detalle, linea)
+	}
+
+	for i, ref := range doc.Referencias {
+		dte.Documento.Referencias = append(dte.Documento.Referencias, models.ReferenciaXMLModel{
+			NroLinRef:  i + 1,
+			TipoDocRef: ref.TipoDocumento,
+			FolioRef:   strconv.Itoa(ref.Folio),
+			FechaRef:   ref.FechaReferencia.Format("2006-01-02"),
+			CodigoRef:  string(ref.TipoReferencia),
+			RazonRef:   ref.RazonReferencia,
+		})
+	}
+
+	return dte, nil
+}
@@ -0,0 +1,109 @@
+package facturacompra
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func facturaCompra(retencion *models.RetencionIVA) *models.DocumentoTributario {
+	return &models.DocumentoTributario{
+		Folio:               7,
+		FechaEmision:        time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		TipoDocumento:       models.TipoFacturaCompra,
+		RUTEmisor:           "76212889-6",
+		RazonSocialEmisor:   "Maderas del Sur SpA",
+		GiroEmisor:          "Aserradero",
+		DireccionEmisor:     "Camino a Valdivia km 5",
+		ComunaEmisor:        "Los Lagos",
+		RUTReceptor:         "9876543-3",
+		RazonSocialReceptor: "Pedro Soto",
+		DireccionReceptor:   "Parcela 12",
+		ComunaReceptor:      "Panguipulli",
+		Detalles: []models.DetalleTributario{
+			{Descripcion: "Metro ruma de pino", Cantidad: 30, PrecioUnitario: 33333.4},
+			{Descripcion: "Flete", Cantidad: 1, PrecioUnitario: 5000, Exento: true},
+		},
+		RetencionIVA: retencion,
+	}
+}
+
+func TestCalcularRetencionTotal(t *testing.T) {
+	doc := facturaCompra(&models.RetencionIVA{Codigo: models.CodigoIVARetenidoTotal})
+
+	require.NoError(t, Calcular(doc))
+	assert.Equal(t, 1000002.0, doc.MontoNeto)
+	assert.Equal(t, 5000.0, doc.MontoExento)
+	assert.Equal(t, 190000.0, doc.MontoIVA)
+	assert.Equal(t, 19.0, doc.RetencionIVA.Tasa)
+	assert.Equal(t, doc.MontoIVA, doc.RetencionIVA.Monto)
+	assert.Zero(t, doc.RetencionIVA.IVANoRetenido)
+	assert.Equal(t, 1005002.0, doc.MontoTotal)
+	assert.NoError(t, Validar(doc))
+}
+
+func TestCalcularRetencionParcial(t *testing.T) {
+	doc := facturaCompra(&models.RetencionIVA{Codigo: models.CodigoIVARetenidoMadera, Tasa: 8})
+
+	require.NoError(t, Calcular(doc))
+	assert.Equal(t, 80000.0, doc.RetencionIVA.Monto)
+	assert.Equal(t, 110000.0, doc.RetencionIVA.IVANoRetenido)
+	assert.Equal(t, 1115002.0, doc.MontoTotal)
+	assert.NoError(t, Validar(doc))
+}
+
+func TestValidarFacturaCompra(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		modificar func(doc *models.DocumentoTributario)
+		error     string
+	}{
+		{"vendedor igual al comprador", func(doc *models.DocumentoTributario) { doc.RUTReceptor = doc.RUTEmisor }, "distinto del comprador"},
+		{"vendedor sin dirección", func(doc *models.DocumentoTributario) { doc.DireccionReceptor = "" }, "dirección"},
+		{"código que no es retención", func(doc *models.DocumentoTributario) { doc.RetencionIVA.Codigo = "27" }, "código de retención"},
+		{"tasa parcial mayor al IVA", func(doc *models.DocumentoTributario) { doc.RetencionIVA.Tasa = 19 }, "menor que 19%"},
+		{"monto retenido descuadrado", func(doc *models.DocumentoTributario) { doc.RetencionIVA.Monto++ }, "IVA retenido"},
+		{"total sin descontar retención", func(doc *models.DocumentoTributario) { doc.MontoTotal += doc.RetencionIVA.Monto }, "IVA retenido"},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			doc := facturaCompra(&models.RetencionIVA{Codigo: models.CodigoIVARetenidoMadera, Tasa: 8})
+			require.NoError(t, Calcular(doc))
+			caso.modificar(doc)
+			assert.ErrorContains(t, Validar(doc), caso.error)
+		})
+	}
+}
+
+func TestConstruirDTEFacturaCompra(t *testing.T) {
+	doc := facturaCompra(&models.RetencionIVA{Codigo: models.CodigoIVARetenidoMadera, Tasa: 8})
+	require.NoError(t, Calcular(doc))
+
+	dte, err := ConstruirDTE(doc)
+	require.NoError(t, err)
+
+	data, err := xml.Marshal(dte)
+	require.NoError(t, err)
+	salida := string(data)
+
+	assert.Contains(t, salida, `<Documento ID="T46F7">`)
+	assert.Contains(t, salida, `<RUTRecep>9876543-3</RUTRecep><RznSocRecep>Pedro Soto</RznSocRecep>`)
+	assert.Contains(t, salida, `<CodImpAdic>33</CodImpAdic><MontoItem>1000002</MontoItem>`)
+	assert.Contains(t, salida, `<IndExe>1</IndExe>`)
+	assert.Contains(t, salida, `<MntNeto>1000002</MntNeto><MntExe>5000</MntExe><TasaIVA>19</TasaIVA><IVA>190000</IVA>`+
+		`<ImptoReten><TipoImp>33</TipoImp><TasaImp>8</TasaImp><MontoImp>80000</MontoImp></ImptoReten>`+
+		`<IVANoRet>110000</IVANoRet><MntTotal>1115002</MntTotal>`)
+
+	// La retención total no informa IVA no retenido
+	doc = facturaCompra(&models.RetencionIVA{Codigo: models.CodigoIVARetenidoTotal})
+	require.NoError(t, Calcular(doc))
+	dte, err = ConstruirDTE(doc)
+	require.NoError(t, err)
+	assert.Nil(t, dte.Documento.Encabezado.Totales.IVANoRet)
+	assert.Equal(t, "15", dte.Documento.Encabezado.Totales.ImptoReten[0].Tipo)
+}
@@ -0,0 +1,134 @@
+// Package facturacompra implementa el cálculo, la validación y la generación del XML de la
+// factura de compra electrónica (46), que el comprador emite por cuenta de un vendedor que no
+// factura electrónicamente y en la que normalmente retiene todo o parte del IVA.
+package facturacompra
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// tasaIVA es la tasa de IVA que se aplica si el documento no informa otra
+const tasaIVA = 19.0
+
+// Calcular completa los montos de una factura de compra: neto y exento según los ítems, IVA
+// sobre el neto, IVA retenido según el código de retención y total a pagar al vendedor, que
+// descuenta el IVA retenido
+func Calcular(doc *models.DocumentoTributario) error {
+	if doc.TipoDocumento != models.TipoFacturaCompra {
+		return fmt.Errorf("el tipo de documento %d no es factura de compra", doc.TipoDocumento)
+	}
+	if doc.TasaIVA == 0 {
+		doc.TasaIVA = tasaIVA
+	}
+
+	var neto, exento float64
+	for i := range doc.Detalles {
+		detalle := &doc.Detalles[i]
+		if detalle.MontoItem == 0 {
+			detalle.MontoItem = math.Round(detalle.PrecioUnitario * float64(detalle.Cantidad))
+		}
+		if detalle.Exento {
+			exento += detalle.MontoItem
+		} else {
+			neto += detalle.MontoItem
+		}
+	}
+
+	doc.MontoNeto = math.Round(neto)
+	doc.MontoExento = math.Round(exento)
+	doc.MontoIVA = math.Round(doc.MontoNeto * doc.TasaIVA / 100)
+
+	var retenido float64
+	if ret := doc.RetencionIVA; ret != nil {
+		if ret.EsTotal() {
+			ret.Tasa = doc.TasaIVA
+			ret.Monto = doc.MontoIVA
+		} else {
+			ret.Monto = math.Min(math.Round(doc.MontoNeto*ret.Tasa/100), doc.MontoIVA)
+		}
+		ret.IVANoRetenido = doc.MontoIVA - ret.Monto
+		retenido = ret.Monto
+	}
+
+	doc.MontoTotal = doc.MontoNeto + doc.MontoExento + doc.MontoIVA - retenido
+	return nil
+}
+
+// Validar verifica las reglas del SII para la factura de compra: el vendedor se informa como
+// receptor con sus datos completos, la retención usa un código de retención de IVA con una
+// tasa coherente y el total descuenta el IVA retenido
+func Validar(doc *models.DocumentoTributario) error {
+	if doc.TipoDocumento != models.TipoFacturaCompra {
+		return fmt.Errorf("el tipo de documento %d no es factura de compra", doc.TipoDocumento)
+	}
+	if doc.Folio <= 0 {
+		return fmt.Errorf("folio inválido: %d", doc.Folio)
+	}
+
+	if doc.RUTEmisor == "" {
+		return fmt.Errorf("RUT del comprador (emisor) requerido")
+	}
+	if doc.RUTReceptor == "" {
+		return fmt.Errorf("RUT del vendedor (receptor) requerido")
+	}
+	if doc.RUTReceptor == doc.RUTEmisor {
+		return fmt.Errorf("el vendedor (receptor) debe ser distinto del comprador (emisor)")
+	}
+	// El vendedor no emite documentos electrónicos, por lo que el SII exige sus datos completos
+	if doc.RazonSocialReceptor == "" || doc.DireccionReceptor == "" || doc.ComunaReceptor == "" {
+		return fmt.Errorf("razón social, dirección y comuna del vendedor requeridas")
+	}
+
+	if len(doc.Detalles) == 0 {
+		return fmt.Errorf("el documento debe tener al menos un item")
+	}
+
+	if math.Round(doc.MontoNeto*doc.TasaIVA/100) != doc.MontoIVA {
+		return fmt.Errorf("el IVA (%.0f) no corresponde al %.0f%% del neto (%.0f)", doc.MontoIVA, doc.TasaIVA, doc.MontoNeto)
+	}
+
+	var retenido float64
+	if ret := doc.RetencionIVA; ret != nil {
+		if err := validarRetencion(doc, ret); err != nil {
+			return err
+		}
+		retenido = ret.Monto
+	}
+
+	if total := doc.MontoNeto + doc.MontoExento + doc.MontoIVA - retenido; total != doc.MontoTotal {
+		return fmt.Errorf("el total (%.0f) no corresponde a neto + exento + IVA - IVA retenido (%.0f)", doc.MontoTotal, total)
+	}
+
+	return nil
+}
+
+// validarRetencion verifica el código, la tasa y el monto del IVA retenido
+func validarRetencion(doc *models.DocumentoTributario, ret *models.RetencionIVA) error {
+	if models.GlosaRetencionIVA(ret.Codigo) == "" {
+		return fmt.Errorf("código de retención de IVA inválido: %q", ret.Codigo)
+	}
+	if doc.MontoNeto <= 0 {
+		return fmt.Errorf("la retención de IVA requiere monto neto afecto")
+	}
+
+	if ret.EsTotal() {
+		if ret.Tasa != doc.TasaIVA || ret.Monto != doc.MontoIVA {
+			return fmt.Errorf("la retención total debe corresponder a todo el IVA (%.0f)", doc.MontoIVA)
+		}
+	} else {
+		if ret.Tasa <= 0 || ret.Tasa >= doc.TasaIVA {
+			return fmt.Errorf("la tasa de retención parcial debe ser mayor que 0 y menor que %.0f%%", doc.TasaIVA)
+		}
+		if esperado := math.Round(doc.MontoNeto * ret.Tasa / 100); ret.Monto != esperado {
+			return fmt.Errorf("el IVA retenido (%.0f) no corresponde al %.2f%% del neto (%.0f)", ret.Monto, ret.Tasa, esperado)
+		}
+	}
+
+	if ret.IVANoRetenido != doc.MontoIVA-ret.Monto {
+		return fmt.Errorf("el IVA no retenido (%.0f) no corresponde a IVA - IVA retenido (%.0f)", ret.IVANoRetenido, doc.MontoIVA-ret.Monto)
+	}
+	return nil
+}
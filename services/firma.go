@@ -15,6 +15,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/utils/xmldsig"
 	"golang.org/x/crypto/pkcs12"
 )
 
@@ -62,50 +64,18 @@ func NewFirmaManager(rutaCertificado, contraseña string) (*FirmaManager, error)
 
 // FirmarXML firma un documento XML usando el certificado
 func (fm *FirmaManager) FirmarXML(xmlData []byte) ([]byte, error) {
-	// Calcular hash SHA-1 del XML
-	hash := sha1.Sum(xmlData)
-
-	// Firmar hash
-	firma, err := rsa.SignPKCS1v15(rand.Reader, fm.llave, crypto.SHA1, hash[:])
+	xmlFirmado, err := xmldsig.NewFirmante(fm.llave, fm.certificado).Firmar(xmlData)
 	if err != nil {
 		return nil, fmt.Errorf("error firmando XML: %v", err)
 	}
-
-	// Codificar firma en base64
-	firmaBase64 := base64.StdEncoding.EncodeToString(firma)
-
-	// Crear nodo de firma
-	nodoFirma := fmt.Sprintf(`<Signature xmlns="http://www.w3.org/2000/09/xmldsig#">
-  <SignedInfo>
-    <CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315"/>
-    <SignatureMethod Algorithm="http://www.w3.org/2000/09/xmldsig#rsa-sha1"/>
-    <Reference URI="">
-      <Transforms>
-        <Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>
-      </Transforms>
-      <DigestMethod Algorithm="http://www.w3.org/2000/09/xmldsig#sha1"/>
-      <DigestValue>%s</DigestValue>
-    </Reference>
-  </SignedInfo>
-  <SignatureValue>%s</SignatureValue>
-  <KeyInfo>
-    <X509Data>
-      <X509Certificate>%s</X509Certificate>
-    </X509Data>
-  </KeyInfo>
-</Signature>`, base64.StdEncoding.EncodeToString(hash[:]), firmaBase64, base64.StdEncoding.EncodeToString(fm.certificado.Raw))
-
-	// Insertar nodo de firma en el XML
-	xmlStr := string(xmlData)
-	xmlStr = strings.Replace(xmlStr, "</Documento>", nodoFirma+"</Documento>", 1)
-
-	return []byte(xmlStr), nil
+	return xmlFirmado, nil
 }
 
-// ObtenerToken obtiene un token de autenticación del SII
-func (fm *FirmaManager) ObtenerToken() (string, error) {
+// ObtenerToken obtiene un token de autenticación de los servicios del ambiente indicado
+// (ambiente.Registro.EndpointsEmpresa de la empresa que firma)
+func (fm *FirmaManager) ObtenerToken(endpoints ambiente.Endpoints) (string, error) {
 	// Obtener semilla
-	semilla, err := fm.GenerarSemilla()
+	semilla, err := fm.GenerarSemilla(endpoints)
 	if err != nil {
 		return "", fmt.Errorf("error obteniendo semilla: %w", err)
 	}
@@ -128,7 +98,7 @@ xmlns:ns1="http://DefaultNamespace">
 </SOAP-ENV:Envelope>`, semillaFirmada)
 
 	// Preparar request
-	req, err := http.NewRequest("POST", SIITokenEndpoint, strings.NewReader(soapRequest))
+	req, err := http.NewRequest("POST", endpoints.Token, strings.NewReader(soapRequest))
 	if err != nil {
 		return "", fmt.Errorf("error creando request: %w", err)
 	}
@@ -199,8 +169,8 @@ func extractRutFromCert(cert *x509.Certificate) string {
 	return cert.Subject.CommonName
 }
 
-// GenerarSemilla genera una semilla para autenticación con el SII
-func (fm *FirmaManager) GenerarSemilla() (string, error) {
+// GenerarSemilla genera una semilla para autenticación con los servicios del ambiente indicado
+func (fm *FirmaManager) GenerarSemilla(endpoints ambiente.Endpoints) (string, error) {
 	// Crear request SOAP para obtener semilla
 	soapRequest := `<?xml version="1.0" encoding="UTF-8"?>
 <SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"
@@ -211,7 +181,7 @@ xmlns:ns1="http://DefaultNamespace">
 </SOAP-ENV:Envelope>`
 
 	// Preparar request
-	req, err := http.NewRequest("POST", SIISemillaEndpoint, strings.NewReader(soapRequest))
+	req, err := http.NewRequest("POST", endpoints.Semilla, strings.NewReader(soapRequest))
 	if err != nil {
 		return "", fmt.Errorf("error creando request: %w", err)
 	}
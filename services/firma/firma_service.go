@@ -1,25 +1,27 @@
 package firma
 
 import (
-	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/pem"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 
+	"github.com/beevik/etree"
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 // Service representa el servicio de firma digital
 type Service struct {
 	privateKey  *rsa.PrivateKey
 	certificate *x509.Certificate
+	timbres     *ted.Generador
 }
 
 // NewService crea una nueva instancia del servicio de firma digital
@@ -62,7 +64,7 @@ func NewService(config *config.Config) (*Service, error) {
 	}, nil
 }
 
-// FirmarDTE firma un DTE
+// FirmarDTE firma el Documento del DTE con XMLDSig y asigna la firma al modelo
 func (s *Service) FirmarDTE(dte *models.DTEXMLModel) error {
 	if dte == nil {
 		return errors.New("el DTE no puede ser nulo")
@@ -72,110 +74,128 @@ func (s *Service) FirmarDTE(dte *models.DTEXMLModel) error {
 	if dte.Documento.Encabezado.IdDoc.TipoDTE == "" {
 		return errors.New("el tipo de DTE es requerido")
 	}
+	if dte.Documento.ID == "" {
+		return errors.New("el ID del documento es requerido")
+	}
 
-	// Calcular hash del documento
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%v", dte)))
-
-	// Firmar hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	dte.Signature = nil
+	xmlDTE, err := xml.Marshal(dte)
 	if err != nil {
-		return fmt.Errorf("error al firmar DTE: %w", err)
+		return fmt.Errorf("error al generar XML del DTE: %w", err)
 	}
 
-	// Crear firma XML con formato adecuado
-	signatureValue := base64.StdEncoding.EncodeToString(signature)
-
-	// Crear modelo de firma XML
-	dte.Signature = &models.FirmaXMLModel{
-		SignedInfo: models.SignedInfoXML{
-			CanonicalizationMethod: models.CanonicalizationMethodXML{
-				Algorithm: "http://www.w3.org/TR/2001/REC-xml-c14n-20010315",
-			},
-			SignatureMethod: models.SignatureMethodXML{
-				Algorithm: "http://www.w3.org/2000/09/xmldsig#rsa-sha1",
-			},
-			Reference: models.ReferenceSignatureXML{
-				URI: "",
-				Transforms: models.TransformsXML{
-					Transform: []models.TransformXML{
-						{Algorithm: "http://www.w3.org/2000/09/xmldsig#enveloped-signature"},
-					},
-				},
-				DigestMethod: models.DigestMethodXML{
-					Algorithm: "http://www.w3.org/2000/09/xmldsig#sha1",
-				},
-				DigestValue: base64.StdEncoding.EncodeToString(hash[:]),
-			},
-		},
-		SignatureValue: signatureValue,
-		KeyInfo: models.KeyInfoXML{
-			KeyValue: models.KeyValueXML{
-				RSAKeyValue: models.RSAKeyValueXML{
-					Modulus:  base64.StdEncoding.EncodeToString(s.privateKey.N.Bytes()),
-					Exponent: base64.StdEncoding.EncodeToString([]byte{1, 0, 1}), // Exponente común RSA: 65537
-				},
-			},
-			X509Data: models.X509DataXML{
-				X509Certificate: base64.StdEncoding.EncodeToString(s.certificate.Raw),
-			},
-		},
+	firma, err := s.firmar(xmlDTE, dte.Documento.ID)
+	if err != nil {
+		return fmt.Errorf("error al firmar DTE: %w", err)
 	}
+	dte.Signature = firma
 
 	return nil
 }
 
-// GenerarTED genera el Timbre Electrónico del Documento
+// SetGeneradorTED asigna el generador con que se timbran los documentos usando sus CAF
+func (s *Service) SetGeneradorTED(generador *ted.Generador) {
+	s.timbres = generador
+}
+
+// GenerarTED genera el Timbre Electrónico del Documento con el CAF de su tipo, lo asigna al
+// documento y retorna su XML
 func (s *Service) GenerarTED(dte *models.DTEXMLModel) (string, error) {
 	if dte == nil {
 		return "", errors.New("el DTE no puede ser nulo")
 	}
+	if s.timbres == nil {
+		return "", errors.New("no hay CAF configurados para timbrar documentos")
+	}
 
 	// Validar datos requeridos
-	if dte.Documento.Encabezado.IdDoc.TipoDTE == "" {
-		return "", errors.New("el tipo de DTE es requerido")
+	tipoDTE, err := strconv.Atoi(dte.Documento.Encabezado.IdDoc.TipoDTE)
+	if err != nil {
+		return "", fmt.Errorf("el tipo de DTE es inválido: %w", err)
 	}
 
-	// Calcular hash del documento
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%v", dte)))
+	datos := ted.Documento{
+		RutEmisor:           dte.Documento.Encabezado.Emisor.RUT,
+		TipoDTE:             tipoDTE,
+		Folio:               int64(dte.Documento.Encabezado.IdDoc.Folio),
+		FchEmis:             dte.Documento.Encabezado.IdDoc.FechaEmision,
+		RutReceptor:         dte.Documento.Encabezado.Receptor.RUT,
+		RazonSocialReceptor: dte.Documento.Encabezado.Receptor.RazonSocial,
+		MontoTotal:          dte.Documento.Encabezado.Totales.MntTotal,
+	}
+	if len(dte.Documento.Detalle) > 0 {
+		datos.PrimerItem = dte.Documento.Detalle[0].Nombre
+	}
 
-	// Firmar hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	timbre, err := s.timbres.Generar(datos)
 	if err != nil {
 		return "", fmt.Errorf("error al generar TED: %w", err)
 	}
+	if dte.Documento.TED, err = ted.Modelo(timbre); err != nil {
+		return "", err
+	}
 
-	// Generar TED
-	ted := base64.StdEncoding.EncodeToString(signature)
-
-	return ted, nil
+	doc := etree.NewDocument()
+	doc.SetRoot(timbre)
+	return doc.WriteToString()
 }
 
-// FirmarSobre firma un sobre de DTE
+// FirmarSobre firma los DTE sin firma del sobre y luego su SetDTE
 func (s *Service) FirmarSobre(sobre *models.SobreDTEModel) error {
 	if sobre == nil {
 		return errors.New("el sobre no puede ser nulo")
 	}
 
-	if len(sobre.SetDTE.DTEs) == 0 {
+	if sobre.SetDTE == nil || len(sobre.SetDTE.DTEs) == 0 {
 		return errors.New("el sobre debe contener al menos un documento")
 	}
 
 	// Validar datos requeridos
-	if sobre.SetDTE.Caratula.RutEmisor == "" {
+	if sobre.SetDTE.Caratula == nil || sobre.SetDTE.Caratula.RutEmisor == "" {
 		return errors.New("el RUT del emisor es requerido")
 	}
+	if sobre.SetDTE.ID == "" {
+		sobre.SetDTE.ID = "SetDoc"
+	}
 
-	// Calcular hash del sobre
-	hash := sha256.Sum256([]byte(fmt.Sprintf("%v", sobre)))
+	for i := range sobre.SetDTE.DTEs {
+		if sobre.SetDTE.DTEs[i].Signature != nil {
+			continue
+		}
+		if err := s.FirmarDTE(&sobre.SetDTE.DTEs[i]); err != nil {
+			return err
+		}
+	}
 
-	// Firmar hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	sobre.Signature = nil
+	xmlSobre, err := xml.Marshal(sobre)
 	if err != nil {
-		return fmt.Errorf("error al firmar sobre: %w", err)
+		return fmt.Errorf("error al generar XML del sobre: %w", err)
 	}
 
-	// Asignar firma
-	sobre.Signature = base64.StdEncoding.EncodeToString(signature)
+	firma, err := s.firmar(xmlSobre, sobre.SetDTE.ID)
+	if err != nil {
+		return fmt.Errorf("error al firmar sobre: %w", err)
+	}
+	sobre.Signature = firma
 
 	return nil
 }
+
+// firmar firma el elemento referenciado dentro del XML y retorna el nodo Signature como modelo
+func (s *Service) firmar(xmlData []byte, referenciaID string) (*models.FirmaXMLModel, error) {
+	firmado, err := xmldsig.NewFirmante(s.privateKey, s.certificate).FirmarDocumento(xmlData, referenciaID)
+	if err != nil {
+		return nil, err
+	}
+	nodo, err := xmldsig.ExtraerFirma(firmado, referenciaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var firma models.FirmaXMLModel
+	if err := xml.Unmarshal([]byte(nodo), &firma); err != nil {
+		return nil, fmt.Errorf("error al leer firma: %w", err)
+	}
+	return &firma, nil
+}
@@ -10,14 +10,17 @@ import (
 	"net/smtp"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/folios"
 	"github.com/go-redis/redis/v8"
 	"github.com/jung-kurt/gofpdf"
 	"github.com/wcharczuk/go-chart"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // FolioService maneja la integración con el sistema de folios
@@ -26,6 +29,7 @@ type FolioService struct {
 	cafService   *CAFService
 	redisClient  *redis.Client
 	umbralFolios int // Cantidad mínima de folios antes de solicitar nuevo CAF
+	asignador    *folios.Asignador
 }
 
 // Folio representa un folio individual
@@ -38,6 +42,12 @@ type Folio struct {
 	CAFID       string    `bson:"caf_id"`
 	FechaUso    time.Time `bson:"fecha_uso,omitempty"`
 	DocumentoID string    `bson:"documento_id,omitempty"`
+
+	// Anulación ante el SII (folios.Anulador)
+	PorAnular          bool      `bson:"por_anular,omitempty"`
+	MotivoAnulacion    string    `bson:"motivo_anulacion,omitempty"`
+	SolicitudAnulacion string    `bson:"solicitud_anulacion,omitempty"`
+	FechaAnulacion     time.Time `bson:"fecha_anulacion,omitempty"`
 }
 
 // RangoFolios representa un rango de folios de un CAF
@@ -79,11 +89,13 @@ type ReporteFolios struct {
 
 // DetalleUsoFolio representa el detalle de uso de un folio
 type DetalleUsoFolio struct {
-	Numero      int
-	Estado      string
-	FechaUso    time.Time
-	DocumentoID string
-	CAFID       string
+	Numero          int
+	Estado          string
+	FechaUso        time.Time
+	DocumentoID     string
+	CAFID           string
+	FechaAnulacion  time.Time
+	MotivoAnulacion string
 }
 
 // AlertConfig configura las alertas por email
@@ -133,6 +145,12 @@ func NewFolioService(db *mongo.Database, cafService *CAFService, redisClient *re
 	}
 }
 
+// SetAsignador entrega los folios desde bloques arrendados de los CAF importados, en lugar
+// de tomar un bloqueo en Redis y una transacción por folio
+func (s *FolioService) SetAsignador(asignador *folios.Asignador) {
+	s.asignador = asignador
+}
+
 // NewEmailAlertService crea una nueva instancia del servicio de alertas
 func NewEmailAlertService(config AlertConfig) *EmailAlertService {
 	return &EmailAlertService{config: config}
@@ -329,6 +347,10 @@ func (s *FolioService) RegistrarRangoFolios(ctx context.Context, rango RangoFoli
 
 // ObtenerFolioDisponible obtiene el próximo folio disponible con manejo de concurrencia
 func (s *FolioService) ObtenerFolioDisponible(ctx context.Context, rutEmisor string, tipoDTE string) (*Folio, error) {
+	if s.asignador != nil {
+		return s.obtenerFolioArrendado(ctx, rutEmisor, tipoDTE)
+	}
+
 	// Crear una clave única para el bloqueo
 	lockKey := fmt.Sprintf("folio_lock:%s:%s", rutEmisor, tipoDTE)
 
@@ -399,6 +421,27 @@ func (s *FolioService) ObtenerFolioDisponible(ctx context.Context, rutEmisor str
 	return folio, nil
 }
 
+// obtenerFolioArrendado entrega el folio desde el bloque arrendado por la instancia
+func (s *FolioService) obtenerFolioArrendado(ctx context.Context, rutEmisor string, tipoDTE string) (*Folio, error) {
+	tipo, err := strconv.Atoi(tipoDTE)
+	if err != nil {
+		return nil, fmt.Errorf("tipo de documento inválido: %s", tipoDTE)
+	}
+	asignado, err := s.asignador.Asignar(ctx, rutEmisor, models.TipoDTE(tipo))
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo folio disponible: %w", err)
+	}
+	return &Folio{
+		ID:        folios.IDFolio(asignado.RutEmisor, asignado.Ambiente, asignado.TipoDTE, asignado.Folio),
+		RUTEmisor: asignado.RutEmisor,
+		TipoDTE:   tipoDTE,
+		Numero:    int(asignado.Folio),
+		Estado:    "UTILIZADO",
+		CAFID:     asignado.CAFID,
+		FechaUso:  asignado.Fecha,
+	}, nil
+}
+
 // ContarFoliosDisponibles cuenta los folios disponibles
 func (s *FolioService) ContarFoliosDisponibles(ctx context.Context, rutEmisor string, tipoDTE string) (int, error) {
 	count, err := s.db.Collection("folios").CountDocuments(
@@ -565,20 +608,25 @@ func (s *FolioService) procesarNuevoCAF(ctx context.Context, req *models.CAFRequ
 	return nil
 }
 
-// AnularFolio marca un folio como anulado
+// AnularFolio marca un folio para anularlo ante el SII. El folio queda ANULADO cuando se
+// aprueba la solicitud de anulación que lo incluye (folios.Anulador).
 func (s *FolioService) AnularFolio(ctx context.Context, folioID string) error {
-	_, err := s.db.Collection("folios").UpdateOne(
+	resultado, err := s.db.Collection("folios").UpdateOne(
 		ctx,
-		bson.M{"_id": folioID},
+		bson.M{"_id": folioID, "estado": bson.M{"$ne": "ANULADO"}},
 		bson.M{
 			"$set": bson.M{
-				"estado": "ANULADO",
+				"por_anular":       true,
+				"motivo_anulacion": models.MotivoFolioInutilizado,
 			},
 		},
 	)
 	if err != nil {
 		return fmt.Errorf("error anulando folio: %v", err)
 	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("folio %s inexistente o ya anulado", folioID)
+	}
 	return nil
 }
 
@@ -594,16 +642,21 @@ func (s *FolioService) ObtenerHistorialFolio(ctx context.Context, folioID string
 
 // GenerarReporteFolios genera un reporte de uso de folios
 func (s *FolioService) GenerarReporteFolios(ctx context.Context, rutEmisor string, tipoDTE string, periodoInicio, periodoFin time.Time) (*ReporteFolios, error) {
+	// Los folios anulados cuentan en el período de su anulación, porque los de un CAF vencido
+	// nunca se usaron
+	periodo := bson.M{"$gte": periodoInicio, "$lte": periodoFin}
+	filtro := bson.M{
+		"rut_emisor": rutEmisor,
+		"tipo_dte":   tipoDTE,
+		"$or": bson.A{
+			bson.M{"fecha_uso": periodo},
+			bson.M{"fecha_anulacion": periodo},
+		},
+	}
+
 	// Obtener estadísticas generales
 	pipeline := []bson.M{
-		{"$match": bson.M{
-			"rut_emisor": rutEmisor,
-			"tipo_dte":   tipoDTE,
-			"fecha_uso": bson.M{
-				"$gte": periodoInicio,
-				"$lte": periodoFin,
-			},
-		}},
+		{"$match": filtro},
 		{"$group": bson.M{
 			"_id":   "$estado",
 			"count": bson.M{"$sum": 1},
@@ -647,15 +700,8 @@ func (s *FolioService) GenerarReporteFolios(ctx context.Context, rutEmisor strin
 	// Obtener detalle de uso
 	detalleCursor, err := s.db.Collection("folios").Find(
 		ctx,
-		bson.M{
-			"rut_emisor": rutEmisor,
-			"tipo_dte":   tipoDTE,
-			"fecha_uso": bson.M{
-				"$gte": periodoInicio,
-				"$lte": periodoFin,
-			},
-		},
-		bson.M{"sort": bson.M{"numero": 1}},
+		filtro,
+		options.Find().SetSort(bson.M{"numero": 1}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error obteniendo detalle de uso: %v", err)
@@ -669,11 +715,13 @@ func (s *FolioService) GenerarReporteFolios(ctx context.Context, rutEmisor strin
 		}
 
 		reporte.DetalleUso = append(reporte.DetalleUso, DetalleUsoFolio{
-			Numero:      folio.Numero,
-			Estado:      folio.Estado,
-			FechaUso:    folio.FechaUso,
-			DocumentoID: folio.DocumentoID,
-			CAFID:       folio.CAFID,
+			Numero:          folio.Numero,
+			Estado:          folio.Estado,
+			FechaUso:        folio.FechaUso,
+			DocumentoID:     folio.DocumentoID,
+			CAFID:           folio.CAFID,
+			FechaAnulacion:  folio.FechaAnulacion,
+			MotivoAnulacion: folio.MotivoAnulacion,
 		})
 	}
 
@@ -779,7 +827,7 @@ func (s *FolioService) exportarPDF(reporte *ReporteFolios) ([]byte, error) {
 	for _, detalle := range reporte.DetalleUso {
 		pdf.Cell(widths[0], 10, fmt.Sprintf("%d", detalle.Numero))
 		pdf.Cell(widths[1], 10, detalle.Estado)
-		pdf.Cell(widths[2], 10, detalle.FechaUso.Format("02/01/2006"))
+		pdf.Cell(widths[2], 10, fechaDetalle(detalle))
 		pdf.Cell(widths[3], 10, detalle.DocumentoID)
 		pdf.Cell(widths[4], 10, detalle.CAFID)
 		pdf.Ln(10)
@@ -810,6 +858,14 @@ func (s *FolioService) exportarPDF(reporte *ReporteFolios) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// fechaDetalle retorna la fecha de uso del folio o, si se anuló sin usarse, la de anulación
+func fechaDetalle(detalle DetalleUsoFolio) string {
+	if detalle.FechaUso.IsZero() && !detalle.FechaAnulacion.IsZero() {
+		return detalle.FechaAnulacion.Format("02/01/2006")
+	}
+	return detalle.FechaUso.Format("02/01/2006")
+}
+
 // exportarCSV exporta el reporte en formato CSV
 func (s *FolioService) exportarCSV(reporte *ReporteFolios) ([]byte, error) {
 	var buf bytes.Buffer
@@ -844,18 +900,24 @@ func (s *FolioService) exportarCSV(reporte *ReporteFolios) ([]byte, error) {
 		return nil, fmt.Errorf("error escribiendo separador CSV: %v", err)
 	}
 
-	detailHeaders := []string{"Número", "Estado", "Fecha Uso", "Documento ID", "CAF ID"}
+	detailHeaders := []string{"Número", "Estado", "Fecha Uso", "Documento ID", "CAF ID", "Fecha Anulación", "Motivo Anulación"}
 	if err := writer.Write(detailHeaders); err != nil {
 		return nil, fmt.Errorf("error escribiendo encabezados de detalle CSV: %v", err)
 	}
 
 	for _, detalle := range reporte.DetalleUso {
+		fechaAnulacion := ""
+		if !detalle.FechaAnulacion.IsZero() {
+			fechaAnulacion = detalle.FechaAnulacion.Format("02/01/2006")
+		}
 		row := []string{
 			fmt.Sprintf("%d", detalle.Numero),
 			detalle.Estado,
 			detalle.FechaUso.Format("02/01/2006"),
 			detalle.DocumentoID,
 			detalle.CAFID,
+			fechaAnulacion,
+			detalle.MotivoAnulacion,
 		}
 		if err := writer.Write(row); err != nil {
 			return nil, fmt.Errorf("error escribiendo detalle CSV: %v", err)
@@ -0,0 +1,411 @@
+package folios
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// Errores de la anulación de folios
+var (
+	ErrSinFoliosPorAnular   = errors.New("no hay folios por anular")
+	ErrSolicitudSuperpuesta = errors.New("los folios ya están en otra solicitud de anulación")
+	ErrEstadoSolicitud      = errors.New("la solicitud de anulación no está en el estado requerido")
+)
+
+// FolioRegistrado es un folio de la colección de folios
+type FolioRegistrado struct {
+	TipoDTE     models.TipoDTE
+	Folio       int64
+	CAFID       string
+	Estado      string
+	Motivo      models.MotivoAnulacion // Vacío si el folio no se marcó para anular
+	FechaUso    time.Time
+	DocumentoID string
+}
+
+// DocumentoFolio identifica el documento emitido con un folio
+type DocumentoFolio struct {
+	ID           string
+	TipoDTE      models.TipoDTE
+	Folio        int64
+	Estado       models.EstadoDTE
+	TrackID      string
+	FechaEmision time.Time
+}
+
+// RepositorioAnulaciones consulta los folios por anular y persiste las solicitudes
+type RepositorioAnulaciones interface {
+	// Rangos retorna los rangos de los CAF de la empresa en el ambiente
+	Rangos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]*models.RangoFolios, error)
+	// Bloques retorna los bloques de la empresa en el estado indicado
+	Bloques(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estado string) ([]*models.BloqueFolios, error)
+	// FoliosRegistrados retorna los folios entregados antes de limite que siguen como
+	// utilizados y los marcados para anular, sin los incluidos en una solicitud aprobada
+	FoliosRegistrados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, limite time.Time) ([]FolioRegistrado, error)
+	// Documentos retorna los documentos emitidos con los folios indicados. Un documento aún
+	// no enviado no tiene ambiente y se considera de ambos.
+	Documentos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE, folios []int64) ([]DocumentoFolio, error)
+	// DocumentosAnuladosSinEnvio retorna los documentos anulados que nunca se enviaron al SII
+	DocumentosAnuladosSinEnvio(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]DocumentoFolio, error)
+	// Solicitudes retorna las solicitudes de la empresa en los estados indicados
+	Solicitudes(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estados ...models.EstadoSolicitudAnulacion) ([]*models.SolicitudAnulacion, error)
+	// RegistrarSolicitud guarda una solicitud nueva. Falla con ErrSolicitudSuperpuesta si
+	// alguno de sus folios está en una solicitud pendiente, presentada o aprobada.
+	RegistrarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion) error
+	// ObtenerSolicitud retorna una solicitud por su ID
+	ObtenerSolicitud(ctx context.Context, id string) (*models.SolicitudAnulacion, error)
+	// ActualizarSolicitud reemplaza la solicitud si sigue en el estado anterior; si no, falla
+	// con ErrEstadoSolicitud
+	ActualizarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion, anterior models.EstadoSolicitudAnulacion) error
+	// MarcarAnulados registra como anulados los folios de una solicitud aprobada
+	MarcarAnulados(ctx context.Context, solicitud *models.SolicitudAnulacion, fecha time.Time) error
+}
+
+// ConfigAnulacion define cuándo un folio entregado se considera saltado
+type ConfigAnulacion struct {
+	Gracia time.Duration // Tiempo desde la entrega de un folio para esperar su documento
+}
+
+// ConfigAnulacionPorDefecto retorna la configuración recomendada: dos días alcanzan para que
+// un documento con reintentos de emisión quede registrado
+func ConfigAnulacionPorDefecto() ConfigAnulacion {
+	return ConfigAnulacion{Gracia: 48 * time.Hour}
+}
+
+// prioridadMotivos ordena los motivos cuando un folio califica por más de uno
+var prioridadMotivos = []models.MotivoAnulacion{
+	models.MotivoFolioInutilizado,
+	models.MotivoAnuladoAntesEnvio,
+	models.MotivoFolioSaltado,
+	models.MotivoCAFVencido,
+}
+
+// Anulador recolecta los folios que deben anularse ante el SII, genera las solicitudes de
+// anulación y registra su aprobación
+type Anulador struct {
+	repo   RepositorioAnulaciones
+	config ConfigAnulacion
+	reloj  func() time.Time
+}
+
+// NewAnulador crea el anulador sobre el repositorio indicado
+func NewAnulador(repo RepositorioAnulaciones, config ConfigAnulacion) *Anulador {
+	return &Anulador{repo: repo, config: config, reloj: time.Now}
+}
+
+// Recolectar retorna, agrupados en rangos por CAF, los folios de la empresa que deben
+// anularse y que no están en otra solicitud: los marcados para anular, los de documentos
+// anulados antes de enviarse, los entregados sin documento después del plazo de gracia y los
+// no usados de CAF vencidos
+func (a *Anulador) Recolectar(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]models.RangoAnulacion, error) {
+	rutEmisor = utils.NormalizarRUT(rutEmisor)
+	ahora := a.reloj()
+	limite := ahora.Add(-a.config.Gracia)
+
+	rangos, err := a.repo.Rangos(ctx, rutEmisor, ambiente)
+	if err != nil {
+		return nil, err
+	}
+	porMotivo := make(map[models.MotivoAnulacion][]models.RangoAnulacion)
+	agregar := func(cafID string, tipo models.TipoDTE, desde, hasta int64, motivo models.MotivoAnulacion) {
+		porMotivo[motivo] = append(porMotivo[motivo], models.RangoAnulacion{
+			CAFID: cafID, TipoDTE: tipo, Desde: desde, Hasta: hasta, Motivo: motivo,
+		})
+	}
+
+	// Folios entregados por el asignador: los marcados se anulan y los demás quedan como
+	// posibles saltados hasta revisar sus documentos
+	registrados, err := a.repo.FoliosRegistrados(ctx, rutEmisor, ambiente, limite)
+	if err != nil {
+		return nil, err
+	}
+	posibles := make(map[models.TipoDTE]map[int64]string)
+	posible := func(tipo models.TipoDTE, folio int64, cafID string) {
+		if posibles[tipo] == nil {
+			posibles[tipo] = make(map[int64]string)
+		}
+		posibles[tipo][folio] = cafID
+	}
+	for _, f := range registrados {
+		if f.Motivo != "" {
+			agregar(f.CAFID, f.TipoDTE, f.Folio, f.Folio, f.Motivo)
+			continue
+		}
+		posible(f.TipoDTE, f.Folio, f.CAFID)
+	}
+
+	// Los folios sin confirmar de los arriendos expirados pudieron entregarse
+	expirados, err := a.repo.Bloques(ctx, rutEmisor, ambiente, models.EstadoBloqueExpirado)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range expirados {
+		if !b.UpdatedAt.Before(limite) {
+			continue
+		}
+		for folio := b.UsadoHasta + 1; folio <= b.Hasta; folio++ {
+			posible(b.TipoDTE, folio, b.CAFID)
+		}
+	}
+
+	for tipo, folios := range posibles {
+		numeros := make([]int64, 0, len(folios))
+		for folio := range folios {
+			numeros = append(numeros, folio)
+		}
+		documentos, err := a.repo.Documentos(ctx, rutEmisor, ambiente, tipo, numeros)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range documentos {
+			delete(folios, doc.Folio)
+		}
+		for folio, cafID := range folios {
+			agregar(cafID, tipo, folio, folio, models.MotivoFolioSaltado)
+		}
+	}
+
+	anulados, err := a.repo.DocumentosAnuladosSinEnvio(ctx, rutEmisor, ambiente)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range anulados {
+		// Un folio fuera de los CAF del ambiente no se puede anular en él
+		if rango := rangoDeFolio(rangos, doc.TipoDTE, doc.Folio); rango != nil {
+			agregar(rango.CAFID, doc.TipoDTE, doc.Folio, doc.Folio, models.MotivoAnuladoAntesEnvio)
+		}
+	}
+
+	libres, err := a.repo.Bloques(ctx, rutEmisor, ambiente, models.EstadoBloqueLibre)
+	if err != nil {
+		return nil, err
+	}
+	for _, rango := range rangos {
+		if ahora.Before(rango.FechaVencimiento) {
+			continue
+		}
+		if rango.Siguiente <= rango.Hasta {
+			agregar(rango.CAFID, rango.TipoDTE, rango.Siguiente, rango.Hasta, models.MotivoCAFVencido)
+		}
+		for _, b := range libres {
+			if b.RangoID == rango.ID {
+				agregar(b.CAFID, b.TipoDTE, b.Desde, b.Hasta, models.MotivoCAFVencido)
+			}
+		}
+	}
+
+	solicitudes, err := a.repo.Solicitudes(ctx, rutEmisor, ambiente,
+		models.EstadoAnulacionPendiente, models.EstadoAnulacionPresentada, models.EstadoAnulacionAprobada)
+	if err != nil {
+		return nil, err
+	}
+	var cubiertos []models.RangoAnulacion
+	for _, s := range solicitudes {
+		cubiertos = append(cubiertos, s.Rangos...)
+	}
+
+	var resultado []models.RangoAnulacion
+	for _, motivo := range prioridadMotivos {
+		nuevos := RestarRangos(AgruparRangos(porMotivo[motivo]), cubiertos)
+		resultado = append(resultado, nuevos...)
+		cubiertos = append(cubiertos, nuevos...)
+	}
+	return AgruparRangos(resultado), nil
+}
+
+// Generar registra una solicitud pendiente con los folios por anular de la empresa
+func (a *Anulador) Generar(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) (*models.SolicitudAnulacion, error) {
+	rangos, err := a.Recolectar(ctx, rutEmisor, ambiente)
+	if err != nil {
+		return nil, err
+	}
+	if len(rangos) == 0 {
+		return nil, ErrSinFoliosPorAnular
+	}
+
+	ahora := a.reloj()
+	solicitud := &models.SolicitudAnulacion{
+		ID:        models.GenerateID(),
+		RutEmisor: utils.NormalizarRUT(rutEmisor),
+		Ambiente:  ambiente,
+		Rangos:    rangos,
+		Estado:    models.EstadoAnulacionPendiente,
+		CreatedAt: ahora,
+		UpdatedAt: ahora,
+	}
+	for _, r := range rangos {
+		solicitud.CantidadFolios += r.Cantidad()
+	}
+	if err := a.repo.RegistrarSolicitud(ctx, solicitud); err != nil {
+		return nil, err
+	}
+	return solicitud, nil
+}
+
+// Obtener retorna una solicitud de anulación por su ID
+func (a *Anulador) Obtener(ctx context.Context, id string) (*models.SolicitudAnulacion, error) {
+	return a.repo.ObtenerSolicitud(ctx, id)
+}
+
+// Presentar registra que la solicitud se presentó al SII con el número de atención indicado
+func (a *Anulador) Presentar(ctx context.Context, id, numeroAtencion string) (*models.SolicitudAnulacion, error) {
+	solicitud, err := a.repo.ObtenerSolicitud(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if solicitud.Estado != models.EstadoAnulacionPendiente {
+		return nil, fmt.Errorf("%w: %s está %s", ErrEstadoSolicitud, id, solicitud.Estado)
+	}
+
+	ahora := a.reloj()
+	solicitud.Estado = models.EstadoAnulacionPresentada
+	solicitud.NumeroAtencion = numeroAtencion
+	solicitud.FechaPresentacion = &ahora
+	solicitud.UpdatedAt = ahora
+	if err := a.repo.ActualizarSolicitud(ctx, solicitud, models.EstadoAnulacionPendiente); err != nil {
+		return nil, err
+	}
+	return solicitud, nil
+}
+
+// Resolver registra la respuesta del SII a una solicitud presentada. Si se aprueba, sus folios
+// quedan anulados antes de cerrar la solicitud, de modo que un reintento completa la
+// anulación; si se rechaza, los folios vuelven a recolectarse.
+func (a *Anulador) Resolver(ctx context.Context, id string, aprobada bool, glosa string) (*models.SolicitudAnulacion, error) {
+	solicitud, err := a.repo.ObtenerSolicitud(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if solicitud.Estado != models.EstadoAnulacionPresentada {
+		return nil, fmt.Errorf("%w: %s está %s", ErrEstadoSolicitud, id, solicitud.Estado)
+	}
+
+	ahora := a.reloj()
+	solicitud.Estado = models.EstadoAnulacionRechazada
+	if aprobada {
+		if err := a.repo.MarcarAnulados(ctx, solicitud, ahora); err != nil {
+			return nil, err
+		}
+		solicitud.Estado = models.EstadoAnulacionAprobada
+	}
+	solicitud.Glosa = glosa
+	solicitud.FechaResolucion = &ahora
+	solicitud.UpdatedAt = ahora
+	if err := a.repo.ActualizarSolicitud(ctx, solicitud, models.EstadoAnulacionPresentada); err != nil {
+		return nil, err
+	}
+	return solicitud, nil
+}
+
+// ExportarSolicitud genera la solicitud para presentarla en el formulario de anulación de
+// folios del SII, que pide tipo de documento, folio inicial y folio final de cada rango
+func ExportarSolicitud(solicitud *models.SolicitudAnulacion, formato string) ([]byte, error) {
+	switch formato {
+	case "CSV":
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		writer.Comma = ';'
+		writer.Write([]string{"RUT Emisor", "Tipo Documento", "Folio Inicial", "Folio Final", "Cantidad", "Motivo", "CAF"})
+		for _, r := range solicitud.Rangos {
+			writer.Write([]string{
+				solicitud.RutEmisor,
+				strconv.Itoa(int(r.TipoDTE)),
+				strconv.FormatInt(r.Desde, 10),
+				strconv.FormatInt(r.Hasta, 10),
+				strconv.FormatInt(r.Cantidad(), 10),
+				string(r.Motivo),
+				r.CAFID,
+			})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, fmt.Errorf("error al exportar solicitud de anulación: %v", err)
+		}
+		return buf.Bytes(), nil
+	case "JSON":
+		return json.MarshalIndent(solicitud, "", "  ")
+	default:
+		return nil, fmt.Errorf("formato no soportado: %s", formato)
+	}
+}
+
+// AgruparRangos ordena los rangos por tipo y folio y une los continuos o superpuestos del
+// mismo CAF y motivo
+func AgruparRangos(rangos []models.RangoAnulacion) []models.RangoAnulacion {
+	if len(rangos) == 0 {
+		return nil
+	}
+	ordenados := append([]models.RangoAnulacion(nil), rangos...)
+	sort.Slice(ordenados, func(i, j int) bool {
+		if ordenados[i].TipoDTE != ordenados[j].TipoDTE {
+			return ordenados[i].TipoDTE < ordenados[j].TipoDTE
+		}
+		return ordenados[i].Desde < ordenados[j].Desde
+	})
+
+	agrupados := []models.RangoAnulacion{ordenados[0]}
+	for _, r := range ordenados[1:] {
+		actual := &agrupados[len(agrupados)-1]
+		if r.TipoDTE == actual.TipoDTE && r.CAFID == actual.CAFID && r.Motivo == actual.Motivo && r.Desde <= actual.Hasta+1 {
+			if r.Hasta > actual.Hasta {
+				actual.Hasta = r.Hasta
+			}
+			continue
+		}
+		agrupados = append(agrupados, r)
+	}
+	return agrupados
+}
+
+// RestarRangos quita de rangos los folios del mismo tipo incluidos en quitar
+func RestarRangos(rangos, quitar []models.RangoAnulacion) []models.RangoAnulacion {
+	var resultado []models.RangoAnulacion
+	for _, r := range rangos {
+		partes := []models.RangoAnulacion{r}
+		for _, q := range quitar {
+			if q.TipoDTE != r.TipoDTE {
+				continue
+			}
+			var restantes []models.RangoAnulacion
+			for _, p := range partes {
+				if q.Hasta < p.Desde || q.Desde > p.Hasta {
+					restantes = append(restantes, p)
+					continue
+				}
+				if p.Desde < q.Desde {
+					izquierda := p
+					izquierda.Hasta = q.Desde - 1
+					restantes = append(restantes, izquierda)
+				}
+				if p.Hasta > q.Hasta {
+					derecha := p
+					derecha.Desde = q.Hasta + 1
+					restantes = append(restantes, derecha)
+				}
+			}
+			partes = restantes
+		}
+		resultado = append(resultado, partes...)
+	}
+	return resultado
+}
+
+// rangoDeFolio retorna el rango de CAF que contiene el folio, o nil
+func rangoDeFolio(rangos []*models.RangoFolios, tipo models.TipoDTE, folio int64) *models.RangoFolios {
+	for _, r := range rangos {
+		if r.TipoDTE == tipo && r.Superpone(folio, folio) {
+			return r
+		}
+	}
+	return nil
+}
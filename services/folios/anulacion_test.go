@@ -0,0 +1,369 @@
+package folios
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// folioMemoria es un folio de la colección de folios
+type folioMemoria struct {
+	FolioRegistrado
+}
+
+// repositorioAnulacionesMemoria implementa RepositorioAnulaciones y RepositorioConciliacion con
+// las mismas reglas que RepositorioMongo
+type repositorioAnulacionesMemoria struct {
+	mu             sync.Mutex
+	rangos         []*models.RangoFolios
+	bloques        []*models.BloqueFolios
+	folios         []*folioMemoria
+	documentos     []DocumentoFolio
+	solicitudes    map[string]*models.SolicitudAnulacion
+	seguimientos   []*models.SeguimientoEnvio
+	conciliaciones map[string]*models.ReporteConciliacion
+}
+
+func newRepositorioAnulacionesMemoria() *repositorioAnulacionesMemoria {
+	return &repositorioAnulacionesMemoria{
+		solicitudes:    make(map[string]*models.SolicitudAnulacion),
+		conciliaciones: make(map[string]*models.ReporteConciliacion),
+	}
+}
+
+func (r *repositorioAnulacionesMemoria) Rangos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]*models.RangoFolios, error) {
+	return r.rangos, nil
+}
+
+func (r *repositorioAnulacionesMemoria) Bloques(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estado string) ([]*models.BloqueFolios, error) {
+	var bloques []*models.BloqueFolios
+	for _, b := range r.bloques {
+		if b.Estado == estado {
+			bloques = append(bloques, b)
+		}
+	}
+	return bloques, nil
+}
+
+func (r *repositorioAnulacionesMemoria) FoliosRegistrados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, limite time.Time) ([]FolioRegistrado, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var folios []FolioRegistrado
+	for _, f := range r.folios {
+		if f.Estado == "ANULADO" {
+			continue
+		}
+		if f.Motivo != "" || (f.Estado == "UTILIZADO" && f.FechaUso.Before(limite)) {
+			folios = append(folios, f.FolioRegistrado)
+		}
+	}
+	return folios, nil
+}
+
+func (r *repositorioAnulacionesMemoria) Documentos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE, folios []int64) ([]DocumentoFolio, error) {
+	var documentos []DocumentoFolio
+	for _, doc := range r.documentos {
+		for _, folio := range folios {
+			if doc.TipoDTE == tipo && doc.Folio == folio {
+				documentos = append(documentos, doc)
+			}
+		}
+	}
+	return documentos, nil
+}
+
+func (r *repositorioAnulacionesMemoria) DocumentosAnuladosSinEnvio(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]DocumentoFolio, error) {
+	var documentos []DocumentoFolio
+	for _, doc := range r.documentos {
+		if doc.Estado == models.EstadoDTEAnulado && doc.TrackID == "" {
+			documentos = append(documentos, doc)
+		}
+	}
+	return documentos, nil
+}
+
+func (r *repositorioAnulacionesMemoria) Solicitudes(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estados ...models.EstadoSolicitudAnulacion) ([]*models.SolicitudAnulacion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.solicitudesEn(estados...), nil
+}
+
+func (r *repositorioAnulacionesMemoria) solicitudesEn(estados ...models.EstadoSolicitudAnulacion) []*models.SolicitudAnulacion {
+	var solicitudes []*models.SolicitudAnulacion
+	for _, s := range r.solicitudes {
+		for _, estado := range estados {
+			if s.Estado == estado {
+				copia := *s
+				solicitudes = append(solicitudes, &copia)
+			}
+		}
+	}
+	return solicitudes
+}
+
+func (r *repositorioAnulacionesMemoria) RegistrarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	vigentes := r.solicitudesEn(models.EstadoAnulacionPendiente, models.EstadoAnulacionPresentada, models.EstadoAnulacionAprobada)
+	if err := verificarSuperposicion(solicitud, vigentes); err != nil {
+		return err
+	}
+	copia := *solicitud
+	r.solicitudes[solicitud.ID] = &copia
+	return nil
+}
+
+func (r *repositorioAnulacionesMemoria) ObtenerSolicitud(ctx context.Context, id string) (*models.SolicitudAnulacion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.solicitudes[id]
+	if !ok {
+		return nil, fmt.Errorf("no existe solicitud de anulación %s", id)
+	}
+	copia := *s
+	return &copia, nil
+}
+
+func (r *repositorioAnulacionesMemoria) ActualizarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion, anterior models.EstadoSolicitudAnulacion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	guardada, ok := r.solicitudes[solicitud.ID]
+	if !ok || guardada.Estado != anterior {
+		return fmt.Errorf("%w: %s", ErrEstadoSolicitud, solicitud.ID)
+	}
+	copia := *solicitud
+	r.solicitudes[solicitud.ID] = &copia
+	return nil
+}
+
+func (r *repositorioAnulacionesMemoria) MarcarAnulados(ctx context.Context, solicitud *models.SolicitudAnulacion, fecha time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rango := range solicitud.Rangos {
+		for folio := rango.Desde; folio <= rango.Hasta; folio++ {
+			var registro *folioMemoria
+			for _, f := range r.folios {
+				if f.TipoDTE == rango.TipoDTE && f.Folio == folio {
+					registro = f
+				}
+			}
+			if registro == nil {
+				registro = &folioMemoria{FolioRegistrado: FolioRegistrado{TipoDTE: rango.TipoDTE, Folio: folio}}
+				r.folios = append(r.folios, registro)
+			}
+			registro.CAFID = rango.CAFID
+			registro.Estado = "ANULADO"
+			registro.Motivo = rango.Motivo
+		}
+	}
+	return nil
+}
+
+// anulados retorna la cantidad de folios marcados como anulados
+func (r *repositorioAnulacionesMemoria) anulados() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cantidad := 0
+	for _, f := range r.folios {
+		if f.Estado == "ANULADO" {
+			cantidad++
+		}
+	}
+	return cantidad
+}
+
+// escenarioAnulacion arma folios de un CAF vigente y uno vencido con todos los motivos de
+// anulación
+func escenarioAnulacion(ahora time.Time) *repositorioAnulacionesMemoria {
+	repo := newRepositorioAnulacionesMemoria()
+	antiguo := ahora.Add(-72 * time.Hour)
+
+	vigente := rangoPrueba(models.TipoBoleta, 1, 100)
+	vigente.CAFID = "caf-vigente"
+	vigente.Siguiente = 51
+	vigente.FechaVencimiento = ahora.AddDate(0, 3, 0)
+	vencido := rangoPrueba(models.TipoBoleta, 201, 300)
+	vencido.CAFID = "caf-vencido"
+	vencido.Siguiente = 251
+	vencido.FechaVencimiento = ahora.AddDate(0, 0, -1)
+	repo.rangos = []*models.RangoFolios{vigente, vencido}
+
+	repo.bloques = []*models.BloqueFolios{
+		{ID: "b-expirado", RangoID: vigente.ID, CAFID: "caf-vigente", TipoDTE: models.TipoBoleta,
+			Desde: 41, Hasta: 50, UsadoHasta: 45, Estado: models.EstadoBloqueExpirado, UpdatedAt: antiguo},
+		{ID: "b-expirado-reciente", RangoID: vigente.ID, CAFID: "caf-vigente", TipoDTE: models.TipoBoleta,
+			Desde: 31, Hasta: 40, UsadoHasta: 30, Estado: models.EstadoBloqueExpirado, UpdatedAt: ahora},
+		{ID: "b-libre", RangoID: vencido.ID, CAFID: "caf-vencido", TipoDTE: models.TipoBoleta,
+			Desde: 231, Hasta: 240, UsadoHasta: 230, Estado: models.EstadoBloqueLibre},
+		{ID: "b-libre-vigente", RangoID: vigente.ID, CAFID: "caf-vigente", TipoDTE: models.TipoBoleta,
+			Desde: 21, Hasta: 30, UsadoHasta: 20, Estado: models.EstadoBloqueLibre},
+	}
+
+	for folio := int64(1); folio <= 10; folio++ {
+		repo.folios = append(repo.folios, &folioMemoria{
+			FolioRegistrado: FolioRegistrado{TipoDTE: models.TipoBoleta, Folio: folio, CAFID: "caf-vigente", Estado: "UTILIZADO", FechaUso: antiguo},
+		})
+		switch folio {
+		case 5, 10:
+			// Saltados: sin boleta
+		case 9:
+			repo.documentos = append(repo.documentos, DocumentoFolio{TipoDTE: models.TipoBoleta, Folio: folio, Estado: models.EstadoDTEAnulado})
+		default:
+			repo.documentos = append(repo.documentos, DocumentoFolio{TipoDTE: models.TipoBoleta, Folio: folio, Estado: models.EstadoDTEAceptado, TrackID: "123"})
+		}
+	}
+	repo.folios = append(repo.folios,
+		&folioMemoria{FolioRegistrado: FolioRegistrado{TipoDTE: models.TipoBoleta, Folio: 11, CAFID: "caf-vigente", Estado: "UTILIZADO", FechaUso: ahora}},
+		&folioMemoria{FolioRegistrado: FolioRegistrado{TipoDTE: models.TipoBoleta, Folio: 20, CAFID: "caf-vigente", Estado: "UTILIZADO", Motivo: models.MotivoFolioInutilizado, FechaUso: ahora}},
+	)
+	repo.documentos = append(repo.documentos, DocumentoFolio{TipoDTE: models.TipoBoleta, Folio: 46, Estado: models.EstadoDTEEnviado, TrackID: "456"})
+	return repo
+}
+
+func anuladorPrueba(repo RepositorioAnulaciones, ahora time.Time) *Anulador {
+	a := NewAnulador(repo, ConfigAnulacionPorDefecto())
+	a.reloj = func() time.Time { return ahora }
+	return a
+}
+
+func TestRecolectarFoliosPorAnular(t *testing.T) {
+	repo := escenarioAnulacion(inicioPrueba)
+	rangos, err := anuladorPrueba(repo, inicioPrueba).Recolectar(context.Background(), rutEmpresa, models.AmbienteCertificacion)
+	require.NoError(t, err)
+
+	assert.Equal(t, []models.RangoAnulacion{
+		{CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 5, Hasta: 5, Motivo: models.MotivoFolioSaltado},
+		{CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 9, Hasta: 9, Motivo: models.MotivoAnuladoAntesEnvio},
+		{CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 10, Hasta: 10, Motivo: models.MotivoFolioSaltado},
+		{CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 20, Hasta: 20, Motivo: models.MotivoFolioInutilizado},
+		{CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 47, Hasta: 50, Motivo: models.MotivoFolioSaltado},
+		{CAFID: "caf-vencido", TipoDTE: models.TipoBoleta, Desde: 231, Hasta: 240, Motivo: models.MotivoCAFVencido},
+		{CAFID: "caf-vencido", TipoDTE: models.TipoBoleta, Desde: 251, Hasta: 300, Motivo: models.MotivoCAFVencido},
+	}, rangos)
+}
+
+func TestSolicitudAnulacion(t *testing.T) {
+	ctx := context.Background()
+	repo := escenarioAnulacion(inicioPrueba)
+	a := anuladorPrueba(repo, inicioPrueba)
+
+	solicitud, err := a.Generar(ctx, "76.212.889-6", models.AmbienteCertificacion)
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoAnulacionPendiente, solicitud.Estado)
+	assert.Equal(t, rutEmpresa, solicitud.RutEmisor)
+	assert.Equal(t, int64(1+1+1+1+4+10+50), solicitud.CantidadFolios)
+
+	// Los folios de una solicitud vigente no se vuelven a solicitar
+	_, err = a.Generar(ctx, rutEmpresa, models.AmbienteCertificacion)
+	assert.ErrorIs(t, err, ErrSinFoliosPorAnular)
+	_, err = a.Resolver(ctx, solicitud.ID, true, "")
+	assert.ErrorIs(t, err, ErrEstadoSolicitud)
+
+	solicitud, err = a.Presentar(ctx, solicitud.ID, "ATN-2026-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoAnulacionPresentada, solicitud.Estado)
+	assert.Equal(t, "ATN-2026-1", solicitud.NumeroAtencion)
+	require.NotNil(t, solicitud.FechaPresentacion)
+
+	solicitud, err = a.Resolver(ctx, solicitud.ID, true, "Folios anulados")
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoAnulacionAprobada, solicitud.Estado)
+	require.NotNil(t, solicitud.FechaResolucion)
+	assert.Equal(t, 68, repo.anulados())
+
+	_, err = a.Resolver(ctx, solicitud.ID, true, "")
+	assert.ErrorIs(t, err, ErrEstadoSolicitud)
+	rangos, err := a.Recolectar(ctx, rutEmpresa, models.AmbienteCertificacion)
+	require.NoError(t, err)
+	assert.Empty(t, rangos)
+}
+
+func TestSolicitudAnulacionRechazada(t *testing.T) {
+	ctx := context.Background()
+	repo := escenarioAnulacion(inicioPrueba)
+	a := anuladorPrueba(repo, inicioPrueba)
+
+	primera, err := a.Generar(ctx, rutEmpresa, models.AmbienteCertificacion)
+	require.NoError(t, err)
+	_, err = a.Presentar(ctx, primera.ID, "ATN-1")
+	require.NoError(t, err)
+	rechazada, err := a.Resolver(ctx, primera.ID, false, "Rango mal informado")
+	require.NoError(t, err)
+	assert.Equal(t, models.EstadoAnulacionRechazada, rechazada.Estado)
+	assert.Equal(t, "Rango mal informado", rechazada.Glosa)
+	assert.Zero(t, repo.anulados())
+
+	segunda, err := a.Generar(ctx, rutEmpresa, models.AmbienteCertificacion)
+	require.NoError(t, err)
+	assert.NotEqual(t, primera.ID, segunda.ID)
+	assert.Equal(t, primera.Rangos, segunda.Rangos)
+
+	// Una solicitud con folios de otra vigente se rechaza al registrarla
+	err = repo.RegistrarSolicitud(ctx, &models.SolicitudAnulacion{ID: "otra", Rangos: segunda.Rangos[:1]})
+	assert.ErrorIs(t, err, ErrSolicitudSuperpuesta)
+}
+
+func TestAgruparYRestarRangos(t *testing.T) {
+	rango := func(caf string, desde, hasta int64, motivo models.MotivoAnulacion) models.RangoAnulacion {
+		return models.RangoAnulacion{CAFID: caf, TipoDTE: models.TipoBoleta, Desde: desde, Hasta: hasta, Motivo: motivo}
+	}
+	agrupados := AgruparRangos([]models.RangoAnulacion{
+		rango("a", 7, 7, models.MotivoFolioSaltado),
+		rango("a", 3, 5, models.MotivoFolioSaltado),
+		rango("a", 6, 6, models.MotivoFolioSaltado),
+		rango("a", 4, 4, models.MotivoFolioSaltado),
+		rango("a", 8, 8, models.MotivoCAFVencido),
+		rango("b", 9, 12, models.MotivoFolioSaltado),
+	})
+	assert.Equal(t, []models.RangoAnulacion{
+		rango("a", 3, 7, models.MotivoFolioSaltado),
+		rango("a", 8, 8, models.MotivoCAFVencido),
+		rango("b", 9, 12, models.MotivoFolioSaltado),
+	}, agrupados)
+
+	restantes := RestarRangos([]models.RangoAnulacion{rango("a", 1, 20, models.MotivoCAFVencido)}, []models.RangoAnulacion{
+		rango("x", 5, 7, models.MotivoFolioSaltado),
+		rango("x", 15, 25, models.MotivoFolioSaltado),
+		{TipoDTE: models.TipoFactura, Desde: 1, Hasta: 20},
+	})
+	assert.Equal(t, []models.RangoAnulacion{
+		rango("a", 1, 4, models.MotivoCAFVencido),
+		rango("a", 8, 14, models.MotivoCAFVencido),
+	}, restantes)
+}
+
+func TestExportarSolicitud(t *testing.T) {
+	solicitud := &models.SolicitudAnulacion{
+		ID:        "sol-1",
+		RutEmisor: rutEmpresa,
+		Rangos: []models.RangoAnulacion{
+			{CAFID: "caf-1", TipoDTE: models.TipoBoleta, Desde: 47, Hasta: 50, Motivo: models.MotivoFolioSaltado},
+		},
+		CantidadFolios: 4,
+		Estado:         models.EstadoAnulacionPendiente,
+	}
+
+	csv, err := ExportarSolicitud(solicitud, "CSV")
+	require.NoError(t, err)
+	lineas := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	require.Len(t, lineas, 2)
+	assert.Equal(t, "RUT Emisor;Tipo Documento;Folio Inicial;Folio Final;Cantidad;Motivo;CAF", lineas[0])
+	assert.Equal(t, "76212889-6;39;47;50;4;FOLIO_SALTADO;caf-1", lineas[1])
+
+	datos, err := ExportarSolicitud(solicitud, "JSON")
+	require.NoError(t, err)
+	var leida models.SolicitudAnulacion
+	require.NoError(t, json.Unmarshal(datos, &leida))
+	assert.Equal(t, solicitud.Rangos, leida.Rangos)
+
+	_, err = ExportarSolicitud(solicitud, "XLS")
+	assert.Error(t, err)
+}
@@ -0,0 +1,313 @@
+package folios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.uber.org/zap"
+)
+
+// Errores de la asignación de folios
+var (
+	ErrSinFolios       = errors.New("sin folios disponibles")
+	ErrArriendoPerdido = errors.New("el bloque de folios ya no está arrendado a esta instancia")
+)
+
+// Serie identifica los folios de un emisor, ambiente y tipo de documento
+type Serie struct {
+	RutEmisor string
+	Ambiente  models.AmbienteSII
+	TipoDTE   models.TipoDTE
+}
+
+// RepositorioBloques arrienda bloques de folios y registra su uso
+type RepositorioBloques interface {
+	// ArrendarBloque arrienda a la instancia hasta tamano folios contiguos de la serie hasta
+	// expira. Primero se arriendan los folios devueltos y luego se corta un bloque nuevo del
+	// rango vigente de folios más bajos. Falla con ErrSinFolios si no quedan.
+	ArrendarBloque(ctx context.Context, serie Serie, instancia string, tamano int64, ahora, expira time.Time) (*models.BloqueFolios, error)
+	// RenovarBloque guarda el último folio entregado y extiende el arriendo hasta expira. Falla
+	// con ErrArriendoPerdido si el arriendo venció o pasó a otra instancia.
+	RenovarBloque(ctx context.Context, bloque *models.BloqueFolios, ahora, expira time.Time) error
+	// DevolverBloque cierra el bloque en el último folio entregado y deja los folios restantes
+	// en un bloque libre
+	DevolverBloque(ctx context.Context, bloque *models.BloqueFolios, ahora time.Time) error
+	// ExpirarBloques marca como expirados los arriendos vencidos y los retorna, con UsadoHasta
+	// en el último folio del bloque registrado con RegistrarUsos si supera al guardado
+	ExpirarBloques(ctx context.Context, ahora time.Time) ([]*models.BloqueFolios, error)
+	// RegistrarUsos guarda los folios entregados como utilizados
+	RegistrarUsos(ctx context.Context, usos []models.FolioAsignado) error
+}
+
+// Ambientes resuelve el ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error)
+}
+
+// ConfigAsignador define el tamaño y la duración de los arriendos
+type ConfigAsignador struct {
+	Instancia    string        // Identifica la instancia del gateway; vacío genera uno
+	TamanoBloque int64         // Folios por bloque arrendado
+	Arriendo     time.Duration // Duración de cada arriendo
+	Renovacion   time.Duration // Renovación de arriendos y registro de folios entregados
+}
+
+// ConfigAsignadorPorDefecto retorna la configuración recomendada: bloques que alcanzan para
+// algunos segundos de boletas en hora punta, sin inmovilizar muchos folios si la instancia cae
+func ConfigAsignadorPorDefecto() ConfigAsignador {
+	return ConfigAsignador{
+		TamanoBloque: 50,
+		Arriendo:     2 * time.Minute,
+		Renovacion:   20 * time.Second,
+	}
+}
+
+// Asignador entrega los folios de bloques arrendados sin bloqueos distribuidos: cada serie
+// tiene un bloque en la instancia y sus folios se entregan en orden bajo un mutex local. Solo
+// arrendar, renovar y devolver un bloque van a la base de datos.
+//
+// Un bloque se deja de usar una renovación antes de que venza su arriendo, de modo que otra
+// instancia no vea vencido un bloque en uso aunque los relojes difieran en menos que eso. Cada
+// folio entregado queda registrado como utilizado, en lotes, en cada renovación.
+type Asignador struct {
+	repo      RepositorioBloques
+	ambientes Ambientes
+	config    ConfigAsignador
+	reloj     func() time.Time
+
+	mu     sync.Mutex
+	series map[Serie]*serieLocal
+
+	muUsos     sync.Mutex
+	pendientes []models.FolioAsignado
+}
+
+// serieLocal es el bloque que la instancia tiene arrendado para una serie
+type serieLocal struct {
+	mu     sync.Mutex
+	bloque *models.BloqueFolios
+}
+
+// NewAsignador crea el asignador. ambientes puede ser nil si solo se usa AsignarSerie.
+func NewAsignador(repo RepositorioBloques, ambientes Ambientes, config ConfigAsignador) *Asignador {
+	if config.Instancia == "" {
+		config.Instancia = models.GenerateID()
+	}
+	if config.TamanoBloque <= 0 {
+		config.TamanoBloque = ConfigAsignadorPorDefecto().TamanoBloque
+	}
+	return &Asignador{
+		repo:      repo,
+		ambientes: ambientes,
+		config:    config,
+		reloj:     time.Now,
+		series:    make(map[Serie]*serieLocal),
+	}
+}
+
+// Instancia retorna el identificador con que la instancia arrienda los bloques
+func (a *Asignador) Instancia() string {
+	return a.config.Instancia
+}
+
+// Asignar entrega el siguiente folio del tipo de documento en el ambiente de la empresa
+func (a *Asignador) Asignar(ctx context.Context, rutEmisor string, tipo models.TipoDTE) (*models.FolioAsignado, error) {
+	if a.ambientes == nil {
+		return nil, fmt.Errorf("asignador de folios sin registro de ambientes")
+	}
+	ambiente, err := a.ambientes.AmbienteEmpresa(rutEmisor)
+	if err != nil {
+		return nil, err
+	}
+	return a.AsignarSerie(ctx, Serie{RutEmisor: rutEmisor, Ambiente: ambiente, TipoDTE: tipo})
+}
+
+// AsignarSerie entrega el siguiente folio de la serie. Los folios de una serie salen en
+// orden creciente dentro de cada instancia.
+func (a *Asignador) AsignarSerie(ctx context.Context, serie Serie) (*models.FolioAsignado, error) {
+	serie.RutEmisor = utils.NormalizarRUT(serie.RutEmisor)
+	local := a.serie(serie)
+	local.mu.Lock()
+	defer local.mu.Unlock()
+
+	ahora := a.reloj()
+	if b := local.bloque; b != nil {
+		switch {
+		case b.Disponibles() <= 0:
+			if err := a.repo.DevolverBloque(ctx, b, ahora); err != nil {
+				utils.LogError(err, zap.String("proceso", "asignacion_folios"), zap.String("bloque", b.ID))
+			}
+			local.bloque = nil
+		case !a.vigente(b, ahora):
+			// Sin renovar a tiempo el arriendo puede pasar a otra instancia; los folios
+			// restantes se informan cuando se expire. Los entregados se registran antes de
+			// soltarlo, porque la expiración solo conoce el último folio registrado.
+			utils.LogWarning("bloque de folios sin renovar a tiempo",
+				zap.String("bloque", b.ID), zap.Int64("usado_hasta", b.UsadoHasta), zap.Int64("hasta", b.Hasta))
+			if err := a.RegistrarUsos(ctx); err != nil {
+				utils.LogError(err, zap.String("proceso", "asignacion_folios"), zap.String("bloque", b.ID))
+			}
+			local.bloque = nil
+		}
+	}
+	if local.bloque == nil {
+		bloque, err := a.repo.ArrendarBloque(ctx, serie, a.config.Instancia, a.config.TamanoBloque, ahora, ahora.Add(a.config.Arriendo))
+		if err != nil {
+			if errors.Is(err, ErrSinFolios) {
+				return nil, fmt.Errorf("%w: %s tipo %d en %s", ErrSinFolios, serie.RutEmisor, serie.TipoDTE, serie.Ambiente)
+			}
+			return nil, err
+		}
+		local.bloque = bloque
+	}
+
+	b := local.bloque
+	b.UsadoHasta++
+	uso := models.FolioAsignado{
+		Folio:     b.UsadoHasta,
+		TipoDTE:   serie.TipoDTE,
+		RutEmisor: serie.RutEmisor,
+		Ambiente:  serie.Ambiente,
+		CAFID:     b.CAFID,
+		BloqueID:  b.ID,
+		Instancia: a.config.Instancia,
+		Fecha:     ahora,
+	}
+	a.muUsos.Lock()
+	a.pendientes = append(a.pendientes, uso)
+	a.muUsos.Unlock()
+	return &uso, nil
+}
+
+// Iniciar renueva los arriendos, registra los folios entregados y expira los arriendos
+// vencidos de todas las instancias hasta que se cancele el contexto
+func (a *Asignador) Iniciar(ctx context.Context) {
+	ticker := time.NewTicker(a.config.Renovacion)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		a.Mantener(ctx)
+	}
+}
+
+// Mantener registra los folios entregados, devuelve los bloques agotados, renueva los demás e
+// informa los folios sin confirmar de los arriendos vencidos
+func (a *Asignador) Mantener(ctx context.Context) {
+	if err := a.RegistrarUsos(ctx); err != nil {
+		utils.LogError(err, zap.String("proceso", "asignacion_folios"))
+	}
+
+	ahora := a.reloj()
+	for _, local := range a.locales() {
+		local.mu.Lock()
+		if b := local.bloque; b != nil && b.Disponibles() <= 0 {
+			if err := a.repo.DevolverBloque(ctx, b, ahora); err != nil {
+				utils.LogError(err, zap.String("proceso", "asignacion_folios"), zap.String("bloque", b.ID))
+			}
+			local.bloque = nil
+		} else if b != nil && a.vigente(b, ahora) {
+			if err := a.repo.RenovarBloque(ctx, b, ahora, ahora.Add(a.config.Arriendo)); err != nil {
+				utils.LogError(err, zap.String("proceso", "asignacion_folios"), zap.String("bloque", b.ID))
+				if errors.Is(err, ErrArriendoPerdido) {
+					local.bloque = nil
+				}
+			} else {
+				b.ExpiraEn = ahora.Add(a.config.Arriendo)
+			}
+		}
+		local.mu.Unlock()
+	}
+
+	expirados, err := a.repo.ExpirarBloques(ctx, ahora)
+	if err != nil {
+		utils.LogError(err, zap.String("proceso", "asignacion_folios"))
+		return
+	}
+	for _, b := range expirados {
+		utils.LogWarning("arriendo de folios expirado; folios sin confirmar",
+			zap.String("bloque", b.ID),
+			zap.String("rut_emisor", b.RutEmisor),
+			zap.Int("tipo_dte", int(b.TipoDTE)),
+			zap.String("instancia", b.Instancia),
+			zap.Int64("desde", b.UsadoHasta+1),
+			zap.Int64("hasta", b.Hasta))
+	}
+}
+
+// RegistrarUsos guarda los folios entregados desde el último registro. Si falla se
+// reintentan en el siguiente.
+func (a *Asignador) RegistrarUsos(ctx context.Context) error {
+	a.muUsos.Lock()
+	usos := a.pendientes
+	a.pendientes = nil
+	a.muUsos.Unlock()
+	if len(usos) == 0 {
+		return nil
+	}
+
+	if err := a.repo.RegistrarUsos(ctx, usos); err != nil {
+		a.muUsos.Lock()
+		a.pendientes = append(usos, a.pendientes...)
+		a.muUsos.Unlock()
+		return fmt.Errorf("error al registrar %d folios entregados: %v", len(usos), err)
+	}
+	return nil
+}
+
+// Cerrar registra los folios entregados y devuelve los bloques arrendados, para que otra
+// instancia use los folios restantes. Se llama al detener la instancia.
+func (a *Asignador) Cerrar(ctx context.Context) error {
+	var errs []error
+	if err := a.RegistrarUsos(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	ahora := a.reloj()
+	for _, local := range a.locales() {
+		local.mu.Lock()
+		if b := local.bloque; b != nil {
+			if err := a.repo.DevolverBloque(ctx, b, ahora); err != nil {
+				errs = append(errs, fmt.Errorf("error al devolver bloque %s: %v", b.ID, err))
+			}
+			local.bloque = nil
+		}
+		local.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// vigente indica si el bloque aún puede entregar folios, con una renovación de margen
+func (a *Asignador) vigente(bloque *models.BloqueFolios, ahora time.Time) bool {
+	return ahora.Before(bloque.ExpiraEn.Add(-a.config.Renovacion))
+}
+
+func (a *Asignador) serie(serie Serie) *serieLocal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	local, ok := a.series[serie]
+	if !ok {
+		local = &serieLocal{}
+		a.series[serie] = local
+	}
+	return local
+}
+
+func (a *Asignador) locales() []*serieLocal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	locales := make([]*serieLocal, 0, len(a.series))
+	for _, local := range a.series {
+		locales = append(locales, local)
+	}
+	return locales
+}
@@ -0,0 +1,524 @@
+package folios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	utils.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// repositorioBloquesMemoria implementa RepositorioBloques con las mismas reglas que
+// RepositorioMongo; latencia simula el viaje a la base de datos de cada operación
+type repositorioBloquesMemoria struct {
+	mu         sync.Mutex
+	latencia   time.Duration
+	rangos     []*models.RangoFolios
+	bloques    map[string]*models.BloqueFolios
+	usos       map[string]models.FolioAsignado
+	fallarUsos int
+}
+
+func newRepositorioBloquesMemoria(rangos ...*models.RangoFolios) *repositorioBloquesMemoria {
+	return &repositorioBloquesMemoria{
+		rangos:  rangos,
+		bloques: make(map[string]*models.BloqueFolios),
+		usos:    make(map[string]models.FolioAsignado),
+	}
+}
+
+func (r *repositorioBloquesMemoria) esperar() {
+	if r.latencia > 0 {
+		time.Sleep(r.latencia)
+	}
+}
+
+func (r *repositorioBloquesMemoria) ArrendarBloque(ctx context.Context, serie Serie, instancia string, tamano int64, ahora, expira time.Time) (*models.BloqueFolios, error) {
+	r.esperar()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deSerie := func(rut string, ambiente models.AmbienteSII, tipo models.TipoDTE, vencimiento time.Time) bool {
+		return rut == utils.NormalizarRUT(serie.RutEmisor) && ambiente == serie.Ambiente && tipo == serie.TipoDTE && vencimiento.After(ahora)
+	}
+
+	var libre *models.BloqueFolios
+	for _, b := range r.bloques {
+		if b.Estado == models.EstadoBloqueLibre && deSerie(b.RutEmisor, b.Ambiente, b.TipoDTE, b.FechaVencimiento) &&
+			(libre == nil || b.Desde < libre.Desde) {
+			libre = b
+		}
+	}
+	if libre != nil {
+		libre.Estado = models.EstadoBloqueArrendado
+		libre.Instancia = instancia
+		libre.ExpiraEn = expira
+		copia := *libre
+		return &copia, nil
+	}
+
+	var rango *models.RangoFolios
+	for _, rg := range r.rangos {
+		if rg.Estado == models.EstadoRangoDisponible && deSerie(rg.RutEmisor, rg.Ambiente, rg.TipoDTE, rg.FechaVencimiento) &&
+			(rango == nil || rg.Desde < rango.Desde) {
+			rango = rg
+		}
+	}
+	if rango == nil {
+		return nil, ErrSinFolios
+	}
+	bloque := nuevoBloque(rango, tamano, instancia, ahora, expira)
+	rango.Siguiente = bloque.Hasta + 1
+	if rango.Siguiente > rango.Hasta {
+		rango.Estado = models.EstadoRangoAgotado
+	}
+	r.bloques[bloque.ID] = bloque
+	copia := *bloque
+	return &copia, nil
+}
+
+// arrendado retorna el bloque si sigue arrendado a la instancia
+func (r *repositorioBloquesMemoria) arrendado(bloque *models.BloqueFolios) (*models.BloqueFolios, error) {
+	guardado, ok := r.bloques[bloque.ID]
+	if !ok || guardado.Estado != models.EstadoBloqueArrendado || guardado.Instancia != bloque.Instancia {
+		return nil, fmt.Errorf("%w: %s", ErrArriendoPerdido, bloque.ID)
+	}
+	return guardado, nil
+}
+
+func (r *repositorioBloquesMemoria) RenovarBloque(ctx context.Context, bloque *models.BloqueFolios, ahora, expira time.Time) error {
+	r.esperar()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	guardado, err := r.arrendado(bloque)
+	if err != nil {
+		return err
+	}
+	if !guardado.ExpiraEn.After(ahora) {
+		return fmt.Errorf("%w: %s", ErrArriendoPerdido, bloque.ID)
+	}
+	guardado.ExpiraEn = expira
+	if bloque.UsadoHasta > guardado.UsadoHasta {
+		guardado.UsadoHasta = bloque.UsadoHasta
+	}
+	return nil
+}
+
+func (r *repositorioBloquesMemoria) DevolverBloque(ctx context.Context, bloque *models.BloqueFolios, ahora time.Time) error {
+	r.esperar()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	guardado, err := r.arrendado(bloque)
+	if err != nil {
+		return err
+	}
+	if bloque.UsadoHasta < bloque.Desde {
+		guardado.Estado = models.EstadoBloqueLibre
+		guardado.Instancia = ""
+		guardado.ExpiraEn = time.Time{}
+		return nil
+	}
+	if resto := bloqueRestante(bloque, ahora); resto != nil {
+		r.bloques[resto.ID] = resto
+	}
+	guardado.Estado = models.EstadoBloqueCerrado
+	guardado.Hasta = bloque.UsadoHasta
+	guardado.UsadoHasta = bloque.UsadoHasta
+	return nil
+}
+
+func (r *repositorioBloquesMemoria) ExpirarBloques(ctx context.Context, ahora time.Time) ([]*models.BloqueFolios, error) {
+	r.esperar()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expirados []*models.BloqueFolios
+	for _, b := range r.bloques {
+		if b.Estado == models.EstadoBloqueArrendado && !b.ExpiraEn.After(ahora) {
+			for _, uso := range r.usos {
+				if uso.BloqueID == b.ID && uso.Folio > b.UsadoHasta {
+					b.UsadoHasta = uso.Folio
+				}
+			}
+			b.Estado = models.EstadoBloqueExpirado
+			copia := *b
+			expirados = append(expirados, &copia)
+		}
+	}
+	return expirados, nil
+}
+
+func (r *repositorioBloquesMemoria) RegistrarUsos(ctx context.Context, usos []models.FolioAsignado) error {
+	r.esperar()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fallarUsos > 0 {
+		r.fallarUsos--
+		return errors.New("base de datos no disponible")
+	}
+	for _, uso := range usos {
+		r.usos[IDFolio(uso.RutEmisor, uso.Ambiente, uso.TipoDTE, uso.Folio)] = uso
+	}
+	return nil
+}
+
+// bloquesEn retorna los bloques de la serie en el estado indicado, ordenados por folio
+func (r *repositorioBloquesMemoria) bloquesEn(estado string) []models.BloqueFolios {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var bloques []models.BloqueFolios
+	for _, b := range r.bloques {
+		if b.Estado == estado {
+			bloques = append(bloques, *b)
+		}
+	}
+	sort.Slice(bloques, func(i, j int) bool { return bloques[i].Desde < bloques[j].Desde })
+	return bloques
+}
+
+// relojPrueba es un reloj que avanza solo cuando el test lo indica
+type relojPrueba struct {
+	mu    sync.Mutex
+	ahora time.Time
+}
+
+func (r *relojPrueba) Now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ahora
+}
+
+func (r *relojPrueba) Avanzar(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ahora = r.ahora.Add(d)
+}
+
+// ambientesPrueba asigna el ambiente de cada empresa por RUT
+type ambientesPrueba map[string]models.AmbienteSII
+
+func (a ambientesPrueba) AmbienteEmpresa(rut string) (models.AmbienteSII, error) {
+	ambiente, ok := a[utils.NormalizarRUT(rut)]
+	if !ok {
+		return "", fmt.Errorf("empresa %s sin ambiente", rut)
+	}
+	return ambiente, nil
+}
+
+var inicioPrueba = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+func rangoPrueba(tipo models.TipoDTE, desde, hasta int64) *models.RangoFolios {
+	return &models.RangoFolios{
+		ID:               models.GenerateID(),
+		CAFID:            fmt.Sprintf("caf-%d-%d", desde, hasta),
+		RutEmisor:        rutEmpresa,
+		Ambiente:         models.AmbienteCertificacion,
+		TipoDTE:          tipo,
+		Desde:            desde,
+		Hasta:            hasta,
+		Siguiente:        desde,
+		Estado:           models.EstadoRangoDisponible,
+		FechaVencimiento: inicioPrueba.AddDate(0, MesesVigenciaCAF, 0),
+	}
+}
+
+func asignadorPrueba(repo RepositorioBloques, instancia string, reloj *relojPrueba) *Asignador {
+	a := NewAsignador(repo, ambientesPrueba{rutEmpresa: models.AmbienteCertificacion}, ConfigAsignador{
+		Instancia:    instancia,
+		TamanoBloque: 50,
+		Arriendo:     2 * time.Minute,
+		Renovacion:   20 * time.Second,
+	})
+	a.reloj = reloj.Now
+	return a
+}
+
+func TestAsignadorEntregaFoliosEnOrden(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 80), rangoPrueba(models.TipoBoleta, 101, 140))
+	a := asignadorPrueba(repo, "gw-1", &relojPrueba{ahora: inicioPrueba})
+
+	var entregados []int64
+	for i := 0; i < 120; i++ {
+		folio, err := a.Asignar(ctx, "76.212.889-6", models.TipoBoleta)
+		require.NoError(t, err)
+		assert.Equal(t, models.AmbienteCertificacion, folio.Ambiente)
+		entregados = append(entregados, folio.Folio)
+	}
+	assert.Equal(t, int64(1), entregados[0])
+	assert.Equal(t, int64(80), entregados[79])
+	assert.Equal(t, int64(101), entregados[80])
+	assert.Equal(t, int64(140), entregados[119])
+	assert.True(t, sort.SliceIsSorted(entregados, func(i, j int) bool { return entregados[i] < entregados[j] }))
+
+	_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	assert.ErrorIs(t, err, ErrSinFolios)
+
+	a.Mantener(ctx)
+	assert.Len(t, repo.usos, 120)
+	assert.Contains(t, repo.usos, IDFolio(rutEmpresa, models.AmbienteCertificacion, models.TipoBoleta, 101))
+	assert.Len(t, repo.bloquesEn(models.EstadoBloqueCerrado), 3)
+	assert.Empty(t, repo.bloquesEn(models.EstadoBloqueArrendado))
+}
+
+func TestAsignadorConcurrenteSinDuplicados(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoFactura, 1, 5000))
+	reloj := &relojPrueba{ahora: inicioPrueba}
+	instancias := []*Asignador{asignadorPrueba(repo, "gw-1", reloj), asignadorPrueba(repo, "gw-2", reloj), asignadorPrueba(repo, "gw-3", reloj)}
+
+	var mu sync.Mutex
+	vistos := make(map[int64]bool)
+	porInstancia := make(map[string][]int64)
+	var wg sync.WaitGroup
+	for _, a := range instancias {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(a *Asignador) {
+				defer wg.Done()
+				for i := 0; i < 110; i++ {
+					folio, err := a.AsignarSerie(ctx, Serie{RutEmisor: rutEmpresa, Ambiente: models.AmbienteCertificacion, TipoDTE: models.TipoFactura})
+					if !assert.NoError(t, err) {
+						return
+					}
+					mu.Lock()
+					assert.False(t, vistos[folio.Folio], "folio %d entregado dos veces", folio.Folio)
+					vistos[folio.Folio] = true
+					porInstancia[folio.Instancia] = append(porInstancia[folio.Instancia], folio.Folio)
+					mu.Unlock()
+				}
+			}(a)
+		}
+	}
+	wg.Wait()
+	assert.Len(t, vistos, 3*4*110)
+
+	for _, a := range instancias {
+		require.NoError(t, a.Cerrar(ctx))
+	}
+	assert.Len(t, repo.usos, 3*4*110)
+
+	// Los bloques cerrados contienen exactamente los folios entregados y no se superponen con
+	// los devueltos
+	var cubiertos int64
+	for _, b := range repo.bloquesEn(models.EstadoBloqueCerrado) {
+		cubiertos += b.Hasta - b.Desde + 1
+		for f := b.Desde; f <= b.Hasta; f++ {
+			assert.True(t, vistos[f], "folio %d cerrado sin entregarse", f)
+		}
+	}
+	assert.Equal(t, int64(3*4*110), cubiertos)
+
+	anterior := int64(0)
+	todos := append(repo.bloquesEn(models.EstadoBloqueCerrado), repo.bloquesEn(models.EstadoBloqueLibre)...)
+	sort.Slice(todos, func(i, j int) bool { return todos[i].Desde < todos[j].Desde })
+	for _, b := range todos {
+		assert.Greater(t, b.Desde, anterior)
+		anterior = b.Hasta
+	}
+}
+
+func TestAsignadorCerrarDevuelveFolios(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 1000))
+	reloj := &relojPrueba{ahora: inicioPrueba}
+	a := asignadorPrueba(repo, "gw-1", reloj)
+	b := asignadorPrueba(repo, "gw-2", reloj)
+
+	for i := 0; i < 10; i++ {
+		_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+		require.NoError(t, err)
+	}
+	folioB, err := b.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(51), folioB.Folio)
+
+	require.NoError(t, a.Cerrar(ctx))
+	libres := repo.bloquesEn(models.EstadoBloqueLibre)
+	require.Len(t, libres, 1)
+	assert.Equal(t, int64(11), libres[0].Desde)
+	assert.Equal(t, int64(50), libres[0].Hasta)
+
+	// Los folios devueltos se arriendan antes que un bloque nuevo
+	c := asignadorPrueba(repo, "gw-3", reloj)
+	folioC, err := c.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), folioC.Folio)
+	assert.Equal(t, "gw-3", folioC.Instancia)
+
+	// Un bloque sin folios entregados vuelve completo
+	require.NoError(t, b.Cerrar(ctx))
+	require.NoError(t, c.Cerrar(ctx))
+	d := asignadorPrueba(repo, "gw-4", reloj)
+	folioD, err := d.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), folioD.Folio)
+}
+
+func TestAsignadorArriendoVencido(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 1000))
+	reloj := &relojPrueba{ahora: inicioPrueba}
+	a := asignadorPrueba(repo, "gw-1", reloj)
+	b := asignadorPrueba(repo, "gw-2", reloj)
+
+	for i := 0; i < 5; i++ {
+		_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+		require.NoError(t, err)
+	}
+	// La renovación guarda el último folio entregado y extiende el arriendo
+	reloj.Avanzar(time.Minute)
+	a.Mantener(ctx)
+	arrendados := repo.bloquesEn(models.EstadoBloqueArrendado)
+	require.Len(t, arrendados, 1)
+	assert.Equal(t, int64(5), arrendados[0].UsadoHasta)
+	assert.Equal(t, inicioPrueba.Add(3*time.Minute), arrendados[0].ExpiraEn)
+
+	_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+
+	// Sin renovaciones el bloque deja de usarse antes de vencer, registrando el folio 6, y
+	// otra instancia lo expira
+	reloj.Avanzar(110 * time.Second)
+	folio, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(51), folio.Folio)
+	assert.Contains(t, repo.usos, IDFolio(rutEmpresa, models.AmbienteCertificacion, models.TipoBoleta, 6))
+
+	reloj.Avanzar(20 * time.Second)
+	b.Mantener(ctx)
+	expirados := repo.bloquesEn(models.EstadoBloqueExpirado)
+	require.Len(t, expirados, 1)
+	assert.Equal(t, int64(1), expirados[0].Desde)
+	assert.Equal(t, int64(6), expirados[0].UsadoHasta)
+
+	// Los folios de un arriendo expirado no se vuelven a entregar
+	folio, err = b.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	assert.Equal(t, int64(101), folio.Folio)
+}
+
+func TestExpirarBloquesConUsosRegistrados(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 1000))
+	reloj := &relojPrueba{ahora: inicioPrueba}
+	a := asignadorPrueba(repo, "gw-1", reloj)
+	b := asignadorPrueba(repo, "gw-2", reloj)
+
+	for i := 0; i < 3; i++ {
+		_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+		require.NoError(t, err)
+	}
+	// Los usos quedan registrados pero el arriendo no se renueva
+	require.NoError(t, a.RegistrarUsos(ctx))
+
+	reloj.Avanzar(2 * time.Minute)
+	b.Mantener(ctx)
+	expirados := repo.bloquesEn(models.EstadoBloqueExpirado)
+	require.Len(t, expirados, 1)
+	assert.Equal(t, int64(3), expirados[0].UsadoHasta)
+}
+
+func TestAsignadorReintentaRegistroDeUsos(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 1000))
+	repo.fallarUsos = 1
+	a := asignadorPrueba(repo, "gw-1", &relojPrueba{ahora: inicioPrueba})
+
+	for i := 0; i < 3; i++ {
+		_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+		require.NoError(t, err)
+	}
+	assert.Error(t, a.RegistrarUsos(ctx))
+	assert.Empty(t, repo.usos)
+
+	_, err := a.Asignar(ctx, rutEmpresa, models.TipoBoleta)
+	require.NoError(t, err)
+	require.NoError(t, a.RegistrarUsos(ctx))
+	assert.Len(t, repo.usos, 4)
+}
+
+// rutaBloqueoPorFolio reproduce FolioService.ObtenerFolioDisponible: SetNX en Redis, conteo de
+// disponibles, transacción por folio y Del, cada uno con un viaje a la base de datos
+type rutaBloqueoPorFolio struct {
+	latencia  time.Duration
+	mu        sync.Mutex
+	bloqueado bool
+	siguiente int64
+}
+
+func (r *rutaBloqueoPorFolio) obtener() (int64, error) {
+	time.Sleep(r.latencia) // SetNX
+	r.mu.Lock()
+	if r.bloqueado {
+		r.mu.Unlock()
+		return 0, errors.New("no se pudo obtener el bloqueo para asignar folio")
+	}
+	r.bloqueado = true
+	r.mu.Unlock()
+
+	time.Sleep(r.latencia)     // CountDocuments
+	time.Sleep(3 * r.latencia) // StartTransaction, FindOneAndUpdate y CommitTransaction
+	r.mu.Lock()
+	r.siguiente++
+	folio := r.siguiente
+	r.mu.Unlock()
+
+	time.Sleep(r.latencia) // Del
+	r.mu.Lock()
+	r.bloqueado = false
+	r.mu.Unlock()
+	return folio, nil
+}
+
+const latenciaBenchmark = 200 * time.Microsecond
+
+func BenchmarkAsignacionBloqueoPorFolio(b *testing.B) {
+	ruta := &rutaBloqueoPorFolio{latencia: latenciaBenchmark}
+	var fallos int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ruta.obtener(); err != nil {
+				atomic.AddInt64(&fallos, 1)
+			}
+		}
+	})
+	b.ReportMetric(float64(fallos)/float64(b.N), "fallos/op")
+}
+
+func BenchmarkAsignacionBloquesArrendados(b *testing.B) {
+	ctx := context.Background()
+	repo := newRepositorioBloquesMemoria(rangoPrueba(models.TipoBoleta, 1, 1<<40))
+	repo.latencia = latenciaBenchmark
+	a := NewAsignador(repo, nil, ConfigAsignadorPorDefecto())
+	serie := Serie{RutEmisor: rutEmpresa, Ambiente: models.AmbienteCertificacion, TipoDTE: models.TipoBoleta}
+	var fallos int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := a.AsignarSerie(ctx, serie); err != nil {
+				atomic.AddInt64(&fallos, 1)
+			}
+		}
+	})
+	b.StopTimer()
+	require.NoError(b, a.Cerrar(ctx))
+	b.ReportMetric(float64(fallos)/float64(b.N), "fallos/op")
+}
@@ -0,0 +1,79 @@
+package folios
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cifrador protege en reposo la llave RSASK de los CAF. contexto se autentica junto con el
+// texto cifrado, de modo que una llave no pueda trasladarse al registro de otro CAF.
+type Cifrador interface {
+	LlaveID() string
+	Cifrar(plano, contexto []byte) ([]byte, error)
+	Descifrar(cifrado, contexto []byte) ([]byte, error)
+}
+
+// CifradorAES cifra con AES-256-GCM; el resultado es el nonce seguido del texto cifrado
+type CifradorAES struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewCifradorAES crea un cifrador con una llave maestra de 32 bytes. id identifica la llave en
+// cada registro cifrado, para poder rotarla.
+func NewCifradorAES(id string, llave []byte) (*CifradorAES, error) {
+	if len(llave) != 32 {
+		return nil, errors.New("la llave maestra debe tener 32 bytes")
+	}
+	bloque, err := aes.NewCipher(llave)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear cifrador: %v", err)
+	}
+	aead, err := cipher.NewGCM(bloque)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear cifrador: %v", err)
+	}
+	return &CifradorAES{id: id, aead: aead}, nil
+}
+
+// NewCifradorAESBase64 crea el cifrador con la llave maestra codificada en base64, como se
+// entrega por variable de entorno
+func NewCifradorAESBase64(id, llave string) (*CifradorAES, error) {
+	data, err := base64.StdEncoding.DecodeString(llave)
+	if err != nil {
+		return nil, fmt.Errorf("llave maestra inválida: %v", err)
+	}
+	return NewCifradorAES(id, data)
+}
+
+// LlaveID implementa Cifrador
+func (c *CifradorAES) LlaveID() string {
+	return c.id
+}
+
+// Cifrar implementa Cifrador
+func (c *CifradorAES) Cifrar(plano, contexto []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error al generar nonce: %v", err)
+	}
+	return c.aead.Seal(nonce, nonce, plano, contexto), nil
+}
+
+// Descifrar implementa Cifrador
+func (c *CifradorAES) Descifrar(cifrado, contexto []byte) ([]byte, error) {
+	if len(cifrado) < c.aead.NonceSize() {
+		return nil, errors.New("texto cifrado demasiado corto")
+	}
+	nonce, datos := cifrado[:c.aead.NonceSize()], cifrado[c.aead.NonceSize():]
+	plano, err := c.aead.Open(nil, nonce, datos, contexto)
+	if err != nil {
+		return nil, fmt.Errorf("error al descifrar: %v", err)
+	}
+	return plano, nil
+}
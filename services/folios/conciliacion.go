@@ -0,0 +1,635 @@
+package folios
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// RepositorioConciliacion consulta lo registrado de los folios de una empresa y guarda los
+// reportes de conciliación
+type RepositorioConciliacion interface {
+	// Rangos retorna los rangos de los CAF de la empresa en el ambiente
+	Rangos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]*models.RangoFolios, error)
+	// Bloques retorna los bloques de la empresa en el estado indicado
+	Bloques(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estado string) ([]*models.BloqueFolios, error)
+	// Solicitudes retorna las solicitudes de anulación de la empresa en los estados indicados
+	Solicitudes(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estados ...models.EstadoSolicitudAnulacion) ([]*models.SolicitudAnulacion, error)
+	// FoliosSerie retorna los folios de un tipo de documento de la colección de folios
+	FoliosSerie(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]FolioRegistrado, error)
+	// DocumentosEmitidos retorna los documentos emitidos de un tipo, enviados o no
+	DocumentosEmitidos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]DocumentoFolio, error)
+	// Seguimientos retorna el seguimiento de los TrackID indicados
+	Seguimientos(ctx context.Context, ambiente models.AmbienteSII, trackIDs []string) ([]*models.SeguimientoEnvio, error)
+	// RegistrarFoliosConciliados registra como utilizados los folios indicados que no están en
+	// la colección de folios; nunca modifica un folio ya registrado
+	RegistrarFoliosConciliados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, folios []FolioRegistrado) error
+	// GuardarConciliacion guarda un reporte de conciliación
+	GuardarConciliacion(ctx context.Context, reporte *models.ReporteConciliacion) error
+	// ObtenerConciliacion retorna un reporte de conciliación por su ID
+	ObtenerConciliacion(ctx context.Context, id string) (*models.ReporteConciliacion, error)
+}
+
+// ConfigConciliacion define cuánto se espera el documento de un folio recién entregado
+type ConfigConciliacion struct {
+	Gracia time.Duration // Tiempo desde la entrega de un folio para esperar su documento
+}
+
+// ConfigConciliacionPorDefecto retorna la configuración recomendada, con el mismo plazo de
+// gracia del Anulador para que ambos consideren saltados los mismos folios
+func ConfigConciliacionPorDefecto() ConfigConciliacion {
+	return ConfigConciliacion{Gracia: ConfigAnulacionPorDefecto().Gracia}
+}
+
+// Conciliador recorre los rangos de los CAF de una empresa y verifica que cada folio usado
+// tenga un documento aceptado por el SII o esté anulado, cruzando la colección de folios, los
+// documentos emitidos y el seguimiento de sus TrackID
+type Conciliador struct {
+	repo   RepositorioConciliacion
+	config ConfigConciliacion
+	reloj  func() time.Time
+}
+
+// NewConciliador crea el conciliador sobre el repositorio indicado
+func NewConciliador(repo RepositorioConciliacion, config ConfigConciliacion) *Conciliador {
+	return &Conciliador{repo: repo, config: config, reloj: time.Now}
+}
+
+// Conciliar concilia los folios de la empresa en el ambiente y guarda el reporte. Con reparar
+// registra en la colección de folios el uso de los folios faltantes, que el Anulador luego
+// solicita anular si siguen sin documento, y el de los documentos sin folio registrado; las
+// demás diferencias solo se informan.
+func (c *Conciliador) Conciliar(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, reparar bool) (*models.ReporteConciliacion, error) {
+	rutEmisor = utils.NormalizarRUT(rutEmisor)
+	ahora := c.reloj()
+
+	rangos, err := c.repo.Rangos(ctx, rutEmisor, ambiente)
+	if err != nil {
+		return nil, err
+	}
+	var bloques []*models.BloqueFolios
+	for _, estado := range []string{
+		models.EstadoBloqueLibre, models.EstadoBloqueArrendado, models.EstadoBloqueCerrado, models.EstadoBloqueExpirado,
+	} {
+		encontrados, err := c.repo.Bloques(ctx, rutEmisor, ambiente, estado)
+		if err != nil {
+			return nil, err
+		}
+		bloques = append(bloques, encontrados...)
+	}
+	solicitudes, err := c.repo.Solicitudes(ctx, rutEmisor, ambiente,
+		models.EstadoAnulacionPendiente, models.EstadoAnulacionPresentada, models.EstadoAnulacionAprobada)
+	if err != nil {
+		return nil, err
+	}
+
+	reporte := &models.ReporteConciliacion{
+		ID:        models.GenerateID(),
+		RutEmisor: rutEmisor,
+		Ambiente:  ambiente,
+		Fecha:     ahora,
+	}
+	var reparaciones []reparacion
+	for _, tipo := range tiposConciliacion(rangos) {
+		serie, err := c.cargarSerie(ctx, rutEmisor, ambiente, tipo, bloques, solicitudes, ahora)
+		if err != nil {
+			return nil, err
+		}
+		reparaciones = append(reparaciones, serie.conciliar(reporte, rangos)...)
+	}
+
+	if reparar && len(reparaciones) > 0 {
+		folios := make([]FolioRegistrado, len(reparaciones))
+		for i, r := range reparaciones {
+			folios[i] = r.folio
+		}
+		if err := c.repo.RegistrarFoliosConciliados(ctx, rutEmisor, ambiente, folios); err != nil {
+			return nil, err
+		}
+		for _, r := range reparaciones {
+			reporte.Hallazgos[r.hallazgo].Reparado = true
+		}
+		reporte.Reparados = len(reparaciones)
+	}
+
+	if err := c.repo.GuardarConciliacion(ctx, reporte); err != nil {
+		return nil, err
+	}
+	return reporte, nil
+}
+
+// Obtener retorna un reporte de conciliación por su ID
+func (c *Conciliador) Obtener(ctx context.Context, id string) (*models.ReporteConciliacion, error) {
+	return c.repo.ObtenerConciliacion(ctx, id)
+}
+
+// reparacion es el folio que se registra para corregir un hallazgo del reporte
+type reparacion struct {
+	hallazgo int
+	folio    FolioRegistrado
+}
+
+// destinoFolio es cómo terminó un folio según la conciliación
+type destinoFolio int
+
+const (
+	folioSinUsar destinoFolio = iota
+	folioAceptado
+	folioAnulado
+	folioEnAnulacion
+	folioPendiente
+	folioConHallazgos
+)
+
+// serieConciliacion reúne lo registrado de un tipo de documento de la empresa
+type serieConciliacion struct {
+	tipo         models.TipoDTE
+	ahora        time.Time
+	limite       time.Time // Los folios entregados después aún pueden no tener documento
+	registros    map[int64]FolioRegistrado
+	documentos   map[int64][]DocumentoFolio
+	seguimientos map[string]*models.SeguimientoEnvio
+	sinEntregar  map[int64]bool // Folios de bloques libres y los sin usar de bloques arrendados
+	recientes    map[int64]bool // Folios de bloques movidos dentro del plazo de gracia
+	solicitudes  map[int64]models.EstadoSolicitudAnulacion
+}
+
+// cargarSerie lee los folios, documentos y seguimientos de un tipo de documento
+func (c *Conciliador) cargarSerie(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE,
+	bloques []*models.BloqueFolios, solicitudes []*models.SolicitudAnulacion, ahora time.Time) (*serieConciliacion, error) {
+	s := &serieConciliacion{
+		tipo:         tipo,
+		ahora:        ahora,
+		limite:       ahora.Add(-c.config.Gracia),
+		registros:    make(map[int64]FolioRegistrado),
+		documentos:   make(map[int64][]DocumentoFolio),
+		seguimientos: make(map[string]*models.SeguimientoEnvio),
+		sinEntregar:  make(map[int64]bool),
+		recientes:    make(map[int64]bool),
+		solicitudes:  make(map[int64]models.EstadoSolicitudAnulacion),
+	}
+
+	registros, err := c.repo.FoliosSerie(ctx, rutEmisor, ambiente, tipo)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range registros {
+		s.registros[f.Folio] = f
+	}
+
+	documentos, err := c.repo.DocumentosEmitidos(ctx, rutEmisor, ambiente, tipo)
+	if err != nil {
+		return nil, err
+	}
+	// Solo se consulta el seguimiento de los documentos que aún no tienen estado final
+	var trackIDs []string
+	vistos := make(map[string]bool)
+	for _, doc := range documentos {
+		s.documentos[doc.Folio] = append(s.documentos[doc.Folio], doc)
+		if doc.TrackID != "" && !estadoFinal(doc.Estado) && doc.Estado != models.EstadoDTERechazado && !vistos[doc.TrackID] {
+			vistos[doc.TrackID] = true
+			trackIDs = append(trackIDs, doc.TrackID)
+		}
+	}
+	if len(trackIDs) > 0 {
+		seguimientos, err := c.repo.Seguimientos(ctx, ambiente, trackIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range seguimientos {
+			s.seguimientos[seg.TrackID] = seg
+		}
+	}
+
+	marcar := func(folios map[int64]bool, desde, hasta int64) {
+		for folio := desde; folio <= hasta; folio++ {
+			folios[folio] = true
+		}
+	}
+	for _, b := range bloques {
+		if b.TipoDTE != tipo {
+			continue
+		}
+		switch b.Estado {
+		case models.EstadoBloqueLibre:
+			marcar(s.sinEntregar, b.Desde, b.Hasta)
+			continue
+		case models.EstadoBloqueArrendado:
+			marcar(s.sinEntregar, b.UsadoHasta+1, b.Hasta)
+		}
+		if !b.UpdatedAt.Before(s.limite) {
+			marcar(s.recientes, b.Desde, b.Hasta)
+		}
+	}
+
+	for _, sol := range solicitudes {
+		for _, r := range sol.Rangos {
+			if r.TipoDTE != tipo {
+				continue
+			}
+			for folio := r.Desde; folio <= r.Hasta; folio++ {
+				s.solicitudes[folio] = sol.Estado
+			}
+		}
+	}
+	return s, nil
+}
+
+// conciliar agrega al reporte el resumen de cada CAF del tipo y los hallazgos de sus folios,
+// seguidos de los documentos con folios de ningún CAF. Retorna los folios por registrar para
+// reparar los hallazgos reparables.
+func (s *serieConciliacion) conciliar(reporte *models.ReporteConciliacion, todos []*models.RangoFolios) []reparacion {
+	var rangos []*models.RangoFolios
+	for _, r := range todos {
+		if r.TipoDTE == s.tipo {
+			rangos = append(rangos, r)
+		}
+	}
+	sort.Slice(rangos, func(i, j int) bool { return rangos[i].Desde < rangos[j].Desde })
+
+	var reparaciones []reparacion
+	for _, rango := range rangos {
+		resumen := models.ConciliacionCAF{
+			CAFID:            rango.CAFID,
+			TipoDTE:          rango.TipoDTE,
+			Desde:            rango.Desde,
+			Hasta:            rango.Hasta,
+			FechaVencimiento: rango.FechaVencimiento,
+		}
+		for folio := rango.Desde; folio <= rango.Hasta; folio++ {
+			destino, hallazgos, folioReparado := s.clasificar(rango, folio)
+			switch destino {
+			case folioSinUsar:
+				resumen.SinUsar++
+			case folioAceptado:
+				resumen.Aceptados++
+			case folioAnulado:
+				resumen.Anulados++
+			case folioEnAnulacion:
+				resumen.EnAnulacion++
+			case folioPendiente:
+				resumen.Pendientes++
+			case folioConHallazgos:
+				resumen.ConHallazgos++
+			}
+			for _, h := range hallazgos {
+				if h.Reparable {
+					reparaciones = append(reparaciones, reparacion{hallazgo: len(reporte.Hallazgos), folio: *folioReparado})
+				}
+				reporte.Hallazgos = append(reporte.Hallazgos, h)
+			}
+		}
+		resumen.Conciliado = resumen.ConHallazgos == 0 && resumen.Pendientes == 0 && resumen.EnAnulacion == 0 &&
+			(s.ahora.Before(rango.FechaVencimiento) || resumen.SinUsar == 0)
+		reporte.CAFs = append(reporte.CAFs, resumen)
+	}
+
+	folios := make([]int64, 0, len(s.documentos))
+	for folio := range s.documentos {
+		if rangoDeFolio(rangos, s.tipo, folio) == nil {
+			folios = append(folios, folio)
+		}
+	}
+	sort.Slice(folios, func(i, j int) bool { return folios[i] < folios[j] })
+	for _, folio := range folios {
+		reporte.Hallazgos = append(reporte.Hallazgos, nuevoHallazgo(models.HallazgoDocumentoFueraCAF, s.tipo, folio, "",
+			"el folio no pertenece a ningún CAF del ambiente", s.documentos[folio]))
+	}
+	return reparaciones
+}
+
+// clasificar determina cómo terminó un folio de un rango y sus diferencias. Si una es
+// reparable, retorna también el folio que debe registrarse para corregirla.
+func (s *serieConciliacion) clasificar(rango *models.RangoFolios, folio int64) (destinoFolio, []models.HallazgoFolio, *FolioRegistrado) {
+	documentos := s.documentos[folio]
+	registro, registrado := s.registros[folio]
+	solicitud := s.solicitudes[folio]
+	utilizado := registrado && registro.Estado == "UTILIZADO"
+	anulado := (registrado && registro.Estado == "ANULADO") || solicitud == models.EstadoAnulacionAprobada
+	enAnulacion := solicitud != "" || (registrado && registro.Motivo != "")
+	hallazgo := func(tipo models.TipoHallazgoFolio, detalle string, docs []DocumentoFolio) models.HallazgoFolio {
+		return nuevoHallazgo(tipo, s.tipo, folio, rango.CAFID, detalle, docs)
+	}
+
+	if len(documentos) == 0 {
+		switch {
+		case anulado:
+			return folioAnulado, nil, nil
+		case enAnulacion:
+			return folioEnAnulacion, nil, nil
+		case utilizado && !registro.FechaUso.Before(s.limite):
+			return folioPendiente, nil, nil
+		case utilizado:
+			return folioConHallazgos, []models.HallazgoFolio{hallazgo(models.HallazgoUtilizadoSinDocumento,
+				fmt.Sprintf("utilizado el %s sin documento emitido; debe anularse", registro.FechaUso.Format("02/01/2006")), nil)}, nil
+		case folio >= rango.Siguiente || s.sinEntregar[folio]:
+			return folioSinUsar, nil, nil
+		case s.recientes[folio]:
+			return folioPendiente, nil, nil
+		}
+		h := hallazgo(models.HallazgoFolioFaltante, "entregado sin registro de uso ni documento", nil)
+		h.Reparable = true
+		return folioConHallazgos, []models.HallazgoFolio{h}, &FolioRegistrado{
+			TipoDTE: s.tipo, Folio: folio, CAFID: rango.CAFID, Estado: "UTILIZADO", FechaUso: s.ahora,
+		}
+	}
+
+	var vigentes, rechazados, sinEstadoFinal []DocumentoFolio
+	aceptado, anuladoSinEnvio := false, false
+	for _, doc := range documentos {
+		estado, abandonado := estadoDocumento(doc, s.seguimientos)
+		switch {
+		case estado == models.EstadoDTERechazado:
+			rechazados = append(rechazados, doc)
+		case estado == models.EstadoDTEAnulado && doc.TrackID == "":
+			// Anulado antes de enviarse: el Anulador solicita la anulación del folio
+			anuladoSinEnvio = true
+		default:
+			vigentes = append(vigentes, doc)
+			aceptado = aceptado || estadoFinal(estado)
+			if abandonado {
+				sinEstadoFinal = append(sinEstadoFinal, doc)
+			}
+		}
+	}
+
+	var hallazgos []models.HallazgoFolio
+	var folioReparado *FolioRegistrado
+	if len(vigentes) > 1 {
+		hallazgos = append(hallazgos, hallazgo(models.HallazgoFolioDuplicado,
+			fmt.Sprintf("%d documentos no rechazados con el mismo folio", len(vigentes)), vigentes))
+	}
+	switch {
+	case anulado && len(vigentes) > 0:
+		hallazgos = append(hallazgos, hallazgo(models.HallazgoAnuladoConDocumento,
+			"el folio se anuló ante el SII y se usó en un documento", vigentes))
+	case !anulado && !utilizado && len(vigentes) > 0 && !s.recientes[folio]:
+		h := hallazgo(models.HallazgoFolioSinRegistro, "documento emitido sin el uso del folio registrado", vigentes)
+		if len(vigentes) == 1 {
+			h.Reparable = true
+			folioReparado = &FolioRegistrado{
+				TipoDTE: s.tipo, Folio: folio, CAFID: rango.CAFID, Estado: "UTILIZADO",
+				FechaUso: vigentes[0].FechaEmision, DocumentoID: vigentes[0].ID,
+			}
+			if folioReparado.FechaUso.IsZero() {
+				folioReparado.FechaUso = s.ahora
+			}
+		}
+		hallazgos = append(hallazgos, h)
+	}
+	for _, doc := range sinEstadoFinal {
+		hallazgos = append(hallazgos, hallazgo(models.HallazgoDocumentoNoAceptado,
+			fmt.Sprintf("el seguimiento del TrackID %s terminó sin estado final", doc.TrackID), []DocumentoFolio{doc}))
+	}
+	if len(vigentes) == 0 && !anulado && !enAnulacion && !anuladoSinEnvio {
+		hallazgos = append(hallazgos, hallazgo(models.HallazgoDocumentoNoAceptado,
+			"documento rechazado por el SII; el folio debe reutilizarse o anularse", rechazados))
+	}
+
+	switch {
+	case len(hallazgos) > 0:
+		return folioConHallazgos, hallazgos, folioReparado
+	case anulado:
+		return folioAnulado, nil, nil
+	case aceptado:
+		return folioAceptado, nil, nil
+	case len(vigentes) == 0:
+		return folioEnAnulacion, nil, nil
+	}
+	return folioPendiente, nil, nil
+}
+
+// nuevoHallazgo crea un hallazgo con los documentos del folio
+func nuevoHallazgo(tipo models.TipoHallazgoFolio, tipoDTE models.TipoDTE, folio int64, cafID, detalle string, documentos []DocumentoFolio) models.HallazgoFolio {
+	h := models.HallazgoFolio{Tipo: tipo, TipoDTE: tipoDTE, Folio: folio, CAFID: cafID, Detalle: detalle}
+	for _, doc := range documentos {
+		h.Documentos = append(h.Documentos, doc.ID)
+	}
+	if len(documentos) == 1 {
+		h.TrackID = documentos[0].TrackID
+	}
+	return h
+}
+
+// estadoDocumento retorna el estado del documento ante el SII. Si el documento aún no tiene
+// el resultado de su TrackID, usa el informado en el seguimiento; abandonado indica que el
+// seguimiento terminó sin que el SII informara un estado final.
+func estadoDocumento(doc DocumentoFolio, seguimientos map[string]*models.SeguimientoEnvio) (estado models.EstadoDTE, abandonado bool) {
+	if estadoFinal(doc.Estado) || doc.Estado == models.EstadoDTERechazado || doc.TrackID == "" {
+		return doc.Estado, false
+	}
+	seg, ok := seguimientos[doc.TrackID]
+	if !ok {
+		return doc.Estado, false
+	}
+	for _, d := range seg.Documentos {
+		mismo := (doc.ID != "" && d.ID == doc.ID) || (d.TipoDTE == doc.TipoDTE && d.Folio == doc.Folio)
+		if mismo && (estadoFinal(d.Estado) || d.Estado == models.EstadoDTERechazado) {
+			return d.Estado, false
+		}
+	}
+	return doc.Estado, seg.Estado == models.EstadoSeguimientoAbandonado
+}
+
+// estadoFinal indica si el documento quedó usado ante el SII: aceptado, con o sin reparos, o
+// anulado después de enviarse
+func estadoFinal(estado models.EstadoDTE) bool {
+	switch estado {
+	case models.EstadoDTEAceptado, models.EstadoDTEReparos, models.EstadoDTEAnulado:
+		return true
+	}
+	return false
+}
+
+// tiposConciliacion retorna los tipos que tienen CAF o que el SII autoriza con CAF, para
+// encontrar documentos de tipos sin ningún CAF importado
+func tiposConciliacion(rangos []*models.RangoFolios) []models.TipoDTE {
+	vistos := make(map[models.TipoDTE]bool, len(tiposConCAF))
+	for tipo := range tiposConCAF {
+		vistos[tipo] = true
+	}
+	for _, r := range rangos {
+		vistos[r.TipoDTE] = true
+	}
+	tipos := make([]models.TipoDTE, 0, len(vistos))
+	for tipo := range vistos {
+		tipos = append(tipos, tipo)
+	}
+	sort.Slice(tipos, func(i, j int) bool { return tipos[i] < tipos[j] })
+	return tipos
+}
+
+// ExportarConciliacion exporta el reporte en los formatos de FolioService.ExportarReporteFolios:
+// PDF, CSV o JSON
+func ExportarConciliacion(reporte *models.ReporteConciliacion, formato string) ([]byte, error) {
+	switch formato {
+	case "PDF":
+		return exportarConciliacionPDF(reporte)
+	case "CSV":
+		return exportarConciliacionCSV(reporte)
+	case "JSON":
+		return json.MarshalIndent(reporte, "", "  ")
+	default:
+		return nil, fmt.Errorf("formato no soportado: %s", formato)
+	}
+}
+
+func exportarConciliacionPDF(reporte *models.ReporteConciliacion) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, "Conciliación de Folios")
+	pdf.Ln(20)
+
+	pdf.SetFont("Arial", "", 12)
+	pdf.Cell(40, 10, fmt.Sprintf("RUT Emisor: %s", reporte.RutEmisor))
+	pdf.Ln(10)
+	pdf.Cell(40, 10, fmt.Sprintf("Ambiente: %s", reporte.Ambiente))
+	pdf.Ln(10)
+	pdf.Cell(40, 10, fmt.Sprintf("Fecha: %s", reporte.Fecha.Format("02/01/2006 15:04")))
+	pdf.Ln(10)
+	pdf.Cell(40, 10, fmt.Sprintf("Hallazgos: %d (reparados: %d)", len(reporte.Hallazgos), reporte.Reparados))
+	pdf.Ln(20)
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(40, 10, "CAF")
+	pdf.Ln(10)
+	pdf.SetFont("Arial", "", 9)
+	headers := []string{"CAF ID", "Tipo", "Desde", "Hasta", "Aceptados", "Anulados", "Pendientes", "Hallazgos", "Conciliado"}
+	widths := []float64{40, 12, 18, 18, 20, 20, 20, 20, 22}
+	for i, header := range headers {
+		pdf.Cell(widths[i], 8, header)
+	}
+	pdf.Ln(8)
+	for _, caf := range reporte.CAFs {
+		valores := []string{
+			caf.CAFID,
+			strconv.Itoa(int(caf.TipoDTE)),
+			strconv.FormatInt(caf.Desde, 10),
+			strconv.FormatInt(caf.Hasta, 10),
+			strconv.FormatInt(caf.Aceptados, 10),
+			strconv.FormatInt(caf.Anulados, 10),
+			strconv.FormatInt(caf.Pendientes+caf.EnAnulacion, 10),
+			strconv.FormatInt(caf.ConHallazgos, 10),
+			siNo(caf.Conciliado),
+		}
+		for i, valor := range valores {
+			pdf.Cell(widths[i], 8, valor)
+		}
+		pdf.Ln(8)
+	}
+
+	pdf.Ln(10)
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(40, 10, "Hallazgos")
+	pdf.Ln(10)
+	pdf.SetFont("Arial", "", 9)
+	headers = []string{"Tipo", "Tipo DTE", "Folio", "CAF ID", "Detalle", "Reparado"}
+	widths = []float64{45, 15, 18, 35, 62, 15}
+	for i, header := range headers {
+		pdf.Cell(widths[i], 8, header)
+	}
+	pdf.Ln(8)
+	for _, h := range reporte.Hallazgos {
+		valores := []string{
+			string(h.Tipo),
+			strconv.Itoa(int(h.TipoDTE)),
+			strconv.FormatInt(h.Folio, 10),
+			h.CAFID,
+			h.Detalle,
+			siNo(h.Reparado),
+		}
+		for i, valor := range valores {
+			pdf.Cell(widths[i], 8, valor)
+		}
+		pdf.Ln(8)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error generando PDF: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func exportarConciliacionCSV(reporte *models.ReporteConciliacion) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	conciliados := 0
+	for _, caf := range reporte.CAFs {
+		if caf.Conciliado {
+			conciliados++
+		}
+	}
+	writer.Write([]string{"RUT Emisor", "Ambiente", "Fecha", "CAF", "CAF Conciliados", "Hallazgos", "Reparados"})
+	writer.Write([]string{
+		reporte.RutEmisor,
+		string(reporte.Ambiente),
+		reporte.Fecha.Format("02/01/2006 15:04"),
+		strconv.Itoa(len(reporte.CAFs)),
+		strconv.Itoa(conciliados),
+		strconv.Itoa(len(reporte.Hallazgos)),
+		strconv.Itoa(reporte.Reparados),
+	})
+
+	writer.Write(nil)
+	writer.Write([]string{"CAF ID", "Tipo DTE", "Desde", "Hasta", "Vencimiento", "Aceptados", "Anulados",
+		"En Anulación", "Pendientes", "Con Hallazgos", "Sin Usar", "Conciliado"})
+	for _, caf := range reporte.CAFs {
+		writer.Write([]string{
+			caf.CAFID,
+			strconv.Itoa(int(caf.TipoDTE)),
+			strconv.FormatInt(caf.Desde, 10),
+			strconv.FormatInt(caf.Hasta, 10),
+			caf.FechaVencimiento.Format("02/01/2006"),
+			strconv.FormatInt(caf.Aceptados, 10),
+			strconv.FormatInt(caf.Anulados, 10),
+			strconv.FormatInt(caf.EnAnulacion, 10),
+			strconv.FormatInt(caf.Pendientes, 10),
+			strconv.FormatInt(caf.ConHallazgos, 10),
+			strconv.FormatInt(caf.SinUsar, 10),
+			siNo(caf.Conciliado),
+		})
+	}
+
+	writer.Write(nil)
+	writer.Write([]string{"Tipo", "Tipo DTE", "Folio", "CAF ID", "Documentos", "Track ID", "Detalle", "Reparable", "Reparado"})
+	for _, h := range reporte.Hallazgos {
+		writer.Write([]string{
+			string(h.Tipo),
+			strconv.Itoa(int(h.TipoDTE)),
+			strconv.FormatInt(h.Folio, 10),
+			h.CAFID,
+			strings.Join(h.Documentos, " "),
+			h.TrackID,
+			h.Detalle,
+			siNo(h.Reparable),
+			siNo(h.Reparado),
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("error al exportar conciliación de folios: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func siNo(valor bool) string {
+	if valor {
+		return "Sí"
+	}
+	return "No"
+}
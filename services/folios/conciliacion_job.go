@@ -0,0 +1,88 @@
+package folios
+
+import (
+	"context"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.uber.org/zap"
+)
+
+// EmpresaConciliacion identifica una empresa cuyos folios se concilian
+type EmpresaConciliacion struct {
+	RutEmisor string
+	Ambiente  models.AmbienteSII
+}
+
+// JobConciliacion concilia una vez al día los folios de las empresas
+type JobConciliacion struct {
+	conciliador *Conciliador
+	empresas    []EmpresaConciliacion
+	hora        int
+	reparar     bool
+}
+
+// NewJobConciliacion crea el job diario. hora indica la hora local (0-23) de ejecución y
+// reparar si se corrigen los hallazgos reparables.
+func NewJobConciliacion(conciliador *Conciliador, empresas []EmpresaConciliacion, hora int, reparar bool) *JobConciliacion {
+	return &JobConciliacion{
+		conciliador: conciliador,
+		empresas:    empresas,
+		hora:        hora,
+		reparar:     reparar,
+	}
+}
+
+// Iniciar bloquea ejecutando el job una vez al día hasta que se cancele el contexto
+func (j *JobConciliacion) Iniciar(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(j.proximaEjecucion(time.Now())))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			j.Ejecutar(ctx)
+		}
+	}
+}
+
+// Ejecutar concilia los folios de todas las empresas. El error de una empresa se registra y
+// no detiene a las demás.
+func (j *JobConciliacion) Ejecutar(ctx context.Context) {
+	for _, empresa := range j.empresas {
+		reporte, err := j.conciliador.Conciliar(ctx, empresa.RutEmisor, empresa.Ambiente, j.reparar)
+		if err != nil {
+			utils.LogError(err,
+				zap.String("proceso", "conciliacion_folios"),
+				zap.String("rut_emisor", empresa.RutEmisor),
+				zap.String("ambiente", string(empresa.Ambiente)),
+			)
+			continue
+		}
+
+		campos := []zap.Field{
+			zap.String("rut_emisor", reporte.RutEmisor),
+			zap.String("ambiente", string(reporte.Ambiente)),
+			zap.String("conciliacion", reporte.ID),
+			zap.Int("hallazgos", len(reporte.Hallazgos)),
+			zap.Int("reparados", reporte.Reparados),
+		}
+		if len(reporte.Hallazgos) > reporte.Reparados {
+			utils.LogWarning("conciliación de folios con hallazgos", campos...)
+			continue
+		}
+		utils.LogInfo("conciliación de folios completada", campos...)
+	}
+}
+
+// proximaEjecucion calcula el próximo instante de ejecución a partir de ahora
+func (j *JobConciliacion) proximaEjecucion(ahora time.Time) time.Time {
+	proxima := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), j.hora, 0, 0, 0, ahora.Location())
+	if !proxima.After(ahora) {
+		proxima = proxima.AddDate(0, 0, 1)
+	}
+	return proxima
+}
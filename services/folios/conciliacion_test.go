@@ -0,0 +1,312 @@
+package folios
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *repositorioAnulacionesMemoria) FoliosSerie(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]FolioRegistrado, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var folios []FolioRegistrado
+	for _, f := range r.folios {
+		if f.TipoDTE == tipo {
+			folios = append(folios, f.FolioRegistrado)
+		}
+	}
+	return folios, nil
+}
+
+func (r *repositorioAnulacionesMemoria) DocumentosEmitidos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]DocumentoFolio, error) {
+	var documentos []DocumentoFolio
+	for _, doc := range r.documentos {
+		if doc.TipoDTE == tipo && doc.Folio > 0 && doc.Estado != models.EstadoDTEBorrador {
+			documentos = append(documentos, doc)
+		}
+	}
+	return documentos, nil
+}
+
+func (r *repositorioAnulacionesMemoria) Seguimientos(ctx context.Context, ambiente models.AmbienteSII, trackIDs []string) ([]*models.SeguimientoEnvio, error) {
+	var seguimientos []*models.SeguimientoEnvio
+	for _, s := range r.seguimientos {
+		for _, trackID := range trackIDs {
+			if s.TrackID == trackID {
+				seguimientos = append(seguimientos, s)
+			}
+		}
+	}
+	return seguimientos, nil
+}
+
+func (r *repositorioAnulacionesMemoria) RegistrarFoliosConciliados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, folios []FolioRegistrado) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, nuevo := range folios {
+		registrado := false
+		for _, f := range r.folios {
+			registrado = registrado || (f.TipoDTE == nuevo.TipoDTE && f.Folio == nuevo.Folio)
+		}
+		if !registrado {
+			r.folios = append(r.folios, &folioMemoria{FolioRegistrado: nuevo})
+		}
+	}
+	return nil
+}
+
+func (r *repositorioAnulacionesMemoria) GuardarConciliacion(ctx context.Context, reporte *models.ReporteConciliacion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copia := *reporte
+	r.conciliaciones[reporte.ID] = &copia
+	return nil
+}
+
+func (r *repositorioAnulacionesMemoria) ObtenerConciliacion(ctx context.Context, id string) (*models.ReporteConciliacion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reporte, ok := r.conciliaciones[id]
+	if !ok {
+		return nil, fmt.Errorf("no existe conciliación de folios %s", id)
+	}
+	copia := *reporte
+	return &copia, nil
+}
+
+// escenarioConciliacion arma un CAF vigente con un folio de cada caso y uno vencido cuyos folios
+// sin usar están en una solicitud de anulación
+func escenarioConciliacion(ahora time.Time) *repositorioAnulacionesMemoria {
+	repo := newRepositorioAnulacionesMemoria()
+	antiguo := ahora.Add(-72 * time.Hour)
+
+	vigente := rangoPrueba(models.TipoBoleta, 1, 30)
+	vigente.CAFID = "caf-vigente"
+	vigente.Siguiente = 21
+	vencido := rangoPrueba(models.TipoBoleta, 101, 110)
+	vencido.CAFID = "caf-vencido"
+	vencido.Siguiente = 106
+	vencido.FechaVencimiento = ahora.AddDate(0, 0, -1)
+	repo.rangos = []*models.RangoFolios{vigente, vencido}
+
+	repo.bloques = []*models.BloqueFolios{
+		{ID: "b-cerrado", RangoID: vigente.ID, CAFID: "caf-vigente", TipoDTE: models.TipoBoleta,
+			Desde: 1, Hasta: 10, UsadoHasta: 10, Estado: models.EstadoBloqueCerrado, UpdatedAt: antiguo},
+		{ID: "b-arrendado", RangoID: vigente.ID, CAFID: "caf-vigente", TipoDTE: models.TipoBoleta,
+			Desde: 11, Hasta: 20, UsadoHasta: 15, Estado: models.EstadoBloqueArrendado, UpdatedAt: ahora},
+	}
+
+	registrar := func(folio int64, estado string, fecha time.Time) {
+		repo.folios = append(repo.folios, &folioMemoria{FolioRegistrado: FolioRegistrado{
+			TipoDTE: models.TipoBoleta, Folio: folio, CAFID: "caf-vigente", Estado: estado, FechaUso: fecha,
+		}})
+	}
+	emitir := func(tipo models.TipoDTE, folio int64, estado models.EstadoDTE, trackID string) {
+		repo.documentos = append(repo.documentos, DocumentoFolio{
+			ID: fmt.Sprintf("doc-%d-%d-%d", tipo, folio, len(repo.documentos)), TipoDTE: tipo, Folio: folio,
+			Estado: estado, TrackID: trackID, FechaEmision: antiguo,
+		})
+	}
+
+	registrar(1, "UTILIZADO", antiguo)
+	emitir(models.TipoBoleta, 1, models.EstadoDTEAceptado, "100")
+	registrar(2, "UTILIZADO", antiguo) // Sin documento
+	// 3: entregado sin registro ni documento
+	emitir(models.TipoBoleta, 4, models.EstadoDTEAceptado, "100") // Sin registro
+	registrar(5, "UTILIZADO", antiguo)
+	emitir(models.TipoBoleta, 5, models.EstadoDTEAceptado, "100")
+	emitir(models.TipoBoleta, 5, models.EstadoDTEEnviado, "101")
+	registrar(6, "UTILIZADO", antiguo)
+	emitir(models.TipoBoleta, 6, models.EstadoDTERechazado, "102")
+	registrar(7, "UTILIZADO", antiguo)
+	emitir(models.TipoBoleta, 7, models.EstadoDTEEnviado, "103") // Aceptado según el seguimiento
+	registrar(8, "UTILIZADO", antiguo)
+	emitir(models.TipoBoleta, 8, models.EstadoDTEEnviado, "104") // Seguimiento abandonado
+	registrar(9, "ANULADO", antiguo)
+	registrar(10, "ANULADO", antiguo)
+	emitir(models.TipoBoleta, 10, models.EstadoDTEAceptado, "100")
+	registrar(11, "UTILIZADO", ahora)
+
+	for folio := int64(101); folio <= 105; folio++ {
+		emitir(models.TipoBoleta, folio, models.EstadoDTEAceptado, "200")
+		repo.folios = append(repo.folios, &folioMemoria{FolioRegistrado: FolioRegistrado{
+			TipoDTE: models.TipoBoleta, Folio: folio, CAFID: "caf-vencido", Estado: "UTILIZADO", FechaUso: antiguo,
+		}})
+	}
+	repo.solicitudes["sol-vencido"] = &models.SolicitudAnulacion{
+		ID:     "sol-vencido",
+		Estado: models.EstadoAnulacionPresentada,
+		Rangos: []models.RangoAnulacion{
+			{CAFID: "caf-vencido", TipoDTE: models.TipoBoleta, Desde: 106, Hasta: 110, Motivo: models.MotivoCAFVencido},
+		},
+	}
+
+	emitir(models.TipoBoleta, 500, models.EstadoDTEAceptado, "100")
+	emitir(models.TipoFactura, 7, models.EstadoDTEEmitido, "")
+
+	repo.seguimientos = []*models.SeguimientoEnvio{
+		{TrackID: "103", Estado: models.EstadoSeguimientoFinalizado, Documentos: []models.DocumentoSeguimiento{
+			{ID: "otro", TipoDTE: models.TipoBoleta, Folio: 7, Estado: models.EstadoDTEAceptado},
+		}},
+		{TrackID: "104", Estado: models.EstadoSeguimientoAbandonado, Documentos: []models.DocumentoSeguimiento{
+			{TipoDTE: models.TipoBoleta, Folio: 8, Estado: models.EstadoDTEEnviado},
+		}},
+	}
+	return repo
+}
+
+func conciliadorPrueba(repo RepositorioConciliacion, ahora time.Time) *Conciliador {
+	c := NewConciliador(repo, ConfigConciliacionPorDefecto())
+	c.reloj = func() time.Time { return ahora }
+	return c
+}
+
+// tiposHallazgos resume los hallazgos como tipo:tipoDTE:folio
+func tiposHallazgos(reporte *models.ReporteConciliacion) []string {
+	var resumen []string
+	for _, h := range reporte.Hallazgos {
+		resumen = append(resumen, fmt.Sprintf("%s:%d:%d", h.Tipo, h.TipoDTE, h.Folio))
+	}
+	return resumen
+}
+
+func TestConciliarFolios(t *testing.T) {
+	repo := escenarioConciliacion(inicioPrueba)
+	reporte, err := conciliadorPrueba(repo, inicioPrueba).Conciliar(context.Background(), "76.212.889-6", models.AmbienteCertificacion, false)
+	require.NoError(t, err)
+	assert.Equal(t, rutEmpresa, reporte.RutEmisor)
+
+	assert.Equal(t, []string{
+		"DOCUMENTO_FUERA_CAF:33:7",
+		"UTILIZADO_SIN_DOCUMENTO:39:2",
+		"FOLIO_FALTANTE:39:3",
+		"FOLIO_SIN_REGISTRO:39:4",
+		"FOLIO_DUPLICADO:39:5",
+		"DOCUMENTO_NO_ACEPTADO:39:6",
+		"DOCUMENTO_NO_ACEPTADO:39:8",
+		"ANULADO_CON_DOCUMENTO:39:10",
+		"DOCUMENTO_FUERA_CAF:39:500",
+	}, tiposHallazgos(reporte))
+	assert.Equal(t, []string{"doc-39-5-2", "doc-39-5-3"}, reporte.Hallazgos[4].Documentos)
+	assert.Equal(t, "104", reporte.Hallazgos[6].TrackID)
+	for _, h := range reporte.Hallazgos {
+		reparable := h.Tipo == models.HallazgoFolioFaltante || h.Tipo == models.HallazgoFolioSinRegistro
+		assert.Equal(t, reparable, h.Reparable, "%s:%d", h.Tipo, h.Folio)
+		assert.False(t, h.Reparado)
+	}
+
+	require.Len(t, reporte.CAFs, 2)
+	assert.Equal(t, models.ConciliacionCAF{
+		CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 1, Hasta: 30,
+		FechaVencimiento: repo.rangos[0].FechaVencimiento,
+		Aceptados:        2, Anulados: 1, Pendientes: 5, ConHallazgos: 7, SinUsar: 15,
+	}, reporte.CAFs[0])
+	assert.Equal(t, models.ConciliacionCAF{
+		CAFID: "caf-vencido", TipoDTE: models.TipoBoleta, Desde: 101, Hasta: 110,
+		FechaVencimiento: repo.rangos[1].FechaVencimiento,
+		Aceptados:        5, EnAnulacion: 5,
+	}, reporte.CAFs[1])
+
+	guardado, err := repo.ObtenerConciliacion(context.Background(), reporte.ID)
+	require.NoError(t, err)
+	assert.Len(t, guardado.Hallazgos, 9)
+}
+
+func TestConciliarFoliosReparando(t *testing.T) {
+	ctx := context.Background()
+	repo := escenarioConciliacion(inicioPrueba)
+	c := conciliadorPrueba(repo, inicioPrueba)
+
+	reporte, err := c.Conciliar(ctx, rutEmpresa, models.AmbienteCertificacion, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reporte.Reparados)
+	assert.True(t, reporte.Hallazgos[2].Reparado)
+	assert.True(t, reporte.Hallazgos[3].Reparado)
+
+	registrados, err := repo.FoliosSerie(ctx, rutEmpresa, models.AmbienteCertificacion, models.TipoBoleta)
+	require.NoError(t, err)
+	porFolio := make(map[int64]FolioRegistrado)
+	for _, f := range registrados {
+		porFolio[f.Folio] = f
+	}
+	assert.Equal(t, FolioRegistrado{TipoDTE: models.TipoBoleta, Folio: 3, CAFID: "caf-vigente", Estado: "UTILIZADO", FechaUso: inicioPrueba}, porFolio[3])
+	assert.Equal(t, "doc-39-4-1", porFolio[4].DocumentoID)
+	assert.Equal(t, inicioPrueba.Add(-72*time.Hour), porFolio[4].FechaUso)
+
+	// El folio faltante queda a la espera de su documento y el del documento, aceptado
+	reporte, err = c.Conciliar(ctx, rutEmpresa, models.AmbienteCertificacion, true)
+	require.NoError(t, err)
+	assert.Zero(t, reporte.Reparados)
+	assert.Len(t, reporte.Hallazgos, 7)
+	assert.Equal(t, int64(3), reporte.CAFs[0].Aceptados)
+	assert.Equal(t, int64(6), reporte.CAFs[0].Pendientes)
+
+	// Vencido el plazo de gracia, el Anulador lo solicita como saltado
+	despues := inicioPrueba.Add(ConfigAnulacionPorDefecto().Gracia + time.Hour)
+	rangos, err := anuladorPrueba(repo, despues).Recolectar(ctx, rutEmpresa, models.AmbienteCertificacion)
+	require.NoError(t, err)
+	assert.Contains(t, rangos, models.RangoAnulacion{
+		CAFID: "caf-vigente", TipoDTE: models.TipoBoleta, Desde: 2, Hasta: 3, Motivo: models.MotivoFolioSaltado,
+	})
+}
+
+func TestConciliacionCAFConciliado(t *testing.T) {
+	repo := newRepositorioAnulacionesMemoria()
+	rango := rangoPrueba(models.TipoFactura, 1, 4)
+	rango.Siguiente = 5
+	rango.FechaVencimiento = inicioPrueba.AddDate(0, 0, -1)
+	repo.rangos = []*models.RangoFolios{rango}
+	for folio := int64(1); folio <= 3; folio++ {
+		repo.folios = append(repo.folios, &folioMemoria{FolioRegistrado: FolioRegistrado{
+			TipoDTE: models.TipoFactura, Folio: folio, Estado: "UTILIZADO", FechaUso: inicioPrueba.AddDate(0, -1, 0),
+		}})
+		repo.documentos = append(repo.documentos, DocumentoFolio{TipoDTE: models.TipoFactura, Folio: folio, Estado: models.EstadoDTEReparos, TrackID: "1"})
+	}
+	repo.folios = append(repo.folios, &folioMemoria{FolioRegistrado: FolioRegistrado{TipoDTE: models.TipoFactura, Folio: 4, Estado: "ANULADO"}})
+
+	reporte, err := conciliadorPrueba(repo, inicioPrueba).Conciliar(context.Background(), rutEmpresa, models.AmbienteCertificacion, false)
+	require.NoError(t, err)
+	assert.Empty(t, reporte.Hallazgos)
+	require.Len(t, reporte.CAFs, 1)
+	assert.True(t, reporte.CAFs[0].Conciliado)
+	assert.Equal(t, int64(3), reporte.CAFs[0].Aceptados)
+	assert.Equal(t, int64(1), reporte.CAFs[0].Anulados)
+}
+
+func TestExportarConciliacion(t *testing.T) {
+	repo := escenarioConciliacion(inicioPrueba)
+	reporte, err := conciliadorPrueba(repo, inicioPrueba).Conciliar(context.Background(), rutEmpresa, models.AmbienteCertificacion, false)
+	require.NoError(t, err)
+
+	datos, err := ExportarConciliacion(reporte, "CSV")
+	require.NoError(t, err)
+	lector := csv.NewReader(strings.NewReader(string(datos)))
+	lector.FieldsPerRecord = -1
+	filas, err := lector.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, filas, 2+1+2+1+9)
+	assert.Equal(t, []string{rutEmpresa, string(models.AmbienteCertificacion), "17/10/2026 12:00", "2", "0", "9", "0"}, filas[1])
+	assert.Equal(t, "caf-vigente", filas[3][0])
+	assert.Equal(t, []string{"FOLIO_FALTANTE", "39", "3", "caf-vigente", "", "", "entregado sin registro de uso ni documento", "Sí", "No"}, filas[8])
+
+	datos, err = ExportarConciliacion(reporte, "JSON")
+	require.NoError(t, err)
+	var leido models.ReporteConciliacion
+	require.NoError(t, json.Unmarshal(datos, &leido))
+	assert.Equal(t, reporte.Hallazgos, leido.Hallazgos)
+
+	datos, err = ExportarConciliacion(reporte, "PDF")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(datos), "%PDF"))
+
+	_, err = ExportarConciliacion(reporte, "XLS")
+	assert.Error(t, err)
+}
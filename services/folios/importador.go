@@ -0,0 +1,234 @@
+package folios
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// Errores de la importación de CAF
+var (
+	ErrCAFInvalido   = errors.New("CAF inválido")
+	ErrFirmaSII      = errors.New("la firma FRMA del CAF no corresponde al SII")
+	ErrEmpresaCAF    = errors.New("el CAF no corresponde a la empresa")
+	ErrCAFVencido    = errors.New("CAF vencido")
+	ErrSuperposicion = errors.New("el rango de folios se superpone con un CAF registrado")
+	ErrCAFDuplicado  = errors.New("el CAF ya fue importado")
+)
+
+// MesesVigenciaCAF es el plazo desde la fecha de autorización en que se pueden usar los folios
+const MesesVigenciaCAF = 6
+
+// tiposConCAF son los tipos de documento para los que el SII autoriza folios
+var tiposConCAF = map[models.TipoDTE]bool{
+	models.TipoFactura:                true,
+	models.TipoFacturaExenta:          true,
+	models.TipoBoleta:                 true,
+	models.TipoBoletaExenta:           true,
+	models.TipoFacturaCompra:          true,
+	models.TipoGuiaDespacho:           true,
+	models.TipoNotaDebito:             true,
+	models.TipoNotaCredito:            true,
+	models.TipoFacturaExportacion:     true,
+	models.TipoNotaDebitoExportacion:  true,
+	models.TipoNotaCreditoExportacion: true,
+}
+
+// Repositorio persiste los CAF importados y sus rangos
+type Repositorio interface {
+	// RegistrarCAF guarda el CAF y su rango en una sola transacción. Falla con ErrCAFDuplicado
+	// si el CAF ya existe y con ErrSuperposicion si el rango choca con otro del mismo emisor,
+	// ambiente y tipo.
+	RegistrarCAF(ctx context.Context, caf *models.CAFImportado, rango *models.RangoFolios) error
+	// ObtenerCAF retorna un CAF importado por su ID
+	ObtenerCAF(ctx context.Context, id string) (*models.CAFImportado, error)
+}
+
+// Empresas obtiene las empresas registradas (services.EmpresaService)
+type Empresas interface {
+	ObtenerEmpresa(id string) (*models.Empresa, error)
+}
+
+// SolicitudImportacion es un archivo CAF subido para una empresa
+type SolicitudImportacion struct {
+	EmpresaID string         `json:"empresa_id"`
+	TipoDTE   models.TipoDTE `json:"tipo_dte,omitempty"` // Si se indica, debe coincidir con el CAF
+	Archivo   []byte         `json:"-"`
+}
+
+// Importador valida los CAF subidos y registra sus folios
+type Importador struct {
+	repo     Repositorio
+	empresas Empresas
+	llaves   *LlavesSII
+	cifrador Cifrador
+	ahora    func() time.Time
+}
+
+// NewImportador crea el importador con las llaves del SII y el cifrador de RSASK
+func NewImportador(repo Repositorio, empresas Empresas, llaves *LlavesSII, cifrador Cifrador) *Importador {
+	return &Importador{
+		repo:     repo,
+		empresas: empresas,
+		llaves:   llaves,
+		cifrador: cifrador,
+		ahora:    time.Now,
+	}
+}
+
+// Importar verifica el CAF contra la llave del SII de su IDK en el ambiente de la empresa,
+// comprueba emisor, tipo, vigencia y que RSASK corresponda a RSAPK, cifra RSASK y registra el
+// CAF y su rango de folios en una sola transacción
+func (i *Importador) Importar(ctx context.Context, solicitud SolicitudImportacion) (*models.CAFImportado, error) {
+	autorizacion, err := caf.ParseCAF(solicitud.Archivo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCAFInvalido, err)
+	}
+	elemento, err := autorizacion.Nodo()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCAFInvalido, err)
+	}
+	nodo, err := autorizacion.NodoXML()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCAFInvalido, err)
+	}
+
+	empresa, err := i.empresas.ObtenerEmpresa(solicitud.EmpresaID)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener empresa: %v", err)
+	}
+	if err := i.validarEmpresa(autorizacion, empresa, solicitud.TipoDTE); err != nil {
+		return nil, err
+	}
+
+	llaveSII, err := i.llaves.Llave(empresa.Ambiente, autorizacion.IDK)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFirmaSII, err)
+	}
+	if err := autorizacion.VerificarFirma(llaveSII); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFirmaSII, err)
+	}
+	if err := validarLlaves(autorizacion); err != nil {
+		return nil, err
+	}
+
+	autorizado, err := time.Parse("2006-01-02", autorizacion.FechaResol)
+	if err != nil {
+		return nil, fmt.Errorf("%w: fecha de autorización %q", ErrCAFInvalido, autorizacion.FechaResol)
+	}
+	ahora := i.ahora()
+	vencimiento := autorizado.AddDate(0, MesesVigenciaCAF, 0)
+	if !ahora.Before(vencimiento) {
+		return nil, fmt.Errorf("%w: autorizado el %s", ErrCAFVencido, autorizacion.FechaResol)
+	}
+
+	hash := sha256.Sum256(xmldsig.Canonicalize(elemento))
+	registro := &models.CAFImportado{
+		ID:                models.GenerateID(),
+		EmpresaID:         empresa.ID,
+		RutEmisor:         utils.NormalizarRUT(autorizacion.RUTEmisor),
+		Ambiente:          empresa.Ambiente,
+		TipoDTE:           models.TipoDTE(autorizacion.TipoDTE),
+		FolioDesde:        int64(autorizacion.FolioInicio),
+		FolioHasta:        int64(autorizacion.FolioFinal),
+		FechaAutorizacion: autorizado,
+		FechaVencimiento:  vencimiento,
+		IDK:               autorizacion.IDK,
+		Hash:              hex.EncodeToString(hash[:]),
+		NodoCAF:           string(nodo),
+		RSAPUBK:           autorizacion.RSAPUBK,
+		LlaveCifrado:      i.cifrador.LlaveID(),
+		CreatedAt:         ahora,
+	}
+	if registro.RSASKCifrada, err = i.cifrador.Cifrar([]byte(autorizacion.RSASK), contextoCifrado(registro)); err != nil {
+		return nil, fmt.Errorf("error al cifrar RSASK: %v", err)
+	}
+
+	rango := &models.RangoFolios{
+		ID:               registro.ID,
+		CAFID:            registro.ID,
+		EmpresaID:        registro.EmpresaID,
+		RutEmisor:        registro.RutEmisor,
+		Ambiente:         registro.Ambiente,
+		TipoDTE:          registro.TipoDTE,
+		Desde:            registro.FolioDesde,
+		Hasta:            registro.FolioHasta,
+		Siguiente:        registro.FolioDesde,
+		Estado:           models.EstadoRangoDisponible,
+		FechaVencimiento: vencimiento,
+		CreatedAt:        ahora,
+		UpdatedAt:        ahora,
+	}
+	if err := i.repo.RegistrarCAF(ctx, registro, rango); err != nil {
+		return nil, err
+	}
+	return registro, nil
+}
+
+// CargarCAF reconstruye un CAF importado con su llave RSASK descifrada, para timbrar
+func (i *Importador) CargarCAF(ctx context.Context, id string) (*caf.CAFXml, error) {
+	registro, err := i.repo.ObtenerCAF(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if registro.LlaveCifrado != i.cifrador.LlaveID() {
+		return nil, fmt.Errorf("el CAF %s está cifrado con la llave %q y no con %q", id, registro.LlaveCifrado, i.cifrador.LlaveID())
+	}
+	rsask, err := i.cifrador.Descifrar(registro.RSASKCifrada, contextoCifrado(registro))
+	if err != nil {
+		return nil, fmt.Errorf("error al descifrar RSASK del CAF %s: %v", id, err)
+	}
+	return caf.ParseAutorizacion([]byte(registro.NodoCAF), string(rsask), registro.RSAPUBK)
+}
+
+// validarEmpresa comprueba que el CAF sea del RUT de la empresa y de un tipo con folios
+func (i *Importador) validarEmpresa(autorizacion *caf.CAFXml, empresa *models.Empresa, tipo models.TipoDTE) error {
+	if empresa == nil {
+		return fmt.Errorf("%w: empresa inexistente", ErrEmpresaCAF)
+	}
+	if !empresa.Ambiente.Valido() {
+		return fmt.Errorf("%w: la empresa %s no tiene ambiente SII", ErrEmpresaCAF, empresa.RUT)
+	}
+	if !utils.MismoRUT(autorizacion.RUTEmisor, empresa.RUT) {
+		return fmt.Errorf("%w: CAF emitido para %s y la empresa es %s", ErrEmpresaCAF, autorizacion.RUTEmisor, empresa.RUT)
+	}
+	if !tiposConCAF[models.TipoDTE(autorizacion.TipoDTE)] {
+		return fmt.Errorf("%w: tipo de documento %d sin folios autorizables", ErrCAFInvalido, autorizacion.TipoDTE)
+	}
+	if tipo != 0 && tipo != models.TipoDTE(autorizacion.TipoDTE) {
+		return fmt.Errorf("%w: se esperaba un CAF del tipo %d y es del tipo %d", ErrEmpresaCAF, tipo, autorizacion.TipoDTE)
+	}
+	if autorizacion.FolioInicio <= 0 || autorizacion.FolioFinal < autorizacion.FolioInicio {
+		return fmt.Errorf("%w: rango %d-%d", ErrCAFInvalido, autorizacion.FolioInicio, autorizacion.FolioFinal)
+	}
+	return nil
+}
+
+// validarLlaves comprueba que la llave RSASK corresponda a la llave RSAPK autorizada
+func validarLlaves(autorizacion *caf.CAFXml) error {
+	privada, err := autorizacion.LlavePrivada()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCAFInvalido, err)
+	}
+	publica, err := autorizacion.LlavePublica()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCAFInvalido, err)
+	}
+	if !privada.PublicKey.Equal(publica) {
+		return fmt.Errorf("%w: la llave RSASK no corresponde a la llave RSAPK", ErrCAFInvalido)
+	}
+	return nil
+}
+
+// contextoCifrado liga la llave cifrada al CAF al que pertenece
+func contextoCifrado(registro *models.CAFImportado) []byte {
+	return []byte(registro.ID + "|" + registro.Hash)
+}
@@ -0,0 +1,250 @@
+package folios
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rutEmpresa = "76212889-6"
+
+// repositorioMemoria implementa Repositorio con las mismas reglas que RepositorioMongo
+type repositorioMemoria struct {
+	mu     sync.Mutex
+	cafs   map[string]*models.CAFImportado
+	rangos []*models.RangoFolios
+}
+
+func newRepositorioMemoria() *repositorioMemoria {
+	return &repositorioMemoria{cafs: make(map[string]*models.CAFImportado)}
+}
+
+func (r *repositorioMemoria) RegistrarCAF(ctx context.Context, caf *models.CAFImportado, rango *models.RangoFolios) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existente := range r.cafs {
+		if existente.Hash == caf.Hash {
+			return ErrCAFDuplicado
+		}
+	}
+	for _, existente := range r.rangos {
+		if existente.RutEmisor == rango.RutEmisor && existente.Ambiente == rango.Ambiente &&
+			existente.TipoDTE == rango.TipoDTE && existente.Superpone(rango.Desde, rango.Hasta) {
+			return ErrSuperposicion
+		}
+	}
+	r.cafs[caf.ID] = caf
+	r.rangos = append(r.rangos, rango)
+	return nil
+}
+
+func (r *repositorioMemoria) ObtenerCAF(ctx context.Context, id string) (*models.CAFImportado, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	caf, ok := r.cafs[id]
+	if !ok {
+		return nil, fmt.Errorf("no existe CAF %s", id)
+	}
+	return caf, nil
+}
+
+// empresasPrueba entrega empresas por ID
+type empresasPrueba map[string]*models.Empresa
+
+func (e empresasPrueba) ObtenerEmpresa(id string) (*models.Empresa, error) {
+	empresa, ok := e[id]
+	if !ok {
+		return nil, fmt.Errorf("empresa %s no encontrada", id)
+	}
+	return empresa, nil
+}
+
+var espacios = regexp.MustCompile(`>\s+<`)
+
+// cafFirmado genera un CAF con una llave de folios nueva y el FRMA firmado con llaveSII
+func cafFirmado(t *testing.T, llaveSII *rsa.PrivateKey, rut string, tipo, desde, hasta int, fecha string, idk int) []byte {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	publica, err := x509.MarshalPKIXPublicKey(&llave.PublicKey)
+	require.NoError(t, err)
+
+	da := fmt.Sprintf(`<DA>
+<RE>%s</RE>
+<RS>COMERCIAL ÑANDÚ LIMITADA</RS>
+<TD>%d</TD>
+<RNG><D>%d</D><H>%d</H></RNG>
+<FA>%s</FA>
+<RSAPK><M>%s</M><E>%s</E></RSAPK>
+<IDK>%d</IDK>
+</DA>`, rut, tipo, desde, hasta, fecha,
+		base64.StdEncoding.EncodeToString(llave.N.Bytes()),
+		base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()), idk)
+
+	doc, err := xmldsig.ParseDocument([]byte(da))
+	require.NoError(t, err)
+	digest := sha1.Sum(espacios.ReplaceAll(xmldsig.Canonicalize(doc.Root()), []byte("><")))
+	firma, err := rsa.SignPKCS1v15(rand.Reader, llaveSII, crypto.SHA1, digest[:])
+	require.NoError(t, err)
+
+	return []byte(fmt.Sprintf(`<?xml version="1.0"?>
+<AUTORIZACION>
+<CAF version="1.0">
+%s
+<FRMA algoritmo="SHA1withRSA">%s</FRMA>
+</CAF>
+<RSASK>%s</RSASK>
+<RSAPUBK>%s</RSAPUBK>
+</AUTORIZACION>`, da, base64.StdEncoding.EncodeToString(firma),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(llave)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publica})))
+}
+
+type escenarioImportacion struct {
+	importador *Importador
+	repo       *repositorioMemoria
+	llaveSII   *rsa.PrivateKey
+}
+
+func nuevoEscenario(t *testing.T) *escenarioImportacion {
+	t.Helper()
+	llaveSII, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	llaves := NewLlavesSII()
+	publica, err := x509.MarshalPKIXPublicKey(&llaveSII.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, llaves.RegistrarPEM(models.AmbienteCertificacion, 100, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publica})))
+
+	cifrador, err := NewCifradorAES("maestra-1", bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+
+	repo := newRepositorioMemoria()
+	importador := NewImportador(repo, empresasPrueba{
+		"cert": {ID: "cert", RUT: "76.212.889-6", Ambiente: models.AmbienteCertificacion},
+		"prod": {ID: "prod", RUT: "76212889-6", Ambiente: models.AmbienteProduccion},
+	}, llaves, cifrador)
+	importador.ahora = func() time.Time { return time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC) }
+	return &escenarioImportacion{importador: importador, repo: repo, llaveSII: llaveSII}
+}
+
+func TestImportarCAF(t *testing.T) {
+	e := nuevoEscenario(t)
+	archivo := cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 100)
+
+	registro, err := e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", TipoDTE: 33, Archivo: archivo})
+	require.NoError(t, err)
+	assert.Equal(t, models.AmbienteCertificacion, registro.Ambiente)
+	assert.Equal(t, int64(1), registro.FolioDesde)
+	assert.Equal(t, int64(100), registro.FolioHasta)
+	assert.Equal(t, time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC), registro.FechaVencimiento)
+	assert.Equal(t, "maestra-1", registro.LlaveCifrado)
+
+	// RSASK no queda en claro en el registro
+	assert.NotContains(t, registro.NodoCAF, "RSASK")
+	assert.False(t, bytes.Contains(registro.RSASKCifrada, []byte("PRIVATE KEY")))
+
+	require.Len(t, e.repo.rangos, 1)
+	rango := e.repo.rangos[0]
+	assert.Equal(t, registro.ID, rango.CAFID)
+	assert.Equal(t, int64(1), rango.Siguiente)
+	assert.Equal(t, models.EstadoRangoDisponible, rango.Estado)
+
+	// El CAF recuperado timbra con la misma llave y conserva el nodo firmado por el SII
+	cargado, err := e.importador.CargarCAF(context.Background(), registro.ID)
+	require.NoError(t, err)
+	privada, err := cargado.LlavePrivada()
+	require.NoError(t, err)
+	publica, err := cargado.LlavePublica()
+	require.NoError(t, err)
+	assert.True(t, privada.PublicKey.Equal(publica))
+	assert.NoError(t, cargado.VerificarFirma(&e.llaveSII.PublicKey))
+	assert.True(t, strings.Contains(cargado.RSASK, "RSA PRIVATE KEY"))
+}
+
+func TestImportarCAFRechazos(t *testing.T) {
+	e := nuevoEscenario(t)
+	otraLlave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	casos := []struct {
+		nombre    string
+		solicitud SolicitudImportacion
+		esperado  error
+	}{
+		{"firma de otra llave", SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, otraLlave, rutEmpresa, 33, 1, 100, "2024-01-15", 100)}, ErrFirmaSII},
+		{"IDK de certificación en producción", SolicitudImportacion{EmpresaID: "prod", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 100)}, ErrFirmaSII},
+		{"IDK desconocido", SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 300)}, ErrFirmaSII},
+		{"otro emisor", SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, "77888999-K", 33, 1, 100, "2024-01-15", 100)}, ErrEmpresaCAF},
+		{"otro tipo", SolicitudImportacion{EmpresaID: "cert", TipoDTE: 39, Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 100)}, ErrEmpresaCAF},
+		{"tipo sin folios", SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 99, 1, 100, "2024-01-15", 100)}, ErrCAFInvalido},
+		{"vencido", SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2023-08-01", 100)}, ErrCAFVencido},
+		{"no es un CAF", SolicitudImportacion{EmpresaID: "cert", Archivo: []byte("<AUTORIZACION/>")}, ErrCAFInvalido},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			_, err := e.importador.Importar(context.Background(), caso.solicitud)
+			assert.True(t, errors.Is(err, caso.esperado), "error: %v", err)
+		})
+	}
+
+	// Un rango alterado invalida la firma del SII
+	alterado := bytes.Replace(cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 100), []byte("<H>100</H>"), []byte("<H>900</H>"), 1)
+	_, err = e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: alterado})
+	assert.True(t, errors.Is(err, ErrFirmaSII), "error: %v", err)
+	assert.Empty(t, e.repo.rangos)
+}
+
+func TestImportarCAFSuperpuesto(t *testing.T) {
+	e := nuevoEscenario(t)
+	archivo := cafFirmado(t, e.llaveSII, rutEmpresa, 33, 1, 100, "2024-01-15", 100)
+	_, err := e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: archivo})
+	require.NoError(t, err)
+
+	_, err = e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: archivo})
+	assert.True(t, errors.Is(err, ErrCAFDuplicado), "error: %v", err)
+
+	_, err = e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 50, 150, "2024-02-01", 100)})
+	assert.True(t, errors.Is(err, ErrSuperposicion), "error: %v", err)
+
+	// El mismo rango en otro tipo de documento no se superpone
+	_, err = e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 61, 1, 100, "2024-02-01", 100)})
+	assert.NoError(t, err)
+	_, err = e.importador.Importar(context.Background(), SolicitudImportacion{EmpresaID: "cert", Archivo: cafFirmado(t, e.llaveSII, rutEmpresa, 33, 101, 200, "2024-02-01", 100)})
+	assert.NoError(t, err)
+	assert.Len(t, e.repo.rangos, 3)
+}
+
+func TestCifradorAES(t *testing.T) {
+	cifrador, err := NewCifradorAESBase64("maestra-1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+
+	cifrado, err := cifrador.Cifrar([]byte("secreto"), []byte("caf-1"))
+	require.NoError(t, err)
+	plano, err := cifrador.Descifrar(cifrado, []byte("caf-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "secreto", string(plano))
+
+	_, err = cifrador.Descifrar(cifrado, []byte("caf-2"))
+	assert.Error(t, err)
+	_, err = NewCifradorAES("corta", []byte("1234"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+// Package folios importa los CAF del SII y administra la asignación de sus folios por empresa,
+// ambiente y tipo de documento.
+package folios
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// ErrIDKDesconocido indica que no hay llave del SII registrada para el IDK del CAF en el
+// ambiente de la empresa
+var ErrIDKDesconocido = errors.New("IDK sin llave pública del SII registrada")
+
+// LlavesSII guarda por ambiente las llaves públicas con que el SII firma el FRMA de los CAF,
+// identificadas por el IDK que el CAF declara. Un CAF de certificación no verifica en una
+// empresa de producción porque su IDK no está registrado en ese ambiente.
+type LlavesSII struct {
+	mu     sync.RWMutex
+	llaves map[models.AmbienteSII]map[int]*rsa.PublicKey
+}
+
+// NewLlavesSII crea un juego de llaves vacío
+func NewLlavesSII() *LlavesSII {
+	return &LlavesSII{llaves: make(map[models.AmbienteSII]map[int]*rsa.PublicKey)}
+}
+
+// Registrar agrega la llave del IDK indicado al ambiente
+func (l *LlavesSII) Registrar(ambiente models.AmbienteSII, idk int, llave *rsa.PublicKey) error {
+	if !ambiente.Valido() {
+		return fmt.Errorf("ambiente SII desconocido: %q", ambiente)
+	}
+	if llave == nil {
+		return fmt.Errorf("llave nula para el IDK %d", idk)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.llaves[ambiente] == nil {
+		l.llaves[ambiente] = make(map[int]*rsa.PublicKey)
+	}
+	l.llaves[ambiente][idk] = llave
+	return nil
+}
+
+// RegistrarPEM agrega una llave en PEM: PUBLIC KEY, RSA PUBLIC KEY o el certificado del SII
+func (l *LlavesSII) RegistrarPEM(ambiente models.AmbienteSII, idk int, data []byte) error {
+	bloque, _ := pem.Decode(data)
+	if bloque == nil {
+		return fmt.Errorf("la llave del IDK %d no está en formato PEM", idk)
+	}
+
+	var publica interface{}
+	var err error
+	switch bloque.Type {
+	case "RSA PUBLIC KEY":
+		publica, err = x509.ParsePKCS1PublicKey(bloque.Bytes)
+	case "CERTIFICATE":
+		var certificado *x509.Certificate
+		if certificado, err = x509.ParseCertificate(bloque.Bytes); err == nil {
+			publica = certificado.PublicKey
+		}
+	default:
+		publica, err = x509.ParsePKIXPublicKey(bloque.Bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("error al parsear llave del IDK %d: %v", idk, err)
+	}
+	llave, ok := publica.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("la llave del IDK %d no es RSA", idk)
+	}
+	return l.Registrar(ambiente, idk, llave)
+}
+
+// CargarArchivo registra la llave PEM guardada en la ruta indicada
+func (l *LlavesSII) CargarArchivo(ambiente models.AmbienteSII, idk int, ruta string) error {
+	data, err := os.ReadFile(ruta)
+	if err != nil {
+		return fmt.Errorf("error al leer llave del SII: %v", err)
+	}
+	return l.RegistrarPEM(ambiente, idk, data)
+}
+
+// Llave retorna la llave del IDK en el ambiente o un error que envuelve ErrIDKDesconocido
+func (l *LlavesSII) Llave(ambiente models.AmbienteSII, idk int) (*rsa.PublicKey, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	llave, ok := l.llaves[ambiente][idk]
+	if !ok {
+		return nil, fmt.Errorf("%w: IDK %d en %s", ErrIDKDesconocido, idk, ambiente)
+	}
+	return llave, nil
+}
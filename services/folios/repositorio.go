@@ -0,0 +1,458 @@
+package folios
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones de los CAF importados, de sus rangos de folios y de su asignación
+const (
+	ColeccionCAFs         = "caf_autorizaciones"
+	ColeccionRangos       = "rangos_folios"
+	ColeccionSeriesFolios = "series_folios" // Un documento por emisor, ambiente y tipo
+	ColeccionBloques      = "bloques_folios"
+	ColeccionFolios       = "folios" // Folios entregados, con el formato de services.Folio
+)
+
+// RepositorioMongo persiste los CAF y los rangos en MongoDB. Requiere un replica set, porque
+// el registro de un CAF usa transacciones.
+type RepositorioMongo struct {
+	db *mongo.Database
+}
+
+// NewRepositorioMongo crea el repositorio sobre la base de datos indicada
+func NewRepositorioMongo(db *mongo.Database) *RepositorioMongo {
+	return &RepositorioMongo{db: db}
+}
+
+// CrearIndices crea el índice único por hash de CAF y los de búsqueda de rangos, bloques,
+// folios, solicitudes de anulación y conciliaciones
+func (r *RepositorioMongo) CrearIndices(ctx context.Context) error {
+	if _, err := r.db.Collection(ColeccionCAFs).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("error al crear índice de CAF: %v", err)
+	}
+	if _, err := r.db.Collection(ColeccionRangos).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "rut_emisor", Value: 1},
+			{Key: "ambiente", Value: 1},
+			{Key: "tipo_dte", Value: 1},
+			{Key: "desde", Value: 1},
+		},
+	}); err != nil {
+		return fmt.Errorf("error al crear índice de rangos: %v", err)
+	}
+	if _, err := r.db.Collection(ColeccionBloques).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{
+			{Key: "rut_emisor", Value: 1},
+			{Key: "ambiente", Value: 1},
+			{Key: "tipo_dte", Value: 1},
+			{Key: "estado", Value: 1},
+			{Key: "desde", Value: 1},
+		}},
+		{Keys: bson.D{{Key: "estado", Value: 1}, {Key: "expira_en", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("error al crear índices de bloques: %v", err)
+	}
+	if _, err := r.db.Collection(ColeccionFolios).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{
+			{Key: "rut_emisor", Value: 1},
+			{Key: "ambiente", Value: 1},
+			{Key: "tipo_dte", Value: 1},
+			{Key: "numero", Value: 1},
+		}},
+		{Keys: bson.D{{Key: "bloque_id", Value: 1}, {Key: "numero", Value: -1}}},
+	}); err != nil {
+		return fmt.Errorf("error al crear índices de folios: %v", err)
+	}
+	if _, err := r.db.Collection(ColeccionAnulaciones).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "rut_emisor", Value: 1}, {Key: "ambiente", Value: 1}, {Key: "estado", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("error al crear índice de anulaciones: %v", err)
+	}
+	if _, err := r.db.Collection(ColeccionConciliaciones).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "rut_emisor", Value: 1}, {Key: "ambiente", Value: 1}, {Key: "fecha", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("error al crear índice de conciliaciones: %v", err)
+	}
+	return nil
+}
+
+// RegistrarCAF implementa Repositorio. Antes de buscar superposiciones la transacción escribe
+// el documento de la serie, de modo que dos importaciones simultáneas de la misma serie
+// chocan con WriteConflict y la segunda se reintenta viendo el rango de la primera.
+func (r *RepositorioMongo) RegistrarCAF(ctx context.Context, caf *models.CAFImportado, rango *models.RangoFolios) error {
+	sesion, err := r.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("error al iniciar sesión: %v", err)
+	}
+	defer sesion.EndSession(ctx)
+
+	_, err = sesion.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := r.db.Collection(ColeccionSeriesFolios).UpdateOne(sc,
+			bson.M{"_id": claveSerie(rango.RutEmisor, rango.Ambiente, rango.TipoDTE)},
+			bson.M{"$inc": bson.M{"version": 1}, "$set": bson.M{"updated_at": time.Now()}},
+			options.Update().SetUpsert(true),
+		); err != nil {
+			return nil, fmt.Errorf("error al reservar serie de folios: %v", err)
+		}
+
+		duplicados, err := r.db.Collection(ColeccionCAFs).CountDocuments(sc, bson.M{"hash": caf.Hash})
+		if err != nil {
+			return nil, fmt.Errorf("error al buscar CAF duplicado: %v", err)
+		}
+		if duplicados > 0 {
+			return nil, fmt.Errorf("%w: %d %d-%d", ErrCAFDuplicado, caf.TipoDTE, caf.FolioDesde, caf.FolioHasta)
+		}
+
+		var existente models.RangoFolios
+		err = r.db.Collection(ColeccionRangos).FindOne(sc, bson.M{
+			"rut_emisor": rango.RutEmisor,
+			"ambiente":   rango.Ambiente,
+			"tipo_dte":   rango.TipoDTE,
+			"desde":      bson.M{"$lte": rango.Hasta},
+			"hasta":      bson.M{"$gte": rango.Desde},
+		}).Decode(&existente)
+		if err == nil {
+			return nil, fmt.Errorf("%w: %d-%d con %d-%d del CAF %s", ErrSuperposicion,
+				rango.Desde, rango.Hasta, existente.Desde, existente.Hasta, existente.CAFID)
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("error al buscar rangos superpuestos: %v", err)
+		}
+
+		if _, err := r.db.Collection(ColeccionCAFs).InsertOne(sc, caf); err != nil {
+			return nil, fmt.Errorf("error al guardar CAF: %v", err)
+		}
+		if _, err := r.db.Collection(ColeccionRangos).InsertOne(sc, rango); err != nil {
+			return nil, fmt.Errorf("error al guardar rango de folios: %v", err)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// ObtenerCAF implementa Repositorio
+func (r *RepositorioMongo) ObtenerCAF(ctx context.Context, id string) (*models.CAFImportado, error) {
+	var caf models.CAFImportado
+	if err := r.db.Collection(ColeccionCAFs).FindOne(ctx, bson.M{"_id": id}).Decode(&caf); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no existe CAF %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener CAF: %v", err)
+	}
+	return &caf, nil
+}
+
+// ArrendarBloque implementa RepositorioBloques. Un bloque libre se toma con un solo
+// FindOneAndUpdate; un bloque nuevo avanza Siguiente en el rango y se inserta en la misma
+// transacción, de modo que ningún folio queda fuera de un bloque.
+func (r *RepositorioMongo) ArrendarBloque(ctx context.Context, serie Serie, instancia string, tamano int64, ahora, expira time.Time) (*models.BloqueFolios, error) {
+	filtroSerie := bson.M{
+		"rut_emisor":        utils.NormalizarRUT(serie.RutEmisor),
+		"ambiente":          serie.Ambiente,
+		"tipo_dte":          serie.TipoDTE,
+		"fecha_vencimiento": bson.M{"$gt": ahora},
+	}
+
+	var libre models.BloqueFolios
+	filtro := copiarFiltro(filtroSerie)
+	filtro["estado"] = models.EstadoBloqueLibre
+	err := r.db.Collection(ColeccionBloques).FindOneAndUpdate(ctx, filtro,
+		bson.M{"$set": bson.M{
+			"estado":     models.EstadoBloqueArrendado,
+			"instancia":  instancia,
+			"expira_en":  expira,
+			"updated_at": ahora,
+		}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "desde", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&libre)
+	if err == nil {
+		return &libre, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("error al arrendar bloque libre: %v", err)
+	}
+
+	sesion, err := r.db.Client().StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("error al iniciar sesión: %v", err)
+	}
+	defer sesion.EndSession(ctx)
+
+	resultado, err := sesion.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		filtro := copiarFiltro(filtroSerie)
+		filtro["estado"] = models.EstadoRangoDisponible
+
+		var rango models.RangoFolios
+		err := r.db.Collection(ColeccionRangos).FindOneAndUpdate(sc, filtro,
+			bson.A{
+				bson.M{"$set": bson.M{
+					"siguiente": bson.M{"$min": bson.A{
+						bson.M{"$add": bson.A{"$siguiente", tamano}},
+						bson.M{"$add": bson.A{"$hasta", 1}},
+					}},
+					"updated_at": ahora,
+				}},
+				bson.M{"$set": bson.M{
+					"estado": bson.M{"$cond": bson.A{
+						bson.M{"$gt": bson.A{"$siguiente", "$hasta"}}, models.EstadoRangoAgotado, "$estado",
+					}},
+				}},
+			},
+			options.FindOneAndUpdate().
+				SetSort(bson.D{{Key: "desde", Value: 1}}).
+				SetReturnDocument(options.Before),
+		).Decode(&rango)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSinFolios
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error al cortar bloque del rango: %v", err)
+		}
+
+		bloque := nuevoBloque(&rango, tamano, instancia, ahora, expira)
+		if _, err := r.db.Collection(ColeccionBloques).InsertOne(sc, bloque); err != nil {
+			return nil, fmt.Errorf("error al guardar bloque de folios: %v", err)
+		}
+		return bloque, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resultado.(*models.BloqueFolios), nil
+}
+
+// RenovarBloque implementa RepositorioBloques
+func (r *RepositorioMongo) RenovarBloque(ctx context.Context, bloque *models.BloqueFolios, ahora, expira time.Time) error {
+	resultado, err := r.db.Collection(ColeccionBloques).UpdateOne(ctx,
+		bson.M{
+			"_id":       bloque.ID,
+			"instancia": bloque.Instancia,
+			"estado":    models.EstadoBloqueArrendado,
+			"expira_en": bson.M{"$gt": ahora},
+		},
+		bson.M{
+			"$set": bson.M{"expira_en": expira, "updated_at": ahora},
+			"$max": bson.M{"usado_hasta": bloque.UsadoHasta},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error al renovar bloque de folios: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrArriendoPerdido, bloque.ID)
+	}
+	return nil
+}
+
+// DevolverBloque implementa RepositorioBloques. Un bloque sin folios entregados vuelve a
+// quedar libre; si no, se acorta hasta el último entregado y el resto pasa a un bloque libre.
+func (r *RepositorioMongo) DevolverBloque(ctx context.Context, bloque *models.BloqueFolios, ahora time.Time) error {
+	filtro := bson.M{"_id": bloque.ID, "instancia": bloque.Instancia, "estado": models.EstadoBloqueArrendado}
+	if bloque.UsadoHasta < bloque.Desde {
+		resultado, err := r.db.Collection(ColeccionBloques).UpdateOne(ctx, filtro, bson.M{
+			"$set":   bson.M{"estado": models.EstadoBloqueLibre, "updated_at": ahora},
+			"$unset": bson.M{"instancia": "", "expira_en": ""},
+		})
+		if err != nil {
+			return fmt.Errorf("error al devolver bloque de folios: %v", err)
+		}
+		if resultado.MatchedCount == 0 {
+			return fmt.Errorf("%w: %s", ErrArriendoPerdido, bloque.ID)
+		}
+		return nil
+	}
+
+	sesion, err := r.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("error al iniciar sesión: %v", err)
+	}
+	defer sesion.EndSession(ctx)
+
+	_, err = sesion.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		resultado, err := r.db.Collection(ColeccionBloques).UpdateOne(sc, filtro, bson.M{"$set": bson.M{
+			"estado":      models.EstadoBloqueCerrado,
+			"hasta":       bloque.UsadoHasta,
+			"usado_hasta": bloque.UsadoHasta,
+			"updated_at":  ahora,
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("error al cerrar bloque de folios: %v", err)
+		}
+		if resultado.MatchedCount == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrArriendoPerdido, bloque.ID)
+		}
+		if resto := bloqueRestante(bloque, ahora); resto != nil {
+			if _, err := r.db.Collection(ColeccionBloques).InsertOne(sc, resto); err != nil {
+				return nil, fmt.Errorf("error al guardar folios devueltos: %v", err)
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// ExpirarBloques implementa RepositorioBloques. Cada bloque se marca con una actualización
+// condicionada, de modo que si dos instancias expiran a la vez solo una lo informa. Antes de
+// informarlo se cruza con los folios registrados del bloque, que pueden superar el UsadoHasta
+// de la última renovación.
+func (r *RepositorioMongo) ExpirarBloques(ctx context.Context, ahora time.Time) ([]*models.BloqueFolios, error) {
+	filtro := bson.M{"estado": models.EstadoBloqueArrendado, "expira_en": bson.M{"$lte": ahora}}
+	cursor, err := r.db.Collection(ColeccionBloques).Find(ctx, filtro)
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar arriendos vencidos: %v", err)
+	}
+	var vencidos []*models.BloqueFolios
+	if err := cursor.All(ctx, &vencidos); err != nil {
+		return nil, fmt.Errorf("error al leer arriendos vencidos: %v", err)
+	}
+
+	var expirados []*models.BloqueFolios
+	for _, bloque := range vencidos {
+		usadoHasta, err := r.ultimoFolioRegistrado(ctx, bloque)
+		if err != nil {
+			return expirados, err
+		}
+		condicion := copiarFiltro(filtro)
+		condicion["_id"] = bloque.ID
+		resultado, err := r.db.Collection(ColeccionBloques).UpdateOne(ctx, condicion, bson.M{
+			"$set": bson.M{"estado": models.EstadoBloqueExpirado, "updated_at": ahora},
+			"$max": bson.M{"usado_hasta": usadoHasta},
+		})
+		if err != nil {
+			return expirados, fmt.Errorf("error al expirar bloque %s: %v", bloque.ID, err)
+		}
+		if resultado.ModifiedCount > 0 {
+			bloque.Estado = models.EstadoBloqueExpirado
+			bloque.UsadoHasta = usadoHasta
+			expirados = append(expirados, bloque)
+		}
+	}
+	return expirados, nil
+}
+
+// ultimoFolioRegistrado retorna el mayor entre el UsadoHasta del bloque y el último folio
+// registrado como utilizado en él
+func (r *RepositorioMongo) ultimoFolioRegistrado(ctx context.Context, bloque *models.BloqueFolios) (int64, error) {
+	var folio struct {
+		Numero int64 `bson:"numero"`
+	}
+	err := r.db.Collection(ColeccionFolios).FindOne(ctx, bson.M{"bloque_id": bloque.ID},
+		options.FindOne().SetSort(bson.D{{Key: "numero", Value: -1}}).SetProjection(bson.M{"numero": 1})).Decode(&folio)
+	if err == mongo.ErrNoDocuments {
+		return bloque.UsadoHasta, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error al buscar folios registrados del bloque %s: %v", bloque.ID, err)
+	}
+	if folio.Numero > bloque.UsadoHasta {
+		return folio.Numero, nil
+	}
+	return bloque.UsadoHasta, nil
+}
+
+// RegistrarUsos implementa RepositorioBloques. Los folios se guardan en la colección de
+// folios del FolioService, con un ID por ambiente, para que sus reportes los incluyan.
+func (r *RepositorioMongo) RegistrarUsos(ctx context.Context, usos []models.FolioAsignado) error {
+	escrituras := make([]mongo.WriteModel, 0, len(usos))
+	for _, uso := range usos {
+		escrituras = append(escrituras, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": IDFolio(uso.RutEmisor, uso.Ambiente, uso.TipoDTE, uso.Folio)}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"rut_emisor": uso.RutEmisor,
+				"ambiente":   uso.Ambiente,
+				"tipo_dte":   strconv.Itoa(int(uso.TipoDTE)),
+				"numero":     uso.Folio,
+				"estado":     "UTILIZADO",
+				"caf_id":     uso.CAFID,
+				"bloque_id":  uso.BloqueID,
+				"instancia":  uso.Instancia,
+				"fecha_uso":  uso.Fecha,
+			}}).
+			SetUpsert(true))
+	}
+	if _, err := r.db.Collection(ColeccionFolios).BulkWrite(ctx, escrituras, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("error al registrar folios utilizados: %v", err)
+	}
+	return nil
+}
+
+// IDFolio identifica un folio en la colección de folios
+func IDFolio(rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE, folio int64) string {
+	return fmt.Sprintf("%s:%d", claveSerie(rutEmisor, ambiente, tipo), folio)
+}
+
+// nuevoBloque corta hasta tamano folios desde el primero no entregado del rango
+func nuevoBloque(rango *models.RangoFolios, tamano int64, instancia string, ahora, expira time.Time) *models.BloqueFolios {
+	hasta := rango.Siguiente + tamano - 1
+	if hasta > rango.Hasta {
+		hasta = rango.Hasta
+	}
+	return &models.BloqueFolios{
+		ID:               models.GenerateID(),
+		RangoID:          rango.ID,
+		CAFID:            rango.CAFID,
+		RutEmisor:        rango.RutEmisor,
+		Ambiente:         rango.Ambiente,
+		TipoDTE:          rango.TipoDTE,
+		Desde:            rango.Siguiente,
+		Hasta:            hasta,
+		UsadoHasta:       rango.Siguiente - 1,
+		Estado:           models.EstadoBloqueArrendado,
+		Instancia:        instancia,
+		ExpiraEn:         expira,
+		FechaVencimiento: rango.FechaVencimiento,
+		CreatedAt:        ahora,
+		UpdatedAt:        ahora,
+	}
+}
+
+// bloqueRestante retorna los folios no entregados de un bloque como un bloque libre, o nil
+// si se entregaron todos
+func bloqueRestante(bloque *models.BloqueFolios, ahora time.Time) *models.BloqueFolios {
+	if bloque.UsadoHasta >= bloque.Hasta {
+		return nil
+	}
+	return &models.BloqueFolios{
+		ID:               models.GenerateID(),
+		RangoID:          bloque.RangoID,
+		CAFID:            bloque.CAFID,
+		RutEmisor:        bloque.RutEmisor,
+		Ambiente:         bloque.Ambiente,
+		TipoDTE:          bloque.TipoDTE,
+		Desde:            bloque.UsadoHasta + 1,
+		Hasta:            bloque.Hasta,
+		UsadoHasta:       bloque.UsadoHasta,
+		Estado:           models.EstadoBloqueLibre,
+		Origen:           bloque.ID,
+		FechaVencimiento: bloque.FechaVencimiento,
+		CreatedAt:        ahora,
+		UpdatedAt:        ahora,
+	}
+}
+
+func copiarFiltro(filtro bson.M) bson.M {
+	copia := make(bson.M, len(filtro)+1)
+	for clave, valor := range filtro {
+		copia[clave] = valor
+	}
+	return copia
+}
+
+// claveSerie identifica la serie de folios de un emisor, ambiente y tipo de documento
+func claveSerie(rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) string {
+	return fmt.Sprintf("%s:%s:%d", utils.NormalizarRUT(rutEmisor), ambiente, tipo)
+}
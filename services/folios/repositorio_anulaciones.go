@@ -0,0 +1,315 @@
+package folios
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones consultadas y escritas por la anulación de folios
+const (
+	ColeccionAnulaciones = "anulaciones_folios"
+	ColeccionDocumentos  = "documentos"
+	ColeccionBoletas     = "boletas"
+)
+
+// loteAnulacion es la cantidad de folios que se marcan como anulados por escritura
+const loteAnulacion = 1000
+
+// Rangos implementa RepositorioAnulaciones
+func (r *RepositorioMongo) Rangos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]*models.RangoFolios, error) {
+	cursor, err := r.db.Collection(ColeccionRangos).Find(ctx, bson.M{"rut_emisor": utils.NormalizarRUT(rutEmisor), "ambiente": ambiente})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar rangos de folios: %v", err)
+	}
+	var rangos []*models.RangoFolios
+	if err := cursor.All(ctx, &rangos); err != nil {
+		return nil, fmt.Errorf("error al leer rangos de folios: %v", err)
+	}
+	return rangos, nil
+}
+
+// Bloques implementa RepositorioAnulaciones
+func (r *RepositorioMongo) Bloques(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estado string) ([]*models.BloqueFolios, error) {
+	cursor, err := r.db.Collection(ColeccionBloques).Find(ctx, bson.M{
+		"rut_emisor": utils.NormalizarRUT(rutEmisor),
+		"ambiente":   ambiente,
+		"estado":     estado,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar bloques de folios: %v", err)
+	}
+	var bloques []*models.BloqueFolios
+	if err := cursor.All(ctx, &bloques); err != nil {
+		return nil, fmt.Errorf("error al leer bloques de folios: %v", err)
+	}
+	return bloques, nil
+}
+
+// folioMongo es un folio de la colección de folios, con el tipo como texto igual que
+// services.Folio
+type folioMongo struct {
+	TipoDTE     string                 `bson:"tipo_dte"`
+	Numero      int64                  `bson:"numero"`
+	CAFID       string                 `bson:"caf_id"`
+	Estado      string                 `bson:"estado"`
+	Motivo      models.MotivoAnulacion `bson:"motivo_anulacion,omitempty"`
+	FechaUso    time.Time              `bson:"fecha_uso,omitempty"`
+	DocumentoID string                 `bson:"documento_id,omitempty"`
+}
+
+// FoliosRegistrados implementa RepositorioAnulaciones. Solo considera los folios con
+// ambiente, es decir, los entregados por el Asignador.
+func (r *RepositorioMongo) FoliosRegistrados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, limite time.Time) ([]FolioRegistrado, error) {
+	cursor, err := r.db.Collection(ColeccionFolios).Find(ctx, bson.M{
+		"rut_emisor": utils.NormalizarRUT(rutEmisor),
+		"ambiente":   ambiente,
+		"estado":     bson.M{"$ne": "ANULADO"},
+		"$or": bson.A{
+			bson.M{"estado": "UTILIZADO", "fecha_uso": bson.M{"$lt": limite}},
+			bson.M{"por_anular": true},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar folios entregados: %v", err)
+	}
+	return leerFolios(ctx, cursor)
+}
+
+// leerFolios convierte los folios leídos de la colección de folios
+func leerFolios(ctx context.Context, cursor *mongo.Cursor) ([]FolioRegistrado, error) {
+	var registros []folioMongo
+	if err := cursor.All(ctx, &registros); err != nil {
+		return nil, fmt.Errorf("error al leer folios entregados: %v", err)
+	}
+
+	folios := make([]FolioRegistrado, 0, len(registros))
+	for _, f := range registros {
+		tipo, err := strconv.Atoi(f.TipoDTE)
+		if err != nil {
+			return nil, fmt.Errorf("tipo de documento inválido en folio %d: %s", f.Numero, f.TipoDTE)
+		}
+		folios = append(folios, FolioRegistrado{
+			TipoDTE:     models.TipoDTE(tipo),
+			Folio:       f.Numero,
+			CAFID:       f.CAFID,
+			Estado:      f.Estado,
+			Motivo:      f.Motivo,
+			FechaUso:    f.FechaUso,
+			DocumentoID: f.DocumentoID,
+		})
+	}
+	return folios, nil
+}
+
+// documentoMongo son los campos de documentos y boletas que identifican su folio
+type documentoMongo struct {
+	ID            string         `bson:"_id"`
+	TipoDocumento models.TipoDTE `bson:"tipo_documento"`
+	Folio         int64          `bson:"folio"`
+	Estado        string         `bson:"estado"`
+	TrackID       string         `bson:"track_id"`
+	FechaEmision  time.Time      `bson:"fecha_emision"`
+}
+
+// Documentos implementa RepositorioAnulaciones buscando en los documentos y en las boletas
+func (r *RepositorioMongo) Documentos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE, folios []int64) ([]DocumentoFolio, error) {
+	return r.buscarDocumentos(ctx, bson.M{
+		"rut_emisor":     utils.NormalizarRUT(rutEmisor),
+		"ambiente":       bson.M{"$in": bson.A{ambiente, nil}},
+		"tipo_documento": tipo,
+		"folio":          bson.M{"$in": folios},
+	})
+}
+
+// DocumentosAnuladosSinEnvio implementa RepositorioAnulaciones
+func (r *RepositorioMongo) DocumentosAnuladosSinEnvio(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII) ([]DocumentoFolio, error) {
+	return r.buscarDocumentos(ctx, bson.M{
+		"rut_emisor": utils.NormalizarRUT(rutEmisor),
+		"ambiente":   bson.M{"$in": bson.A{ambiente, nil}},
+		"estado":     models.EstadoDTEAnulado,
+		"track_id":   bson.M{"$in": bson.A{nil, ""}},
+	})
+}
+
+func (r *RepositorioMongo) buscarDocumentos(ctx context.Context, filtro bson.M) ([]DocumentoFolio, error) {
+	var documentos []DocumentoFolio
+	for _, coleccion := range []string{ColeccionDocumentos, ColeccionBoletas} {
+		cursor, err := r.db.Collection(coleccion).Find(ctx, filtro, options.Find().SetProjection(bson.M{
+			"tipo_documento": 1, "folio": 1, "estado": 1, "track_id": 1, "fecha_emision": 1,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("error al buscar %s: %v", coleccion, err)
+		}
+		var encontrados []documentoMongo
+		if err := cursor.All(ctx, &encontrados); err != nil {
+			return nil, fmt.Errorf("error al leer %s: %v", coleccion, err)
+		}
+		for _, d := range encontrados {
+			documentos = append(documentos, DocumentoFolio{
+				ID:           d.ID,
+				TipoDTE:      d.TipoDocumento,
+				Folio:        d.Folio,
+				Estado:       models.EstadoDTE(d.Estado),
+				TrackID:      d.TrackID,
+				FechaEmision: d.FechaEmision,
+			})
+		}
+	}
+	return documentos, nil
+}
+
+// Solicitudes implementa RepositorioAnulaciones
+func (r *RepositorioMongo) Solicitudes(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estados ...models.EstadoSolicitudAnulacion) ([]*models.SolicitudAnulacion, error) {
+	return r.solicitudes(ctx, rutEmisor, ambiente, estados)
+}
+
+func (r *RepositorioMongo) solicitudes(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, estados []models.EstadoSolicitudAnulacion) ([]*models.SolicitudAnulacion, error) {
+	cursor, err := r.db.Collection(ColeccionAnulaciones).Find(ctx, bson.M{
+		"rut_emisor": utils.NormalizarRUT(rutEmisor),
+		"ambiente":   ambiente,
+		"estado":     bson.M{"$in": estados},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar solicitudes de anulación: %v", err)
+	}
+	var solicitudes []*models.SolicitudAnulacion
+	if err := cursor.All(ctx, &solicitudes); err != nil {
+		return nil, fmt.Errorf("error al leer solicitudes de anulación: %v", err)
+	}
+	return solicitudes, nil
+}
+
+// RegistrarSolicitud implementa RepositorioAnulaciones. Igual que RegistrarCAF, la
+// transacción escribe primero el documento de la empresa en series_folios para que dos
+// solicitudes simultáneas choquen y la segunda vea los folios de la primera.
+func (r *RepositorioMongo) RegistrarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion) error {
+	sesion, err := r.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("error al iniciar sesión: %v", err)
+	}
+	defer sesion.EndSession(ctx)
+
+	_, err = sesion.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := r.db.Collection(ColeccionSeriesFolios).UpdateOne(sc,
+			bson.M{"_id": fmt.Sprintf("anulaciones:%s:%s", solicitud.RutEmisor, solicitud.Ambiente)},
+			bson.M{"$inc": bson.M{"version": 1}, "$set": bson.M{"updated_at": time.Now()}},
+			options.Update().SetUpsert(true),
+		); err != nil {
+			return nil, fmt.Errorf("error al reservar anulaciones de la empresa: %v", err)
+		}
+
+		vigentes, err := r.solicitudes(sc, solicitud.RutEmisor, solicitud.Ambiente, []models.EstadoSolicitudAnulacion{
+			models.EstadoAnulacionPendiente, models.EstadoAnulacionPresentada, models.EstadoAnulacionAprobada,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := verificarSuperposicion(solicitud, vigentes); err != nil {
+			return nil, err
+		}
+
+		if _, err := r.db.Collection(ColeccionAnulaciones).InsertOne(sc, solicitud); err != nil {
+			return nil, fmt.Errorf("error al guardar solicitud de anulación: %v", err)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// ObtenerSolicitud implementa RepositorioAnulaciones
+func (r *RepositorioMongo) ObtenerSolicitud(ctx context.Context, id string) (*models.SolicitudAnulacion, error) {
+	var solicitud models.SolicitudAnulacion
+	if err := r.db.Collection(ColeccionAnulaciones).FindOne(ctx, bson.M{"_id": id}).Decode(&solicitud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no existe solicitud de anulación %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener solicitud de anulación: %v", err)
+	}
+	return &solicitud, nil
+}
+
+// ActualizarSolicitud implementa RepositorioAnulaciones
+func (r *RepositorioMongo) ActualizarSolicitud(ctx context.Context, solicitud *models.SolicitudAnulacion, anterior models.EstadoSolicitudAnulacion) error {
+	resultado, err := r.db.Collection(ColeccionAnulaciones).ReplaceOne(ctx, bson.M{"_id": solicitud.ID, "estado": anterior}, solicitud)
+	if err != nil {
+		return fmt.Errorf("error al actualizar solicitud de anulación: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("%w: %s ya no está %s", ErrEstadoSolicitud, solicitud.ID, anterior)
+	}
+	return nil
+}
+
+// MarcarAnulados implementa RepositorioAnulaciones. Cada folio queda en la colección de
+// folios como ANULADO con la solicitud y la fecha de anulación, que usan el RCOF y
+// GenerarReporteFolios; los folios nunca entregados se crean. Es idempotente.
+func (r *RepositorioMongo) MarcarAnulados(ctx context.Context, solicitud *models.SolicitudAnulacion, fecha time.Time) error {
+	coleccion := r.db.Collection(ColeccionFolios)
+	escrituras := make([]mongo.WriteModel, 0, loteAnulacion)
+	escribir := func() error {
+		if len(escrituras) == 0 {
+			return nil
+		}
+		if _, err := coleccion.BulkWrite(ctx, escrituras, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("error al marcar folios anulados: %v", err)
+		}
+		escrituras = escrituras[:0]
+		return nil
+	}
+
+	for _, rango := range solicitud.Rangos {
+		tipo := strconv.Itoa(int(rango.TipoDTE))
+		for folio := rango.Desde; folio <= rango.Hasta; folio++ {
+			escrituras = append(escrituras, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{
+					"rut_emisor": solicitud.RutEmisor,
+					"ambiente":   solicitud.Ambiente,
+					"tipo_dte":   tipo,
+					"numero":     folio,
+				}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"estado":              "ANULADO",
+						"caf_id":              rango.CAFID,
+						"motivo_anulacion":    rango.Motivo,
+						"solicitud_anulacion": solicitud.ID,
+						"fecha_anulacion":     fecha,
+					},
+					"$unset":       bson.M{"por_anular": ""},
+					"$setOnInsert": bson.M{"_id": IDFolio(solicitud.RutEmisor, solicitud.Ambiente, rango.TipoDTE, folio)},
+				}).
+				SetUpsert(true))
+			if len(escrituras) == loteAnulacion {
+				if err := escribir(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return escribir()
+}
+
+// verificarSuperposicion comprueba que ningún folio de la solicitud esté en otra vigente
+func verificarSuperposicion(solicitud *models.SolicitudAnulacion, vigentes []*models.SolicitudAnulacion) error {
+	for _, otra := range vigentes {
+		if otra.ID == solicitud.ID {
+			continue
+		}
+		for _, r := range solicitud.Rangos {
+			for _, o := range otra.Rangos {
+				if r.TipoDTE == o.TipoDTE && r.Desde <= o.Hasta && o.Desde <= r.Hasta {
+					return fmt.Errorf("%w: tipo %d folios %d-%d en la solicitud %s", ErrSolicitudSuperpuesta, r.TipoDTE, r.Desde, r.Hasta, otra.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package folios
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones consultadas y escritas por la conciliación de folios
+const (
+	ColeccionConciliaciones = "conciliaciones_folios"
+	ColeccionSeguimientos   = "seguimiento_envios" // Escrita por services/seguimiento
+)
+
+// loteConciliacion es la cantidad de folios que se registran por escritura al reparar
+const loteConciliacion = 1000
+
+// FoliosSerie implementa RepositorioConciliacion
+func (r *RepositorioMongo) FoliosSerie(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]FolioRegistrado, error) {
+	cursor, err := r.db.Collection(ColeccionFolios).Find(ctx, bson.M{
+		"rut_emisor": utils.NormalizarRUT(rutEmisor),
+		"ambiente":   ambiente,
+		"tipo_dte":   strconv.Itoa(int(tipo)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar folios registrados: %v", err)
+	}
+	return leerFolios(ctx, cursor)
+}
+
+// DocumentosEmitidos implementa RepositorioConciliacion buscando en los documentos y en las
+// boletas. Los borradores aún no usan su folio.
+func (r *RepositorioMongo) DocumentosEmitidos(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, tipo models.TipoDTE) ([]DocumentoFolio, error) {
+	return r.buscarDocumentos(ctx, bson.M{
+		"rut_emisor":     utils.NormalizarRUT(rutEmisor),
+		"ambiente":       bson.M{"$in": bson.A{ambiente, nil}},
+		"tipo_documento": tipo,
+		"folio":          bson.M{"$gt": 0},
+		"estado":         bson.M{"$nin": bson.A{models.EstadoDTEBorrador, models.EstadoDTERecibido}},
+	})
+}
+
+// Seguimientos implementa RepositorioConciliacion
+func (r *RepositorioMongo) Seguimientos(ctx context.Context, ambiente models.AmbienteSII, trackIDs []string) ([]*models.SeguimientoEnvio, error) {
+	cursor, err := r.db.Collection(ColeccionSeguimientos).Find(ctx, bson.M{
+		"track_id": bson.M{"$in": trackIDs},
+		"ambiente": bson.M{"$in": bson.A{ambiente, nil}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar seguimientos de envíos: %v", err)
+	}
+	var seguimientos []*models.SeguimientoEnvio
+	if err := cursor.All(ctx, &seguimientos); err != nil {
+		return nil, fmt.Errorf("error al leer seguimientos de envíos: %v", err)
+	}
+	return seguimientos, nil
+}
+
+// RegistrarFoliosConciliados implementa RepositorioConciliacion. Solo escribe con
+// $setOnInsert, de modo que un folio registrado entre la conciliación y la reparación, por
+// ejemplo anulado, queda como estaba.
+func (r *RepositorioMongo) RegistrarFoliosConciliados(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, folios []FolioRegistrado) error {
+	rutEmisor = utils.NormalizarRUT(rutEmisor)
+	coleccion := r.db.Collection(ColeccionFolios)
+	for inicio := 0; inicio < len(folios); inicio += loteConciliacion {
+		fin := inicio + loteConciliacion
+		if fin > len(folios) {
+			fin = len(folios)
+		}
+		escrituras := make([]mongo.WriteModel, 0, fin-inicio)
+		for _, f := range folios[inicio:fin] {
+			tipo := strconv.Itoa(int(f.TipoDTE))
+			insertar := bson.M{
+				"_id":       IDFolio(rutEmisor, ambiente, f.TipoDTE, f.Folio),
+				"estado":    f.Estado,
+				"caf_id":    f.CAFID,
+				"fecha_uso": f.FechaUso,
+				"origen":    "conciliacion",
+			}
+			if f.DocumentoID != "" {
+				insertar["documento_id"] = f.DocumentoID
+			}
+			escrituras = append(escrituras, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{
+					"rut_emisor": rutEmisor,
+					"ambiente":   ambiente,
+					"tipo_dte":   tipo,
+					"numero":     f.Folio,
+				}).
+				SetUpdate(bson.M{"$setOnInsert": insertar}).
+				SetUpsert(true))
+		}
+		if _, err := coleccion.BulkWrite(ctx, escrituras, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("error al registrar folios conciliados: %v", err)
+		}
+	}
+	return nil
+}
+
+// GuardarConciliacion implementa RepositorioConciliacion
+func (r *RepositorioMongo) GuardarConciliacion(ctx context.Context, reporte *models.ReporteConciliacion) error {
+	if _, err := r.db.Collection(ColeccionConciliaciones).InsertOne(ctx, reporte); err != nil {
+		return fmt.Errorf("error al guardar conciliación de folios: %v", err)
+	}
+	return nil
+}
+
+// ObtenerConciliacion implementa RepositorioConciliacion
+func (r *RepositorioMongo) ObtenerConciliacion(ctx context.Context, id string) (*models.ReporteConciliacion, error) {
+	var reporte models.ReporteConciliacion
+	if err := r.db.Collection(ColeccionConciliaciones).FindOne(ctx, bson.M{"_id": id}).Decode(&reporte); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no existe conciliación de folios %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener conciliación de folios: %v", err)
+	}
+	return &reporte, nil
+}
@@ -0,0 +1,152 @@
+package guias
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// tasaIVA es la tasa de IVA aplicada a la factura que consolida las guías
+const tasaIVA = 19.0
+
+// diaLimiteFacturacion es el día del mes siguiente hasta el que pueden facturarse las guías
+// del período
+const diaLimiteFacturacion = 10
+
+// ParametrosFacturacion identifica las guías a consolidar: emisor, receptor y período
+// tributario (AAAA-MM) en que fueron emitidas
+type ParametrosFacturacion struct {
+	RutEmisor    string    `json:"rut_emisor" binding:"required"`
+	RutReceptor  string    `json:"rut_receptor" binding:"required"`
+	Periodo      string    `json:"periodo" binding:"required"`
+	FechaEmision time.Time `json:"fecha_emision"`
+}
+
+// Rango retorna el inicio del período y el inicio del período siguiente
+func (p ParametrosFacturacion) Rango() (time.Time, time.Time, error) {
+	inicio, err := time.Parse("2006-01", p.Periodo)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("período inválido, se espera AAAA-MM: %v", err)
+	}
+	return inicio, inicio.AddDate(0, 1, 0), nil
+}
+
+// Validar verifica los parámetros y que la fecha de la factura no supere el plazo legal para
+// facturar las guías del período
+func (p ParametrosFacturacion) Validar() error {
+	if p.RutEmisor == "" || p.RutReceptor == "" {
+		return fmt.Errorf("RUT del emisor y del receptor requeridos")
+	}
+	inicio, fin, err := p.Rango()
+	if err != nil {
+		return err
+	}
+	if p.FechaEmision.IsZero() {
+		return fmt.Errorf("fecha de emisión de la factura requerida")
+	}
+	if p.FechaEmision.Before(inicio) {
+		return fmt.Errorf("la factura no puede ser anterior al período %s", p.Periodo)
+	}
+	limite := fin.AddDate(0, 0, diaLimiteFacturacion)
+	if !p.FechaEmision.Before(limite) {
+		return fmt.Errorf("las guías del período %s deben facturarse a más tardar el %s", p.Periodo, limite.AddDate(0, 0, -1).Format("02/01/2006"))
+	}
+	return nil
+}
+
+// ConsolidarFactura arma la factura electrónica (33) que consolida las guías de despacho de un
+// receptor: una línea de detalle y una referencia (TpoDocRef 52) por guía
+func ConsolidarFactura(params ParametrosFacturacion, folio int, guias []models.GuiaDespacho) (*models.DocumentoTributario, error) {
+	if err := params.Validar(); err != nil {
+		return nil, err
+	}
+	if folio <= 0 {
+		return nil, fmt.Errorf("folio inválido: %d", folio)
+	}
+	if len(guias) == 0 {
+		return nil, fmt.Errorf("no hay guías por facturar para %s en el período %s", params.RutReceptor, params.Periodo)
+	}
+
+	primera := guias[0]
+	factura := &models.DocumentoTributario{
+		Folio:               folio,
+		FechaEmision:        params.FechaEmision,
+		TipoDocumento:       models.TipoFactura,
+		TipoDTE:             strconv.Itoa(int(models.TipoFactura)),
+		RUTEmisor:           primera.RUTEmisor,
+		RazonSocialEmisor:   primera.RazonSocialEmisor,
+		GiroEmisor:          primera.GiroEmisor,
+		DireccionEmisor:     primera.DireccionEmisor,
+		ComunaEmisor:        primera.ComunaEmisor,
+		RUTReceptor:         primera.RUTReceptor,
+		RazonSocialReceptor: primera.RazonSocialReceptor,
+		GiroReceptor:        primera.GiroReceptor,
+		DireccionReceptor:   primera.DireccionReceptor,
+		ComunaReceptor:      primera.ComunaReceptor,
+		TasaIVA:             tasaIVA,
+		Estado:              models.EstadoDTEEmitido,
+	}
+
+	for _, guia := range guias {
+		if err := validarGuiaFacturable(params, &guia); err != nil {
+			return nil, err
+		}
+
+		glosa := fmt.Sprintf("Guía de despacho N° %d del %s", guia.Folio, guia.FechaEmision.Format("02/01/2006"))
+		if neto := math.Round(guia.MontoNeto); neto > 0 {
+			factura.Detalles = append(factura.Detalles, models.DetalleTributario{
+				Descripcion: glosa, Cantidad: 1, PrecioUnitario: neto, MontoItem: neto,
+			})
+			factura.MontoNeto += neto
+		}
+		if exento := math.Round(guia.MontoExento); exento > 0 {
+			factura.Detalles = append(factura.Detalles, models.DetalleTributario{
+				Descripcion: glosa + " (exento)", Cantidad: 1, PrecioUnitario: exento, MontoItem: exento, Exento: true,
+			})
+			factura.MontoExento += exento
+		}
+
+		factura.Referencias = append(factura.Referencias, models.Referencia{
+			TipoDocumento:   strconv.Itoa(int(models.TipoGuiaDespacho)),
+			Folio:           guia.Folio,
+			FechaReferencia: guia.FechaEmision,
+			RazonReferencia: "Facturación de guías del período " + params.Periodo,
+		})
+	}
+
+	factura.MontoIVA = math.Round(factura.MontoNeto * tasaIVA / 100)
+	factura.MontoTotal = factura.MontoNeto + factura.MontoExento + factura.MontoIVA
+	return factura, nil
+}
+
+// validarGuiaFacturable verifica que la guía pertenezca al emisor, receptor y período, que
+// constituya venta y que no haya sido facturada antes
+func validarGuiaFacturable(params ParametrosFacturacion, guia *models.GuiaDespacho) error {
+	inicio, fin, err := params.Rango()
+	if err != nil {
+		return err
+	}
+	switch {
+	case guia.TipoDocumento != models.TipoGuiaDespacho:
+		return fmt.Errorf("el documento folio %d no es una guía de despacho", guia.Folio)
+	case !utils.MismoRUT(guia.RUTEmisor, params.RutEmisor):
+		return fmt.Errorf("la guía %d no pertenece al emisor %s", guia.Folio, params.RutEmisor)
+	case !utils.MismoRUT(guia.RUTReceptor, params.RutReceptor):
+		return fmt.Errorf("la guía %d no pertenece al receptor %s", guia.Folio, params.RutReceptor)
+	case guia.FechaEmision.Before(inicio) || !guia.FechaEmision.Before(fin):
+		return fmt.Errorf("la guía %d no pertenece al período %s", guia.Folio, params.Periodo)
+	case !guia.ConstituyeVenta():
+		return fmt.Errorf("la guía %d no constituye venta (indicador de traslado %s)", guia.Folio, guia.IndicadorTraslado)
+	case guia.Facturada():
+		return fmt.Errorf("la guía %d ya fue facturada en la factura %d", guia.Folio, guia.FolioFactura)
+	case guia.Estado == models.EstadoDTEAnulado:
+		return fmt.Errorf("la guía %d está anulada", guia.Folio)
+	case params.FechaEmision.Before(guia.FechaEmision):
+		return fmt.Errorf("la factura no puede ser anterior a la guía %d", guia.Folio)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package guias implementa las reglas de la guía de despacho electrónica (52) y la facturación
+// mensual de las guías que constituyen venta.
+package guias
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// Validar verifica las reglas del SII para la guía de despacho: indicador de traslado, tipo de
+// despacho, datos de transporte cuando el emisor realiza el despacho y montos de las ventas
+func Validar(guia *models.GuiaDespacho) error {
+	if guia.TipoDocumento != models.TipoGuiaDespacho {
+		return fmt.Errorf("el documento no es una guía de despacho: tipo %d", guia.TipoDocumento)
+	}
+	if guia.Folio <= 0 {
+		return fmt.Errorf("folio inválido: %d", guia.Folio)
+	}
+	if guia.RUTEmisor == "" {
+		return fmt.Errorf("RUT del emisor requerido")
+	}
+	if guia.RUTReceptor == "" {
+		return fmt.Errorf("RUT del receptor requerido")
+	}
+
+	if models.GlosaIndTraslado(guia.IndicadorTraslado) == "" {
+		return fmt.Errorf("indicador de traslado inválido: %q", guia.IndicadorTraslado)
+	}
+	if guia.TipoDespacho != "" && models.GlosaTipoDespacho(guia.TipoDespacho) == "" {
+		return fmt.Errorf("tipo de despacho inválido: %q", guia.TipoDespacho)
+	}
+
+	// En los traslados internos el emisor se traslada mercadería a sí mismo
+	if guia.IndicadorTraslado == models.IndTrasladoInterno && !utils.MismoRUT(guia.RUTReceptor, guia.RUTEmisor) {
+		return fmt.Errorf("en un traslado interno el receptor debe ser el mismo emisor")
+	}
+
+	if guia.DespachoPorEmisor() {
+		switch {
+		case guia.Patente == "":
+			return fmt.Errorf("patente del vehículo requerida")
+		case guia.RutChofer == "" || guia.NombreChofer == "":
+			return fmt.Errorf("RUT y nombre del chofer requeridos")
+		case guia.DireccionDestino == "" || guia.ComunaDestino == "":
+			return fmt.Errorf("dirección y comuna de destino requeridas")
+		}
+	}
+
+	if len(guia.Items) == 0 {
+		return fmt.Errorf("el documento debe tener al menos un item")
+	}
+	if guia.ConstituyeVenta() && guia.MontoTotal <= 0 {
+		return fmt.Errorf("una guía que constituye venta debe informar montos")
+	}
+
+	return nil
+}
+
+// ConstruirDTE valida la guía y arma su XML con el indicador de traslado, el tipo de
+// despacho, el bloque de transporte y las referencias
+func ConstruirDTE(guia *models.GuiaDespacho) (*models.DTEXMLModel, error) {
+	if err := Validar(guia); err != nil {
+		return nil, err
+	}
+
+	encabezado := models.EncabezadoXMLModel{
+		IdDoc: models.IDDocumentoXML{
+			TipoDTE:      strconv.Itoa(int(models.TipoGuiaDespacho)),
+			Folio:        guia.Folio,
+			FechaEmision: guia.FechaEmision.Format("2006-01-02"),
+			TipoDespacho: guia.TipoDespacho,
+			IndTraslado:  guia.IndicadorTraslado,
+		},
+		Emisor: models.EmisorXML{
+			RUT:         guia.RUTEmisor,
+			RazonSocial: guia.RazonSocialEmisor,
+			Giro:        guia.GiroEmisor,
+			Direccion:   guia.DireccionEmisor,
+			Comuna:      guia.ComunaEmisor,
+			Ciudad:      guia.CiudadOrigen,
+		},
+		Receptor: models.ReceptorXML{
+			RUT:         guia.RUTReceptor,
+			RazonSocial: guia.RazonSocialReceptor,
+			Giro:        guia.GiroReceptor,
+			Direccion:   guia.DireccionReceptor,
+			Comuna:      guia.ComunaReceptor,
+		},
+		Totales: models.TotalesXML{
+			MontoExento: int(math.Round(guia.MontoExento)),
+			MntTotal:    int64(math.Round(guia.MontoTotal)),
+		},
+	}
+
+	if guia.MontoNeto > 0 {
+		neto := int64(math.Round(guia.MontoNeto))
+		iva := int64(math.Round(guia.MontoIVA))
+		tasa := 19.0
+		encabezado.Totales.MntNeto = &neto
+		encabezado.Totales.TasaIVA = &tasa
+		encabezado.Totales.IVA = &iva
+	}
+
+	if transporte := construirTransporte(guia); transporte != nil {
+		encabezado.Transporte = transporte
+	}
+
+	dte := &models.DTEXMLModel{
+		Version: "1.0",
+		Documento: models.DocumentoXMLModel{
+			ID:         fmt.Sprintf("T%dF%d", models.TipoGuiaDespacho, guia.Folio),
+			Encabezado: encabezado,
+		},
+	}
+
+	for i, item := range guia.Items {
+		detalle := models.DetalleXML{
+			NroLinDet:    i + 1,
+			Nombre:       item.Nombre,
+			UnidadMedida: item.UnidadMedida,
+			MontoItem:    int64(math.Round(item.MontoItem)),
+		}
+		if item.Descripcion != "" {
+			descripcion := item.Descripcion
+			detalle.Descripcion = &descripcion
+		}
+		if item.Cantidad > 0 {
+			cantidad := item.Cantidad
+			detalle.Cantidad = &cantidad
+		}
+		// Los traslados que no son venta pueden omitir precios
+		if item.PrecioUnitario > 0 {
+			precio := item.PrecioUnitario
+			detalle.Precio = &precio
+		}
+		dte.Documento.Detalle = append(dte.Documento.Detalle, detalle)
+	}
+
+	for i, ref := range guia.Referencias {
+		dte.Documento.Referencias = append(dte.Documento.Referencias, models.ReferenciaXMLModel{
+			NroLinRef:  i + 1,
+			TipoDocRef: ref.TipoDocumento,
+			FolioRef:   strconv.Itoa(ref.Folio),
+			FechaRef:   ref.FechaReferencia.Format("2006-01-02"),
+			CodigoRef:  string(ref.TipoReferencia),
+			RazonRef:   ref.RazonReferencia,
+		})
+	}
+
+	return dte, nil
+}
+
+// construirTransporte arma el nodo Transporte; retorna nil si la guía no informa transporte
+func construirTransporte(guia *models.GuiaDespacho) *models.TransporteXML {
+	transporte := &models.TransporteXML{
+		Patente:    guia.Patente,
+		RUTTrans:   guia.RutTransportista,
+		DirDest:    guia.DireccionDestino,
+		CmnaDest:   guia.ComunaDestino,
+		CiudadDest: guia.CiudadDestino,
+	}
+	if guia.RutChofer != "" {
+		transporte.Chofer = &models.ChoferXML{RUTChofer: guia.RutChofer, NombreChofer: guia.NombreChofer}
+	}
+
+	if transporte.Patente == "" && transporte.RUTTrans == "" && transporte.Chofer == nil && transporte.DirDest == "" {
+		return nil
+	}
+	return transporte
+}
@@ -0,0 +1,154 @@
+package guias
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// coleccionDocumentos es la colección donde se almacenan guías y facturas emitidas
+const coleccionDocumentos = "documentos"
+
+// Folios asigna el siguiente folio autorizado de un tipo de documento
+type Folios interface {
+	SiguienteFolio(ctx context.Context, rutEmisor string, tipo models.TipoDTE) (int, error)
+}
+
+// Service gestiona la facturación de las guías de despacho
+type Service struct {
+	db     *mongo.Database
+	folios Folios
+}
+
+// NewService crea una nueva instancia del servicio de guías de despacho
+func NewService(db *mongo.Database, folios Folios) *Service {
+	return &Service{
+		db:     db,
+		folios: folios,
+	}
+}
+
+// ResultadoFacturacion contiene la factura emitida y los folios de las guías consolidadas
+type ResultadoFacturacion struct {
+	Factura *models.DocumentoTributario `json:"factura"`
+	Guias   []int                       `json:"guias"`
+}
+
+// FacturarGuias consolida en una factura las guías entregadas y aún no facturadas del receptor
+// en el período, y marca esas guías como facturadas en la misma transacción
+func (s *Service) FacturarGuias(ctx context.Context, params ParametrosFacturacion) (*ResultadoFacturacion, error) {
+	if params.FechaEmision.IsZero() {
+		params.FechaEmision = time.Now()
+	}
+	if err := params.Validar(); err != nil {
+		return nil, err
+	}
+
+	guias, err := s.guiasPorFacturar(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(guias) == 0 {
+		return nil, fmt.Errorf("no hay guías por facturar para %s en el período %s", params.RutReceptor, params.Periodo)
+	}
+
+	folio, err := s.folios.SiguienteFolio(ctx, params.RutEmisor, models.TipoFactura)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener folio de factura: %v", err)
+	}
+
+	factura, err := ConsolidarFactura(params, folio, guias)
+	if err != nil {
+		return nil, err
+	}
+	factura.CreatedAt = time.Now()
+	factura.UpdatedAt = factura.CreatedAt
+
+	ids := make([]string, 0, len(guias))
+	folios := make([]int, 0, len(guias))
+	for _, guia := range guias {
+		ids = append(ids, guia.ID)
+		folios = append(folios, guia.Folio)
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando sesión: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	err = mongo.WithSession(ctx, session, func(sessCtx mongo.SessionContext) error {
+		if err := session.StartTransaction(); err != nil {
+			return err
+		}
+
+		if _, err := s.db.Collection(coleccionDocumentos).InsertOne(sessCtx, factura); err != nil {
+			session.AbortTransaction(sessCtx)
+			return fmt.Errorf("error al guardar factura: %v", err)
+		}
+
+		// El filtro sobre folio_factura evita facturar dos veces una guía consolidada en paralelo
+		resultado, err := s.db.Collection(coleccionDocumentos).UpdateMany(
+			sessCtx,
+			bson.M{
+				"_id":           bson.M{"$in": ids},
+				"folio_factura": bson.M{"$exists": false},
+			},
+			bson.M{
+				"$set": bson.M{
+					"folio_factura":     factura.Folio,
+					"fecha_facturacion": factura.FechaEmision,
+					"updated_at":        time.Now(),
+				},
+			},
+		)
+		if err != nil {
+			session.AbortTransaction(sessCtx)
+			return fmt.Errorf("error al marcar guías facturadas: %v", err)
+		}
+		if resultado.ModifiedCount != int64(len(ids)) {
+			session.AbortTransaction(sessCtx)
+			return fmt.Errorf("otra facturación modificó las guías del período %s", params.Periodo)
+		}
+
+		return session.CommitTransaction(sessCtx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultadoFacturacion{Factura: factura, Guias: folios}, nil
+}
+
+// guiasPorFacturar obtiene las guías aceptadas del receptor en el período que constituyen
+// venta y no han sido facturadas
+func (s *Service) guiasPorFacturar(ctx context.Context, params ParametrosFacturacion) ([]models.GuiaDespacho, error) {
+	inicio, fin, err := params.Rango()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.db.Collection(coleccionDocumentos).Find(ctx, bson.M{
+		"tipo_documento":     models.TipoGuiaDespacho,
+		"rut_emisor":         params.RutEmisor,
+		"rut_receptor":       params.RutReceptor,
+		"fecha_emision":      bson.M{"$gte": inicio, "$lt": fin},
+		"indicador_traslado": models.IndTrasladoVenta,
+		"estado":             models.EstadoDTEAceptado,
+		"folio_factura":      bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar guías: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var guias []models.GuiaDespacho
+	if err := cursor.All(ctx, &guias); err != nil {
+		return nil, fmt.Errorf("error al decodificar guías: %v", err)
+	}
+	return guias, nil
+}
@@ -0,0 +1,170 @@
+package guias
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func guiaVenta(folio int, dia int, neto float64) models.GuiaDespacho {
+	guia := models.GuiaDespacho{
+		DocumentoTributario: models.DocumentoTributario{
+			ID:                  "guia-" + time.Date(2024, 3, dia, 0, 0, 0, 0, time.UTC).Format("0102"),
+			Folio:               folio,
+			FechaEmision:        time.Date(2024, 3, dia, 0, 0, 0, 0, time.UTC),
+			TipoDocumento:       models.TipoGuiaDespacho,
+			RUTEmisor:           "76212889-6",
+			RazonSocialEmisor:   "Distribuidora Sur SpA",
+			GiroEmisor:          "Distribución de alimentos",
+			RUTReceptor:         "77777777-7",
+			RazonSocialReceptor: "Supermercado Norte Ltda",
+			Estado:              models.EstadoDTEAceptado,
+		},
+		IndicadorTraslado: models.IndTrasladoVenta,
+		TipoDespacho:      models.TipoDespachoEmisorACliente,
+		Patente:           "ABCD12",
+		RutTransportista:  "76000000-0",
+		RutChofer:         "11111111-1",
+		NombreChofer:      "Juan Pérez",
+		DireccionDestino:  "Av. Norte 456",
+		ComunaDestino:     "Antofagasta",
+		MontoNeto:         neto,
+		MontoIVA:          neto * 0.19,
+		Items: []models.Item{
+			{Nombre: "Harina 25 kg", Cantidad: 10, UnidadMedida: "SAC", PrecioUnitario: neto / 10, MontoItem: neto},
+		},
+	}
+	guia.MontoTotal = guia.MontoNeto + guia.MontoIVA
+	return guia
+}
+
+func TestValidarGuia(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		modificar func(guia *models.GuiaDespacho)
+		error     string
+	}{
+		{"indicador inválido", func(guia *models.GuiaDespacho) { guia.IndicadorTraslado = "0" }, "indicador de traslado"},
+		{"tipo de despacho inválido", func(guia *models.GuiaDespacho) { guia.TipoDespacho = "4" }, "tipo de despacho"},
+		{"despacho del emisor sin patente", func(guia *models.GuiaDespacho) { guia.Patente = "" }, "patente"},
+		{"despacho del emisor sin chofer", func(guia *models.GuiaDespacho) { guia.NombreChofer = "" }, "chofer"},
+		{"traslado interno a otro RUT", func(guia *models.GuiaDespacho) { guia.IndicadorTraslado = models.IndTrasladoInterno }, "mismo emisor"},
+		{"venta sin montos", func(guia *models.GuiaDespacho) { guia.MontoTotal = 0 }, "montos"},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			guia := guiaVenta(10, 5, 100000)
+			caso.modificar(&guia)
+			assert.ErrorContains(t, Validar(&guia), caso.error)
+		})
+	}
+
+	// Un traslado interno retirado por el receptor no exige transporte ni montos
+	guia := guiaVenta(10, 5, 100000)
+	guia.IndicadorTraslado = models.IndTrasladoInterno
+	guia.RUTReceptor = "76.212.889-6"
+	guia.TipoDespacho = models.TipoDespachoReceptor
+	guia.Patente, guia.RutChofer, guia.NombreChofer = "", "", ""
+	guia.MontoNeto, guia.MontoIVA, guia.MontoTotal = 0, 0, 0
+	assert.NoError(t, Validar(&guia))
+}
+
+func TestConstruirDTEGuia(t *testing.T) {
+	guia := guiaVenta(10, 5, 100000)
+
+	dte, err := ConstruirDTE(&guia)
+	require.NoError(t, err)
+
+	data, err := xml.Marshal(dte)
+	require.NoError(t, err)
+	salida := string(data)
+
+	assert.Contains(t, salida, `<Documento ID="T52F10">`)
+	assert.Contains(t, salida, `<TipoDespacho>2</TipoDespacho><IndTraslado>1</IndTraslado>`)
+	assert.Contains(t, salida, `<Transporte><Patente>ABCD12</Patente><RUTTrans>76000000-0</RUTTrans>`+
+		`<Chofer><RUTChofer>11111111-1</RUTChofer><NombreChofer>Juan Pérez</NombreChofer></Chofer>`+
+		`<DirDest>Av. Norte 456</DirDest><CmnaDest>Antofagasta</CmnaDest></Transporte>`)
+	assert.Contains(t, salida, `<MntNeto>100000</MntNeto><TasaIVA>19</TasaIVA><IVA>19000</IVA><MntTotal>119000</MntTotal>`)
+	assert.Less(t, strings.Index(salida, "<Receptor>"), strings.Index(salida, "<Transporte>"))
+	assert.Less(t, strings.Index(salida, "<Transporte>"), strings.Index(salida, "<Totales>"))
+
+	// Sin datos de transporte no se genera el nodo
+	guia.TipoDespacho = models.TipoDespachoReceptor
+	guia.Patente, guia.RutTransportista, guia.RutChofer, guia.DireccionDestino = "", "", "", ""
+	dte, err = ConstruirDTE(&guia)
+	require.NoError(t, err)
+	assert.Nil(t, dte.Documento.Encabezado.Transporte)
+}
+
+func TestConsolidarFactura(t *testing.T) {
+	params := ParametrosFacturacion{
+		RutEmisor:    "76212889-6",
+		RutReceptor:  "77777777-7",
+		Periodo:      "2024-03",
+		FechaEmision: time.Date(2024, 4, 5, 0, 0, 0, 0, time.UTC),
+	}
+	exenta := guiaVenta(12, 20, 0)
+	exenta.MontoExento, exenta.MontoTotal = 5000, 5000
+	guias := []models.GuiaDespacho{guiaVenta(10, 5, 100000), guiaVenta(11, 12, 50001), exenta}
+
+	factura, err := ConsolidarFactura(params, 501, guias)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.TipoFactura, factura.TipoDocumento)
+	assert.Equal(t, 501, factura.Folio)
+	assert.Equal(t, "77777777-7", factura.RUTReceptor)
+	assert.Equal(t, 150001.0, factura.MontoNeto)
+	assert.Equal(t, 5000.0, factura.MontoExento)
+	assert.Equal(t, 28500.0, factura.MontoIVA)
+	assert.Equal(t, 183501.0, factura.MontoTotal)
+	require.Len(t, factura.Detalles, 3)
+	assert.Equal(t, "Guía de despacho N° 11 del 12/03/2024", factura.Detalles[1].Descripcion)
+	assert.True(t, factura.Detalles[2].Exento)
+
+	require.Len(t, factura.Referencias, 3)
+	for i, ref := range factura.Referencias {
+		assert.Equal(t, "52", ref.TipoDocumento)
+		assert.Equal(t, guias[i].Folio, ref.Folio)
+		assert.Equal(t, guias[i].FechaEmision, ref.FechaReferencia)
+	}
+}
+
+func TestConsolidarFacturaRechazaGuias(t *testing.T) {
+	params := ParametrosFacturacion{
+		RutEmisor:    "76212889-6",
+		RutReceptor:  "77777777-7",
+		Periodo:      "2024-03",
+		FechaEmision: time.Date(2024, 4, 5, 0, 0, 0, 0, time.UTC),
+	}
+
+	casos := []struct {
+		nombre    string
+		modificar func(guia *models.GuiaDespacho)
+		error     string
+	}{
+		{"ya facturada", func(guia *models.GuiaDespacho) { guia.FolioFactura = 400 }, "ya fue facturada"},
+		{"no constituye venta", func(guia *models.GuiaDespacho) { guia.IndicadorTraslado = models.IndTrasladoConsignacion }, "no constituye venta"},
+		{"otro receptor", func(guia *models.GuiaDespacho) { guia.RUTReceptor = "88888888-8" }, "receptor"},
+		{"fuera del período", func(guia *models.GuiaDespacho) { guia.FechaEmision = time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC) }, "período"},
+	}
+
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			guia := guiaVenta(10, 5, 100000)
+			caso.modificar(&guia)
+			_, err := ConsolidarFactura(params, 501, []models.GuiaDespacho{guia})
+			assert.ErrorContains(t, err, caso.error)
+		})
+	}
+
+	// La factura debe emitirse a más tardar el día 10 del mes siguiente
+	params.FechaEmision = time.Date(2024, 4, 11, 0, 0, 0, 0, time.UTC)
+	_, err := ConsolidarFactura(params, 501, []models.GuiaDespacho{guiaVenta(10, 5, 100000)})
+	assert.ErrorContains(t, err, "a más tardar el 10/04/2024")
+}
@@ -0,0 +1,142 @@
+package intercambio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// SolicitudAcuse contiene los datos de la recepción de mercaderías o servicios de un documento
+type SolicitudAcuse struct {
+	Recinto        string    `json:"recinto" binding:"required"`
+	RutFirma       string    `json:"rut_firma" binding:"required"`
+	FechaRecepcion time.Time `json:"fecha_recepcion"`
+	NmbContacto    string    `json:"nombre_contacto"`
+	FonoContacto   string    `json:"fono_contacto"`
+	MailContacto   string    `json:"mail_contacto"`
+}
+
+// Validar verifica los datos obligatorios del acuse
+func (s SolicitudAcuse) Validar() error {
+	if s.Recinto == "" {
+		return fmt.Errorf("el recinto de recepción es requerido")
+	}
+	if len([]rune(s.Recinto)) > 80 {
+		return fmt.Errorf("el recinto no puede exceder 80 caracteres")
+	}
+	if s.RutFirma == "" {
+		return fmt.Errorf("el RUT de quien firma el recibo es requerido")
+	}
+	return nil
+}
+
+// ConstruirRecibo arma el Recibo de un documento recibido. Se firma al incluirlo en el
+// EnvioRecibos con ConstruirEnvioRecibos.
+func ConstruirRecibo(documento *models.DocumentoTributario, solicitud SolicitudAcuse) models.ReciboXML {
+	return models.ReciboXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		DocumentoRecibo: models.DocumentoReciboXML{
+			ID:              fmt.Sprintf("Recibo_T%dF%d", int(documento.TipoDocumento), documento.Folio),
+			TipoDoc:         int(documento.TipoDocumento),
+			Folio:           int64(documento.Folio),
+			FchEmis:         documento.FechaEmision.Format("2006-01-02"),
+			RUTEmisor:       documento.RUTEmisor,
+			RUTRecep:        documento.RUTReceptor,
+			MntTotal:        int64(documento.MontoTotal),
+			Recinto:         solicitud.Recinto,
+			RutFirma:        solicitud.RutFirma,
+			Declaracion:     models.DeclaracionRecibo,
+			TmstFirmaRecibo: solicitud.FechaRecepcion.Format("2006-01-02T15:04:05"),
+		},
+	}
+}
+
+// ConstruirEnvioRecibos arma el EnvioRecibos en ISO-8859-1 con los recibos dirigidos a un mismo
+// emisor y lo firma. Cada Recibo se firma ya ubicado en el envío, con el mismo texto y los mismos
+// namespaces que el SetRecibos, y luego se firma el SetRecibos.
+func ConstruirEnvioRecibos(firmador Firmador, rutResponde, rutRecibe string, solicitud SolicitudAcuse, recibos []models.ReciboXML, ahora time.Time) ([]byte, error) {
+	if len(recibos) == 0 {
+		return nil, fmt.Errorf("el envío debe contener al menos un recibo")
+	}
+
+	envio := models.EnvioRecibosXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		SetRecibos: models.SetRecibosXML{
+			ID: "SetRecibos_" + ahora.Format("20060102150405"),
+			Caratula: models.CaratulaRecibosXML{
+				Version:      "1.0",
+				RutResponde:  rutResponde,
+				RutRecibe:    rutRecibe,
+				NmbContacto:  solicitud.NmbContacto,
+				FonoContacto: solicitud.FonoContacto,
+				MailContacto: solicitud.MailContacto,
+				TmstFirmaEnv: ahora.Format("2006-01-02T15:04:05"),
+			},
+		},
+	}
+
+	xmlData, err := xml.Marshal(envio)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar envío de recibos: %v", err)
+	}
+	if xmlData, err = xmldsig.CodificarLatin1(xmlData); err != nil {
+		return nil, fmt.Errorf("error al codificar envío de recibos: %v", err)
+	}
+	doc, err := xmldsig.ParseDocument(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	setRecibos := doc.Root().SelectElement("SetRecibos")
+
+	for _, recibo := range recibos {
+		nodo, err := agregarNodo(setRecibos, recibo)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := firmador.FirmarElemento(nodo.SelectElement("DocumentoRecibo"), recibo.DocumentoRecibo.ID); err != nil {
+			return nil, fmt.Errorf("error al firmar recibo: %v", err)
+		}
+	}
+	if _, err := firmador.FirmarElemento(setRecibos, envio.SetRecibos.ID); err != nil {
+		return nil, fmt.Errorf("error al firmar envío de recibos: %v", err)
+	}
+
+	return xmldsig.Serializar(doc)
+}
+
+// ExtraerRecibo retorna el Recibo firmado con el ID indicado desde un EnvioRecibos
+func ExtraerRecibo(envio []byte, id string) (string, error) {
+	doc, err := xmldsig.ParseDocument(envio)
+	if err != nil {
+		return "", err
+	}
+	documento := xmldsig.BuscarPorID(doc.Root(), id)
+	if documento == nil || documento.Parent() == nil || documento.Parent().Tag != "Recibo" {
+		return "", fmt.Errorf("el envío no contiene el recibo %s", id)
+	}
+
+	recibo := etree.NewDocument()
+	recibo.SetRoot(documento.Parent().Copy())
+	return recibo.WriteToString()
+}
+
+// agregarNodo serializa un nodo y lo agrega como último hijo del padre
+func agregarNodo(padre *etree.Element, nodo interface{}) (*etree.Element, error) {
+	xmlData, err := xml.Marshal(nodo)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar nodo: %v", err)
+	}
+	doc, err := xmldsig.ParseDocument(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	elemento := doc.Root()
+	padre.AddChild(elemento)
+	return elemento, nil
+}
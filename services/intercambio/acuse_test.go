@@ -0,0 +1,63 @@
+package intercambio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstruirEnvioRecibos(t *testing.T) {
+	documento := &models.DocumentoTributario{
+		Folio:         7,
+		FechaEmision:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		TipoDocumento: models.TipoFactura,
+		RUTEmisor:     "76212889-6",
+		RUTReceptor:   "11111111-1",
+		MontoTotal:    119000,
+	}
+	solicitud := SolicitudAcuse{
+		Recinto:        "Bodega Ñuñoa",
+		RutFirma:       "13195458-1",
+		FechaRecepcion: time.Date(2024, 3, 2, 8, 15, 0, 0, time.UTC),
+	}
+	require.NoError(t, solicitud.Validar())
+
+	recibo := ConstruirRecibo(documento, solicitud)
+	assert.Equal(t, "Recibo_T33F7", recibo.DocumentoRecibo.ID)
+
+	ahora := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	envio, err := ConstruirEnvioRecibos(firmantePrueba(t), "11111111-1", "76212889-6", solicitud, []models.ReciboXML{recibo}, ahora)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(envio, []byte("<Recinto>Bodega \xd1u\xf1oa</Recinto>")))
+
+	// Firma del Recibo y del SetRecibos
+	xml := verificarLatin1(t, envio)
+	assert.Equal(t, 2, strings.Count(xml, "<Signature "))
+	assert.Contains(t, xml, `<SetRecibos ID="SetRecibos_20240302090000"><Caratula version="1.0"><RutResponde>11111111-1</RutResponde><RutRecibe>76212889-6</RutRecibe><TmstFirmaEnv>2024-03-02T09:00:00</TmstFirmaEnv></Caratula><Recibo `)
+	assert.Contains(t, xml, `<DocumentoRecibo ID="Recibo_T33F7"><TipoDoc>33</TipoDoc><Folio>7</Folio><FchEmis>2024-03-01</FchEmis>`)
+	assert.Contains(t, xml, `<Recinto>Bodega Ñuñoa</Recinto><RutFirma>13195458-1</RutFirma><Declaracion>El acuse de recibo`)
+	assert.Contains(t, xml, `<TmstFirmaRecibo>2024-03-02T08:15:00</TmstFirmaRecibo></DocumentoRecibo><Signature `)
+	assert.Contains(t, xml, `</SetRecibos><Signature `)
+
+	// El Recibo extraído del envío conserva una firma válida
+	xmlRecibo, err := ExtraerRecibo(envio, "Recibo_T33F7")
+	require.NoError(t, err)
+	assert.Contains(t, xmlRecibo, "<Recinto>Bodega Ñuñoa</Recinto>")
+	_, err = xmldsig.VerificarDocumento([]byte(xmlRecibo))
+	require.NoError(t, err)
+
+	_, err = ExtraerRecibo(envio, "SetRecibos_20240302090000")
+	assert.Error(t, err)
+}
+
+func TestSolicitudAcuseValidar(t *testing.T) {
+	assert.Error(t, SolicitudAcuse{RutFirma: "13195458-1"}.Validar())
+	assert.Error(t, SolicitudAcuse{Recinto: "Bodega"}.Validar())
+	assert.Error(t, SolicitudAcuse{Recinto: strings.Repeat("x", 81), RutFirma: "13195458-1"}.Validar())
+}
@@ -0,0 +1,135 @@
+package intercambio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/utils"
+	"go.uber.org/zap"
+)
+
+// Subdirectorios del buzón donde se mueven los archivos revisados
+const (
+	DirectorioProcesados = "procesados"
+	DirectorioErrores    = "errores"
+)
+
+// BuzonEmpresa es el directorio donde se depositan los EnvioDTE dirigidos a una empresa
+type BuzonEmpresa struct {
+	RutReceptor string
+	Directorio  string
+	Firmador    Firmador
+}
+
+// Buzon revisa periódicamente los directorios de intercambio de las empresas
+type Buzon struct {
+	service   *Service
+	empresas  []BuzonEmpresa
+	intervalo time.Duration
+}
+
+// NewBuzon crea el lector de buzones con el intervalo de revisión indicado
+func NewBuzon(service *Service, empresas []BuzonEmpresa, intervalo time.Duration) *Buzon {
+	return &Buzon{
+		service:   service,
+		empresas:  empresas,
+		intervalo: intervalo,
+	}
+}
+
+// Iniciar bloquea revisando los buzones hasta que se cancele el contexto
+func (b *Buzon) Iniciar(ctx context.Context) {
+	ticker := time.NewTicker(b.intervalo)
+	defer ticker.Stop()
+
+	for {
+		b.Revisar(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Revisar procesa los archivos XML pendientes de todos los buzones
+func (b *Buzon) Revisar(ctx context.Context) {
+	for _, empresa := range b.empresas {
+		if err := b.revisarEmpresa(ctx, empresa); err != nil {
+			utils.LogError(err,
+				zap.String("proceso", "buzon_intercambio"),
+				zap.String("rut_receptor", empresa.RutReceptor),
+			)
+		}
+	}
+}
+
+// revisarEmpresa procesa los archivos del buzón de una empresa y los mueve según el resultado
+func (b *Buzon) revisarEmpresa(ctx context.Context, empresa BuzonEmpresa) error {
+	entradas, err := os.ReadDir(empresa.Directorio)
+	if err != nil {
+		return fmt.Errorf("error al leer buzón: %v", err)
+	}
+
+	for _, entrada := range entradas {
+		if entrada.IsDir() || !strings.EqualFold(filepath.Ext(entrada.Name()), ".xml") {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		ruta := filepath.Join(empresa.Directorio, entrada.Name())
+		destino := DirectorioProcesados
+		if err := b.procesarArchivo(ctx, empresa, ruta); err != nil {
+			destino = DirectorioErrores
+			utils.LogError(err,
+				zap.String("proceso", "buzon_intercambio"),
+				zap.String("rut_receptor", empresa.RutReceptor),
+				zap.String("archivo", entrada.Name()),
+			)
+		}
+
+		if err := moverArchivo(ruta, filepath.Join(empresa.Directorio, destino)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// procesarArchivo recibe un EnvioDTE depositado en el buzón
+func (b *Buzon) procesarArchivo(ctx context.Context, empresa BuzonEmpresa, ruta string) error {
+	data, err := os.ReadFile(ruta)
+	if err != nil {
+		return fmt.Errorf("error al leer archivo: %v", err)
+	}
+
+	intercambio, err := b.service.RecibirEnvio(ctx, empresa.Firmador, empresa.RutReceptor, filepath.Base(ruta), data)
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfo("envío de proveedor recibido",
+		zap.String("rut_receptor", empresa.RutReceptor),
+		zap.String("rut_emisor", intercambio.RutEmisor),
+		zap.Int("estado_recep_env", intercambio.EstadoRecepEnv),
+		zap.Int("documentos", len(intercambio.Documentos)),
+	)
+	return nil
+}
+
+// moverArchivo mueve el archivo al directorio indicado, creándolo si no existe
+func moverArchivo(ruta, directorio string) error {
+	if err := os.MkdirAll(directorio, 0755); err != nil {
+		return fmt.Errorf("error al crear directorio %s: %v", directorio, err)
+	}
+	if err := os.Rename(ruta, filepath.Join(directorio, filepath.Base(ruta))); err != nil {
+		return fmt.Errorf("error al mover archivo: %v", err)
+	}
+	return nil
+}
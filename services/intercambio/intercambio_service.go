@@ -0,0 +1,411 @@
+package intercambio
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones utilizadas por el servicio de intercambio
+const (
+	ColeccionIntercambios        = "intercambios_dte"
+	ColeccionDocumentosRecibidos = "documentos_recibidos"
+	ColeccionAcuses              = "acuses_recibo"
+)
+
+// Claves de los esquemas en el validador XML
+const (
+	SchemaEnvioDTE     = "EnvioDTE"
+	SchemaRespuestaDTE = "RespuestaEnvioDTE"
+	SchemaEnvioRecibos = "EnvioRecibos"
+)
+
+// Validador valida un documento XML contra un esquema registrado
+type Validador interface {
+	ValidateXML(xmlData []byte, schemaType string) error
+}
+
+// LlavesSII entrega la llave pública con que el SII firma los CAF de un ambiente
+type LlavesSII interface {
+	Llave(ambiente models.AmbienteSII, idk int) (*rsa.PublicKey, error)
+}
+
+// Ambientes entrega el ambiente del SII en que opera una empresa
+type Ambientes interface {
+	AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error)
+}
+
+// Service recibe los EnvioDTE de proveedores y genera las respuestas de intercambio
+type Service struct {
+	db        *mongo.Database
+	validador Validador
+	llaves    LlavesSII
+	ambientes Ambientes
+}
+
+// NewService crea una nueva instancia del servicio de intercambio
+func NewService(db *mongo.Database, validador Validador) *Service {
+	return &Service{
+		db:        db,
+		validador: validador,
+	}
+}
+
+// SetLlavesSII configura las llaves del SII con que se verifican los CAF de los timbres
+// recibidos, según el ambiente de la empresa receptora. Sin ellas todo timbre se rechaza.
+func (s *Service) SetLlavesSII(llaves LlavesSII, ambientes Ambientes) {
+	s.llaves = llaves
+	s.ambientes = ambientes
+}
+
+// RecibirEnvio revisa un EnvioDTE recibido, almacena los documentos recibidos conforme y
+// genera la respuesta RecepcionEnvio firmada con el certificado de la empresa receptora
+func (s *Service) RecibirEnvio(ctx context.Context, firmador Firmador, rutReceptor, nombreArchivo string, data []byte) (*models.IntercambioDTE, error) {
+	llaves, err := s.llavesCAF(rutReceptor)
+	if err != nil {
+		return nil, err
+	}
+	analisis := AnalizarEnvio(data, rutReceptor, llaves)
+
+	if analisis.Estado == models.EstadoRecepEnvOK && s.validador != nil {
+		if err := s.validador.ValidateXML(data, SchemaEnvioDTE); err != nil {
+			analisis.Estado = models.EstadoRecepEnvSchema
+			analisis.Glosa = fmt.Sprintf("Error de schema: %v", err)
+			analisis.Documentos = nil
+		}
+	}
+
+	if analisis.Estado == models.EstadoRecepEnvOK {
+		repetido, err := s.envioRepetido(ctx, rutReceptor, analisis)
+		if err != nil {
+			return nil, err
+		}
+		if repetido {
+			analisis.Estado = models.EstadoRecepEnvRepetido
+			analisis.Glosa = "Envío repetido"
+			analisis.Documentos = nil
+		}
+	}
+
+	codEnvio, err := s.siguienteCodEnvio(ctx, rutReceptor)
+	if err != nil {
+		return nil, err
+	}
+
+	ahora := time.Now()
+	intercambio := &models.IntercambioDTE{
+		ID:             models.GenerateID(),
+		CodEnvio:       codEnvio,
+		RutReceptor:    rutReceptor,
+		RutEmisor:      analisis.RutEmisor,
+		NombreArchivo:  nombreArchivo,
+		EnvioDTEID:     analisis.EnvioDTEID,
+		Digest:         analisis.Digest,
+		EstadoRecepEnv: analisis.Estado,
+		Glosa:          analisis.Glosa,
+		FechaRecepcion: ahora,
+		CreatedAt:      ahora,
+		UpdatedAt:      ahora,
+	}
+
+	for _, doc := range analisis.Documentos {
+		recepcion := doc.Recepcion
+		if recepcion.EstadoRecepDTE == models.EstadoRecepDTEOK {
+			if recepcion, err = s.guardarDocumento(ctx, recepcion, doc.Documento); err != nil {
+				return nil, err
+			}
+		}
+		intercambio.Documentos = append(intercambio.Documentos, recepcion)
+	}
+
+	xmlRecepcion, err := s.firmarRespuesta(firmador, ConstruirRecepcionEnvio(intercambio, ahora))
+	if err != nil {
+		return nil, err
+	}
+	intercambio.XMLRecepcion = string(xmlRecepcion)
+
+	if _, err := s.db.Collection(ColeccionIntercambios).InsertOne(ctx, intercambio); err != nil {
+		return nil, fmt.Errorf("error al guardar intercambio: %v", err)
+	}
+
+	return intercambio, nil
+}
+
+// llavesCAF retorna las llaves del SII del ambiente en que opera la empresa receptora
+func (s *Service) llavesCAF(rutReceptor string) (LlavesCAF, error) {
+	if s.llaves == nil || s.ambientes == nil {
+		return nil, nil
+	}
+	ambiente, err := s.ambientes.AmbienteEmpresa(rutReceptor)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener ambiente del receptor: %w", err)
+	}
+	return func(idk int) (*rsa.PublicKey, error) {
+		return s.llaves.Llave(ambiente, idk)
+	}, nil
+}
+
+// ResponderDocumentos genera la respuesta ResultadoDTE con la aprobación o rechazo comercial
+// de los documentos del envío
+func (s *Service) ResponderDocumentos(ctx context.Context, firmador Firmador, intercambioID string, decisiones []DecisionDTE) (*models.IntercambioDTE, error) {
+	intercambio, err := s.ObtenerIntercambio(ctx, intercambioID)
+	if err != nil {
+		return nil, err
+	}
+	if intercambio.EstadoRecepEnv != models.EstadoRecepEnvOK {
+		return nil, fmt.Errorf("el envío %s fue rechazado en la recepción (estado %d)", intercambioID, intercambio.EstadoRecepEnv)
+	}
+
+	ahora := time.Now()
+	respuesta, err := ConstruirResultadoDTE(intercambio, decisiones, ahora)
+	if err != nil {
+		return nil, err
+	}
+	xmlResultado, err := s.firmarRespuesta(firmador, respuesta)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range intercambio.Documentos {
+		resultado := respuesta.Resultado.ResultadoDTE[i]
+		intercambio.Documentos[i].EstadoDTE = &resultado.EstadoDTE
+		intercambio.Documentos[i].GlosaResultado = resultado.EstadoDTEGlosa
+	}
+	intercambio.XMLResultado = string(xmlResultado)
+	intercambio.UpdatedAt = ahora
+
+	if _, err := s.db.Collection(ColeccionIntercambios).UpdateOne(ctx,
+		bson.M{"_id": intercambio.ID},
+		bson.M{"$set": bson.M{
+			"documentos":    intercambio.Documentos,
+			"xml_resultado": intercambio.XMLResultado,
+			"updated_at":    ahora,
+		}},
+	); err != nil {
+		return nil, fmt.Errorf("error al actualizar intercambio: %v", err)
+	}
+
+	return intercambio, nil
+}
+
+// ObtenerIntercambio obtiene un intercambio por su ID
+func (s *Service) ObtenerIntercambio(ctx context.Context, id string) (*models.IntercambioDTE, error) {
+	var intercambio models.IntercambioDTE
+	if err := s.db.Collection(ColeccionIntercambios).FindOne(ctx, bson.M{"_id": id}).Decode(&intercambio); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("intercambio no encontrado: %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener intercambio: %v", err)
+	}
+	return &intercambio, nil
+}
+
+// RegistrarAcuse marca un documento recibido como entregado, genera el Recibo y el EnvioRecibos
+// firmados y vincula el acuse al documento
+func (s *Service) RegistrarAcuse(ctx context.Context, firmador Firmador, documentoID string, solicitud SolicitudAcuse, usuario, ip string) (*models.AcuseRecibo, error) {
+	if err := solicitud.Validar(); err != nil {
+		return nil, err
+	}
+
+	var documento models.DocumentoTributario
+	if err := s.db.Collection(ColeccionDocumentosRecibidos).FindOne(ctx, bson.M{"_id": documentoID}).Decode(&documento); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("documento recibido no encontrado: %s", documentoID)
+		}
+		return nil, fmt.Errorf("error al obtener documento recibido: %v", err)
+	}
+	if documento.AcuseReciboID != "" {
+		return nil, fmt.Errorf("el documento %s ya tiene acuse de recibo %s", documentoID, documento.AcuseReciboID)
+	}
+
+	rechazados, err := s.db.Collection(ColeccionIntercambios).CountDocuments(ctx, bson.M{
+		"documentos": bson.M{"$elemMatch": bson.M{
+			"documento_id": documentoID,
+			"estado_dte":   models.EstadoResultadoRechazado,
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al consultar resultado comercial: %v", err)
+	}
+	if rechazados > 0 {
+		return nil, fmt.Errorf("el documento %s fue rechazado comercialmente", documentoID)
+	}
+
+	ahora := time.Now()
+	if solicitud.FechaRecepcion.IsZero() {
+		solicitud.FechaRecepcion = ahora
+	}
+
+	recibo := ConstruirRecibo(&documento, solicitud)
+	envio, err := ConstruirEnvioRecibos(firmador, documento.RUTReceptor, documento.RUTEmisor, solicitud, []models.ReciboXML{recibo}, ahora)
+	if err != nil {
+		return nil, err
+	}
+	xmlRecibo, err := ExtraerRecibo(envio, recibo.DocumentoRecibo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if s.validador != nil {
+		if err := s.validador.ValidateXML(envio, SchemaEnvioRecibos); err != nil {
+			return nil, fmt.Errorf("envío de recibos no cumple el esquema: %v", err)
+		}
+	}
+
+	acuse := &models.AcuseRecibo{
+		ID:              models.GenerateID(),
+		DocumentoID:     documentoID,
+		TipoDTE:         int(documento.TipoDocumento),
+		Folio:           int64(documento.Folio),
+		RutEmisor:       documento.RUTEmisor,
+		RutReceptor:     documento.RUTReceptor,
+		MontoTotal:      int64(documento.MontoTotal),
+		Recinto:         solicitud.Recinto,
+		RutFirma:        solicitud.RutFirma,
+		FechaRecepcion:  solicitud.FechaRecepcion,
+		XMLRecibo:       xmlRecibo,
+		XMLEnvioRecibos: string(envio),
+		Historial: []models.EventoAcuse{
+			{Fecha: ahora, Accion: models.AccionAcuseRegistrado, Usuario: usuario, IP: ip, Detalle: "Recepción en " + solicitud.Recinto},
+			{Fecha: ahora, Accion: models.AccionAcuseFirmado, Usuario: usuario, IP: ip, Detalle: "Recibo firmado por " + solicitud.RutFirma},
+		},
+		CreatedAt: ahora,
+		UpdatedAt: ahora,
+	}
+
+	// Se reserva el documento antes de guardar el acuse para no generar dos acuses del mismo documento
+	resultado, err := s.db.Collection(ColeccionDocumentosRecibidos).UpdateOne(ctx,
+		bson.M{"_id": documentoID, "acuse_recibo_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"acuse_recibo_id": acuse.ID, "updated_at": ahora}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error al vincular acuse al documento: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return nil, fmt.Errorf("el documento %s ya tiene acuse de recibo", documentoID)
+	}
+
+	if _, err := s.db.Collection(ColeccionAcuses).InsertOne(ctx, acuse); err != nil {
+		s.db.Collection(ColeccionDocumentosRecibidos).UpdateOne(ctx,
+			bson.M{"_id": documentoID},
+			bson.M{"$unset": bson.M{"acuse_recibo_id": ""}},
+		)
+		return nil, fmt.Errorf("error al guardar acuse de recibo: %v", err)
+	}
+
+	return acuse, nil
+}
+
+// ObtenerAcuse obtiene un acuse de recibo por su ID
+func (s *Service) ObtenerAcuse(ctx context.Context, id string) (*models.AcuseRecibo, error) {
+	var acuse models.AcuseRecibo
+	if err := s.db.Collection(ColeccionAcuses).FindOne(ctx, bson.M{"_id": id}).Decode(&acuse); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("acuse de recibo no encontrado: %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener acuse de recibo: %v", err)
+	}
+	return &acuse, nil
+}
+
+// DescargarAcuse retorna el EnvioRecibos firmado y registra la descarga en el historial
+func (s *Service) DescargarAcuse(ctx context.Context, id, usuario, ip string) (string, error) {
+	acuse, err := s.ObtenerAcuse(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	ahora := time.Now()
+	evento := models.EventoAcuse{Fecha: ahora, Accion: models.AccionAcuseDescargado, Usuario: usuario, IP: ip}
+	if _, err := s.db.Collection(ColeccionAcuses).UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$push": bson.M{"historial": evento},
+			"$set":  bson.M{"updated_at": ahora},
+		},
+	); err != nil {
+		return "", fmt.Errorf("error al registrar descarga del acuse: %v", err)
+	}
+
+	return acuse.XMLEnvioRecibos, nil
+}
+
+// envioRepetido indica si el mismo envío ya fue recibido conforme
+func (s *Service) envioRepetido(ctx context.Context, rutReceptor string, analisis *EnvioAnalizado) (bool, error) {
+	cantidad, err := s.db.Collection(ColeccionIntercambios).CountDocuments(ctx, bson.M{
+		"rut_receptor":     rutReceptor,
+		"rut_emisor":       analisis.RutEmisor,
+		"envio_dte_id":     analisis.EnvioDTEID,
+		"digest":           analisis.Digest,
+		"estado_recep_env": models.EstadoRecepEnvOK,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error al buscar envíos anteriores: %v", err)
+	}
+	return cantidad > 0, nil
+}
+
+// siguienteCodEnvio asigna el correlativo de recepción de la empresa receptora
+func (s *Service) siguienteCodEnvio(ctx context.Context, rutReceptor string) (int64, error) {
+	var ultimo models.IntercambioDTE
+	err := s.db.Collection(ColeccionIntercambios).FindOne(ctx,
+		bson.M{"rut_receptor": rutReceptor},
+		options.FindOne().SetSort(bson.M{"cod_envio": -1}),
+	).Decode(&ultimo)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, fmt.Errorf("error al obtener último código de envío: %v", err)
+	}
+	return ultimo.CodEnvio + 1, nil
+}
+
+// guardarDocumento almacena un documento recibido, marcándolo como repetido si ya existía
+func (s *Service) guardarDocumento(ctx context.Context, recepcion models.DTERecibido, documento *models.DocumentoTributario) (models.DTERecibido, error) {
+	coleccion := s.db.Collection(ColeccionDocumentosRecibidos)
+
+	var existente models.DocumentoTributario
+	err := coleccion.FindOne(ctx, bson.M{
+		"rut_emisor":     documento.RUTEmisor,
+		"rut_receptor":   documento.RUTReceptor,
+		"tipo_documento": documento.TipoDocumento,
+		"folio":          documento.Folio,
+	}).Decode(&existente)
+	if err == nil {
+		recepcion.DocumentoID = existente.ID
+		recepcion.EstadoRecepDTE = models.EstadoRecepDTERepetido
+		recepcion.Glosa = "DTE repetido"
+		return recepcion, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return recepcion, fmt.Errorf("error al buscar documento recibido: %v", err)
+	}
+
+	ahora := time.Now()
+	documento.ID = models.GenerateID()
+	documento.CreatedAt = ahora
+	documento.UpdatedAt = ahora
+	if _, err := coleccion.InsertOne(ctx, documento); err != nil {
+		return recepcion, fmt.Errorf("error al guardar documento recibido: %v", err)
+	}
+
+	recepcion.DocumentoID = documento.ID
+	return recepcion, nil
+}
+
+// firmarRespuesta firma la respuesta y la valida contra el esquema
+func (s *Service) firmarRespuesta(firmador Firmador, respuesta *models.RespuestaDTEXML) ([]byte, error) {
+	firmado, err := FirmarRespuesta(firmador, respuesta)
+	if err != nil {
+		return nil, err
+	}
+	if s.validador != nil {
+		if err := s.validador.ValidateXML(firmado, SchemaRespuestaDTE); err != nil {
+			return nil, fmt.Errorf("respuesta no cumple el esquema: %v", err)
+		}
+	}
+	return firmado, nil
+}
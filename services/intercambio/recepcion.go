@@ -0,0 +1,370 @@
+package intercambio
+
+import (
+	"crypto/rsa"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// LlavesCAF entrega la llave pública del SII identificada por el IDK de un CAF
+type LlavesCAF func(idk int) (*rsa.PublicKey, error)
+
+// EnvioAnalizado contiene el resultado de la revisión técnica de un EnvioDTE recibido
+type EnvioAnalizado struct {
+	EnvioDTEID  string
+	RutEmisor   string
+	RutEnvia    string
+	RutReceptor string
+	Digest      string
+	Estado      int
+	Glosa       string
+	Documentos  []DocumentoAnalizado
+}
+
+// DocumentoAnalizado contiene el resultado de la revisión de un DTE del envío y, si es legible,
+// el documento convertido al modelo interno
+type DocumentoAnalizado struct {
+	Recepcion models.DTERecibido
+	Documento *models.DocumentoTributario
+}
+
+// dteRecibido contiene los campos del DTE que se almacenan al recibirlo
+type dteRecibido struct {
+	Documento struct {
+		ID         string `xml:"ID,attr"`
+		Encabezado struct {
+			IdDoc struct {
+				TipoDTE int    `xml:"TipoDTE"`
+				Folio   int64  `xml:"Folio"`
+				FchEmis string `xml:"FchEmis"`
+			} `xml:"IdDoc"`
+			Emisor struct {
+				RUTEmisor  string `xml:"RUTEmisor"`
+				RznSoc     string `xml:"RznSoc"`
+				GiroEmis   string `xml:"GiroEmis"`
+				DirOrigen  string `xml:"DirOrigen"`
+				CmnaOrigen string `xml:"CmnaOrigen"`
+			} `xml:"Emisor"`
+			Receptor struct {
+				RUTRecep    string `xml:"RUTRecep"`
+				RznSocRecep string `xml:"RznSocRecep"`
+				GiroRecep   string `xml:"GiroRecep"`
+				DirRecep    string `xml:"DirRecep"`
+				CmnaRecep   string `xml:"CmnaRecep"`
+			} `xml:"Receptor"`
+			Totales struct {
+				MntNeto    float64 `xml:"MntNeto"`
+				MntExe     float64 `xml:"MntExe"`
+				TasaIVA    float64 `xml:"TasaIVA"`
+				IVA        float64 `xml:"IVA"`
+				ImptoReten []struct {
+					TipoImp  string  `xml:"TipoImp"`
+					TasaImp  float64 `xml:"TasaImp"`
+					MontoImp float64 `xml:"MontoImp"`
+				} `xml:"ImptoReten"`
+				MntTotal int64 `xml:"MntTotal"`
+			} `xml:"Totales"`
+		} `xml:"Encabezado"`
+		Detalle []struct {
+			IndExe    int     `xml:"IndExe"`
+			NmbItem   string  `xml:"NmbItem"`
+			QtyItem   float64 `xml:"QtyItem"`
+			PrcItem   float64 `xml:"PrcItem"`
+			MontoItem float64 `xml:"MontoItem"`
+		} `xml:"Detalle"`
+	} `xml:"Documento"`
+}
+
+// AnalizarEnvio revisa la legibilidad, la firma del sobre, el receptor y cada DTE del envío
+// (firma, emisor, receptor y timbre). Las firmas deben cubrir el mismo SetDTE y Documento que
+// se procesan y estar hechas con un certificado del RUT que envía o del emisor; un envío con
+// IDs repetidos se rechaza para que una firma no pueda validar un elemento distinto del leído.
+// El CAF de cada timbre se verifica con las llaves del SII; sin ellas el timbre se rechaza.
+// No revisa esquema ni duplicados, que dependen del validador y de los documentos ya almacenados.
+func AnalizarEnvio(data []byte, rutReceptor string, llaves LlavesCAF) *EnvioAnalizado {
+	analisis := &EnvioAnalizado{Estado: models.EstadoRecepEnvOK, Glosa: "Envío recibido conforme"}
+
+	doc, err := xmldsig.ParseDocument(data)
+	if err != nil || doc.Root().Tag != "EnvioDTE" {
+		analisis.Estado = models.EstadoRecepEnvIlegible
+		analisis.Glosa = "Archivo ilegible o no corresponde a un EnvioDTE"
+		return analisis
+	}
+
+	setDTE := doc.Root().SelectElement("SetDTE")
+	if setDTE == nil {
+		analisis.Estado = models.EstadoRecepEnvIlegible
+		analisis.Glosa = "El envío no contiene SetDTE"
+		return analisis
+	}
+	analisis.EnvioDTEID = setDTE.SelectAttrValue("ID", "")
+	if caratula := setDTE.SelectElement("Caratula"); caratula != nil {
+		analisis.RutEmisor = textoHijo(caratula, "RutEmisor")
+		analisis.RutEnvia = textoHijo(caratula, "RutEnvia")
+		analisis.RutReceptor = textoHijo(caratula, "RutReceptor")
+	}
+
+	if id := xmldsig.IDDuplicado(doc.Root()); id != "" {
+		analisis.Estado = models.EstadoRecepEnvFirma
+		analisis.Glosa = fmt.Sprintf("El ID %s está repetido en el envío", id)
+		return analisis
+	}
+
+	firmaSobre := firmaHija(doc.Root())
+	if firmaSobre == nil {
+		analisis.Estado = models.EstadoRecepEnvFirma
+		analisis.Glosa = "El envío no está firmado"
+		return analisis
+	}
+	if digest := firmaSobre.FindElement("SignedInfo/Reference/DigestValue"); digest != nil {
+		analisis.Digest = strings.TrimSpace(digest.Text())
+	}
+	resultado, err := xmldsig.VerificarFirma(doc, firmaSobre)
+	if err == nil && resultado.Elemento != setDTE {
+		err = fmt.Errorf("la firma referencia %q y no el SetDTE", resultado.Referencia)
+	}
+	if err == nil {
+		err = verificarFirmante(resultado, analisis.RutEnvia, analisis.RutEmisor)
+	}
+	if err != nil {
+		analisis.Estado = models.EstadoRecepEnvFirma
+		analisis.Glosa = fmt.Sprintf("Error de firma del envío: %v", err)
+		return analisis
+	}
+
+	if analisis.RutReceptor != rutReceptor {
+		analisis.Estado = models.EstadoRecepEnvRutReceptor
+		analisis.Glosa = fmt.Sprintf("El RUT receptor %s no corresponde a %s", analisis.RutReceptor, rutReceptor)
+		return analisis
+	}
+
+	for _, dte := range setDTE.SelectElements("DTE") {
+		analisis.Documentos = append(analisis.Documentos, analizarDTE(doc, dte, analisis.RutEmisor, analisis.RutEnvia, rutReceptor, llaves))
+	}
+	if len(analisis.Documentos) == 0 {
+		analisis.Estado = models.EstadoRecepEnvOtros
+		analisis.Glosa = "El envío no contiene documentos"
+	}
+
+	return analisis
+}
+
+// analizarDTE revisa un DTE del envío y lo convierte al modelo interno. Los datos se leen del
+// mismo Documento cuya firma se verifica.
+func analizarDTE(doc *etree.Document, dte *etree.Element, rutEmisor, rutEnvia, rutReceptor string, llaves LlavesCAF) DocumentoAnalizado {
+	ilegible := func(glosa string) DocumentoAnalizado {
+		return DocumentoAnalizado{Recepcion: models.DTERecibido{
+			EstadoRecepDTE: models.EstadoRecepDTEOtros,
+			Glosa:          glosa,
+		}}
+	}
+
+	documentos := dte.SelectElements("Documento")
+	if len(documentos) != 1 {
+		return ilegible(fmt.Sprintf("DTE ilegible: contiene %d elementos Documento", len(documentos)))
+	}
+	documento := documentos[0]
+
+	var origen dteRecibido
+	if err := xml.Unmarshal(xmldsig.Canonicalize(documento), &origen.Documento); err != nil {
+		return ilegible(fmt.Sprintf("DTE ilegible: %v", err))
+	}
+	xmlDTE := string(xmldsig.Canonicalize(dte))
+
+	enc := origen.Documento.Encabezado
+	analizado := DocumentoAnalizado{
+		Recepcion: models.DTERecibido{
+			TipoDTE:        enc.IdDoc.TipoDTE,
+			Folio:          enc.IdDoc.Folio,
+			FchEmis:        enc.IdDoc.FchEmis,
+			RutEmisor:      enc.Emisor.RUTEmisor,
+			RutReceptor:    enc.Receptor.RUTRecep,
+			MontoTotal:     enc.Totales.MntTotal,
+			EstadoRecepDTE: models.EstadoRecepDTEOK,
+			Glosa:          "DTE recibido OK",
+		},
+	}
+
+	rechazar := func(estado int, glosa string) DocumentoAnalizado {
+		analizado.Recepcion.EstadoRecepDTE = estado
+		analizado.Recepcion.Glosa = glosa
+		return analizado
+	}
+
+	firma := firmaHija(dte)
+	if firma == nil {
+		return rechazar(models.EstadoRecepDTEFirma, "El DTE no está firmado")
+	}
+	resultado, err := xmldsig.VerificarFirma(doc, firma)
+	if err == nil && resultado.Elemento != documento {
+		err = fmt.Errorf("la firma referencia %q y no el Documento", resultado.Referencia)
+	}
+	if err == nil {
+		err = verificarFirmante(resultado, rutEnvia, rutEmisor)
+	}
+	if err != nil {
+		return rechazar(models.EstadoRecepDTEFirma, fmt.Sprintf("Error de firma del DTE: %v", err))
+	}
+
+	if enc.Emisor.RUTEmisor != rutEmisor {
+		return rechazar(models.EstadoRecepDTERutEmisor, fmt.Sprintf("El RUT emisor %s no corresponde al del envío", enc.Emisor.RUTEmisor))
+	}
+	if enc.Receptor.RUTRecep != rutReceptor {
+		return rechazar(models.EstadoRecepDTERutReceptor, fmt.Sprintf("El RUT receptor %s no corresponde a %s", enc.Receptor.RUTRecep, rutReceptor))
+	}
+
+	if err := verificarTimbre(dte, analizado.Recepcion, llaves); err != nil {
+		return rechazar(models.EstadoRecepDTEOtros, fmt.Sprintf("Timbre electrónico inválido: %v", err))
+	}
+
+	analizado.Documento = convertirDocumento(origen, xmlDTE)
+	return analizado
+}
+
+// verificarFirmante exige que la firma traiga un certificado de alguno de los RUT indicados
+func verificarFirmante(resultado *xmldsig.Resultado, ruts ...string) error {
+	if resultado.Certificado == nil {
+		return fmt.Errorf("la firma no incluye el certificado del firmante")
+	}
+	for _, rut := range ruts {
+		if xmldsig.CertificadoDeRut(resultado.Certificado, rut) {
+			return nil
+		}
+	}
+	return fmt.Errorf("el certificado de %s no corresponde al RUT que envía ni al emisor", resultado.Certificado.Subject.CommonName)
+}
+
+// verificarTimbre valida el CAF y el TED, y que sus datos coincidan con el encabezado del DTE
+func verificarTimbre(dte *etree.Element, recepcion models.DTERecibido, llaves LlavesCAF) error {
+	nodo := dte.FindElement("Documento/TED")
+	if nodo == nil {
+		return fmt.Errorf("el DTE no contiene TED")
+	}
+	if err := verificarCAF(nodo, llaves); err != nil {
+		return err
+	}
+	datos, err := ted.Verificar(nodo)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case datos.RutEmisor != recepcion.RutEmisor:
+		return fmt.Errorf("el RUT emisor del timbre no coincide")
+	case datos.TipoDTE != recepcion.TipoDTE:
+		return fmt.Errorf("el tipo de documento del timbre no coincide")
+	case datos.Folio != recepcion.Folio:
+		return fmt.Errorf("el folio del timbre no coincide")
+	case datos.RutReceptor != recepcion.RutReceptor:
+		return fmt.Errorf("el RUT receptor del timbre no coincide")
+	case datos.MontoTotal != recepcion.MontoTotal:
+		return fmt.Errorf("el monto total del timbre no coincide")
+	}
+	return nil
+}
+
+// verificarCAF comprueba la firma FRMA del CAF incluido en el timbre con la llave del SII de
+// su IDK, antes de confiar en la llave RSAPK con que se verifica la firma FRMT
+func verificarCAF(nodo *etree.Element, llaves LlavesCAF) error {
+	if llaves == nil {
+		return fmt.Errorf("no hay llaves del SII para verificar el CAF")
+	}
+	nodoCAF := nodo.FindElement("DD/CAF")
+	if nodoCAF == nil {
+		return fmt.Errorf("el timbre no contiene el CAF")
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(nodoCAF.Copy())
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return fmt.Errorf("error al serializar el CAF: %v", err)
+	}
+	autorizacion, err := caf.ParseAutorizacion(data, "", "")
+	if err != nil {
+		return err
+	}
+	llaveSII, err := llaves(autorizacion.IDK)
+	if err != nil {
+		return fmt.Errorf("CAF no verificable: %w", err)
+	}
+	if err := autorizacion.VerificarFirma(llaveSII); err != nil {
+		return fmt.Errorf("CAF no autorizado por el SII: %w", err)
+	}
+	return nil
+}
+
+// convertirDocumento construye el documento recibido a partir del DTE
+func convertirDocumento(origen dteRecibido, xmlDTE string) *models.DocumentoTributario {
+	enc := origen.Documento.Encabezado
+	fechaEmision, _ := time.Parse("2006-01-02", enc.IdDoc.FchEmis)
+
+	documento := &models.DocumentoTributario{
+		Folio:               int(enc.IdDoc.Folio),
+		FechaEmision:        fechaEmision,
+		TipoDocumento:       models.TipoDTE(enc.IdDoc.TipoDTE),
+		TipoDTE:             strconv.Itoa(enc.IdDoc.TipoDTE),
+		RUTEmisor:           enc.Emisor.RUTEmisor,
+		RazonSocialEmisor:   enc.Emisor.RznSoc,
+		GiroEmisor:          enc.Emisor.GiroEmis,
+		DireccionEmisor:     enc.Emisor.DirOrigen,
+		ComunaEmisor:        enc.Emisor.CmnaOrigen,
+		RUTReceptor:         enc.Receptor.RUTRecep,
+		RazonSocialReceptor: enc.Receptor.RznSocRecep,
+		GiroReceptor:        enc.Receptor.GiroRecep,
+		DireccionReceptor:   enc.Receptor.DirRecep,
+		ComunaReceptor:      enc.Receptor.CmnaRecep,
+		MontoNeto:           enc.Totales.MntNeto,
+		MontoExento:         enc.Totales.MntExe,
+		MontoIVA:            enc.Totales.IVA,
+		TasaIVA:             enc.Totales.TasaIVA,
+		MontoTotal:          float64(enc.Totales.MntTotal),
+		Estado:              models.EstadoDTERecibido,
+		XML:                 xmlDTE,
+	}
+
+	for _, imp := range enc.Totales.ImptoReten {
+		documento.ImpuestosAdicionales = append(documento.ImpuestosAdicionales, models.ImpuestoAdicionalItem{
+			Codigo: imp.TipoImp,
+			Tasa:   imp.TasaImp,
+			Monto:  imp.MontoImp,
+		})
+	}
+	for _, det := range origen.Documento.Detalle {
+		documento.Detalles = append(documento.Detalles, models.DetalleTributario{
+			Descripcion:    det.NmbItem,
+			Cantidad:       int(det.QtyItem),
+			PrecioUnitario: det.PrcItem,
+			MontoItem:      det.MontoItem,
+			Exento:         det.IndExe == 1,
+		})
+	}
+
+	return documento
+}
+
+// firmaHija retorna la firma XMLDSig que es hija directa del elemento
+func firmaHija(el *etree.Element) *etree.Element {
+	for _, hijo := range el.ChildElements() {
+		if hijo.Tag == "Signature" && hijo.NamespaceURI() == xmldsig.NamespaceXMLDSig {
+			return hijo
+		}
+	}
+	return nil
+}
+
+// textoHijo retorna el texto sin espacios de un hijo directo
+func textoHijo(el *etree.Element, tag string) string {
+	if hijo := el.SelectElement(tag); hijo != nil {
+		return strings.TrimSpace(hijo.Text())
+	}
+	return ""
+}
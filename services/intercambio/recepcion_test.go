@@ -0,0 +1,194 @@
+package intercambio
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const envioRecibidoPrueba = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<EnvioDTE xmlns="http://www.sii.cl/SiiDte" version="1.0"><SetDTE ID="SetDoc"><Caratula version="1.0">` +
+	`<RutEmisor>76212889-6</RutEmisor><RutEnvia>13195458-1</RutEnvia><RutReceptor>11111111-1</RutReceptor></Caratula>` +
+	`<DTE version="1.0"><Documento ID="F33T1"><Encabezado><IdDoc><TipoDTE>33</TipoDTE><Folio>1</Folio><FchEmis>2024-03-01</FchEmis></IdDoc>` +
+	`<Emisor><RUTEmisor>76212889-6</RUTEmisor></Emisor><Receptor><RUTRecep>11111111-1</RUTRecep></Receptor>` +
+	`<Totales><MntTotal>119000</MntTotal></Totales></Encabezado></Documento></DTE></SetDTE></EnvioDTE>`
+
+// firmarEnvioPrueba firma el DTE y el SetDTE del envío, aplicando alterar entre ambas firmas
+func firmarEnvioPrueba(t *testing.T, firmante *xmldsig.Firmante, alterar func(setDTE, documento *etree.Element)) []byte {
+	t.Helper()
+	doc, err := xmldsig.ParseDocument([]byte(envioRecibidoPrueba))
+	require.NoError(t, err)
+	setDTE := doc.FindElement("//SetDTE")
+	documento := doc.FindElement("//Documento")
+
+	_, err = firmante.FirmarElemento(documento, "F33T1")
+	require.NoError(t, err)
+	if alterar != nil {
+		alterar(setDTE, documento)
+	}
+	_, err = firmante.FirmarElemento(setDTE, "SetDoc")
+	require.NoError(t, err)
+
+	data, err := xmldsig.Serializar(doc)
+	require.NoError(t, err)
+	return data
+}
+
+func TestAnalizarEnvioFirmas(t *testing.T) {
+	firmante := firmanteConRut(t, "13.195.458-1")
+
+	// Firmas válidas del RUT que envía: el DTE solo falla por no tener timbre
+	analisis := AnalizarEnvio(firmarEnvioPrueba(t, firmante, nil), "11111111-1", nil)
+	require.Equal(t, models.EstadoRecepEnvOK, analisis.Estado, analisis.Glosa)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Equal(t, models.EstadoRecepDTEOtros, analisis.Documentos[0].Recepcion.EstadoRecepDTE)
+	assert.Contains(t, analisis.Documentos[0].Recepcion.Glosa, "Timbre")
+
+	// Certificado de un RUT ajeno al envío
+	analisis = AnalizarEnvio(firmarEnvioPrueba(t, firmanteConRut(t, "99999999-9"), nil), "11111111-1", nil)
+	assert.Equal(t, models.EstadoRecepEnvFirma, analisis.Estado)
+	assert.Contains(t, analisis.Glosa, "certificado")
+
+	// Documento firmado desplazado fuera del DTE y reemplazado por otro sin firma
+	envuelto := firmarEnvioPrueba(t, firmante, func(setDTE, documento *etree.Element) {
+		setDTE.AddChild(documento.Copy())
+		documento.RemoveAttr("ID")
+		documento.CreateAttr("ID", "F33T9")
+		documento.FindElement("Encabezado/Totales/MntTotal").SetText("1")
+	})
+	analisis = AnalizarEnvio(envuelto, "11111111-1", nil)
+	require.Equal(t, models.EstadoRecepEnvOK, analisis.Estado, analisis.Glosa)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Equal(t, models.EstadoRecepDTEFirma, analisis.Documentos[0].Recepcion.EstadoRecepDTE)
+	assert.Nil(t, analisis.Documentos[0].Documento)
+
+	// SetDTE falso con el mismo ID que el firmado
+	firmado := firmarEnvioPrueba(t, firmante, nil)
+	doc, err := xmldsig.ParseDocument(firmado)
+	require.NoError(t, err)
+	falso := doc.FindElement("//SetDTE").Copy()
+	falso.FindElement("Caratula/RutReceptor").SetText("22222222-2")
+	doc.Root().InsertChildAt(0, falso)
+	duplicado, err := xmldsig.Serializar(doc)
+	require.NoError(t, err)
+	analisis = AnalizarEnvio(duplicado, "22222222-2", nil)
+	assert.Equal(t, models.EstadoRecepEnvFirma, analisis.Estado)
+	assert.True(t, strings.Contains(analisis.Glosa, "SetDoc"), analisis.Glosa)
+}
+
+var espaciosDA = regexp.MustCompile(`>\s+<`)
+
+// cafFirmado genera un CAF del emisor del envío con una llave de folios nueva y el FRMA
+// firmado con llaveSII
+func cafFirmado(t *testing.T, llaveSII *rsa.PrivateKey, idk int) *caf.CAFXml {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	publica, err := x509.MarshalPKIXPublicKey(&llave.PublicKey)
+	require.NoError(t, err)
+
+	da := fmt.Sprintf(`<DA><RE>76212889-6</RE><RS>EMISOR</RS><TD>33</TD><RNG><D>1</D><H>100</H></RNG>`+
+		`<FA>2024-01-01</FA><RSAPK><M>%s</M><E>%s</E></RSAPK><IDK>%d</IDK></DA>`,
+		base64.StdEncoding.EncodeToString(llave.N.Bytes()),
+		base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()), idk)
+	doc, err := xmldsig.ParseDocument([]byte(da))
+	require.NoError(t, err)
+	digest := sha1.Sum(espaciosDA.ReplaceAll(xmldsig.Canonicalize(doc.Root()), []byte("><")))
+	firma, err := rsa.SignPKCS1v15(rand.Reader, llaveSII, crypto.SHA1, digest[:])
+	require.NoError(t, err)
+
+	autorizacion, err := caf.ParseCAF([]byte(fmt.Sprintf(`<?xml version="1.0"?>
+<AUTORIZACION><CAF version="1.0">%s<FRMA algoritmo="SHA1withRSA">%s</FRMA></CAF><RSASK>%s</RSASK><RSAPUBK>%s</RSAPUBK></AUTORIZACION>`,
+		da, base64.StdEncoding.EncodeToString(firma),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(llave)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publica}))))
+	require.NoError(t, err)
+	return autorizacion
+}
+
+// timbrarPrueba agrega al Documento del envío un TED generado con el CAF indicado
+func timbrarPrueba(t *testing.T, autorizacion *caf.CAFXml, documento *etree.Element) {
+	t.Helper()
+	nodo, err := ted.Timbrar(ted.Documento{
+		RutEmisor:   "76212889-6",
+		TipoDTE:     33,
+		Folio:       1,
+		FchEmis:     "2024-03-01",
+		RutReceptor: "11111111-1",
+		MontoTotal:  119000,
+	}, autorizacion, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	documento.AddChild(nodo)
+}
+
+func TestAnalizarEnvioVerificaCAF(t *testing.T) {
+	firmante := firmanteConRut(t, "13.195.458-1")
+	llaveSII, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	llaves := func(idk int) (*rsa.PublicKey, error) {
+		if idk != 100 {
+			return nil, fmt.Errorf("IDK %d desconocido", idk)
+		}
+		return &llaveSII.PublicKey, nil
+	}
+
+	// El DTE se timbra antes de firmarlo, por lo que el TED va dentro del Documento firmado
+	firmarTimbrado := func(autorizacion *caf.CAFXml) []byte {
+		doc, err := xmldsig.ParseDocument([]byte(envioRecibidoPrueba))
+		require.NoError(t, err)
+		documento := doc.FindElement("//Documento")
+		timbrarPrueba(t, autorizacion, documento)
+		_, err = firmante.FirmarElemento(documento, "F33T1")
+		require.NoError(t, err)
+		_, err = firmante.FirmarElemento(doc.FindElement("//SetDTE"), "SetDoc")
+		require.NoError(t, err)
+		data, err := xmldsig.Serializar(doc)
+		require.NoError(t, err)
+		return data
+	}
+
+	// CAF autorizado por el SII
+	analisis := AnalizarEnvio(firmarTimbrado(cafFirmado(t, llaveSII, 100)), "11111111-1", llaves)
+	require.Equal(t, models.EstadoRecepEnvOK, analisis.Estado, analisis.Glosa)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Equal(t, models.EstadoRecepDTEOK, analisis.Documentos[0].Recepcion.EstadoRecepDTE, analisis.Documentos[0].Recepcion.Glosa)
+	assert.NotNil(t, analisis.Documentos[0].Documento)
+
+	// CAF falsificado con un par de llaves propio: el FRMT es válido con su RSAPK, pero el
+	// FRMA no corresponde a la llave del SII
+	falsificador, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	analisis = AnalizarEnvio(firmarTimbrado(cafFirmado(t, falsificador, 100)), "11111111-1", llaves)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Equal(t, models.EstadoRecepDTEOtros, analisis.Documentos[0].Recepcion.EstadoRecepDTE)
+	assert.Contains(t, analisis.Documentos[0].Recepcion.Glosa, "CAF no autorizado")
+	assert.Nil(t, analisis.Documentos[0].Documento)
+
+	// IDK sin llave del SII registrada
+	analisis = AnalizarEnvio(firmarTimbrado(cafFirmado(t, llaveSII, 200)), "11111111-1", llaves)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Contains(t, analisis.Documentos[0].Recepcion.Glosa, "CAF no verificable")
+
+	// Sin llaves del SII el timbre no se acepta
+	analisis = AnalizarEnvio(firmarTimbrado(cafFirmado(t, llaveSII, 100)), "11111111-1", nil)
+	require.Len(t, analisis.Documentos, 1)
+	assert.Equal(t, models.EstadoRecepDTEOtros, analisis.Documentos[0].Recepcion.EstadoRecepDTE)
+}
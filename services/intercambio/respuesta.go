@@ -0,0 +1,179 @@
+package intercambio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// largoGlosa es el largo máximo de las glosas de la respuesta
+const largoGlosa = 256
+
+// Firmador firma un documento XML completo referenciando el ID indicado, o un elemento en su
+// ubicación final dentro del documento
+type Firmador interface {
+	FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error)
+	FirmarElemento(objetivo *etree.Element, referenciaID string) (*etree.Element, error)
+}
+
+// DecisionDTE es la aprobación o rechazo comercial de un DTE recibido
+type DecisionDTE struct {
+	TipoDTE   int    `json:"tipo_dte" binding:"required"`
+	Folio     int64  `json:"folio" binding:"required"`
+	EstadoDTE int    `json:"estado_dte"`
+	Glosa     string `json:"glosa"`
+	CodRchDsc int    `json:"cod_rch_dsc,omitempty"`
+}
+
+// ConstruirRecepcionEnvio arma la respuesta de recepción técnica (RecepcionEnvio) de un envío
+func ConstruirRecepcionEnvio(intercambio *models.IntercambioDTE, ahora time.Time) *models.RespuestaDTEXML {
+	recepcion := models.RecepcionEnvioXML{
+		NmbEnvio:       truncar(intercambio.NombreArchivo, 80),
+		FchRecep:       intercambio.FechaRecepcion.Format("2006-01-02T15:04:05"),
+		CodEnvio:       intercambio.CodEnvio,
+		EnvioDTEID:     intercambio.EnvioDTEID,
+		Digest:         intercambio.Digest,
+		RutEmisor:      intercambio.RutEmisor,
+		RutReceptor:    intercambio.RutReceptor,
+		EstadoRecepEnv: intercambio.EstadoRecepEnv,
+		RecepEnvGlosa:  truncar(intercambio.Glosa, largoGlosa),
+	}
+	if recepcion.EnvioDTEID == "" {
+		recepcion.EnvioDTEID = "0"
+	}
+	for _, doc := range intercambio.Documentos {
+		recepcion.RecepcionDTE = append(recepcion.RecepcionDTE, models.RecepcionDTEXML{
+			TipoDTE:        doc.TipoDTE,
+			Folio:          doc.Folio,
+			FchEmis:        doc.FchEmis,
+			RUTEmisor:      doc.RutEmisor,
+			RUTRecep:       doc.RutReceptor,
+			MntTotal:       doc.MontoTotal,
+			EstadoRecepDTE: doc.EstadoRecepDTE,
+			RecepDTEGlosa:  truncar(doc.Glosa, largoGlosa),
+		})
+	}
+	recepcion.NroDTE = len(recepcion.RecepcionDTE)
+
+	return &models.RespuestaDTEXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		Resultado: models.ResultadoRespuesta{
+			ID:             fmt.Sprintf("RecepcionEnvio_%d", intercambio.CodEnvio),
+			Caratula:       caratula(intercambio, 1, ahora),
+			RecepcionEnvio: []models.RecepcionEnvioXML{recepcion},
+		},
+	}
+}
+
+// ConstruirResultadoDTE arma la respuesta de aprobación comercial (ResultadoDTE). Los documentos
+// sin decisión explícita se aceptan si fueron recibidos OK y se rechazan en caso contrario.
+func ConstruirResultadoDTE(intercambio *models.IntercambioDTE, decisiones []DecisionDTE, ahora time.Time) (*models.RespuestaDTEXML, error) {
+	porDocumento := make(map[string]DecisionDTE, len(decisiones))
+	for _, decision := range decisiones {
+		if decision.EstadoDTE < models.EstadoResultadoAceptado || decision.EstadoDTE > models.EstadoResultadoRechazado {
+			return nil, fmt.Errorf("estado %d inválido para el DTE tipo %d folio %d", decision.EstadoDTE, decision.TipoDTE, decision.Folio)
+		}
+		porDocumento[claveDocumento(decision.TipoDTE, decision.Folio)] = decision
+	}
+
+	var resultados []models.ResultadoDTEXML
+	for _, doc := range intercambio.Documentos {
+		decision, ok := porDocumento[claveDocumento(doc.TipoDTE, doc.Folio)]
+		if ok {
+			delete(porDocumento, claveDocumento(doc.TipoDTE, doc.Folio))
+		} else {
+			decision = decisionPorDefecto(doc)
+		}
+		if decision.EstadoDTE != models.EstadoResultadoRechazado && doc.EstadoRecepDTE != models.EstadoRecepDTEOK {
+			return nil, fmt.Errorf("el DTE tipo %d folio %d no fue recibido conforme y no puede aceptarse", doc.TipoDTE, doc.Folio)
+		}
+		if decision.Glosa == "" {
+			decision.Glosa = decisionPorDefecto(doc).Glosa
+		}
+
+		resultados = append(resultados, models.ResultadoDTEXML{
+			TipoDTE:        doc.TipoDTE,
+			Folio:          doc.Folio,
+			FchEmis:        doc.FchEmis,
+			RUTEmisor:      doc.RutEmisor,
+			RUTRecep:       doc.RutReceptor,
+			MntTotal:       doc.MontoTotal,
+			CodEnvio:       intercambio.CodEnvio,
+			EstadoDTE:      decision.EstadoDTE,
+			EstadoDTEGlosa: truncar(decision.Glosa, largoGlosa),
+			CodRchDsc:      decision.CodRchDsc,
+		})
+	}
+	for clave := range porDocumento {
+		return nil, fmt.Errorf("el documento %s no pertenece al envío", clave)
+	}
+	if len(resultados) == 0 {
+		return nil, fmt.Errorf("el envío no contiene documentos para responder")
+	}
+
+	return &models.RespuestaDTEXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		Resultado: models.ResultadoRespuesta{
+			ID:           fmt.Sprintf("ResultadoDTE_%d", intercambio.CodEnvio),
+			Caratula:     caratula(intercambio, len(resultados), ahora),
+			ResultadoDTE: resultados,
+		},
+	}, nil
+}
+
+// FirmarRespuesta serializa la respuesta en ISO-8859-1 y la firma referenciando el nodo Resultado
+func FirmarRespuesta(firmador Firmador, respuesta *models.RespuestaDTEXML) ([]byte, error) {
+	xmlData, err := xml.Marshal(respuesta)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar respuesta: %v", err)
+	}
+	if xmlData, err = xmldsig.CodificarLatin1(xmlData); err != nil {
+		return nil, fmt.Errorf("error al codificar respuesta: %v", err)
+	}
+
+	firmado, err := firmador.FirmarDocumento(xmlData, respuesta.Resultado.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error al firmar respuesta: %v", err)
+	}
+	return firmado, nil
+}
+
+// caratula arma la carátula de una respuesta dirigida al emisor del envío
+func caratula(intercambio *models.IntercambioDTE, nroDetalles int, ahora time.Time) models.CaratulaRespuesta {
+	return models.CaratulaRespuesta{
+		Version:       "1.0",
+		RutResponde:   intercambio.RutReceptor,
+		RutRecibe:     intercambio.RutEmisor,
+		IdRespuesta:   intercambio.CodEnvio,
+		NroDetalles:   nroDetalles,
+		TmstFirmaResp: ahora.Format("2006-01-02T15:04:05"),
+	}
+}
+
+// decisionPorDefecto acepta los documentos recibidos OK y rechaza el resto con la glosa de recepción
+func decisionPorDefecto(doc models.DTERecibido) DecisionDTE {
+	if doc.EstadoRecepDTE == models.EstadoRecepDTEOK {
+		return DecisionDTE{TipoDTE: doc.TipoDTE, Folio: doc.Folio, EstadoDTE: models.EstadoResultadoAceptado, Glosa: "DTE aceptado"}
+	}
+	return DecisionDTE{TipoDTE: doc.TipoDTE, Folio: doc.Folio, EstadoDTE: models.EstadoResultadoRechazado, Glosa: doc.Glosa}
+}
+
+// claveDocumento identifica un documento dentro de un envío
+func claveDocumento(tipoDTE int, folio int64) string {
+	return fmt.Sprintf("T%dF%d", tipoDTE, folio)
+}
+
+// truncar limita el texto al largo máximo permitido por el esquema
+func truncar(s string, largo int) string {
+	runas := []rune(s)
+	if len(runas) <= largo {
+		return s
+	}
+	return string(runas[:largo])
+}
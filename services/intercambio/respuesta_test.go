@@ -0,0 +1,134 @@
+package intercambio
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// firmantePrueba crea un firmante con una llave y un certificado autofirmado del receptor
+func firmantePrueba(t *testing.T) *xmldsig.Firmante {
+	return firmanteConRut(t, "11111111-1")
+}
+
+// firmanteConRut crea un firmante cuyo certificado informa el RUT en el SerialNumber del sujeto
+func firmanteConRut(t *testing.T, rut string) *xmldsig.Firmante {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(11),
+		Subject:      pkix.Name{CommonName: "Firmante Prueba", SerialNumber: rut},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return xmldsig.NewFirmante(llave, cert)
+}
+
+// verificarLatin1 verifica las firmas de un documento en ISO-8859-1 y lo retorna en UTF-8
+func verificarLatin1(t *testing.T, firmado []byte) string {
+	t.Helper()
+	require.True(t, strings.HasPrefix(string(firmado), `<?xml version="1.0" encoding="ISO-8859-1"?>`))
+	_, err := xmldsig.VerificarDocumento(firmado)
+	require.NoError(t, err)
+	texto, err := charmap.ISO8859_1.NewDecoder().Bytes(firmado)
+	require.NoError(t, err)
+	return string(texto)
+}
+
+func intercambioPrueba() *models.IntercambioDTE {
+	return &models.IntercambioDTE{
+		CodEnvio:       12,
+		RutReceptor:    "11111111-1",
+		RutEmisor:      "76212889-6",
+		NombreArchivo:  "envio.xml",
+		EnvioDTEID:     "SetDoc",
+		Digest:         "ZGlnZXN0",
+		EstadoRecepEnv: models.EstadoRecepEnvOK,
+		Glosa:          "Envío recibido conforme",
+		FechaRecepcion: time.Date(2024, 3, 2, 9, 30, 0, 0, time.UTC),
+		Documentos: []models.DTERecibido{
+			{TipoDTE: 33, Folio: 7, FchEmis: "2024-03-01", RutEmisor: "76212889-6", RutReceptor: "11111111-1", MontoTotal: 119000, EstadoRecepDTE: models.EstadoRecepDTEOK, Glosa: "DTE recibido OK"},
+			{TipoDTE: 33, Folio: 8, FchEmis: "2024-03-01", RutEmisor: "76212889-6", RutReceptor: "11111111-1", MontoTotal: 5000, EstadoRecepDTE: models.EstadoRecepDTEFirma, Glosa: "Error de firma del DTE"},
+		},
+	}
+}
+
+func TestConstruirRecepcionEnvio(t *testing.T) {
+	respuesta := ConstruirRecepcionEnvio(intercambioPrueba(), time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC))
+	firmado, err := FirmarRespuesta(firmantePrueba(t), respuesta)
+	require.NoError(t, err)
+
+	xml := verificarLatin1(t, firmado)
+	assert.Contains(t, xml, `<Resultado ID="RecepcionEnvio_12"><Caratula version="1.0"><RutResponde>11111111-1</RutResponde><RutRecibe>76212889-6</RutRecibe><IdRespuesta>12</IdRespuesta><NroDetalles>1</NroDetalles>`)
+	assert.Contains(t, xml, `<FchRecep>2024-03-02T09:30:00</FchRecep><CodEnvio>12</CodEnvio><EnvioDTEID>SetDoc</EnvioDTEID><Digest>ZGlnZXN0</Digest>`)
+	assert.Contains(t, xml, `<EstadoRecepEnv>0</EstadoRecepEnv><RecepEnvGlosa>Envío recibido conforme</RecepEnvGlosa><NroDTE>2</NroDTE>`)
+	assert.Contains(t, xml, `<EstadoRecepDTE>1</EstadoRecepDTE><RecepDTEGlosa>Error de firma del DTE</RecepDTEGlosa>`)
+	assert.Contains(t, xml, `</Resultado><Signature xmlns="http://www.w3.org/2000/09/xmldsig#">`)
+	assert.Contains(t, xml, `<Reference URI="#RecepcionEnvio_12">`)
+}
+
+func TestConstruirResultadoDTE(t *testing.T) {
+	ahora := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	respuesta, err := ConstruirResultadoDTE(intercambioPrueba(), nil, ahora)
+	require.NoError(t, err)
+	resultados := respuesta.Resultado.ResultadoDTE
+	require.Len(t, resultados, 2)
+	assert.Equal(t, models.EstadoResultadoAceptado, resultados[0].EstadoDTE)
+	assert.Equal(t, models.EstadoResultadoRechazado, resultados[1].EstadoDTE)
+	assert.Equal(t, "Error de firma del DTE", resultados[1].EstadoDTEGlosa)
+	assert.Equal(t, int64(12), resultados[0].CodEnvio)
+
+	respuesta, err = ConstruirResultadoDTE(intercambioPrueba(), []DecisionDTE{
+		{TipoDTE: 33, Folio: 7, EstadoDTE: models.EstadoResultadoRechazado, Glosa: "Mercadería no recibida", CodRchDsc: -1},
+	}, ahora)
+	require.NoError(t, err)
+	assert.Equal(t, "Mercadería no recibida", respuesta.Resultado.ResultadoDTE[0].EstadoDTEGlosa)
+	assert.Equal(t, -1, respuesta.Resultado.ResultadoDTE[0].CodRchDsc)
+
+	firmado, err := FirmarRespuesta(firmantePrueba(t), respuesta)
+	require.NoError(t, err)
+	assert.Contains(t, verificarLatin1(t, firmado), `<EstadoDTEGlosa>Mercadería no recibida</EstadoDTEGlosa>`)
+}
+
+func TestConstruirResultadoDTEValidaciones(t *testing.T) {
+	ahora := time.Now()
+
+	_, err := ConstruirResultadoDTE(intercambioPrueba(), []DecisionDTE{{TipoDTE: 33, Folio: 8, EstadoDTE: models.EstadoResultadoAceptado}}, ahora)
+	assert.Error(t, err)
+
+	_, err = ConstruirResultadoDTE(intercambioPrueba(), []DecisionDTE{{TipoDTE: 33, Folio: 99}}, ahora)
+	assert.Error(t, err)
+
+	_, err = ConstruirResultadoDTE(intercambioPrueba(), []DecisionDTE{{TipoDTE: 33, Folio: 7, EstadoDTE: 5}}, ahora)
+	assert.Error(t, err)
+}
+
+func TestAnalizarEnvioRechazos(t *testing.T) {
+	analisis := AnalizarEnvio([]byte("no es xml"), "11111111-1", nil)
+	assert.Equal(t, models.EstadoRecepEnvIlegible, analisis.Estado)
+
+	sinFirma := `<EnvioDTE xmlns="http://www.sii.cl/SiiDte" version="1.0"><SetDTE ID="SetDoc"><Caratula version="1.0">` +
+		`<RutEmisor>76212889-6</RutEmisor><RutEnvia>13195458-1</RutEnvia><RutReceptor>11111111-1</RutReceptor></Caratula></SetDTE></EnvioDTE>`
+	analisis = AnalizarEnvio([]byte(sinFirma), "11111111-1", nil)
+	assert.Equal(t, models.EstadoRecepEnvFirma, analisis.Estado)
+	assert.Equal(t, "SetDoc", analisis.EnvioDTEID)
+	assert.Equal(t, "76212889-6", analisis.RutEmisor)
+}
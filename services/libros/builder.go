@@ -0,0 +1,234 @@
+package libros
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// ParametrosLibro contiene los datos de la carátula del libro a generar
+type ParametrosLibro struct {
+	RutEmisor         string
+	RutEnvia          string
+	Periodo           string // AAAA-MM
+	FchResol          string // AAAA-MM-DD
+	NroResol          int
+	TipoOperacion     string
+	TipoLibro         string
+	TipoEnvio         string
+	FolioNotificacion int64
+	CodAutRec         string
+	IncluirDetalle    bool
+}
+
+// Validar verifica que los parámetros sean consistentes con el instructivo del IECV
+func (p ParametrosLibro) Validar() error {
+	if p.RutEmisor == "" || p.RutEnvia == "" {
+		return fmt.Errorf("RUT emisor y RUT envía son requeridos")
+	}
+	if _, err := time.Parse("2006-01", p.Periodo); err != nil {
+		return fmt.Errorf("período tributario inválido: %s", p.Periodo)
+	}
+	if _, err := time.Parse("2006-01-02", p.FchResol); err != nil {
+		return fmt.Errorf("fecha de resolución inválida: %s", p.FchResol)
+	}
+	switch p.TipoOperacion {
+	case models.TipoOperacionCompra, models.TipoOperacionVenta:
+	default:
+		return fmt.Errorf("tipo de operación inválido: %s", p.TipoOperacion)
+	}
+	switch p.TipoLibro {
+	case models.TipoLibroMensual, models.TipoLibroRectifica:
+	case models.TipoLibroEspecial:
+		if p.FolioNotificacion == 0 {
+			return fmt.Errorf("el libro especial requiere folio de notificación")
+		}
+	default:
+		return fmt.Errorf("tipo de libro inválido: %s", p.TipoLibro)
+	}
+	if p.TipoLibro == models.TipoLibroRectifica && p.CodAutRec == "" {
+		return fmt.Errorf("el libro rectificatorio requiere código de autorización")
+	}
+	switch p.TipoEnvio {
+	case models.TipoEnvioTotal, models.TipoEnvioParcial, models.TipoEnvioFinal, models.TipoEnvioAjuste:
+	default:
+		return fmt.Errorf("tipo de envío inválido: %s", p.TipoEnvio)
+	}
+	return nil
+}
+
+// IDEnvio retorna el identificador del nodo EnvioLibro referenciado por la firma
+func (p ParametrosLibro) IDEnvio() string {
+	return fmt.Sprintf("IECV_%s_%s", p.TipoOperacion, p.Periodo)
+}
+
+// ConstruirLibro arma el libro de compra o venta a partir de los documentos del período
+func ConstruirLibro(params ParametrosLibro, documentos []models.DocumentoTributario) (*models.LibroCompraVentaXML, error) {
+	if err := params.Validar(); err != nil {
+		return nil, err
+	}
+
+	totales := make(map[int]*models.TotalesPeriodoXML)
+	otrosImp := make(map[int]map[string]int64)
+	detalles := make([]models.DetalleLibroXML, 0, len(documentos))
+
+	for _, doc := range documentos {
+		tipo, err := tipoDocumento(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		total, ok := totales[tipo]
+		if !ok {
+			total = &models.TotalesPeriodoXML{TpoDoc: tipo, TpoImp: 1}
+			totales[tipo] = total
+			otrosImp[tipo] = make(map[string]int64)
+		}
+
+		anulado := doc.Estado == models.EstadoDTEAnulado
+		if anulado {
+			total.TotAnulado++
+		} else {
+			total.TotDoc++
+			acumularTotales(total, otrosImp[tipo], doc, params.TipoOperacion)
+		}
+
+		if params.IncluirDetalle {
+			detalles = append(detalles, construirDetalle(tipo, doc, params.TipoOperacion, anulado))
+		}
+	}
+
+	tipos := make([]int, 0, len(totales))
+	for tipo := range totales {
+		tipos = append(tipos, tipo)
+	}
+	sort.Ints(tipos)
+
+	resumen := &models.ResumenPeriodoXML{}
+	for _, tipo := range tipos {
+		total := totales[tipo]
+		codigos := make([]string, 0, len(otrosImp[tipo]))
+		for codigo := range otrosImp[tipo] {
+			codigos = append(codigos, codigo)
+		}
+		sort.Strings(codigos)
+		for _, codigo := range codigos {
+			total.TotOtrosImp = append(total.TotOtrosImp, models.TotOtrosImpXML{
+				CodImp:    codigo,
+				TotMntImp: otrosImp[tipo][codigo],
+			})
+		}
+		resumen.TotalesPeriodo = append(resumen.TotalesPeriodo, *total)
+	}
+
+	sort.SliceStable(detalles, func(i, j int) bool {
+		if detalles[i].TpoDoc != detalles[j].TpoDoc {
+			return detalles[i].TpoDoc < detalles[j].TpoDoc
+		}
+		return detalles[i].NroDoc < detalles[j].NroDoc
+	})
+
+	libro := &models.LibroCompraVentaXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		EnvioLibro: models.EnvioLibroXML{
+			ID: params.IDEnvio(),
+			Caratula: models.CaratulaLibroXML{
+				RutEmisorLibro:    params.RutEmisor,
+				RutEnvia:          params.RutEnvia,
+				PeriodoTributario: params.Periodo,
+				FchResol:          params.FchResol,
+				NroResol:          params.NroResol,
+				TipoOperacion:     params.TipoOperacion,
+				TipoLibro:         params.TipoLibro,
+				TipoEnvio:         params.TipoEnvio,
+				FolioNotificacion: params.FolioNotificacion,
+				CodAutRec:         params.CodAutRec,
+			},
+			Detalle:   detalles,
+			TmstFirma: time.Now().Format("2006-01-02T15:04:05"),
+		},
+	}
+	if len(resumen.TotalesPeriodo) > 0 {
+		libro.EnvioLibro.ResumenPeriodo = resumen
+	}
+
+	return libro, nil
+}
+
+// acumularTotales suma los montos del documento a los totales de su tipo
+func acumularTotales(total *models.TotalesPeriodoXML, otrosImp map[string]int64, doc models.DocumentoTributario, operacion string) {
+	exento := models.RedondearMonto(doc.MontoExento)
+	neto := models.RedondearMonto(doc.MontoNeto)
+	iva := models.RedondearMonto(doc.MontoIVA)
+
+	if exento > 0 {
+		total.TotOpExe++
+	}
+	total.TotMntExe += exento
+	total.TotMntNeto += neto
+	total.TotMntIVA += iva
+	if operacion == models.TipoOperacionCompra && iva > 0 {
+		total.TotOpIVARec++
+	}
+	total.TotMntTotal += models.RedondearMonto(doc.MontoTotal)
+
+	for _, imp := range doc.ImpuestosAdicionales {
+		otrosImp[imp.Codigo] += models.RedondearMonto(imp.Monto)
+	}
+}
+
+// construirDetalle arma la línea de detalle de un documento
+func construirDetalle(tipo int, doc models.DocumentoTributario, operacion string, anulado bool) models.DetalleLibroXML {
+	detalle := models.DetalleLibroXML{
+		TpoDoc: tipo,
+		NroDoc: int64(doc.Folio),
+		FchDoc: doc.FechaEmision.Format("2006-01-02"),
+	}
+
+	// En ventas se informa la contraparte receptora; en compras, el proveedor
+	if operacion == models.TipoOperacionVenta {
+		detalle.RUTDoc = doc.RUTReceptor
+		detalle.RznSoc = doc.RazonSocialReceptor
+	} else {
+		detalle.RUTDoc = doc.RUTEmisor
+		detalle.RznSoc = doc.RazonSocialEmisor
+	}
+
+	if anulado {
+		detalle.Anulado = "A"
+		return detalle
+	}
+
+	detalle.MntExe = models.RedondearMonto(doc.MontoExento)
+	detalle.MntNeto = models.RedondearMonto(doc.MontoNeto)
+	detalle.MntIVA = models.RedondearMonto(doc.MontoIVA)
+	if detalle.MntIVA > 0 {
+		detalle.TasaImp = doc.TasaIVA
+	}
+	for _, imp := range doc.ImpuestosAdicionales {
+		detalle.OtrosImp = append(detalle.OtrosImp, models.OtrosImpLibroXML{
+			CodImp:  imp.Codigo,
+			TasaImp: imp.Tasa,
+			MntImp:  models.RedondearMonto(imp.Monto),
+		})
+	}
+	detalle.MntTotal = models.RedondearMonto(doc.MontoTotal)
+
+	return detalle
+}
+
+// tipoDocumento obtiene el código SII del documento
+func tipoDocumento(doc models.DocumentoTributario) (int, error) {
+	if doc.TipoDocumento != 0 {
+		return int(doc.TipoDocumento), nil
+	}
+	tipo, err := strconv.Atoi(doc.TipoDTE)
+	if err != nil {
+		return 0, fmt.Errorf("tipo de documento inválido en folio %d: %s", doc.Folio, doc.TipoDTE)
+	}
+	return tipo, nil
+}
@@ -0,0 +1,87 @@
+package libros
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parametrosVenta() ParametrosLibro {
+	return ParametrosLibro{
+		RutEmisor:      "76212889-6",
+		RutEnvia:       "13195458-1",
+		Periodo:        "2024-03",
+		FchResol:       "2014-08-22",
+		NroResol:       80,
+		TipoOperacion:  models.TipoOperacionVenta,
+		TipoLibro:      models.TipoLibroMensual,
+		TipoEnvio:      models.TipoEnvioTotal,
+		IncluirDetalle: true,
+	}
+}
+
+func TestConstruirLibroTotalesPorTipo(t *testing.T) {
+	fecha := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	documentos := []models.DocumentoTributario{
+		{Folio: 2, TipoDocumento: models.TipoFactura, FechaEmision: fecha, RUTReceptor: "11111111-1", MontoNeto: 1000, MontoIVA: 190, TasaIVA: 19, MontoTotal: 1190},
+		{Folio: 1, TipoDocumento: models.TipoFactura, FechaEmision: fecha, RUTReceptor: "22222222-2", MontoNeto: 2000, MontoIVA: 380, TasaIVA: 19, MontoTotal: 2480,
+			ImpuestosAdicionales: []models.ImpuestoAdicionalItem{{Codigo: "27", Tasa: 10, Monto: 100}}},
+		{Folio: 3, TipoDTE: "61", FechaEmision: fecha, RUTReceptor: "11111111-1", MontoExento: 500, MontoTotal: 500},
+		{Folio: 4, TipoDocumento: models.TipoFactura, FechaEmision: fecha, RUTReceptor: "11111111-1", MontoNeto: 9999, Estado: models.EstadoDTEAnulado},
+	}
+
+	libro, err := ConstruirLibro(parametrosVenta(), documentos)
+	require.NoError(t, err)
+	require.NotNil(t, libro.EnvioLibro.ResumenPeriodo)
+
+	totales := libro.EnvioLibro.ResumenPeriodo.TotalesPeriodo
+	require.Len(t, totales, 2)
+
+	factura := totales[0]
+	assert.Equal(t, 33, factura.TpoDoc)
+	assert.Equal(t, 2, factura.TotDoc)
+	assert.Equal(t, 1, factura.TotAnulado)
+	assert.Equal(t, int64(3000), factura.TotMntNeto)
+	assert.Equal(t, int64(570), factura.TotMntIVA)
+	assert.Equal(t, int64(3670), factura.TotMntTotal)
+	assert.Equal(t, []models.TotOtrosImpXML{{CodImp: "27", TotMntImp: 100}}, factura.TotOtrosImp)
+
+	notaCredito := totales[1]
+	assert.Equal(t, 61, notaCredito.TpoDoc)
+	assert.Equal(t, 1, notaCredito.TotOpExe)
+	assert.Equal(t, int64(500), notaCredito.TotMntExe)
+
+	detalles := libro.EnvioLibro.Detalle
+	require.Len(t, detalles, 4)
+	assert.Equal(t, int64(1), detalles[0].NroDoc)
+	assert.Equal(t, "22222222-2", detalles[0].RUTDoc)
+	assert.Equal(t, "A", detalles[2].Anulado)
+	assert.Zero(t, detalles[2].MntNeto)
+}
+
+func TestConstruirLibroXML(t *testing.T) {
+	libro, err := ConstruirLibro(parametrosVenta(), nil)
+	require.NoError(t, err)
+
+	data, err := xml.Marshal(libro)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `<LibroCompraVenta xmlns="http://www.sii.cl/SiiDte" version="1.0">`)
+	assert.Contains(t, string(data), `<EnvioLibro ID="IECV_VENTA_2024-03">`)
+	assert.NotContains(t, string(data), "ResumenPeriodo")
+}
+
+func TestParametrosLibroValidar(t *testing.T) {
+	params := parametrosVenta()
+	params.TipoLibro = models.TipoLibroEspecial
+	assert.Error(t, params.Validar())
+
+	params.FolioNotificacion = 123
+	assert.NoError(t, params.Validar())
+
+	params.Periodo = "2024-13"
+	assert.Error(t, params.Validar())
+}
@@ -0,0 +1,248 @@
+package libros
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Colecciones utilizadas por el servicio de libros
+const (
+	ColeccionLibros              = "libros_compra_venta"
+	ColeccionDocumentosEmitidos  = "documentos"
+	ColeccionDocumentosRecibidos = "documentos_recibidos"
+)
+
+// SchemaLibroCV es la clave del esquema del libro en el validador XML. El esquema oficial
+// LibroCV_v10.xsd del SII no se incluye en schemas/: se debe copiar sin modificaciones antes
+// de registrarlo, porque un subconjunto escrito a mano aceptaría libros que el SII rechaza.
+const SchemaLibroCV = "LibroCV"
+
+// Firmador firma un documento XML completo referenciando el ID indicado
+type Firmador interface {
+	FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error)
+}
+
+// Validador valida un documento XML contra un esquema registrado
+type Validador interface {
+	ValidateXML(xmlData []byte, schemaType string) error
+}
+
+// Uploader envía archivos al servicio de recepción del SII
+type Uploader interface {
+	Subir(ctx context.Context, token, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error)
+}
+
+// Tokens ejecuta operaciones con el token vigente de la empresa, renovándolo si el SII lo rechaza
+type Tokens interface {
+	ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error
+}
+
+// Service genera, firma y envía los libros de compra y venta
+type Service struct {
+	db        *mongo.Database
+	firmador  Firmador
+	validador Validador
+	uploader  Uploader
+	tokens    Tokens
+}
+
+// NewService crea una nueva instancia del servicio de libros. Los envíos usan los tokens
+// compartidos de token.Manager.
+func NewService(db *mongo.Database, firmador Firmador, validador Validador, uploader Uploader, tokens Tokens) *Service {
+	return &Service{
+		db:        db,
+		firmador:  firmador,
+		validador: validador,
+		uploader:  uploader,
+		tokens:    tokens,
+	}
+}
+
+// GenerarLibro construye, firma y valida el libro del período y lo guarda en estado GENERADO
+func (s *Service) GenerarLibro(ctx context.Context, params ParametrosLibro) (*models.LibroCompraVenta, error) {
+	if err := params.Validar(); err != nil {
+		return nil, err
+	}
+
+	documentos, err := s.obtenerDocumentosPeriodo(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	libroXML, err := ConstruirLibro(params, documentos)
+	if err != nil {
+		return nil, fmt.Errorf("error al construir libro: %v", err)
+	}
+
+	xmlFirmado, err := s.firmarLibro(libroXML)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.validador != nil {
+		if err := s.validador.ValidateXML(xmlFirmado, SchemaLibroCV); err != nil {
+			return nil, fmt.Errorf("libro no cumple el esquema: %v", err)
+		}
+	}
+
+	var totales []models.TotalesPeriodoXML
+	if libroXML.EnvioLibro.ResumenPeriodo != nil {
+		totales = libroXML.EnvioLibro.ResumenPeriodo.TotalesPeriodo
+	}
+
+	ahora := time.Now()
+	libro := &models.LibroCompraVenta{
+		ID:                 models.GenerateID(),
+		RutEmisor:          params.RutEmisor,
+		Periodo:            params.Periodo,
+		TipoOperacion:      params.TipoOperacion,
+		TipoLibro:          params.TipoLibro,
+		TipoEnvio:          params.TipoEnvio,
+		FolioNotificacion:  params.FolioNotificacion,
+		CantidadDocumentos: len(documentos),
+		Totales:            totales,
+		XML:                string(xmlFirmado),
+		Estado:             models.EstadoLibroGenerado,
+		FechaGeneracion:    ahora,
+		CreatedAt:          ahora,
+		UpdatedAt:          ahora,
+	}
+
+	if _, err := s.db.Collection(ColeccionLibros).InsertOne(ctx, libro); err != nil {
+		return nil, fmt.Errorf("error al guardar libro: %v", err)
+	}
+
+	return libro, nil
+}
+
+// EnviarLibro sube el libro al SII con el token de la empresa y registra el TrackID retornado
+func (s *Service) EnviarLibro(ctx context.Context, libroID, rutEnvia string) (*models.LibroCompraVenta, error) {
+	libro, err := s.ObtenerLibro(ctx, libroID)
+	if err != nil {
+		return nil, err
+	}
+	if libro.Estado != models.EstadoLibroGenerado && libro.Estado != models.EstadoLibroError {
+		return nil, fmt.Errorf("el libro %s ya fue enviado (estado %s)", libroID, libro.Estado)
+	}
+
+	nombreArchivo := fmt.Sprintf("IECV_%s_%s_%s.xml", libro.TipoOperacion, libro.RutEmisor, libro.Periodo)
+	var respuesta *models.RespuestaUploadSII
+	errEnvio := s.tokens.ConToken(ctx, libro.RutEmisor, func(token string) error {
+		var err error
+		respuesta, err = s.uploader.Subir(ctx, token, rutEnvia, libro.RutEmisor, nombreArchivo, []byte(libro.XML))
+		return err
+	})
+
+	ahora := time.Now()
+	actualizacion := bson.M{"updated_at": ahora}
+	if errEnvio != nil {
+		libro.Estado = models.EstadoLibroError
+		libro.Glosa = errEnvio.Error()
+	} else {
+		libro.Estado = models.EstadoLibroEnviado
+		libro.TrackID = respuesta.TrackID
+		libro.Glosa = ""
+		libro.FechaEnvio = &ahora
+		actualizacion["track_id"] = respuesta.TrackID
+		actualizacion["fecha_envio"] = ahora
+	}
+	actualizacion["estado"] = libro.Estado
+	actualizacion["glosa"] = libro.Glosa
+	libro.UpdatedAt = ahora
+
+	if _, err := s.db.Collection(ColeccionLibros).UpdateOne(ctx, bson.M{"_id": libroID}, bson.M{"$set": actualizacion}); err != nil {
+		return nil, fmt.Errorf("error al actualizar libro: %v", err)
+	}
+
+	if errEnvio != nil {
+		return libro, fmt.Errorf("error al enviar libro al SII: %v", errEnvio)
+	}
+
+	return libro, nil
+}
+
+// ActualizarEstado registra el resultado de la revisión del libro por parte del SII
+func (s *Service) ActualizarEstado(ctx context.Context, trackID string, estado models.EstadoLibro, glosa string) error {
+	resultado, err := s.db.Collection(ColeccionLibros).UpdateOne(ctx,
+		bson.M{"track_id": trackID},
+		bson.M{"$set": bson.M{
+			"estado":     estado,
+			"glosa":      glosa,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("error al actualizar estado del libro: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("no existe libro con TrackID %s", trackID)
+	}
+	return nil
+}
+
+// ObtenerLibro obtiene un libro por su ID
+func (s *Service) ObtenerLibro(ctx context.Context, id string) (*models.LibroCompraVenta, error) {
+	var libro models.LibroCompraVenta
+	if err := s.db.Collection(ColeccionLibros).FindOne(ctx, bson.M{"_id": id}).Decode(&libro); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("libro no encontrado: %s", id)
+		}
+		return nil, fmt.Errorf("error al obtener libro: %v", err)
+	}
+	return &libro, nil
+}
+
+// obtenerDocumentosPeriodo obtiene los documentos emitidos o recibidos del período tributario
+func (s *Service) obtenerDocumentosPeriodo(ctx context.Context, params ParametrosLibro) ([]models.DocumentoTributario, error) {
+	inicio, _ := time.Parse("2006-01", params.Periodo)
+	fin := inicio.AddDate(0, 1, 0)
+
+	coleccion := ColeccionDocumentosEmitidos
+	filter := bson.M{
+		"fecha_emision": bson.M{"$gte": inicio, "$lt": fin},
+	}
+	if params.TipoOperacion == models.TipoOperacionVenta {
+		filter["rut_emisor"] = params.RutEmisor
+	} else {
+		coleccion = ColeccionDocumentosRecibidos
+		filter["rut_receptor"] = params.RutEmisor
+	}
+
+	cursor, err := s.db.Collection(coleccion).Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener documentos del período: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var documentos []models.DocumentoTributario
+	if err := cursor.All(ctx, &documentos); err != nil {
+		return nil, fmt.Errorf("error al decodificar documentos: %v", err)
+	}
+
+	return documentos, nil
+}
+
+// firmarLibro serializa el libro en ISO-8859-1 y lo firma referenciando el nodo EnvioLibro
+func (s *Service) firmarLibro(libro *models.LibroCompraVentaXML) ([]byte, error) {
+	xmlData, err := xml.Marshal(libro)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar libro: %v", err)
+	}
+	if xmlData, err = xmldsig.CodificarLatin1(xmlData); err != nil {
+		return nil, fmt.Errorf("error al codificar libro: %v", err)
+	}
+
+	firmado, err := s.firmador.FirmarDocumento(xmlData, libro.EnvioLibro.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error al firmar libro: %v", err)
+	}
+
+	return firmado, nil
+}
@@ -0,0 +1,298 @@
+// Package muestras genera las muestras impresas que se entregan al SII durante la certificación:
+// la representación impresa de cada DTE de un EnvioDTE firmado, con su copia cedible cuando el
+// tipo de documento la exige, empaquetadas en un único archivo listo para subir.
+package muestras
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Muestra es la representación impresa de un DTE: la copia tributaria y, si corresponde, la
+// copia cedible en las páginas siguientes del mismo PDF
+type Muestra struct {
+	TipoDTE models.TipoDTE
+	Folio   int
+	Leyenda string // Leyenda de la copia cedible, vacía si el documento no la tiene
+	Archivo string
+	PDF     []byte
+}
+
+// Generador arma las muestras impresas de los envíos de un emisor
+type Generador struct {
+	unidad string
+}
+
+// NewGenerador crea un generador de muestras. unidad es la unidad del SII que corresponde al
+// domicilio del emisor, que se imprime bajo el recuadro del folio.
+func NewGenerador(unidad string) *Generador {
+	return &Generador{unidad: unidad}
+}
+
+// Generar verifica las firmas del EnvioDTE y genera la muestra impresa de cada DTE, con la
+// resolución del emisor informada en la carátula
+func (g *Generador) Generar(envio []byte) ([]Muestra, error) {
+	verificaciones, err := xmldsig.VerificarFirmas(envio)
+	if err != nil {
+		return nil, fmt.Errorf("error al verificar firmas del envío: %v", err)
+	}
+	for _, v := range verificaciones {
+		if v.Error != nil {
+			return nil, fmt.Errorf("firma inválida en %s: %v", v.Ruta, v.Error)
+		}
+	}
+
+	doc, err := xmldsig.ParseDocument(envio)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Root().Tag != "EnvioDTE" {
+		return nil, fmt.Errorf("el archivo no es un EnvioDTE: %s", doc.Root().Tag)
+	}
+	setDTE := doc.Root().SelectElement("SetDTE")
+	if setDTE == nil {
+		return nil, fmt.Errorf("el envío no contiene SetDTE")
+	}
+	resolucion, err := g.resolucion(setDTE.SelectElement("Caratula"))
+	if err != nil {
+		return nil, err
+	}
+
+	dtes := setDTE.SelectElements("DTE")
+	if len(dtes) == 0 {
+		return nil, fmt.Errorf("el envío no contiene documentos")
+	}
+	muestras := make([]Muestra, 0, len(dtes))
+	for _, dte := range dtes {
+		muestra, err := generarMuestra(dte, resolucion)
+		if err != nil {
+			return nil, err
+		}
+		muestras = append(muestras, *muestra)
+	}
+	return muestras, nil
+}
+
+// resolucion lee de la carátula la fecha y número de resolución del emisor
+func (g *Generador) resolucion(caratula *etree.Element) (utils.ResolucionSII, error) {
+	if caratula == nil {
+		return utils.ResolucionSII{}, fmt.Errorf("el envío no contiene carátula")
+	}
+	fecha, err := time.Parse("2006-01-02", textoHijo(caratula, "FchResol"))
+	if err != nil {
+		return utils.ResolucionSII{}, fmt.Errorf("fecha de resolución inválida en la carátula: %v", err)
+	}
+	numero, err := strconv.Atoi(textoHijo(caratula, "NroResol"))
+	if err != nil {
+		return utils.ResolucionSII{}, fmt.Errorf("número de resolución inválido en la carátula: %v", err)
+	}
+	return utils.ResolucionSII{Numero: numero, Fecha: fecha, Unidad: g.unidad}, nil
+}
+
+// generarMuestra dibuja la copia tributaria del DTE y, si el tipo la exige, la copia cedible
+// con el acuse de recibo
+func generarMuestra(dte *etree.Element, resolucion utils.ResolucionSII) (*Muestra, error) {
+	copia := etree.NewDocument()
+	copia.SetRoot(dte.Copy())
+	xmlDTE, err := copia.WriteToString()
+	if err != nil {
+		return nil, fmt.Errorf("error al leer DTE: %v", err)
+	}
+
+	var (
+		tipo    models.TipoDTE
+		folio   int
+		dibujar func(pdf *gofpdf.Fpdf) error
+	)
+	switch {
+	case dte.SelectElement("Documento") != nil:
+		var modelo models.DTEXMLModel
+		if err := xml.Unmarshal([]byte(xmlDTE), &modelo); err != nil {
+			return nil, fmt.Errorf("error al leer DTE: %v", err)
+		}
+		numero, err := strconv.Atoi(modelo.Documento.Encabezado.IdDoc.TipoDTE)
+		if err != nil {
+			return nil, fmt.Errorf("tipo de documento inválido en %s: %q", modelo.Documento.ID, modelo.Documento.Encabezado.IdDoc.TipoDTE)
+		}
+		tipo, folio = models.TipoDTE(numero), modelo.Documento.Encabezado.IdDoc.Folio
+		dibujar = func(pdf *gofpdf.Fpdf) error {
+			return utils.DibujarDTE(pdf, &modelo, xmlDTE, resolucion)
+		}
+	case dte.SelectElement("Exportaciones") != nil:
+		var modelo models.DTEExportacionXML
+		if err := xml.Unmarshal([]byte(xmlDTE), &modelo); err != nil {
+			return nil, fmt.Errorf("error al leer DTE: %v", err)
+		}
+		documento := documentoExportacion(&modelo, xmlDTE)
+		tipo, folio = documento.TipoDocumento, documento.Folio
+		dibujar = func(pdf *gofpdf.Fpdf) error {
+			return utils.DibujarDocumentoExportacion(pdf, documento, resolucion)
+		}
+	default:
+		return nil, fmt.Errorf("el DTE no contiene un documento con representación impresa")
+	}
+
+	muestra := &Muestra{
+		TipoDTE: tipo,
+		Folio:   folio,
+		Leyenda: utils.LeyendaCopiaCedible(tipo),
+		Archivo: fmt.Sprintf("T%dF%d.pdf", tipo, folio),
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	if err := dibujar(pdf); err != nil {
+		return nil, fmt.Errorf("error al dibujar documento tipo %d folio %d: %v", tipo, folio, err)
+	}
+	if muestra.Leyenda != "" {
+		pdf.AddPage()
+		if err := dibujar(pdf); err != nil {
+			return nil, fmt.Errorf("error al dibujar copia cedible tipo %d folio %d: %v", tipo, folio, err)
+		}
+		if err := utils.DibujarCopiaCedible(pdf, tipo, xmlDTE); err != nil {
+			return nil, fmt.Errorf("error al dibujar copia cedible tipo %d folio %d: %v", tipo, folio, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error al generar PDF: %v", err)
+	}
+	muestra.PDF = buf.Bytes()
+	return muestra, nil
+}
+
+// documentoExportacion convierte un DTE de exportación al documento que recibe el generador de
+// PDF de exportación
+func documentoExportacion(dte *models.DTEExportacionXML, xmlDTE string) *models.DocumentoTributario {
+	enc := dte.Exportacion.Encabezado
+	fechaEmision, _ := time.Parse("2006-01-02", enc.IdDoc.FchEmis)
+
+	documento := &models.DocumentoTributario{
+		Folio:               enc.IdDoc.Folio,
+		FechaEmision:        fechaEmision,
+		TipoDocumento:       models.TipoDTE(enc.IdDoc.TipoDTE),
+		TipoDTE:             strconv.Itoa(enc.IdDoc.TipoDTE),
+		RUTEmisor:           enc.Emisor.RUT,
+		RazonSocialEmisor:   enc.Emisor.RazonSocial,
+		GiroEmisor:          enc.Emisor.Giro,
+		DireccionEmisor:     enc.Emisor.Direccion,
+		ComunaEmisor:        enc.Emisor.Comuna,
+		RUTReceptor:         enc.Receptor.RUTRecep,
+		RazonSocialReceptor: enc.Receptor.RznSocRecep,
+		GiroReceptor:        enc.Receptor.GiroRecep,
+		DireccionReceptor:   enc.Receptor.DirRecep,
+		ComunaReceptor:      enc.Receptor.CmnaRecep,
+		MontoExento:         enc.Totales.MntExe,
+		MontoTotal:          enc.Totales.MntTotal,
+		XML:                 xmlDTE,
+		Exportacion: &models.DatosExportacion{
+			IndServicio: enc.IdDoc.IndServicio,
+			FmaPagExp:   enc.IdDoc.FmaPagExp,
+			TpoMoneda:   enc.Totales.TpoMoneda,
+		},
+	}
+
+	exp := documento.Exportacion
+	if extranjero := enc.Receptor.Extranjero; extranjero != nil {
+		exp.Extranjero = models.ReceptorExtranjero{NumID: extranjero.NumId, Nacionalidad: extranjero.Nacionalidad}
+	}
+	if enc.Transporte != nil {
+		aduana := enc.Transporte.Aduana
+		exp.Aduana = models.DatosAduana{
+			CodModVenta:    aduana.CodModVenta,
+			CodClauVenta:   aduana.CodClauVenta,
+			TotClauVenta:   aduana.TotClauVenta,
+			CodViaTransp:   aduana.CodViaTransp,
+			NombreTransp:   aduana.NombreTransp,
+			CodPtoEmbarque: aduana.CodPtoEmbarque,
+			CodPtoDesemb:   aduana.CodPtoDesemb,
+			TotBultos:      aduana.TotBultos,
+			MntFlete:       aduana.MntFlete,
+			MntSeguro:      aduana.MntSeguro,
+			CodPaisRecep:   aduana.CodPaisRecep,
+			CodPaisDestin:  aduana.CodPaisDestin,
+		}
+		for _, bulto := range aduana.TipoBultos {
+			exp.Aduana.TipoBultos = append(exp.Aduana.TipoBultos, models.TipoBulto{
+				CodTpoBultos: bulto.CodTpoBultos,
+				CantBultos:   bulto.CantBultos,
+				Marcas:       bulto.Marcas,
+				IdContainer:  bulto.IdContainer,
+				Sello:        bulto.Sello,
+			})
+		}
+	}
+	if otra := enc.OtraMoneda; otra != nil {
+		exp.TpoCambio = otra.TpoCambio
+		exp.MontoExentoPesos = otra.MntExeOtrMnda
+		exp.MontoTotalPesos = otra.MntTotOtrMnda
+	}
+
+	for _, det := range dte.Exportacion.Detalle {
+		documento.Detalles = append(documento.Detalles, models.DetalleTributario{
+			Descripcion:    det.NmbItem,
+			Cantidad:       int(det.QtyItem),
+			PrecioUnitario: det.PrcItem,
+			MontoItem:      det.MontoItem,
+			Exento:         true,
+			UnidadMedida:   det.UnmdItem,
+		})
+	}
+	for _, ref := range dte.Exportacion.Referencia {
+		folioRef, _ := strconv.Atoi(ref.FolioRef)
+		fechaRef, _ := time.Parse("2006-01-02", ref.FchRef)
+		documento.Referencias = append(documento.Referencias, models.Referencia{
+			TipoDocumento:   ref.TpoDocRef,
+			TipoReferencia:  models.TipoReferencia(ref.CodRef),
+			Folio:           folioRef,
+			FechaReferencia: fechaRef,
+			RazonReferencia: ref.RazonRef,
+		})
+	}
+	return documento
+}
+
+// Empaquetar escribe las muestras en un archivo ZIP, un PDF por documento
+func Empaquetar(w io.Writer, muestras []Muestra) error {
+	archivo := zip.NewWriter(w)
+	nombres := make(map[string]bool, len(muestras))
+	for _, muestra := range muestras {
+		if nombres[muestra.Archivo] {
+			return fmt.Errorf("documento repetido en las muestras: %s", muestra.Archivo)
+		}
+		nombres[muestra.Archivo] = true
+
+		entrada, err := archivo.Create(muestra.Archivo)
+		if err != nil {
+			return fmt.Errorf("error al agregar %s al archivo: %v", muestra.Archivo, err)
+		}
+		if _, err := entrada.Write(muestra.PDF); err != nil {
+			return fmt.Errorf("error al agregar %s al archivo: %v", muestra.Archivo, err)
+		}
+	}
+	if err := archivo.Close(); err != nil {
+		return fmt.Errorf("error al cerrar el archivo de muestras: %v", err)
+	}
+	return nil
+}
+
+// textoHijo retorna el texto sin espacios de un hijo directo
+func textoHijo(el *etree.Element, tag string) string {
+	if hijo := el.SelectElement(tag); hijo != nil {
+		return strings.TrimSpace(hijo.Text())
+	}
+	return ""
+}
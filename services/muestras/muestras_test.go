@@ -0,0 +1,152 @@
+package muestras
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/services/certificacion"
+	"github.com/cursor/FMgo/services/ted"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envioPrueba arma y firma el EnvioDTE del set de pruebas de certificación
+func envioPrueba(t *testing.T) []byte {
+	t.Helper()
+	manager := caf.NewManager("")
+	for _, tipo := range []int{33, 52, 56, 61} {
+		llave, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		privada := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(llave)})
+		autorizacion, err := caf.ParseCAF([]byte(fmt.Sprintf(`<AUTORIZACION><CAF version="1.0"><DA>
+<RE>76212889-6</RE><RS>COMERCIAL LOS ANDES</RS><TD>%d</TD><RNG><D>1</D><H>50</H></RNG><FA>2024-01-01</FA>
+<RSAPK><M>%s</M><E>%s</E></RSAPK><IDK>100</IDK></DA><FRMA algoritmo="SHA1withRSA">c2lp</FRMA></CAF>
+<RSASK>%s</RSASK></AUTORIZACION>`, tipo,
+			base64.StdEncoding.EncodeToString(llave.N.Bytes()),
+			base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()),
+			privada)))
+		require.NoError(t, err)
+		require.NoError(t, manager.AgregarCAF(autorizacion))
+	}
+
+	archivo, err := os.Open("../certificacion/testdata/set_pruebas.txt")
+	require.NoError(t, err)
+	defer archivo.Close()
+	set, err := certificacion.ParsearSet(archivo)
+	require.NoError(t, err)
+	set.Secciones = set.Secciones[:2]
+
+	params := certificacion.Parametros{
+		Emisor: certificacion.Contribuyente{
+			RUT: "76212889-6", RazonSocial: "Comercial Los Andes SpA", Giro: "Venta al por mayor",
+			Direccion: "Av. Apoquindo 4500", Comuna: "Las Condes", Ciudad: "Santiago",
+		},
+		Receptor: certificacion.Contribuyente{
+			RUT: "77777777-7", RazonSocial: "Distribuidora Sur Ltda", Giro: "Comercio",
+			Direccion: "Los Carrera 120", Comuna: "Concepción", Ciudad: "Concepción",
+		},
+		RutEnvia:     "11111111-1",
+		FchResol:     "2014-08-22",
+		NroResol:     80,
+		FechaEmision: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+		Transporte:   certificacion.Transporte{Patente: "HJKL12", RutChofer: "12345678-5", NombreChofer: "Juan Pérez"},
+	}
+	documentos, err := certificacion.GenerarDocumentos(set, params, certificacion.NewFoliosCAF(manager, nil))
+	require.NoError(t, err)
+	envio, err := certificacion.ConstruirEnvio(params, documentos, ted.NewGenerador(manager), time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "Usuario Certificación"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	firmado, err := xmldsig.NewFirmante(llave, cert).Firmar(envio)
+	require.NoError(t, err)
+	return firmado
+}
+
+// paginas cuenta las páginas de un PDF
+func paginas(pdf []byte) int {
+	return strings.Count(string(pdf), "/Type /Page\n")
+}
+
+func TestGenerar(t *testing.T) {
+	muestras, err := NewGenerador("Santiago Oriente").Generar(envioPrueba(t))
+	require.NoError(t, err)
+	require.Len(t, muestras, 7)
+
+	porArchivo := make(map[string]Muestra)
+	for _, muestra := range muestras {
+		porArchivo[muestra.Archivo] = muestra
+		assert.True(t, bytes.HasPrefix(muestra.PDF, []byte("%PDF-")), muestra.Archivo)
+	}
+
+	// Las facturas y guías llevan copia cedible en una segunda página; las notas no
+	factura := porArchivo["T33F1.pdf"]
+	assert.Equal(t, models.TipoFactura, factura.TipoDTE)
+	assert.Equal(t, utils.LeyendaCedible, factura.Leyenda)
+	assert.Equal(t, 2, paginas(factura.PDF))
+
+	guia := porArchivo["T52F2.pdf"]
+	assert.Equal(t, utils.LeyendaCedibleConFactura, guia.Leyenda)
+	assert.Equal(t, 2, paginas(guia.PDF))
+
+	nota := porArchivo["T61F1.pdf"]
+	assert.Empty(t, nota.Leyenda)
+	assert.Equal(t, 1, paginas(nota.PDF))
+	assert.Contains(t, porArchivo, "T56F1.pdf")
+}
+
+func TestGenerarEnvioAlterado(t *testing.T) {
+	envio := envioPrueba(t)
+	alterado := bytes.Replace(envio, []byte("Distribuidora Sur Ltda"), []byte("Distribuidora Norte Ltda"), 1)
+
+	_, err := NewGenerador("").Generar(alterado)
+	assert.ErrorContains(t, err, "firma inválida")
+
+	_, err = NewGenerador("").Generar([]byte(`<EnvioDTE/>`))
+	assert.Error(t, err)
+}
+
+func TestEmpaquetar(t *testing.T) {
+	muestras := []Muestra{
+		{Archivo: "T33F1.pdf", PDF: []byte("%PDF-1")},
+		{Archivo: "T61F1.pdf", PDF: []byte("%PDF-2")},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, Empaquetar(&buf, muestras))
+
+	lector, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, lector.File, 2)
+	assert.Equal(t, "T33F1.pdf", lector.File[0].Name)
+	assert.Equal(t, "T61F1.pdf", lector.File[1].Name)
+
+	err = Empaquetar(&bytes.Buffer{}, append(muestras, muestras[0]))
+	assert.ErrorContains(t, err, "documento repetido")
+}
@@ -5,13 +5,15 @@ import (
 	"fmt"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
 	"github.com/jung-kurt/gofpdf"
 )
 
 // TributarioPDF maneja la generación de PDFs para documentos tributarios
 type TributarioPDF struct {
-	pdf      *gofpdf.Fpdf
-	metadata map[string]string
+	pdf        *gofpdf.Fpdf
+	metadata   map[string]string
+	resolucion utils.ResolucionSII
 }
 
 // generarTablaImpuestos genera la tabla de impuestos del documento
@@ -164,15 +166,23 @@ func NewTributarioPDF() *TributarioPDF {
 	}
 }
 
+// SetResolucion asigna la resolución del emisor que se imprime bajo el timbre electrónico
+func (p *TributarioPDF) SetResolucion(resolucion utils.ResolucionSII) {
+	p.resolucion = resolucion
+}
+
 // GeneratePDF genera un PDF para un documento tributario
 func (p *TributarioPDF) GeneratePDF(doc interface{}) error {
+	if d, ok := doc.(*models.DocumentoTributario); ok && d.TipoDocumento.EsExportacion() {
+		return utils.DibujarDocumentoExportacion(p.pdf, d, p.resolucion)
+	}
 	p.agregarEncabezado(doc)
 	p.agregarDatosEmisorReceptor(doc)
+	p.agregarTransporte(doc)
 	p.agregarItems(doc)
 	p.generarTablaImpuestos(doc)
 	p.agregarObservaciones(doc)
-	p.agregarTimbreElectronico(doc)
-	return nil
+	return p.agregarTimbreElectronico(doc)
 }
 
 // SaveToFile guarda el PDF en un archivo
@@ -259,6 +269,41 @@ func (p *TributarioPDF) agregarDatosEmisorReceptor(doc interface{}) {
 	p.pdf.Ln(15)
 }
 
+// agregarTransporte agrega a las guías de despacho el tipo de traslado, el tipo de despacho y
+// los datos del transporte
+func (p *TributarioPDF) agregarTransporte(doc interface{}) {
+	guia, ok := doc.(*models.GuiaDespacho)
+	if !ok {
+		return
+	}
+	tr := p.pdf.UnicodeTranslatorFromDescriptor("")
+
+	lineas := [][2]string{
+		{"Tipo de traslado:", fmt.Sprintf("%s - %s", guia.IndicadorTraslado, models.GlosaIndTraslado(guia.IndicadorTraslado))},
+	}
+	if guia.TipoDespacho != "" {
+		lineas = append(lineas, [2]string{"Tipo de despacho:", fmt.Sprintf("%s - %s", guia.TipoDespacho, models.GlosaTipoDespacho(guia.TipoDespacho))})
+	}
+	if guia.Patente != "" || guia.RutTransportista != "" {
+		lineas = append(lineas, [2]string{"Transporte:", fmt.Sprintf("Patente %s  RUT transportista %s", guia.Patente, guia.RutTransportista)})
+	}
+	if guia.RutChofer != "" {
+		lineas = append(lineas, [2]string{"Chofer:", fmt.Sprintf("%s - %s", guia.RutChofer, guia.NombreChofer)})
+	}
+	if guia.DireccionDestino != "" {
+		lineas = append(lineas, [2]string{"Destino:", fmt.Sprintf("%s, %s %s", guia.DireccionDestino, guia.ComunaDestino, guia.CiudadDestino)})
+	}
+
+	for _, linea := range lineas {
+		p.pdf.SetFont("Arial", "B", 10)
+		p.pdf.Cell(40, 7, tr(linea[0]))
+		p.pdf.SetFont("Arial", "", 10)
+		p.pdf.Cell(0, 7, tr(linea[1]))
+		p.pdf.Ln(7)
+	}
+	p.pdf.Ln(5)
+}
+
 // agregarItems agrega los items al PDF
 func (p *TributarioPDF) agregarItems(doc interface{}) {
 	var items []models.Item
@@ -306,7 +351,25 @@ func (p *TributarioPDF) agregarObservaciones(doc interface{}) {
 	// Implementar según el tipo de documento (si es necesario)
 }
 
-// agregarTimbreElectronico agrega el timbre electrónico al PDF
-func (p *TributarioPDF) agregarTimbreElectronico(doc interface{}) {
-	// Implementar la lógica para agregar el timbre electrónico (si es necesario)
+// agregarTimbreElectronico agrega el timbre electrónico al pie del PDF como código PDF417
+func (p *TributarioPDF) agregarTimbreElectronico(doc interface{}) error {
+	var timbre string
+
+	switch d := doc.(type) {
+	case *models.Factura:
+		timbre = d.TimbreElectronico
+	case *models.Boleta:
+		timbre = d.TimbreElectronico
+	case *models.NotaCredito:
+		timbre = d.TimbreElectronico
+	case *models.NotaDebito:
+		timbre = d.TimbreElectronico
+	case *models.GuiaDespacho:
+		timbre = d.TimbreElectronico
+	}
+
+	if err := utils.DibujarTimbreAlPie(p.pdf, timbre, p.resolucion); err != nil {
+		return fmt.Errorf("error al agregar timbre electrónico: %v", err)
+	}
+	return nil
 }
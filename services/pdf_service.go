@@ -9,6 +9,7 @@ import (
 	"github.com/jung-kurt/gofpdf"
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
 )
 
 // PDFService maneja la generación de PDFs
@@ -16,6 +17,7 @@ type PDFService struct {
 	config       *config.SupabaseConfig
 	templatePath string
 	outputPath   string
+	resolucion   utils.ResolucionSII
 }
 
 // NewPDFService crea una nueva instancia del servicio de PDF
@@ -27,6 +29,11 @@ func NewPDFService(config *config.SupabaseConfig, templatePath, outputPath strin
 	}
 }
 
+// SetResolucion asigna la resolución del emisor que se imprime bajo el timbre electrónico
+func (s *PDFService) SetResolucion(resolucion utils.ResolucionSII) {
+	s.resolucion = resolucion
+}
+
 // GenerarPDF genera un PDF para una factura
 func (s *PDFService) GenerarPDF(factura *models.Factura) (string, error) {
 	// Crear PDF
@@ -94,6 +101,11 @@ func (s *PDFService) GenerarPDF(factura *models.Factura) (string, error) {
 	pdf.Cell(35, 10, "Total:")
 	pdf.Cell(35, 10, fmt.Sprintf("$%d", factura.Total))
 
+	// Timbre electrónico (PDF417)
+	if err := utils.DibujarTimbreAlPie(pdf, factura.TimbreElectronico, s.resolucion); err != nil {
+		return "", fmt.Errorf("error al agregar timbre electrónico: %v", err)
+	}
+
 	// Guardar PDF
 	outputFile := filepath.Join(s.outputPath, fmt.Sprintf("factura_%d.pdf", factura.Numero))
 	err := pdf.OutputFileAndClose(outputFile)
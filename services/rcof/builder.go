@@ -0,0 +1,185 @@
+package rcof
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// ParametrosConsumo contiene los datos de la carátula del reporte
+type ParametrosConsumo struct {
+	RutEmisor string
+	RutEnvia  string
+	FchResol  string // AAAA-MM-DD
+	NroResol  int
+	Fecha     time.Time
+	SecEnvio  int
+
+	// FoliosAnulados son los folios de boleta anulados ante el SII en el día que no tienen
+	// boleta; se informan como anulados junto a las boletas anuladas
+	FoliosAnulados map[models.TipoDTE][]int
+	// Ambiente limita los folios anulados que GenerarConsumo busca a los de ese ambiente
+	Ambiente models.AmbienteSII
+}
+
+// IDDocumento retorna el identificador del nodo DocumentoConsumoFolios
+func (p ParametrosConsumo) IDDocumento() string {
+	return fmt.Sprintf("RCOF_%s_%d", p.Fecha.Format("20060102"), p.SecEnvio)
+}
+
+// ConstruirConsumoFolios arma el reporte de consumo de folios de un día a partir de sus boletas
+func ConstruirConsumoFolios(params ParametrosConsumo, boletas []models.Boleta) (*models.ConsumoFoliosXML, error) {
+	if params.RutEmisor == "" || params.RutEnvia == "" {
+		return nil, fmt.Errorf("RUT emisor y RUT envía son requeridos")
+	}
+	if params.SecEnvio < 1 {
+		return nil, fmt.Errorf("secuencia de envío inválida: %d", params.SecEnvio)
+	}
+
+	resumenes, err := ResumirConsumo(boletas, params.FoliosAnulados)
+	if err != nil {
+		return nil, err
+	}
+
+	fecha := params.Fecha.Format("2006-01-02")
+	return &models.ConsumoFoliosXML{
+		Xmlns:   models.NamespaceSII,
+		Version: "1.0",
+		DocumentoConsumoFolios: models.DocumentoConsumoFoliosXML{
+			ID: params.IDDocumento(),
+			Caratula: models.CaratulaConsumoXML{
+				Version:      "1.0",
+				RutEmisor:    params.RutEmisor,
+				RutEnvia:     params.RutEnvia,
+				FchResol:     params.FchResol,
+				NroResol:     params.NroResol,
+				FchInicio:    fecha,
+				FchFinal:     fecha,
+				SecEnvio:     params.SecEnvio,
+				TmstFirmaEnv: time.Now().Format("2006-01-02T15:04:05"),
+			},
+			Resumen: resumenes,
+		},
+	}, nil
+}
+
+// ResumirBoletas agrupa las boletas por tipo (39/41) calculando montos y rangos de folios
+func ResumirBoletas(boletas []models.Boleta) ([]models.ResumenConsumoXML, error) {
+	return ResumirConsumo(boletas, nil)
+}
+
+// ResumirConsumo agrupa las boletas por tipo (39/41) y agrega a los anulados los folios
+// anulados sin boleta. Un folio anulado que también tiene boleta emitida se informa emitido.
+func ResumirConsumo(boletas []models.Boleta, foliosAnulados map[models.TipoDTE][]int) ([]models.ResumenConsumoXML, error) {
+	type acumulado struct {
+		resumen  models.ResumenConsumoXML
+		emitidos []int
+		anulados []int
+	}
+	porTipo := make(map[int]*acumulado)
+	acumuladoTipo := func(tipo int, folio int) (*acumulado, error) {
+		if tipo != int(models.TipoBoleta) && tipo != int(models.TipoBoletaExenta) {
+			return nil, fmt.Errorf("tipo de documento %d no corresponde a boleta (folio %d)", tipo, folio)
+		}
+		acc, ok := porTipo[tipo]
+		if !ok {
+			acc = &acumulado{resumen: models.ResumenConsumoXML{TipoDocumento: tipo}}
+			porTipo[tipo] = acc
+		}
+		return acc, nil
+	}
+
+	for _, boleta := range boletas {
+		tipo := int(boleta.TipoDocumento)
+		if tipo == 0 {
+			tipo = int(models.TipoBoleta)
+		}
+		acc, err := acumuladoTipo(tipo, boleta.Folio)
+		if err != nil {
+			return nil, err
+		}
+
+		if boleta.Estado == string(models.EstadoDTEAnulado) {
+			acc.anulados = append(acc.anulados, boleta.Folio)
+			continue
+		}
+
+		acc.emitidos = append(acc.emitidos, boleta.Folio)
+		acc.resumen.MntNeto += models.RedondearMonto(boleta.MontoNeto)
+		acc.resumen.MntIva += models.RedondearMonto(boleta.MontoIVA)
+		acc.resumen.MntExento += models.RedondearMonto(boleta.MontoExento)
+		acc.resumen.MntTotal += models.RedondearMonto(boleta.MontoTotal)
+		if boleta.TasaIVA > 0 {
+			acc.resumen.TasaIVA = boleta.TasaIVA
+		}
+	}
+
+	for tipoDTE, folios := range foliosAnulados {
+		if len(folios) == 0 {
+			continue
+		}
+		acc, err := acumuladoTipo(int(tipoDTE), folios[0])
+		if err != nil {
+			return nil, err
+		}
+		informados := make(map[int]bool, len(acc.emitidos)+len(acc.anulados))
+		for _, folio := range append(append([]int(nil), acc.emitidos...), acc.anulados...) {
+			informados[folio] = true
+		}
+		for _, folio := range folios {
+			if !informados[folio] {
+				informados[folio] = true
+				acc.anulados = append(acc.anulados, folio)
+			}
+		}
+	}
+
+	tipos := make([]int, 0, len(porTipo))
+	for tipo := range porTipo {
+		tipos = append(tipos, tipo)
+	}
+	sort.Ints(tipos)
+
+	resumenes := make([]models.ResumenConsumoXML, 0, len(tipos))
+	for _, tipo := range tipos {
+		acc := porTipo[tipo]
+		acc.resumen.FoliosEmitidos = len(acc.emitidos)
+		acc.resumen.FoliosAnulados = len(acc.anulados)
+		acc.resumen.FoliosUtilizados = len(acc.emitidos) + len(acc.anulados)
+		acc.resumen.RangoUtilizados = CalcularRangos(acc.emitidos)
+		acc.resumen.RangoAnulados = CalcularRangos(acc.anulados)
+		if acc.resumen.MntIva == 0 {
+			acc.resumen.TasaIVA = 0
+		}
+		resumenes = append(resumenes, acc.resumen)
+	}
+
+	return resumenes, nil
+}
+
+// CalcularRangos agrupa una lista de folios en rangos continuos
+func CalcularRangos(folios []int) []models.RangoFoliosXML {
+	if len(folios) == 0 {
+		return nil
+	}
+
+	ordenados := append([]int(nil), folios...)
+	sort.Ints(ordenados)
+
+	rangos := []models.RangoFoliosXML{{Inicial: ordenados[0], Final: ordenados[0]}}
+	for _, folio := range ordenados[1:] {
+		actual := &rangos[len(rangos)-1]
+		switch {
+		case folio == actual.Final:
+			// Folio duplicado, se ignora
+		case folio == actual.Final+1:
+			actual.Final = folio
+		default:
+			rangos = append(rangos, models.RangoFoliosXML{Inicial: folio, Final: folio})
+		}
+	}
+
+	return rangos
+}
@@ -0,0 +1,82 @@
+package rcof
+
+import (
+	"testing"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcularRangos(t *testing.T) {
+	rangos := CalcularRangos([]int{7, 3, 1, 2, 5, 6, 10})
+	assert.Equal(t, []models.RangoFoliosXML{
+		{Inicial: 1, Final: 3},
+		{Inicial: 5, Final: 7},
+		{Inicial: 10, Final: 10},
+	}, rangos)
+	assert.Nil(t, CalcularRangos(nil))
+}
+
+func TestResumirBoletas(t *testing.T) {
+	boletas := []models.Boleta{
+		{Folio: 1, TipoDocumento: models.TipoBoleta, MontoNeto: 1000, MontoIVA: 190, TasaIVA: 19, MontoTotal: 1190},
+		{Folio: 2, TipoDocumento: models.TipoBoleta, MontoNeto: 500, MontoIVA: 95, TasaIVA: 19, MontoTotal: 595},
+		{Folio: 3, TipoDocumento: models.TipoBoleta, Estado: string(models.EstadoDTEAnulado), MontoTotal: 1000},
+		{Folio: 20, TipoDocumento: models.TipoBoletaExenta, MontoExento: 300, MontoTotal: 300},
+	}
+
+	resumenes, err := ResumirBoletas(boletas)
+	require.NoError(t, err)
+	require.Len(t, resumenes, 2)
+
+	afecta := resumenes[0]
+	assert.Equal(t, 39, afecta.TipoDocumento)
+	assert.Equal(t, int64(1500), afecta.MntNeto)
+	assert.Equal(t, int64(285), afecta.MntIva)
+	assert.Equal(t, int64(1785), afecta.MntTotal)
+	assert.Equal(t, 2, afecta.FoliosEmitidos)
+	assert.Equal(t, 1, afecta.FoliosAnulados)
+	assert.Equal(t, 3, afecta.FoliosUtilizados)
+	assert.Equal(t, []models.RangoFoliosXML{{Inicial: 1, Final: 2}}, afecta.RangoUtilizados)
+	assert.Equal(t, []models.RangoFoliosXML{{Inicial: 3, Final: 3}}, afecta.RangoAnulados)
+
+	exenta := resumenes[1]
+	assert.Equal(t, 41, exenta.TipoDocumento)
+	assert.Zero(t, exenta.TasaIVA)
+	assert.Equal(t, int64(300), exenta.MntExento)
+
+	_, err = ResumirBoletas([]models.Boleta{{Folio: 1, TipoDocumento: models.TipoFactura}})
+	assert.Error(t, err)
+}
+
+func TestResumirConsumoConFoliosAnulados(t *testing.T) {
+	boletas := []models.Boleta{
+		{Folio: 10, TipoDocumento: models.TipoBoleta, MontoNeto: 1000, MontoIVA: 190, TasaIVA: 19, MontoTotal: 1190},
+		{Folio: 11, TipoDocumento: models.TipoBoleta, Estado: string(models.EstadoDTEAnulado), MontoTotal: 500},
+	}
+	anulados := map[models.TipoDTE][]int{
+		models.TipoBoleta:       {5, 6, 7, 10, 11},
+		models.TipoBoletaExenta: {300},
+	}
+
+	resumenes, err := ResumirConsumo(boletas, anulados)
+	require.NoError(t, err)
+	require.Len(t, resumenes, 2)
+
+	afecta := resumenes[0]
+	assert.Equal(t, 1, afecta.FoliosEmitidos)
+	assert.Equal(t, 4, afecta.FoliosAnulados)
+	assert.Equal(t, 5, afecta.FoliosUtilizados)
+	assert.Equal(t, []models.RangoFoliosXML{{Inicial: 10, Final: 10}}, afecta.RangoUtilizados)
+	assert.Equal(t, []models.RangoFoliosXML{{Inicial: 5, Final: 7}, {Inicial: 11, Final: 11}}, afecta.RangoAnulados)
+
+	exenta := resumenes[1]
+	assert.Equal(t, 41, exenta.TipoDocumento)
+	assert.Zero(t, exenta.FoliosEmitidos)
+	assert.Equal(t, 1, exenta.FoliosAnulados)
+	assert.Zero(t, exenta.MntTotal)
+
+	_, err = ResumirConsumo(nil, map[models.TipoDTE][]int{models.TipoFactura: {1}})
+	assert.Error(t, err)
+}
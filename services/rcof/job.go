@@ -0,0 +1,112 @@
+package rcof
+
+import (
+	"context"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// EmpresaConsumo contiene los datos de una empresa que emite boletas
+type EmpresaConsumo struct {
+	RutEmisor string
+	RutEnvia  string
+	FchResol  string
+	NroResol  int
+	Ambiente  models.AmbienteSII
+}
+
+// Job ejecuta diariamente el envío del consumo de folios del día anterior
+type Job struct {
+	service  *Service
+	empresas []EmpresaConsumo
+	hora     int
+}
+
+// NewJob crea el job diario. hora indica la hora local (0-23) de ejecución.
+func NewJob(service *Service, empresas []EmpresaConsumo, hora int) *Job {
+	return &Job{
+		service:  service,
+		empresas: empresas,
+		hora:     hora,
+	}
+}
+
+// Iniciar bloquea ejecutando el job una vez al día hasta que se cancele el contexto
+func (j *Job) Iniciar(ctx context.Context) {
+	for {
+		espera := time.Until(j.proximaEjecucion(time.Now()))
+		timer := time.NewTimer(espera)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case ahora := <-timer.C:
+			j.EjecutarDia(ctx, ahora.AddDate(0, 0, -1))
+		}
+	}
+}
+
+// EjecutarDia genera y envía el consumo de folios del día indicado para todas las empresas.
+// Las empresas que ya tienen el día enviado se omiten.
+func (j *Job) EjecutarDia(ctx context.Context, fecha time.Time) {
+	for _, empresa := range j.empresas {
+		if err := j.procesarEmpresa(ctx, empresa, fecha); err != nil {
+			utils.LogError(err,
+				zap.String("proceso", "rcof"),
+				zap.String("rut_emisor", empresa.RutEmisor),
+				zap.String("fecha", fecha.Format("2006-01-02")),
+			)
+		}
+	}
+}
+
+// procesarEmpresa genera y envía el reporte de una empresa
+func (j *Job) procesarEmpresa(ctx context.Context, empresa EmpresaConsumo, fecha time.Time) error {
+	ultimo, err := j.service.ObtenerUltimo(ctx, empresa.RutEmisor, fecha.Format("2006-01-02"))
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if ultimo != nil && ultimo.Estado != models.EstadoRCOFGenerado && ultimo.Estado != models.EstadoRCOFError {
+		return nil
+	}
+
+	consumo := ultimo
+	if consumo == nil || consumo.Estado == models.EstadoRCOFError {
+		consumo, err = j.service.GenerarConsumo(ctx, ParametrosConsumo{
+			RutEmisor: empresa.RutEmisor,
+			RutEnvia:  empresa.RutEnvia,
+			FchResol:  empresa.FchResol,
+			NroResol:  empresa.NroResol,
+			Fecha:     fecha,
+			Ambiente:  empresa.Ambiente,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := j.service.EnviarConsumo(ctx, consumo, empresa.RutEnvia); err != nil {
+		return err
+	}
+
+	utils.LogInfo("consumo de folios enviado",
+		zap.String("rut_emisor", empresa.RutEmisor),
+		zap.String("fecha", consumo.Fecha),
+		zap.String("track_id", consumo.TrackID),
+	)
+	return nil
+}
+
+// proximaEjecucion calcula el próximo instante de ejecución a partir de ahora
+func (j *Job) proximaEjecucion(ahora time.Time) time.Time {
+	proxima := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), j.hora, 0, 0, 0, ahora.Location())
+	if !proxima.After(ahora) {
+		proxima = proxima.AddDate(0, 0, 1)
+	}
+	return proxima
+}
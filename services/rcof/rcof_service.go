@@ -0,0 +1,287 @@
+package rcof
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones utilizadas por el servicio de consumo de folios
+const (
+	ColeccionConsumoFolios = "consumo_folios"
+	ColeccionBoletas       = "boletas"
+	ColeccionFolios        = "folios"
+)
+
+// SchemaConsumoFolios es la clave del esquema del reporte en el validador XML. El esquema
+// oficial ConsumoFolio_v10.xsd del SII no se incluye en schemas/: se debe copiar sin
+// modificaciones antes de registrarlo.
+const SchemaConsumoFolios = "ConsumoFolio"
+
+// Firmador firma un documento XML completo referenciando el ID indicado
+type Firmador interface {
+	FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error)
+}
+
+// Validador valida un documento XML contra un esquema registrado
+type Validador interface {
+	ValidateXML(xmlData []byte, schemaType string) error
+}
+
+// Uploader envía archivos al servicio de recepción del SII
+type Uploader interface {
+	Subir(ctx context.Context, token, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error)
+}
+
+// Tokens ejecuta operaciones con el token vigente de la empresa, renovándolo si el SII lo rechaza
+type Tokens interface {
+	ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error
+}
+
+// Service genera y envía los reportes de consumo de folios de boletas
+type Service struct {
+	db        *mongo.Database
+	firmador  Firmador
+	validador Validador
+	uploader  Uploader
+	tokens    Tokens
+}
+
+// NewService crea una nueva instancia del servicio de consumo de folios. Los envíos usan los
+// tokens compartidos de token.Manager.
+func NewService(db *mongo.Database, firmador Firmador, validador Validador, uploader Uploader, tokens Tokens) *Service {
+	return &Service{
+		db:        db,
+		firmador:  firmador,
+		validador: validador,
+		uploader:  uploader,
+		tokens:    tokens,
+	}
+}
+
+// GenerarConsumo construye y firma el reporte del día. Si ya existe un reporte para la
+// empresa y fecha, se genera uno nuevo con la siguiente secuencia de envío.
+func (s *Service) GenerarConsumo(ctx context.Context, params ParametrosConsumo) (*models.ConsumoFolios, error) {
+	fecha := params.Fecha.Format("2006-01-02")
+
+	anterior, err := s.ObtenerUltimo(ctx, params.RutEmisor, fecha)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	params.SecEnvio = 1
+	if anterior != nil {
+		params.SecEnvio = anterior.SecEnvio + 1
+	}
+
+	boletas, err := s.obtenerBoletasDia(ctx, params.RutEmisor, params.Fecha)
+	if err != nil {
+		return nil, err
+	}
+	if params.FoliosAnulados == nil {
+		if params.FoliosAnulados, err = s.obtenerFoliosAnuladosDia(ctx, params.RutEmisor, params.Ambiente, params.Fecha); err != nil {
+			return nil, err
+		}
+	}
+
+	consumoXML, err := ConstruirConsumoFolios(params, boletas)
+	if err != nil {
+		return nil, fmt.Errorf("error al construir consumo de folios: %v", err)
+	}
+
+	xmlData, err := xml.Marshal(consumoXML)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar consumo de folios: %v", err)
+	}
+	if xmlData, err = xmldsig.CodificarLatin1(xmlData); err != nil {
+		return nil, fmt.Errorf("error al codificar consumo de folios: %v", err)
+	}
+
+	xmlFirmado, err := s.firmador.FirmarDocumento(xmlData, consumoXML.DocumentoConsumoFolios.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error al firmar consumo de folios: %v", err)
+	}
+
+	if s.validador != nil {
+		if err := s.validador.ValidateXML(xmlFirmado, SchemaConsumoFolios); err != nil {
+			return nil, fmt.Errorf("consumo de folios no cumple el esquema: %v", err)
+		}
+	}
+
+	ahora := time.Now()
+	consumo := &models.ConsumoFolios{
+		ID:        models.GenerateID(),
+		RutEmisor: params.RutEmisor,
+		Fecha:     fecha,
+		SecEnvio:  params.SecEnvio,
+		Resumenes: consumoXML.DocumentoConsumoFolios.Resumen,
+		XML:       string(xmlFirmado),
+		Estado:    models.EstadoRCOFGenerado,
+		CreatedAt: ahora,
+		UpdatedAt: ahora,
+	}
+
+	if _, err := s.db.Collection(ColeccionConsumoFolios).InsertOne(ctx, consumo); err != nil {
+		return nil, fmt.Errorf("error al guardar consumo de folios: %v", err)
+	}
+
+	return consumo, nil
+}
+
+// EnviarConsumo sube el reporte al SII y registra el TrackID y resultado
+func (s *Service) EnviarConsumo(ctx context.Context, consumo *models.ConsumoFolios, rutEnvia string) error {
+	nombreArchivo := fmt.Sprintf("RCOF_%s_%s_%d.xml", consumo.RutEmisor, consumo.Fecha, consumo.SecEnvio)
+	var respuesta *models.RespuestaUploadSII
+	errEnvio := s.tokens.ConToken(ctx, consumo.RutEmisor, func(token string) error {
+		var err error
+		respuesta, err = s.uploader.Subir(ctx, token, rutEnvia, consumo.RutEmisor, nombreArchivo, []byte(consumo.XML))
+		return err
+	})
+
+	ahora := time.Now()
+	actualizacion := bson.M{"updated_at": ahora}
+	if errEnvio != nil {
+		consumo.Estado = models.EstadoRCOFError
+		consumo.Glosa = errEnvio.Error()
+	} else {
+		consumo.Estado = models.EstadoRCOFEnviado
+		consumo.TrackID = respuesta.TrackID
+		consumo.Glosa = ""
+		consumo.FechaEnvio = &ahora
+		actualizacion["track_id"] = respuesta.TrackID
+		actualizacion["fecha_envio"] = ahora
+	}
+	actualizacion["estado"] = consumo.Estado
+	actualizacion["glosa"] = consumo.Glosa
+	consumo.UpdatedAt = ahora
+
+	if _, err := s.db.Collection(ColeccionConsumoFolios).UpdateOne(ctx, bson.M{"_id": consumo.ID}, bson.M{"$set": actualizacion}); err != nil {
+		return fmt.Errorf("error al actualizar consumo de folios: %v", err)
+	}
+
+	if errEnvio != nil {
+		return fmt.Errorf("error al enviar consumo de folios al SII: %v", errEnvio)
+	}
+	return nil
+}
+
+// ReenviarDia regenera el reporte de un día ya informado (por ejemplo, tras corregir
+// boletas) con una nueva secuencia de envío y lo envía al SII
+func (s *Service) ReenviarDia(ctx context.Context, params ParametrosConsumo) (*models.ConsumoFolios, error) {
+	consumo, err := s.GenerarConsumo(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.EnviarConsumo(ctx, consumo, params.RutEnvia); err != nil {
+		return consumo, err
+	}
+	return consumo, nil
+}
+
+// ActualizarEstado registra el resultado de la revisión del reporte por parte del SII
+func (s *Service) ActualizarEstado(ctx context.Context, trackID string, estado models.EstadoConsumoFolios, glosa string) error {
+	resultado, err := s.db.Collection(ColeccionConsumoFolios).UpdateOne(ctx,
+		bson.M{"track_id": trackID},
+		bson.M{"$set": bson.M{
+			"estado":     estado,
+			"glosa":      glosa,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("error al actualizar estado del consumo de folios: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("no existe consumo de folios con TrackID %s", trackID)
+	}
+	return nil
+}
+
+// ObtenerUltimo obtiene el último reporte generado para una empresa y fecha (AAAA-MM-DD)
+func (s *Service) ObtenerUltimo(ctx context.Context, rutEmisor, fecha string) (*models.ConsumoFolios, error) {
+	opts := options.FindOne().SetSort(bson.M{"sec_envio": -1})
+	var consumo models.ConsumoFolios
+	err := s.db.Collection(ColeccionConsumoFolios).FindOne(ctx, bson.M{
+		"rut_emisor": rutEmisor,
+		"fecha":      fecha,
+	}, opts).Decode(&consumo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error al obtener consumo de folios: %v", err)
+	}
+	return &consumo, nil
+}
+
+// obtenerBoletasDia obtiene las boletas emitidas por la empresa en el día indicado
+func (s *Service) obtenerBoletasDia(ctx context.Context, rutEmisor string, fecha time.Time) ([]models.Boleta, error) {
+	inicio := time.Date(fecha.Year(), fecha.Month(), fecha.Day(), 0, 0, 0, 0, fecha.Location())
+	fin := inicio.AddDate(0, 0, 1)
+
+	cursor, err := s.db.Collection(ColeccionBoletas).Find(ctx, bson.M{
+		"rut_emisor":     rutEmisor,
+		"fecha_emision":  bson.M{"$gte": inicio, "$lt": fin},
+		"tipo_documento": bson.M{"$in": []models.TipoDTE{models.TipoBoleta, models.TipoBoletaExenta}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener boletas del día: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var boletas []models.Boleta
+	if err := cursor.All(ctx, &boletas); err != nil {
+		return nil, fmt.Errorf("error al decodificar boletas: %v", err)
+	}
+	return boletas, nil
+}
+
+// obtenerFoliosAnuladosDia obtiene los folios de boleta cuya anulación aprobó el SII en el día
+// indicado. Los anulados por haberse anulado la boleta antes del envío se omiten, porque la
+// boleta ya se informó anulada el día de su emisión.
+func (s *Service) obtenerFoliosAnuladosDia(ctx context.Context, rutEmisor string, ambiente models.AmbienteSII, fecha time.Time) (map[models.TipoDTE][]int, error) {
+	inicio := time.Date(fecha.Year(), fecha.Month(), fecha.Day(), 0, 0, 0, 0, fecha.Location())
+	fin := inicio.AddDate(0, 0, 1)
+
+	filtro := bson.M{
+		"rut_emisor":       rutEmisor,
+		"estado":           "ANULADO",
+		"tipo_dte":         bson.M{"$in": []string{strconv.Itoa(int(models.TipoBoleta)), strconv.Itoa(int(models.TipoBoletaExenta))}},
+		"fecha_anulacion":  bson.M{"$gte": inicio, "$lt": fin},
+		"motivo_anulacion": bson.M{"$ne": models.MotivoAnuladoAntesEnvio},
+	}
+	if ambiente != "" {
+		filtro["ambiente"] = ambiente
+	}
+	cursor, err := s.db.Collection(ColeccionFolios).Find(ctx, filtro,
+		options.Find().SetProjection(bson.M{"tipo_dte": 1, "numero": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener folios anulados del día: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var folios []struct {
+		TipoDTE string `bson:"tipo_dte"`
+		Numero  int    `bson:"numero"`
+	}
+	if err := cursor.All(ctx, &folios); err != nil {
+		return nil, fmt.Errorf("error al decodificar folios anulados: %v", err)
+	}
+
+	anulados := make(map[models.TipoDTE][]int)
+	for _, f := range folios {
+		tipo, err := strconv.Atoi(f.TipoDTE)
+		if err != nil {
+			return nil, fmt.Errorf("tipo de documento inválido en folio anulado %d: %s", f.Numero, f.TipoDTE)
+		}
+		anulados[models.TipoDTE(tipo)] = append(anulados[models.TipoDTE(tipo)], f.Numero)
+	}
+	return anulados, nil
+}
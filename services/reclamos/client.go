@@ -0,0 +1,189 @@
+package reclamos
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/utils"
+)
+
+// NamespaceRegistroReclamo es el namespace del servicio registroreclamodteservice
+const NamespaceRegistroReclamo = "http://ws.registroreclamodte.diii.sdi.des.sii.cl"
+
+// Documento identifica un DTE ante el servicio (la tripleta RUT emisor, tipo y folio es única)
+type Documento struct {
+	RutEmisor string
+	TipoDoc   int
+	Folio     int64
+}
+
+// Ambientes resuelve las URLs del ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	EndpointsEmpresa(rutEmpresa string) (ambiente.Endpoints, error)
+}
+
+// Client consume el Web Service de Consulta y Registro de Aceptación/Reclamo a DTE recibido.
+// Cada consulta va al servicio del ambiente de la empresa que la hace, dueña del token.
+type Client struct {
+	client    *http.Client
+	ambientes Ambientes
+}
+
+// NewClient crea un cliente que toma la URL del servicio del ambiente de cada empresa
+func NewClient(ambientes Ambientes, timeout time.Duration) *Client {
+	return &Client{
+		client:    &http.Client{Timeout: timeout},
+		ambientes: ambientes,
+	}
+}
+
+// respuestaWS es el nodo return de los métodos del servicio
+type respuestaWS struct {
+	CodResp         int                   `xml:"codResp"`
+	DescResp        string                `xml:"descResp"`
+	ListaEventosDoc []models.EventoDTESII `xml:"listaEventosDoc"`
+	Texto           string                `xml:",chardata"`
+}
+
+// sobreRespuesta es el sobre SOAP de respuesta
+type sobreRespuesta struct {
+	Body struct {
+		Fault *struct {
+			FaultString string `xml:"faultstring"`
+		} `xml:"Fault"`
+		Respuesta struct {
+			Return respuestaWS `xml:"return"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// IngresarAceptacionReclamoDoc registra una acción (ACD, RCD, ERM, RFP o RFT) sobre un documento
+func (c *Client) IngresarAceptacionReclamoDoc(ctx context.Context, token, rutEmpresa string, doc Documento, accion models.AccionRegistro) (*models.RespuestaRegistroReclamo, error) {
+	if !accion.EsValida() {
+		return nil, fmt.Errorf("acción inválida: %s", accion)
+	}
+	ret, err := c.invocar(ctx, token, rutEmpresa, "ingresarAceptacionReclamoDoc", doc, campo{"accionDoc", string(accion)})
+	if err != nil {
+		return nil, err
+	}
+	return &models.RespuestaRegistroReclamo{Codigo: ret.CodResp, Descripcion: ret.DescResp}, nil
+}
+
+// ListarEventosHistDoc obtiene los eventos de aceptación o reclamo registrados sobre un documento
+func (c *Client) ListarEventosHistDoc(ctx context.Context, token, rutEmpresa string, doc Documento) (*models.RespuestaRegistroReclamo, []models.EventoDTESII, error) {
+	ret, err := c.invocar(ctx, token, rutEmpresa, "listarEventosHistDoc", doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.RespuestaRegistroReclamo{Codigo: ret.CodResp, Descripcion: ret.DescResp}, ret.ListaEventosDoc, nil
+}
+
+// ConsultarDocDteCedible consulta si un documento puede cederse
+func (c *Client) ConsultarDocDteCedible(ctx context.Context, token, rutEmpresa string, doc Documento) (*models.RespuestaRegistroReclamo, error) {
+	ret, err := c.invocar(ctx, token, rutEmpresa, "consultarDocDteCedible", doc)
+	if err != nil {
+		return nil, err
+	}
+	return &models.RespuestaRegistroReclamo{Codigo: ret.CodResp, Descripcion: ret.DescResp}, nil
+}
+
+// ConsultarFechaRecepcionSii obtiene la fecha en que el SII recibió el documento, tal como la
+// informa el servicio
+func (c *Client) ConsultarFechaRecepcionSii(ctx context.Context, token, rutEmpresa string, doc Documento) (string, error) {
+	ret, err := c.invocar(ctx, token, rutEmpresa, "consultarFechaRecepcionSii", doc)
+	if err != nil {
+		return "", err
+	}
+	fecha := strings.TrimSpace(ret.Texto)
+	if fecha == "" {
+		return "", fmt.Errorf("el SII no informó fecha de recepción")
+	}
+	return fecha, nil
+}
+
+// campo es un parámetro adicional del método invocado
+type campo struct {
+	nombre, valor string
+}
+
+// invocar envía la petición SOAP autenticada con el token de la empresa al servicio de su
+// ambiente y retorna el nodo return
+func (c *Client) invocar(ctx context.Context, token, rutEmpresa, metodo string, doc Documento, extra ...campo) (*respuestaWS, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token es requerido")
+	}
+	endpoints, err := c.ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+	rut, dv, err := utils.SepararRUT(doc.RutEmisor)
+	if err != nil {
+		return nil, err
+	}
+
+	campos := append([]campo{
+		{"rutEmisor", rut},
+		{"dvEmisor", dv},
+		{"tipoDoc", strconv.Itoa(doc.TipoDoc)},
+		{"folio", strconv.FormatInt(doc.Folio, 10)},
+	}, extra...)
+
+	var parametros strings.Builder
+	for _, p := range campos {
+		parametros.WriteString("<" + p.nombre + ">")
+		xml.EscapeText(&parametros, []byte(p.valor))
+		parametros.WriteString("</" + p.nombre + ">")
+	}
+
+	soapRequest := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ws="%s">
+   <soapenv:Header/>
+   <soapenv:Body>
+      <ws:%s>%s</ws:%s>
+   </soapenv:Body>
+</soapenv:Envelope>`, NamespaceRegistroReclamo, metodo, parametros.String(), metodo)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.RCV, bytes.NewBufferString(soapRequest))
+	if err != nil {
+		return nil, fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/xml;charset=UTF-8")
+	req.Header.Set("SOAPAction", "")
+	req.Header.Set("Cookie", "TOKEN="+token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al invocar %s: %v", metodo, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer respuesta de %s: %v", metodo, err)
+	}
+
+	var sobre sobreRespuesta
+	if err := xml.Unmarshal(body, &sobre); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error HTTP %d al invocar %s", resp.StatusCode, metodo)
+		}
+		return nil, fmt.Errorf("error al decodificar respuesta de %s: %v", metodo, err)
+	}
+	if sobre.Body.Fault != nil {
+		return nil, fmt.Errorf("el SII retornó un error en %s: %s", metodo, sobre.Body.Fault.FaultString)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d al invocar %s", resp.StatusCode, metodo)
+	}
+
+	return &sobre.Body.Respuesta.Return, nil
+}
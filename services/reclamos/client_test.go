@@ -0,0 +1,135 @@
+package reclamos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rutEmpresaPrueba es la empresa que consulta el registro en las pruebas
+const rutEmpresaPrueba = "77888999-1"
+
+// registroPrueba registra la empresa de prueba en certificación con el servicio en url
+func registroPrueba(t *testing.T, url string) *ambiente.Registro {
+	registro := ambiente.NewRegistro()
+	endpoints := ambiente.Certificacion
+	endpoints.RCV = url
+	require.NoError(t, registro.SetEndpoints(models.AmbienteCertificacion, endpoints))
+	require.NoError(t, registro.AsignarAmbiente(rutEmpresaPrueba, models.AmbienteCertificacion))
+	return registro
+}
+
+func servidorPrueba(t *testing.T, respuesta string, peticion *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("TOKEN")
+		require.NoError(t, err)
+		assert.Equal(t, "TOKEN123", cookie.Value)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if peticion != nil {
+			*peticion = string(body)
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` + respuesta + `</soap:Body></soap:Envelope>`))
+	}))
+}
+
+func TestIngresarAceptacionReclamoDoc(t *testing.T) {
+	var peticion string
+	server := servidorPrueba(t, `<ns2:ingresarAceptacionReclamoDocResponse xmlns:ns2="`+NamespaceRegistroReclamo+`">
+		<return><codResp>0</codResp><descResp>Accion Completada OK</descResp></return>
+	</ns2:ingresarAceptacionReclamoDocResponse>`, &peticion)
+	defer server.Close()
+
+	client := NewClient(registroPrueba(t, server.URL), 5*time.Second)
+	respuesta, err := client.IngresarAceptacionReclamoDoc(context.Background(), "TOKEN123", rutEmpresaPrueba,
+		Documento{RutEmisor: "76.123.456-k", TipoDoc: 33, Folio: 1500}, models.AccionReclamoContenido)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.CodigoRegistroOK, respuesta.Codigo)
+	assert.Equal(t, "Accion Completada OK", respuesta.Descripcion)
+	assert.Contains(t, peticion, "<ws:ingresarAceptacionReclamoDoc>")
+	assert.Contains(t, peticion, "<rutEmisor>76123456</rutEmisor><dvEmisor>K</dvEmisor>")
+	assert.Contains(t, peticion, "<tipoDoc>33</tipoDoc><folio>1500</folio><accionDoc>RCD</accionDoc>")
+}
+
+func TestIngresarAccionInvalida(t *testing.T) {
+	client := NewClient(registroPrueba(t, "http://localhost"), time.Second)
+	_, err := client.IngresarAceptacionReclamoDoc(context.Background(), "TOKEN123", rutEmpresaPrueba,
+		Documento{RutEmisor: "76123456-0", TipoDoc: 33, Folio: 1}, "XXX")
+	assert.Error(t, err)
+}
+
+func TestListarEventosHistDoc(t *testing.T) {
+	server := servidorPrueba(t, `<ns2:listarEventosHistDocResponse xmlns:ns2="`+NamespaceRegistroReclamo+`">
+		<return>
+			<codResp>15</codResp><descResp>Listado de eventos del documento</descResp>
+			<listaEventosDoc>
+				<codEvento>ERM</codEvento><descEvento>Otorga Recibo de Mercaderias o Servicios</descEvento>
+				<rutResponsable>77888999</rutResponsable><dvResponsable>1</dvResponsable>
+				<fechaEvento>2024-03-01 10:15:00</fechaEvento>
+			</listaEventosDoc>
+			<listaEventosDoc>
+				<codEvento>ACD</codEvento><descEvento>Acepta Contenido del Documento</descEvento>
+				<rutResponsable>77888999</rutResponsable><dvResponsable>1</dvResponsable>
+				<fechaEvento>2024-03-01 10:16:00</fechaEvento>
+			</listaEventosDoc>
+		</return>
+	</ns2:listarEventosHistDocResponse>`, nil)
+	defer server.Close()
+
+	client := NewClient(registroPrueba(t, server.URL), 5*time.Second)
+	respuesta, eventos, err := client.ListarEventosHistDoc(context.Background(), "TOKEN123", rutEmpresaPrueba,
+		Documento{RutEmisor: "76123456-0", TipoDoc: 33, Folio: 1500})
+	require.NoError(t, err)
+
+	assert.Equal(t, models.CodigoListadoEventos, respuesta.Codigo)
+	require.Len(t, eventos, 2)
+	assert.Equal(t, "ERM", eventos[0].CodEvento)
+	assert.Equal(t, "77888999", eventos[0].RutResponsable)
+	assert.Equal(t, "1", eventos[0].DvResponsable)
+	assert.Equal(t, "2024-03-01 10:16:00", eventos[1].FechaEvento)
+}
+
+func TestConsultarFechaRecepcionSii(t *testing.T) {
+	server := servidorPrueba(t, `<ns2:consultarFechaRecepcionSiiResponse xmlns:ns2="`+NamespaceRegistroReclamo+`">
+		<return>01-03-2024 09:30:12</return>
+	</ns2:consultarFechaRecepcionSiiResponse>`, nil)
+	defer server.Close()
+
+	client := NewClient(registroPrueba(t, server.URL), 5*time.Second)
+	fecha, err := client.ConsultarFechaRecepcionSii(context.Background(), "TOKEN123", rutEmpresaPrueba,
+		Documento{RutEmisor: "76123456-0", TipoDoc: 33, Folio: 1500})
+	require.NoError(t, err)
+	assert.Equal(t, "01-03-2024 09:30:12", fecha)
+}
+
+func TestInvocarFault(t *testing.T) {
+	server := servidorPrueba(t, `<soap:Fault><faultcode>soap:Server</faultcode><faultstring>Token invalido</faultstring></soap:Fault>`, nil)
+	defer server.Close()
+
+	client := NewClient(registroPrueba(t, server.URL), 5*time.Second)
+	_, err := client.ConsultarDocDteCedible(context.Background(), "TOKEN123", rutEmpresaPrueba,
+		Documento{RutEmisor: "76123456-0", TipoDoc: 33, Folio: 1500})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Token invalido"))
+}
+
+func TestInvocarEmpresaSinAmbiente(t *testing.T) {
+	client := NewClient(registroPrueba(t, "http://localhost"), time.Second)
+	_, err := client.ConsultarDocDteCedible(context.Background(), "TOKEN123", "76123456-0",
+		Documento{RutEmisor: "76123456-0", TipoDoc: 33, Folio: 1500})
+	assert.True(t, errors.Is(err, ambiente.ErrEmpresaDesconocida))
+}
@@ -0,0 +1,69 @@
+package reclamos
+
+import (
+	"context"
+	"time"
+
+	"github.com/cursor/FMgo/utils"
+	"go.uber.org/zap"
+)
+
+// Monitor consulta periódicamente los eventos que receptores y cesionarios registran sobre
+// las facturas emitidas
+type Monitor struct {
+	service   *Service
+	empresas  []string
+	intervalo time.Duration
+	ventana   time.Duration
+}
+
+// NewMonitor crea el monitor para los RUT emisores indicados. ventana define la antigüedad
+// máxima de los documentos consultados; los eventos sólo pueden registrarse durante los 8 días
+// siguientes a la recepción en el SII.
+func NewMonitor(service *Service, empresas []string, intervalo, ventana time.Duration) *Monitor {
+	return &Monitor{
+		service:   service,
+		empresas:  empresas,
+		intervalo: intervalo,
+		ventana:   ventana,
+	}
+}
+
+// Iniciar bloquea consultando los eventos hasta que se cancele el contexto
+func (m *Monitor) Iniciar(ctx context.Context) {
+	ticker := time.NewTicker(m.intervalo)
+	defer ticker.Stop()
+
+	for {
+		m.Revisar(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Revisar sincroniza los eventos de todas las empresas
+func (m *Monitor) Revisar(ctx context.Context) {
+	desde := time.Now().Add(-m.ventana)
+	for _, rutEmisor := range m.empresas {
+		nuevos, err := m.service.SincronizarEventos(ctx, rutEmisor, desde)
+		if err != nil {
+			utils.LogError(err,
+				zap.String("proceso", "monitor_reclamos"),
+				zap.String("rut_emisor", rutEmisor),
+			)
+		}
+		for _, evento := range nuevos {
+			utils.LogInfo("evento de registro de reclamos",
+				zap.String("rut_emisor", rutEmisor),
+				zap.Int("tipo_dte", evento.TipoDTE),
+				zap.Int64("folio", evento.Folio),
+				zap.String("cod_evento", evento.CodEvento),
+				zap.String("rut_responsable", evento.RutResponsable),
+			)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package reclamos
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones utilizadas por el servicio de reclamos
+const (
+	ColeccionEventos             = "eventos_registro_reclamo"
+	ColeccionDocumentosEmitidos  = "documentos"
+	ColeccionDocumentosRecibidos = "documentos_recibidos"
+)
+
+// TokenProvider entrega un token de autenticación SII vigente para una empresa
+type TokenProvider interface {
+	ObtenerToken(ctx context.Context, rutEmpresa string) (string, error)
+}
+
+// ClienteRegistro expone los métodos del servicio de registro de reclamos usados por el servicio
+type ClienteRegistro interface {
+	IngresarAceptacionReclamoDoc(ctx context.Context, token, rutEmpresa string, doc Documento, accion models.AccionRegistro) (*models.RespuestaRegistroReclamo, error)
+	ListarEventosHistDoc(ctx context.Context, token, rutEmpresa string, doc Documento) (*models.RespuestaRegistroReclamo, []models.EventoDTESII, error)
+}
+
+// tiposRegistro son los tipos de documento que opera el registro de aceptación o reclamo
+var tiposRegistro = map[models.TipoDTE]bool{
+	models.TipoFactura:       true,
+	models.TipoFacturaExenta: true,
+	43:                       true, // Liquidación factura electrónica
+}
+
+// Service registra aceptaciones y reclamos de documentos recibidos y sincroniza los eventos
+// que terceros registran sobre los documentos emitidos
+type Service struct {
+	db      *mongo.Database
+	cliente ClienteRegistro
+	tokens  TokenProvider
+}
+
+// NewService crea una nueva instancia del servicio de reclamos
+func NewService(db *mongo.Database, cliente ClienteRegistro, tokens TokenProvider) *Service {
+	return &Service{
+		db:      db,
+		cliente: cliente,
+		tokens:  tokens,
+	}
+}
+
+// Aceptar acepta el contenido de un documento recibido (ACD)
+func (s *Service) Aceptar(ctx context.Context, documentoID string) (*models.RespuestaRegistroReclamo, error) {
+	return s.RegistrarAccion(ctx, documentoID, models.AccionAceptaContenido)
+}
+
+// Reclamar reclama un documento recibido por su contenido (RCD) o por falta parcial (RFP) o
+// total (RFT) de mercaderías
+func (s *Service) Reclamar(ctx context.Context, documentoID string, accion models.AccionRegistro) (*models.RespuestaRegistroReclamo, error) {
+	if !accion.EsReclamo() {
+		return nil, fmt.Errorf("la acción %s no es un reclamo", accion)
+	}
+	return s.RegistrarAccion(ctx, documentoID, accion)
+}
+
+// AcusarRecibo otorga el recibo de mercaderías o servicios de un documento recibido (ERM)
+func (s *Service) AcusarRecibo(ctx context.Context, documentoID string) (*models.RespuestaRegistroReclamo, error) {
+	return s.RegistrarAccion(ctx, documentoID, models.AccionReciboMercaderias)
+}
+
+// RegistrarAccion registra en el SII una acción sobre un documento recibido y la almacena
+// como evento propio
+func (s *Service) RegistrarAccion(ctx context.Context, documentoID string, accion models.AccionRegistro) (*models.RespuestaRegistroReclamo, error) {
+	if !accion.EsValida() {
+		return nil, fmt.Errorf("acción inválida: %s", accion)
+	}
+
+	var documento models.DocumentoTributario
+	if err := s.db.Collection(ColeccionDocumentosRecibidos).FindOne(ctx, bson.M{"_id": documentoID}).Decode(&documento); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("documento recibido no encontrado: %s", documentoID)
+		}
+		return nil, fmt.Errorf("error al obtener documento recibido: %v", err)
+	}
+	if !tiposRegistro[documento.TipoDocumento] {
+		return nil, fmt.Errorf("el tipo de documento %d no opera en el registro de reclamos", documento.TipoDocumento)
+	}
+
+	token, err := s.tokens.ObtenerToken(ctx, documento.RUTReceptor)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener token: %v", err)
+	}
+
+	doc := Documento{RutEmisor: documento.RUTEmisor, TipoDoc: int(documento.TipoDocumento), Folio: int64(documento.Folio)}
+	respuesta, err := s.cliente.IngresarAceptacionReclamoDoc(ctx, token, documento.RUTReceptor, doc, accion)
+	if err != nil {
+		return nil, err
+	}
+	if respuesta.Codigo != models.CodigoRegistroOK {
+		return respuesta, fmt.Errorf("el SII no registró la acción %s (código %d): %s", accion, respuesta.Codigo, respuesta.Descripcion)
+	}
+
+	evento := models.EventoDTESII{
+		CodEvento:      string(accion),
+		DescEvento:     respuesta.Descripcion,
+		RutResponsable: documento.RUTReceptor,
+		FechaEvento:    time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if _, err := s.guardarEvento(ctx, doc, documentoID, evento, models.OrigenEventoPropio); err != nil {
+		return respuesta, err
+	}
+
+	return respuesta, nil
+}
+
+// SincronizarEventos consulta los eventos registrados sobre los documentos emitidos por la
+// empresa desde la fecha indicada y retorna los que no se conocían
+func (s *Service) SincronizarEventos(ctx context.Context, rutEmisor string, desde time.Time) ([]models.EventoRegistroReclamo, error) {
+	tipos := make([]models.TipoDTE, 0, len(tiposRegistro))
+	for tipo := range tiposRegistro {
+		tipos = append(tipos, tipo)
+	}
+
+	cursor, err := s.db.Collection(ColeccionDocumentosEmitidos).Find(ctx, bson.M{
+		"rut_emisor":     rutEmisor,
+		"tipo_documento": bson.M{"$in": tipos},
+		"fecha_emision":  bson.M{"$gte": desde},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener documentos emitidos: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var documentos []models.DocumentoTributario
+	if err := cursor.All(ctx, &documentos); err != nil {
+		return nil, fmt.Errorf("error al decodificar documentos emitidos: %v", err)
+	}
+	if len(documentos) == 0 {
+		return nil, nil
+	}
+
+	token, err := s.tokens.ObtenerToken(ctx, rutEmisor)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener token: %v", err)
+	}
+
+	var nuevos []models.EventoRegistroReclamo
+	for _, documento := range documentos {
+		doc := Documento{RutEmisor: rutEmisor, TipoDoc: int(documento.TipoDocumento), Folio: int64(documento.Folio)}
+		respuesta, eventos, err := s.cliente.ListarEventosHistDoc(ctx, token, rutEmisor, doc)
+		if err != nil {
+			return nuevos, err
+		}
+		if respuesta.Codigo != models.CodigoListadoEventos {
+			continue
+		}
+
+		for _, evento := range eventos {
+			guardado, err := s.guardarEvento(ctx, doc, documento.ID, evento, models.OrigenEventoSII)
+			if err != nil {
+				return nuevos, err
+			}
+			if guardado != nil {
+				nuevos = append(nuevos, *guardado)
+			}
+		}
+	}
+
+	return nuevos, nil
+}
+
+// ObtenerEventos lista los eventos conocidos de un documento
+func (s *Service) ObtenerEventos(ctx context.Context, rutEmisor string, tipoDTE int, folio int64) ([]models.EventoRegistroReclamo, error) {
+	cursor, err := s.db.Collection(ColeccionEventos).Find(ctx,
+		bson.M{"rut_emisor": rutEmisor, "tipo_dte": tipoDTE, "folio": folio},
+		options.Find().SetSort(bson.M{"fecha_evento": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener eventos: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var eventos []models.EventoRegistroReclamo
+	if err := cursor.All(ctx, &eventos); err != nil {
+		return nil, fmt.Errorf("error al decodificar eventos: %v", err)
+	}
+	return eventos, nil
+}
+
+// guardarEvento almacena el evento si no existía. Retorna nil cuando el evento ya estaba registrado.
+func (s *Service) guardarEvento(ctx context.Context, doc Documento, documentoID string, evento models.EventoDTESII, origen string) (*models.EventoRegistroReclamo, error) {
+	registro := models.EventoRegistroReclamo{
+		ID:             models.GenerateID(),
+		RutEmisor:      doc.RutEmisor,
+		TipoDTE:        doc.TipoDoc,
+		Folio:          doc.Folio,
+		DocumentoID:    documentoID,
+		CodEvento:      evento.CodEvento,
+		DescEvento:     evento.DescEvento,
+		RutResponsable: evento.RutResponsable,
+		FechaEvento:    evento.FechaEvento,
+		Origen:         origen,
+		CreatedAt:      time.Now(),
+	}
+	if evento.DvResponsable != "" {
+		registro.RutResponsable = evento.RutResponsable + "-" + evento.DvResponsable
+	}
+
+	resultado, err := s.db.Collection(ColeccionEventos).UpdateOne(ctx,
+		bson.M{
+			"rut_emisor":   registro.RutEmisor,
+			"tipo_dte":     registro.TipoDTE,
+			"folio":        registro.Folio,
+			"cod_evento":   registro.CodEvento,
+			"fecha_evento": registro.FechaEvento,
+		},
+		bson.M{"$setOnInsert": registro},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error al guardar evento: %v", err)
+	}
+	if resultado.UpsertedCount == 0 {
+		return nil, nil
+	}
+	return &registro, nil
+}
@@ -0,0 +1,60 @@
+package seguimiento
+
+import (
+	"sync"
+	"time"
+)
+
+// limitador reparte las consultas al SII de cada empresa con una cubeta de fichas que se
+// recarga a razón de porMinuto fichas por minuto
+type limitador struct {
+	mu         sync.Mutex
+	capacidad  float64
+	porSegundo float64
+	cubetas    map[string]*cubeta
+}
+
+type cubeta struct {
+	fichas      float64
+	actualizada time.Time
+}
+
+// newLimitador crea el limitador; con porMinuto cero o negativo no limita
+func newLimitador(porMinuto int) *limitador {
+	if porMinuto <= 0 {
+		return nil
+	}
+	return &limitador{
+		capacidad:  float64(porMinuto),
+		porSegundo: float64(porMinuto) / 60,
+		cubetas:    make(map[string]*cubeta),
+	}
+}
+
+// reservar consume una ficha de la empresa. Si no quedan, retorna la espera hasta la próxima.
+func (l *limitador) reservar(rutEmpresa string, ahora time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.cubetas[rutEmpresa]
+	if !ok {
+		c = &cubeta{fichas: l.capacidad, actualizada: ahora}
+		l.cubetas[rutEmpresa] = c
+	}
+	if ahora.After(c.actualizada) {
+		c.fichas += ahora.Sub(c.actualizada).Seconds() * l.porSegundo
+		if c.fichas > l.capacidad {
+			c.fichas = l.capacidad
+		}
+		c.actualizada = ahora
+	}
+
+	if c.fichas >= 1 {
+		c.fichas--
+		return 0
+	}
+	return time.Duration((1 - c.fichas) / l.porSegundo * float64(time.Second))
+}
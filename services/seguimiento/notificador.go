@@ -0,0 +1,173 @@
+package seguimiento
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+)
+
+// TipoEvento identifica los eventos emitidos por el poller
+type TipoEvento string
+
+// Eventos del seguimiento de TrackID
+const (
+	EventoEstadoDocumento       TipoEvento = "ESTADO_DOCUMENTO"       // Un documento cambió de estado
+	EventoSeguimientoAbandonado TipoEvento = "SEGUIMIENTO_ABANDONADO" // Sin estado final dentro del horizonte
+)
+
+// Evento describe un cambio en el seguimiento de un TrackID
+type Evento struct {
+	Tipo           TipoEvento                   `json:"tipo"`
+	RutEmpresa     string                       `json:"rut_empresa"`
+	TrackID        string                       `json:"track_id"`
+	Documento      *models.DocumentoSeguimiento `json:"documento,omitempty"`
+	EstadoAnterior models.EstadoDTE             `json:"estado_anterior,omitempty"`
+	Estado         models.EstadoDTE             `json:"estado,omitempty"`
+	Detalle        *models.DetalleEstadoSII     `json:"detalle,omitempty"`
+	Motivo         string                       `json:"motivo,omitempty"`
+	Fecha          time.Time                    `json:"fecha"`
+}
+
+// Notificador recibe los eventos del poller
+type Notificador interface {
+	Notificar(ctx context.Context, evento Evento) error
+}
+
+// NotificadorFunc adapta una función al tipo Notificador
+type NotificadorFunc func(ctx context.Context, evento Evento) error
+
+// Notificar implementa Notificador
+func (f NotificadorFunc) Notificar(ctx context.Context, evento Evento) error {
+	return f(ctx, evento)
+}
+
+// Notificadores envía cada evento a todos los notificadores, aunque alguno falle
+type Notificadores []Notificador
+
+// Notificar implementa Notificador
+func (n Notificadores) Notificar(ctx context.Context, evento Evento) error {
+	var errs []error
+	for _, notificador := range n {
+		if err := notificador.Notificar(ctx, evento); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Webhook es un destino HTTP de los eventos. Si Secreto no está vacío, cada evento se firma con
+// HMAC-SHA256 en la cabecera X-FMgo-Firma.
+type Webhook struct {
+	URL     string
+	Secreto string
+}
+
+// NotificadorWebhook publica los eventos en los webhooks de cada empresa
+type NotificadorWebhook struct {
+	client   *http.Client
+	webhooks map[string][]Webhook
+}
+
+// NewNotificadorWebhook crea el notificador con los webhooks por RUT de empresa; los
+// registrados con la clave "*" reciben los eventos de todas las empresas
+func NewNotificadorWebhook(webhooks map[string][]Webhook, timeout time.Duration) *NotificadorWebhook {
+	normalizados := make(map[string][]Webhook, len(webhooks))
+	for rut, destinos := range webhooks {
+		clave := utils.NormalizarRUT(rut)
+		normalizados[clave] = append(normalizados[clave], destinos...)
+	}
+	return &NotificadorWebhook{
+		client:   &http.Client{Timeout: timeout},
+		webhooks: normalizados,
+	}
+}
+
+// Notificar envía el evento como JSON por POST; una respuesta distinta de 2xx es un error
+func (n *NotificadorWebhook) Notificar(ctx context.Context, evento Evento) error {
+	destinos := append(append([]Webhook(nil), n.webhooks[utils.NormalizarRUT(evento.RutEmpresa)]...), n.webhooks["*"]...)
+	if len(destinos) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(evento)
+	if err != nil {
+		return fmt.Errorf("error al serializar evento: %v", err)
+	}
+
+	var errs []error
+	for _, webhook := range destinos {
+		if err := n.enviar(ctx, webhook, string(evento.Tipo), body); err != nil {
+			errs = append(errs, fmt.Errorf("error al notificar webhook %s: %v", webhook.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *NotificadorWebhook) enviar(ctx context.Context, webhook Webhook, tipo string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-FMgo-Evento", tipo)
+	if webhook.Secreto != "" {
+		req.Header.Set("X-FMgo-Firma", "sha256="+FirmarEvento(webhook.Secreto, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("respuesta HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FirmarEvento retorna la firma HMAC-SHA256 en hexadecimal del cuerpo de un evento, para que
+// el receptor del webhook verifique su origen
+func FirmarEvento(secreto string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secreto))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EnviadorAlertas envía alertas operacionales (services.AlertService)
+type EnviadorAlertas interface {
+	SendAlert(subject string, payload interface{}) error
+}
+
+// NotificadorAlertas envía una alerta cuando un seguimiento se abandona o un documento queda
+// rechazado; ignora los demás eventos
+type NotificadorAlertas struct {
+	alertas EnviadorAlertas
+}
+
+// NewNotificadorAlertas crea el notificador sobre el servicio de alertas indicado
+func NewNotificadorAlertas(alertas EnviadorAlertas) *NotificadorAlertas {
+	return &NotificadorAlertas{alertas: alertas}
+}
+
+// Notificar implementa Notificador
+func (n *NotificadorAlertas) Notificar(ctx context.Context, evento Evento) error {
+	switch {
+	case evento.Tipo == EventoSeguimientoAbandonado:
+		return n.alertas.SendAlert(fmt.Sprintf("TrackID %s de %s sin estado final del SII", evento.TrackID, evento.RutEmpresa), evento)
+	case evento.Tipo == EventoEstadoDocumento && evento.Estado == models.EstadoDTERechazado:
+		return n.alertas.SendAlert(fmt.Sprintf("DTE %d folio %d de %s rechazado por el SII",
+			evento.Documento.TipoDTE, evento.Documento.Folio, evento.RutEmpresa), evento)
+	}
+	return nil
+}
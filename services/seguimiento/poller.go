@@ -0,0 +1,413 @@
+// Package seguimiento consulta en segundo plano el estado de los TrackID enviados al SII y
+// lleva cada documento a ACEPTADO, ACEPTADO_CON_REPAROS o RECHAZADO con el detalle del SII.
+// Los seguimientos se persisten, se consultan con espera creciente y con un límite de consultas
+// por empresa, y se abandonan con una alerta si no hay estado final dentro del horizonte.
+package seguimiento
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.uber.org/zap"
+)
+
+// errLimiteConsultas indica que la empresa agotó su cuota de consultas al SII
+var errLimiteConsultas = errors.New("límite de consultas al SII alcanzado")
+
+// Consultor consulta el estado de envíos y documentos en el SII (estado.Cliente)
+type Consultor interface {
+	ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error)
+	ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error)
+}
+
+// Repositorio persiste los seguimientos y el estado de los documentos
+type Repositorio interface {
+	// RegistrarPendientes crea el seguimiento de los TrackID de documentos enviados que aún no
+	// lo tienen y retorna cuántos creó
+	RegistrarPendientes(ctx context.Context, ahora time.Time) (int, error)
+	// Tomar reserva hasta limite seguimientos pendientes cuya próxima consulta ya venció,
+	// postergándolos por reserva para que otra instancia no los consulte a la vez
+	Tomar(ctx context.Context, ahora time.Time, reserva time.Duration, limite int) ([]*models.SeguimientoEnvio, error)
+	// Guardar actualiza el seguimiento
+	Guardar(ctx context.Context, seguimiento *models.SeguimientoEnvio) error
+	// ActualizarDocumento fija el estado del documento y adjunta el detalle del SII
+	ActualizarDocumento(ctx context.Context, documentoID string, estado models.EstadoDTE, detalle *models.DetalleEstadoSII) error
+}
+
+// Config define la frecuencia y los límites de las consultas
+type Config struct {
+	Intervalo          time.Duration // Revisión de los seguimientos vencidos
+	Descubrimiento     time.Duration // Búsqueda de nuevos TrackID enviados
+	EsperaInicial      time.Duration // Espera después de la primera consulta sin estado final
+	EsperaMaxima       time.Duration // Tope de la espera entre consultas
+	Factor             float64       // Crecimiento de la espera en cada consulta sin cambios
+	Horizonte          time.Duration // Tiempo desde el envío tras el cual se abandona el seguimiento
+	ConsultasPorMinuto int           // Consultas al SII por empresa; cero no limita
+	Lote               int           // Seguimientos tomados en cada revisión
+	Reserva            time.Duration // Tiempo que un seguimiento tomado queda reservado
+}
+
+// ConfigPorDefecto retorna la configuración recomendada: el SII suele procesar un envío en
+// pocos minutos, pero puede demorar horas en períodos de alta carga
+func ConfigPorDefecto() Config {
+	return Config{
+		Intervalo:          15 * time.Second,
+		Descubrimiento:     time.Minute,
+		EsperaInicial:      30 * time.Second,
+		EsperaMaxima:       30 * time.Minute,
+		Factor:             2,
+		Horizonte:          72 * time.Hour,
+		ConsultasPorMinuto: 20,
+		Lote:               100,
+		Reserva:            5 * time.Minute,
+	}
+}
+
+// Poller consulta los TrackID pendientes y actualiza sus documentos
+type Poller struct {
+	repo        Repositorio
+	consultor   Consultor
+	consultores map[models.AmbienteSII]Consultor
+	notificador Notificador
+	config      Config
+	limitador   *limitador
+	reloj       func() time.Time
+}
+
+// NewPoller crea el poller. notificador puede ser nil.
+func NewPoller(repo Repositorio, consultor Consultor, notificador Notificador, config Config) *Poller {
+	return &Poller{
+		repo:        repo,
+		consultor:   consultor,
+		notificador: notificador,
+		config:      config,
+		limitador:   newLimitador(config.ConsultasPorMinuto),
+		reloj:       time.Now,
+	}
+}
+
+// SetConsultorAmbiente fija el consultor de los envíos hechos en un ambiente, de modo que un
+// TrackID se siga consultando donde se envió aunque la empresa cambie de ambiente. Los
+// seguimientos sin ambiente usan el consultor de NewPoller.
+func (p *Poller) SetConsultorAmbiente(ambiente models.AmbienteSII, consultor Consultor) {
+	if p.consultores == nil {
+		p.consultores = make(map[models.AmbienteSII]Consultor)
+	}
+	p.consultores[ambiente] = consultor
+}
+
+// Iniciar bloquea consultando los seguimientos hasta que se cancele el contexto
+func (p *Poller) Iniciar(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Intervalo)
+	defer ticker.Stop()
+
+	var ultimoDescubrimiento time.Time
+	for {
+		if ahora := p.reloj(); ahora.Sub(ultimoDescubrimiento) >= p.config.Descubrimiento {
+			p.Descubrir(ctx)
+			ultimoDescubrimiento = ahora
+		}
+		p.Revisar(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Descubrir registra el seguimiento de los documentos enviados que aún no lo tienen
+func (p *Poller) Descubrir(ctx context.Context) {
+	nuevos, err := p.repo.RegistrarPendientes(ctx, p.reloj())
+	if err != nil {
+		utils.LogError(err, zap.String("proceso", "seguimiento_trackid"))
+		return
+	}
+	if nuevos > 0 {
+		utils.LogInfo("nuevos TrackID en seguimiento", zap.Int("cantidad", nuevos))
+	}
+}
+
+// Revisar consulta los seguimientos vencidos; las empresas se procesan en paralelo y los
+// TrackID de cada empresa en orden, respetando su límite de consultas
+func (p *Poller) Revisar(ctx context.Context) {
+	seguimientos, err := p.repo.Tomar(ctx, p.reloj(), p.config.Reserva, p.config.Lote)
+	if err != nil {
+		utils.LogError(err, zap.String("proceso", "seguimiento_trackid"))
+		return
+	}
+
+	porEmpresa := make(map[string][]*models.SeguimientoEnvio)
+	for _, s := range seguimientos {
+		porEmpresa[s.RutEmpresa] = append(porEmpresa[s.RutEmpresa], s)
+	}
+
+	var wg sync.WaitGroup
+	for _, lista := range porEmpresa {
+		wg.Add(1)
+		go func(lista []*models.SeguimientoEnvio) {
+			defer wg.Done()
+			for _, s := range lista {
+				if ctx.Err() != nil {
+					return
+				}
+				p.procesar(ctx, s)
+			}
+		}(lista)
+	}
+	wg.Wait()
+}
+
+// procesar consulta un TrackID y, si el envío tiene estado final, actualiza sus documentos
+func (p *Poller) procesar(ctx context.Context, s *models.SeguimientoEnvio) {
+	ahora := p.reloj()
+	if ahora.Sub(s.Enviado) > p.config.Horizonte {
+		p.abandonar(ctx, s, ahora)
+		return
+	}
+	if espera := p.limitador.reservar(s.RutEmpresa, ahora); espera > 0 {
+		s.ProximaConsulta = ahora.Add(espera)
+		p.guardar(ctx, s)
+		return
+	}
+
+	respuesta, err := p.consultorDe(s).ConsultarEnvio(ctx, s.RutEmpresa, s.TrackID)
+	s.UltimaConsulta = ahora
+	if respuesta == nil {
+		p.reintentar(ctx, s, ahora, err, false)
+		return
+	}
+
+	avanzo := s.UltimaRespuesta == nil || s.UltimaRespuesta.Estado != respuesta.Estado
+	s.UltimaRespuesta = respuesta
+	s.Resultado = respuesta.Resultado()
+	if err != nil {
+		p.reintentar(ctx, s, ahora, err, avanzo)
+		return
+	}
+
+	switch s.Resultado {
+	case models.ResultadoEnvioAceptado, models.ResultadoEnvioReparos,
+		models.ResultadoEnvioRechazoParcial, models.ResultadoEnvioRechazado:
+	default:
+		p.reintentar(ctx, s, ahora, nil, avanzo)
+		return
+	}
+
+	if err := p.resolver(ctx, s, respuesta, ahora); err != nil {
+		p.reintentar(ctx, s, ahora, err, false)
+		return
+	}
+	s.Estado = models.EstadoSeguimientoFinalizado
+	s.Error = ""
+	p.guardar(ctx, s)
+}
+
+// resolver fija el estado de cada documento del envío. Los documentos ya resueltos en un
+// intento anterior se omiten.
+func (p *Poller) resolver(ctx context.Context, s *models.SeguimientoEnvio, respuesta *models.RespuestaEstadoEnvio, ahora time.Time) error {
+	for i := range s.Documentos {
+		doc := &s.Documentos[i]
+		if estadoFinal(doc.Estado) {
+			continue
+		}
+
+		estado, detalle, err := p.estadoDocumento(ctx, s, *doc, respuesta, ahora)
+		if err != nil {
+			return fmt.Errorf("error al resolver DTE %d folio %d: %v", doc.TipoDTE, doc.Folio, err)
+		}
+		if err := p.repo.ActualizarDocumento(ctx, doc.ID, estado, detalle); err != nil {
+			return fmt.Errorf("error al actualizar DTE %d folio %d: %v", doc.TipoDTE, doc.Folio, err)
+		}
+
+		anterior := doc.Estado
+		doc.Estado = estado
+		documento := *doc
+		p.notificar(ctx, Evento{
+			Tipo:           EventoEstadoDocumento,
+			RutEmpresa:     s.RutEmpresa,
+			TrackID:        s.TrackID,
+			Documento:      &documento,
+			EstadoAnterior: anterior,
+			Estado:         estado,
+			Detalle:        detalle,
+			Fecha:          ahora,
+		})
+	}
+	return nil
+}
+
+// estadoDocumento decide el estado de un documento a partir del resumen de su tipo. QueryEstUp
+// sólo informa cantidades, por lo que cuando un tipo tiene documentos aceptados y rechazados
+// se consulta el documento con QueryEstDte. Los reparos no se informan por documento: si el
+// tipo tiene reparos, sus documentos aceptados quedan con reparos.
+func (p *Poller) estadoDocumento(ctx context.Context, s *models.SeguimientoEnvio, doc models.DocumentoSeguimiento, respuesta *models.RespuestaEstadoEnvio, ahora time.Time) (models.EstadoDTE, *models.DetalleEstadoSII, error) {
+	resultado := respuesta.Resultado()
+	detalle := &models.DetalleEstadoSII{
+		TrackID:       s.TrackID,
+		EstadoEnvio:   respuesta.Estado,
+		GlosaEnvio:    respuesta.Glosa,
+		Resultado:     resultado,
+		NumAtencion:   respuesta.NumAtencion,
+		FechaConsulta: ahora,
+	}
+	if detalle.GlosaEnvio == "" {
+		detalle.GlosaEnvio = respuesta.Estado.Glosa()
+	}
+	resumen := resumenTipo(respuesta, doc.TipoDTE)
+	detalle.Resumen = resumen
+
+	switch {
+	case resultado == models.ResultadoEnvioRechazado:
+		return models.EstadoDTERechazado, detalle, nil
+	case resultado == models.ResultadoEnvioAceptado:
+		return models.EstadoDTEAceptado, detalle, nil
+	case resumen == nil && resultado == models.ResultadoEnvioReparos:
+		return models.EstadoDTEReparos, detalle, nil
+	case resumen != nil && resumen.Aceptados == 0:
+		return models.EstadoDTERechazado, detalle, nil
+	case resumen != nil && resumen.Rechazados == 0 && resumen.Reparos == 0:
+		return models.EstadoDTEAceptado, detalle, nil
+	case resumen != nil && resumen.Rechazados == 0:
+		return models.EstadoDTEReparos, detalle, nil
+	}
+
+	if espera := p.limitador.reservar(s.RutEmpresa, ahora); espera > 0 {
+		return "", nil, errLimiteConsultas
+	}
+	rutConsultante := s.RutEnvia
+	if rutConsultante == "" {
+		rutConsultante = s.RutEmpresa
+	}
+	estadoDTE, err := p.consultorDe(s).ConsultarDTE(ctx, models.ConsultaEstadoDTE{
+		RutConsultante: rutConsultante,
+		RutEmisor:      s.RutEmpresa,
+		RutReceptor:    doc.RutReceptor,
+		TipoDTE:        doc.TipoDTE,
+		Folio:          doc.Folio,
+		FechaEmision:   doc.FechaEmision,
+		MontoTotal:     doc.MontoTotal,
+		RutEmpresa:     s.RutEmpresa,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	detalle.EstadoDTE = estadoDTE.Estado
+	detalle.GlosaDTE = estadoDTE.GlosaEstado
+	switch {
+	case !estadoDTE.Estado.Recibido():
+		return models.EstadoDTERechazado, detalle, nil
+	case resumen != nil && resumen.Reparos > 0:
+		return models.EstadoDTEReparos, detalle, nil
+	default:
+		return models.EstadoDTEAceptado, detalle, nil
+	}
+}
+
+// consultorDe retorna el consultor del ambiente en que se hizo el envío
+func (p *Poller) consultorDe(s *models.SeguimientoEnvio) Consultor {
+	if consultor, ok := p.consultores[s.Ambiente]; ok && s.Ambiente != "" {
+		return consultor
+	}
+	return p.consultor
+}
+
+// reintentar programa la próxima consulta. La espera crece con cada consulta sin cambios y
+// vuelve a la inicial cuando el estado del envío avanza.
+func (p *Poller) reintentar(ctx context.Context, s *models.SeguimientoEnvio, ahora time.Time, causa error, avanzo bool) {
+	if avanzo {
+		s.Intentos = 1
+	} else {
+		s.Intentos++
+	}
+	s.ProximaConsulta = ahora.Add(p.espera(s.Intentos))
+	s.Error = ""
+	if causa != nil {
+		s.Error = causa.Error()
+		utils.LogWarning("consulta de TrackID sin estado final",
+			zap.String("rut_empresa", s.RutEmpresa),
+			zap.String("track_id", s.TrackID),
+			zap.Int("intentos", s.Intentos),
+			zap.Error(causa),
+		)
+	}
+	p.guardar(ctx, s)
+}
+
+// espera retorna la espera después del intento indicado
+func (p *Poller) espera(intentos int) time.Duration {
+	if intentos < 1 {
+		intentos = 1
+	}
+	espera := float64(p.config.EsperaInicial) * math.Pow(p.config.Factor, float64(intentos-1))
+	if maxima := float64(p.config.EsperaMaxima); maxima > 0 && espera > maxima {
+		espera = maxima
+	}
+	return time.Duration(espera)
+}
+
+// abandonar deja de consultar el TrackID y emite una alerta
+func (p *Poller) abandonar(ctx context.Context, s *models.SeguimientoEnvio, ahora time.Time) {
+	s.Estado = models.EstadoSeguimientoAbandonado
+	s.Error = fmt.Sprintf("sin estado final del SII después de %s", p.config.Horizonte)
+	p.guardar(ctx, s)
+
+	utils.LogWarning("seguimiento de TrackID abandonado",
+		zap.String("rut_empresa", s.RutEmpresa),
+		zap.String("track_id", s.TrackID),
+		zap.Int("intentos", s.Intentos),
+	)
+	p.notificar(ctx, Evento{
+		Tipo:       EventoSeguimientoAbandonado,
+		RutEmpresa: s.RutEmpresa,
+		TrackID:    s.TrackID,
+		Motivo:     s.Error,
+		Fecha:      ahora,
+	})
+}
+
+func (p *Poller) guardar(ctx context.Context, s *models.SeguimientoEnvio) {
+	s.UpdatedAt = p.reloj()
+	if err := p.repo.Guardar(ctx, s); err != nil {
+		utils.LogError(err,
+			zap.String("proceso", "seguimiento_trackid"),
+			zap.String("track_id", s.TrackID),
+		)
+	}
+}
+
+func (p *Poller) notificar(ctx context.Context, evento Evento) {
+	if p.notificador == nil {
+		return
+	}
+	if err := p.notificador.Notificar(ctx, evento); err != nil {
+		utils.LogError(err,
+			zap.String("proceso", "seguimiento_trackid"),
+			zap.String("evento", string(evento.Tipo)),
+			zap.String("track_id", evento.TrackID),
+		)
+	}
+}
+
+// resumenTipo retorna una copia del resumen del tipo de documento indicado, o nil
+func resumenTipo(respuesta *models.RespuestaEstadoEnvio, tipo models.TipoDTE) *models.ResumenEstadoEnvio {
+	for _, r := range respuesta.Documentos {
+		if r.TipoDTE == tipo {
+			resumen := r
+			return &resumen
+		}
+	}
+	return nil
+}
+
+// estadoFinal indica que el documento ya tiene el estado informado por el SII
+func estadoFinal(estado models.EstadoDTE) bool {
+	return estado == models.EstadoDTEAceptado || estado == models.EstadoDTEReparos || estado == models.EstadoDTERechazado
+}
@@ -0,0 +1,305 @@
+package seguimiento
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const rutEmpresa = "76212889-6"
+
+func TestMain(m *testing.M) {
+	utils.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// repositorioMemoria guarda los seguimientos y los estados de documentos en memoria
+type repositorioMemoria struct {
+	mu           sync.Mutex
+	seguimientos map[string]*models.SeguimientoEnvio
+	documentos   map[string]models.EstadoDTE
+	detalles     map[string]*models.DetalleEstadoSII
+}
+
+func newRepositorioMemoria(seguimientos ...*models.SeguimientoEnvio) *repositorioMemoria {
+	r := &repositorioMemoria{
+		seguimientos: make(map[string]*models.SeguimientoEnvio),
+		documentos:   make(map[string]models.EstadoDTE),
+		detalles:     make(map[string]*models.DetalleEstadoSII),
+	}
+	for _, s := range seguimientos {
+		r.seguimientos[s.ID] = s
+	}
+	return r
+}
+
+func (r *repositorioMemoria) RegistrarPendientes(ctx context.Context, ahora time.Time) (int, error) {
+	return 0, nil
+}
+
+func (r *repositorioMemoria) Tomar(ctx context.Context, ahora time.Time, reserva time.Duration, limite int) ([]*models.SeguimientoEnvio, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tomados []*models.SeguimientoEnvio
+	for _, s := range r.seguimientos {
+		if s.Estado == models.EstadoSeguimientoPendiente && !s.ProximaConsulta.After(ahora) && len(tomados) < limite {
+			s.ProximaConsulta = ahora.Add(reserva)
+			copia := *s
+			copia.Documentos = append([]models.DocumentoSeguimiento(nil), s.Documentos...)
+			tomados = append(tomados, &copia)
+		}
+	}
+	return tomados, nil
+}
+
+func (r *repositorioMemoria) Guardar(ctx context.Context, s *models.SeguimientoEnvio) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seguimientos[s.ID] = s
+	return nil
+}
+
+func (r *repositorioMemoria) ActualizarDocumento(ctx context.Context, id string, estado models.EstadoDTE, detalle *models.DetalleEstadoSII) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.documentos[id] = estado
+	r.detalles[id] = detalle
+	return nil
+}
+
+func (r *repositorioMemoria) seguimiento(id string) *models.SeguimientoEnvio {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seguimientos[id]
+}
+
+// consultorPrueba responde las consultas de envío en orden y las de documentos por folio
+type consultorPrueba struct {
+	mu         sync.Mutex
+	envios     []*models.RespuestaEstadoEnvio
+	documentos map[int64]models.EstadoConsultaDTE
+	consultas  int
+}
+
+func (c *consultorPrueba) ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	respuesta := c.envios[0]
+	if len(c.envios) > 1 {
+		c.envios = c.envios[1:]
+	}
+	c.consultas++
+	return respuesta, nil
+}
+
+func (c *consultorPrueba) ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	estado := c.documentos[consulta.Folio]
+	return &models.RespuestaEstadoDTE{Estado: estado, GlosaEstado: estado.Glosa(), ErrCode: estado.ErrCode()}, nil
+}
+
+// eventosPrueba registra los eventos notificados
+type eventosPrueba struct {
+	mu      sync.Mutex
+	eventos []Evento
+}
+
+func (e *eventosPrueba) Notificar(ctx context.Context, evento Evento) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.eventos = append(e.eventos, evento)
+	return nil
+}
+
+func nuevoSeguimiento(id, trackID string, enviado time.Time, folios ...int64) *models.SeguimientoEnvio {
+	s := &models.SeguimientoEnvio{
+		ID:              id,
+		TrackID:         trackID,
+		RutEmpresa:      rutEmpresa,
+		Estado:          models.EstadoSeguimientoPendiente,
+		Enviado:         enviado,
+		ProximaConsulta: enviado,
+	}
+	for _, folio := range folios {
+		s.Documentos = append(s.Documentos, models.DocumentoSeguimiento{
+			ID: fmt.Sprintf("%s-%d", id, folio), TipoDTE: 33, Folio: folio, RutReceptor: "77777777-7",
+			FechaEmision: enviado, MontoTotal: 11900, Estado: models.EstadoDTEEnviado,
+		})
+	}
+	return s
+}
+
+func pollerPrueba(repo Repositorio, consultor Consultor, notificador Notificador, ahora *time.Time) *Poller {
+	config := ConfigPorDefecto()
+	config.ConsultasPorMinuto = 0
+	p := NewPoller(repo, consultor, notificador, config)
+	p.reloj = func() time.Time { return *ahora }
+	return p
+}
+
+func TestPollerEsperaCrecienteHastaEstadoFinal(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	repo := newRepositorioMemoria(nuevoSeguimiento("s1", "5870", ahora, 10, 11))
+	consultor := &consultorPrueba{envios: []*models.RespuestaEstadoEnvio{
+		{TrackID: "5870", Estado: models.EstadoConsultaEnvioRecibido},
+		{TrackID: "5870", Estado: models.EstadoConsultaEnvioRecibido},
+		{TrackID: "5870", Estado: models.EstadoConsultaEnvioSchemaOK},
+		{TrackID: "5870", Estado: models.EstadoConsultaEnvioProcesado, Documentos: []models.ResumenEstadoEnvio{
+			{TipoDTE: 33, Informados: 2, Aceptados: 2},
+		}},
+	}}
+	eventos := &eventosPrueba{}
+	p := pollerPrueba(repo, consultor, eventos, &ahora)
+
+	p.Revisar(context.Background())
+	assert.Equal(t, ahora.Add(30*time.Second), repo.seguimiento("s1").ProximaConsulta)
+
+	// Antes de la próxima consulta no se consulta
+	ahora = ahora.Add(10 * time.Second)
+	p.Revisar(context.Background())
+	assert.Equal(t, 1, consultor.consultas)
+
+	// Sin cambios la espera se duplica
+	ahora = ahora.Add(20 * time.Second)
+	p.Revisar(context.Background())
+	assert.Equal(t, ahora.Add(60*time.Second), repo.seguimiento("s1").ProximaConsulta)
+
+	// Si el estado avanza vuelve a la espera inicial
+	ahora = ahora.Add(60 * time.Second)
+	p.Revisar(context.Background())
+	assert.Equal(t, ahora.Add(30*time.Second), repo.seguimiento("s1").ProximaConsulta)
+	assert.Equal(t, models.ResultadoEnvioEnProceso, repo.seguimiento("s1").Resultado)
+
+	ahora = ahora.Add(30 * time.Second)
+	p.Revisar(context.Background())
+	s := repo.seguimiento("s1")
+	assert.Equal(t, models.EstadoSeguimientoFinalizado, s.Estado)
+	assert.Equal(t, models.ResultadoEnvioAceptado, s.Resultado)
+	for _, doc := range s.Documentos {
+		assert.Equal(t, models.EstadoDTEAceptado, repo.documentos[doc.ID])
+		assert.Equal(t, models.EstadoConsultaEnvioProcesado, repo.detalles[doc.ID].EstadoEnvio)
+		assert.Equal(t, 2, repo.detalles[doc.ID].Resumen.Aceptados)
+	}
+	require.Len(t, eventos.eventos, 2)
+	assert.Equal(t, EventoEstadoDocumento, eventos.eventos[0].Tipo)
+	assert.Equal(t, models.EstadoDTEEnviado, eventos.eventos[0].EstadoAnterior)
+	assert.Equal(t, models.EstadoDTEAceptado, eventos.eventos[0].Estado)
+}
+
+func TestPollerRechazoParcialConsultaDocumentos(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	repo := newRepositorioMemoria(nuevoSeguimiento("s1", "5871", ahora, 10, 11, 12))
+	consultor := &consultorPrueba{
+		envios: []*models.RespuestaEstadoEnvio{{TrackID: "5871", Estado: models.EstadoConsultaEnvioProcesado, Documentos: []models.ResumenEstadoEnvio{
+			{TipoDTE: 33, Informados: 3, Aceptados: 2, Rechazados: 1, Reparos: 1},
+		}}},
+		documentos: map[int64]models.EstadoConsultaDTE{
+			10: models.EstadoConsultaDTEDatosOK,
+			11: models.EstadoConsultaDTENoRecibido,
+			12: models.EstadoConsultaDTEDatosOK,
+		},
+	}
+	p := pollerPrueba(repo, consultor, nil, &ahora)
+	p.Revisar(context.Background())
+
+	s := repo.seguimiento("s1")
+	assert.Equal(t, models.EstadoSeguimientoFinalizado, s.Estado)
+	assert.Equal(t, models.ResultadoEnvioRechazoParcial, s.Resultado)
+	assert.Equal(t, models.EstadoDTEReparos, repo.documentos[s.Documentos[0].ID])
+	assert.Equal(t, models.EstadoDTERechazado, repo.documentos[s.Documentos[1].ID])
+	assert.Equal(t, models.EstadoConsultaDTENoRecibido, repo.detalles[s.Documentos[1].ID].EstadoDTE)
+	assert.Equal(t, models.EstadoDTEReparos, repo.documentos[s.Documentos[2].ID])
+}
+
+func TestPollerRechazoEnvio(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	repo := newRepositorioMemoria(nuevoSeguimiento("s1", "5872", ahora, 10))
+	consultor := &consultorPrueba{envios: []*models.RespuestaEstadoEnvio{{TrackID: "5872", Estado: models.EstadoConsultaEnvioRechazoFirma}}}
+	p := pollerPrueba(repo, consultor, nil, &ahora)
+	p.Revisar(context.Background())
+
+	s := repo.seguimiento("s1")
+	assert.Equal(t, models.EstadoDTERechazado, repo.documentos[s.Documentos[0].ID])
+	assert.Equal(t, "Rechazado por Error en Firma", repo.detalles[s.Documentos[0].ID].GlosaEnvio)
+}
+
+func TestPollerAbandonaFueraDelHorizonte(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	repo := newRepositorioMemoria(nuevoSeguimiento("s1", "5873", ahora.Add(-73*time.Hour), 10))
+	consultor := &consultorPrueba{envios: []*models.RespuestaEstadoEnvio{{Estado: models.EstadoConsultaEnvioEnProceso}}}
+	eventos := &eventosPrueba{}
+	p := pollerPrueba(repo, consultor, eventos, &ahora)
+	p.Revisar(context.Background())
+
+	assert.Equal(t, models.EstadoSeguimientoAbandonado, repo.seguimiento("s1").Estado)
+	assert.Equal(t, 0, consultor.consultas)
+	require.Len(t, eventos.eventos, 1)
+	assert.Equal(t, EventoSeguimientoAbandonado, eventos.eventos[0].Tipo)
+	assert.Equal(t, "5873", eventos.eventos[0].TrackID)
+}
+
+func TestLimitadorPorEmpresa(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	l := newLimitador(2)
+	assert.Zero(t, l.reservar(rutEmpresa, ahora))
+	assert.Zero(t, l.reservar(rutEmpresa, ahora))
+	assert.Equal(t, 30*time.Second, l.reservar(rutEmpresa, ahora))
+	assert.Zero(t, l.reservar("11111111-1", ahora), "cada empresa tiene su cuota")
+	assert.Zero(t, l.reservar(rutEmpresa, ahora.Add(30*time.Second)))
+}
+
+func TestNotificadorWebhook(t *testing.T) {
+	var recibido Evento
+	var firma, tipo string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		firma = r.Header.Get("X-FMgo-Firma")
+		tipo = r.Header.Get("X-FMgo-Evento")
+		assert.Equal(t, "sha256="+FirmarEvento("secreto", body), firma)
+		require.NoError(t, json.Unmarshal(body, &recibido))
+	}))
+	defer server.Close()
+
+	notificador := NewNotificadorWebhook(map[string][]Webhook{"76.212.889-6": {{URL: server.URL, Secreto: "secreto"}}}, 5*time.Second)
+	err := notificador.Notificar(context.Background(), Evento{
+		Tipo: EventoEstadoDocumento, RutEmpresa: rutEmpresa, TrackID: "5870", Estado: models.EstadoDTEAceptado,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, string(EventoEstadoDocumento), tipo)
+	assert.Equal(t, models.EstadoDTEAceptado, recibido.Estado)
+
+	// Sin webhooks para la empresa no se envía nada
+	require.NoError(t, notificador.Notificar(context.Background(), Evento{RutEmpresa: "11111111-1"}))
+}
+
+func TestPollerConsultaEnElAmbienteDelEnvio(t *testing.T) {
+	ahora := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	certificacion := nuevoSeguimiento("s1", "5880", ahora, 10)
+	certificacion.Ambiente = models.AmbienteCertificacion
+	repo := newRepositorioMemoria(certificacion, nuevoSeguimiento("s2", "5881", ahora, 11))
+
+	porDefecto := &consultorPrueba{envios: []*models.RespuestaEstadoEnvio{{TrackID: "5881", Estado: models.EstadoConsultaEnvioProcesado}}}
+	consultorCertificacion := &consultorPrueba{envios: []*models.RespuestaEstadoEnvio{{TrackID: "5880", Estado: models.EstadoConsultaEnvioProcesado}}}
+	p := pollerPrueba(repo, porDefecto, nil, &ahora)
+	p.SetConsultorAmbiente(models.AmbienteCertificacion, consultorCertificacion)
+	p.Revisar(context.Background())
+
+	assert.Equal(t, 1, consultorCertificacion.consultas)
+	assert.Equal(t, 1, porDefecto.consultas)
+	assert.Equal(t, models.EstadoSeguimientoFinalizado, repo.seguimiento("s1").Estado)
+	assert.Equal(t, models.EstadoSeguimientoFinalizado, repo.seguimiento("s2").Estado)
+}
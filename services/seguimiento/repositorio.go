@@ -0,0 +1,170 @@
+package seguimiento
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Colecciones utilizadas por el seguimiento
+const (
+	ColeccionSeguimientos       = "seguimiento_envios"
+	ColeccionDocumentosEmitidos = "documentos"
+)
+
+// RepositorioMongo persiste los seguimientos en MongoDB y actualiza los documentos emitidos
+type RepositorioMongo struct {
+	db *mongo.Database
+}
+
+// NewRepositorioMongo crea el repositorio sobre la base de datos indicada
+func NewRepositorioMongo(db *mongo.Database) *RepositorioMongo {
+	return &RepositorioMongo{db: db}
+}
+
+// RegistrarPendientes agrupa por ambiente y TrackID los documentos en estado ENVIADO y crea el
+// seguimiento de los TrackID que aún no lo tienen; los TrackID de certificación y producción
+// son independientes aunque coincidan
+func (r *RepositorioMongo) RegistrarPendientes(ctx context.Context, ahora time.Time) (int, error) {
+	filtro := bson.M{
+		"estado":   models.EstadoDTEEnviado,
+		"track_id": bson.M{"$nin": bson.A{nil, ""}},
+	}
+	cursor, err := r.db.Collection(ColeccionDocumentosEmitidos).Find(ctx, filtro)
+	if err != nil {
+		return 0, fmt.Errorf("error al buscar documentos enviados: %v", err)
+	}
+	var documentos []models.DocumentoTributario
+	if err := cursor.All(ctx, &documentos); err != nil {
+		return 0, fmt.Errorf("error al leer documentos enviados: %v", err)
+	}
+
+	porTrackID := make(map[string]*models.SeguimientoEnvio)
+	var orden []string
+	for _, doc := range documentos {
+		clave := string(doc.Ambiente) + "/" + utils.NormalizarRUT(doc.RUTEmisor) + "/" + doc.TrackID
+		s, ok := porTrackID[clave]
+		if !ok {
+			s = &models.SeguimientoEnvio{
+				ID:              models.GenerateID(),
+				TrackID:         doc.TrackID,
+				RutEmpresa:      doc.RUTEmisor,
+				Ambiente:        doc.Ambiente,
+				Estado:          models.EstadoSeguimientoPendiente,
+				Enviado:         doc.UpdatedAt,
+				ProximaConsulta: ahora,
+				CreatedAt:       ahora,
+				UpdatedAt:       ahora,
+			}
+			porTrackID[clave] = s
+			orden = append(orden, clave)
+		}
+		if s.Enviado.IsZero() || (!doc.UpdatedAt.IsZero() && doc.UpdatedAt.Before(s.Enviado)) {
+			s.Enviado = doc.UpdatedAt
+		}
+		s.Documentos = append(s.Documentos, models.DocumentoSeguimiento{
+			ID:           doc.ID,
+			TipoDTE:      doc.TipoDocumento,
+			Folio:        int64(doc.Folio),
+			RutReceptor:  doc.RUTReceptor,
+			FechaEmision: doc.FechaEmision,
+			MontoTotal:   int64(math.Round(doc.MontoTotal)),
+			Estado:       doc.Estado,
+		})
+	}
+
+	nuevos := 0
+	for _, clave := range orden {
+		s := porTrackID[clave]
+		if s.Enviado.IsZero() {
+			s.Enviado = ahora
+		}
+		resultado, err := r.db.Collection(ColeccionSeguimientos).UpdateOne(ctx,
+			bson.M{"track_id": s.TrackID, "rut_empresa": s.RutEmpresa, "ambiente": filtroAmbiente(s.Ambiente)},
+			bson.M{"$setOnInsert": s},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return nuevos, fmt.Errorf("error al registrar seguimiento de %s: %v", s.TrackID, err)
+		}
+		if resultado.UpsertedCount > 0 {
+			nuevos++
+		}
+	}
+	return nuevos, nil
+}
+
+// Tomar reserva los seguimientos vencidos uno a uno con FindOneAndUpdate, de modo que dos
+// instancias nunca tomen el mismo
+func (r *RepositorioMongo) Tomar(ctx context.Context, ahora time.Time, reserva time.Duration, limite int) ([]*models.SeguimientoEnvio, error) {
+	coleccion := r.db.Collection(ColeccionSeguimientos)
+	opciones := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "proxima_consulta", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var tomados []*models.SeguimientoEnvio
+	for len(tomados) < limite {
+		var s models.SeguimientoEnvio
+		err := coleccion.FindOneAndUpdate(ctx,
+			bson.M{"estado": models.EstadoSeguimientoPendiente, "proxima_consulta": bson.M{"$lte": ahora}},
+			bson.M{"$set": bson.M{"proxima_consulta": ahora.Add(reserva)}},
+			opciones,
+		).Decode(&s)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		if err != nil {
+			return tomados, fmt.Errorf("error al tomar seguimientos: %v", err)
+		}
+		tomados = append(tomados, &s)
+	}
+	return tomados, nil
+}
+
+// Guardar reemplaza el seguimiento
+func (r *RepositorioMongo) Guardar(ctx context.Context, seguimiento *models.SeguimientoEnvio) error {
+	if _, err := r.db.Collection(ColeccionSeguimientos).ReplaceOne(ctx, bson.M{"_id": seguimiento.ID}, seguimiento); err != nil {
+		return fmt.Errorf("error al guardar seguimiento: %v", err)
+	}
+	return nil
+}
+
+// ActualizarDocumento fija el estado del documento, adjunta el detalle del SII y registra la
+// fecha de aceptación o rechazo
+func (r *RepositorioMongo) ActualizarDocumento(ctx context.Context, documentoID string, estado models.EstadoDTE, detalle *models.DetalleEstadoSII) error {
+	ahora := time.Now()
+	actualizacion := bson.M{
+		"estado":      estado,
+		"detalle_sii": detalle,
+		"updated_at":  ahora,
+	}
+	if estado == models.EstadoDTERechazado {
+		actualizacion["timestamps.fecha_rechazo"] = ahora.Format(time.RFC3339)
+	} else {
+		actualizacion["timestamps.fecha_aceptacion"] = ahora.Format(time.RFC3339)
+	}
+
+	resultado, err := r.db.Collection(ColeccionDocumentosEmitidos).UpdateOne(ctx, bson.M{"_id": documentoID}, bson.M{"$set": actualizacion})
+	if err != nil {
+		return fmt.Errorf("error al actualizar documento: %v", err)
+	}
+	if resultado.MatchedCount == 0 {
+		return fmt.Errorf("no existe documento %s", documentoID)
+	}
+	return nil
+}
+
+// filtroAmbiente filtra por ambiente; los seguimientos sin ambiente no tienen el campo
+func filtroAmbiente(ambiente models.AmbienteSII) interface{} {
+	if ambiente == "" {
+		return bson.M{"$exists": false}
+	}
+	return ambiente
+}
@@ -17,6 +17,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 // SeguridadService maneja la seguridad del sistema
@@ -127,6 +128,37 @@ func (s *SeguridadService) ValidarFirmaDigital(
 	return true, nil
 }
 
+// VerificarFirmasXML verifica cada firma XMLDSig de un documento (DTE, sobre de envío, CAF)
+// y retorna un resultado por nodo Signature
+func (s *SeguridadService) VerificarFirmasXML(documento []byte) ([]models.VerificacionFirmaXML, error) {
+	verificaciones, err := xmldsig.VerificarFirmas(documento)
+	if err != nil {
+		return nil, err
+	}
+
+	resultados := make([]models.VerificacionFirmaXML, 0, len(verificaciones))
+	for _, v := range verificaciones {
+		resultado := models.VerificacionFirmaXML{
+			Firma:  v.Firma,
+			Ruta:   v.Ruta,
+			Valida: v.Valida(),
+		}
+		if v.Error != nil {
+			resultado.Error = v.Error.Error()
+		}
+		if v.Resultado != nil {
+			resultado.Referencias = v.Resultado.Referencias
+			if cert := v.Resultado.Certificado; cert != nil {
+				resultado.Firmante = cert.Subject.CommonName
+				resultado.NumeroSerie = cert.SerialNumber.String()
+				resultado.VigenteHasta = cert.NotAfter
+			}
+		}
+		resultados = append(resultados, resultado)
+	}
+	return resultados, nil
+}
+
 // EncriptarDatos encripta datos sensibles
 func (s *SeguridadService) EncriptarDatos(
 	ctx context.Context,
@@ -21,28 +21,47 @@ type SesionService struct {
 }
 
 // NewSesionService crea una nueva instancia del servicio de sesiones
-func NewSesionService(config *config.SupabaseConfig, db *mongo.Database) *SesionService {
+func NewSesionService(config *config.SupabaseConfig, db *mongo.Database, tokens TokenSesion) *SesionService {
 	return &SesionService{
 		config: config,
 		db:     db,
-		client: NewSesionSIIClient(config),
+		client: NewSesionSIIClient(config, tokens),
 	}
 }
 
 // IniciarSesion inicia una nueva sesión electrónica
 func (s *SesionService) IniciarSesion(ctx context.Context, empresa *models.Empresa) (*models.SesionElectronica, error) {
+	// El token lo administra el caché compartido; la sesión sólo refleja el vigente
+	resp, err := s.client.IniciarSesion(ctx, empresa)
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando sesión con SII: %v", err)
+	}
+
 	// Verificar si ya existe una sesión activa
 	sesion, err := s.ObtenerSesionActiva(ctx, empresa.ID)
 	if err == nil && sesion != nil && sesion.IsValid() {
+		if sesion.Token != resp.Token {
+			sesion.Token = resp.Token
+			sesion.FechaExpiracion = resp.FechaExpiracion
+			sesion.UpdatedAt = time.Now()
+			_, err = s.db.Collection("sesiones").UpdateOne(
+				ctx,
+				bson.M{"_id": sesion.ID},
+				bson.M{
+					"$set": bson.M{
+						"token":            sesion.Token,
+						"fecha_expiracion": sesion.FechaExpiracion,
+						"updated_at":       sesion.UpdatedAt,
+					},
+				},
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error actualizando sesión: %v", err)
+			}
+		}
 		return sesion, nil
 	}
 
-	// Iniciar nueva sesión con el SII
-	resp, err := s.client.IniciarSesion(ctx, empresa)
-	if err != nil {
-		return nil, fmt.Errorf("error iniciando sesión con SII: %v", err)
-	}
-
 	// Crear nueva sesión
 	sesion = &models.SesionElectronica{
 		EmpresaID:       empresa.ID,
@@ -2,37 +2,49 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/token"
 )
 
+// TokenSesion entrega el token SII vigente de una empresa junto con su expiración
+type TokenSesion interface {
+	Obtener(ctx context.Context, rutEmpresa string) (*token.Token, error)
+}
+
 // SesionSIIClient representa un cliente para interactuar con el SII para sesiones
 type SesionSIIClient struct {
 	config     *config.SupabaseConfig
 	httpClient *http.Client
+	tokens     TokenSesion
 }
 
 // NewSesionSIIClient crea una nueva instancia del cliente SII para sesiones
-func NewSesionSIIClient(config *config.SupabaseConfig) *SesionSIIClient {
+func NewSesionSIIClient(config *config.SupabaseConfig, tokens TokenSesion) *SesionSIIClient {
 	return &SesionSIIClient{
 		config: config,
 		httpClient: &http.Client{
 			Timeout: time.Second * 30,
 		},
+		tokens: tokens,
 	}
 }
 
-// IniciarSesion inicia una sesión con el SII
+// IniciarSesion inicia una sesión con el SII usando el token compartido de la empresa
 func (c *SesionSIIClient) IniciarSesion(ctx context.Context, empresa *models.Empresa) (*models.SesionResponse, error) {
-	// Implementación simulada para desarrollo
-	// En producción, aquí se incluiría la lógica real para iniciar sesión con el SII
+	tokenSII, err := c.tokens.Obtener(ctx, empresa.RUT)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener token: %v", err)
+	}
+
 	return &models.SesionResponse{
-		Token:           "token-simulado",
+		Token:           tokenSII.Valor,
 		Estado:          "ACTIVA",
-		FechaExpiracion: time.Now().Add(time.Hour * 12),
+		FechaExpiracion: tokenSII.Expira,
 	}, nil
 }
 
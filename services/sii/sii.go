@@ -4,9 +4,6 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
 
 	"github.com/cursor/FMgo/models"
 )
@@ -21,13 +18,16 @@ type HTTPClient interface {
 type SIIClient struct {
 	httpClient HTTPClient
 	baseURL    string
+	estado     ConsultorEstado
 }
 
-// NewSIIClient crea una nueva instancia del cliente SII
-func NewSIIClient(httpClient HTTPClient, baseURL string) *SIIClient {
+// NewSIIClient crea una nueva instancia del cliente SII. Las consultas de estado se delegan en
+// el consultor indicado.
+func NewSIIClient(httpClient HTTPClient, baseURL string, estado ConsultorEstado) *SIIClient {
 	return &SIIClient{
 		httpClient: httpClient,
 		baseURL:    baseURL,
+		estado:     estado,
 	}
 }
 
@@ -58,46 +58,26 @@ func (c *SIIClient) EnviarDTE(dte *models.DTEXMLModel) (*models.RespuestaSII, er
 	return &respuesta, nil
 }
 
-// ConsultarEstado consulta el estado de un DTE en el SII
-func (c *SIIClient) ConsultarEstado(trackID string) (*models.EstadoSII, error) {
+// ConsultarEstado consulta el estado de un envío de la empresa por su TrackID (QueryEstUp)
+func (c *SIIClient) ConsultarEstado(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error) {
 	if trackID == "" {
 		return nil, fmt.Errorf("trackID es requerido")
 	}
-
-	// Consultar estado al SII
-	resp, err := c.httpClient.Get(context.Background(), fmt.Sprintf("%s/api/dte/estado/%s", c.baseURL, trackID))
-	if err != nil {
-		return nil, fmt.Errorf("error al consultar estado al SII: %v", err)
-	}
-
-	// Procesar la respuesta
-	var estado models.EstadoSII
-	if err := xml.Unmarshal(resp, &estado); err != nil {
-		return nil, fmt.Errorf("error al procesar estado del SII: %v", err)
+	if c.estado == nil {
+		return nil, ErrSinConsultaEstado
 	}
-
-	return &estado, nil
+	return c.estado.ConsultarEnvio(ctx, rutEmpresa, trackID)
 }
 
-// ConsultarDTE consulta un DTE específico en el SII
-func (c *SIIClient) ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.EstadoSII, error) {
-	if tipoDTE == "" || folio == "" || rutEmisor == "" {
+// ConsultarDTE consulta el estado de un documento específico en el SII (QueryEstDte)
+func (c *SIIClient) ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	if consulta.TipoDTE == 0 || consulta.Folio == 0 || consulta.RutEmisor == "" {
 		return nil, fmt.Errorf("tipoDTE, folio y rutEmisor son requeridos")
 	}
-
-	// Consultar DTE al SII
-	resp, err := c.httpClient.Get(context.Background(), fmt.Sprintf("%s/api/dte/consulta/%s/%s/%s", c.baseURL, tipoDTE, folio, rutEmisor))
-	if err != nil {
-		return nil, fmt.Errorf("error al consultar DTE al SII: %v", err)
-	}
-
-	// Procesar la respuesta
-	var estado models.EstadoSII
-	if err := xml.Unmarshal(resp, &estado); err != nil {
-		return nil, fmt.Errorf("error al procesar estado del DTE: %v", err)
+	if c.estado == nil {
+		return nil, ErrSinConsultaEstado
 	}
-
-	return &estado, nil
+	return c.estado.ConsultarDTE(ctx, consulta)
 }
 
 // VerificarComunicacion verifica la comunicación con el SII
@@ -123,59 +103,20 @@ func (c *SIIClient) VerificarComunicacion() error {
 
 // Client representa un cliente para interactuar con el SII
 type Client struct {
-	baseURL string
-	client  *http.Client
+	estado ConsultorEstado
 }
 
-// NewClient crea una nueva instancia del cliente SII
-func NewClient(baseURL string) *Client {
-	return &Client{
-		baseURL: baseURL,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
+// NewClient crea una nueva instancia del cliente SII que consulta con el consultor indicado
+func NewClient(estado ConsultorEstado) *Client {
+	return &Client{estado: estado}
 }
 
-// ConsultarEstado consulta el estado de un DTE
-func (c *Client) ConsultarEstado(tipoDTE int, folio int, rutEmisor string) (*models.EstadoSII, error) {
-	// Crear request
-	url := fmt.Sprintf("%s/ConsultaEstado?tipoDTE=%d&folio=%d&rutEmisor=%s",
-		c.baseURL, tipoDTE, folio, rutEmisor)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error al crear request: %w", err)
-	}
-
-	// Configurar headers
-	req.Header.Set("Accept", "application/xml")
-
-	// Enviar request
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error al enviar request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Leer respuesta
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error al leer respuesta: %w", err)
-	}
-
-	// Verificar status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error en respuesta del SII: %s", string(body))
-	}
-
-	// Decodificar respuesta
-	var estado models.EstadoSII
-	err = xml.Unmarshal(body, &estado)
-	if err != nil {
-		return nil, fmt.Errorf("error al decodificar respuesta: %w", err)
+// ConsultarEstado consulta el estado de un DTE (QueryEstDte)
+func (c *Client) ConsultarEstado(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	if c.estado == nil {
+		return nil, ErrSinConsultaEstado
 	}
-
-	return &estado, nil
+	return c.estado.ConsultarDTE(ctx, consulta)
 }
 
 // EstadoDTE representa el estado de un DTE
@@ -2,8 +2,10 @@ package sii
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,28 +17,48 @@ import (
 
 // SIIService define la interfaz para el servicio SII
 type SIIService interface {
-	// ConsultarEstado consulta el estado de una solicitud al SII
-	ConsultarEstado(trackID string) (*models.EstadoSII, error)
+	// ConsultarEstado consulta el estado de un envío de la empresa por su TrackID (QueryEstUp)
+	ConsultarEstado(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error)
 	// EnviarDTE envía un DTE al SII
 	EnviarDTE(dte []byte) (*models.EstadoSII, error)
-	// ConsultarDTE consulta un DTE específico en el SII
-	ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.EstadoSII, error)
+	// ConsultarDTE consulta el estado de un documento específico en el SII (QueryEstDte)
+	ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error)
 	// VerificarComunicacion verifica la comunicación con el SII
 	VerificarComunicacion() error
 }
 
+// ConsultorEstado consulta los servicios de estado QueryEstUp y QueryEstDte del SII. Lo
+// implementan estado.Cliente y estado.ClienteAmbientes.
+type ConsultorEstado interface {
+	ConsultarEnvio(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error)
+	ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error)
+}
+
+// ErrSinConsultaEstado indica que el cliente se creó sin un ConsultorEstado
+var ErrSinConsultaEstado = errors.New("no hay cliente de consulta de estado configurado")
+
+// TokenProvider administra los tokens de autenticación SII compartidos entre clientes
+type TokenProvider interface {
+	ObtenerToken(ctx context.Context, rutEmpresa string) (string, error)
+	Invalidar(ctx context.Context, rutEmpresa string) error
+}
+
 // SIIServiceImpl implementa la interfaz sii.SIIService
 type SIIServiceImpl struct {
 	baseURL    string
 	token      string
+	tokens     TokenProvider
+	rutEmpresa string
 	ambiente   string
 	certFile   string
 	keyFile    string
 	httpClient *http.Client
+	estado     ConsultorEstado
 }
 
-// NewSIIServiceImpl crea una nueva instancia del servicio SII
-func NewSIIServiceImpl(baseURL, token, ambiente, certFile, keyFile string) (*SIIServiceImpl, error) {
+// NewSIIServiceImpl crea una nueva instancia del servicio SII. Las consultas de estado se
+// delegan en el consultor indicado.
+func NewSIIServiceImpl(baseURL, token, ambiente, certFile, keyFile string, estado ConsultorEstado) (*SIIServiceImpl, error) {
 	// Validar parámetros
 	if baseURL == "" {
 		return nil, fmt.Errorf("baseURL es requerido")
@@ -44,6 +66,38 @@ func NewSIIServiceImpl(baseURL, token, ambiente, certFile, keyFile string) (*SII
 	if token == "" {
 		return nil, fmt.Errorf("token es requerido")
 	}
+
+	service, err := crearSIIService(baseURL, ambiente, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	service.token = token
+	service.estado = estado
+	return service, nil
+}
+
+// NewSIIServiceConTokens crea una instancia del servicio SII que obtiene los tokens de la
+// empresa desde el administrador de tokens compartido
+func NewSIIServiceConTokens(baseURL string, tokens TokenProvider, rutEmpresa, ambiente, certFile, keyFile string, estado ConsultorEstado) (*SIIServiceImpl, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("baseURL es requerido")
+	}
+	if tokens == nil || rutEmpresa == "" {
+		return nil, fmt.Errorf("administrador de tokens y rutEmpresa son requeridos")
+	}
+
+	service, err := crearSIIService(baseURL, ambiente, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	service.tokens = tokens
+	service.rutEmpresa = rutEmpresa
+	service.estado = estado
+	return service, nil
+}
+
+// crearSIIService configura el cliente HTTP con el certificado de la empresa
+func crearSIIService(baseURL, ambiente, certFile, keyFile string) (*SIIServiceImpl, error) {
 	if certFile == "" {
 		return nil, fmt.Errorf("certFile es requerido")
 	}
@@ -70,7 +124,6 @@ func NewSIIServiceImpl(baseURL, token, ambiente, certFile, keyFile string) (*SII
 
 	return &SIIServiceImpl{
 		baseURL:    baseURL,
-		token:      token,
 		ambiente:   ambiente,
 		certFile:   certFile,
 		keyFile:    keyFile,
@@ -78,58 +131,33 @@ func NewSIIServiceImpl(baseURL, token, ambiente, certFile, keyFile string) (*SII
 	}, nil
 }
 
-// ConsultarEstado consulta el estado de un DTE
-func (s *SIIServiceImpl) ConsultarEstado(trackID string) (*models.EstadoSII, error) {
-	// Crear request
-	url := fmt.Sprintf("%s/ConsultaEstado?trackID=%s", s.baseURL, trackID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error al crear request: %w", err)
-	}
-
-	// Configurar headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
-
-	// Enviar request
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error al enviar request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Leer respuesta
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error al leer respuesta: %w", err)
-	}
-
-	// Verificar status code
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
-		return nil, fmt.Errorf("error en respuesta del SII: %s", string(body))
-	}
-
-	// Decodificar respuesta
-	var respuesta models.RespuestaSII
-	if err := json.Unmarshal(body, &respuesta); err != nil {
-		return nil, fmt.Errorf("error al decodificar respuesta: %w", err)
+// autenticar agrega el token vigente a la petición
+func (s *SIIServiceImpl) autenticar(req *http.Request) error {
+	token := s.token
+	if s.tokens != nil {
+		var err error
+		token, err = s.tokens.ObtenerToken(req.Context(), s.rutEmpresa)
+		if err != nil {
+			return fmt.Errorf("error al obtener token: %v", err)
+		}
 	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
 
-	// Convertir a EstadoSII
-	estado := &models.EstadoSII{
-		Estado:  respuesta.Estado,
-		Glosa:   respuesta.Glosa,
-		TrackID: respuesta.TrackID,
-		Fecha:   respuesta.FechaProceso,
+// verificarAutenticacion descarta el token cuando el SII lo rechaza
+func (s *SIIServiceImpl) verificarAutenticacion(resp *http.Response) {
+	if s.tokens != nil && resp.StatusCode == http.StatusUnauthorized {
+		s.tokens.Invalidar(resp.Request.Context(), s.rutEmpresa)
 	}
+}
 
-	// Si hay errores, agregarlos al estado
-	if len(respuesta.Errores) > 0 {
-		estado.Errores = make([]models.ErrorSII, len(respuesta.Errores))
-		copy(estado.Errores, respuesta.Errores)
+// ConsultarEstado consulta el estado de un envío con el cliente de QueryEstUp
+func (s *SIIServiceImpl) ConsultarEstado(ctx context.Context, rutEmpresa, trackID string) (*models.RespuestaEstadoEnvio, error) {
+	if s.estado == nil {
+		return nil, ErrSinConsultaEstado
 	}
-
-	return estado, nil
+	return s.estado.ConsultarEnvio(ctx, rutEmpresa, trackID)
 }
 
 // EnviarDTE envía un DTE al SII
@@ -141,7 +169,9 @@ func (s *SIIServiceImpl) EnviarDTE(dte []byte) (*models.EstadoSII, error) {
 		return nil, fmt.Errorf("error al crear request: %v", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	if err := s.autenticar(req); err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(req)
@@ -149,6 +179,7 @@ func (s *SIIServiceImpl) EnviarDTE(dte []byte) (*models.EstadoSII, error) {
 		return nil, fmt.Errorf("error al enviar DTE: %v", err)
 	}
 	defer resp.Body.Close()
+	s.verificarAutenticacion(resp)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -163,35 +194,12 @@ func (s *SIIServiceImpl) EnviarDTE(dte []byte) (*models.EstadoSII, error) {
 	return &respuesta, nil
 }
 
-// ConsultarDTE consulta un DTE específico en el SII
-func (s *SIIServiceImpl) ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.EstadoSII, error) {
-	url := fmt.Sprintf("%s/dte/consulta/%s/%s/%s", s.baseURL, tipoDTE, folio, rutEmisor)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error al crear request: %v", err)
+// ConsultarDTE consulta el estado de un documento con el cliente de QueryEstDte
+func (s *SIIServiceImpl) ConsultarDTE(ctx context.Context, consulta models.ConsultaEstadoDTE) (*models.RespuestaEstadoDTE, error) {
+	if s.estado == nil {
+		return nil, ErrSinConsultaEstado
 	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error al consultar DTE: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error al leer respuesta: %v", err)
-	}
-
-	var respuesta models.EstadoSII
-	if err := json.Unmarshal(body, &respuesta); err != nil {
-		return nil, fmt.Errorf("error al decodificar respuesta: %v", err)
-	}
-
-	return &respuesta, nil
+	return s.estado.ConsultarDTE(ctx, consulta)
 }
 
 // VerificarComunicacion verifica la comunicación con el SII
@@ -203,13 +211,16 @@ func (s *SIIServiceImpl) VerificarComunicacion() error {
 		return fmt.Errorf("error al crear request: %v", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	if err := s.autenticar(req); err != nil {
+		return err
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error al verificar comunicación: %v", err)
 	}
 	defer resp.Body.Close()
+	s.verificarAutenticacion(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("error en respuesta del SII: %d", resp.StatusCode)
@@ -231,7 +242,7 @@ func NewService(config *config.Config) *Service {
 }
 
 // EnviarDTE envía un DTE al SII
-func (s *Service) EnviarDTE(sobre *models.SobreDTE) (*models.RespuestaSII, error) {
+func (s *Service) EnviarDTE(sobre *models.SobreDTEModel) (*models.RespuestaSII, error) {
 	// Implementación mock
 	return &models.RespuestaSII{
 		Estado:  "OK",
@@ -244,9 +255,9 @@ func (s *Service) EnviarDTE(sobre *models.SobreDTE) (*models.RespuestaSII, error
 func (s *Service) ConsultarEstado(trackID string) (*models.EstadoSII, error) {
 	// Implementación mock
 	return &models.EstadoSII{
-		Estado:  "OK",
-		Glosa:   "Documento Aceptado",
-		TrackID: trackID,
-		Fecha:   time.Now(),
+		Estado:    "OK",
+		Glosa:     "Documento Aceptado",
+		TrackID:   trackID,
+		Timestamp: time.Now(),
 	}, nil
 }
@@ -154,7 +154,7 @@ func (s *TributarioSII) generarXMLImpuestos(doc interface{}) (string, error) {
 
 	// Formatear XML con los valores
 	if len(impuestosParaXML) > 0 {
-		return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA, impuestosParaXML[0].Porcentaje, impuestosParaXML[0].Monto), nil
+		return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA, impuestosParaXML[0].Tasa, impuestosParaXML[0].Monto), nil
 	}
 	return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA), nil
 }
@@ -240,7 +240,7 @@ func (s *TributarioSII) GenerateXML(doc interface{}) (string, error) {
 				</Totales>
 			</Encabezado>
 		</DTE>`
-		return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA, impuestosParaXML[0].TipoImpuesto, impuestosParaXML[0].Porcentaje, impuestosParaXML[0].Monto, montoTotal), nil
+		return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA, impuestosParaXML[0].Tipo, impuestosParaXML[0].Tasa, impuestosParaXML[0].Monto, montoTotal), nil
 	}
 
 	return fmt.Sprintf(xml, montoNeto, montoExento, montoIVA, montoTotal), nil
@@ -0,0 +1,181 @@
+package sii
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
+	"github.com/cursor/FMgo/services/token"
+	"github.com/cursor/FMgo/utils"
+)
+
+// userAgentUpload es el User-Agent que el SII exige en el servicio DTEUpload
+const userAgentUpload = "Mozilla/4.0 (compatible; PROG 1.0; Windows NT 5.0; YComp 5.0.2.4)"
+
+// mensajesStatusUpload describe los códigos STATUS de la respuesta RECEPCIONDTE
+var mensajesStatusUpload = map[string]string{
+	"0":  "Upload OK",
+	"1":  "El remitente no tiene permiso para enviar",
+	"2":  "Error en tamaño del archivo",
+	"3":  "Archivo cortado",
+	"5":  "No está autenticado",
+	"6":  "Empresa no autorizada a enviar archivos",
+	"7":  "Esquema inválido",
+	"8":  "Firma del documento inválida",
+	"9":  "Sistema bloqueado",
+	"99": "Error interno del SII",
+}
+
+// Ambientes resuelve las URLs del ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	EndpointsEmpresa(rutEmpresa string) (ambiente.Endpoints, error)
+}
+
+// Uploader envía archivos XML (EnvioDTE, libros, consumo de folios, cesiones) a los servicios de
+// carga del SII del ambiente de cada empresa
+type Uploader struct {
+	client    *http.Client
+	ambientes Ambientes
+}
+
+// NewUploader crea un nuevo uploader que toma las URLs de DTEUpload y del registro de cesiones
+// del ambiente de la empresa
+func NewUploader(ambientes Ambientes, timeout time.Duration) *Uploader {
+	return &Uploader{
+		client:    &http.Client{Timeout: timeout},
+		ambientes: ambientes,
+	}
+}
+
+// Subir envía el archivo al SII y retorna la respuesta con el TrackID asignado
+func (u *Uploader) Subir(ctx context.Context, token, rutEnvia, rutEmpresa, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error) {
+	rutSender, dvSender, err := utils.SepararRUT(rutEnvia)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de envío inválido: %v", err)
+	}
+	rutCompany, dvCompany, err := utils.SepararRUT(rutEmpresa)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de empresa inválido: %v", err)
+	}
+	endpoints, err := u.ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.enviarFormulario(ctx, endpoints.UploadDTE, token, []campoFormulario{
+		{"rutSender", rutSender},
+		{"dvSender", dvSender},
+		{"rutCompany", rutCompany},
+		{"dvCompany", dvCompany},
+	}, nombreArchivo, archivo)
+}
+
+// SubirAEC envía un Archivo Electrónico de Cesión al Registro Electrónico de Cesiones
+func (u *Uploader) SubirAEC(ctx context.Context, token, rutEmpresa, emailNotificacion, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error) {
+	rutCompany, dvCompany, err := utils.SepararRUT(rutEmpresa)
+	if err != nil {
+		return nil, fmt.Errorf("RUT de empresa inválido: %v", err)
+	}
+	endpoints, err := u.ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.enviarFormulario(ctx, endpoints.UploadCesion, token, []campoFormulario{
+		{"emailNotif", emailNotificacion},
+		{"rutCompany", rutCompany},
+		{"dvCompany", dvCompany},
+	}, nombreArchivo, archivo)
+}
+
+// campoFormulario representa un campo de texto del formulario multipart
+type campoFormulario struct {
+	nombre, valor string
+}
+
+// enviarFormulario envía el formulario multipart con el archivo adjunto y procesa la respuesta
+func (u *Uploader) enviarFormulario(ctx context.Context, url, token string, campos []campoFormulario, nombreArchivo string, archivo []byte) (*models.RespuestaUploadSII, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token es requerido")
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, campo := range campos {
+		if err := writer.WriteField(campo.nombre, campo.valor); err != nil {
+			return nil, fmt.Errorf("error al escribir campo %s: %v", campo.nombre, err)
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="archivo"; filename="%s"`, nombreArchivo))
+	header.Set("Content-Type", "text/xml")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear parte del archivo: %v", err)
+	}
+	if _, err := part.Write(archivo); err != nil {
+		return nil, fmt.Errorf("error al escribir archivo: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error al cerrar formulario: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("User-Agent", userAgentUpload)
+	req.Header.Set("Cookie", "TOKEN="+token)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al enviar archivo al SII: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer respuesta del SII: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d del SII: %s", resp.StatusCode, string(respBody))
+	}
+
+	return ParsearRespuestaUpload(respBody)
+}
+
+// ParsearRespuestaUpload interpreta el XML RECEPCIONDTE (o RECEPCIONAEC) retornado por el SII
+func ParsearRespuestaUpload(data []byte) (*models.RespuestaUploadSII, error) {
+	var respuesta models.RespuestaUploadSII
+	if err := xml.Unmarshal(data, &respuesta); err != nil {
+		return nil, fmt.Errorf("error al procesar respuesta del SII: %v", err)
+	}
+
+	if respuesta.Status == "5" {
+		// El token expiró o fue revocado; el administrador de tokens lo descarta y reintenta
+		return &respuesta, fmt.Errorf("SII rechazó el archivo (STATUS 5): %w", token.ErrTokenRechazado)
+	}
+	if respuesta.Status != "0" {
+		mensaje, ok := mensajesStatusUpload[respuesta.Status]
+		if !ok {
+			mensaje = "Error desconocido"
+		}
+		return &respuesta, fmt.Errorf("SII rechazó el archivo (STATUS %s): %s", respuesta.Status, mensaje)
+	}
+
+	if respuesta.TrackID == "" {
+		return &respuesta, fmt.Errorf("el SII no retornó un TrackID")
+	}
+
+	return &respuesta, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
@@ -9,20 +10,7 @@ import (
 	"time"
 
 	"github.com/cursor/FMgo/models"
-)
-
-const (
-	// Endpoints de certificación
-	SIISemillaEndpoint = "https://maullin.sii.cl/DTEWS/CrSeed.jws?wsdl"
-	SIITokenEndpoint   = "https://maullin.sii.cl/DTEWS/GetTokenFromSeed.jws?wsdl"
-	SIIEnvioEndpoint   = "https://maullin.sii.cl/cgi_dte/UPL/DTEUpload"
-	SIIEstadoEndpoint  = "https://maullin.sii.cl/DTEWS/QueryEstDte.jws?wsdl"
-
-	// Endpoints de producción (comentados por ahora)
-	// SIISemillaEndpoint = "https://palena.sii.cl/DTEWS/CrSeed.jws?wsdl"
-	// SIITokenEndpoint   = "https://palena.sii.cl/DTEWS/GetTokenFromSeed.jws?wsdl"
-	// SIIEnvioEndpoint   = "https://palena.sii.cl/cgi_dte/UPL/DTEUpload"
-	// SIIEstadoEndpoint  = "https://palena.sii.cl/DTEWS/QueryEstDte.jws?wsdl"
+	"github.com/cursor/FMgo/services/ambiente"
 )
 
 // SIIClientInterface define la interfaz común para clientes SII
@@ -32,11 +20,25 @@ type SIIClientInterface interface {
 	EnviarDTE(sobre *models.Sobre, token string) error
 }
 
+// TokenSII administra los tokens de autenticación SII compartidos entre clientes
+type TokenSII interface {
+	ObtenerToken(ctx context.Context, rutEmpresa string) (string, error)
+	Invalidar(ctx context.Context, rutEmpresa string) error
+}
+
+// AmbientesSII resuelve las URLs del ambiente SII de cada empresa (ambiente.Registro)
+type AmbientesSII interface {
+	EndpointsEmpresa(rutEmpresa string) (ambiente.Endpoints, error)
+}
+
 // SIIClient maneja la comunicación con el SII
 type SIIClient struct {
 	client     *http.Client
 	firma      *FirmaManager
 	simulacion bool
+	tokens     TokenSII
+	ambientes  AmbientesSII
+	rutEmpresa string
 }
 
 // MockSIIClient es un cliente mock para pruebas
@@ -83,8 +85,35 @@ func (c *SIIClient) SetSimulacion(simular bool) {
 	c.simulacion = simular
 }
 
+// SetTokens configura el administrador de tokens compartido. Con él configurado la semilla y
+// el token se obtienen desde el administrador en lugar de solicitarse en cada envío.
+func (c *SIIClient) SetTokens(tokens TokenSII, rutEmpresa string) {
+	c.tokens = tokens
+	c.rutEmpresa = rutEmpresa
+}
+
+// SetAmbientes configura el registro de ambientes y la empresa del cliente; las solicitudes
+// van a los servicios del ambiente de esa empresa
+func (c *SIIClient) SetAmbientes(ambientes AmbientesSII, rutEmpresa string) {
+	c.ambientes = ambientes
+	c.rutEmpresa = rutEmpresa
+}
+
+// endpoints retorna las URLs del ambiente de la empresa del cliente
+func (c *SIIClient) endpoints() (ambiente.Endpoints, error) {
+	if c.ambientes == nil {
+		return ambiente.Endpoints{}, fmt.Errorf("cliente SII sin registro de ambientes")
+	}
+	return c.ambientes.EndpointsEmpresa(c.rutEmpresa)
+}
+
 // ObtenerSemilla obtiene una semilla del SII
 func (c *SIIClient) ObtenerSemilla() (string, error) {
+	if c.tokens != nil {
+		// La semilla la solicita el administrador de tokens sólo cuando debe renovar
+		return "", nil
+	}
+
 	// Crear solicitud SOAP
 	soapRequest := `<?xml version="1.0" encoding="UTF-8"?>
 <soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ws="http://ws.sii.dte">
@@ -94,9 +123,14 @@ func (c *SIIClient) ObtenerSemilla() (string, error) {
    </soapenv:Body>
 </soapenv:Envelope>`
 
+	endpoints, err := c.endpoints()
+	if err != nil {
+		return "", err
+	}
+
 	// Enviar solicitud
 	resp, err := c.client.Post(
-		"https://maullin.sii.cl/DTEWS/CrSeed.jws",
+		endpoints.Semilla,
 		"text/xml;charset=UTF-8",
 		bytes.NewBufferString(soapRequest),
 	)
@@ -130,6 +164,10 @@ func (c *SIIClient) ObtenerSemilla() (string, error) {
 
 // ObtenerToken obtiene un token de autenticación del SII
 func (c *SIIClient) ObtenerToken(semilla string) (string, error) {
+	if c.tokens != nil {
+		return c.tokens.ObtenerToken(context.Background(), c.rutEmpresa)
+	}
+
 	// Firmar semilla
 	semillaFirmada, err := c.firma.FirmarSemilla(semilla)
 	if err != nil {
@@ -148,9 +186,14 @@ func (c *SIIClient) ObtenerToken(semilla string) (string, error) {
    </soapenv:Body>
 </soapenv:Envelope>`, semilla, semillaFirmada)
 
+	endpoints, err := c.endpoints()
+	if err != nil {
+		return "", err
+	}
+
 	// Enviar solicitud
 	resp, err := c.client.Post(
-		"https://maullin.sii.cl/DTEWS/GetTokenFromSeed.jws",
+		endpoints.Token,
 		"text/xml;charset=UTF-8",
 		bytes.NewBufferString(soapRequest),
 	)
@@ -208,9 +251,14 @@ func (c *SIIClient) EnviarDTE(sobre *models.Sobre, token string) error {
    </soapenv:Body>
 </soapenv:Envelope>`, token, string(sobreXMLFirmado))
 
+	endpoints, err := c.endpoints()
+	if err != nil {
+		return err
+	}
+
 	// Enviar solicitud
 	resp, err := c.client.Post(
-		"https://maullin.sii.cl/DTEWS/RecepcionDTE.jws",
+		endpoints.UploadDTE,
 		"text/xml;charset=UTF-8",
 		bytes.NewBufferString(soapRequest),
 	)
@@ -262,9 +310,14 @@ func (c *SIIClient) ConsultarEstado(trackID string) (*models.EstadoResult, error
    </soapenv:Body>
 </soapenv:Envelope>`, trackID)
 
+	endpoints, err := c.endpoints()
+	if err != nil {
+		return nil, err
+	}
+
 	// Enviar solicitud
 	resp, err := c.client.Post(
-		"https://maullin.sii.cl/DTEWS/QueryEstDte.jws",
+		endpoints.EstadoDTE,
 		"text/xml;charset=UTF-8",
 		bytes.NewBufferString(soapRequest),
 	)
@@ -10,58 +10,40 @@ import (
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
-	"github.com/cursor/FMgo/utils/sii"
 )
 
-// SIIService es un alias para la interfaz sii.SIIService
-type SIIService = sii.SIIService
+// SIIService envía DTE y consulta su estado en los servicios SOAP del SII, con los hosts del
+// ambiente de cada empresa
+type SIIService struct {
+	config    *config.SupabaseConfig
+	ambientes AmbientesSII
+	client    *http.Client
+}
 
-// NewSIIService crea una nueva instancia del servicio de SII
-func NewSIIService(config *config.SupabaseConfig) *SIIService {
+// NewSIIService crea una nueva instancia del servicio de SII que envía y consulta cada
+// documento en el ambiente de su empresa
+func NewSIIService(config *config.SupabaseConfig, ambientes AmbientesSII) *SIIService {
 	return &SIIService{
-		config: config,
+		config:    config,
+		ambientes: ambientes,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 }
 
-// EnviarDTE envía un documento al SII
+// EnviarDTE envía al SII el documento firmado de la empresa
 func (s *SIIService) EnviarDTE(xmlData []byte, empresa *models.Empresa) (*models.RespuestaSII, error) {
-	// Crear estructura de envío
-	dte := &models.DTEXMLModel{
-		Version: "1.0",
-		Documento: models.DocumentoXMLModel{
-			ID: fmt.Sprintf("DTE_%d", time.Now().Unix()),
-			Encabezado: models.EncabezadoXMLModel{
-				ID: models.IDDocumentoXMLModel{
-					TipoDTE:      models.FacturaElectronica,
-					Folio:        1,
-					FechaEmision: time.Now().Format("2006-01-02"),
-				},
-				Emisor: models.EmisorXMLModel{
-					RUT:         empresa.RUT,
-					RazonSocial: empresa.RazonSocial,
-					Giro:        empresa.Giro,
-					Direccion:   empresa.Direccion,
-					Comuna:      empresa.Comuna,
-					Ciudad:      empresa.Ciudad,
-				},
-			},
-		},
-	}
-
-	// Generar XML de envío
-	envioXML, err := xml.MarshalIndent(dte, "", "  ")
+	endpoints, err := s.ambientes.EndpointsEmpresa(empresa.RUT)
 	if err != nil {
-		return nil, fmt.Errorf("error al generar XML de envío: %v", err)
+		return nil, err
 	}
 
 	// Enviar al SII
 	resp, err := s.client.Post(
-		"https://palena.sii.cl/DTEWS/CrSeed.jws",
+		endpoints.UploadDTE,
 		"application/xml",
-		bytes.NewReader(envioXML),
+		bytes.NewReader(xmlData),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error al enviar al SII: %v", err)
@@ -83,14 +65,18 @@ func (s *SIIService) EnviarDTE(xmlData []byte, empresa *models.Empresa) (*models
 	return &respuesta, nil
 }
 
-// ConsultarEstado consulta el estado de un documento en el SII
-func (s *SIIService) ConsultarEstado(trackID string) (*models.EstadoSII, error) {
+// ConsultarEstado consulta el estado de un envío de la empresa en el SII
+func (s *SIIService) ConsultarEstado(rutEmpresa, trackID string) (*models.EstadoSII, error) {
 	if trackID == "" {
 		return nil, fmt.Errorf("trackID es requerido")
 	}
+	endpoints, err := s.ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
 
 	// Consultar estado al SII
-	resp, err := s.client.Get(fmt.Sprintf("https://palena.sii.cl/DTEWS/QueryEstDte.jws?trackID=%s", trackID))
+	resp, err := s.client.Get(fmt.Sprintf("%s?trackID=%s", endpoints.EstadoEnvio, trackID))
 	if err != nil {
 		return nil, fmt.Errorf("error al consultar estado al SII: %v", err)
 	}
@@ -117,8 +103,13 @@ func (s *SIIService) ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.Est
 		return nil, fmt.Errorf("tipoDTE, folio y rutEmisor son requeridos")
 	}
 
+	endpoints, err := s.ambientes.EndpointsEmpresa(rutEmisor)
+	if err != nil {
+		return nil, err
+	}
+
 	// Consultar DTE al SII
-	resp, err := s.client.Get(fmt.Sprintf("https://palena.sii.cl/DTEWS/QueryDte.jws?tipoDTE=%s&folio=%s&rutEmisor=%s", tipoDTE, folio, rutEmisor))
+	resp, err := s.client.Get(fmt.Sprintf("%s?tipoDTE=%s&folio=%s&rutEmisor=%s", endpoints.EstadoDTE, tipoDTE, folio, rutEmisor))
 	if err != nil {
 		return nil, fmt.Errorf("error al consultar DTE al SII: %v", err)
 	}
@@ -139,10 +130,15 @@ func (s *SIIService) ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.Est
 	return &estado, nil
 }
 
-// VerificarComunicacion verifica la comunicación con el SII
-func (s *SIIService) VerificarComunicacion() error {
+// VerificarComunicacion verifica la comunicación con el SII del ambiente de la empresa
+func (s *SIIService) VerificarComunicacion(rutEmpresa string) error {
+	endpoints, err := s.ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return err
+	}
+
 	// Verificar comunicación con el SII
-	resp, err := s.client.Get("https://palena.sii.cl/DTEWS/CrSeed.jws")
+	resp, err := s.client.Get(endpoints.Semilla)
 	if err != nil {
 		return fmt.Errorf("error al verificar comunicación con el SII: %v", err)
 	}
@@ -160,7 +156,7 @@ func (s *SIIService) VerificarComunicacion() error {
 		return fmt.Errorf("error al parsear estado del SII: %v", err)
 	}
 
-	if estado.Estado != models.EstadoSIIAceptado {
+	if estado.Estado != string(models.EstadoSIIAceptado) {
 		return fmt.Errorf("error de comunicación con el SII: %s", estado.Glosa)
 	}
 
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/exportacion"
+	"github.com/cursor/FMgo/services/facturacompra"
 )
 
 // SobreService representa el servicio para gestionar sobres de envío
@@ -114,6 +116,14 @@ func tipoDocumentoACodigo(tipo models.TipoDocumento) string {
 		return "56"
 	case models.TipoGuiaDespacho:
 		return "52"
+	case models.DocumentoFacturaCompra:
+		return "46"
+	case models.DocumentoFacturaExportacion:
+		return "110"
+	case models.DocumentoNotaDebitoExportacion:
+		return "111"
+	case models.DocumentoNotaCreditoExportacion:
+		return "112"
 	default:
 		return "33" // Factura por defecto
 	}
@@ -220,6 +230,17 @@ func (s *SobreService) GenerarSobreMultiple(documentos []models.DocumentoTributa
 
 	// Agregar cada documento al sobre
 	for _, doc := range documentos {
+		if doc.TipoDocumento.EsExportacion() {
+			dte, err := generarDTEExportacion(&doc)
+			if err != nil {
+				return nil, err
+			}
+			sobre.SetDTE.Exportaciones = append(sobre.SetDTE.Exportaciones, *dte)
+			continue
+		}
+		if err := validarDocumentoSobre(&doc); err != nil {
+			return nil, err
+		}
 		dte := generarDTEDesdeDocumento(&doc)
 		sobre.SetDTE.DTEs = append(sobre.SetDTE.DTEs, dte)
 	}
@@ -280,6 +301,17 @@ func (s *SobreService) GenerarSobreReceptor(documentos []models.DocumentoTributa
 
 	// Agregar cada documento al sobre
 	for _, doc := range documentos {
+		if doc.TipoDocumento.EsExportacion() {
+			dte, err := generarDTEExportacion(&doc)
+			if err != nil {
+				return nil, err
+			}
+			sobre.SetDTE.Exportaciones = append(sobre.SetDTE.Exportaciones, *dte)
+			continue
+		}
+		if err := validarDocumentoSobre(&doc); err != nil {
+			return nil, err
+		}
 		dte := generarDTEDesdeDocumento(&doc)
 		sobre.SetDTE.DTEs = append(sobre.SetDTE.DTEs, dte)
 	}
@@ -336,6 +368,17 @@ func (s *SobreService) GenerarSobreSII(documentos []models.DocumentoTributario,
 
 	// Agregar cada documento al sobre
 	for _, doc := range documentos {
+		if doc.TipoDocumento.EsExportacion() {
+			dte, err := generarDTEExportacion(&doc)
+			if err != nil {
+				return nil, err
+			}
+			sobre.SetDTE.Exportaciones = append(sobre.SetDTE.Exportaciones, *dte)
+			continue
+		}
+		if err := validarDocumentoSobre(&doc); err != nil {
+			return nil, err
+		}
 		dte := generarDTEDesdeDocumento(&doc)
 		sobre.SetDTE.DTEs = append(sobre.SetDTE.DTEs, dte)
 	}
@@ -619,6 +662,27 @@ func generarDTEDesdeDocumento(doc *models.DocumentoTributario) models.DTEType {
 	return dte
 }
 
+// generarDTEExportacion arma el DTE de una factura, nota de débito o nota de crédito de
+// exportación con el receptor extranjero, los datos de Aduana y los totales en OtraMoneda
+func generarDTEExportacion(doc *models.DocumentoTributario) (*models.DTEExportacionXML, error) {
+	dte, err := exportacion.ConstruirDTE(doc)
+	if err != nil {
+		return nil, fmt.Errorf("documento de exportación con folio %d inválido: %v", doc.Folio, err)
+	}
+	return dte, nil
+}
+
+// validarDocumentoSobre aplica las validaciones propias del tipo de documento antes de
+// incluirlo en un sobre
+func validarDocumentoSobre(doc *models.DocumentoTributario) error {
+	if doc.TipoDocumento == models.TipoFacturaCompra {
+		if err := facturacompra.Validar(doc); err != nil {
+			return fmt.Errorf("factura de compra con folio %d inválida: %v", doc.Folio, err)
+		}
+	}
+	return nil
+}
+
 // esBoleta determina si un tipo de documento es boleta
 func esBoleta(tipo models.TipoDocumento) bool {
 	return tipo == models.TipoBoleta
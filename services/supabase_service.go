@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cursor/FMgo/config"
+	"github.com/cursor/FMgo/models"
 	"github.com/supabase-community/supabase-go"
 )
 
@@ -26,19 +27,20 @@ func NewSupabaseService(cfg *config.SupabaseConfig) *SupabaseService {
 
 // SupabaseDocumento representa un documento tributario en Supabase
 type SupabaseDocumento struct {
-	ID          string    `json:"id"`
-	Tipo        string    `json:"tipo"` // DTE, BOLETA, etc.
-	RutEmisor   string    `json:"rut_emisor"`
-	RutReceptor string    `json:"rut_receptor"`
-	Folio       int       `json:"folio"`
-	MontoTotal  float64   `json:"monto_total"`
-	Estado      string    `json:"estado"`
-	XML         string    `json:"xml"`
-	PDF         string    `json:"pdf"`
-	Firma       string    `json:"firma"`
-	TED         string    `json:"ted"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string             `json:"id"`
+	Tipo        string             `json:"tipo"` // DTE, BOLETA, etc.
+	RutEmisor   string             `json:"rut_emisor"`
+	RutReceptor string             `json:"rut_receptor"`
+	Folio       int                `json:"folio"`
+	MontoTotal  float64            `json:"monto_total"`
+	Estado      string             `json:"estado"`
+	XML         string             `json:"xml"`
+	PDF         string             `json:"pdf"`
+	Firma       string             `json:"firma"`
+	TED         string             `json:"ted"`
+	Cesion      *models.CesionInfo `json:"cesion,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
 }
 
 // GuardarDocumento guarda un documento en Supabase
@@ -85,6 +87,33 @@ func (s *SupabaseService) ActualizarEstadoDocumento(ctx context.Context, id stri
 	return nil
 }
 
+// ObtenerXMLDocumento obtiene el XML firmado de un documento
+func (s *SupabaseService) ObtenerXMLDocumento(ctx context.Context, id string) (string, error) {
+	doc, err := s.ObtenerDocumento(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if doc.XML == "" {
+		return "", fmt.Errorf("el documento %s no tiene XML firmado", id)
+	}
+	return doc.XML, nil
+}
+
+// RegistrarCesion registra en el documento la cesión vigente del crédito
+func (s *SupabaseService) RegistrarCesion(ctx context.Context, id string, cesion *models.CesionInfo) error {
+	update := map[string]interface{}{
+		"cesion":     cesion,
+		"updated_at": time.Now(),
+	}
+
+	err := s.client.DB.From("documentos").Update(update).Eq("id", id).Execute(nil)
+	if err != nil {
+		return fmt.Errorf("error al registrar cesión: %v", err)
+	}
+
+	return nil
+}
+
 // ListarDocumentos obtiene una lista de documentos con filtros opcionales
 func (s *SupabaseService) ListarDocumentos(ctx context.Context, filtros map[string]interface{}) ([]SupabaseDocumento, error) {
 	query := s.client.DB.From("documentos").Select("*")
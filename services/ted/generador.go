@@ -0,0 +1,271 @@
+package ted
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/utils"
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+const (
+	// largoMaximoTexto es el largo máximo de RSR e IT1 en el DD
+	largoMaximoTexto = 40
+	// RutSinReceptor es el RUT que se timbra en boletas emitidas sin receptor identificado
+	RutSinReceptor = "66666666-6"
+	// formatoTSTED es el formato del instante de generación del timbre
+	formatoTSTED = "2006-01-02T15:04:05"
+)
+
+// Documento contiene los datos del documento que se timbran en el DD
+type Documento struct {
+	RutEmisor           string
+	TipoDTE             int
+	Folio               int64
+	FchEmis             string
+	RutReceptor         string
+	RazonSocialReceptor string
+	MontoTotal          int64
+	PrimerItem          string
+}
+
+// Autorizaciones entrega el CAF vigente de un tipo de documento
+type Autorizaciones interface {
+	GetCAF(tipoDTE int) (*caf.CAFXml, error)
+}
+
+// Generador timbra documentos con la llave privada del CAF que autoriza su folio
+type Generador struct {
+	cafs  Autorizaciones
+	ahora func() time.Time
+}
+
+// NewGenerador crea un generador de timbres que obtiene los CAF del administrador indicado
+// (normalmente un caf.Manager)
+func NewGenerador(cafs Autorizaciones) *Generador {
+	return &Generador{cafs: cafs, ahora: time.Now}
+}
+
+// Generar construye y firma el TED del documento con el CAF de su tipo
+func (g *Generador) Generar(doc Documento) (*etree.Element, error) {
+	autorizacion, err := g.cafs.GetCAF(doc.TipoDTE)
+	if err != nil {
+		return nil, err
+	}
+	return Timbrar(doc, autorizacion, g.ahora())
+}
+
+// GenerarModelo construye el TED del documento y lo retorna como modelo XML
+func (g *Generador) GenerarModelo(doc Documento) (*models.TEDXMLModel, error) {
+	ted, err := g.Generar(doc)
+	if err != nil {
+		return nil, err
+	}
+	return Modelo(ted)
+}
+
+// TimbrarDocumento lee los datos del elemento Documento (o Exportaciones, Liquidacion) y le
+// agrega el TED, antes de TmstFirma si existe. Un TED anterior se reemplaza.
+func (g *Generador) TimbrarDocumento(documento *etree.Element) error {
+	doc, err := DocumentoDesdeXML(documento)
+	if err != nil {
+		return err
+	}
+	ted, err := g.Generar(doc)
+	if err != nil {
+		return err
+	}
+
+	if anterior := documento.SelectElement("TED"); anterior != nil {
+		documento.RemoveChild(anterior)
+	}
+	if tmst := documento.SelectElement("TmstFirma"); tmst != nil {
+		documento.InsertChildAt(tmst.Index(), ted)
+	} else {
+		documento.AddChild(ted)
+	}
+	return nil
+}
+
+// Verificar comprueba el TED contra el CAF registrado para su tipo de documento
+func (g *Generador) Verificar(ted *etree.Element) (*DatosTED, error) {
+	tipo, err := strconv.Atoi(texto(ted, "DD/TD"))
+	if err != nil {
+		return nil, fmt.Errorf("tipo de documento inválido en el timbre: %v", err)
+	}
+	autorizacion, err := g.cafs.GetCAF(tipo)
+	if err != nil {
+		return nil, err
+	}
+	return VerificarConCAF(ted, autorizacion)
+}
+
+// Timbrar construye el DD con los datos del documento y el nodo CAF, y lo firma con la
+// llave RSASK del CAF (FRMT SHA1withRSA sobre el DD aplanado en ISO-8859-1)
+func Timbrar(doc Documento, autorizacion *caf.CAFXml, instante time.Time) (*etree.Element, error) {
+	if autorizacion.TipoDTE != doc.TipoDTE {
+		return nil, fmt.Errorf("el CAF corresponde al tipo %d y no al tipo %d", autorizacion.TipoDTE, doc.TipoDTE)
+	}
+	if !autorizacion.ContieneFolio(int(doc.Folio)) {
+		return nil, fmt.Errorf("el folio %d está fuera del rango autorizado %d-%d", doc.Folio, autorizacion.FolioInicio, autorizacion.FolioFinal)
+	}
+	rutEmisor := utils.NormalizarRUT(doc.RutEmisor)
+	if rutCAF := utils.NormalizarRUT(autorizacion.RUTEmisor); rutCAF != rutEmisor {
+		return nil, fmt.Errorf("el CAF pertenece a %s y no al emisor %s", rutCAF, rutEmisor)
+	}
+
+	nodoCAF, err := autorizacion.Nodo()
+	if err != nil {
+		return nil, err
+	}
+	llave, err := autorizacion.LlavePrivada()
+	if err != nil {
+		return nil, err
+	}
+
+	rutReceptor := utils.NormalizarRUT(doc.RutReceptor)
+	if rutReceptor == "" {
+		rutReceptor = RutSinReceptor
+	}
+
+	ted := etree.NewElement("TED")
+	ted.CreateAttr("version", "1.0")
+	dd := ted.CreateElement("DD")
+	dd.CreateElement("RE").SetText(rutEmisor)
+	dd.CreateElement("TD").SetText(strconv.Itoa(doc.TipoDTE))
+	dd.CreateElement("F").SetText(strconv.FormatInt(doc.Folio, 10))
+	dd.CreateElement("FE").SetText(doc.FchEmis)
+	dd.CreateElement("RR").SetText(rutReceptor)
+	dd.CreateElement("RSR").SetText(truncar(doc.RazonSocialReceptor))
+	dd.CreateElement("MNT").SetText(strconv.FormatInt(doc.MontoTotal, 10))
+	dd.CreateElement("IT1").SetText(truncar(doc.PrimerItem))
+	dd.AddChild(nodoCAF)
+	dd.CreateElement("TSTED").SetText(instante.Format(formatoTSTED))
+	// El DD se emite tal como se firma, sin los espacios del archivo CAF
+	quitarEspacios(dd)
+
+	plano, err := AplanarDD(dd)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(plano)
+	firma, err := rsa.SignPKCS1v15(rand.Reader, llave, crypto.SHA1, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("error al firmar DD: %v", err)
+	}
+
+	frmt := ted.CreateElement("FRMT")
+	frmt.CreateAttr("algoritmo", "SHA1withRSA")
+	frmt.SetText(base64.StdEncoding.EncodeToString(firma))
+
+	return ted, nil
+}
+
+// VerificarConCAF comprueba la firma del TED y que haya sido emitido con el CAF indicado, y
+// no sólo con la llave incluida en el propio timbre
+func VerificarConCAF(ted *etree.Element, autorizacion *caf.CAFXml) (*DatosTED, error) {
+	datos, err := Verificar(ted)
+	if err != nil {
+		return nil, err
+	}
+
+	publica, err := autorizacion.LlavePublica()
+	if err != nil {
+		return nil, err
+	}
+	rsapk := ted.FindElement("DD/CAF/DA/RSAPK")
+	incluida, err := llaveRSAPK(rsapk)
+	if err != nil {
+		return nil, err
+	}
+	if !publica.Equal(incluida) {
+		return nil, fmt.Errorf("el timbre no fue emitido con el CAF del tipo %d: %w", autorizacion.TipoDTE, ErrFirmaTED)
+	}
+	if datos.FolioDesde != int64(autorizacion.FolioInicio) || datos.FolioHasta != int64(autorizacion.FolioFinal) {
+		return nil, fmt.Errorf("el rango del timbre %d-%d no corresponde al CAF %d-%d",
+			datos.FolioDesde, datos.FolioHasta, autorizacion.FolioInicio, autorizacion.FolioFinal)
+	}
+
+	return datos, nil
+}
+
+// DocumentoDesdeXML obtiene los datos a timbrar desde el Encabezado y el primer Detalle del
+// documento. Las boletas sin receptor se timbran con RutSinReceptor.
+func DocumentoDesdeXML(documento *etree.Element) (Documento, error) {
+	doc := Documento{
+		RutEmisor:           texto(documento, "Encabezado/Emisor/RUTEmisor"),
+		FchEmis:             texto(documento, "Encabezado/IdDoc/FchEmis"),
+		RutReceptor:         texto(documento, "Encabezado/Receptor/RUTRecep"),
+		RazonSocialReceptor: texto(documento, "Encabezado/Receptor/RznSocRecep"),
+		PrimerItem:          texto(documento, "Detalle/NmbItem"),
+	}
+	if doc.RutReceptor == "" {
+		doc.RutReceptor = RutSinReceptor
+		if doc.RazonSocialReceptor == "" {
+			doc.RazonSocialReceptor = "Consumidor Final"
+		}
+	}
+
+	var err error
+	if doc.TipoDTE, err = strconv.Atoi(texto(documento, "Encabezado/IdDoc/TipoDTE")); err != nil {
+		return doc, fmt.Errorf("tipo de documento inválido: %v", err)
+	}
+	if doc.Folio, err = strconv.ParseInt(texto(documento, "Encabezado/IdDoc/Folio"), 10, 64); err != nil {
+		return doc, fmt.Errorf("folio inválido: %v", err)
+	}
+	// Los documentos de exportación informan el total con decimales en la moneda de la
+	// operación; el DD lo registra redondeado al entero
+	total, err := strconv.ParseFloat(texto(documento, "Encabezado/Totales/MntTotal"), 64)
+	if err != nil {
+		return doc, fmt.Errorf("monto total inválido: %v", err)
+	}
+	doc.MontoTotal = int64(math.Round(total))
+
+	return doc, nil
+}
+
+// Modelo convierte el TED en el modelo XML que se incluye en DocumentoXMLModel
+func Modelo(ted *etree.Element) (*models.TEDXMLModel, error) {
+	var contenido strings.Builder
+	for _, hijo := range ted.ChildElements() {
+		doc := etree.NewDocument()
+		doc.SetRoot(hijo.Copy())
+		xmlHijo, err := doc.WriteToString()
+		if err != nil {
+			return nil, fmt.Errorf("error al escribir TED: %v", err)
+		}
+		contenido.WriteString(xmlHijo)
+	}
+	return &models.TEDXMLModel{
+		Version:   ted.SelectAttrValue("version", "1.0"),
+		Contenido: contenido.String(),
+	}, nil
+}
+
+// llaveRSAPK lee la llave pública de un nodo RSAPK
+func llaveRSAPK(rsapk *etree.Element) (*rsa.PublicKey, error) {
+	if rsapk == nil {
+		return nil, fmt.Errorf("el CAF del timbre no contiene la llave RSAPK")
+	}
+	return xmldsig.LlaveRSA(texto(rsapk, "M"), texto(rsapk, "E"))
+}
+
+// truncar limita el texto al largo máximo de RSR e IT1
+func truncar(s string) string {
+	s = strings.TrimSpace(s)
+	if runas := []rune(s); len(runas) > largoMaximoTexto {
+		return string(runas[:largoMaximoTexto])
+	}
+	return s
+}
@@ -0,0 +1,214 @@
+package ted
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/services/caf"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cafPrueba genera un archivo CAF del SII con una llave creada para la prueba
+func cafPrueba(t *testing.T, tipoDTE int) []byte {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	privada := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(llave)})
+	publica, err := x509.MarshalPKIXPublicKey(&llave.PublicKey)
+	require.NoError(t, err)
+
+	return []byte(fmt.Sprintf(`<?xml version="1.0"?>
+<AUTORIZACION>
+<CAF version="1.0">
+<DA>
+<RE>76212889-6</RE>
+<RS>EMISOR</RS>
+<TD>%d</TD>
+<RNG><D>1</D><H>100</H></RNG>
+<FA>2024-01-01</FA>
+<RSAPK><M>%s</M><E>%s</E></RSAPK>
+<IDK>100</IDK>
+</DA>
+<FRMA algoritmo="SHA1withRSA">c2lp</FRMA>
+</CAF>
+<RSASK>%s</RSASK>
+<RSAPUBK>%s</RSAPUBK>
+</AUTORIZACION>`, tipoDTE,
+		base64.StdEncoding.EncodeToString(llave.N.Bytes()),
+		base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()),
+		privada,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publica})))
+}
+
+// generadorPrueba crea un generador con un CAF de prueba para el tipo indicado
+func generadorPrueba(t *testing.T, tipoDTE int) (*Generador, *caf.CAFXml) {
+	t.Helper()
+	autorizacion, err := caf.ParseCAF(cafPrueba(t, tipoDTE))
+	require.NoError(t, err)
+
+	manager := caf.NewManager("")
+	require.NoError(t, manager.AgregarCAF(autorizacion))
+
+	generador := NewGenerador(manager)
+	generador.ahora = func() time.Time { return time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC) }
+	return generador, autorizacion
+}
+
+func TestTimbrarDocumento(t *testing.T) {
+	generador, autorizacion := generadorPrueba(t, 33)
+
+	doc, err := xmldsig.ParseDocument([]byte(`<DTE xmlns="http://www.sii.cl/SiiDte" version="1.0"><Documento ID="F7T33">` +
+		`<Encabezado><IdDoc><TipoDTE>33</TipoDTE><Folio>7</Folio><FchEmis>2024-03-01</FchEmis></IdDoc>` +
+		`<Emisor><RUTEmisor>76.212.889-6</RUTEmisor></Emisor>` +
+		`<Receptor><RUTRecep>11111111-1</RUTRecep><RznSocRecep>Comercial Ñandú &amp; Compañía Limitada de Servicios</RznSocRecep></Receptor>` +
+		`<Totales><MntTotal>119000</MntTotal></Totales></Encabezado>` +
+		`<Detalle><NroLinDet>1</NroLinDet><NmbItem>Servicio</NmbItem></Detalle>` +
+		`<TmstFirma>2024-03-01T10:00:00</TmstFirma></Documento></DTE>`))
+	require.NoError(t, err)
+	documento := doc.FindElement("//Documento")
+
+	require.NoError(t, generador.TimbrarDocumento(documento))
+
+	timbre := documento.SelectElement("TED")
+	require.NotNil(t, timbre)
+	assert.Equal(t, "TmstFirma", documento.ChildElements()[len(documento.ChildElements())-1].Tag)
+	assert.Equal(t, "76212889-6", texto(timbre, "DD/RE"))
+	assert.Equal(t, "Comercial Ñandú & Compañía Limitada de S", texto(timbre, "DD/RSR"))
+	assert.Equal(t, "2024-03-01T10:00:00", texto(timbre, "DD/TSTED"))
+
+	// El timbre se verifica igual después de serializar e indentar el documento
+	doc.Indent(2)
+	data, err := doc.WriteToBytes()
+	require.NoError(t, err)
+	leido, err := xmldsig.ParseDocument(data)
+	require.NoError(t, err)
+
+	datos, err := VerificarConCAF(leido.FindElement("//TED"), autorizacion)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), datos.Folio)
+	assert.Equal(t, int64(119000), datos.MontoTotal)
+
+	_, err = generador.Verificar(leido.FindElement("//TED"))
+	assert.NoError(t, err)
+}
+
+func TestTimbrarBoletaSinReceptor(t *testing.T) {
+	generador, _ := generadorPrueba(t, 39)
+
+	timbre, err := generador.Generar(Documento{
+		RutEmisor:  "76212889-6",
+		TipoDTE:    39,
+		Folio:      1,
+		FchEmis:    "2024-03-01",
+		MontoTotal: 1000,
+		PrimerItem: "Café",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, RutSinReceptor, texto(timbre, "DD/RR"))
+
+	datos, err := Verificar(timbre)
+	require.NoError(t, err)
+	assert.Equal(t, 39, datos.TipoDTE)
+}
+
+func TestTimbrarConComillasYApostrofes(t *testing.T) {
+	generador, autorizacion := generadorPrueba(t, 33)
+
+	timbre, err := generador.Generar(Documento{
+		RutEmisor:           "76212889-6",
+		TipoDTE:             33,
+		Folio:               8,
+		FchEmis:             "2024-03-01",
+		RutReceptor:         "11111111-1",
+		RazonSocialReceptor: `Comercial O'Higgins "Sur" Ltda`,
+		MontoTotal:          5000,
+		PrimerItem:          `Tubo 3/4" d'acero`,
+	})
+	require.NoError(t, err)
+
+	// El DD firmado es el mismo que queda en el documento emitido
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="ISO-8859-1"`)
+	doc.SetRoot(timbre)
+	emitido, err := xmldsig.Serializar(doc)
+	require.NoError(t, err)
+	plano, err := AplanarDD(timbre.SelectElement("DD"))
+	require.NoError(t, err)
+	assert.Contains(t, string(plano), "<RSR>Comercial O&apos;Higgins &quot;Sur&quot; Ltda</RSR>")
+	assert.Contains(t, string(plano), "<IT1>Tubo 3/4&quot; d&apos;acero</IT1>")
+	assert.True(t, bytes.Contains(emitido, plano))
+
+	leido, err := xmldsig.ParseDocument(emitido)
+	require.NoError(t, err)
+	datos, err := VerificarConCAF(leido.Root(), autorizacion)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), datos.Folio)
+}
+
+func TestDocumentoDesdeXMLExportacion(t *testing.T) {
+	doc, err := xmldsig.ParseDocument([]byte(`<DTE version="1.0"><Exportaciones ID="T110F3">` +
+		`<Encabezado><IdDoc><TipoDTE>110</TipoDTE><Folio>3</Folio><FchEmis>2024-03-01</FchEmis></IdDoc>` +
+		`<Emisor><RUTEmisor>76212889-6</RUTEmisor></Emisor>` +
+		`<Receptor><RUTRecep>55555555-5</RUTRecep><RznSocRecep>Fruit Imports LLC</RznSocRecep></Receptor>` +
+		`<Totales><TpoMoneda>DOLAR USA</TpoMoneda><MntExe>4245.5</MntExe><MntTotal>4245.5</MntTotal></Totales></Encabezado>` +
+		`<Detalle><NroLinDet>1</NroLinDet><NmbItem>Cerezas</NmbItem><MontoItem>4245.5</MontoItem></Detalle>` +
+		`</Exportaciones></DTE>`))
+	require.NoError(t, err)
+
+	datos, err := DocumentoDesdeXML(doc.FindElement("//Exportaciones"))
+	require.NoError(t, err)
+	assert.Equal(t, 110, datos.TipoDTE)
+	assert.Equal(t, "55555555-5", datos.RutReceptor)
+	assert.Equal(t, int64(4246), datos.MontoTotal)
+}
+
+func TestTimbrarFolioFueraDeRango(t *testing.T) {
+	generador, _ := generadorPrueba(t, 33)
+
+	_, err := generador.Generar(Documento{RutEmisor: "76212889-6", TipoDTE: 33, Folio: 101})
+	assert.ErrorContains(t, err, "fuera del rango")
+
+	_, err = generador.Generar(Documento{RutEmisor: "76212889-6", TipoDTE: 34, Folio: 1})
+	assert.Error(t, err)
+}
+
+func TestVerificarConOtroCAF(t *testing.T) {
+	generador, _ := generadorPrueba(t, 33)
+	otro, err := caf.ParseCAF(cafPrueba(t, 33))
+	require.NoError(t, err)
+
+	timbre, err := generador.Generar(Documento{RutEmisor: "76212889-6", TipoDTE: 33, Folio: 5, MontoTotal: 10})
+	require.NoError(t, err)
+
+	_, err = VerificarConCAF(timbre, otro)
+	assert.ErrorIs(t, err, ErrFirmaTED)
+}
+
+func TestModelo(t *testing.T) {
+	generador, _ := generadorPrueba(t, 33)
+	timbre, err := generador.Generar(Documento{RutEmisor: "76212889-6", TipoDTE: 33, Folio: 5, MontoTotal: 10})
+	require.NoError(t, err)
+
+	modelo, err := Modelo(timbre)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", modelo.Version)
+	assert.True(t, strings.HasPrefix(modelo.Contenido, "<DD>"))
+
+	doc, err := xmldsig.ParseDocument([]byte(`<TED version="1.0">` + modelo.Contenido + `</TED>`))
+	require.NoError(t, err)
+	_, err = Verificar(doc.Root())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,168 @@
+package ted
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ErrFirmaTED indica que la firma FRMT no corresponde al DD del timbre
+var ErrFirmaTED = errors.New("la firma del timbre electrónico no es válida")
+
+// DatosTED contiene los datos del DD (datos del documento) de un timbre verificado
+type DatosTED struct {
+	RutEmisor   string
+	TipoDTE     int
+	Folio       int64
+	FchEmis     string
+	RutReceptor string
+	MontoTotal  int64
+	FolioDesde  int64
+	FolioHasta  int64
+}
+
+// Verificar comprueba la firma FRMT del TED con la llave pública del CAF incluido en el DD
+// y que el folio timbrado pertenezca al rango autorizado
+func Verificar(ted *etree.Element) (*DatosTED, error) {
+	dd := ted.SelectElement("DD")
+	if dd == nil {
+		return nil, fmt.Errorf("el timbre no contiene el nodo DD")
+	}
+	frmt := ted.SelectElement("FRMT")
+	if frmt == nil {
+		return nil, fmt.Errorf("el timbre no contiene el nodo FRMT")
+	}
+
+	datos, err := leerDD(dd)
+	if err != nil {
+		return nil, err
+	}
+
+	llave, err := llaveRSAPK(dd.FindElement("CAF/DA/RSAPK"))
+	if err != nil {
+		return nil, fmt.Errorf("error al leer llave del CAF: %v", err)
+	}
+
+	firma, err := xmldsig.DecodificarBase64(frmt.Text())
+	if err != nil {
+		return nil, fmt.Errorf("error al decodificar FRMT: %v", err)
+	}
+
+	plano, err := AplanarDD(dd)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(plano)
+	if err := rsa.VerifyPKCS1v15(llave, crypto.SHA1, hash[:], firma); err != nil {
+		return nil, ErrFirmaTED
+	}
+
+	if datos.Folio < datos.FolioDesde || datos.Folio > datos.FolioHasta {
+		return nil, fmt.Errorf("el folio %d está fuera del rango autorizado %d-%d", datos.Folio, datos.FolioDesde, datos.FolioHasta)
+	}
+
+	return datos, nil
+}
+
+// AplanarDD serializa el DD sin espacios entre elementos y en ISO-8859-1, que es la forma
+// sobre la que se calcula la firma FRMT. Se escribe con etree y su configuración por defecto,
+// la misma con que se emite el documento, para que el escape de los textos (por ejemplo
+// &apos; y &quot;) coincida con el DD enviado.
+func AplanarDD(dd *etree.Element) ([]byte, error) {
+	plano := dd.Copy()
+	quitarEspacios(plano)
+
+	var buf bytes.Buffer
+	plano.WriteTo(&buf, &etree.NewDocument().WriteSettings)
+
+	codificado, err := charmap.ISO8859_1.NewEncoder().Bytes(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error al codificar DD en ISO-8859-1: %v", err)
+	}
+	return codificado, nil
+}
+
+// quitarEspacios elimina las declaraciones de namespace, los comentarios y los nodos de texto
+// que sólo contienen espacios entre elementos hijos
+func quitarEspacios(el *etree.Element) {
+	atributos := el.Attr[:0]
+	for _, a := range el.Attr {
+		if (a.Space == "" && a.Key == "xmlns") || a.Space == "xmlns" {
+			continue
+		}
+		atributos = append(atributos, a)
+	}
+	el.Attr = atributos
+
+	conHijos := len(el.ChildElements()) > 0
+	for _, token := range append([]etree.Token(nil), el.Child...) {
+		switch t := token.(type) {
+		case *etree.Element:
+			quitarEspacios(t)
+		case *etree.CharData:
+			if conHijos && strings.TrimSpace(t.Data) == "" {
+				el.RemoveChild(t)
+			}
+		default:
+			el.RemoveChild(t)
+		}
+	}
+}
+
+// leerDD obtiene los datos del documento y el rango del CAF
+func leerDD(dd *etree.Element) (*DatosTED, error) {
+	datos := &DatosTED{
+		RutEmisor:   texto(dd, "RE"),
+		FchEmis:     texto(dd, "FE"),
+		RutReceptor: texto(dd, "RR"),
+	}
+
+	var err error
+	if datos.TipoDTE, err = strconv.Atoi(texto(dd, "TD")); err != nil {
+		return nil, fmt.Errorf("tipo de documento inválido en el timbre: %v", err)
+	}
+	if datos.Folio, err = strconv.ParseInt(texto(dd, "F"), 10, 64); err != nil {
+		return nil, fmt.Errorf("folio inválido en el timbre: %v", err)
+	}
+	if datos.MontoTotal, err = strconv.ParseInt(texto(dd, "MNT"), 10, 64); err != nil {
+		return nil, fmt.Errorf("monto inválido en el timbre: %v", err)
+	}
+
+	rango := dd.FindElement("CAF/DA/RNG")
+	if rango == nil {
+		return nil, fmt.Errorf("el CAF del timbre no contiene el rango autorizado")
+	}
+	if datos.FolioDesde, err = strconv.ParseInt(texto(rango, "D"), 10, 64); err != nil {
+		return nil, fmt.Errorf("rango inválido en el CAF: %v", err)
+	}
+	if datos.FolioHasta, err = strconv.ParseInt(texto(rango, "H"), 10, 64); err != nil {
+		return nil, fmt.Errorf("rango inválido en el CAF: %v", err)
+	}
+
+	if tipoCAF := texto(dd, "CAF/DA/TD"); tipoCAF != strconv.Itoa(datos.TipoDTE) {
+		return nil, fmt.Errorf("el CAF corresponde al tipo %s y no al tipo %d", tipoCAF, datos.TipoDTE)
+	}
+	if rutCAF := texto(dd, "CAF/DA/RE"); rutCAF != datos.RutEmisor {
+		return nil, fmt.Errorf("el CAF pertenece a %s y no al emisor %s", rutCAF, datos.RutEmisor)
+	}
+
+	return datos, nil
+}
+
+// texto retorna el texto sin espacios del elemento en la ruta indicada
+func texto(el *etree.Element, ruta string) string {
+	hijo := el.FindElement(ruta)
+	if hijo == nil {
+		return ""
+	}
+	return strings.TrimSpace(hijo.Text())
+}
@@ -0,0 +1,86 @@
+package ted
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timbrePrueba genera un TED firmado con una llave de CAF creada para la prueba
+func timbrePrueba(t *testing.T, folio int, razonSocial string) string {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	modulo := base64.StdEncoding.EncodeToString(llave.N.Bytes())
+	exponente := base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes())
+	dd := fmt.Sprintf(`<DD><RE>76212889-6</RE><TD>33</TD><F>%d</F><FE>2024-03-01</FE><RR>11111111-1</RR>`+
+		`<RSR>%s</RSR><MNT>119000</MNT><IT1>Servicio</IT1>`+
+		`<CAF version="1.0"><DA><RE>76212889-6</RE><RS>EMISOR</RS><TD>33</TD><RNG><D>1</D><H>100</H></RNG>`+
+		`<FA>2024-01-01</FA><RSAPK><M>%s</M><E>%s</E></RSAPK><IDK>100</IDK></DA>`+
+		`<FRMA algoritmo="SHA1withRSA">c2lp</FRMA></CAF><TSTED>2024-03-01T10:00:00</TSTED></DD>`,
+		folio, razonSocial, modulo, exponente)
+
+	doc, err := xmldsig.ParseDocument([]byte(dd))
+	require.NoError(t, err)
+	plano, err := AplanarDD(doc.Root())
+	require.NoError(t, err)
+	hash := sha1.Sum(plano)
+	firma, err := rsa.SignPKCS1v15(rand.Reader, llave, crypto.SHA1, hash[:])
+	require.NoError(t, err)
+
+	// El timbre se indenta como en los documentos reales, lo que no altera la firma
+	dd = strings.Replace(dd, "<RE>", "\n  <RE>", 1)
+	return `<TED version="1.0">` + dd + `<FRMT algoritmo="SHA1withRSA">` +
+		base64.StdEncoding.EncodeToString(firma) + `</FRMT></TED>`
+}
+
+func TestVerificar(t *testing.T) {
+	timbre := timbrePrueba(t, 7, "Deudor &amp; Cía")
+	doc, err := xmldsig.ParseDocument([]byte(`<DTE xmlns="http://www.sii.cl/SiiDte">` + timbre + `</DTE>`))
+	require.NoError(t, err)
+
+	datos, err := Verificar(doc.FindElement("//TED"))
+	require.NoError(t, err)
+	assert.Equal(t, "76212889-6", datos.RutEmisor)
+	assert.Equal(t, 33, datos.TipoDTE)
+	assert.Equal(t, int64(7), datos.Folio)
+	assert.Equal(t, int64(119000), datos.MontoTotal)
+	assert.Equal(t, int64(100), datos.FolioHasta)
+}
+
+func TestVerificarTimbreAlterado(t *testing.T) {
+	timbre := strings.Replace(timbrePrueba(t, 7, "Deudor"), "<MNT>119000<", "<MNT>1190<", 1)
+	doc, err := xmldsig.ParseDocument([]byte(timbre))
+	require.NoError(t, err)
+
+	_, err = Verificar(doc.Root())
+	assert.ErrorIs(t, err, ErrFirmaTED)
+}
+
+func TestVerificarFolioFueraDeRango(t *testing.T) {
+	doc, err := xmldsig.ParseDocument([]byte(timbrePrueba(t, 101, "Deudor")))
+	require.NoError(t, err)
+
+	_, err = Verificar(doc.Root())
+	assert.ErrorContains(t, err, "fuera del rango")
+}
+
+func TestAplanarDD(t *testing.T) {
+	doc, err := xmldsig.ParseDocument([]byte("<DD>\n  <RE>1-9</RE>\n  <RSR>Ñandú &amp; Cía</RSR>\n</DD>"))
+	require.NoError(t, err)
+
+	plano, err := AplanarDD(doc.Root())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("<DD><RE>1-9</RE><RSR>\xd1and\xfa &amp; C\xeda</RSR></DD>"), plano)
+}
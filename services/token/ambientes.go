@@ -0,0 +1,65 @@
+package token
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cursor/FMgo/models"
+)
+
+// Ambientes resuelve el ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error)
+}
+
+// ManagerAmbientes delega en el Manager del ambiente de cada empresa, para que una instancia
+// atienda empresas de certificación y producción con los tokens de su propio ambiente
+type ManagerAmbientes struct {
+	ambientes Ambientes
+	managers  map[models.AmbienteSII]*Manager
+}
+
+// NewManagerAmbientes crea el enrutador con un Manager por ambiente
+func NewManagerAmbientes(ambientes Ambientes, managers map[models.AmbienteSII]*Manager) *ManagerAmbientes {
+	return &ManagerAmbientes{ambientes: ambientes, managers: managers}
+}
+
+// Manager retorna el Manager del ambiente de la empresa
+func (m *ManagerAmbientes) Manager(rutEmpresa string) (*Manager, error) {
+	ambiente, err := m.ambientes.AmbienteEmpresa(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+	manager, ok := m.managers[ambiente]
+	if !ok {
+		return nil, fmt.Errorf("sin administrador de tokens para el ambiente %s", ambiente)
+	}
+	return manager, nil
+}
+
+// ObtenerToken retorna un token vigente del ambiente de la empresa
+func (m *ManagerAmbientes) ObtenerToken(ctx context.Context, rutEmpresa string) (string, error) {
+	manager, err := m.Manager(rutEmpresa)
+	if err != nil {
+		return "", err
+	}
+	return manager.ObtenerToken(ctx, rutEmpresa)
+}
+
+// Invalidar descarta el token de la empresa en su ambiente
+func (m *ManagerAmbientes) Invalidar(ctx context.Context, rutEmpresa string) error {
+	manager, err := m.Manager(rutEmpresa)
+	if err != nil {
+		return err
+	}
+	return manager.Invalidar(ctx, rutEmpresa)
+}
+
+// ConToken ejecuta la operación con el token del ambiente de la empresa
+func (m *ManagerAmbientes) ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error {
+	manager, err := m.Manager(rutEmpresa)
+	if err != nil {
+		return err
+	}
+	return manager.ConToken(ctx, rutEmpresa, operacion)
+}
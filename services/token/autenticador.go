@@ -0,0 +1,170 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/utils/xmldsig"
+)
+
+// ErrTokenRechazado indica que el SII no reconoce el token (expirado o inválido)
+var ErrTokenRechazado = errors.New("token rechazado por el SII")
+
+// Firmador firma documentos XML con el certificado digital de la empresa
+type Firmador interface {
+	FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error)
+}
+
+// Autenticador obtiene tokens del SII mediante los servicios CrSeed y GetTokenFromSeed
+type Autenticador struct {
+	client     *http.Client
+	urlSemilla string
+	urlToken   string
+}
+
+// NewAutenticador crea un autenticador para las URLs de semilla y token del ambiente
+func NewAutenticador(urlSemilla, urlToken string, timeout time.Duration) *Autenticador {
+	return &Autenticador{
+		client:     &http.Client{Timeout: timeout},
+		urlSemilla: urlSemilla,
+		urlToken:   urlToken,
+	}
+}
+
+// ObtenerSemilla solicita una semilla al servicio CrSeed
+func (a *Autenticador) ObtenerSemilla(ctx context.Context) (string, error) {
+	soapRequest := `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+   <soapenv:Body>
+      <getSeed/>
+   </soapenv:Body>
+</soapenv:Envelope>`
+
+	respuesta, err := a.invocar(ctx, a.urlSemilla, soapRequest)
+	if err != nil {
+		return "", fmt.Errorf("error al obtener semilla: %v", err)
+	}
+	return respuesta.valor("SEMILLA")
+}
+
+// SolicitarToken obtiene una semilla, la firma con el certificado de la empresa y la canjea por un token
+func (a *Autenticador) SolicitarToken(ctx context.Context, firmador Firmador) (string, error) {
+	semilla, err := a.ObtenerSemilla(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	semillaFirmada, err := firmador.FirmarDocumento([]byte(fmt.Sprintf("<getToken><item><Semilla>%s</Semilla></item></getToken>", semilla)), "")
+	if err != nil {
+		return "", fmt.Errorf("error al firmar semilla: %v", err)
+	}
+
+	soapRequest := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+   <soapenv:Body>
+      <getToken>
+         <pszXml><![CDATA[<?xml version="1.0" encoding="UTF-8"?>%s]]></pszXml>
+      </getToken>
+   </soapenv:Body>
+</soapenv:Envelope>`, semillaFirmada)
+
+	respuesta, err := a.invocar(ctx, a.urlToken, soapRequest)
+	if err != nil {
+		return "", fmt.Errorf("error al obtener token: %v", err)
+	}
+	return respuesta.valor("TOKEN")
+}
+
+// respuestaSII es el documento SII:RESPUESTA retornado como texto dentro del sobre SOAP
+type respuestaSII struct {
+	Estado string
+	Glosa  string
+	cuerpo map[string]string
+}
+
+// valor retorna el campo indicado del cuerpo cuando el estado es 00
+func (r *respuestaSII) valor(campo string) (string, error) {
+	if r.Estado != "00" {
+		return "", fmt.Errorf("el SII respondió estado %s: %s", r.Estado, r.Glosa)
+	}
+	valor := r.cuerpo[campo]
+	if valor == "" {
+		return "", fmt.Errorf("el SII no retornó %s", campo)
+	}
+	return valor, nil
+}
+
+// invocar envía el sobre SOAP y decodifica el SII:RESPUESTA contenido en el return
+func (a *Autenticador) invocar(ctx context.Context, url, soapRequest string) (*respuestaSII, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(soapRequest))
+	if err != nil {
+		return nil, fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/xml;charset=UTF-8")
+	req.Header.Set("SOAPAction", "")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al enviar request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer respuesta: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d del SII", resp.StatusCode)
+	}
+
+	var sobre struct {
+		Body struct {
+			Respuesta struct {
+				Return string `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.Unmarshal(body, &sobre); err != nil {
+		return nil, fmt.Errorf("error al decodificar sobre SOAP: %v", err)
+	}
+
+	return parsearRespuestaSII(strings.TrimSpace(sobre.Body.Respuesta.Return))
+}
+
+// parsearRespuestaSII interpreta el XML SII:RESPUESTA (RESP_HDR y RESP_BODY)
+func parsearRespuestaSII(data string) (*respuestaSII, error) {
+	if data == "" {
+		return nil, fmt.Errorf("respuesta vacía del SII")
+	}
+	doc, err := xmldsig.ParseDocument([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("error al decodificar respuesta del SII: %v", err)
+	}
+
+	respuesta := &respuestaSII{cuerpo: make(map[string]string)}
+	if hdr := doc.FindElement("//RESP_HDR"); hdr != nil {
+		if estado := hdr.SelectElement("ESTADO"); estado != nil {
+			respuesta.Estado = strings.TrimSpace(estado.Text())
+		}
+		if glosa := hdr.SelectElement("GLOSA"); glosa != nil {
+			respuesta.Glosa = strings.TrimSpace(glosa.Text())
+		}
+	}
+	if body := doc.FindElement("//RESP_BODY"); body != nil {
+		for _, campo := range body.ChildElements() {
+			respuesta.cuerpo[campo.Tag] = strings.TrimSpace(campo.Text())
+		}
+	}
+	if respuesta.Estado == "" {
+		return nil, fmt.Errorf("la respuesta del SII no informa estado")
+	}
+
+	return respuesta, nil
+}
@@ -0,0 +1,89 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// AutenticadorREST obtiene tokens de los servicios REST de boleta electrónica, que usan el
+// mismo canje de semilla firmada que los servicios SOAP pero sin sobre
+type AutenticadorREST struct {
+	client     *http.Client
+	urlSemilla string
+	urlToken   string
+}
+
+// NewAutenticadorREST crea un autenticador para las URLs boleta.electronica.semilla y
+// boleta.electronica.token del ambiente
+func NewAutenticadorREST(urlSemilla, urlToken string, timeout time.Duration) *AutenticadorREST {
+	return &AutenticadorREST{
+		client:     &http.Client{Timeout: timeout},
+		urlSemilla: urlSemilla,
+		urlToken:   urlToken,
+	}
+}
+
+// ObtenerSemilla solicita una semilla al servicio REST
+func (a *AutenticadorREST) ObtenerSemilla(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.urlSemilla, nil)
+	if err != nil {
+		return "", fmt.Errorf("error al crear request: %v", err)
+	}
+
+	respuesta, err := a.invocar(req)
+	if err != nil {
+		return "", fmt.Errorf("error al obtener semilla: %v", err)
+	}
+	return respuesta.valor("SEMILLA")
+}
+
+// SolicitarToken obtiene una semilla, la firma con el certificado de la empresa y la canjea por un token
+func (a *AutenticadorREST) SolicitarToken(ctx context.Context, firmador Firmador) (string, error) {
+	semilla, err := a.ObtenerSemilla(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	semillaFirmada, err := firmador.FirmarDocumento([]byte(fmt.Sprintf("<getToken><item><Semilla>%s</Semilla></item></getToken>", semilla)), "")
+	if err != nil {
+		return "", fmt.Errorf("error al firmar semilla: %v", err)
+	}
+
+	cuerpo := append([]byte(`<?xml version="1.0" encoding="UTF-8"?>`), semillaFirmada...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.urlToken, bytes.NewReader(cuerpo))
+	if err != nil {
+		return "", fmt.Errorf("error al crear request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	respuesta, err := a.invocar(req)
+	if err != nil {
+		return "", fmt.Errorf("error al obtener token: %v", err)
+	}
+	return respuesta.valor("TOKEN")
+}
+
+// invocar envía la petición y decodifica el SII:RESPUESTA del cuerpo
+func (a *AutenticadorREST) invocar(req *http.Request) (*respuestaSII, error) {
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al enviar request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer respuesta: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d del SII", resp.StatusCode)
+	}
+
+	return parsearRespuestaSII(string(bytes.TrimSpace(body)))
+}
@@ -0,0 +1,87 @@
+package token
+
+import (
+	"context"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sobreSII(metodo, respuesta string) string {
+	return `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body>` +
+		`<ns1:` + metodo + `Response xmlns:ns1="http://DefaultNamespace"><` + metodo + `Return>` +
+		html.EscapeString(respuesta) +
+		`</` + metodo + `Return></ns1:` + metodo + `Response></soapenv:Body></soapenv:Envelope>`
+}
+
+type firmadorMarca struct{}
+
+func (firmadorMarca) FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error) {
+	return []byte(strings.Replace(string(xmlData), "</getToken>", "<Signature/></getToken>", 1)), nil
+}
+
+func TestSolicitarToken(t *testing.T) {
+	var peticionToken string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/CrSeed.jws", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sobreSII("getSeed", `<?xml version="1.0" encoding="UTF-8"?><SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_BODY><SEMILLA>012345678901</SEMILLA></SII:RESP_BODY><SII:RESP_HDR><ESTADO>00</ESTADO></SII:RESP_HDR></SII:RESPUESTA>`)))
+	})
+	mux.HandleFunc("/GetTokenFromSeed.jws", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		peticionToken = string(body)
+		w.Write([]byte(sobreSII("getToken", `<?xml version="1.0" encoding="UTF-8"?><SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_BODY><TOKEN>ABCDEFGHIJKLM</TOKEN></SII:RESP_BODY><SII:RESP_HDR><ESTADO>00</ESTADO><GLOSA>Token Creado</GLOSA></SII:RESP_HDR></SII:RESPUESTA>`)))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	autenticador := NewAutenticador(server.URL+"/CrSeed.jws", server.URL+"/GetTokenFromSeed.jws", 5*time.Second)
+	token, err := autenticador.SolicitarToken(context.Background(), firmadorMarca{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "ABCDEFGHIJKLM", token)
+	assert.Contains(t, peticionToken, "<getToken><item><Semilla>012345678901</Semilla></item><Signature/></getToken>")
+}
+
+func TestObtenerSemillaEstadoError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sobreSII("getSeed", `<SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_HDR><ESTADO>-07</ESTADO><GLOSA>Error interno</GLOSA></SII:RESP_HDR></SII:RESPUESTA>`)))
+	}))
+	defer server.Close()
+
+	autenticador := NewAutenticador(server.URL, server.URL, 5*time.Second)
+	_, err := autenticador.ObtenerSemilla(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-07")
+}
+
+func TestAutenticadorRESTSolicitarToken(t *testing.T) {
+	var peticionToken, contentType string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boleta.electronica.semilla", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_BODY><SEMILLA>098765432109</SEMILLA></SII:RESP_BODY><SII:RESP_HDR><ESTADO>00</ESTADO></SII:RESP_HDR></SII:RESPUESTA>`))
+	})
+	mux.HandleFunc("/boleta.electronica.token", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		peticionToken = string(body)
+		contentType = r.Header.Get("Content-Type")
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><SII:RESPUESTA xmlns:SII="http://www.sii.cl/XMLSchema"><SII:RESP_BODY><TOKEN>BOLETA123</TOKEN></SII:RESP_BODY><SII:RESP_HDR><ESTADO>00</ESTADO><GLOSA>Token Creado</GLOSA></SII:RESP_HDR></SII:RESPUESTA>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	autenticador := NewAutenticadorREST(server.URL+"/boleta.electronica.semilla", server.URL+"/boleta.electronica.token", 5*time.Second)
+	token, err := autenticador.SolicitarToken(context.Background(), firmadorMarca{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "BOLETA123", token)
+	assert.Equal(t, "application/xml", contentType)
+	assert.True(t, strings.HasPrefix(peticionToken, `<?xml version="1.0" encoding="UTF-8"?><getToken><item><Semilla>098765432109</Semilla></item><Signature/>`))
+}
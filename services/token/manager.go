@@ -0,0 +1,237 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cursor/FMgo/utils"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DuracionToken es la vigencia asumida para un token del SII, que no informa su expiración
+	DuracionToken = 60 * time.Minute
+	// MargenRenovacion es la anticipación con que se renueva un token antes de expirar
+	MargenRenovacion = 5 * time.Minute
+)
+
+// Solicitante obtiene un token nuevo del SII firmando la semilla con el firmador indicado
+type Solicitante interface {
+	SolicitarToken(ctx context.Context, firmador Firmador) (string, error)
+}
+
+// Credenciales entrega el firmador con el certificado digital de una empresa
+type Credenciales interface {
+	FirmadorEmpresa(ctx context.Context, rutEmpresa string) (Firmador, error)
+}
+
+// CredencialesFunc adapta una función al tipo Credenciales
+type CredencialesFunc func(ctx context.Context, rutEmpresa string) (Firmador, error)
+
+// FirmadorEmpresa implementa Credenciales
+func (f CredencialesFunc) FirmadorEmpresa(ctx context.Context, rutEmpresa string) (Firmador, error) {
+	return f(ctx, rutEmpresa)
+}
+
+// ErrNoEncontrado indica que la clave no existe en el caché
+var ErrNoEncontrado = errors.New("clave no encontrada en caché")
+
+// Cache almacena los tokens compartidos entre instancias del gateway
+type Cache interface {
+	Obtener(ctx context.Context, clave string) (string, error)
+	Guardar(ctx context.Context, clave, valor string, ttl time.Duration) error
+	Eliminar(ctx context.Context, clave string) error
+}
+
+// Token es un token del SII con su expiración
+type Token struct {
+	Valor    string    `json:"valor"`
+	Obtenido time.Time `json:"obtenido"`
+	Expira   time.Time `json:"expira"`
+}
+
+// Manager administra los tokens SII por empresa y ambiente. Los tokens se guardan en caché
+// hasta su expiración y se renuevan una sola vez aunque varias solicitudes los pidan a la vez.
+type Manager struct {
+	cache        Cache
+	solicitante  Solicitante
+	credenciales Credenciales
+	ambiente     string
+	duracion     time.Duration
+	margen       time.Duration
+	grupo        singleflight.Group
+	ahora        func() time.Time
+}
+
+// NewManager crea un administrador de tokens para el ambiente indicado (certificacion o produccion)
+func NewManager(cache Cache, solicitante Solicitante, credenciales Credenciales, ambiente string) *Manager {
+	return &Manager{
+		cache:        cache,
+		solicitante:  solicitante,
+		credenciales: credenciales,
+		ambiente:     ambiente,
+		duracion:     DuracionToken,
+		margen:       MargenRenovacion,
+		ahora:        time.Now,
+	}
+}
+
+// SetDuracion ajusta la vigencia de los tokens y la anticipación de su renovación
+func (m *Manager) SetDuracion(duracion, margen time.Duration) {
+	m.duracion = duracion
+	m.margen = margen
+}
+
+// ObtenerToken retorna un token vigente para la empresa
+func (m *Manager) ObtenerToken(ctx context.Context, rutEmpresa string) (string, error) {
+	token, err := m.Obtener(ctx, rutEmpresa)
+	if err != nil {
+		return "", err
+	}
+	return token.Valor, nil
+}
+
+// Obtener retorna el token vigente de la empresa, renovándolo si está próximo a expirar
+func (m *Manager) Obtener(ctx context.Context, rutEmpresa string) (*Token, error) {
+	clave := m.clave(rutEmpresa)
+
+	actual, err := m.leer(ctx, clave)
+	if err != nil {
+		utils.LogError(err, zap.String("proceso", "token_sii"), zap.String("rut_empresa", rutEmpresa))
+	}
+	if actual != nil && m.ahora().Before(actual.Expira.Add(-m.margen)) {
+		return actual, nil
+	}
+
+	resultado, err, _ := m.grupo.Do(clave, func() (interface{}, error) {
+		// Otra instancia pudo renovarlo mientras esperábamos
+		if vigente, _ := m.leer(ctx, clave); vigente != nil && m.ahora().Before(vigente.Expira.Add(-m.margen)) {
+			return vigente, nil
+		}
+		return m.renovar(ctx, clave, rutEmpresa)
+	})
+	if err != nil {
+		if actual != nil && m.ahora().Before(actual.Expira) {
+			// El token actual aún no expira; se reintentará la renovación en la próxima solicitud
+			utils.LogError(err, zap.String("proceso", "token_sii"), zap.String("rut_empresa", rutEmpresa))
+			return actual, nil
+		}
+		return nil, err
+	}
+
+	return resultado.(*Token), nil
+}
+
+// Invalidar descarta el token de la empresa, por ejemplo cuando el SII lo rechaza
+func (m *Manager) Invalidar(ctx context.Context, rutEmpresa string) error {
+	if err := m.cache.Eliminar(ctx, m.clave(rutEmpresa)); err != nil {
+		return fmt.Errorf("error al invalidar token: %v", err)
+	}
+	return nil
+}
+
+// ConToken ejecuta la operación con un token vigente. Si la operación retorna ErrTokenRechazado
+// el token se invalida y la operación se reintenta una vez con un token nuevo.
+func (m *Manager) ConToken(ctx context.Context, rutEmpresa string, operacion func(token string) error) error {
+	token, err := m.ObtenerToken(ctx, rutEmpresa)
+	if err != nil {
+		return err
+	}
+
+	err = operacion(token)
+	if !errors.Is(err, ErrTokenRechazado) {
+		return err
+	}
+
+	if err := m.Invalidar(ctx, rutEmpresa); err != nil {
+		return err
+	}
+	token, err = m.ObtenerToken(ctx, rutEmpresa)
+	if err != nil {
+		return err
+	}
+	return operacion(token)
+}
+
+// renovar solicita un token nuevo al SII y lo guarda en caché hasta su expiración
+func (m *Manager) renovar(ctx context.Context, clave, rutEmpresa string) (*Token, error) {
+	firmador, err := m.credenciales.FirmadorEmpresa(ctx, rutEmpresa)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener certificado de la empresa %s: %v", rutEmpresa, err)
+	}
+
+	valor, err := m.solicitante.SolicitarToken(ctx, firmador)
+	if err != nil {
+		return nil, err
+	}
+
+	ahora := m.ahora()
+	token := &Token{Valor: valor, Obtenido: ahora, Expira: ahora.Add(m.duracion)}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar token: %v", err)
+	}
+	if err := m.cache.Guardar(ctx, clave, string(data), m.duracion); err != nil {
+		// El token es válido aunque no se haya podido compartir
+		utils.LogError(err, zap.String("proceso", "token_sii"), zap.String("rut_empresa", rutEmpresa))
+	}
+
+	return token, nil
+}
+
+// leer obtiene el token guardado en caché
+func (m *Manager) leer(ctx context.Context, clave string) (*Token, error) {
+	data, err := m.cache.Obtener(ctx, clave)
+	if err != nil {
+		if errors.Is(err, ErrNoEncontrado) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error al leer token desde caché: %v", err)
+	}
+
+	var token Token
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, fmt.Errorf("error al decodificar token desde caché: %v", err)
+	}
+	if !m.ahora().Before(token.Expira) {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// clave construye la clave de caché por ambiente y empresa
+func (m *Manager) clave(rutEmpresa string) string {
+	return fmt.Sprintf("sii:token:%s:%s", m.ambiente, rutEmpresa)
+}
+
+// redisCache implementa Cache sobre Redis
+type redisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache crea un caché de tokens sobre el cliente Redis
+func NewRedisCache(client *redis.Client) Cache {
+	return &redisCache{client: client}
+}
+
+func (c *redisCache) Obtener(ctx context.Context, clave string) (string, error) {
+	valor, err := c.client.Get(ctx, clave).Result()
+	if err == redis.Nil {
+		return "", ErrNoEncontrado
+	}
+	return valor, err
+}
+
+func (c *redisCache) Guardar(ctx context.Context, clave, valor string, ttl time.Duration) error {
+	return c.client.Set(ctx, clave, valor, ttl).Err()
+}
+
+func (c *redisCache) Eliminar(ctx context.Context, clave string) error {
+	return c.client.Del(ctx, clave).Err()
+}
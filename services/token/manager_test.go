@@ -0,0 +1,230 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	utils.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+type cachePrueba struct {
+	mu    sync.Mutex
+	datos map[string]string
+}
+
+func newCachePrueba() *cachePrueba {
+	return &cachePrueba{datos: make(map[string]string)}
+}
+
+func (c *cachePrueba) Obtener(ctx context.Context, clave string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	valor, ok := c.datos[clave]
+	if !ok {
+		return "", ErrNoEncontrado
+	}
+	return valor, nil
+}
+
+func (c *cachePrueba) Guardar(ctx context.Context, clave, valor string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.datos[clave] = valor
+	return nil
+}
+
+func (c *cachePrueba) Eliminar(ctx context.Context, clave string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.datos, clave)
+	return nil
+}
+
+type solicitantePrueba struct {
+	llamadas int32
+	espera   time.Duration
+	err      error
+}
+
+func (s *solicitantePrueba) SolicitarToken(ctx context.Context, firmador Firmador) (string, error) {
+	n := atomic.AddInt32(&s.llamadas, 1)
+	time.Sleep(s.espera)
+	if s.err != nil {
+		return "", s.err
+	}
+	return fmt.Sprintf("TOKEN%d", n), nil
+}
+
+type firmadorNulo struct{}
+
+func (firmadorNulo) FirmarDocumento(xmlData []byte, referenceID string) ([]byte, error) {
+	return xmlData, nil
+}
+
+func credencialesPrueba() Credenciales {
+	return CredencialesFunc(func(ctx context.Context, rutEmpresa string) (Firmador, error) {
+		return firmadorNulo{}, nil
+	})
+}
+
+func TestManagerReutilizaToken(t *testing.T) {
+	solicitante := &solicitantePrueba{}
+	manager := NewManager(newCachePrueba(), solicitante, credencialesPrueba(), "certificacion")
+
+	primero, err := manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	segundo, err := manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+
+	assert.Equal(t, "TOKEN1", primero)
+	assert.Equal(t, primero, segundo)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&solicitante.llamadas))
+}
+
+func TestManagerSeparaEmpresasYAmbientes(t *testing.T) {
+	cache := newCachePrueba()
+	solicitante := &solicitantePrueba{}
+	certificacion := NewManager(cache, solicitante, credencialesPrueba(), "certificacion")
+	produccion := NewManager(cache, solicitante, credencialesPrueba(), "produccion")
+
+	a, err := certificacion.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	b, err := certificacion.ObtenerToken(context.Background(), "77888999-1")
+	require.NoError(t, err)
+	c, err := produccion.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&solicitante.llamadas))
+}
+
+func TestManagerRenovacionConcurrente(t *testing.T) {
+	solicitante := &solicitantePrueba{espera: 50 * time.Millisecond}
+	manager := NewManager(newCachePrueba(), solicitante, credencialesPrueba(), "certificacion")
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = manager.ObtenerToken(context.Background(), "76123456-0")
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&solicitante.llamadas))
+	for _, token := range tokens {
+		assert.Equal(t, "TOKEN1", token)
+	}
+}
+
+func TestManagerRenuevaAntesDeExpirar(t *testing.T) {
+	solicitante := &solicitantePrueba{}
+	manager := NewManager(newCachePrueba(), solicitante, credencialesPrueba(), "certificacion")
+	ahora := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	manager.ahora = func() time.Time { return ahora }
+
+	primero, err := manager.Obtener(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	assert.Equal(t, ahora.Add(DuracionToken), primero.Expira)
+
+	ahora = ahora.Add(DuracionToken - MargenRenovacion - time.Second)
+	token, err := manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	assert.Equal(t, "TOKEN1", token)
+
+	ahora = ahora.Add(2 * time.Second)
+	token, err = manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	assert.Equal(t, "TOKEN2", token)
+}
+
+func TestManagerConservaTokenSiFallaRenovacion(t *testing.T) {
+	solicitante := &solicitantePrueba{}
+	manager := NewManager(newCachePrueba(), solicitante, credencialesPrueba(), "certificacion")
+	ahora := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	manager.ahora = func() time.Time { return ahora }
+
+	_, err := manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+
+	solicitante.err = errors.New("SII no disponible")
+	ahora = ahora.Add(DuracionToken - time.Minute)
+	token, err := manager.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	assert.Equal(t, "TOKEN1", token)
+
+	ahora = ahora.Add(2 * time.Minute)
+	_, err = manager.ObtenerToken(context.Background(), "76123456-0")
+	assert.Error(t, err)
+}
+
+func TestManagerConTokenReintentaSiElSIIRechaza(t *testing.T) {
+	solicitante := &solicitantePrueba{}
+	manager := NewManager(newCachePrueba(), solicitante, credencialesPrueba(), "certificacion")
+
+	var usados []string
+	err := manager.ConToken(context.Background(), "76123456-0", func(token string) error {
+		usados = append(usados, token)
+		if token == "TOKEN1" {
+			return fmt.Errorf("SII rechazó el archivo (STATUS 5): %w", ErrTokenRechazado)
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"TOKEN1", "TOKEN2"}, usados)
+}
+
+// ambientesPrueba asigna el ambiente de cada empresa
+type ambientesPrueba map[string]models.AmbienteSII
+
+func (a ambientesPrueba) AmbienteEmpresa(rutEmpresa string) (models.AmbienteSII, error) {
+	ambiente, ok := a[rutEmpresa]
+	if !ok {
+		return "", errors.New("empresa desconocida")
+	}
+	return ambiente, nil
+}
+
+func TestManagerAmbientes(t *testing.T) {
+	cache := newCachePrueba()
+	certificacion := &solicitantePrueba{}
+	produccion := &solicitantePrueba{}
+	managers := NewManagerAmbientes(ambientesPrueba{
+		"76123456-0": models.AmbienteCertificacion,
+		"77888999-1": models.AmbienteProduccion,
+	}, map[models.AmbienteSII]*Manager{
+		models.AmbienteCertificacion: NewManager(cache, certificacion, credencialesPrueba(), string(models.AmbienteCertificacion)),
+		models.AmbienteProduccion:    NewManager(cache, produccion, credencialesPrueba(), string(models.AmbienteProduccion)),
+	})
+
+	_, err := managers.ObtenerToken(context.Background(), "76123456-0")
+	require.NoError(t, err)
+	err = managers.ConToken(context.Background(), "77888999-1", func(token string) error { return nil })
+	require.NoError(t, err)
+	_, err = managers.ObtenerToken(context.Background(), "11111111-1")
+	assert.Error(t, err)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&certificacion.llamadas))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&produccion.llamadas))
+	_, err = cache.Obtener(context.Background(), "sii:token:produccion:77888999-1")
+	assert.NoError(t, err)
+}
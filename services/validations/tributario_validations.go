@@ -7,6 +7,8 @@ import (
 
 	"github.com/cursor/FMgo/domain"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/facturacompra"
+	"github.com/cursor/FMgo/services/guias"
 )
 
 // TributarioValidation maneja las validaciones de negocio para documentos tributarios
@@ -51,6 +53,11 @@ func (v *TributarioValidation) ValidarDocumento(doc interface{}) error {
 		return v.validarNotaDebito(d)
 	case *models.GuiaDespacho:
 		return v.validarGuiaDespacho(d)
+	case *models.DocumentoTributario:
+		if d.TipoDocumento == models.TipoFacturaCompra {
+			return v.validarFacturaCompra(d)
+		}
+		return errors.New("tipo de documento no soportado")
 	default:
 		return errors.New("tipo de documento no soportado")
 	}
@@ -244,7 +251,7 @@ func (v *TributarioValidation) validarCodigoImpuesto(codigo string) bool {
 	// Mapa de códigos de impuestos adicionales según la normativa SII
 	codigosValidos := map[string]bool{
 		"14": true, // IVA anticipado faenamiento carne
-		"15": true, // IVA anticipado carne
+		"15": true, // IVA retenido total
 		"17": true, // Impuesto a las bebidas analcohólicas
 		"18": true, // Impuesto a las bebidas alcohólicas
 		"19": true, // IVA
@@ -366,6 +373,11 @@ func (v *TributarioValidation) validarNotaDebito(nota *models.NotaDebito) error
 
 // validarGuiaDespacho valida una guía de despacho según las reglas de negocio
 func (v *TributarioValidation) validarGuiaDespacho(guia *models.GuiaDespacho) error {
+	// Validar traslado, despacho y transporte
+	if err := guias.Validar(guia); err != nil {
+		return err
+	}
+
 	// Validar montos
 	if err := v.validarMontos(guia); err != nil {
 		return err
@@ -384,6 +396,24 @@ func (v *TributarioValidation) validarGuiaDespacho(guia *models.GuiaDespacho) er
 	return nil
 }
 
+// validarFacturaCompra valida una factura de compra: datos del vendedor, retención de IVA y
+// total descontado el IVA retenido
+func (v *TributarioValidation) validarFacturaCompra(doc *models.DocumentoTributario) error {
+	if doc.MontoTotal > v.config.MaxMontoTotal {
+		return fmt.Errorf("monto total excede el máximo permitido: %v", v.config.MaxMontoTotal)
+	}
+
+	if len(doc.Detalles) > v.config.MaxItems {
+		return fmt.Errorf("número de ítems excede el máximo permitido: %v", v.config.MaxItems)
+	}
+
+	if math.Abs(doc.TasaIVA/100-v.config.PorcentajeIVA) > 0.0001 {
+		return fmt.Errorf("tasa de IVA inválida: %.2f", doc.TasaIVA)
+	}
+
+	return facturacompra.Validar(doc)
+}
+
 // validarReferenciasNota valida las referencias de una nota de crédito/débito
 func (v *TributarioValidation) validarReferenciasNota(nota interface{}) error {
 	var folioReferencia int
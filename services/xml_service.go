@@ -9,6 +9,8 @@ import (
 
 	"github.com/cursor/FMgo/config"
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/exportacion"
+	"github.com/cursor/FMgo/services/facturacompra"
 	"github.com/cursor/FMgo/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -135,6 +137,13 @@ func tipoDocumentoToInt(tipo models.TipoDTE) int {
 
 // GenerarXMLFactura genera el XML para una factura electrónica
 func (s *XMLService) GenerarXMLFactura(ctx context.Context, documento *models.DocumentoTributario, empresa *models.Empresa) (string, error) {
+	if documento.TipoDocumento.EsExportacion() {
+		return s.generarXMLExportacion(documento)
+	}
+	if documento.TipoDocumento == models.TipoFacturaCompra {
+		return s.generarXMLFacturaCompra(documento)
+	}
+
 	// Estructura para el XML de factura
 	type FacturaXML struct {
 		XMLName    xml.Name `xml:"Documento"`
@@ -190,6 +199,46 @@ func (s *XMLService) GenerarXMLFactura(ctx context.Context, documento *models.Do
 	return xmlString, nil
 }
 
+// generarXMLExportacion genera el XML de una factura, nota de débito o nota de crédito de
+// exportación, con los datos de Aduana y los totales en la moneda de la operación
+func (s *XMLService) generarXMLExportacion(documento *models.DocumentoTributario) (string, error) {
+	if err := exportacion.Calcular(documento); err != nil {
+		return "", fmt.Errorf("error al calcular documento de exportación: %v", err)
+	}
+	dte, err := exportacion.ConstruirDTE(documento)
+	if err != nil {
+		return "", fmt.Errorf("error al generar documento de exportación: %v", err)
+	}
+
+	xmlData, err := xml.MarshalIndent(dte, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error al generar XML: %v", err)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="ISO-8859-1"?>
+%s`, string(xmlData)), nil
+}
+
+// generarXMLFacturaCompra genera el XML de una factura de compra con el IVA retenido al
+// vendedor
+func (s *XMLService) generarXMLFacturaCompra(documento *models.DocumentoTributario) (string, error) {
+	if err := facturacompra.Calcular(documento); err != nil {
+		return "", fmt.Errorf("error al calcular factura de compra: %v", err)
+	}
+	dte, err := facturacompra.ConstruirDTE(documento)
+	if err != nil {
+		return "", fmt.Errorf("error al generar factura de compra: %v", err)
+	}
+
+	xmlData, err := xml.MarshalIndent(dte, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error al generar XML: %v", err)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="ISO-8859-1"?>
+%s`, string(xmlData)), nil
+}
+
 // GuardarXML guarda el XML en la base de datos
 func (s *XMLService) GuardarXML(ctx context.Context, documentoID string, xmlContent string) error {
 	// Actualizar el documento con el XML generado
@@ -251,6 +300,10 @@ func (s *XMLService) ValidarXML(xmlData []byte, tipoDTE int) error {
 		return validator.ValidarXML(xmlData)
 	case TipoDTEGuiaDespacho.ToInt():
 		return validator.ValidarXML(xmlData)
+	case int(models.TipoFacturaExportacion), int(models.TipoNotaDebitoExportacion), int(models.TipoNotaCreditoExportacion):
+		return validator.ValidarXML(xmlData)
+	case int(models.TipoFacturaCompra):
+		return validator.ValidarXML(xmlData)
 	default:
 		return fmt.Errorf("tipo de documento no soportado: %d", tipoDTE)
 	}
@@ -1,19 +1,15 @@
 package services
 
 import (
-	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
-	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 // XMLSigner maneja la firma digital de documentos XML
@@ -62,96 +58,22 @@ func NewXMLSigner(certPath, keyPath string) (*XMLSigner, error) {
 	}, nil
 }
 
-// Firmar firma digitalmente un documento XML
+// Firmar firma digitalmente un documento XML. Los DTE y sobres de envío se firman sobre su
+// Documento y SetDTE; otros documentos sobre el primer elemento con ID o completos.
 func (s *XMLSigner) Firmar(xmlData []byte) ([]byte, error) {
-	// Crear documento XML
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(xmlData); err != nil {
-		return nil, fmt.Errorf("error parseando XML: %w", err)
-	}
-
-	// Crear elemento Signature
-	signature := doc.CreateElement("ds:Signature")
-	signature.CreateAttr("xmlns:ds", "http://www.w3.org/2000/09/xmldsig#")
-
-	// Crear elemento SignedInfo
-	signedInfo := signature.CreateElement("ds:SignedInfo")
-	signedInfo.CreateAttr("xmlns:ds", "http://www.w3.org/2000/09/xmldsig#")
-
-	// Crear elemento CanonicalizationMethod
-	canonicalizationMethod := signedInfo.CreateElement("ds:CanonicalizationMethod")
-	canonicalizationMethod.CreateAttr("Algorithm", "http://www.w3.org/TR/2001/REC-xml-c14n-20010315")
-
-	// Crear elemento SignatureMethod
-	signatureMethod := signedInfo.CreateElement("ds:SignatureMethod")
-	signatureMethod.CreateAttr("Algorithm", "http://www.w3.org/2000/09/xmldsig#rsa-sha1")
-
-	// Crear elemento Reference
-	reference := signedInfo.CreateElement("ds:Reference")
-	reference.CreateAttr("URI", "")
-
-	// Crear elemento Transforms
-	transforms := reference.CreateElement("ds:Transforms")
-	transform := transforms.CreateElement("ds:Transform")
-	transform.CreateAttr("Algorithm", "http://www.w3.org/2000/09/xmldsig#enveloped-signature")
-
-	// Crear elemento DigestMethod
-	digestMethod := reference.CreateElement("ds:DigestMethod")
-	digestMethod.CreateAttr("Algorithm", "http://www.w3.org/2000/09/xmldsig#sha1")
-
-	// Calcular digest
-	digestValue := s.calcularDigest(xmlData)
-	digestValueElement := reference.CreateElement("ds:DigestValue")
-	digestValueElement.SetText(base64.StdEncoding.EncodeToString(digestValue))
-
-	// Calcular firma
-	signatureValue := s.calcularFirma(signedInfo)
-	signatureValueElement := signature.CreateElement("ds:SignatureValue")
-	signatureValueElement.SetText(base64.StdEncoding.EncodeToString(signatureValue))
-
-	// Agregar certificado
-	keyInfo := signature.CreateElement("ds:KeyInfo")
-	x509Data := keyInfo.CreateElement("ds:X509Data")
-	x509Certificate := x509Data.CreateElement("ds:X509Certificate")
-	x509Certificate.SetText(base64.StdEncoding.EncodeToString(s.certificado.Raw))
-
-	// Generar XML final
-	doc.Indent(2)
-	return doc.WriteToBytes()
-}
-
-// calcularDigest calcula el digest SHA-1 del documento
-func (s *XMLSigner) calcularDigest(xmlData []byte) []byte {
-	hash := sha1.New()
-	hash.Write(xmlData)
-	return hash.Sum(nil)
-}
-
-// calcularFirma calcula la firma RSA del documento
-func (s *XMLSigner) calcularFirma(signedInfo *etree.Element) []byte {
-	// Canonicalizar el elemento SignedInfo
-	canonicalized, err := signedInfo.Canonicalize()
+	firmado, err := xmldsig.NewFirmante(s.privateKey, s.certificado).Firmar(xmlData)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("error firmando XML: %w", err)
 	}
-
-	// Calcular hash SHA-1
-	hash := sha1.New()
-	hash.Write(canonicalized)
-	hashed := hash.Sum(nil)
-
-	// Firmar con RSA
-	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA1, hashed)
-	if err != nil {
-		return nil
-	}
-
-	return signature
+	return firmado, nil
 }
 
-// VerificarFirma verifica la firma digital de un documento XML
+// VerificarFirma verifica todas las firmas XMLDSig del documento: el digest de cada
+// referencia y el valor de la firma con la llave incluida en KeyInfo
 func (s *XMLSigner) VerificarFirma(xmlData []byte) error {
-	// TODO: Implementar verificación de firma
+	if _, err := xmldsig.VerificarDocumento(xmlData); err != nil {
+		return fmt.Errorf("error verificando firma: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,11 @@
+-- Ambiente SII de cada empresa y ambiente al que se envió cada documento
+ALTER TABLE empresas
+    ADD COLUMN ambiente VARCHAR(20) NOT NULL DEFAULT 'certificacion'
+    CHECK (ambiente IN ('certificacion', 'produccion'));
+
+ALTER TABLE documentos
+    ADD COLUMN ambiente VARCHAR(20)
+    CHECK (ambiente IN ('certificacion', 'produccion'));
+
+CREATE INDEX idx_empresas_ambiente ON empresas(ambiente);
+CREATE INDEX idx_documentos_ambiente ON documentos(ambiente);
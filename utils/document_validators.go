@@ -404,18 +404,27 @@ func (v *GuiaDespachoValidator) Validate() error {
 		return err
 	}
 
-	if v.guiaDespacho.DireccionDestino == "" {
-		return fmt.Errorf("dirección de destino es requerida")
+	if models.GlosaIndTraslado(v.guiaDespacho.IndicadorTraslado) == "" {
+		return fmt.Errorf("indicador de traslado inválido")
 	}
 
-	if v.guiaDespacho.Transportista == "" {
-		return fmt.Errorf("transportista es requerido")
+	// Los datos del transporte solo se exigen cuando el emisor realiza el despacho
+	if !v.guiaDespacho.DespachoPorEmisor() {
+		return nil
+	}
+
+	if v.guiaDespacho.DireccionDestino == "" {
+		return fmt.Errorf("dirección de destino es requerida")
 	}
 
 	if v.guiaDespacho.Patente == "" {
 		return fmt.Errorf("patente es requerida")
 	}
 
+	if v.guiaDespacho.RutChofer == "" {
+		return fmt.Errorf("RUT del chofer es requerido")
+	}
+
 	return nil
 }
 
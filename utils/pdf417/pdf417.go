@@ -0,0 +1,237 @@
+// Package pdf417 implementa un codificador PDF417 (ISO/IEC 15438) en Go puro, usado para
+// imprimir el timbre electrónico (TED) en las representaciones impresas de los DTE.
+package pdf417
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	// NivelSeguridadSII es el nivel de corrección de errores exigido por el SII para el timbre
+	NivelSeguridadSII = 5
+
+	minColumnas      = 1
+	maxColumnas      = 30
+	minFilas         = 3
+	maxFilas         = 90
+	maxPalabras      = 928
+	modulosPalabra   = 17
+	modulosInicio    = 17
+	modulosFin       = 18
+	modulosIndicador = 2 * modulosPalabra
+
+	patronInicio = 0x1fea8
+	patronFin    = 0x3fa29
+
+	palabraRelleno    = 900
+	latchBytes        = 901
+	latchBytesMultipl = 924
+	modulo            = 929
+)
+
+// Codigo es un símbolo PDF417 ya codificado: una matriz de módulos de filas × Ancho()
+type Codigo struct {
+	Filas          int
+	Columnas       int
+	NivelSeguridad int
+	modulos        [][]bool
+}
+
+// Codificar genera el símbolo PDF417 de los datos, usando compactación de bytes para que el
+// contenido (por ejemplo el TED en ISO-8859-1) se recupere sin cambios. Si columnas es 0 se
+// eligen las columnas de datos que dejan el símbolo más cercano a la proporción 5:2 del timbre.
+func Codificar(datos []byte, columnas, nivelSeguridad int) (*Codigo, error) {
+	if nivelSeguridad < 0 || nivelSeguridad > 8 {
+		return nil, fmt.Errorf("nivel de seguridad PDF417 inválido: %d", nivelSeguridad)
+	}
+	if columnas != 0 && (columnas < minColumnas || columnas > maxColumnas) {
+		return nil, fmt.Errorf("número de columnas PDF417 inválido: %d", columnas)
+	}
+
+	palabras := compactarBytes(datos)
+	correccion := 1 << (nivelSeguridad + 1)
+	total := 1 + len(palabras) + correccion
+	if total > maxPalabras {
+		return nil, fmt.Errorf("los datos (%d bytes) exceden la capacidad de un símbolo PDF417 con nivel %d", len(datos), nivelSeguridad)
+	}
+
+	if columnas == 0 {
+		if columnas = columnasProporcion(total, 2.5); columnas == 0 {
+			return nil, fmt.Errorf("los datos (%d bytes) no caben en un símbolo PDF417 con nivel %d", len(datos), nivelSeguridad)
+		}
+	}
+	filas := (total + columnas - 1) / columnas
+	if filas < minFilas {
+		filas = minFilas
+	}
+	if filas > maxFilas {
+		return nil, fmt.Errorf("los datos requieren %d filas con %d columnas; el máximo es %d", filas, columnas, maxFilas)
+	}
+	// El relleno hasta completar la matriz también cuenta en el límite de palabras del símbolo
+	if filas*columnas > maxPalabras {
+		return nil, fmt.Errorf("con %d columnas el símbolo requiere %d palabras; el máximo es %d", columnas, filas*columnas, maxPalabras)
+	}
+
+	// Descriptor de largo, datos y relleno hasta completar la matriz
+	datosTotales := filas*columnas - correccion
+	secuencia := make([]int, 0, filas*columnas)
+	secuencia = append(secuencia, datosTotales)
+	secuencia = append(secuencia, palabras...)
+	for len(secuencia) < datosTotales {
+		secuencia = append(secuencia, palabraRelleno)
+	}
+	secuencia = append(secuencia, corregirErrores(secuencia, nivelSeguridad)...)
+
+	codigo := &Codigo{Filas: filas, Columnas: columnas, NivelSeguridad: nivelSeguridad}
+	for fila := 0; fila < filas; fila++ {
+		cluster := fila % 3
+		izquierdo, derecho := indicadores(fila, filas, columnas, nivelSeguridad)
+
+		linea := make([]bool, 0, codigo.Ancho())
+		linea = agregarPatron(linea, patronInicio, modulosInicio)
+		linea = agregarPatron(linea, patrones[cluster][izquierdo], modulosPalabra)
+		for _, palabra := range secuencia[fila*columnas : (fila+1)*columnas] {
+			linea = agregarPatron(linea, patrones[cluster][palabra], modulosPalabra)
+		}
+		linea = agregarPatron(linea, patrones[cluster][derecho], modulosPalabra)
+		linea = agregarPatron(linea, patronFin, modulosFin)
+		codigo.modulos = append(codigo.modulos, linea)
+	}
+
+	return codigo, nil
+}
+
+// Ancho retorna el ancho del símbolo en módulos, sin zona de silencio
+func (c *Codigo) Ancho() int {
+	return modulosInicio + modulosIndicador + c.Columnas*modulosPalabra + modulosFin
+}
+
+// Modulo indica si el módulo de la fila y columna indicadas es una barra (negro)
+func (c *Codigo) Modulo(fila, columna int) bool {
+	return c.modulos[fila][columna]
+}
+
+// compactarBytes codifica los datos en modo de compactación de bytes: cada grupo de 6 bytes
+// se convierte en 5 palabras en base 900 y los bytes restantes se codifican uno por palabra
+func compactarBytes(datos []byte) []int {
+	latch := latchBytes
+	if len(datos)%6 == 0 {
+		latch = latchBytesMultipl
+	}
+	palabras := []int{latch}
+
+	base := big.NewInt(900)
+	i := 0
+	for ; i+6 <= len(datos); i += 6 {
+		valor := new(big.Int).SetBytes(datos[i : i+6])
+		grupo := make([]int, 5)
+		for j := 4; j >= 0; j-- {
+			resto := new(big.Int)
+			valor.QuoRem(valor, base, resto)
+			grupo[j] = int(resto.Int64())
+		}
+		palabras = append(palabras, grupo...)
+	}
+	for ; i < len(datos); i++ {
+		palabras = append(palabras, int(datos[i]))
+	}
+
+	return palabras
+}
+
+// columnasProporcion elige las columnas de datos cuyo símbolo (con filas de 3 módulos de alto)
+// tiene la proporción ancho/alto más cercana a la indicada, descartando las que con el relleno
+// exceden el máximo de palabras. Retorna 0 si ninguna cabe.
+func columnasProporcion(palabras int, proporcion float64) int {
+	mejor, diferencia := 0, -1.0
+	for columnas := minColumnas; columnas <= maxColumnas; columnas++ {
+		filas := (palabras + columnas - 1) / columnas
+		if filas > maxFilas {
+			continue
+		}
+		if filas < minFilas {
+			filas = minFilas
+		}
+		if filas*columnas > maxPalabras {
+			continue
+		}
+		ancho := float64(modulosInicio + modulosIndicador + columnas*modulosPalabra + modulosFin)
+		d := ancho/float64(3*filas) - proporcion
+		if d < 0 {
+			d = -d
+		}
+		if diferencia < 0 || d < diferencia {
+			mejor, diferencia = columnas, d
+		}
+	}
+	return mejor
+}
+
+// indicadores calcula las palabras indicadoras de fila izquierda y derecha, que informan el
+// número de filas, columnas y el nivel de seguridad según el cluster de la fila
+func indicadores(fila, filas, columnas, nivel int) (int, int) {
+	base := 30 * (fila / 3)
+	filasInfo := (filas - 1) / 3
+	nivelInfo := nivel*3 + (filas-1)%3
+	columnasInfo := columnas - 1
+
+	switch fila % 3 {
+	case 0:
+		return base + filasInfo, base + columnasInfo
+	case 1:
+		return base + nivelInfo, base + filasInfo
+	default:
+		return base + columnasInfo, base + nivelInfo
+	}
+}
+
+// agregarPatron agrega los módulos del patrón, desde el bit más significativo
+func agregarPatron(linea []bool, patron, largo int) []bool {
+	for bit := largo - 1; bit >= 0; bit-- {
+		linea = append(linea, patron&(1<<bit) != 0)
+	}
+	return linea
+}
+
+// corregirErrores calcula las palabras de corrección Reed-Solomon sobre GF(929) con el
+// polinomio generador de raíces 3^1 ... 3^k
+func corregirErrores(datos []int, nivel int) []int {
+	coeficientes := polinomioGenerador(1 << (nivel + 1))
+	k := len(coeficientes)
+
+	restos := make([]int, k)
+	for _, palabra := range datos {
+		t := (palabra + restos[k-1]) % modulo
+		for j := k - 1; j > 0; j-- {
+			restos[j] = (restos[j-1] + modulo - t*coeficientes[j]%modulo) % modulo
+		}
+		restos[0] = (modulo - t*coeficientes[0]%modulo) % modulo
+	}
+
+	correccion := make([]int, k)
+	for j := range restos {
+		if restos[j] != 0 {
+			restos[j] = modulo - restos[j]
+		}
+		correccion[k-1-j] = restos[j]
+	}
+	return correccion
+}
+
+// polinomioGenerador retorna los coeficientes (de grado 0 a k-1; el de grado k es 1) del
+// polinomio (x - 3)(x - 3^2)...(x - 3^k) módulo 929
+func polinomioGenerador(k int) []int {
+	polinomio := []int{1}
+	raiz := 1
+	for i := 0; i < k; i++ {
+		raiz = raiz * 3 % modulo
+		siguiente := make([]int, len(polinomio)+1)
+		for j, c := range polinomio {
+			siguiente[j+1] = (siguiente[j+1] + c) % modulo
+			siguiente[j] = (siguiente[j] + modulo - c*raiz%modulo) % modulo
+		}
+		polinomio = siguiente
+	}
+	return polinomio[:k]
+}
@@ -0,0 +1,125 @@
+package pdf417
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolinomioGenerador(t *testing.T) {
+	// Coeficientes publicados en ISO/IEC 15438 para los niveles 0 y 1
+	assert.Equal(t, []int{27, 917}, polinomioGenerador(2))
+	assert.Equal(t, []int{522, 568, 723, 809}, polinomioGenerador(4))
+}
+
+func TestCorregirErroresSindromesNulos(t *testing.T) {
+	datos := []int{16, 902, 1, 278, 827, 900, 295, 902, 2, 326, 823, 544, 900, 149, 900, 900}
+	palabras := append(append([]int{}, datos...), corregirErrores(datos, NivelSeguridadSII)...)
+
+	// El polinomio del mensaje completo se anula en cada raíz 3^j del generador
+	raiz := 1
+	for j := 1; j <= 1<<(NivelSeguridadSII+1); j++ {
+		raiz = raiz * 3 % modulo
+		valor := 0
+		for _, palabra := range palabras {
+			valor = (valor*raiz + palabra) % modulo
+		}
+		assert.Zero(t, valor, "síndrome %d", j)
+	}
+}
+
+func TestCompactarBytes(t *testing.T) {
+	datos := []byte("<TED version=\"1.0\">Ñ")
+	palabras := compactarBytes(datos)
+	require.Equal(t, latchBytes, palabras[0])
+
+	// Cada grupo de 5 palabras en base 900 reconstruye 6 bytes
+	var recuperados []byte
+	grupos := len(datos) / 6
+	for g := 0; g < grupos; g++ {
+		valor := new(big.Int)
+		for _, palabra := range palabras[1+g*5 : 1+(g+1)*5] {
+			valor.Mul(valor, big.NewInt(900))
+			valor.Add(valor, big.NewInt(int64(palabra)))
+		}
+		bytes := make([]byte, 6)
+		valor.FillBytes(bytes)
+		recuperados = append(recuperados, bytes...)
+	}
+	for _, palabra := range palabras[1+grupos*5:] {
+		recuperados = append(recuperados, byte(palabra))
+	}
+	assert.Equal(t, datos, recuperados)
+
+	assert.Equal(t, latchBytesMultipl, compactarBytes([]byte("123456"))[0])
+}
+
+func TestPatronesPorCluster(t *testing.T) {
+	for cluster := range patrones {
+		for palabra, patron := range patrones[cluster] {
+			// Cada patrón tiene 4 barras y 4 espacios en 17 módulos, y su cluster es
+			// (b1 - b2 + b3 - b4 + 9) mod 9
+			anchos := anchosPatron(patron)
+			require.Len(t, anchos, 8, "cluster %d palabra %d", cluster, palabra)
+			assert.Equal(t, cluster*3, (anchos[0]-anchos[2]+anchos[4]-anchos[6]+9)%9, "cluster %d palabra %d", cluster, palabra)
+		}
+	}
+}
+
+func TestCodificar(t *testing.T) {
+	datos := make([]byte, 400)
+	for i := range datos {
+		datos[i] = byte(i)
+	}
+
+	codigo, err := Codificar(datos, 0, NivelSeguridadSII)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, codigo.Filas, minFilas)
+	assert.LessOrEqual(t, codigo.Filas, maxFilas)
+	assert.Equal(t, 17*(codigo.Columnas+4)+1, codigo.Ancho())
+
+	for fila := 0; fila < codigo.Filas; fila++ {
+		require.Len(t, codigo.modulos[fila], codigo.Ancho())
+		assert.True(t, codigo.Modulo(fila, 0))
+		assert.True(t, codigo.Modulo(fila, codigo.Ancho()-1))
+	}
+
+	// Proporción cercana a la del timbre (5:2) con filas de 3 módulos de alto
+	proporcion := float64(codigo.Ancho()) / float64(3*codigo.Filas)
+	assert.InDelta(t, 2.5, proporcion, 0.6)
+}
+
+func TestCodificarExcedeCapacidad(t *testing.T) {
+	_, err := Codificar(make([]byte, 1200), 0, NivelSeguridadSII)
+	assert.Error(t, err)
+
+	_, err = Codificar([]byte("x"), 31, NivelSeguridadSII)
+	assert.Error(t, err)
+
+	// 1 + 856 + 64 = 921 palabras caben, pero con 17 columnas el relleno llega a 55 × 17 = 935
+	datos := make([]byte, 1026)
+	require.Len(t, compactarBytes(datos), 856)
+	_, err = Codificar(datos, 17, NivelSeguridadSII)
+	assert.Error(t, err)
+
+	codigo, err := Codificar(datos, 0, NivelSeguridadSII)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, codigo.Filas*codigo.Columnas, maxPalabras)
+}
+
+// anchosPatron retorna los anchos de las barras y espacios alternados del patrón
+func anchosPatron(patron int) []int {
+	var anchos []int
+	anterior, ancho := true, 0
+	for bit := modulosPalabra - 1; bit >= 0; bit-- {
+		actual := patron&(1<<bit) != 0
+		if actual != anterior {
+			anchos = append(anchos, ancho)
+			anterior, ancho = actual, 0
+		}
+		ancho++
+	}
+	return append(anchos, ancho)
+}
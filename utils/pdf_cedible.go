@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Leyendas de la copia cedible según el manual de muestras impresas del SII
+const (
+	LeyendaCedible           = "CEDIBLE"
+	LeyendaCedibleConFactura = "CEDIBLE CON SU FACTURA"
+)
+
+// Dimensiones del recuadro de acuse de recibo de la copia cedible, en milímetros
+const (
+	anchoMinimoAcuse = 80.0
+	altoLineaAcuse   = 6.0
+	altoLeyendaCopia = 7.0
+)
+
+// LeyendaCopiaCedible retorna la leyenda que se imprime en la copia cedible del tipo de
+// documento, o vacío si el documento no tiene copia cedible. Las guías de despacho se ceden
+// junto con la factura que las incluye.
+func LeyendaCopiaCedible(tipo models.TipoDTE) string {
+	switch tipo {
+	case models.TipoFactura, models.TipoFacturaExenta, models.TipoFacturaCompra:
+		return LeyendaCedible
+	case models.TipoGuiaDespacho:
+		return LeyendaCedibleConFactura
+	default:
+		return ""
+	}
+}
+
+// DibujarCopiaCedible completa la página actual como copia cedible: dibuja a la derecha del
+// timbre el recuadro de acuse de recibo (nombre, RUT, fecha, recinto y firma, con la declaración
+// de la Ley 19.983) y bajo él la leyenda del tipo de documento. xmlTED es el XML del DTE (o del
+// TED) ya dibujado al pie, para ubicar el recuadro sin cubrir el timbre.
+func DibujarCopiaCedible(pdf *gofpdf.Fpdf, tipo models.TipoDTE, xmlTED string) error {
+	leyenda := LeyendaCopiaCedible(tipo)
+	if leyenda == "" {
+		return fmt.Errorf("el tipo de documento %d no tiene copia cedible", tipo)
+	}
+
+	ted, err := ContenidoTED(xmlTED)
+	if err != nil {
+		return err
+	}
+	anchoTimbre, err := AnchoTimbre(ted)
+	if err != nil {
+		return err
+	}
+
+	ancho, altoPagina := pdf.GetPageSize()
+	_, _, derecho, margenInferior := pdf.GetMargins()
+	x := MargenTimbre + anchoTimbre + 5
+	anchoAcuse := ancho - derecho - x
+	if anchoAcuse < anchoMinimoAcuse {
+		return fmt.Errorf("el timbre no deja espacio para el acuse de recibo en el pie de página")
+	}
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	campos := [][2]string{{"Nombre", ""}, {"R.U.T.", "Fecha"}, {"Recinto", ""}, {"Firma", ""}}
+	altoDeclaracion := 3 * 2.8
+	altoAcuse := 5 + float64(len(campos))*altoLineaAcuse + altoDeclaracion + 2
+	y := altoPagina - margenInferior - altoLeyendaCopia - altoAcuse - 1
+
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.Rect(x, y, anchoAcuse, altoAcuse, "D")
+	pdf.SetXY(x, y+1)
+	pdf.SetFont("Arial", "B", 8)
+	pdf.CellFormat(anchoAcuse, 4, tr("ACUSE DE RECIBO"), "", 2, "C", false, 0, "")
+
+	pdf.SetFont("Arial", "", 8)
+	for i, campo := range campos {
+		// Línea para escribir a continuación de cada etiqueta
+		linea := y + 5 + float64(i+1)*altoLineaAcuse - 1
+		pdf.SetX(x + 2)
+		if campo[1] == "" {
+			pdf.CellFormat(anchoAcuse-4, altoLineaAcuse, tr(campo[0]+":"), "", 2, "L", false, 0, "")
+			pdf.Line(x+18, linea, x+anchoAcuse-3, linea)
+		} else {
+			mitad := (anchoAcuse - 4) / 2
+			pdf.CellFormat(mitad, altoLineaAcuse, tr(campo[0]+":"), "", 0, "L", false, 0, "")
+			pdf.CellFormat(mitad, altoLineaAcuse, tr(campo[1]+":"), "", 2, "L", false, 0, "")
+			pdf.Line(x+18, linea, x+2+mitad-3, linea)
+			pdf.Line(x+2+mitad+12, linea, x+anchoAcuse-3, linea)
+		}
+	}
+
+	pdf.SetX(x + 2)
+	pdf.SetFont("Arial", "", 6)
+	pdf.MultiCell(anchoAcuse-4, 2.8, tr(models.DeclaracionRecibo), "", "J", false)
+
+	pdf.SetXY(x, altoPagina-margenInferior-altoLeyendaCopia)
+	pdf.SetFont("Arial", "B", 14)
+	pdf.CellFormat(anchoAcuse, altoLeyendaCopia, tr(leyenda), "", 0, "R", false, 0, "")
+	return nil
+}
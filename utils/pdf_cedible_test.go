@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeyendaCopiaCedible(t *testing.T) {
+	casos := map[models.TipoDTE]string{
+		models.TipoFactura:            LeyendaCedible,
+		models.TipoFacturaExenta:      LeyendaCedible,
+		models.TipoFacturaCompra:      LeyendaCedible,
+		models.TipoGuiaDespacho:       LeyendaCedibleConFactura,
+		models.TipoNotaCredito:        "",
+		models.TipoNotaDebito:         "",
+		models.TipoFacturaExportacion: "",
+	}
+	for tipo, esperada := range casos {
+		assert.Equal(t, esperada, LeyendaCopiaCedible(tipo), "tipo %d", tipo)
+	}
+}
+
+func TestDibujarDTECopiaCedible(t *testing.T) {
+	neto, iva, tasa := int64(100000), int64(19000), 19.0
+	cantidad, precio := 2.5, 40000.0
+	dte := &models.DTEXMLModel{
+		Documento: models.DocumentoXMLModel{
+			Encabezado: models.EncabezadoXMLModel{
+				IdDoc:    models.IDDocumentoXML{TipoDTE: "33", Folio: 12, FechaEmision: "2024-06-03"},
+				Emisor:   models.EmisorXML{RUT: "76212889-6", RazonSocial: "Comercial Los Andes SpA", Giro: "Venta al por mayor"},
+				Receptor: models.ReceptorXML{RUT: "77777777-7", RazonSocial: "Distribuidora Sur Ltda"},
+				Totales:  models.TotalesXML{MntNeto: &neto, TasaIVA: &tasa, IVA: &iva, MntTotal: 119000},
+			},
+			Detalle: []models.DetalleXML{{NroLinDet: 1, Nombre: "Pañuelo", Cantidad: &cantidad, Precio: &precio, MontoItem: 100000}},
+		},
+	}
+	xmlDTE := strings.Replace(dtePruebaTimbre, "c2lp", strings.Repeat("QUJD", 43), 1)
+	resolucion := ResolucionSII{Numero: 80, Fecha: time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC), Unidad: "Santiago Oriente"}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	require.NoError(t, DibujarDTE(pdf, dte, xmlDTE, resolucion))
+	require.NoError(t, DibujarCopiaCedible(pdf, models.TipoFactura, xmlDTE))
+	assert.Equal(t, 1, pdf.PageCount())
+
+	var buf bytes.Buffer
+	require.NoError(t, pdf.Output(&buf))
+	assert.NotZero(t, buf.Len())
+
+	assert.ErrorContains(t, DibujarCopiaCedible(pdf, models.TipoNotaCredito, xmlDTE), "no tiene copia cedible")
+
+	dte.Documento.Encabezado.IdDoc.TipoDTE = "39"
+	assert.ErrorContains(t, DibujarDTE(pdf, dte, xmlDTE, resolucion), "no tiene representación impresa")
+}
+
+func TestFormatearMonto(t *testing.T) {
+	assert.Equal(t, "$ 0", formatearMonto(0))
+	assert.Equal(t, "$ 999", formatearMonto(999))
+	assert.Equal(t, "$ 1.234.567", formatearMonto(1234567))
+	assert.Equal(t, "$ -18.104", formatearMonto(-18104))
+	assert.Equal(t, "2,5", formatearCantidad(2.5))
+}
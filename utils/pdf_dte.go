@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// AltoPie es el espacio, en milímetros, que se reserva al pie de la última página para el
+// timbre electrónico y, en las copias cedibles, el acuse de recibo
+const AltoPie = 55.0
+
+// DibujarDTE dibuja en la página actual la representación impresa de un documento nacional
+// (facturas, factura de compra, guía de despacho y notas) según el manual de muestras impresas
+// del SII: recuadro con RUT, tipo y folio, emisor, receptor, datos del traslado en las guías,
+// detalle con sus descuentos, descuentos y recargos globales, referencias, totales y el timbre
+// electrónico al pie. xmlDTE es el XML del DTE timbrado, del que se toma el TED.
+func DibujarDTE(pdf *gofpdf.Fpdf, dte *models.DTEXMLModel, xmlDTE string, resolucion ResolucionSII) error {
+	enc := dte.Documento.Encabezado
+	tipo, err := strconv.Atoi(enc.IdDoc.TipoDTE)
+	if err != nil {
+		return fmt.Errorf("tipo de documento inválido: %q", enc.IdDoc.TipoDTE)
+	}
+	titulo := tituloDTE(models.TipoDTE(tipo))
+	if titulo == "" {
+		return fmt.Errorf("el tipo de documento %d no tiene representación impresa", tipo)
+	}
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	ancho, _ := pdf.GetPageSize()
+	izquierdo, _, derecho, _ := pdf.GetMargins()
+	util := ancho - izquierdo - derecho
+
+	// El detalle continúa en páginas nuevas antes de invadir el espacio del timbre
+	auto, margenInferior := pdf.GetAutoPageBreak()
+	pdf.SetAutoPageBreak(true, margenInferior+AltoPie)
+
+	dibujarRecuadroFolio(pdf, enc.Emisor.RUT, titulo, enc.IdDoc.Folio, resolucion.Unidad)
+
+	// Emisor
+	pdf.SetXY(izquierdo, 10)
+	pdf.SetFont("Arial", "B", 11)
+	pdf.MultiCell(util-80, 5, tr(enc.Emisor.RazonSocial), "", "L", false)
+	pdf.SetFont("Arial", "", 9)
+	pdf.MultiCell(util-80, 4, tr(enc.Emisor.Giro), "", "L", false)
+	pdf.CellFormat(util-80, 4, tr(unirNoVacios(", ", enc.Emisor.Direccion, enc.Emisor.Comuna, enc.Emisor.Ciudad)), "", 2, "L", false, 0, "")
+	if pdf.GetY() < 38 {
+		pdf.SetY(38)
+	}
+
+	// Receptor
+	seccionDocumento(pdf, tr, "Receptor")
+	campoDocumento(pdf, tr, "Señor(es)", enc.Receptor.RazonSocial)
+	campoDocumento(pdf, tr, "R.U.T.", enc.Receptor.RUT)
+	if enc.Receptor.Giro != "" {
+		campoDocumento(pdf, tr, "Giro", enc.Receptor.Giro)
+	}
+	campoDocumento(pdf, tr, "Dirección", unirNoVacios(", ", enc.Receptor.Direccion, enc.Receptor.Comuna, enc.Receptor.Ciudad))
+	campoDocumento(pdf, tr, "Fecha emisión", formatearFecha(enc.IdDoc.FechaEmision))
+
+	// Traslado de las guías de despacho
+	if models.TipoDTE(tipo) == models.TipoGuiaDespacho {
+		seccionDocumento(pdf, tr, "Traslado")
+		campoDocumento(pdf, tr, "Tipo de traslado", models.GlosaIndTraslado(enc.IdDoc.IndTraslado))
+		if enc.IdDoc.TipoDespacho != "" {
+			campoDocumento(pdf, tr, "Tipo de despacho", models.GlosaTipoDespacho(enc.IdDoc.TipoDespacho))
+		}
+		if transporte := enc.Transporte; transporte != nil {
+			if transporte.Patente != "" {
+				campoDocumento(pdf, tr, "Patente", transporte.Patente)
+			}
+			if transporte.RUTTrans != "" {
+				campoDocumento(pdf, tr, "RUT transportista", transporte.RUTTrans)
+			}
+			if transporte.Chofer != nil {
+				campoDocumento(pdf, tr, "Chofer", transporte.Chofer.NombreChofer+" (RUT "+transporte.Chofer.RUTChofer+")")
+			}
+			if transporte.DirDest != "" {
+				campoDocumento(pdf, tr, "Destino", unirNoVacios(", ", transporte.DirDest, transporte.CmnaDest, transporte.CiudadDest))
+			}
+		}
+	}
+
+	// Detalle
+	pdf.Ln(3)
+	pdf.SetFont("Arial", "B", 8)
+	pdf.SetFillColor(230, 230, 230)
+	columnas := []float64{util - 115, 18, 14, 25, 14, 20, 24}
+	for i, encabezado := range []string{"Descripción", "Cantidad", "Unidad", "Precio unitario", "% Desc.", "Descuento", "Valor"} {
+		pdf.CellFormat(columnas[i], 6, tr(encabezado), "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Arial", "", 8)
+	for _, detalle := range dte.Documento.Detalle {
+		nombre := detalle.Nombre
+		if detalle.IndExe == 1 {
+			nombre += " (exento)"
+		}
+		var cantidad, precio, porcentaje, descuento string
+		if detalle.Cantidad != nil {
+			cantidad = formatearCantidad(*detalle.Cantidad)
+		}
+		if detalle.Precio != nil {
+			precio = formatearCantidad(*detalle.Precio)
+		}
+		if detalle.PorcentajeDesc > 0 {
+			porcentaje = formatearCantidad(detalle.PorcentajeDesc) + "%"
+		}
+		if detalle.Descuento > 0 {
+			descuento = formatearMonto(int64(detalle.Descuento))
+		}
+		pdf.CellFormat(columnas[0], 5, tr(nombre), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(columnas[1], 5, cantidad, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[2], 5, tr(detalle.UnidadMedida), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(columnas[3], 5, precio, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[4], 5, porcentaje, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[5], 5, descuento, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[6], 5, formatearMonto(detalle.MontoItem), "1", 1, "R", false, 0, "")
+		if detalle.Descripcion != nil && *detalle.Descripcion != "" {
+			pdf.SetFont("Arial", "I", 7)
+			pdf.MultiCell(columnas[0], 4, tr(*detalle.Descripcion), "LR", "L", false)
+			pdf.SetFont("Arial", "", 8)
+		}
+	}
+
+	// Descuentos y recargos globales
+	if len(dte.Documento.DscRcgGlobal) > 0 {
+		seccionDocumento(pdf, tr, "Descuentos y recargos globales")
+		for _, dr := range dte.Documento.DscRcgGlobal {
+			movimiento := "Descuento"
+			if dr.TpoMov == "R" {
+				movimiento = "Recargo"
+			}
+			valor := formatearMonto(int64(dr.ValorDR))
+			if dr.TpoValor == "%" {
+				valor = formatearCantidad(dr.ValorDR) + "%"
+			}
+			campoDocumento(pdf, tr, movimiento, strings.TrimSpace(valor+"  "+dr.GlosaDR))
+		}
+	}
+
+	// Referencias
+	if len(dte.Documento.Referencias) > 0 {
+		seccionDocumento(pdf, tr, "Referencias")
+		for _, ref := range dte.Documento.Referencias {
+			documento := ref.TipoDocRef
+			if n, err := strconv.Atoi(ref.TipoDocRef); err == nil {
+				if nombre := tituloDTE(models.TipoDTE(n)); nombre != "" {
+					documento = nombre
+				}
+			}
+			detalle := fmt.Sprintf("N° %s del %s", ref.FolioRef, formatearFecha(ref.FechaRef))
+			if glosa := glosaCodigoReferencia(ref.CodigoRef); glosa != "" {
+				detalle += "  " + glosa
+			}
+			campoDocumento(pdf, tr, documento, strings.TrimSpace(detalle+"  "+ref.RazonRef))
+		}
+	}
+
+	// Totales
+	pdf.Ln(3)
+	totales := enc.Totales
+	var filas [][2]string
+	if totales.MntNeto != nil {
+		filas = append(filas, [2]string{"Monto neto", formatearMonto(*totales.MntNeto)})
+	}
+	if totales.MontoExento > 0 {
+		filas = append(filas, [2]string{"Monto exento", formatearMonto(int64(totales.MontoExento))})
+	}
+	if totales.IVA != nil {
+		etiqueta := "IVA"
+		if totales.TasaIVA != nil {
+			etiqueta = fmt.Sprintf("IVA %s%%", formatearCantidad(*totales.TasaIVA))
+		}
+		filas = append(filas, [2]string{etiqueta, formatearMonto(*totales.IVA)})
+	}
+	for _, imp := range totales.ImptoReten {
+		etiqueta := models.GlosaRetencionIVA(imp.Tipo)
+		if etiqueta == "" {
+			etiqueta = "Impuesto código " + imp.Tipo
+		}
+		filas = append(filas, [2]string{fmt.Sprintf("%s %s%%", etiqueta, formatearCantidad(imp.Tasa)), formatearMonto(int64(imp.Monto))})
+	}
+	if totales.IVANoRet != nil {
+		filas = append(filas, [2]string{"IVA no retenido", formatearMonto(*totales.IVANoRet)})
+	}
+	filas = append(filas, [2]string{"Total", formatearMonto(totales.MntTotal)})
+	for i, fila := range filas {
+		pdf.SetX(ancho - derecho - 90)
+		pdf.SetFont("Arial", "B", 9)
+		pdf.CellFormat(55, 6, tr(fila[0]), "1", 0, "L", i == len(filas)-1, 0, "")
+		pdf.SetFont("Arial", "", 9)
+		pdf.CellFormat(35, 6, fila[1], "1", 1, "R", i == len(filas)-1, 0, "")
+	}
+
+	pdf.SetAutoPageBreak(auto, margenInferior)
+	return DibujarTimbreAlPie(pdf, xmlDTE, resolucion)
+}
+
+// dibujarRecuadroFolio dibuja en rojo, en la esquina superior derecha, el recuadro con el RUT del
+// emisor, el nombre del documento y su folio, y bajo él la unidad del SII del emisor
+func dibujarRecuadroFolio(pdf *gofpdf.Fpdf, rutEmisor, titulo string, folio int, unidad string) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	ancho, _ := pdf.GetPageSize()
+	_, _, derecho, _ := pdf.GetMargins()
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetTextColor(200, 0, 0)
+	pdf.SetDrawColor(200, 0, 0)
+	pdf.SetXY(ancho-derecho-75, 10)
+	pdf.CellFormat(75, 7, tr("R.U.T.: "+rutEmisor), "LTR", 2, "C", false, 0, "")
+	// Los nombres largos se achican para que quepan en el recuadro
+	for tamano := 12.0; tamano > 7 && pdf.GetStringWidth(tr(titulo)) > 73; tamano-- {
+		pdf.SetFontSize(tamano - 1)
+	}
+	pdf.CellFormat(75, 7, tr(titulo), "LR", 2, "C", false, 0, "")
+	pdf.SetFontSize(12)
+	pdf.CellFormat(75, 7, tr(fmt.Sprintf("N° %d", folio)), "LBR", 2, "C", false, 0, "")
+	if unidad != "" {
+		pdf.SetFont("Arial", "B", 10)
+		pdf.CellFormat(75, 6, tr("S.I.I. - "+strings.ToUpper(unidad)), "", 2, "C", false, 0, "")
+	}
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetDrawColor(0, 0, 0)
+}
+
+// tituloDTE retorna el nombre impreso del tipo de documento, o vacío si no tiene
+// representación impresa en este formato
+func tituloDTE(tipo models.TipoDTE) string {
+	switch tipo {
+	case models.TipoFactura:
+		return "FACTURA ELECTRÓNICA"
+	case models.TipoFacturaExenta:
+		return "FACTURA NO AFECTA O EXENTA ELECTRÓNICA"
+	case models.TipoFacturaCompra:
+		return "FACTURA DE COMPRA ELECTRÓNICA"
+	case models.TipoGuiaDespacho:
+		return "GUÍA DE DESPACHO ELECTRÓNICA"
+	case models.TipoNotaDebito:
+		return "NOTA DE DÉBITO ELECTRÓNICA"
+	case models.TipoNotaCredito:
+		return "NOTA DE CRÉDITO ELECTRÓNICA"
+	case models.TipoFacturaExportacion, models.TipoNotaDebitoExportacion, models.TipoNotaCreditoExportacion:
+		return tituloExportacion(tipo)
+	default:
+		return ""
+	}
+}
+
+// glosaCodigoReferencia retorna la descripción del código de referencia (CodRef)
+func glosaCodigoReferencia(codigo string) string {
+	switch codigo {
+	case "1":
+		return "Anula documento de referencia"
+	case "2":
+		return "Corrige texto del documento de referencia"
+	case "3":
+		return "Corrige montos"
+	default:
+		return ""
+	}
+}
+
+// formatearMonto muestra un monto en pesos con separador de miles
+func formatearMonto(monto int64) string {
+	signo := ""
+	if monto < 0 {
+		signo = "-"
+		monto = -monto
+	}
+	digitos := strconv.FormatInt(monto, 10)
+	var partes []string
+	for len(digitos) > 3 {
+		partes = append([]string{digitos[len(digitos)-3:]}, partes...)
+		digitos = digitos[:len(digitos)-3]
+	}
+	partes = append([]string{digitos}, partes...)
+	return "$ " + signo + strings.Join(partes, ".")
+}
+
+// formatearCantidad muestra una cantidad, precio o porcentaje sin decimales innecesarios y
+// con coma decimal
+func formatearCantidad(valor float64) string {
+	return strings.Replace(strconv.FormatFloat(valor, 'f', -1, 64), ".", ",", 1)
+}
+
+// formatearFecha convierte una fecha AAAA-MM-DD del XML al formato DD/MM/AAAA
+func formatearFecha(fecha string) string {
+	if t, err := time.Parse("2006-01-02", fecha); err == nil {
+		return t.Format("02/01/2006")
+	}
+	return fecha
+}
+
+// unirNoVacios une los textos no vacíos con el separador indicado
+func unirNoVacios(separador string, textos ...string) string {
+	var partes []string
+	for _, texto := range textos {
+		if texto = strings.TrimSpace(texto); texto != "" {
+			partes = append(partes, texto)
+		}
+	}
+	return strings.Join(partes, separador)
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Glosas de las tablas de Aduana que se imprimen en los documentos de exportación
+var (
+	glosasModalidadVenta = map[int]string{
+		1: "A firme",
+		2: "Bajo condición",
+		3: "En consignación libre",
+		4: "En consignación con un mínimo a firme",
+		9: "Sin pago",
+	}
+	glosasClausulaVenta = map[int]string{
+		1: "CIF", 2: "CFR", 3: "EXW", 4: "FAS", 5: "FOB", 6: "S/CL", 9: "OTROS",
+		10: "DDP", 11: "FCA", 12: "CPT", 13: "CIP", 17: "DAT", 18: "DAP",
+	}
+	glosasViaTransporte = map[int]string{
+		1:  "Marítima, fluvial y lacustre",
+		4:  "Aérea",
+		5:  "Postal",
+		6:  "Ferroviaria",
+		7:  "Carretera / terrestre",
+		8:  "Oleoductos, gasoductos",
+		9:  "Tendido eléctrico",
+		10: "Otra",
+	}
+)
+
+// DibujarDocumentoExportacion dibuja en la página actual la representación impresa de una
+// factura, nota de débito o nota de crédito de exportación: receptor extranjero, datos de
+// Aduana, detalle y totales en la moneda de la operación y el timbre electrónico al pie
+func DibujarDocumentoExportacion(pdf *gofpdf.Fpdf, doc *models.DocumentoTributario, resolucion ResolucionSII) error {
+	if !doc.TipoDocumento.EsExportacion() || doc.Exportacion == nil {
+		return fmt.Errorf("el documento no es de exportación")
+	}
+	exp := doc.Exportacion
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	ancho, _ := pdf.GetPageSize()
+	izquierdo, _, derecho, _ := pdf.GetMargins()
+	util := ancho - izquierdo - derecho
+
+	// Recuadro con el tipo y folio del documento
+	dibujarRecuadroFolio(pdf, doc.RUTEmisor, tituloExportacion(doc.TipoDocumento), doc.Folio, resolucion.Unidad)
+
+	// Emisor
+	pdf.SetXY(izquierdo, 10)
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(util-80, 6, tr(doc.RazonSocialEmisor), "", 2, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 9)
+	pdf.CellFormat(util-80, 5, tr(doc.GiroEmisor), "", 2, "L", false, 0, "")
+	pdf.CellFormat(util-80, 5, tr(strings.TrimSpace(doc.DireccionEmisor+", "+doc.ComunaEmisor)), "", 2, "L", false, 0, "")
+	pdf.SetY(35)
+
+	// Receptor extranjero
+	seccionDocumento(pdf, tr, "Receptor")
+	campoDocumento(pdf, tr, "Señor(es)", doc.RazonSocialReceptor)
+	campoDocumento(pdf, tr, "Identificación", strings.TrimSpace(exp.Extranjero.NumID+" (RUT "+models.RutReceptorExtranjero+")"))
+	campoDocumento(pdf, tr, "Nacionalidad", exp.Extranjero.Nacionalidad)
+	campoDocumento(pdf, tr, "Dirección", doc.DireccionReceptor)
+	campoDocumento(pdf, tr, "Fecha emisión", doc.FechaEmision.Format("02/01/2006"))
+
+	// Datos de Aduana
+	aduana := exp.Aduana
+	seccionDocumento(pdf, tr, "Aduana")
+	if aduana.CodModVenta != 0 {
+		campoDocumento(pdf, tr, "Modalidad de venta", glosa(glosasModalidadVenta, aduana.CodModVenta))
+	}
+	if aduana.CodClauVenta != 0 {
+		campoDocumento(pdf, tr, "Cláusula de venta", fmt.Sprintf("%s  %s %s", glosa(glosasClausulaVenta, aduana.CodClauVenta), exp.TpoMoneda, formatearMontoExportacion(aduana.TotClauVenta)))
+	}
+	if aduana.CodViaTransp != 0 {
+		campoDocumento(pdf, tr, "Vía de transporte", glosa(glosasViaTransporte, aduana.CodViaTransp))
+	}
+	if aduana.CodPtoEmbarque != 0 || aduana.CodPtoDesemb != 0 {
+		campoDocumento(pdf, tr, "Puertos", fmt.Sprintf("Embarque %d / Desembarque %d", aduana.CodPtoEmbarque, aduana.CodPtoDesemb))
+	}
+	campoDocumento(pdf, tr, "País receptor / destino", fmt.Sprintf("%d / %d", aduana.CodPaisRecep, aduana.CodPaisDestin))
+	if aduana.TotBultos > 0 {
+		bultos := make([]string, 0, len(aduana.TipoBultos))
+		for _, tipo := range aduana.TipoBultos {
+			bultos = append(bultos, fmt.Sprintf("%d x tipo %d", tipo.CantBultos, tipo.CodTpoBultos))
+		}
+		campoDocumento(pdf, tr, "Bultos", strings.TrimSpace(fmt.Sprintf("%d  %s", aduana.TotBultos, strings.Join(bultos, ", "))))
+	}
+	if aduana.MntFlete > 0 || aduana.MntSeguro > 0 {
+		campoDocumento(pdf, tr, "Flete / Seguro", fmt.Sprintf("%s / %s", formatearMontoExportacion(aduana.MntFlete), formatearMontoExportacion(aduana.MntSeguro)))
+	}
+
+	// Detalle en la moneda de la operación
+	pdf.Ln(3)
+	pdf.SetFont("Arial", "B", 9)
+	pdf.SetFillColor(230, 230, 230)
+	columnas := []float64{util - 100, 20, 20, 30, 30}
+	for i, titulo := range []string{"Descripción", "Cantidad", "Unidad", "Precio " + exp.TpoMoneda, "Total " + exp.TpoMoneda} {
+		pdf.CellFormat(columnas[i], 6, tr(titulo), "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Arial", "", 9)
+	for _, detalle := range doc.Detalles {
+		pdf.CellFormat(columnas[0], 6, tr(detalle.Descripcion), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(columnas[1], 6, strconv.Itoa(detalle.Cantidad), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[2], 6, tr(detalle.UnidadMedida), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(columnas[3], 6, formatearMontoExportacion(detalle.PrecioUnitario), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(columnas[4], 6, formatearMontoExportacion(detalle.MontoItem), "1", 1, "R", false, 0, "")
+	}
+
+	// Referencias de las notas de débito y crédito
+	if len(doc.Referencias) > 0 {
+		seccionDocumento(pdf, tr, "Referencias")
+		for _, ref := range doc.Referencias {
+			campoDocumento(pdf, tr, "Documento "+ref.TipoDocumento, fmt.Sprintf("N° %d del %s  %s", ref.Folio, ref.FechaReferencia.Format("02/01/2006"), ref.RazonReferencia))
+		}
+	}
+
+	// Totales: exento en la moneda de la operación y, si hay tipo de cambio, en pesos
+	pdf.Ln(3)
+	totales := [][2]string{
+		{"Monto exento " + exp.TpoMoneda, formatearMontoExportacion(doc.MontoExento)},
+		{"Total " + exp.TpoMoneda, formatearMontoExportacion(doc.MontoTotal)},
+	}
+	if exp.TpoCambio > 0 {
+		totales = append(totales,
+			[2]string{"Tipo de cambio", formatearMontoExportacion(exp.TpoCambio)},
+			[2]string{"Total " + models.MonedaPesoChileno, fmt.Sprintf("%.0f", exp.MontoTotalPesos)},
+		)
+	}
+	for _, total := range totales {
+		pdf.SetX(ancho - derecho - 90)
+		pdf.SetFont("Arial", "B", 9)
+		pdf.CellFormat(55, 6, tr(total[0]), "1", 0, "L", false, 0, "")
+		pdf.SetFont("Arial", "", 9)
+		pdf.CellFormat(35, 6, total[1], "1", 1, "R", false, 0, "")
+	}
+
+	return DibujarTimbreAlPie(pdf, doc.XML, resolucion)
+}
+
+// tituloExportacion retorna el nombre impreso del documento de exportación
+func tituloExportacion(tipo models.TipoDTE) string {
+	switch tipo {
+	case models.TipoNotaDebitoExportacion:
+		return "NOTA DE DÉBITO DE EXPORTACIÓN ELECTRÓNICA"
+	case models.TipoNotaCreditoExportacion:
+		return "NOTA DE CRÉDITO DE EXPORTACIÓN ELECTRÓNICA"
+	default:
+		return "FACTURA DE EXPORTACIÓN ELECTRÓNICA"
+	}
+}
+
+// seccionDocumento dibuja el título de una sección del documento
+func seccionDocumento(pdf *gofpdf.Fpdf, tr func(string) string, titulo string) {
+	pdf.Ln(2)
+	pdf.SetFont("Arial", "B", 10)
+	pdf.CellFormat(0, 6, tr(titulo), "B", 1, "L", false, 0, "")
+}
+
+// campoDocumento dibuja una línea etiqueta: valor
+func campoDocumento(pdf *gofpdf.Fpdf, tr func(string) string, etiqueta, valor string) {
+	pdf.SetFont("Arial", "B", 9)
+	pdf.CellFormat(45, 5, tr(etiqueta+":"), "", 0, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 9)
+	pdf.CellFormat(0, 5, tr(valor), "", 1, "L", false, 0, "")
+}
+
+// glosa retorna la glosa del código o el código si no está en la tabla
+func glosa(tabla map[int]string, codigo int) string {
+	if texto, ok := tabla[codigo]; ok {
+		return fmt.Sprintf("%d - %s", codigo, texto)
+	}
+	return strconv.Itoa(codigo)
+}
+
+// formatearMontoExportacion muestra un monto en moneda extranjera con al menos dos y hasta
+// cuatro decimales
+func formatearMontoExportacion(monto float64) string {
+	texto := strconv.FormatFloat(monto, 'f', 4, 64)
+	for strings.HasSuffix(texto, "0") && len(texto)-strings.Index(texto, ".") > 3 {
+		texto = strings.TrimSuffix(texto, "0")
+	}
+	return texto
+}
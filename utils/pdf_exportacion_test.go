@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cursor/FMgo/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePDFExportacion(t *testing.T) {
+	doc := &models.DocumentoTributario{
+		Folio:               15,
+		FechaEmision:        time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		TipoDocumento:       models.TipoFacturaExportacion,
+		RUTEmisor:           "76212889-6",
+		RazonSocialEmisor:   "Exportadora Sur SpA",
+		RazonSocialReceptor: "Fruit Imports LLC",
+		MontoExento:         4245.9999,
+		MontoTotal:          4245.9999,
+		Detalles: []models.DetalleTributario{
+			{Descripcion: "Cerezas frescas", Cantidad: 120, PrecioUnitario: 35.125, MontoItem: 4215, UnidadMedida: "CJ"},
+		},
+		Exportacion: &models.DatosExportacion{
+			Extranjero: models.ReceptorExtranjero{NumID: "12-3456789", Nacionalidad: "225"},
+			Aduana: models.DatosAduana{
+				CodModVenta: 1, CodClauVenta: 2, TotClauVenta: 4245.9999, CodViaTransp: 1,
+				CodPtoEmbarque: 906, CodPtoDesemb: 213, TotBultos: 120,
+				TipoBultos:   []models.TipoBulto{{CodTpoBultos: 75, CantBultos: 120}},
+				CodPaisRecep: 225, CodPaisDestin: 225,
+			},
+			TpoMoneda:       "DOLAR USA",
+			TpoCambio:       950.5,
+			MontoTotalPesos: 4035823,
+		},
+		XML: strings.Replace(dtePruebaTimbre, "c2lp", strings.Repeat("QUJD", 43), 1),
+	}
+
+	utils := NewPDFUtils()
+	utils.SetResolucion(ResolucionSII{Numero: 80, Fecha: time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC)})
+	pdf, err := utils.GeneratePDF(doc)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(pdf), "%PDF"))
+
+	doc.XML = ""
+	_, err = utils.GeneratePDF(doc)
+	assert.Error(t, err)
+}
+
+func TestFormatearMontoExportacion(t *testing.T) {
+	assert.Equal(t, "4215.00", formatearMontoExportacion(4215))
+	assert.Equal(t, "35.125", formatearMontoExportacion(35.125))
+	assert.Equal(t, "4245.9999", formatearMontoExportacion(4245.9999))
+}
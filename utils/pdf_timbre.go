@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cursor/FMgo/utils/pdf417"
+	"github.com/cursor/FMgo/utils/xmldsig"
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Dimensiones del timbre impreso, en milímetros, según el manual de muestras impresas del SII
+const (
+	AnchoMinimoTimbre = 50.0 // El timbre debe medir al menos 2x5 cm
+	AltoMinimoTimbre  = 20.0
+	MargenTimbre      = 20.0 // Distancia mínima desde el borde izquierdo de la hoja
+	moduloMinimo      = 0.17 // Ancho mínimo del módulo (0,0067 pulgadas)
+	zonaSilencio      = 2    // Módulos en blanco alrededor del símbolo
+	altoLeyenda       = 3.5
+)
+
+// ResolucionSII identifica la resolución que autoriza al emisor, que se imprime bajo el timbre,
+// y la unidad del SII que corresponde a su domicilio, que se imprime bajo el recuadro del folio
+type ResolucionSII struct {
+	Numero int
+	Fecha  time.Time
+	Unidad string
+}
+
+// ContenidoTED extrae el nodo TED de un DTE (o de un XML que sólo contiene el TED) y lo
+// retorna sin espacios entre elementos y codificado en ISO-8859-1, que es el contenido que
+// se imprime en el código PDF417
+func ContenidoTED(xmlData string) ([]byte, error) {
+	doc, err := xmldsig.ParseDocument([]byte(xmlData))
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el timbre: %v", err)
+	}
+
+	ted := doc.Root()
+	if ted.Tag != "TED" {
+		if ted = doc.FindElement("//TED"); ted == nil {
+			return nil, fmt.Errorf("el documento no contiene el timbre electrónico (TED)")
+		}
+	}
+
+	salida := etree.NewDocument()
+	salida.SetRoot(ted.Copy())
+	quitarEspacios(salida.Root())
+	for _, attr := range append([]etree.Attr{}, salida.Root().Attr...) {
+		if attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns") {
+			salida.Root().RemoveAttr(attr.FullKey())
+		}
+	}
+	plano, err := salida.WriteToString()
+	if err != nil {
+		return nil, fmt.Errorf("error al escribir el timbre: %v", err)
+	}
+
+	contenido, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(plano))
+	if err != nil {
+		return nil, fmt.Errorf("el timbre contiene caracteres no representables en ISO-8859-1: %v", err)
+	}
+	return contenido, nil
+}
+
+// DibujarTimbre dibuja el código PDF417 del TED (nivel de seguridad 5, filas de al menos tres
+// módulos de alto y un mínimo de 2x5 cm) con la leyenda del SII, desde la esquina superior
+// izquierda (x, y). Retorna el alto total ocupado.
+func DibujarTimbre(pdf *gofpdf.Fpdf, ted []byte, x, y float64, resolucion ResolucionSII) (float64, error) {
+	codigo, err := pdf417.Codificar(ted, 0, pdf417.NivelSeguridadSII)
+	if err != nil {
+		return 0, fmt.Errorf("error al codificar el timbre en PDF417: %v", err)
+	}
+
+	anchoModulo, altoFila := medidasTimbre(codigo)
+	ancho := anchoModulo * float64(codigo.Ancho()+2*zonaSilencio)
+	alto := altoFila*float64(codigo.Filas) + 2*zonaSilencio*anchoModulo
+
+	r, g, b := pdf.GetFillColor()
+	defer pdf.SetFillColor(r, g, b)
+
+	// Fondo blanco para mantener el contraste sobre plantillas con color
+	pdf.SetFillColor(255, 255, 255)
+	pdf.Rect(x, y, ancho, alto, "F")
+
+	pdf.SetFillColor(0, 0, 0)
+	origenX := x + zonaSilencio*anchoModulo
+	origenY := y + zonaSilencio*anchoModulo
+	for fila := 0; fila < codigo.Filas; fila++ {
+		filaY := origenY + float64(fila)*altoFila
+		for columna := 0; columna < codigo.Ancho(); {
+			if !codigo.Modulo(fila, columna) {
+				columna++
+				continue
+			}
+			inicio := columna
+			for columna < codigo.Ancho() && codigo.Modulo(fila, columna) {
+				columna++
+			}
+			pdf.Rect(origenX+float64(inicio)*anchoModulo, filaY, float64(columna-inicio)*anchoModulo, altoFila, "F")
+		}
+	}
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	leyenda := []string{
+		"Timbre Electrónico SII",
+		fmt.Sprintf("Res. N° %d de %d", resolucion.Numero, resolucion.Fecha.Year()),
+		"Verifique documento: www.sii.cl",
+	}
+	pdf.SetFont("Arial", "B", 8)
+	for i, linea := range leyenda {
+		pdf.SetXY(x, y+alto+float64(i)*altoLeyenda)
+		pdf.CellFormat(ancho, altoLeyenda, tr(linea), "", 0, "C", false, 0, "")
+	}
+
+	return alto + float64(len(leyenda))*altoLeyenda, nil
+}
+
+// AltoTimbre retorna el alto que ocupará el timbre del TED con su leyenda, para reservar su
+// espacio al pie de la página
+func AltoTimbre(ted []byte) (float64, error) {
+	codigo, err := pdf417.Codificar(ted, 0, pdf417.NivelSeguridadSII)
+	if err != nil {
+		return 0, fmt.Errorf("error al codificar el timbre en PDF417: %v", err)
+	}
+	anchoModulo, altoFila := medidasTimbre(codigo)
+	return altoFila*float64(codigo.Filas) + 2*zonaSilencio*anchoModulo + 3*altoLeyenda, nil
+}
+
+// DibujarTimbreAlPie dibuja el timbre del XML indicado (DTE o TED) en la parte inferior de la
+// página actual, a la distancia mínima del borde izquierdo y sobre el margen inferior
+func DibujarTimbreAlPie(pdf *gofpdf.Fpdf, xmlTED string, resolucion ResolucionSII) error {
+	ted, err := ContenidoTED(xmlTED)
+	if err != nil {
+		return err
+	}
+	alto, err := AltoTimbre(ted)
+	if err != nil {
+		return err
+	}
+
+	_, altoPagina := pdf.GetPageSize()
+	_, _, _, margenInferior := pdf.GetMargins()
+	_, err = DibujarTimbre(pdf, ted, MargenTimbre, altoPagina-margenInferior-alto-1, resolucion)
+	return err
+}
+
+// AnchoTimbre retorna el ancho que ocupará el timbre del TED, para ubicar otros elementos del
+// pie de página a su derecha
+func AnchoTimbre(ted []byte) (float64, error) {
+	codigo, err := pdf417.Codificar(ted, 0, pdf417.NivelSeguridadSII)
+	if err != nil {
+		return 0, fmt.Errorf("error al codificar el timbre en PDF417: %v", err)
+	}
+	anchoModulo, _ := medidasTimbre(codigo)
+	return anchoModulo * float64(codigo.Ancho()+2*zonaSilencio), nil
+}
+
+// medidasTimbre calcula el ancho del módulo y el alto de fila para que el símbolo cubra al
+// menos 2x5 cm, con módulos no menores al mínimo y filas de al menos tres módulos de alto
+func medidasTimbre(codigo *pdf417.Codigo) (float64, float64) {
+	anchoModulo := AnchoMinimoTimbre / float64(codigo.Ancho()+2*zonaSilencio)
+	if anchoModulo < moduloMinimo {
+		anchoModulo = moduloMinimo
+	}
+	altoFila := 3 * anchoModulo
+	if altoFila*float64(codigo.Filas) < AltoMinimoTimbre {
+		altoFila = AltoMinimoTimbre / float64(codigo.Filas)
+	}
+	return anchoModulo, altoFila
+}
+
+// quitarEspacios elimina los nodos de texto que sólo contienen espacios
+func quitarEspacios(el *etree.Element) {
+	for _, token := range append([]etree.Token{}, el.Child...) {
+		switch t := token.(type) {
+		case *etree.CharData:
+			if strings.TrimSpace(t.Data) == "" {
+				el.RemoveChild(t)
+			}
+		case *etree.Element:
+			quitarEspacios(t)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dtePruebaTimbre = `<DTE xmlns="http://www.sii.cl/SiiDte" version="1.0">
+  <Documento ID="F1T33">
+    <TED version="1.0">
+      <DD>
+        <RE>76212889-6</RE>
+        <RSR>Ñandú</RSR>
+      </DD>
+      <FRMT algoritmo="SHA1withRSA">c2lp</FRMT>
+    </TED>
+  </Documento>
+</DTE>`
+
+func TestContenidoTED(t *testing.T) {
+	contenido, err := ContenidoTED(dtePruebaTimbre)
+	require.NoError(t, err)
+
+	esperado := `<TED version="1.0"><DD><RE>76212889-6</RE><RSR>` + "\xd1and\xfa" + `</RSR></DD>` +
+		`<FRMT algoritmo="SHA1withRSA">c2lp</FRMT></TED>`
+	assert.Equal(t, esperado, string(contenido))
+
+	_, err = ContenidoTED(`<DTE><Documento/></DTE>`)
+	assert.Error(t, err)
+}
+
+func TestDibujarTimbreAlPie(t *testing.T) {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.AddPage()
+
+	ted := strings.Replace(dtePruebaTimbre, "c2lp", strings.Repeat("QUJD", 43), 1)
+	resolucion := ResolucionSII{Numero: 80, Fecha: time.Date(2014, 8, 22, 0, 0, 0, 0, time.UTC)}
+	require.NoError(t, DibujarTimbreAlPie(pdf, ted, resolucion))
+
+	// El timbre y su leyenda caben sobre el margen inferior sin agregar páginas
+	assert.Equal(t, 1, pdf.PageCount())
+
+	var buf bytes.Buffer
+	require.NoError(t, pdf.Output(&buf))
+	assert.NotZero(t, buf.Len())
+}
+
+func TestAltoTimbreMinimo(t *testing.T) {
+	contenido, err := ContenidoTED(dtePruebaTimbre)
+	require.NoError(t, err)
+
+	alto, err := AltoTimbre(contenido)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, alto, AltoMinimoTimbre)
+}
@@ -6,18 +6,16 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"image"
-	"io"
 
 	"github.com/cursor/FMgo/models"
 	"github.com/jung-kurt/gofpdf"
-	"github.com/skip2/go-qrcode"
 )
 
 // PDFUtils contiene utilidades para la generación de PDFs y firma digital
 type PDFUtils struct {
-	utils *DocumentUtils
-	sii   *SIIUtils
+	utils      *DocumentUtils
+	sii        *SIIUtils
+	resolucion ResolucionSII
 }
 
 // NewPDFUtils crea una nueva instancia de PDFUtils
@@ -28,8 +26,23 @@ func NewPDFUtils() *PDFUtils {
 	}
 }
 
-// GeneratePDF genera un PDF moderno para un documento tributario
+// SetResolucion asigna la resolución del emisor que se imprime bajo el timbre electrónico
+func (p *PDFUtils) SetResolucion(resolucion ResolucionSII) {
+	p.resolucion = resolucion
+}
+
+// GeneratePDF genera un PDF moderno para un documento tributario. El documento debe incluir
+// su XML timbrado, del que se toma el TED impreso como PDF417.
 func (p *PDFUtils) GeneratePDF(doc *models.DocumentoTributario) ([]byte, error) {
+	if doc.TipoDocumento.EsExportacion() {
+		return p.generarPDFExportacion(doc)
+	}
+
+	ted, err := ContenidoTED(doc.XML)
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener timbre electrónico: %v", err)
+	}
+
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
 
@@ -107,23 +120,25 @@ func (p *PDFUtils) GeneratePDF(doc *models.DocumentoTributario) ([]byte, error)
 	pdf.SetX(100)
 	pdf.CellFormat(50, 8, "IVA:", "0", 0, "R", false, 0, "")
 	pdf.CellFormat(30, 8, fmt.Sprintf("$%.2f", doc.MontoIVA), "0", 1, "R", false, 0, "")
+	if ret := doc.RetencionIVA; ret != nil {
+		pdf.SetX(100)
+		pdf.CellFormat(50, 8, fmt.Sprintf("IVA retenido (%s):", ret.Codigo), "0", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 8, fmt.Sprintf("-$%.2f", ret.Monto), "0", 1, "R", false, 0, "")
+	}
 	pdf.SetX(100)
 	pdf.SetFillColor(0, 255, 204)
 	pdf.SetTextColor(0, 0, 0)
 	pdf.CellFormat(50, 10, "TOTAL:", "1", 0, "R", true, 0, "")
 	pdf.CellFormat(30, 10, fmt.Sprintf("$%.2f", doc.MontoTotal), "1", 1, "R", true, 0, "")
 
-	// QR CODE (ejemplo: folio y total)
-	qrText := fmt.Sprintf("Folio: %d\nTotal: $%.2f", doc.Folio, doc.MontoTotal)
-	qr, _ := qrcode.New(qrText, qrcode.Medium)
-	qrImg := qr.Image(80)
-
-	// Convertir image.Image a Reader
-	qrImageReader := newQRImageReader(qrImg)
-
-	imgOpt := gofpdf.ImageOptions{ImageType: "png", ReadDpi: false}
-	pdf.RegisterImageOptionsReader("qr.png", imgOpt, qrImageReader)
-	pdf.ImageOptions("qr.png", 160, 230, 30, 30, false, imgOpt, 0, "")
+	// TIMBRE ELECTRÓNICO (PDF417), sobre el pie de página y a 2 cm del borde izquierdo
+	altoTimbre, err := AltoTimbre(ted)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := DibujarTimbre(pdf, ted, MargenTimbre, 268-altoTimbre, p.resolucion); err != nil {
+		return nil, err
+	}
 
 	// PIE DE PÁGINA
 	pdf.SetY(270)
@@ -133,7 +148,7 @@ func (p *PDFUtils) GeneratePDF(doc *models.DocumentoTributario) ([]byte, error)
 
 	// GENERAR PDF EN MEMORIA
 	var buf bytes.Buffer
-	err := pdf.Output(&buf)
+	err = pdf.Output(&buf)
 	if err != nil {
 		return nil, fmt.Errorf("error al generar PDF: %v", err)
 	}
@@ -141,29 +156,20 @@ func (p *PDFUtils) GeneratePDF(doc *models.DocumentoTributario) ([]byte, error)
 	return buf.Bytes(), nil
 }
 
-// QRImageReader implementa io.Reader para image.Image
-type QRImageReader struct {
-	img     image.Image
-	encoded []byte
-	pos     int
-}
-
-func newQRImageReader(img image.Image) *QRImageReader {
-	// Codificar la imagen (simulación simple)
-	return &QRImageReader{
-		img:     img,
-		encoded: []byte("simulated_qr_image_data"),
-		pos:     0,
+// generarPDFExportacion genera la representación impresa de un documento de exportación,
+// con los datos de Aduana y los montos en la moneda de la operación
+func (p *PDFUtils) generarPDFExportacion(doc *models.DocumentoTributario) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	if err := DibujarDocumentoExportacion(pdf, doc, p.resolucion); err != nil {
+		return nil, err
 	}
-}
 
-func (r *QRImageReader) Read(p []byte) (n int, err error) {
-	if r.pos >= len(r.encoded) {
-		return 0, io.EOF
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error al generar PDF: %v", err)
 	}
-	n = copy(p, r.encoded[r.pos:])
-	r.pos += n
-	return n, nil
+	return buf.Bytes(), nil
 }
 
 // SignPDF firma digitalmente un PDF
@@ -69,3 +69,26 @@ func ValidateRUT(rut string) error {
 
 	return nil
 }
+
+// SepararRUT separa un RUT con guión, con o sin puntos, en número y dígito verificador en
+// mayúscula, como lo piden los servicios del SII. No valida el dígito verificador.
+func SepararRUT(rut string) (string, string, error) {
+	rut = strings.ReplaceAll(strings.TrimSpace(rut), ".", "")
+	partes := strings.Split(rut, "-")
+	if len(partes) != 2 || partes[0] == "" || partes[1] == "" {
+		return "", "", fmt.Errorf("formato de RUT inválido: %s", rut)
+	}
+	return partes[0], strings.ToUpper(partes[1]), nil
+}
+
+// MismoRUT indica si dos RUT son iguales sin considerar puntos, guión ni la mayúscula del
+// dígito verificador
+func MismoRUT(a, b string) bool {
+	return strings.EqualFold(CleanRUT(strings.TrimSpace(a)), CleanRUT(strings.TrimSpace(b)))
+}
+
+// NormalizarRUT quita espacios y puntos y deja el dígito verificador en mayúscula, que es la
+// forma en que el SII escribe el RUT en sus documentos (12345678-K). No valida el RUT.
+func NormalizarRUT(rut string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(rut), ".", ""))
+}
@@ -2,6 +2,7 @@ package sii
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/xml"
 	"fmt"
@@ -10,13 +11,27 @@ import (
 	"time"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/services/ambiente"
 )
 
+// TokenProvider administra los tokens de autenticación SII compartidos entre clientes
+type TokenProvider interface {
+	ObtenerToken(ctx context.Context, rutEmpresa string) (string, error)
+	Invalidar(ctx context.Context, rutEmpresa string) error
+}
+
+// Ambientes resuelve las URLs del ambiente SII de cada empresa (ambiente.Registro)
+type Ambientes interface {
+	EndpointsEmpresa(rutEmpresa string) (ambiente.Endpoints, error)
+}
+
 // SIIClient maneja la comunicación con el SII
 type SIIClient struct {
 	certPath     string
 	certPassword string
 	client       *http.Client
+	tokens       TokenProvider
+	ambientes    Ambientes
 }
 
 // NewSIIClient crea una nueva instancia de SIIClient
@@ -46,6 +61,25 @@ func NewSIIClient(certPath, certPassword string) (*SIIClient, error) {
 	}, nil
 }
 
+// SetTokens configura el administrador de tokens usado cuando el sobre no trae token
+func (c *SIIClient) SetTokens(tokens TokenProvider) {
+	c.tokens = tokens
+}
+
+// SetAmbientes configura el registro con que se resuelven los servicios del ambiente de cada
+// empresa
+func (c *SIIClient) SetAmbientes(ambientes Ambientes) {
+	c.ambientes = ambientes
+}
+
+// endpoints retorna las URLs del ambiente de la empresa
+func (c *SIIClient) endpoints(rutEmpresa string) (ambiente.Endpoints, error) {
+	if c.ambientes == nil {
+		return ambiente.Endpoints{}, fmt.Errorf("cliente SII sin registro de ambientes")
+	}
+	return c.ambientes.EndpointsEmpresa(rutEmpresa)
+}
+
 // FirmarDTE firma un documento DTE
 func (c *SIIClient) FirmarDTE(xml []byte) ([]byte, error) {
 	// TODO: Implementar firma del documento
@@ -54,15 +88,28 @@ func (c *SIIClient) FirmarDTE(xml []byte) ([]byte, error) {
 
 // EnviarDTE envía un documento DTE al SII
 func (c *SIIClient) EnviarDTE(sobre models.Sobre) (*models.RespuestaSII, error) {
+	endpoints, err := c.endpoints(sobre.RUTCompania)
+	if err != nil {
+		return nil, err
+	}
+
 	// Crear request
-	req, err := http.NewRequest("POST", "https://palena.sii.cl/DTEWS/CrSeed.jws", bytes.NewReader(sobre.Documento))
+	req, err := http.NewRequest("POST", endpoints.UploadDTE, bytes.NewReader(sobre.Documento))
 	if err != nil {
 		return nil, fmt.Errorf("error al crear request: %v", err)
 	}
 
+	token := sobre.Token
+	if token == "" && c.tokens != nil {
+		token, err = c.tokens.ObtenerToken(req.Context(), sobre.RUTCompania)
+		if err != nil {
+			return nil, fmt.Errorf("error al obtener token: %v", err)
+		}
+	}
+
 	// Agregar headers
 	req.Header.Set("Content-Type", "application/xml")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", sobre.Token))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	// Enviar request
 	resp, err := c.client.Do(req)
@@ -71,6 +118,11 @@ func (c *SIIClient) EnviarDTE(sobre models.Sobre) (*models.RespuestaSII, error)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized && c.tokens != nil {
+		c.tokens.Invalidar(req.Context(), sobre.RUTCompania)
+		return nil, fmt.Errorf("el SII rechazó el token de la empresa %s", sobre.RUTCompania)
+	}
+
 	// Leer respuesta
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -87,10 +139,15 @@ func (c *SIIClient) EnviarDTE(sobre models.Sobre) (*models.RespuestaSII, error)
 	return &respuesta, nil
 }
 
-// ConsultarEstado consulta el estado de un documento DTE en el SII
-func (c *SIIClient) ConsultarEstado(trackID string) (*models.RespuestaSII, error) {
+// ConsultarEstado consulta el estado de un envío de la empresa en el SII
+func (c *SIIClient) ConsultarEstado(rutEmpresa, trackID string) (*models.RespuestaSII, error) {
+	endpoints, err := c.endpoints(rutEmpresa)
+	if err != nil {
+		return nil, err
+	}
+
 	// Crear request
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://palena.sii.cl/DTEWS/GetTokenFromSeed.jws?seed=%s", trackID), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?trackID=%s", endpoints.EstadoEnvio, trackID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error al crear request: %v", err)
 	}
@@ -7,8 +7,8 @@ type SIIService interface {
 	// EnviarDTE envía un DTE al SII
 	EnviarDTE(dte *models.DTEXMLModel) (*models.RespuestaSII, error)
 
-	// ConsultarEstado consulta el estado de un DTE en el SII
-	ConsultarEstado(trackID string) (*models.EstadoSII, error)
+	// ConsultarEstado consulta el estado de un envío de la empresa en el SII
+	ConsultarEstado(rutEmpresa, trackID string) (*models.EstadoSII, error)
 
 	// ValidarDTE valida un DTE antes de enviarlo al SII
 	ValidarDTE(dte *models.DTEXMLModel) (*models.RespuestaSII, error)
@@ -16,6 +16,6 @@ type SIIService interface {
 	// ConsultarDTE consulta un DTE específico en el SII
 	ConsultarDTE(tipoDTE, folio, rutEmisor string) (*models.EstadoSII, error)
 
-	// VerificarComunicacion verifica la comunicación con el SII
-	VerificarComunicacion() error
+	// VerificarComunicacion verifica la comunicación con el SII del ambiente de la empresa
+	VerificarComunicacion(rutEmpresa string) error
 }
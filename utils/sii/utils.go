@@ -7,17 +7,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 const (
-	// URLs del SII
-	urlSemilla = "https://apicert.sii.cl/recursos/v1/boleta.electronica.semilla"
-	urlToken   = "https://apicert.sii.cl/recursos/v1/boleta.electronica.token"
-	urlEnvio   = "https://apicert.sii.cl/recursos/v1/boleta.electronica.envio"
-
 	// Headers comunes
 	contentType     = "Content-Type"
 	userAgent       = "User-Agent"
@@ -29,19 +26,21 @@ const (
 	applicationXML  = "application/xml"
 	mozillaAgent    = "Mozilla/5.0"
 
-	// Algoritmos de firma
-	algoritmoCanonicalizacion = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
-	algoritmoFirma            = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
-	algoritmoDigest           = "http://www.w3.org/2000/09/xmldsig#sha1"
-
 	// Estados válidos del SII
 	estadoOK    = "OK"
 	estadoERROR = "ERROR"
 )
 
-// ObtenerSemilla obtiene una semilla del SII
-func ObtenerSemilla() (string, error) {
-	resp, err := http.Get(urlSemilla)
+// ObtenerSemilla obtiene una semilla de los servicios de boleta del ambiente de la empresa.
+//
+// Deprecated: la semilla la solicita el administrador de tokens compartido (services/token).
+func ObtenerSemilla(ambientes Ambientes, rutEmpresa string) (string, error) {
+	endpoints, err := ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Get(endpoints.BoletaAPI + "/boleta.electronica.semilla")
 	if err != nil {
 		return "", fmt.Errorf("error obteniendo semilla: %v", err)
 	}
@@ -59,14 +58,23 @@ func ObtenerSemilla() (string, error) {
 	return string(body), nil
 }
 
-// ObtenerToken obtiene un token de autenticación del SII
-func ObtenerToken(semilla, certPath, keyPath string) (string, error) {
+// ObtenerToken obtiene un token de autenticación de los servicios de boleta del ambiente de
+// la empresa.
+//
+// Deprecated: cada llamada repite el canje de semilla; los clientes deben recibir el
+// administrador de tokens compartido (services/token) mediante SetTokens.
+func ObtenerToken(ambientes Ambientes, rutEmpresa, semilla, certPath, keyPath string) (string, error) {
+	endpoints, err := ambientes.EndpointsEmpresa(rutEmpresa)
+	if err != nil {
+		return "", err
+	}
+
 	client, err := crearClienteHTTP(certPath, keyPath)
 	if err != nil {
 		return "", fmt.Errorf("error creando cliente HTTP: %v", err)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, urlToken, nil)
+	req, err := http.NewRequest(http.MethodGet, endpoints.BoletaAPI+"/boleta.electronica.token", nil)
 	if err != nil {
 		return "", fmt.Errorf("error creando request: %v", err)
 	}
@@ -183,76 +191,43 @@ func ValidarDTE(xmlData []byte) error {
 	return nil
 }
 
-// crearFirmaXML crea la firma XML según la especificación del SII
-func crearFirmaXML(doc *models.DocumentoTributario) (*models.FirmaXMLModel, error) {
-	// TODO: Implementar firma XML
-	return nil, nil
-}
-
-// insertarFirmaEnXML inserta la firma en el documento XML
-func insertarFirmaEnXML(xmlData []byte, firma *models.FirmaXMLModel) ([]byte, error) {
-	// Parsear XML original
-	var doc models.DTEXMLModel
-	if err := xml.Unmarshal(xmlData, &doc); err != nil {
-		return nil, fmt.Errorf("error parseando XML: %v", err)
-	}
-
-	// Agregar firma
-	doc.Signature = firma
-
-	// Convertir a XML
-	xmlFirmado, err := xml.MarshalIndent(doc, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("error convirtiendo documento firmado a XML: %v", err)
-	}
-
-	return xmlFirmado, nil
-}
-
-// FirmarDTE firma un DTE con el certificado digital
+// FirmarDTE firma el Documento del DTE con el certificado digital. La firma se calcula sobre
+// la forma canónica del Documento y el resto del XML se conserva sin cambios.
 func FirmarDTE(xmlData []byte, certPath, keyPath string) ([]byte, error) {
-	// Parsear XML original
-	var doc models.DTEXMLModel
-	if err := xml.Unmarshal(xmlData, &doc); err != nil {
-		return nil, fmt.Errorf("error parseando XML: %v", err)
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo certificado: %v", err)
 	}
-
-	// Extraer información relevante para crear un DocumentoTributario temporal
-	tempDoc := &models.DocumentoTributario{
-		TipoDTE:      doc.Documento.Encabezado.IdDoc.TipoDTE,
-		Folio:        doc.Documento.Encabezado.IdDoc.Folio,
-		FechaEmision: time.Now(), // TODO: Parsear fecha desde XML
-		MontoTotal:   float64(doc.Documento.Encabezado.Totales.MntTotal),
-		Emisor: &models.Emisor{
-			RUT: doc.Documento.Encabezado.Emisor.RUT,
-		},
-		Receptor: &models.Receptor{
-			RUT: doc.Documento.Encabezado.Receptor.RUT,
-		},
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo llave privada: %v", err)
 	}
 
-	// Crear firma
-	firma, err := crearFirmaXML(tempDoc)
+	firmante, err := xmldsig.CargarFirmante(certPEM, keyPEM)
 	if err != nil {
-		return nil, fmt.Errorf("error creando firma: %v", err)
+		return nil, err
 	}
 
-	// Insertar firma en XML
-	xmlFirmado, err := insertarFirmaEnXML(xmlData, firma)
+	xmlFirmado, err := firmante.Firmar(xmlData)
 	if err != nil {
-		return nil, fmt.Errorf("error insertando firma: %v", err)
+		return nil, fmt.Errorf("error firmando DTE: %v", err)
 	}
 
 	return xmlFirmado, nil
 }
 
-// EnviarDTE envía un DTE al SII
-func EnviarDTE(xmlData []byte, token, rutEmisor, rutEnvia string) ([]byte, error) {
+// EnviarDTE envía un DTE al servicio de recepción de boletas del ambiente del emisor
+func EnviarDTE(ambientes Ambientes, xmlData []byte, token, rutEmisor, rutEnvia string) ([]byte, error) {
+	endpoints, err := ambientes.EndpointsEmpresa(rutEmisor)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
 
-	req, err := http.NewRequest(http.MethodPost, urlEnvio, bytes.NewBuffer(xmlData))
+	req, err := http.NewRequest(http.MethodPost, endpoints.BoletaEnvio+"/boleta.electronica.envio", bytes.NewBuffer(xmlData))
 	if err != nil {
 		return nil, fmt.Errorf("error creando request: %v", err)
 	}
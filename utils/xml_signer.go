@@ -1,16 +1,14 @@
 package utils
 
 import (
-	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/pem"
+	"encoding/xml"
 	"fmt"
 
 	"github.com/cursor/FMgo/models"
+	"github.com/cursor/FMgo/utils/xmldsig"
 )
 
 // FirmadorXML representa un firmador de documentos XML
@@ -35,51 +33,32 @@ func NuevoFirmadorXML(privateKeyPEM []byte) (*FirmadorXML, error) {
 	}, nil
 }
 
-// FirmarDTE firma un documento DTE
+// FirmarDTE firma el Documento del DTE con XMLDSig y asigna la firma al modelo
 func (f *FirmadorXML) FirmarDTE(dte *models.DTEXMLModel) error {
-	// Generar el hash del documento
-	hash := sha1.New()
-	hash.Write([]byte(dte.Documento.ID))
-	hash.Write([]byte(dte.Documento.Encabezado.IdDoc.FechaEmision))
-	hash.Write([]byte(dte.Documento.Encabezado.IdDoc.TipoDTE))
-	hash.Write([]byte(dte.Documento.Encabezado.Emisor.RUT))
-	hash.Write([]byte(dte.Documento.Encabezado.Receptor.RUT))
-	montoTotal := fmt.Sprintf("%d", dte.Documento.Encabezado.Totales.MntTotal)
-	hash.Write([]byte(montoTotal))
+	if dte.Documento.ID == "" {
+		return fmt.Errorf("el documento no tiene ID")
+	}
 
-	// Firmar el hash
-	signature, err := rsa.SignPKCS1v15(rand.Reader, f.privateKey, crypto.SHA1, hash.Sum(nil))
+	dte.Signature = nil
+	xmlDTE, err := xml.Marshal(dte)
+	if err != nil {
+		return fmt.Errorf("error generando XML del documento: %v", err)
+	}
+
+	firmado, err := xmldsig.NewFirmante(f.privateKey, nil).FirmarDocumento(xmlDTE, dte.Documento.ID)
+	if err != nil {
+		return fmt.Errorf("error firmando documento: %v", err)
+	}
+	nodo, err := xmldsig.ExtraerFirma(firmado, dte.Documento.ID)
 	if err != nil {
 		return fmt.Errorf("error firmando documento: %v", err)
 	}
 
-	// Agregar la firma al documento
-	dte.Signature = &models.FirmaXMLModel{
-		SignedInfo: models.SignedInfoXML{
-			CanonicalizationMethod: models.CanonicalizationMethodXML{
-				Algorithm: "http://www.w3.org/TR/2001/REC-xml-c14n-20010315",
-			},
-			SignatureMethod: models.SignatureMethodXML{
-				Algorithm: "http://www.w3.org/2000/09/xmldsig#rsa-sha1",
-			},
-			Reference: models.ReferenceSignatureXML{
-				URI: "#" + dte.Documento.ID,
-				DigestMethod: models.DigestMethodXML{
-					Algorithm: "http://www.w3.org/2000/09/xmldsig#sha1",
-				},
-				DigestValue: base64.StdEncoding.EncodeToString(hash.Sum(nil)),
-			},
-		},
-		SignatureValue: base64.StdEncoding.EncodeToString(signature),
-		KeyInfo: models.KeyInfoXML{
-			KeyValue: models.KeyValueXML{
-				RSAKeyValue: models.RSAKeyValueXML{
-					Modulus:  base64.StdEncoding.EncodeToString(f.privateKey.PublicKey.N.Bytes()),
-					Exponent: "AQAB", // RSA estándar
-				},
-			},
-		},
+	var firma models.FirmaXMLModel
+	if err := xml.Unmarshal([]byte(nodo), &firma); err != nil {
+		return fmt.Errorf("error leyendo firma: %v", err)
 	}
+	dte.Signature = &firma
 
 	return nil
 }
@@ -90,27 +69,20 @@ func (f *FirmadorXML) VerificarFirmaDTE(dte *models.DTEXMLModel) error {
 		return fmt.Errorf("documento no firmado")
 	}
 
-	// Generar el hash del documento
-	hash := sha1.New()
-	hash.Write([]byte(dte.Documento.ID))
-	hash.Write([]byte(dte.Documento.Encabezado.IdDoc.FechaEmision))
-	hash.Write([]byte(dte.Documento.Encabezado.IdDoc.TipoDTE))
-	hash.Write([]byte(dte.Documento.Encabezado.Emisor.RUT))
-	hash.Write([]byte(dte.Documento.Encabezado.Receptor.RUT))
-	montoTotal := fmt.Sprintf("%d", dte.Documento.Encabezado.Totales.MntTotal)
-	hash.Write([]byte(montoTotal))
-
-	// Decodificar la firma
-	signature, err := base64.StdEncoding.DecodeString(dte.Signature.SignatureValue)
+	xmlDTE, err := xml.Marshal(dte)
 	if err != nil {
-		return fmt.Errorf("error decodificando firma: %v", err)
+		return fmt.Errorf("error generando XML del documento: %v", err)
 	}
 
-	// Verificar la firma
-	err = rsa.VerifyPKCS1v15(&f.privateKey.PublicKey, crypto.SHA1, hash.Sum(nil), signature)
+	resultados, err := xmldsig.VerificarDocumento(xmlDTE)
 	if err != nil {
 		return fmt.Errorf("error verificando firma: %v", err)
 	}
+	for _, r := range resultados {
+		if r.LlavePublica.N.Cmp(f.privateKey.N) != 0 {
+			return fmt.Errorf("error verificando firma: el documento fue firmado con otra llave")
+		}
+	}
 
 	return nil
 }
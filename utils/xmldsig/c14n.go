@@ -0,0 +1,176 @@
+package xmldsig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// namespaceXML es el namespace reservado del prefijo xml
+const namespaceXML = "http://www.w3.org/XML/1998/namespace"
+
+// ParseDocument lee un documento XML aceptando las codificaciones usadas por el SII (ISO-8859-1 y UTF-8)
+func ParseDocument(data []byte) (*etree.Document, error) {
+	doc := etree.NewDocument()
+	doc.ReadSettings.CharsetReader = charsetReader
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("error al leer XML: %v", err)
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("el documento XML no tiene elemento raíz")
+	}
+	return doc, nil
+}
+
+// charsetReader convierte ISO-8859-1 a UTF-8 antes de procesar el documento
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "latin-1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "utf-8", "utf8", "":
+		return input, nil
+	default:
+		return nil, fmt.Errorf("codificación no soportada: %s", charset)
+	}
+}
+
+// Canonicalize serializa un elemento y sus descendientes según Canonical XML 1.0
+// (http://www.w3.org/TR/2001/REC-xml-c14n-20010315), sin comentarios. Como la forma
+// inclusiva lo exige, el elemento hereda los namespaces declarados en sus ancestros.
+func Canonicalize(el *etree.Element) []byte {
+	var buf bytes.Buffer
+	escribirCanonico(&buf, el, namespacesEnAmbito(el.Parent()), map[string]string{})
+	return buf.Bytes()
+}
+
+// namespacesEnAmbito retorna los namespaces declarados en el elemento y sus ancestros
+func namespacesEnAmbito(el *etree.Element) map[string]string {
+	var cadena []*etree.Element
+	for e := el; e != nil; e = e.Parent() {
+		cadena = append(cadena, e)
+	}
+
+	ns := map[string]string{}
+	for i := len(cadena) - 1; i >= 0; i-- {
+		agregarDeclaraciones(ns, cadena[i])
+	}
+	return ns
+}
+
+// agregarDeclaraciones incorpora al mapa las declaraciones xmlns del elemento
+func agregarDeclaraciones(ns map[string]string, el *etree.Element) {
+	for _, a := range el.Attr {
+		switch {
+		case a.Space == "" && a.Key == "xmlns":
+			ns[""] = a.Value
+		case a.Space == "xmlns":
+			ns[a.Key] = a.Value
+		}
+	}
+}
+
+// escribirCanonico escribe el elemento en forma canónica. enAmbito contiene los namespaces
+// heredados y renderizados los ya emitidos por el ancestro de salida más cercano.
+func escribirCanonico(buf *bytes.Buffer, el *etree.Element, heredados, renderizados map[string]string) {
+	enAmbito := make(map[string]string, len(heredados)+2)
+	for k, v := range heredados {
+		enAmbito[k] = v
+	}
+	agregarDeclaraciones(enAmbito, el)
+
+	nombre := el.Tag
+	if el.Space != "" {
+		nombre = el.Space + ":" + el.Tag
+	}
+	buf.WriteString("<" + nombre)
+
+	// Nodos de namespace: el namespace por defecto primero y luego por prefijo
+	prefijos := make([]string, 0, len(enAmbito))
+	for prefijo, uri := range enAmbito {
+		anterior, emitido := renderizados[prefijo]
+		if prefijo == "" && uri == "" && (!emitido || anterior == "") {
+			continue
+		}
+		if emitido && anterior == uri {
+			continue
+		}
+		prefijos = append(prefijos, prefijo)
+	}
+	sort.Strings(prefijos)
+	for _, prefijo := range prefijos {
+		if prefijo == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + prefijo + `="`)
+		}
+		buf.WriteString(escaparAtributo(enAmbito[prefijo]))
+		buf.WriteString(`"`)
+	}
+
+	// Atributos ordenados por namespace y nombre local
+	type atributo struct {
+		uri, nombre, local, valor string
+	}
+	var atributos []atributo
+	for _, a := range el.Attr {
+		if (a.Space == "" && a.Key == "xmlns") || a.Space == "xmlns" {
+			continue
+		}
+		at := atributo{nombre: a.Key, local: a.Key, valor: a.Value}
+		if a.Space != "" {
+			at.nombre = a.Space + ":" + a.Key
+			if a.Space == "xml" {
+				at.uri = namespaceXML
+			} else {
+				at.uri = enAmbito[a.Space]
+			}
+		}
+		atributos = append(atributos, at)
+	}
+	sort.Slice(atributos, func(i, j int) bool {
+		if atributos[i].uri != atributos[j].uri {
+			return atributos[i].uri < atributos[j].uri
+		}
+		return atributos[i].local < atributos[j].local
+	})
+	for _, a := range atributos {
+		buf.WriteString(" " + a.nombre + `="` + escaparAtributo(a.valor) + `"`)
+	}
+	buf.WriteString(">")
+
+	for _, token := range el.Child {
+		switch t := token.(type) {
+		case *etree.Element:
+			escribirCanonico(buf, t, enAmbito, enAmbito)
+		case *etree.CharData:
+			buf.WriteString(escaparTexto(t.Data))
+		case *etree.ProcInst:
+			buf.WriteString("<?" + t.Target)
+			if t.Inst != "" {
+				buf.WriteString(" " + t.Inst)
+			}
+			buf.WriteString("?>")
+		}
+	}
+
+	buf.WriteString("</" + nombre + ">")
+}
+
+var reemplazosTexto = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var reemplazosAtributo = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+// escaparTexto escapa un nodo de texto según C14N
+func escaparTexto(s string) string {
+	return reemplazosTexto.Replace(s)
+}
+
+// escaparAtributo escapa el valor de un atributo según C14N
+func escaparAtributo(s string) string {
+	return reemplazosAtributo.Replace(s)
+}
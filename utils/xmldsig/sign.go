@@ -0,0 +1,291 @@
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/beevik/etree"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Firmante firma documentos XML con firmas XMLDSig RSA-SHA1 y C14N inclusivo, el formato
+// exigido por el SII para DTE, sobres de envío, libros y solicitudes de token.
+// El documento firmado se serializa sin reindentar, por lo que los digest calculados sobre
+// la forma canónica se mantienen al volver a leerlo.
+type Firmante struct {
+	llave       *rsa.PrivateKey
+	certificado *x509.Certificate
+}
+
+// NewFirmante crea un firmante con la llave privada y el certificado indicados
+func NewFirmante(llave *rsa.PrivateKey, certificado *x509.Certificate) *Firmante {
+	return &Firmante{llave: llave, certificado: certificado}
+}
+
+// CargarFirmante crea un firmante a partir del certificado y la llave privada en formato PEM.
+// La llave puede estar en PKCS#1 o PKCS#8.
+func CargarFirmante(certPEM, llavePEM []byte) (*Firmante, error) {
+	bloque, _ := pem.Decode(certPEM)
+	if bloque == nil {
+		return nil, fmt.Errorf("no se pudo decodificar el certificado PEM")
+	}
+	cert, err := x509.ParseCertificate(bloque.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error al parsear el certificado: %v", err)
+	}
+
+	bloque, _ = pem.Decode(llavePEM)
+	if bloque == nil {
+		return nil, fmt.Errorf("no se pudo decodificar la llave privada PEM")
+	}
+	llave, err := x509.ParsePKCS1PrivateKey(bloque.Bytes)
+	if err != nil {
+		pkcs8, err8 := x509.ParsePKCS8PrivateKey(bloque.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("error al parsear la llave privada: %v", err)
+		}
+		var ok bool
+		if llave, ok = pkcs8.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("la llave privada no es RSA")
+		}
+	}
+
+	return NewFirmante(llave, cert), nil
+}
+
+// FirmarDocumento firma el elemento con el ID indicado, o el documento completo si
+// referenciaID está vacío, y retorna el documento firmado
+func (f *Firmante) FirmarDocumento(data []byte, referenciaID string) ([]byte, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	objetivo := doc.Root()
+	if referenciaID != "" {
+		if objetivo = BuscarPorID(doc.Root(), referenciaID); objetivo == nil {
+			return nil, fmt.Errorf("no existe elemento con ID %s", referenciaID)
+		}
+	}
+	if _, err := f.FirmarElemento(objetivo, referenciaID); err != nil {
+		return nil, err
+	}
+
+	return Serializar(doc)
+}
+
+// Firmar firma el documento según su tipo: el Documento de un DTE; los DTE sin firma y luego
+// el SetDTE de un EnvioDTE o EnvioBOLETA; en otro caso el primer hijo con ID de la raíz
+// (EnvioLibro, DocumentoConsumoFolios, SetRecibos, etc.), la raíz si tiene ID o el documento
+// completo. Todas las firmas se calculan en su ubicación final, con los namespaces que
+// heredan del sobre.
+func (f *Firmante) Firmar(data []byte) ([]byte, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	raiz := doc.Root()
+	switch raiz.Tag {
+	case "DTE":
+		if err := f.firmarDTE(raiz); err != nil {
+			return nil, err
+		}
+	case "EnvioDTE", "EnvioBOLETA":
+		setDTE := raiz.SelectElement("SetDTE")
+		if setDTE == nil {
+			return nil, fmt.Errorf("el %s no contiene SetDTE", raiz.Tag)
+		}
+		for _, dte := range setDTE.SelectElements("DTE") {
+			if firmaDirecta(dte) != nil {
+				continue
+			}
+			if err := f.firmarDTE(dte); err != nil {
+				return nil, err
+			}
+		}
+		id := setDTE.SelectAttrValue("ID", "")
+		if id == "" {
+			return nil, fmt.Errorf("el SetDTE no tiene ID")
+		}
+		if _, err := f.FirmarElemento(setDTE, id); err != nil {
+			return nil, err
+		}
+	default:
+		objetivo, id := raiz, raiz.SelectAttrValue("ID", "")
+		for _, hijo := range raiz.ChildElements() {
+			if hijoID := hijo.SelectAttrValue("ID", ""); hijoID != "" {
+				objetivo, id = hijo, hijoID
+				break
+			}
+		}
+		if _, err := f.FirmarElemento(objetivo, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return Serializar(doc)
+}
+
+// firmarDTE firma el Documento, Exportaciones o Liquidacion contenido en el DTE
+func (f *Firmante) firmarDTE(dte *etree.Element) error {
+	for _, hijo := range dte.ChildElements() {
+		switch hijo.Tag {
+		case "Documento", "Exportaciones", "Liquidacion":
+			id := hijo.SelectAttrValue("ID", "")
+			if id == "" {
+				return fmt.Errorf("el %s del DTE no tiene ID", hijo.Tag)
+			}
+			_, err := f.FirmarElemento(hijo, id)
+			return err
+		}
+	}
+	return fmt.Errorf("el DTE no contiene Documento")
+}
+
+// FirmarElemento calcula la firma del elemento y la agrega al documento. Si el elemento es la
+// raíz la firma queda como su último hijo (firma enveloped); en otro caso queda como último
+// hijo del padre, a continuación del elemento, como en DTE y EnvioDTE.
+func (f *Firmante) FirmarElemento(objetivo *etree.Element, referenciaID string) (*etree.Element, error) {
+	if f.llave == nil {
+		return nil, fmt.Errorf("el firmante no tiene llave privada")
+	}
+
+	padre := objetivo.Parent()
+	uri, transform := "", AlgoritmoEnveloped
+	if referenciaID != "" {
+		uri = "#" + referenciaID
+	}
+	if padre == nil || padre.Parent() == nil {
+		padre = objetivo
+	} else {
+		transform = AlgoritmoC14N
+	}
+
+	// El digest se calcula antes de agregar la firma, lo que equivale a la transformación enveloped
+	digest := crypto.SHA1.New()
+	digest.Write(Canonicalize(objetivo))
+
+	firma := padre.CreateElement("Signature")
+	firma.CreateAttr("xmlns", NamespaceXMLDSig)
+	signedInfo := firma.CreateElement("SignedInfo")
+	signedInfo.CreateElement("CanonicalizationMethod").CreateAttr("Algorithm", AlgoritmoC14N)
+	signedInfo.CreateElement("SignatureMethod").CreateAttr("Algorithm", AlgoritmoRSASHA1)
+	ref := signedInfo.CreateElement("Reference")
+	ref.CreateAttr("URI", uri)
+	ref.CreateElement("Transforms").CreateElement("Transform").CreateAttr("Algorithm", transform)
+	ref.CreateElement("DigestMethod").CreateAttr("Algorithm", AlgoritmoSHA1)
+	ref.CreateElement("DigestValue").SetText(base64.StdEncoding.EncodeToString(digest.Sum(nil)))
+
+	// SignedInfo se canonicaliza ya ubicado en el documento para heredar sus namespaces
+	hash := crypto.SHA1.New()
+	hash.Write(Canonicalize(signedInfo))
+	valor, err := rsa.SignPKCS1v15(rand.Reader, f.llave, crypto.SHA1, hash.Sum(nil))
+	if err != nil {
+		padre.RemoveChild(firma)
+		return nil, fmt.Errorf("error al firmar: %v", err)
+	}
+	firma.CreateElement("SignatureValue").SetText(base64.StdEncoding.EncodeToString(valor))
+
+	keyInfo := firma.CreateElement("KeyInfo")
+	rsaKey := keyInfo.CreateElement("KeyValue").CreateElement("RSAKeyValue")
+	rsaKey.CreateElement("Modulus").SetText(base64.StdEncoding.EncodeToString(f.llave.N.Bytes()))
+	rsaKey.CreateElement("Exponent").SetText(base64.StdEncoding.EncodeToString(big.NewInt(int64(f.llave.E)).Bytes()))
+	if f.certificado != nil {
+		keyInfo.CreateElement("X509Data").CreateElement("X509Certificate").
+			SetText(base64.StdEncoding.EncodeToString(f.certificado.Raw))
+	}
+
+	return firma, nil
+}
+
+// ExtraerFirma retorna el nodo Signature que referencia el ID indicado ("" para el documento
+// completo), para los modelos que almacenan la firma por separado
+func ExtraerFirma(data []byte, referenciaID string) (string, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return "", err
+	}
+
+	uri := ""
+	if referenciaID != "" {
+		uri = "#" + referenciaID
+	}
+	for _, firma := range BuscarFirmas(doc.Root()) {
+		ref := firma.FindElement("SignedInfo/Reference")
+		if ref == nil || ref.SelectAttrValue("URI", "") != uri {
+			continue
+		}
+		salida := etree.NewDocument()
+		salida.SetRoot(firma.Copy())
+		return salida.WriteToString()
+	}
+	return "", ErrFirmaNoEncontrada
+}
+
+// Serializar escribe el documento sin alterar espacios ni indentación, codificado según su
+// declaración XML (ISO-8859-1 o UTF-8)
+func Serializar(doc *etree.Document) ([]byte, error) {
+	salida, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("error al escribir XML: %v", err)
+	}
+	if !esLatin1(doc) {
+		return salida, nil
+	}
+
+	var buf bytes.Buffer
+	w := charmap.ISO8859_1.NewEncoder().Writer(&buf)
+	if _, err := w.Write(salida); err != nil {
+		return nil, fmt.Errorf("el documento contiene caracteres no representables en ISO-8859-1: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// CodificarLatin1 declara ISO-8859-1 en un XML generado en UTF-8 (por ejemplo con xml.Marshal)
+// y lo recodifica. Los documentos que se envían en ISO-8859-1 deben pasar por aquí antes de
+// firmarse: agregar la declaración después haría que el texto no ASCII se lea distinto de lo
+// que se firmó.
+func CodificarLatin1(data []byte) ([]byte, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range doc.Child {
+		if pi, ok := token.(*etree.ProcInst); ok && pi.Target == "xml" {
+			doc.RemoveChild(pi)
+			break
+		}
+	}
+	doc.InsertChildAt(0, etree.NewProcInst("xml", `version="1.0" encoding="ISO-8859-1"`))
+	return Serializar(doc)
+}
+
+// esLatin1 indica si la declaración XML del documento es ISO-8859-1
+func esLatin1(doc *etree.Document) bool {
+	for _, token := range doc.Child {
+		if pi, ok := token.(*etree.ProcInst); ok && pi.Target == "xml" {
+			inst := strings.ToLower(pi.Inst)
+			return strings.Contains(inst, "iso-8859-1") || strings.Contains(inst, "latin1")
+		}
+	}
+	return false
+}
+
+// firmaDirecta retorna la firma XMLDSig hija directa del elemento
+func firmaDirecta(el *etree.Element) *etree.Element {
+	for _, hijo := range el.ChildElements() {
+		if hijo.Tag == "Signature" && hijo.NamespaceURI() == NamespaceXMLDSig {
+			return hijo
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package xmldsig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const envioSinFirmar = "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+	`<EnvioDTE xmlns="http://www.sii.cl/SiiDte" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" version="1.0">
+  <SetDTE ID="SetDoc">
+    <Caratula version="1.0">
+      <RutEmisor>76212889-6</RutEmisor>
+    </Caratula>
+    <DTE version="1.0">
+      <Documento ID="F1T33">
+        <Encabezado><Emisor><RznSoc>Compañía Ñandú</RznSoc></Emisor></Encabezado>
+      </Documento>
+    </DTE>
+    <DTE version="1.0">
+      <Documento ID="F2T33">
+        <Encabezado><Emisor><RznSoc>Compañía Ñandú</RznSoc></Emisor></Encabezado>
+      </Documento>
+    </DTE>
+  </SetDTE>
+</EnvioDTE>`
+
+func TestFirmarEnvio(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	firmante := NewFirmante(llave, cert)
+
+	envio, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(envioSinFirmar))
+	require.NoError(t, err)
+
+	firmado, err := firmante.Firmar(envio)
+	require.NoError(t, err)
+
+	// Se conserva la codificación y la indentación original
+	assert.True(t, bytes.Contains(firmado, []byte{'C', 'o', 'm', 'p', 'a', 0xF1, 0xED, 'a'}))
+	assert.Contains(t, string(firmado), "\n      <Documento ID=\"F1T33\">\n")
+
+	verificaciones, err := VerificarFirmas(firmado)
+	require.NoError(t, err)
+	require.Len(t, verificaciones, 3)
+	rutas := make([]string, 0, len(verificaciones))
+	for _, v := range verificaciones {
+		require.NoError(t, v.Error, v.Ruta)
+		rutas = append(rutas, v.Ruta+" "+v.Resultado.Referencias[0])
+	}
+	assert.ElementsMatch(t, []string{
+		"/EnvioDTE/SetDTE/DTE[1] #F1T33",
+		"/EnvioDTE/SetDTE/DTE[2] #F2T33",
+		"/EnvioDTE #SetDoc",
+	}, rutas)
+
+	// Un DTE ya firmado no se vuelve a firmar
+	refirmado, err := firmante.Firmar(firmado)
+	require.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(refirmado), "<Signature "))
+}
+
+func TestFirmarDocumentoCompleto(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+
+	firmado, err := NewFirmante(llave, cert).FirmarDocumento([]byte(`<getToken><item><Semilla>012345678901</Semilla></item></getToken>`), "")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(firmado), `<getToken><item><Semilla>012345678901</Semilla></item><Signature xmlns="http://www.w3.org/2000/09/xmldsig#">`))
+	assert.Contains(t, string(firmado), `<Reference URI="">`)
+
+	resultados, err := VerificarDocumento(firmado)
+	require.NoError(t, err)
+	assert.Equal(t, cert.SerialNumber, resultados[0].Certificado.SerialNumber)
+}
+
+func TestFirmarDocumentoPorID(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	libro := `<LibroCompraVenta xmlns="http://www.sii.cl/SiiDte" version="1.0"><EnvioLibro ID="LIBRO1"><Caratula/></EnvioLibro></LibroCompraVenta>`
+
+	firmado, err := NewFirmante(llave, cert).FirmarDocumento([]byte(libro), "LIBRO1")
+	require.NoError(t, err)
+	assert.Contains(t, string(firmado), `</EnvioLibro><Signature xmlns="http://www.w3.org/2000/09/xmldsig#">`)
+
+	_, err = VerificarDocumento(firmado)
+	require.NoError(t, err)
+
+	firma, err := ExtraerFirma(firmado, "LIBRO1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(firma, `<Signature xmlns="http://www.w3.org/2000/09/xmldsig#"><SignedInfo>`))
+
+	_, err = NewFirmante(llave, cert).FirmarDocumento([]byte(libro), "NOEXISTE")
+	assert.Error(t, err)
+}
+
+func TestCodificarLatin1AntesDeFirmar(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	libro := `<LibroCompraVenta xmlns="http://www.sii.cl/SiiDte" version="1.0"><EnvioLibro ID="LIBRO1"><RznSoc>Compañía Ñandú</RznSoc></EnvioLibro></LibroCompraVenta>`
+
+	codificado, err := CodificarLatin1([]byte(libro))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(codificado), `<?xml version="1.0" encoding="ISO-8859-1"?><LibroCompraVenta`))
+	assert.True(t, bytes.Contains(codificado, []byte{'C', 'o', 'm', 'p', 'a', 0xF1, 0xED, 'a'}))
+
+	firmado, err := NewFirmante(llave, cert).FirmarDocumento(codificado, "LIBRO1")
+	require.NoError(t, err)
+	_, err = VerificarDocumento(firmado)
+	require.NoError(t, err)
+
+	doc, err := ParseDocument(firmado)
+	require.NoError(t, err)
+	assert.Equal(t, "Compañía Ñandú", doc.FindElement("//RznSoc").Text())
+}
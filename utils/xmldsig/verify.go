@@ -0,0 +1,559 @@
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// Algoritmos soportados
+const (
+	AlgoritmoC14N      = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	AlgoritmoEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	AlgoritmoSHA1      = "http://www.w3.org/2000/09/xmldsig#sha1"
+	AlgoritmoSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+	AlgoritmoRSASHA1   = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	AlgoritmoRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	NamespaceXMLDSig   = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// Errores de verificación
+var (
+	ErrFirmaNoEncontrada = errors.New("el documento no contiene firmas")
+	ErrDigestInvalido    = errors.New("el digest de la referencia no coincide")
+	ErrFirmaInvalida     = errors.New("el valor de la firma no es válido")
+	ErrIDDuplicado       = errors.New("más de un elemento tiene el ID referenciado")
+)
+
+// Resultado contiene la información de una firma verificada
+type Resultado struct {
+	Referencia   string         // ID de la primera referencia, vacío si firma el documento completo
+	Referencias  []string       // URI de todas las referencias
+	Elemento     *etree.Element // Elemento firmado por la primera referencia
+	Certificado  *x509.Certificate
+	LlavePublica *rsa.PublicKey
+}
+
+// Verificacion es el resultado de verificar un nodo Signature del documento
+type Verificacion struct {
+	Firma     string     // Atributo Id del nodo Signature, si existe
+	Ruta      string     // Ruta del elemento que contiene la firma, por ejemplo /EnvioDTE/SetDTE/DTE[1]
+	Resultado *Resultado // nil si la firma no es válida
+	Error     error
+}
+
+// Valida indica si la firma fue verificada sin errores
+func (v Verificacion) Valida() bool {
+	return v.Error == nil
+}
+
+// VerificarFirmas verifica cada nodo Signature del documento y retorna un resultado por
+// nodo. Sólo retorna error si el documento no se puede leer o no contiene firmas.
+func VerificarFirmas(data []byte) ([]Verificacion, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	firmas := BuscarFirmas(doc.Root())
+	if len(firmas) == 0 {
+		return nil, ErrFirmaNoEncontrada
+	}
+
+	verificaciones := make([]Verificacion, 0, len(firmas))
+	for _, firma := range firmas {
+		resultado, err := VerificarFirma(doc, firma)
+		verificaciones = append(verificaciones, Verificacion{
+			Firma:     firma.SelectAttrValue("Id", ""),
+			Ruta:      rutaElemento(firma.Parent()),
+			Resultado: resultado,
+			Error:     err,
+		})
+	}
+	return verificaciones, nil
+}
+
+// VerificarDocumento verifica todas las firmas del documento. Retorna error si no hay
+// firmas o si alguna no es válida.
+func VerificarDocumento(data []byte) ([]Resultado, error) {
+	verificaciones, err := VerificarFirmas(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resultados := make([]Resultado, 0, len(verificaciones))
+	for _, v := range verificaciones {
+		if v.Error != nil {
+			return resultados, fmt.Errorf("firma en %s: %w", v.Ruta, v.Error)
+		}
+		resultados = append(resultados, *v.Resultado)
+	}
+	return resultados, nil
+}
+
+// BuscarFirmas retorna todos los elementos Signature del namespace XMLDSig bajo el elemento
+func BuscarFirmas(el *etree.Element) []*etree.Element {
+	var firmas []*etree.Element
+	for _, hijo := range el.ChildElements() {
+		if hijo.Tag == "Signature" && hijo.NamespaceURI() == NamespaceXMLDSig {
+			firmas = append(firmas, hijo)
+			continue
+		}
+		firmas = append(firmas, BuscarFirmas(hijo)...)
+	}
+	return firmas
+}
+
+// VerificarFirma verifica una firma enveloped: el digest de cada referencia y el valor de la
+// firma sobre SignedInfo, usando la llave pública incluida en KeyInfo
+func VerificarFirma(doc *etree.Document, firma *etree.Element) (*Resultado, error) {
+	signedInfo := firma.SelectElement("SignedInfo")
+	if signedInfo == nil {
+		return nil, fmt.Errorf("la firma no contiene SignedInfo")
+	}
+
+	if err := validarCanonicalizacion(signedInfo); err != nil {
+		return nil, err
+	}
+
+	referencias := signedInfo.SelectElements("Reference")
+	if len(referencias) == 0 {
+		return nil, fmt.Errorf("la firma no contiene referencias")
+	}
+
+	resultado := &Resultado{}
+	for _, ref := range referencias {
+		uri := ref.SelectAttrValue("URI", "")
+		elemento, err := verificarReferencia(doc, firma, ref)
+		if err != nil {
+			return nil, fmt.Errorf("referencia %q: %w", uri, err)
+		}
+		if len(resultado.Referencias) == 0 {
+			resultado.Referencia = strings.TrimPrefix(uri, "#")
+			resultado.Elemento = elemento
+		}
+		resultado.Referencias = append(resultado.Referencias, uri)
+	}
+
+	llave, cert, err := LlavePublica(firma)
+	if err != nil {
+		return nil, err
+	}
+	resultado.LlavePublica = llave
+	resultado.Certificado = cert
+
+	valorElem := firma.SelectElement("SignatureValue")
+	if valorElem == nil {
+		return nil, fmt.Errorf("la firma no contiene SignatureValue")
+	}
+	valor, err := DecodificarBase64(valorElem.Text())
+	if err != nil {
+		return nil, fmt.Errorf("SignatureValue inválido: %v", err)
+	}
+
+	metodo := signedInfo.SelectElement("SignatureMethod")
+	if metodo == nil {
+		return nil, fmt.Errorf("la firma no contiene SignatureMethod")
+	}
+	hash, err := hashFirma(metodo.SelectAttrValue("Algorithm", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(Canonicalize(signedInfo))
+	if err := rsa.VerifyPKCS1v15(llave, hash, h.Sum(nil), valor); err != nil {
+		return nil, ErrFirmaInvalida
+	}
+
+	return resultado, nil
+}
+
+// LlavePublica obtiene la llave pública de KeyInfo, priorizando el certificado X509
+func LlavePublica(firma *etree.Element) (*rsa.PublicKey, *x509.Certificate, error) {
+	keyInfo := firma.SelectElement("KeyInfo")
+	if keyInfo == nil {
+		return nil, nil, fmt.Errorf("la firma no contiene KeyInfo")
+	}
+
+	if certElem := keyInfo.FindElement(".//X509Certificate"); certElem != nil && strings.TrimSpace(certElem.Text()) != "" {
+		der, err := DecodificarBase64(certElem.Text())
+		if err != nil {
+			return nil, nil, fmt.Errorf("X509Certificate inválido: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error al leer certificado: %v", err)
+		}
+		llave, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("el certificado no contiene una llave RSA")
+		}
+		return llave, cert, nil
+	}
+
+	rsaKey := keyInfo.FindElement(".//RSAKeyValue")
+	if rsaKey == nil {
+		return nil, nil, fmt.Errorf("KeyInfo no contiene certificado ni RSAKeyValue")
+	}
+	llave, err := LlaveRSA(textoHijo(rsaKey, "Modulus"), textoHijo(rsaKey, "Exponent"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return llave, nil, nil
+}
+
+// LlaveRSA construye una llave pública a partir de módulo y exponente en Base64
+func LlaveRSA(modulo, exponente string) (*rsa.PublicKey, error) {
+	m, err := DecodificarBase64(modulo)
+	if err != nil {
+		return nil, fmt.Errorf("módulo RSA inválido: %v", err)
+	}
+	e, err := DecodificarBase64(exponente)
+	if err != nil {
+		return nil, fmt.Errorf("exponente RSA inválido: %v", err)
+	}
+	if len(m) == 0 || len(e) == 0 {
+		return nil, fmt.Errorf("llave RSA incompleta")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(m),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// oidRutSII identifica el RUT del titular en el SubjectAltName de los certificados emitidos
+// para firmar ante el SII
+var oidRutSII = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 8321, 1}
+
+// oidSubjectAltName es la extensión X.509 SubjectAltName
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// RutCertificado retorna el RUT del titular del certificado, sin puntos y con el dígito
+// verificador en mayúscula. Se lee del otherName del SII en el SubjectAltName y, si no está,
+// del SerialNumber o el CommonName del sujeto. Retorna "" si el certificado no lo contiene.
+func RutCertificado(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSubjectAltName) {
+			if rut := normalizarRut(rutOtherName(ext.Value)); rut != "" {
+				return rut
+			}
+		}
+	}
+	if rut := normalizarRut(cert.Subject.SerialNumber); rut != "" {
+		return rut
+	}
+	return normalizarRut(cert.Subject.CommonName)
+}
+
+// CertificadoDeRut indica si el certificado pertenece al RUT indicado
+func CertificadoDeRut(cert *x509.Certificate, rut string) bool {
+	titular := RutCertificado(cert)
+	return titular != "" && titular == normalizarRut(rut)
+}
+
+// rutOtherName busca el otherName con el RUT del SII en el valor de un SubjectAltName
+func rutOtherName(valor []byte) string {
+	var nombres asn1.RawValue
+	if _, err := asn1.Unmarshal(valor, &nombres); err != nil {
+		return ""
+	}
+	resto := nombres.Bytes
+	for len(resto) > 0 {
+		var nombre asn1.RawValue
+		var err error
+		if resto, err = asn1.Unmarshal(resto, &nombre); err != nil {
+			return ""
+		}
+		// otherName es [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+		if nombre.Class != asn1.ClassContextSpecific || nombre.Tag != 0 {
+			continue
+		}
+		var tipo asn1.ObjectIdentifier
+		contenido, err := asn1.Unmarshal(nombre.Bytes, &tipo)
+		if err != nil || !tipo.Equal(oidRutSII) {
+			continue
+		}
+		var explicito asn1.RawValue
+		if _, err := asn1.Unmarshal(contenido, &explicito); err != nil {
+			continue
+		}
+		var rut string
+		if _, err := asn1.Unmarshal(explicito.Bytes, &rut); err != nil {
+			continue
+		}
+		return rut
+	}
+	return ""
+}
+
+// normalizarRut quita puntos, espacios y ceros a la izquierda y pasa el dígito verificador a
+// mayúscula. Retorna "" si el texto no tiene forma de RUT. No usa utils.NormalizarRUT porque
+// utils importa este paquete, y además debe descartar los campos del certificado que no son
+// un RUT.
+func normalizarRut(rut string) string {
+	rut = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(rut), ".", ""))
+	numero, dv, ok := strings.Cut(rut, "-")
+	numero = strings.TrimLeft(numero, "0")
+	if !ok || numero == "" || len(numero) > 8 || len(dv) != 1 {
+		return ""
+	}
+	for _, c := range numero {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	if (dv[0] < '0' || dv[0] > '9') && dv[0] != 'K' {
+		return ""
+	}
+	return numero + "-" + dv
+}
+
+// verificarReferencia calcula el digest del elemento referenciado y lo compara con DigestValue.
+// Retorna el elemento referenciado. Un ID repetido se rechaza, porque el elemento verificado
+// podría no ser el que procesa quien lee el documento.
+func verificarReferencia(doc *etree.Document, firma, ref *etree.Element) (*etree.Element, error) {
+	uri := ref.SelectAttrValue("URI", "")
+	referenciado := doc.Root()
+	if uri != "" {
+		if !strings.HasPrefix(uri, "#") {
+			return nil, fmt.Errorf("URI no soportada")
+		}
+		id := strings.TrimPrefix(uri, "#")
+		elementos := buscarTodosPorID(doc.Root(), id, nil)
+		switch {
+		case len(elementos) == 0:
+			return nil, fmt.Errorf("no existe elemento con ID %s", id)
+		case len(elementos) > 1:
+			return nil, fmt.Errorf("%w: %s", ErrIDDuplicado, id)
+		}
+		referenciado = elementos[0]
+	}
+	objetivo := referenciado
+
+	if transforms := ref.SelectElement("Transforms"); transforms != nil {
+		for _, t := range transforms.SelectElements("Transform") {
+			switch alg := t.SelectAttrValue("Algorithm", ""); alg {
+			case AlgoritmoEnveloped:
+				objetivo = sinFirma(objetivo, firma)
+			case AlgoritmoC14N:
+			default:
+				return nil, fmt.Errorf("transformación no soportada: %s", alg)
+			}
+		}
+	}
+
+	metodo := ref.SelectElement("DigestMethod")
+	if metodo == nil {
+		return nil, fmt.Errorf("la referencia no contiene DigestMethod")
+	}
+	hash, err := hashDigest(metodo.SelectAttrValue("Algorithm", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	esperado, err := DecodificarBase64(textoHijo(ref, "DigestValue"))
+	if err != nil {
+		return nil, fmt.Errorf("DigestValue inválido: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(Canonicalize(objetivo))
+	if !bytes.Equal(h.Sum(nil), esperado) {
+		return nil, ErrDigestInvalido
+	}
+	return referenciado, nil
+}
+
+// BuscarPorID busca el elemento cuyo atributo ID (o Id) tiene el valor indicado
+func BuscarPorID(el *etree.Element, id string) *etree.Element {
+	for _, a := range el.Attr {
+		if (a.Key == "ID" || a.Key == "Id") && a.Space == "" && a.Value == id {
+			return el
+		}
+	}
+	for _, hijo := range el.ChildElements() {
+		if encontrado := BuscarPorID(hijo, id); encontrado != nil {
+			return encontrado
+		}
+	}
+	return nil
+}
+
+// buscarTodosPorID agrega a encontrados los elementos cuyo atributo ID (o Id) tiene el valor indicado
+func buscarTodosPorID(el *etree.Element, id string, encontrados []*etree.Element) []*etree.Element {
+	for _, a := range el.Attr {
+		if (a.Key == "ID" || a.Key == "Id") && a.Space == "" && a.Value == id {
+			encontrados = append(encontrados, el)
+			break
+		}
+	}
+	for _, hijo := range el.ChildElements() {
+		encontrados = buscarTodosPorID(hijo, id, encontrados)
+	}
+	return encontrados
+}
+
+// IDDuplicado retorna el primer valor de ID (o Id) que se repite bajo el elemento, o "" si
+// todos son únicos
+func IDDuplicado(el *etree.Element) string {
+	vistos := map[string]bool{}
+	var buscar func(e *etree.Element) string
+	buscar = func(e *etree.Element) string {
+		for _, a := range e.Attr {
+			if (a.Key == "ID" || a.Key == "Id") && a.Space == "" {
+				if vistos[a.Value] {
+					return a.Value
+				}
+				vistos[a.Value] = true
+			}
+		}
+		for _, hijo := range e.ChildElements() {
+			if id := buscar(hijo); id != "" {
+				return id
+			}
+		}
+		return ""
+	}
+	return buscar(el)
+}
+
+// sinFirma aplica la transformación enveloped-signature: si la firma está dentro del
+// elemento referenciado, retorna una copia sin ella
+func sinFirma(objetivo, firma *etree.Element) *etree.Element {
+	contenida := false
+	for e := firma.Parent(); e != nil; e = e.Parent() {
+		if e == objetivo {
+			contenida = true
+			break
+		}
+	}
+	if !contenida {
+		return objetivo
+	}
+
+	// La copia pierde el padre, por lo que se re-declaran los namespaces heredados
+	copia := objetivo.Copy()
+	ruta := rutaHasta(objetivo, firma)
+	actual := copia
+	for _, i := range ruta[:len(ruta)-1] {
+		actual = actual.ChildElements()[i]
+	}
+	actual.RemoveChild(actual.ChildElements()[ruta[len(ruta)-1]])
+
+	for prefijo, uri := range namespacesEnAmbito(objetivo.Parent()) {
+		if prefijo == "" {
+			if copia.SelectAttr("xmlns") == nil {
+				copia.CreateAttr("xmlns", uri)
+			}
+		} else if copia.SelectAttr("xmlns:"+prefijo) == nil {
+			copia.CreateAttr("xmlns:"+prefijo, uri)
+		}
+	}
+	return copia
+}
+
+// rutaElemento retorna la ruta del elemento desde la raíz, indicando la posición entre
+// hermanos con el mismo nombre cuando hay más de uno
+func rutaElemento(el *etree.Element) string {
+	var partes []string
+	for e := el; e != nil && e.Parent() != nil; e = e.Parent() {
+		parte := e.Tag
+		if hermanos := e.Parent().SelectElements(e.Tag); len(hermanos) > 1 {
+			for i, h := range hermanos {
+				if h == e {
+					parte = fmt.Sprintf("%s[%d]", e.Tag, i+1)
+					break
+				}
+			}
+		}
+		partes = append([]string{parte}, partes...)
+	}
+	return "/" + strings.Join(partes, "/")
+}
+
+// rutaHasta retorna los índices de elementos hijos desde el ancestro hasta el descendiente
+func rutaHasta(ancestro, descendiente *etree.Element) []int {
+	var ruta []int
+	for e := descendiente; e != ancestro; e = e.Parent() {
+		padre := e.Parent()
+		for i, hijo := range padre.ChildElements() {
+			if hijo == e {
+				ruta = append([]int{i}, ruta...)
+				break
+			}
+		}
+	}
+	return ruta
+}
+
+// validarCanonicalizacion verifica que SignedInfo use C14N inclusivo
+func validarCanonicalizacion(signedInfo *etree.Element) error {
+	metodo := signedInfo.SelectElement("CanonicalizationMethod")
+	if metodo == nil {
+		return fmt.Errorf("la firma no contiene CanonicalizationMethod")
+	}
+	alg := metodo.SelectAttrValue("Algorithm", "")
+	if alg != AlgoritmoC14N {
+		return fmt.Errorf("canonicalización no soportada: %s", alg)
+	}
+	return nil
+}
+
+// hashFirma retorna el hash asociado al algoritmo de firma
+func hashFirma(algoritmo string) (crypto.Hash, error) {
+	switch algoritmo {
+	case AlgoritmoRSASHA1:
+		return crypto.SHA1, nil
+	case AlgoritmoRSASHA256:
+		return crypto.SHA256, nil
+	default:
+		return 0, fmt.Errorf("algoritmo de firma no soportado: %s", algoritmo)
+	}
+}
+
+// hashDigest retorna el hash asociado al algoritmo de digest
+func hashDigest(algoritmo string) (crypto.Hash, error) {
+	switch algoritmo {
+	case AlgoritmoSHA1:
+		return crypto.SHA1, nil
+	case AlgoritmoSHA256:
+		return crypto.SHA256, nil
+	default:
+		return 0, fmt.Errorf("algoritmo de digest no soportado: %s", algoritmo)
+	}
+}
+
+// textoHijo retorna el texto del hijo indicado o vacío si no existe
+func textoHijo(el *etree.Element, tag string) string {
+	if hijo := el.SelectElement(tag); hijo != nil {
+		return hijo.Text()
+	}
+	return ""
+}
+
+// DecodificarBase64 decodifica Base64 ignorando saltos de línea y espacios
+func DecodificarBase64(s string) ([]byte, error) {
+	limpio := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\n', '\r', '\t':
+			return -1
+		}
+		return r
+	}, s)
+	return base64.StdEncoding.DecodeString(limpio)
+}
@@ -0,0 +1,259 @@
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const envioPrueba = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<EnvioDTE xmlns="http://www.sii.cl/SiiDte" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" version="1.0">
+<SetDTE ID="SetDoc">
+<Caratula version="1.0"><RutEmisor>76212889-6</RutEmisor><Nota b="2" a='x"y'>A &amp; B &gt; C</Nota><Vacio/></Caratula>
+</SetDTE>
+</EnvioDTE>`
+
+func TestCanonicalizeHeredaNamespaces(t *testing.T) {
+	doc, err := ParseDocument([]byte(envioPrueba))
+	require.NoError(t, err)
+
+	setDTE := doc.FindElement("//SetDTE")
+	require.NotNil(t, setDTE)
+
+	esperado := `<SetDTE xmlns="http://www.sii.cl/SiiDte" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="SetDoc">
+<Caratula version="1.0"><RutEmisor>76212889-6</RutEmisor><Nota a="x&quot;y" b="2">A &amp; B &gt; C</Nota><Vacio></Vacio></Caratula>
+</SetDTE>`
+	assert.Equal(t, esperado, string(Canonicalize(setDTE)))
+}
+
+func TestParseDocumentISO88591(t *testing.T) {
+	data := append([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><A>`), 0xD1, 'a', 'n', 'd', 0xFA)
+	data = append(data, []byte(`</A>`)...)
+
+	doc, err := ParseDocument(data)
+	require.NoError(t, err)
+	assert.Equal(t, "<A>Ñandú</A>", string(Canonicalize(doc.Root())))
+}
+
+func TestVerificarFirma(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	firmado := firmarPrueba(t, envioPrueba, "SetDoc", llave, cert)
+
+	resultados, err := VerificarDocumento(firmado)
+	require.NoError(t, err)
+	require.Len(t, resultados, 1)
+	assert.Equal(t, "SetDoc", resultados[0].Referencia)
+	assert.Equal(t, cert.SerialNumber, resultados[0].Certificado.SerialNumber)
+
+	alterado := strings.Replace(string(firmado), "76212889-6", "76212889-7", 1)
+	_, err = VerificarDocumento([]byte(alterado))
+	assert.ErrorIs(t, err, ErrDigestInvalido)
+
+	doc, err := ParseDocument(firmado)
+	require.NoError(t, err)
+	valor := doc.FindElement("//SignatureValue")
+	valor.SetText(base64.StdEncoding.EncodeToString(make([]byte, 256)))
+	alteradoFirma, err := doc.WriteToBytes()
+	require.NoError(t, err)
+	_, err = VerificarDocumento(alteradoFirma)
+	assert.ErrorIs(t, err, ErrFirmaInvalida)
+}
+
+func TestVerificarDocumentoSinFirma(t *testing.T) {
+	_, err := VerificarDocumento([]byte(envioPrueba))
+	assert.ErrorIs(t, err, ErrFirmaNoEncontrada)
+}
+
+func TestVerificarFirmasPorNodo(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	doc, err := ParseDocument([]byte(`<EnvioDTE xmlns="http://www.sii.cl/SiiDte"><SetDTE ID="SetDoc">` +
+		`<DTE><Documento ID="F1T33"><Folio>1</Folio></Documento></DTE>` +
+		`<DTE><Documento ID="F2T33"><Folio>2</Folio></Documento></DTE>` +
+		`</SetDTE></EnvioDTE>`))
+	require.NoError(t, err)
+
+	dtes := doc.FindElements("//DTE")
+	agregarFirmaPrueba(t, doc, dtes[0], "#F1T33", llave, cert)
+	agregarFirmaPrueba(t, doc, dtes[1], "#F2T33", llave, nil)
+	alterado := strings.Replace(string(escribirPrueba(t, doc)), "<Folio>2</Folio>", "<Folio>3</Folio>", 1)
+
+	verificaciones, err := VerificarFirmas([]byte(alterado))
+	require.NoError(t, err)
+	require.Len(t, verificaciones, 2)
+
+	assert.True(t, verificaciones[0].Valida())
+	assert.Equal(t, "/EnvioDTE/SetDTE/DTE[1]", verificaciones[0].Ruta)
+	assert.Equal(t, []string{"#F1T33"}, verificaciones[0].Resultado.Referencias)
+	assert.Equal(t, "Firmante Prueba", verificaciones[0].Resultado.Certificado.Subject.CommonName)
+
+	assert.False(t, verificaciones[1].Valida())
+	assert.Equal(t, "/EnvioDTE/SetDTE/DTE[2]", verificaciones[1].Ruta)
+	assert.ErrorIs(t, verificaciones[1].Error, ErrDigestInvalido)
+	assert.Nil(t, verificaciones[1].Resultado)
+
+	_, err = VerificarDocumento([]byte(alterado))
+	assert.ErrorIs(t, err, ErrDigestInvalido)
+}
+
+func TestVerificarFirmaDocumentoCompletoConKeyValue(t *testing.T) {
+	llave, _ := credencialesPrueba(t)
+	doc, err := ParseDocument([]byte(`<getToken><item><Semilla>012345678901</Semilla></item></getToken>`))
+	require.NoError(t, err)
+	agregarFirmaPrueba(t, doc, doc.Root(), "", llave, nil)
+
+	resultados, err := VerificarDocumento(escribirPrueba(t, doc))
+	require.NoError(t, err)
+	require.Len(t, resultados, 1)
+	assert.Equal(t, "", resultados[0].Referencia)
+	assert.Nil(t, resultados[0].Certificado)
+	assert.Equal(t, llave.PublicKey.N, resultados[0].LlavePublica.N)
+}
+
+func TestVerificarFirmaRechazaIDDuplicado(t *testing.T) {
+	llave, cert := credencialesPrueba(t)
+	firmado := firmarPrueba(t, envioPrueba, "SetDoc", llave, cert)
+
+	doc, err := ParseDocument(firmado)
+	require.NoError(t, err)
+	resultado, err := VerificarFirma(doc, doc.FindElement("//Signature"))
+	require.NoError(t, err)
+	assert.Same(t, doc.FindElement("//SetDTE"), resultado.Elemento)
+	assert.Equal(t, "", IDDuplicado(doc.Root()))
+
+	// Un SetDTE falso con el mismo ID antes del firmado
+	falso := doc.FindElement("//SetDTE").Copy()
+	falso.FindElement("Caratula/RutEmisor").SetText("99999999-9")
+	doc.Root().InsertChildAt(0, falso)
+	assert.Equal(t, "SetDoc", IDDuplicado(doc.Root()))
+
+	_, err = VerificarDocumento(escribirPrueba(t, doc))
+	assert.ErrorIs(t, err, ErrIDDuplicado)
+}
+
+func TestRutCertificado(t *testing.T) {
+	llave, sinRut := credencialesPrueba(t)
+	assert.Equal(t, "", RutCertificado(sinRut))
+	assert.False(t, CertificadoDeRut(sinRut, "13195458-1"))
+
+	ia5, err := asn1.MarshalWithParams("13.195.458-k", "ia5")
+	require.NoError(t, err)
+	valor, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ia5})
+	require.NoError(t, err)
+	tipo, err := asn1.Marshal(oidRutSII)
+	require.NoError(t, err)
+	otherName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(tipo, valor...)})
+	require.NoError(t, err)
+	san, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: otherName})
+	require.NoError(t, err)
+
+	plantilla := &x509.Certificate{
+		SerialNumber:    big.NewInt(43),
+		Subject:         pkix.Name{CommonName: "Juan Pérez"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidSubjectAltName, Value: san}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	assert.Equal(t, "13195458-K", RutCertificado(cert))
+	assert.True(t, CertificadoDeRut(cert, "13.195.458-k"))
+	assert.False(t, CertificadoDeRut(cert, "76212889-6"))
+
+	sinRut.Subject.SerialNumber = "076.212.889-6"
+	assert.Equal(t, "76212889-6", RutCertificado(sinRut))
+}
+
+// credencialesPrueba genera una llave y un certificado autofirmado
+func credencialesPrueba(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	llave, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	plantilla := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "Firmante Prueba"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &llave.PublicKey, llave)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return llave, cert
+}
+
+// firmarPrueba agrega una firma enveloped RSA-SHA1 como último hijo de la raíz
+func firmarPrueba(t *testing.T, xmlData, referencia string, llave *rsa.PrivateKey, cert *x509.Certificate) []byte {
+	t.Helper()
+	doc, err := ParseDocument([]byte(xmlData))
+	require.NoError(t, err)
+
+	agregarFirmaPrueba(t, doc, doc.Root(), "#"+referencia, llave, cert)
+	return escribirPrueba(t, doc)
+}
+
+// agregarFirmaPrueba firma la referencia indicada y agrega la firma como último hijo de padre.
+// Sin certificado, KeyInfo informa la llave como RSAKeyValue.
+func agregarFirmaPrueba(t *testing.T, doc *etree.Document, padre *etree.Element, uri string, llave *rsa.PrivateKey, cert *x509.Certificate) {
+	t.Helper()
+	objetivo := doc.Root()
+	if uri != "" {
+		objetivo = BuscarPorID(doc.Root(), strings.TrimPrefix(uri, "#"))
+	}
+	require.NotNil(t, objetivo)
+	digest := sha1.Sum(Canonicalize(objetivo))
+
+	firma := padre.CreateElement("Signature")
+	firma.CreateAttr("xmlns", NamespaceXMLDSig)
+	signedInfo := firma.CreateElement("SignedInfo")
+	signedInfo.CreateElement("CanonicalizationMethod").CreateAttr("Algorithm", AlgoritmoC14N)
+	signedInfo.CreateElement("SignatureMethod").CreateAttr("Algorithm", AlgoritmoRSASHA1)
+	ref := signedInfo.CreateElement("Reference")
+	ref.CreateAttr("URI", uri)
+	transform := AlgoritmoC14N
+	if uri == "" {
+		transform = AlgoritmoEnveloped
+	}
+	ref.CreateElement("Transforms").CreateElement("Transform").CreateAttr("Algorithm", transform)
+	ref.CreateElement("DigestMethod").CreateAttr("Algorithm", AlgoritmoSHA1)
+	ref.CreateElement("DigestValue").SetText(base64.StdEncoding.EncodeToString(digest[:]))
+
+	hashSignedInfo := sha1.Sum(Canonicalize(signedInfo))
+	valor, err := rsa.SignPKCS1v15(rand.Reader, llave, crypto.SHA1, hashSignedInfo[:])
+	require.NoError(t, err)
+	firma.CreateElement("SignatureValue").SetText(base64.StdEncoding.EncodeToString(valor))
+
+	keyInfo := firma.CreateElement("KeyInfo")
+	if cert != nil {
+		keyInfo.CreateElement("X509Data").CreateElement("X509Certificate").
+			SetText(base64.StdEncoding.EncodeToString(cert.Raw))
+		return
+	}
+	rsaKey := keyInfo.CreateElement("KeyValue").CreateElement("RSAKeyValue")
+	rsaKey.CreateElement("Modulus").SetText(base64.StdEncoding.EncodeToString(llave.N.Bytes()))
+	rsaKey.CreateElement("Exponent").SetText(base64.StdEncoding.EncodeToString(big.NewInt(int64(llave.E)).Bytes()))
+}
+
+func escribirPrueba(t *testing.T, doc *etree.Document) []byte {
+	t.Helper()
+	doc.WriteSettings = etree.WriteSettings{CanonicalEndTags: true}
+	salida, err := doc.WriteToBytes()
+	require.NoError(t, err)
+	return salida
+}